@@ -0,0 +1,10 @@
+package assets
+
+import (
+  "embed"
+)
+
+// The companion files that are shipped within the binary, so it keeps working
+// when it's used outside of a checkout of this repository
+//go:embed prices.json compat.json gpu-agents.sh completion templates presets examples help
+var Files embed.FS
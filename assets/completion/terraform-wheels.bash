@@ -0,0 +1,24 @@
+# Bash completion for terraform-wheels
+#
+# Install with:
+#   terraform-wheels wheels-completion bash > /etc/bash_completion.d/terraform-wheels
+
+_terraform_wheels() {
+  local cur="${COMP_WORDS[COMP_CWORD]}"
+  local i
+
+  # Complete the command, unless we have one already
+  for (( i=1; i < COMP_CWORD; i++ )); do
+    if [[ "${COMP_WORDS[i]}" == help && i == COMP_CWORD-1 ]]; then
+      COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" wheels-completion -topics 2>/dev/null)" -- "$cur") )
+      return
+    elif [[ "${COMP_WORDS[i]}" != -* ]]; then
+      COMPREPLY=( $(compgen -f -- "$cur") )
+      return
+    fi
+  done
+
+  COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" wheels-completion -commands 2>/dev/null)" -- "$cur") )
+}
+
+complete -F _terraform_wheels terraform-wheels
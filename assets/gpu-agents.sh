@@ -0,0 +1,10 @@
+#!/bin/bash
+# Installs the NVIDIA driver on the GPU agents (CentOS 7), before DC/OS
+# detects their GPUs. Override this file to pin another driver version.
+set -e
+
+yum install -y "kernel-devel-$(uname -r)" "kernel-headers-$(uname -r)" gcc make yum-utils
+yum-config-manager --add-repo https://developer.download.nvidia.com/compute/cuda/repos/rhel7/x86_64/cuda-rhel7.repo
+yum install -y nvidia-driver-latest-dkms
+
+nvidia-smi
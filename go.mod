@@ -1,6 +1,6 @@
 module github.com/mesosphere-incubator/terraform-wheels
 
-go 1.13
+go 1.16
 
 require (
 	github.com/Masterminds/semver/v3 v3.0.3
@@ -13,7 +13,7 @@ require (
 	github.com/mattn/go-runewidth v0.0.8 // indirect
 	github.com/spf13/cobra v0.0.6 // indirect
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
-	golang.org/x/sys v0.0.0-20200219091948-cb0a6d8edb6c // indirect
+	golang.org/x/sys v0.0.0-20200219091948-cb0a6d8edb6c
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 	gopkg.in/hlandau/easymetric.v1 v1.0.0 // indirect
 	gopkg.in/hlandau/measurable.v1 v1.0.1 // indirect
@@ -1,8 +1,11 @@
 package main
 
 import (
+  "encoding/json"
+  "flag"
   "fmt"
   "os"
+  "runtime"
   "strings"
 
   "github.com/Masterminds/semver/v3"
@@ -12,13 +15,43 @@ import (
 )
 
 var buildVersion string // Defined at build time
+var buildCommit string  // Defined at build time
+var buildDate string    // Defined at build time
 
 var plugins []Plugin = []Plugin{
   CreatePluginImportCluster(),
   CreatePluginDcosAws(),
+  CreatePluginDcosVersions(),
   CreatePluginSSHAgent(),
   CreatePluginAddService(),
   CreatePluginDcosProvider(),
+  CreatePluginShare(),
+  CreatePluginCost(),
+  CreatePluginGenerated(),
+  CreatePluginRemoveCluster(),
+  CreatePluginValidate(),
+  CreatePluginLicense(),
+  CreatePluginScanSecrets(),
+  CreatePluginClusterIdentity(),
+  CreatePluginPRComment(),
+  CreatePluginGitInit(),
+  CreatePluginEnv(),
+  CreatePluginState(),
+  CreatePluginPlan(),
+  CreatePluginPolicy(),
+  CreatePluginTFEBackend(),
+  CreatePluginModules(),
+  CreatePluginMirror(),
+  CreatePluginTelemetry(),
+  CreatePluginSpec(),
+  CreatePluginMonitoring(),
+  CreatePluginKubernetes(),
+  CreatePluginClusterInfo(),
+  CreatePluginGraph(),
+  CreatePluginRecovery(),
+  CreatePluginReplaceNode(),
+  CreatePluginNewProject(),
+  CreatePluginUpgradeConfig(),
 }
 
 var knownTerraformCommands []string = []string{
@@ -28,92 +61,799 @@ var knownTerraformCommands []string = []string{
   "state",
 }
 
+// Read-only commands that never need any plugin, so they skip loading them
+var readOnlyTerraformCommands []string = []string{
+  "output", "show", "version", "providers", "graph",
+}
+
+func isReadOnlyCommand(args []string) bool {
+  cmd := GetTerraformCommand(args)
+  for _, readOnly := range readOnlyTerraformCommands {
+    if cmd == readOnly {
+      return true
+    }
+  }
+  return false
+}
+
 func showMissingTerraformHelp() {
-  fmt.Println("Your system does not have terraform installed, or it's version is not")
-  fmt.Printf("compatible with our %sx requirements. This means we cannot show you\n", RequiredTerraformVersionPrefix)
-  fmt.Println("the terraform help screen. ")
-  fmt.Println("")
-  fmt.Println("This tool will automatically download the correct terraform version and")
-  fmt.Println("place it in your current project directory when you try to use the")
-  fmt.Println("following commands for the first time:")
+  Println("Your system does not have terraform installed, or it's version is not")
+  Printf("compatible with our %sx requirements. This means we cannot show you\n", RequiredTerraformVersionPrefix)
+  Println("the terraform help screen. ")
+  Println("")
+  Println("This tool will automatically download the correct terraform version and")
+  Println("place it in the shared cache (~/.terraform-wheels) when you try to use")
+  Println("the following commands for the first time:")
+}
+
+/**
+ * The commands of terraform-wheels itself, with their description
+ */
+func getBuiltinCommands() [][]string {
+  return [][]string{
+    {"wheels-version", "Check the version of " + os.Args[0]},
+    {"wheels-upgrade", "Upgrade to the latest version of " + os.Args[0]},
+    {"wheels-rollback", "Go back to the version used before the last upgrade"},
+    {"wheels-completion", "Print the shell completion script (bash or zsh)"},
+    {"wheels-render", "Generate the terraform files without running terraform"},
+    {"tf", "Pass the arguments to terraform as-is"},
+  }
 }
 
 func showPluginHelp() {
-  fmt.Println("")
-  fmt.Println("DC/OS Commands:")
-  fmt.Printf("    %-18s %s %s\n", "wheels-version", "Check the version of", os.Args[0])
-  fmt.Printf("    %-18s %s %s\n", "wheels-upgrade", "Upgrade to the latest version of", os.Args[0])
+  Println("")
+  Println("DC/OS Commands:")
+  for _, builtin := range getBuiltinCommands() {
+    name := builtin[0]
+    if name == "tf" {
+      name = "tf -- <args>"
+    }
+    Printf("    %-18s %s\n", name, builtin[1])
+  }
 
   for _, plugin := range plugins {
     for _, cmd := range plugin.GetCommands() {
-      fmt.Printf("    %-18s %s\n", cmd.GetName(), cmd.GetDescription())
+      Printf("    %-18s %s\n", cmd.GetName(), cmd.GetDescription())
+    }
+  }
+
+  Println("")
+  Println("Global Options:")
+  PrintGlobalFlags()
+}
+
+/**
+ * Returns the names of all the commands: of terraform, of terraform-wheels
+ * itself and of the plugins
+ */
+func getCommandNames() []string {
+  names := append([]string{}, knownTerraformCommands...)
+  for _, builtin := range getBuiltinCommands() {
+    names = append(names, builtin[0])
+  }
+  names = append(names, "help")
+  for _, plugin := range plugins {
+    for _, cmd := range plugin.GetCommands() {
+      names = append(names, cmd.GetName())
+    }
+  }
+  return names
+}
+
+func showCompletion(args []string) {
+  if len(args) > 0 && isHelpArg(args[0]) {
+    PrintHelp("wheels-completion", "[bash|zsh]", []interface{}{
+      "This command prints the completion script for the given shell (bash by",
+      fmt.Sprintf("default), eg. %s wheels-completion bash > /etc/bash_completion.d/%s", os.Args[0], os.Args[0]),
+    }, nil)
+    return
+  }
+
+  // Used by the completion scripts themselves
+  if len(args) > 0 && args[0] == "-commands" {
+    for _, name := range getCommandNames() {
+      Println(name)
+    }
+    return
+  }
+  if len(args) > 0 && args[0] == "-topics" {
+    for _, name := range getHelpTopicNames() {
+      Println(name)
+    }
+    for _, name := range getCommandNames() {
+      if name != "help" {
+        Println(name)
+      }
     }
+    return
+  }
+
+  shell := "bash"
+  if len(args) > 0 {
+    shell = args[0]
+  }
+  script, err := ReadAsset(fmt.Sprintf("completion/terraform-wheels.%s", shell))
+  if err != nil {
+    FatalError(fmt.Errorf("There is no completion script for %s, please use bash or zsh", shell))
+  }
+  fmt.Print(string(script))
+}
+
+type versionPlugin struct {
+  Name    string `json:"name"`
+  Version string `json:"version"`
+}
+
+type versionInfo struct {
+  Version             string          `json:"version"`
+  Commit              string          `json:"commit"`
+  Date                string          `json:"date"`
+  GoVersion           string          `json:"go_version"`
+  Platform            string          `json:"platform"`
+  TerraformConstraint string          `json:"terraform_constraint"`
+  Plugins             []versionPlugin `json:"plugins"`
+  LatestVersion       string          `json:"latest_version,omitempty"`
+  UpdateAvailable     *bool           `json:"update_available"`
+  UpdateError         string          `json:"update_error,omitempty"`
+}
+
+func showVersion(args []string) {
+  fSet := flag.NewFlagSet("wheels-version", flag.ContinueOnError)
+  fJSON := fSet.Bool("json", false, "Print the build information and the update status as JSON")
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  if err := ParseCommandFlags(fSet, args); err != nil {
+    FatalError(err)
+  }
+
+  if *help {
+    PrintHelp("wheels-version", "", []interface{}{
+      fmt.Sprintf("This command shows the version of %s, or with -json the build", os.Args[0]),
+      "information and whether there is an update available.",
+    }, fSet)
+    return
+  }
+
+  if !*fJSON {
+    PrintInfo("You are using terraform-wheels version %s", Bold(buildVersion))
+    return
+  }
+
+  info := versionInfo{
+    Version:             buildVersion,
+    Commit:              buildCommit,
+    Date:                buildDate,
+    GoVersion:           runtime.Version(),
+    Platform:            runtime.GOOS + "/" + runtime.GOARCH,
+    TerraformConstraint: "~> " + RequiredTerraformVersionPrefix + "0",
+  }
+
+  // The plugins are built in, so they share the version of the binary
+  for _, plugin := range plugins {
+    info.Plugins = append(info.Plugins, versionPlugin{plugin.GetName(), buildVersion})
+  }
+
+  // The update status is unknown when offline, or when the check fails
+  if IsOffline() {
+    info.UpdateError = "running in offline mode"
+  } else if latest, err := GetLatestVersion(); err != nil {
+    info.UpdateError = err.Error()
+  } else {
+    info.LatestVersion = latest.Version.String()
+    if ver, err := semver.NewVersion(buildVersion); err == nil {
+      available := latest.Version.Compare(ver) > 0
+      info.UpdateAvailable = &available
+    }
+  }
+
+  enc := json.NewEncoder(os.Stdout)
+  enc.SetEscapeHTML(false)
+  enc.SetIndent("", "  ")
+  enc.Encode(info)
+}
+
+/**
+ * Upgrades to the latest released version, or to the given one (even an
+ * older one), keeping the current binary to roll back to
+ */
+func upgradeWheels(args []string) {
+  fSet := flag.NewFlagSet("wheels-upgrade", flag.ContinueOnError)
+  fTo := fSet.String("to", "", "Install this released version (eg. 0.4.1) instead of the latest one, even if it's older")
+  fForce := fSet.Bool("force-self-upgrade", false, "Replace the binary even if it was installed with a package manager (Homebrew or apt)")
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  if err := ParseCommandFlags(fSet, args); err != nil {
+    FatalError(err)
+  }
+
+  if *help {
+    PrintHelp("wheels-upgrade", "", []interface{}{
+      fmt.Sprintf("This command upgrades %s to the latest released version, or to the", os.Args[0]),
+      "one given with -to. The current version is kept, and wheels-rollback goes",
+      "back to it.",
+      "",
+      "When it was installed with Homebrew or apt, the upgrade is left to them,",
+      "unless -force-self-upgrade is given.",
+    }, fSet)
+    return
+  }
+  if IsOffline() {
+    PrintInfo("Running in offline mode, skipping the upgrade check")
+    return
+  }
+  if pm := DetectPackageManager(); pm != nil && !*fForce {
+    upgradeWithPackageManager(pm, *fTo)
+    return
+  }
+
+  ver := semver.MustParse(buildVersion)
+  var release LatestVersion
+  var err error
+  if *fTo != "" {
+    if to, err := semver.NewVersion(*fTo); err == nil && to.Equal(ver) {
+      PrintInfo("You are already running version %s", Bold(buildVersion))
+      return
+    }
+    release, err = GetReleasedVersion(*fTo)
+  } else {
+    release, err = GetLatestVersion()
+  }
+  if err != nil {
+    FatalError(err)
+  }
+
+  cmp := release.Version.Compare(ver)
+  if cmp <= 0 && *fTo == "" {
+    PrintInfo("You are running the latest released version")
+    return
+  }
+
+  if cmp > 0 {
+    PrintInfo("Upgrading from %s to %s", Bold(buildVersion), Bold(release.Version.String()))
+  } else {
+    PrintInfo("Downgrading from %s to %s", Bold(buildVersion), Bold(release.Version.String()))
+  }
+  if err := PerformUpgrade(release); err != nil {
+    FatalError(err)
+  }
+}
+
+/**
+ * Leaves the upgrade to the package manager that installed the binary, so
+ * its files are not replaced behind its back
+ */
+func upgradeWithPackageManager(pm *PackageManager, version string) {
+  cmds, err := pm.GetUpgradeCommands(version)
+  if err != nil {
+    FatalError(fmt.Errorf("%s, use --force-self-upgrade to replace the binary anyway", err.Error()))
+  }
+
+  PrintInfo("%s was installed with %s, upgrade it with: %s", os.Args[0], pm.Name, Bold(FormatUpgradeCommands(cmds)))
+  if !IsInteractive() || !ReadYN("Run it now?") {
+    FatalError(fmt.Errorf("Not replacing the files managed by %s, use --force-self-upgrade to do it anyway", pm.Name))
+  }
+  if err := RunUpgradeCommands(cmds); err != nil {
+    FatalError(err)
+  }
+}
+
+/**
+ * Goes back to the version used before the last upgrade
+ */
+func rollbackWheels(args []string) {
+  fSet := flag.NewFlagSet("wheels-rollback", flag.ContinueOnError)
+  fForce := fSet.Bool("force-self-upgrade", false, "Replace the binary even if it was installed with a package manager (Homebrew or apt)")
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  if err := ParseCommandFlags(fSet, args); err != nil {
+    FatalError(err)
+  }
+
+  if *help {
+    PrintHelp("wheels-rollback", "", []interface{}{
+      fmt.Sprintf("This command goes back to the version of %s that was used before", os.Args[0]),
+      "the last wheels-upgrade. Rolling back again returns to the newer one.",
+    }, fSet)
+    return
+  }
+  if pm := DetectPackageManager(); pm != nil && !*fForce {
+    FatalError(fmt.Errorf("%s was installed with %s, install the previous version with it, or use --force-self-upgrade", os.Args[0], pm.Name))
+  }
+  if err := PerformRollback(); err != nil {
+    FatalError(err)
+  }
+}
+
+// The commands that only generate files, and can be used with wheels-render
+func isGeneratorCommand(name string) bool {
+  return strings.HasPrefix(name, "add-") || name == "import-cluster"
+}
+
+/**
+ * Generates the terraform files of the project, without ever running
+ * terraform, so they can be committed and planned/applied by another tool
+ */
+func renderProject(sandbox *ProjectSandbox, args []string) {
+  fSet := flag.NewFlagSet("wheels-render", flag.ContinueOnError)
+  fCheck := fSet.Bool("check", false, "Fail if the rendered files were not up to date (eg. in CI)")
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  if err := ParseCommandFlags(fSet, args); err != nil {
+    FatalError(err)
+  }
+
+  if *help {
+    PrintHelp("wheels-render", "[add-command [arguments]]", []interface{}{
+      "This command will generate the terraform files of the project, like the",
+      "DC/OS provider and the missing SSH keys, without ever running terraform.",
+      "The files can then be committed, and planned and applied by another tool",
+      "(eg. Atlantis or Terraform Cloud).",
+      "",
+      "An add-* command can be given, to generate its files without running",
+      "`terraform init` afterwards.",
+    }, fSet)
+    return
+  }
+
+  before, err := sandbox.SnapshotProjectFiles()
+  if err != nil {
+    FatalError(err)
+  }
+
+  if name := fSet.Arg(0); name != "" {
+    if !isGeneratorCommand(name) {
+      FatalError(fmt.Errorf("Only the add-* and import-cluster commands can be rendered, not %s", name))
+    }
+    generator := findPluginCommand(name)
+    if generator == nil {
+      var generators []string
+      for _, cmdName := range getCommandNames() {
+        if isGeneratorCommand(cmdName) {
+          generators = append(generators, cmdName)
+        }
+      }
+      if suggestions := SuggestValues(name, generators); len(suggestions) > 0 {
+        FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown command %s, %s", name, FormatSuggestions(suggestions))))
+      }
+      FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown command %s", name)))
+    }
+
+    // The generators never use terraform
+    if err := generator.Handle(fSet.Args()[1:], sandbox, nil); err != nil {
+      FatalError(err)
+    }
+    if err := sandbox.ReloadTerraformProject(); err != nil {
+      FatalError(err)
+    }
+  }
+
+  for _, plugin := range loadPlugins(sandbox) {
+    if renderer, ok := plugin.(PluginRenderer); ok {
+      if err := renderer.Render(sandbox); err != nil {
+        FatalError(fmt.Errorf("Could not render %s: %s", plugin.GetName(), err.Error()))
+      }
+    }
+  }
+  if err := sandbox.BootstrapRepository(); err != nil {
+    FatalError(err)
+  }
+
+  after, err := sandbox.SnapshotProjectFiles()
+  if err != nil {
+    FatalError(err)
+  }
+  changed := GetChangedProjectFiles(before, after)
+  if len(changed) == 0 {
+    PrintInfo("The rendered files are up to date")
+    return
+  }
+  PrintInfo("Rendered %s", Bold(strings.Join(changed, ", ")))
+  if *fCheck {
+    FatalError(WithExitCode(ExitValidationFailed, fmt.Errorf("The rendered files were not up to date, please commit them")))
   }
 }
 
 func showInitUsage() {
-  FatalError(fmt.Errorf("Your current directory does not contain terraform files. Please run `init` to prepare it."))
+  FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Your current directory does not contain terraform files. Create a project with `%s new <name>`, or generate a cluster here with `%s add-aws-cluster`", os.Args[0], os.Args[0])))
+}
+
+func isHelpArg(arg string) bool {
+  return arg == "help" || arg == "-help" || arg == "--help" || arg == "-h"
 }
 
-func shouldShowHelp(args []string) bool {
-  for _, arg := range args {
-    if strings.Contains(arg, "help") {
+/**
+ * Returns the command (the first argument that is not a flag) and its index
+ * in the arguments
+ */
+func findCommand(args []string) (string, int) {
+  for i, arg := range args {
+    if !strings.HasPrefix(arg, "-") {
+      return arg, i
+    }
+  }
+  return "", -1
+}
+
+func isTerraformCommand(name string) bool {
+  for _, cmd := range knownTerraformCommands {
+    if cmd == name {
       return true
     }
   }
   return false
 }
 
-func showHelp(sandbox *ProjectSandbox) {
-  // Show terraform help
-  if sandbox.HasTerraform() {
-    tf, err := sandbox.GetTerraform()
-    if err != nil {
-      FatalError(err)
+func findPluginCommand(name string) PluginCommand {
+  for _, plugin := range plugins {
+    for _, cmd := range plugin.GetCommands() {
+      if cmd.GetName() == name {
+        return cmd
+      }
     }
-    tf.Invoke([]string{})
-  } else {
+  }
+  return nil
+}
+
+/**
+ * The help topics that are not in the embedded docs
+ */
+var builtinHelpTopics [][]string = [][]string{
+  {"terraform", "The terraform commands, that run with the plugins of the project"},
+  {"commands", "All the commands"},
+  {"options", "The global options, that can be given anywhere in the command-line"},
+}
+
+func getHelpTopicNames() []string {
+  var names []string
+  if topics, err := ReadHelpTopics(); err == nil {
+    for _, topic := range topics {
+      names = append(names, topic.Name)
+    }
+  }
+  for _, topic := range builtinHelpTopics {
+    names = append(names, topic[0])
+  }
+  return names
+}
+
+/**
+ * Shows the help topics, or the help of the given topic or command, through
+ * a pager when it's interactive
+ */
+func showHelp(sandbox *ProjectSandbox, args []string) {
+  topics, err := ReadHelpTopics()
+  if err != nil {
+    FatalError(err)
+  }
+
+  name := ""
+  if len(args) > 0 {
+    name = args[0]
+  }
+  var topic *HelpTopic
+  for i := range topics {
+    if topics[i].Name == name {
+      topic = &topics[i]
+    }
+  }
+  cmd := findPluginCommand(name)
+
+  isBuiltin := false
+  for _, builtin := range getBuiltinCommands() {
+    isBuiltin = isBuiltin || builtin[0] == name
+  }
+  if name != "" && topic == nil && cmd == nil && !isBuiltin && !isTerraformCommand(name) &&
+    name != "terraform" && name != "commands" && name != "options" {
+    candidates := append(getHelpTopicNames(), getCommandNames()...)
+    if suggestions := SuggestValues(name, candidates); len(suggestions) > 0 {
+      FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown help topic or command '%s', %s", name, FormatSuggestions(suggestions))))
+    }
+    FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown help topic or command '%s', see `%s help` for the available ones", name, os.Args[0])))
+  }
+
+  stopPager := StartPager()
+  defer stopPager()
+
+  switch {
+  case name == "":
+    showHelpTopics(topics)
+  case name == "terraform":
+    showTerraformHelp(sandbox, nil)
+  case name == "commands":
+    showPluginHelp()
+  case name == "options":
+    Println("Global Options:")
+    PrintGlobalFlags()
+  case topic != nil:
+    showHelpTopic(topic)
+  default:
+    showCommandHelp(sandbox, name, cmd)
+  }
+}
+
+func showHelpTopics(topics []HelpTopic) {
+  Printf("Usage: %s [global options] <command> [options] [args]\n", os.Args[0])
+  Println("")
+  Println("Help topics:")
+  for _, topic := range topics {
+    Printf("    %-18s %s\n", topic.Name, topic.Description)
+  }
+  for _, topic := range builtinHelpTopics {
+    Printf("    %-18s %s\n", topic[0], topic[1])
+  }
+  Println("")
+  Printf("See `%s help <topic>`, or `%s help <command>` for the options and the\n", os.Args[0], os.Args[0])
+  Printf("examples of a command (eg. `%s help add-aws-cluster`).\n", os.Args[0])
+}
+
+func showHelpTopic(topic *HelpTopic) {
+  Printf("%s\n", Bold(topic.Description))
+  if topic.Text != "" {
+    Println("")
+    Printf("%s", topic.Text)
+  }
+  Println("")
+  Println("Commands:")
+  for _, name := range topic.Commands {
+    Printf("    %-18s %s\n", name, getCommandDescription(name))
+  }
+  Println("")
+  Printf("See `%s help <command>` for the options and the examples of a command.\n", os.Args[0])
+}
+
+/**
+ * Shows the help of terraform, or of one of its commands
+ */
+func showTerraformHelp(sandbox *ProjectSandbox, args []string) {
+  if !sandbox.HasTerraform() {
     showMissingTerraformHelp()
+    Printf("    %s\n", strings.Join(knownTerraformCommands, ", "))
+    return
   }
+  tf, err := sandbox.GetTerraform()
+  if err != nil {
+    FatalError(err)
+  }
+  tf.Invoke(args)
+}
 
-  // Show plugin help and exit
-  showPluginHelp()
-  os.Exit(1)
+func showCommandHelp(sandbox *ProjectSandbox, name string, cmd PluginCommand) {
+  helpArgs := []string{"-help"}
+  switch {
+  case cmd != nil:
+    if err := cmd.Handle(helpArgs, sandbox, nil); err != nil {
+      FatalError(err)
+    }
+  case name == "wheels-version":
+    showVersion(helpArgs)
+  case name == "wheels-upgrade":
+    upgradeWheels(helpArgs)
+  case name == "wheels-rollback":
+    rollbackWheels(helpArgs)
+  case name == "wheels-completion":
+    showCompletion(helpArgs)
+  case name == "wheels-render":
+    renderProject(sandbox, helpArgs)
+  case name == "tf":
+    showTfHelp()
+  default:
+    showTerraformHelp(sandbox, []string{name, "-help"})
+  }
+
+  examples, err := ReadCommandExamples(name)
+  if err != nil {
+    FatalError(err)
+  }
+  if len(examples) > 0 {
+    Println("")
+    Println("Examples:")
+    Println("")
+    for _, example := range examples {
+      Printf("  %s %s\n", os.Args[0], example)
+    }
+  }
 }
 
-func invokeTerraform(sandbox *ProjectSandbox, tf *TerraformWrapper, plugins []Plugin, args []string) {
-  isInit := false
-  for _, arg := range args {
-    if arg == "init" {
-      isInit = true
-      break
+/**
+ * Returns the description of the given command, that is not a terraform one
+ */
+func getCommandDescription(name string) string {
+  for _, builtin := range getBuiltinCommands() {
+    if builtin[0] == name {
+      return builtin[1]
     }
   }
+  if cmd := findPluginCommand(name); cmd != nil {
+    return cmd.GetDescription()
+  }
+  return ""
+}
 
-  // Pre-run
-  for _, plugin := range plugins {
-    err := plugin.BeforeRun(sandbox, tf, isInit)
+func showTfHelp() {
+  PrintHelp("tf", "-- <terraform arguments>", []interface{}{
+    "This command passes the arguments as-is to terraform, with the plugins",
+    "of the project, even if it's a terraform command that we do not know.",
+  }, nil)
+}
+
+/**
+ * Runs terraform with the hooks of the plugins, returning the error of
+ * terraform (with its exit code) if it failed
+ */
+func invokeTerraform(sandbox *ProjectSandbox, tf *TerraformWrapper, plugins []Plugin, args []string) error {
+  isInit := GetTerraformCommand(args) == "init"
+
+  // Parallel `init` runs are populating the same provider cache, so make
+  // sure only one of them is doing so at a time
+  if isInit {
+    lock, err := LockPluginCache()
+    if err != nil {
+      FatalError(err)
+    }
+    defer lock.Release()
+
+    if IsOffline() {
+      offlineArgs, err := AddOfflineInitArgs(args)
+      if err != nil {
+        FatalError(err)
+      }
+      args = offlineArgs
+    }
+  }
+
+  // The parts of the cluster given with --target-masters and the like
+  targetArgs, err := sandbox.ApplyTargetGroups(args)
+  if err != nil {
+    FatalError(err)
+  }
+  args = targetArgs
+
+  // Partial applies do not tell us anything about the project as a whole
+  isTargeted := ParseTerraformArgs(args).HasOption("target")
+
+  if IsFastMode() {
+    fastArgs, err := sandbox.ApplyFastMode(args)
     if err != nil {
-      FatalError(fmt.Errorf("Could not start %s: %s", plugin.GetName(), err.Error()))
+      FatalError(err)
+    }
+    args = fastArgs
+  }
+
+  // Guard the protected environments, and give them their own variables
+  if IsDestroyRun(args) {
+    if err := sandbox.CheckDestroyAllowed(); err != nil {
+      FatalError(err)
+    }
+  }
+  args = sandbox.AddWorkspaceVarFile(args)
+
+  // Show what the cluster is going to cost before changing it
+  cmd := GetTerraformCommand(args)
+  if sandbox.GetConfig().Cost.BeforeApply && (cmd == "plan" || cmd == "apply") {
+    if err := sandbox.PrintProjectCost(); err != nil {
+      PrintWarning("Could not estimate the cost: %s", err.Error())
+    }
+  }
+
+  // Give terraform the credentials of the configured AWS profile or role
+  if err := AttachAWSCredentials(tf); err != nil {
+    FatalError(err)
+  }
+
+  // Keep a copy of the state, in case the changes have to be rolled back
+  if cmd == "apply" || cmd == "destroy" {
+    sandbox.BackupStateBefore(tf, cmd)
+  }
+
+  // Pre-run, concurrently since the plugins are mostly independent
+  if errs := RunBeforeRun(plugins, sandbox, tf, isInit); len(errs) > 0 {
+    for _, err := range errs[1:] {
+      PrintError(err)
+    }
+    FatalError(WithDefaultExitCode(ExitPluginFailed, errs[0]))
+  }
+
+  // The hooks of .wheels.yaml that need the cluster that is going away
+  if IsDestroyRun(args) {
+    if err := sandbox.RunHooks(tf, "before_destroy", sandbox.GetConfig().Hooks.BeforeDestroy); err != nil {
+      FatalError(WithExitCode(ExitPluginFailed, fmt.Errorf("%s, not destroying (use --no-hooks to skip them)", err.Error())))
+    }
+  }
+
+  // The policies of the project and the quotas, before anything is changed.
+  // They are checked against the plan that is then applied.
+  if cmd == "apply" && !IsDestroyRun(args) {
+    if perr := sandbox.CheckBeforeApply(tf, args); perr != nil {
+      FatalError(perr)
+    }
+    if sandbox.HasPlanChecks() {
+      tf.SetApplyPreparer(func(args []string) ([]string, error) {
+        prepared, perr := sandbox.PlanCheckedApply(tf, args)
+        if perr != nil {
+          FatalError(perr)
+        }
+        return prepared, nil
+      })
+      // Also when exiting on an error
+      unregister := RegisterCleanup(sandbox.RemoveCheckedPlan)
+      defer func() {
+        unregister()
+        sandbox.RemoveCheckedPlan()
+      }()
     }
   }
 
   // Run
-  err := tf.Invoke(args)
+  if IsFailFastMode() || sandbox.GetConfig().FailFast.Enabled {
+    tf.EnableFailFast(sandbox.GetConfig().FailFast.Patterns)
+  }
+  err = tf.InvokeWithRetry(args, sandbox.GetConfig().Retry)
+  SetTelemetryExitCode(tf.GetLastExitCode())
+  switch tf.GetLastCommand() {
+  case "plan", "apply", "destroy", "refresh":
+    sandbox.PrintRunSummary(tf)
+  }
+
+  // A plan only tells what apply is going to refuse
+  if err == nil && tf.GetLastCommand() == "plan" && !tf.HasLastFailed() && sandbox.HasPolicies() {
+    if input, perr := sandbox.CreatePolicyInput(tf, tf.GetLastOutput()); perr != nil {
+      PrintWarning("Could not check the policies: %s", perr.Error())
+    } else if violations, perr := sandbox.EvaluatePolicies(input); perr != nil {
+      PrintWarning("Could not check the policies: %s", perr.Error())
+    } else if len(violations) > 0 {
+      PrintPolicyViolations(violations)
+      PrintWarning("This plan breaks %d policy rule(s), apply is going to refuse it", len(violations))
+    }
+  }
+
+  // Remember what was applied, for the next fast run
+  if err == nil && tf.GetLastCommand() == "apply" && !isTargeted {
+    if rerr := sandbox.RecordAppliedBlocks(); rerr != nil {
+      PrintWarning("Could not record the applied components: %s", rerr.Error())
+    }
+  } else if err == nil && tf.GetLastCommand() == "destroy" {
+    sandbox.ForgetAppliedBlocks()
+  }
+
+  // Keep the cluster registry up to date, so expired clusters can be reaped
+  if err == nil && tf.GetLastCommand() == "apply" {
+    if rerr := sandbox.MarkClusterApplied(); rerr != nil {
+      PrintWarning("Could not update the cluster registry: %s", rerr.Error())
+    }
+  } else if err == nil && tf.GetLastCommand() == "destroy" && !isTargeted {
+    if rerr := sandbox.UnregisterCluster(); rerr != nil {
+      PrintWarning("Could not update the cluster registry: %s", rerr.Error())
+    }
+  }
 
   // Post-run
   for _, plugin := range plugins {
+    span := StartSpan("plugin", plugin.GetName()+" after run")
     perr := plugin.AfterRun(sandbox, tf, err)
+    span.End()
     if perr != nil {
-      FatalError(fmt.Errorf("Could not finalize %s: %s", plugin.GetName(), err.Error()))
+      FatalError(WithDefaultExitCode(ExitPluginFailed, fmt.Errorf("Could not finalize %s: %s", plugin.GetName(), perr.Error())))
     }
   }
+
+  // Once the cluster is applied (and ready, when the readiness gate is on)
+  if err == nil && tf.GetLastCommand() == "apply" && !IsDestroyRun(args) {
+    if herr := sandbox.RunHooks(tf, "after_apply", sandbox.GetConfig().Hooks.AfterApply); herr != nil {
+      FatalError(WithExitCode(ExitPluginFailed, herr))
+    }
+  }
+  return err
 }
 
 func loadPlugins(sandbox *ProjectSandbox) []Plugin {
   var loadedPlugins []Plugin
   for _, plugin := range plugins {
+    if logged, ok := plugin.(PluginWithLogger); ok {
+      logged.SetLogger(CreateLogger(plugin.GetName()))
+    }
+    span := StartSpan("plugin", plugin.GetName()+" is used")
     used, err := plugin.IsUsed(sandbox)
+    span.End()
     if err != nil {
       FatalError(err)
     }
@@ -124,58 +864,93 @@ func loadPlugins(sandbox *ProjectSandbox) []Plugin {
     }
   }
 
-  return loadedPlugins
+  // Run the hooks in the order the plugins declare
+  sortedPlugins, err := SortPlugins(loadedPlugins)
+  if err != nil {
+    FatalError(err)
+  }
+
+  var names []string
+  for _, plugin := range sortedPlugins {
+    names = append(names, plugin.GetName())
+  }
+  SetTelemetryPlugins(names)
+  return sortedPlugins
 }
 
 func main() {
+  // Strip the options that are meant for us and not for terraform
+  os.Args = append(os.Args[:1], ParseGlobalFlags(os.Args[1:])...)
+  SetWheelsVersion(buildVersion)
+  HandleInterrupts()
+  defer WriteTrace()
+  defer SendTelemetry(false)
+
   // Early upgrade checks
   if len(os.Args) > 1 {
     cmd := os.Args[1]
 
     if cmd == "wheels-complete-upgrade" {
-      PrintInfo("🍺 Upgraded to latest version")
+      PrintInfo("🍺 Upgraded to version %s", Bold(buildVersion))
       CompleteUpgrade(os.Args[2])
       return
 
     } else if cmd == "wheels-version" {
-      PrintInfo("You are using terraform-wheels version %s", Bold(buildVersion))
+      SetTelemetryCommand(cmd)
+      showVersion(os.Args[2:])
+      return
+
+    } else if cmd == "wheels-completion" {
+      showCompletion(os.Args[2:])
       return
 
     } else if cmd == "wheels-upgrade" {
-      ver := semver.MustParse(buildVersion)
-      latest, err := GetLatestVersion()
-      if err != nil {
-        FatalError(err)
-      }
+      SetTelemetryCommand(cmd)
+      upgradeWheels(os.Args[2:])
+      return
 
-      if latest.Version.Compare(ver) > 0 {
-        PrintInfo("Upgrading from %s to %s", Bold(buildVersion), Bold(latest.Version.String()))
-        err = PerformUpgrade(latest)
-        if err != nil {
-          FatalError(err)
-        }
-      } else {
-        PrintInfo("You are running the latest released version")
-      }
+    } else if cmd == "wheels-rollback" {
+      SetTelemetryCommand(cmd)
+      rollbackWheels(os.Args[2:])
       return
     }
   }
 
   // Get a work directory sandbox
-  cwd, err := os.Getwd()
+  cwd, err := ChangeToProjectDir()
   if err != nil {
-    FatalError(err)
+    FatalError(WithExitCode(ExitConfigError, err))
+  }
+  // Only the runs that change the project lock it
+  openSandbox := OpenSandbox
+  if len(os.Args) <= 1 || isHelpArg(os.Args[1]) || isReadOnlyCommand(os.Args[1:]) {
+    openSandbox = OpenSandboxWithoutLock
   }
-  sandbox, err := OpenSandbox(cwd)
+  sandbox, err := openSandbox(cwd)
   if err != nil {
-    FatalError(err)
+    FatalError(WithExitCode(ExitConfigError, err))
+  }
+  defer sandbox.Unlock()
+  err = sandbox.CheckWheelsVersion()
+  if err != nil {
+    FatalError(WithExitCode(ExitConfigError, err))
   }
+  ConfigureAWSAuth(sandbox.GetConfig().AWS)
+  StartUpdateCheck(sandbox.GetConfig().UpdateCheck)
+  defer PrintUpdateBanner()
 
   // Handle help prompt early
-  if len(os.Args) <= 1 || strings.Contains(os.Args[1], "help") {
-    showHelp(sandbox)
+  if len(os.Args) <= 1 {
+    showHelp(sandbox, nil)
+    os.Exit(1)
+  }
+  if isHelpArg(os.Args[1]) {
+    showHelp(sandbox, os.Args[2:])
     return
   }
+  if err := sandbox.OpenLog(); err != nil {
+    PrintWarning("%s", err.Error())
+  }
 
   // Check the sandbox status
   hasTfFiles, err := sandbox.HasTerraformFiles()
@@ -183,81 +958,152 @@ func main() {
     FatalError(err)
   }
 
+  // Find the command, the flags before it are only meant for terraform
+  cmdName, cmdIndex := findCommand(os.Args[1:])
+  if cmdName == "" {
+    showHelp(sandbox, nil)
+    os.Exit(1)
+  }
+  cmdArgs := os.Args[cmdIndex+2:]
+
+  // Generating the files never needs terraform
+  if cmdName == "wheels-render" {
+    SetTelemetryCommand(cmdName)
+    renderProject(sandbox, cmdArgs)
+    return
+  }
+
+  // Explicitly pass everything after `--` to terraform, even the commands
+  // that we do not know about
+  if cmdName == "tf" {
+    if len(cmdArgs) > 0 && cmdArgs[0] == "--" {
+      cmdArgs = cmdArgs[1:]
+    }
+    if len(cmdArgs) == 0 || isHelpArg(cmdArgs[0]) {
+      showTfHelp()
+      return
+    }
+    SetTelemetryCommand("tf")
+    runTerraform(sandbox, cmdArgs, hasTfFiles)
+    return
+  }
+
   // Check if this is a plugin command and delegate it to the respective handler
-  if len(os.Args) > 1 {
+  if cmd := findPluginCommand(cmdName); cmd != nil {
+    SetTelemetryCommand(cmd.GetName())
+    if cmdIndex > 0 {
+      FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown option %s, the options of %s go after it", os.Args[1], cmdName)))
+    }
+    tf, err := sandbox.GetTerraform()
+    if err != nil {
+      FatalError(err)
+    }
 
-    // Check if we were invoked a valid terraform command
-    isTerraformCommand := false
-    for _, cmd := range knownTerraformCommands {
-      for _, arg := range os.Args[1:] {
-        if arg == cmd {
-          isTerraformCommand = true
-          break
+    // Some commands run terraform themselves, with the plugins of the
+    // (possibly just generated) project
+    ranTerraform := false
+    if runnerCmd, ok := cmd.(PluginCommandWithTerraform); ok {
+      runnerCmd.SetTerraformRunner(func(args []string) error {
+        if !ranTerraform && !hasTfFiles {
+          prepareNewProject(sandbox)
+        } else if err := sandbox.ReloadTerraformProject(); err != nil {
+          return err
         }
-      }
+        ranTerraform = true
+
+        if err := invokeTerraform(sandbox, tf, loadPlugins(sandbox), args); err != nil {
+          return WithExitCode(GetExitCode(err), fmt.Errorf("terraform %s failed with exit code %d", GetTerraformCommand(args), tf.GetLastExitCode()))
+        }
+        return nil
+      })
+    }
+    if projectCmd, ok := cmd.(PluginCommandWithProjectTerraform); ok {
+      projectCmd.SetProjectTerraformRunner(func(project *ProjectSandbox, projectTf *TerraformWrapper, args []string) error {
+        if err := project.ReloadTerraformProject(); err != nil {
+          return err
+        }
+        if err := invokeTerraform(project, projectTf, loadPlugins(project), args); err != nil {
+          return WithExitCode(GetExitCode(err), fmt.Errorf("terraform %s failed with exit code %d", GetTerraformCommand(args), projectTf.GetLastExitCode()))
+        }
+        return nil
+      })
     }
 
-    // Ignore flags until we find a command
-    cmd_n := ""
-    cmd_i := 0
-    for i := 1; i < len(os.Args); i++ {
-      if strings.HasPrefix(os.Args[i], "-") {
-        continue
-      }
-      cmd_i = i
-      cmd_n = os.Args[i]
-      break
+    if logged, ok := cmd.(PluginWithLogger); ok {
+      logged.SetLogger(CreateLogger(cmd.GetName()))
     }
 
-    // If there was no command, show help
-    if cmd_i == 0 {
-      showHelp(sandbox)
-      return
+    span := StartSpan("plugin", cmd.GetName())
+    err = cmd.Handle(cmdArgs, sandbox, tf)
+    span.End()
+    if err != nil {
+      FatalError(WithDefaultExitCode(ExitPluginFailed, err))
     }
 
-    // Check if this is a plugin command
-    for _, plugin := range plugins {
-      for _, cmd := range plugin.GetCommands() {
-        if cmd.GetName() == cmd_n {
-          tf, err := sandbox.GetTerraform()
-          if err != nil {
-            FatalError(err)
-          }
-
-          err = cmd.Handle(os.Args[2:], sandbox, tf)
-          if err != nil {
-            FatalError(err)
-          }
-
-          nowHasTfFiles, err := sandbox.HasTerraformFiles()
-          if err != nil {
-            FatalError(err)
-          }
-
-          // If that's the first time we saw some tf files, take the opportunity
-          // to run initialize, so the user has less things to do
-          if !hasTfFiles && nowHasTfFiles {
-            PrintInfo("Terraform project created, initializing now")
-
-            err := sandbox.ReloadTerraformProject()
-            if err != nil {
-              FatalError(err)
-            }
-
-            loadedPlugins := loadPlugins(sandbox)
-            invokeTerraform(sandbox, tf, loadedPlugins, []string{"init"})
-          }
-
-          return
-        }
+    nowHasTfFiles, err := sandbox.HasTerraformFiles()
+    if err != nil {
+      FatalError(err)
+    }
+
+    // If that's the first time we saw some tf files, take the opportunity
+    // to run initialize, so the user has less things to do
+    if !hasTfFiles && nowHasTfFiles && !ranTerraform {
+      PrintInfo("Terraform project created, initializing now")
+      prepareNewProject(sandbox)
+
+      loadedPlugins := loadPlugins(sandbox)
+      if err := invokeTerraform(sandbox, tf, loadedPlugins, sandbox.GetInitArgs()); err != nil {
+        Exit(GetExitCode(err))
       }
     }
+    return
+  }
 
-    // Check if this is not a terraform command neither
-    if !isTerraformCommand {
-      showHelp(sandbox)
-      return
+  // Anything else has to be a terraform command
+  if !isTerraformCommand(cmdName) {
+    if suggestions := SuggestValues(cmdName, getCommandNames()); len(suggestions) > 0 {
+      FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown command '%s', %s See `%s -help` for the available ones", cmdName, FormatSuggestions(suggestions), os.Args[0])))
     }
+    FatalError(WithExitCode(ExitConfigError, fmt.Errorf("Unknown command '%s', see `%s -help` for the available ones, or use `%s tf -- %s` to pass it to terraform anyway", cmdName, os.Args[0], os.Args[0], cmdName)))
+  }
+  SetTelemetryCommand(cmdName)
+  runTerraform(sandbox, os.Args[1:], hasTfFiles)
+}
+
+/**
+ * Loads the files of a project that was just generated, and prepares it for
+ * version control
+ */
+func prepareNewProject(sandbox *ProjectSandbox) {
+  err := sandbox.ReloadTerraformProject()
+  if err != nil {
+    FatalError(err)
+  }
+  if err := sandbox.BootstrapRepository(); err != nil {
+    PrintWarning("Could not prepare the project for version control: %s", err.Error())
+  }
+}
+
+/**
+ * Runs terraform with the given arguments, and the plugins of the project
+ */
+func runTerraform(sandbox *ProjectSandbox, args []string, hasTfFiles bool) {
+  // A directory of projects, rather than a project
+  subProjects, err := sandbox.ListSubProjects()
+  if err != nil {
+    FatalError(err)
+  }
+  if len(subProjects) > 0 {
+    PrintInfo("This directory contains the projects:")
+    for _, name := range subProjects {
+      Printf("  %s\n", name)
+    }
+    FatalError(fmt.Errorf("There are no terraform files here, use `%s --project-dir=<project> %s` to run it in one of the projects", os.Args[0], strings.Join(args, " ")))
+  }
+
+  // Nothing to plan (an apply can still be given a plan file)
+  if cmd := GetTerraformCommand(args); !hasTfFiles && (cmd == "plan" || cmd == "destroy" || cmd == "refresh") {
+    showInitUsage()
   }
 
   // Initialize terraform now
@@ -266,14 +1112,24 @@ func main() {
     FatalError(err)
   }
 
+  // Read-only commands go straight to terraform
+  if isReadOnlyCommand(args) {
+    if err := tf.Invoke(args); err != nil {
+      Exit(GetExitCode(err))
+    }
+    return
+  }
+
   // Forward to terraform
   loadedPlugins := loadPlugins(sandbox)
-  invokeTerraform(sandbox, tf, loadedPlugins, os.Args[1:])
+  tfErr := invokeTerraform(sandbox, tf, loadedPlugins, args)
 
   if !hasTfFiles {
-    fmt.Println("")
-    fmt.Printf("Consider running %s add-aws-cluster if you are trying to\n", os.Args[0])
-    fmt.Printf("launch a DC/OS cluster. Or %s -help to see all options\n", os.Args[0])
+    Println("")
+    Printf("Consider running %s new <name> to create a project, or %s add-aws-cluster\n", os.Args[0], os.Args[0])
+    Printf("to launch a DC/OS cluster here. Or %s -help to see all options\n", os.Args[0])
+  }
+  if tfErr != nil {
+    Exit(GetExitCode(tfErr))
   }
-
 }
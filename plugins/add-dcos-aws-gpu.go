@@ -0,0 +1,53 @@
+package plugins
+
+import (
+  "fmt"
+  "regexp"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * Returns the pool of GPU agents, running the NVIDIA driver installed by the
+ * gpu-agents.sh asset, and the data source of its AMI when none is given
+ */
+func getGpuAgentPool(count int, instanceType string, ami string, instanceOS string) (agentPoolSpec, []byte, error) {
+  if !regexp.MustCompile(`^(p[0-9]|g[0-9])[a-z]*\.`).MatchString(instanceType) {
+    PrintWarning("%s does not look like a GPU instance type (eg. p3.2xlarge or g4dn.xlarge)", instanceType)
+  }
+
+  script, err := ReadAsset("gpu-agents.sh")
+  if err != nil {
+    return agentPoolSpec{}, nil, err
+  }
+  // Given in a heredoc, where terraform would interpolate ${...}
+  userData := strings.ReplaceAll(string(script), "${", "$${")
+
+  var amiLines []string
+  if ami == "" {
+    if !strings.HasPrefix(instanceOS, "centos_") {
+      PrintWarning("The GPU agents are running CentOS, use -gpu-agents-ami to run %s on them", instanceOS)
+    }
+    ami = "${data.aws_ami.gpu-agents.id}"
+    amiLines = []string{
+      `// The GPU agents run CentOS 7, with the NVIDIA driver installed at boot`,
+      `data "aws_ami" "gpu-agents" {`,
+      `  most_recent = true`,
+      `  owners      = ["aws-marketplace"]`,
+      ``,
+      `  filter {`,
+      `    name   = "product-code"`,
+      `    values = ["aw0evgkw8e5c1q413zgy5pjce"]`,
+      `  }`,
+      `}`,
+      ``,
+      ``,
+    }
+  } else if !regexp.MustCompile(`^ami-[0-9a-f]+$`).MatchString(ami) {
+    return agentPoolSpec{}, nil, fmt.Errorf("Invalid AMI '%s', expected an ID like ami-0123456789abcdef0", ami)
+  }
+
+  pool := agentPoolSpec{"gpu", count, instanceType, false, "", ami, userData}
+  return pool, []byte(strings.Join(amiLines, "\n")), nil
+}
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+  "fmt"
+  "net"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * The hardening options of add-aws-cluster
+ */
+type clusterHardening struct {
+  adminIPs             StringSlice
+  restrictPublicAgents bool
+  strictSecurity       bool
+  encryptVolumes       bool
+}
+
+/**
+ * Applies the hardening options to module.dcos, returning the contents of
+ * the additional file they need, if any
+ */
+func (h *clusterHardening) apply(tfc *TerraformFileConfig) ([]byte, error) {
+  if len(h.adminIPs) > 0 {
+    if getFlagValue(tfc, "admin_ips", "") != "" {
+      return nil, fmt.Errorf("Please use either -admin-ips or -admin_ips, not both")
+    }
+    var cidrs []string
+    for _, cidr := range h.adminIPs {
+      if _, _, err := net.ParseCIDR(cidr); err != nil {
+        return nil, fmt.Errorf("Invalid admin CIDR '%s', expected eg. 10.0.0.0/8", cidr)
+      }
+      if strings.HasSuffix(cidr, "/0") {
+        PrintWarning("The admin CIDR %s opens the cluster to the whole world", cidr)
+      }
+      cidrs = append(cidrs, cidr)
+    }
+
+    // Replaces the address of the machine running terraform
+    tfc.PreLines = removeWhatIsMyIP(tfc.PreLines)
+    for i, line := range tfc.BodyLines {
+      if strings.HasPrefix(strings.TrimSpace(line), "admin_ips ") {
+        tfc.BodyLines[i] = fmt.Sprintf(`  admin_ips                  = %s`, FormatJSON(cidrs))
+      }
+    }
+  }
+
+  // Only the admins reach the load balancer of the public agents, since
+  // they are joined to the list
+  if h.restrictPublicAgents {
+    if getFlagValue(tfc, "public_agents_access_ips", "") != "" {
+      return nil, fmt.Errorf("-restrict-public-agents cannot be used together with -public_agents_access_ips")
+    }
+    tfc.BodyLines = append(tfc.BodyLines, ``, `  # Only the admin IPs can reach the public agents`, `  public_agents_access_ips = []`)
+  }
+
+  if h.strictSecurity {
+    if getFlagValue(tfc, "dcos_variant", "open") != "ee" {
+      return nil, fmt.Errorf("The strict security mode needs DC/OS Enterprise (-dcos_variant=ee)")
+    }
+    if value := getFlagValue(tfc, "dcos_security", "strict"); value != "strict" {
+      return nil, fmt.Errorf("-strict-security cannot be used together with -dcos_security=%s", value)
+    }
+    tfc.Flags.Set("dcos_security", "strict")
+  }
+
+  if !h.encryptVolumes {
+    return nil, nil
+  }
+  PrintWarning("-encrypt-volumes enables the default EBS encryption of the region, for the whole AWS account, until the cluster is destroyed")
+  lines := []string{
+    `// Encrypts all the new EBS volumes of the region, including the ones of`,
+    `// the cluster. This is a setting of the whole AWS account, that is turned`,
+    `// off again when the cluster is destroyed.`,
+    `resource "aws_ebs_encryption_by_default" "cluster" {`,
+    `  enabled = true`,
+    `}`,
+  }
+  return []byte(strings.Join(lines, "\n")), nil
+}
+
+/**
+ * Removes the data source that finds the public IP of the machine running
+ * terraform
+ */
+func removeWhatIsMyIP(lines []string) []string {
+  var ret []string
+  skipping := false
+  for _, line := range lines {
+    if strings.Contains(line, "Used to determine your public IP") || strings.HasPrefix(line, `data "http" "whatismyip"`) {
+      skipping = true
+      continue
+    }
+    if skipping {
+      if line == "}" {
+        skipping = false
+      }
+      continue
+    }
+    ret = append(ret, line)
+  }
+  for len(ret) > 0 && ret[0] == "" {
+    ret = ret[1:]
+  }
+  return ret
+}
@@ -0,0 +1,95 @@
+package plugins
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+)
+
+/**
+ * The SSH keys of the nodes that do not use the key of the cluster, given
+ * with -agents-ssh-key and the ssh-key option of -agent-pool
+ */
+type sshKeyAssignment struct {
+  agentsKey string
+  poolKeys  map[string]string
+}
+
+/**
+ * Returns the name of the key pair resource of the given role
+ */
+func keyPairName(role string) string {
+  return fmt.Sprintf("%s-ssh-key", role)
+}
+
+/**
+ * Returns the expression of the key name to use for the agents of the given
+ * pool (empty for the spot agents), or the default one
+ */
+func (k *sshKeyAssignment) getKeyName(pool string, defaultKeyName string) string {
+  if pool != "" {
+    if _, ok := k.poolKeys[pool]; ok {
+      return fmt.Sprintf("aws_key_pair.%s.key_name", keyPairName("pool-"+pool))
+    }
+  }
+  if k.agentsKey != "" {
+    return fmt.Sprintf("aws_key_pair.%s.key_name", keyPairName("agents"))
+  }
+  return defaultKeyName
+}
+
+func (k *sshKeyAssignment) isEmpty() bool {
+  return k.agentsKey == "" && len(k.poolKeys) == 0
+}
+
+/**
+ * Validates the name of a public key file
+ */
+func checkPublicKeyFile(file string) error {
+  if !regexp.MustCompile(`^[A-Za-z0-9._/-]+\.pub$`).MatchString(file) {
+    return fmt.Errorf("Invalid SSH public key file '%s', expected a file name ending with .pub", file)
+  }
+  return nil
+}
+
+/**
+ * Returns the contents of the file with the key pairs of the agents. Each key
+ * pair is replaced on its own when its public key changes, so the keys can be
+ * rotated independently.
+ */
+func generateKeyPairs(keys *sshKeyAssignment, clusterName string) []byte {
+  keyPair := func(role string, file string) []string {
+    return []string{
+      fmt.Sprintf(`resource "aws_key_pair" "%s" {`, keyPairName(role)),
+      fmt.Sprintf(`  key_name_prefix = "%s-%s-"`, clusterName, role),
+      fmt.Sprintf(`  public_key      = "${file("%s")}"`, file),
+      ``,
+      `  lifecycle {`,
+      `    create_before_destroy = true`,
+      `  }`,
+      `}`,
+    }
+  }
+
+  lines := []string{
+    `// The SSH keys of the agents, that are separate from the key of the cluster`,
+  }
+  if keys.agentsKey != "" {
+    lines = append(lines, keyPair("agents", keys.agentsKey)...)
+  }
+
+  var pools []string
+  for pool := range keys.poolKeys {
+    pools = append(pools, pool)
+  }
+  sort.Strings(pools)
+  for _, pool := range pools {
+    if len(lines) > 1 {
+      lines = append(lines, ``)
+    }
+    lines = append(lines, keyPair("pool-"+pool, keys.poolKeys[pool])...)
+  }
+
+  return []byte(strings.Join(lines, "\n"))
+}
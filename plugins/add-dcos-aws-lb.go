@@ -0,0 +1,93 @@
+package plugins
+
+import (
+  "fmt"
+  "regexp"
+  "strings"
+)
+
+/**
+ * The existing target groups to register the nodes with, given with
+ * -masters-target-groups and -public-agents-target-groups
+ */
+type existingLoadBalancers struct {
+  masters      []string
+  publicAgents []string
+}
+
+/**
+ * Validates the target group flags, returning nil if none is given
+ */
+func parseExistingLoadBalancers(masters string, publicAgents string) (*existingLoadBalancers, error) {
+  lbs := &existingLoadBalancers{splitIDs(masters), splitIDs(publicAgents)}
+  if len(lbs.masters) == 0 && len(lbs.publicAgents) == 0 {
+    return nil, nil
+  }
+
+  re := regexp.MustCompile(`^arn:aws[a-z-]*:elasticloadbalancing:[a-z0-9-]+:[0-9]+:targetgroup/.+$`)
+  for _, arn := range append(append([]string{}, lbs.masters...), lbs.publicAgents...) {
+    if !re.MatchString(arn) {
+      return nil, fmt.Errorf("Invalid target group '%s', expected an ARN like arn:aws:elasticloadbalancing:us-west-2:123456789012:targetgroup/name/0123456789abcdef", arn)
+    }
+  }
+  return lbs, nil
+}
+
+/**
+ * Disables the load balancers that module.dcos would create in place of the
+ * existing ones, and points the outputs to the existing ones
+ */
+func (lbs *existingLoadBalancers) apply(bodyLines []string, refs *awsClusterRefs) []string {
+  if len(lbs.masters) > 0 {
+    bodyLines = append(bodyLines, `  lb_disable_masters = true`)
+    refs.clusterAddress = "data.aws_lb.masters.dns_name"
+  }
+  if len(lbs.publicAgents) > 0 {
+    bodyLines = append(bodyLines, `  lb_disable_public_agents = true`)
+    refs.publicAgentsAddress = "data.aws_lb.public-agents.dns_name"
+  }
+  return bodyLines
+}
+
+/**
+ * Returns the contents of the file that registers the nodes with the
+ * existing target groups
+ */
+func (lbs *existingLoadBalancers) generate(refs awsClusterRefs) []byte {
+  lines := []string{
+    `// The nodes are registered with load balancers that are managed elsewhere`,
+  }
+
+  attach := func(name string, arns []string, instances string, count string) {
+    lines = append(lines,
+      `locals {`,
+      fmt.Sprintf(`  %s_target_groups = ["%s"]`, strings.Replace(name, "-", "_", -1), strings.Join(arns, `", "`)),
+      `}`,
+      ``,
+      fmt.Sprintf(`data "aws_lb_target_group" "%s" {`, name),
+      fmt.Sprintf(`  arn = "%s"`, arns[0]),
+      `}`,
+      ``,
+      fmt.Sprintf(`data "aws_lb" "%s" {`, name),
+      fmt.Sprintf(`  arn = "${element(data.aws_lb_target_group.%s.load_balancer_arns, 0)}"`, name),
+      `}`,
+      ``,
+      `// Every node is registered with every target group`,
+      fmt.Sprintf(`resource "aws_lb_target_group_attachment" "%s" {`, name),
+      fmt.Sprintf(`  count            = "${length(local.%s_target_groups) * %s}"`, strings.Replace(name, "-", "_", -1), count),
+      fmt.Sprintf(`  target_group_arn = "${element(local.%s_target_groups, floor(count.index / %s))}"`, strings.Replace(name, "-", "_", -1), count),
+      fmt.Sprintf(`  target_id        = "${element(%s, count.index)}"`, instances),
+      `}`,
+      ``,
+    )
+  }
+
+  if len(lbs.masters) > 0 {
+    attach("masters", lbs.masters, refs.mastersInstances, "var.num_masters")
+  }
+  if len(lbs.publicAgents) > 0 {
+    attach("public-agents", lbs.publicAgents, refs.publicAgentsInstances, "var.num_public_agents")
+  }
+
+  return []byte(strings.TrimSpace(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,151 @@
+package plugins
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * A pool of identical agents, given with -agent-pool
+ */
+type agentPoolSpec struct {
+  name         string
+  count        int
+  instanceType string
+  public       bool
+  sshKey       string
+  ami          string
+  userData     string
+}
+
+/**
+ * A flag.Value that collects the agent pools (the flag can be repeated)
+ */
+type agentPoolList []agentPoolSpec
+
+func (l *agentPoolList) String() string {
+  var names []string
+  for _, pool := range *l {
+    names = append(names, pool.name)
+  }
+  return strings.Join(names, ",")
+}
+
+func (l *agentPoolList) Set(value string) error {
+  options := make(StringMap)
+  for _, kv := range strings.Split(value, ",") {
+    if err := options.Set(kv); err != nil {
+      return err
+    }
+  }
+
+  pool := agentPoolSpec{"", 1, "t2.medium", false, "", "", ""}
+  for _, key := range []string{"name", "count", "type", "public", "ssh-key"} {
+    option, ok := options[key]
+    if !ok {
+      continue
+    }
+    delete(options, key)
+
+    switch key {
+    case "name":
+      pool.name = option
+    case "count":
+      n, err := strconv.Atoi(option)
+      if err != nil || n < 0 {
+        return fmt.Errorf("invalid count '%s'", option)
+      }
+      pool.count = n
+    case "type":
+      instanceType := CreateAWSInstanceTypeFlag("")
+      if err := instanceType.Set(option); err != nil {
+        return fmt.Errorf("invalid type '%s', %s", option, err.Error())
+      }
+      pool.instanceType = instanceType.Value
+    case "public":
+      b, err := strconv.ParseBool(option)
+      if err != nil {
+        return fmt.Errorf("invalid public '%s', expected true or false", option)
+      }
+      pool.public = b
+    case "ssh-key":
+      if err := checkPublicKeyFile(option); err != nil {
+        return err
+      }
+      pool.sshKey = option
+    }
+  }
+  for key := range options {
+    return fmt.Errorf("unknown pool option '%s', expected name, count, type, public or ssh-key", key)
+  }
+
+  if !regexp.MustCompile(`^[a-z][a-z0-9-]*$`).MatchString(pool.name) {
+    return fmt.Errorf("the pool name must only contain lower-case letters, digits and dashes")
+  }
+  for _, other := range *l {
+    if other.name == pool.name {
+      return fmt.Errorf("the pool %s is given more than once", pool.name)
+    }
+  }
+
+  *l = append(*l, pool)
+  return nil
+}
+
+/**
+ * Returns the contents of the file that describes the given agent pool,
+ * attached to the cluster in module.dcos
+ */
+func generateAgentPool(pool agentPoolSpec, clusterName string, instanceOS string, tags resourceTags, refs awsClusterRefs) []byte {
+  kind := "private"
+  securityGroups := refs.securityGroups
+  if pool.public {
+    kind = "public"
+    securityGroups = refs.publicSecurityGroups
+  }
+
+  lines := []string{
+    fmt.Sprintf(`// Agent pool "%s", installed by the cluster as additional %s agents`, pool.name, kind),
+    fmt.Sprintf(`module "dcos-pool-%s" {`, pool.name),
+    GetModuleSource(fmt.Sprintf("dcos-terraform/%s-agents/aws", kind), "0.2.0"),
+    ``,
+    `  providers = {`,
+    `    aws = "aws"`,
+    `  }`,
+    ``,
+    fmt.Sprintf(`  cluster_name = "%s"`, clusterName),
+    fmt.Sprintf(`  name_prefix  = "%s"`, pool.name),
+    ``,
+    fmt.Sprintf(`  num_%s_agents   = %d`, kind, pool.count),
+    fmt.Sprintf(`  aws_instance_type = "%s"`, pool.instanceType),
+    fmt.Sprintf(`  dcos_instance_os  = "%s"`, instanceOS),
+  }
+  if pool.ami != "" {
+    lines = append(lines, fmt.Sprintf(`  aws_ami           = "%s"`, pool.ami))
+  }
+  if pool.userData != "" {
+    lines = append(lines, ``, `  user_data = <<EOF`, strings.TrimRight(pool.userData, "\n"), `EOF`)
+  }
+  lines = append(lines,
+    ``,
+    fmt.Sprintf(`  aws_key_name             = "${%s}"`, refs.keyName),
+    fmt.Sprintf(`  aws_subnet_ids           = ["${%s}"]`, refs.subnetIDs),
+    fmt.Sprintf(`  aws_security_group_ids   = %s`, formatInterpolationList(securityGroups)),
+    fmt.Sprintf(`  aws_iam_instance_profile = "${%s}"`, refs.agentProfile),
+    ``,
+  )
+  lines = append(lines, tags.lines("  ", "")...)
+  lines = append(lines,
+    `}`,
+    ``,
+    fmt.Sprintf(`output "%s-agents-ips" {`, pool.name),
+    fmt.Sprintf(`  value = "${module.dcos-pool-%s.private_ips}"`, pool.name),
+    `}`,
+  )
+
+  return []byte(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,51 @@
+package plugins
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestAgentPoolList(t *testing.T) {
+  tests := []struct {
+    values []string
+    want   agentPoolList
+    err    string
+  }{
+    {
+      values: []string{"name=gpu,count=2,type=p3.2xlarge", "name=edge,public=true"},
+      want: agentPoolList{
+        {name: "gpu", count: 2, instanceType: "p3.2xlarge"},
+        {name: "edge", count: 1, instanceType: "t2.medium", public: true},
+      },
+    },
+    {values: []string{"name=new,type=t2.meduim"}, err: "invalid type 't2.meduim', did you mean 't2.medium'?"},
+    {values: []string{"name=gpu,count"}, err: "expected key=value, got 'count'"},
+    {values: []string{"name=gpu,count=-1"}, err: "invalid count '-1'"},
+    {values: []string{"name=gpu,type=p3"}, err: "invalid type 'p3', expected an instance type like m5.xlarge"},
+    {values: []string{"name=gpu,public=maybe"}, err: "invalid public 'maybe'"},
+    {values: []string{"name=gpu,size=2"}, err: "unknown pool option 'size'"},
+    {values: []string{"name=GPU"}, err: "the pool name must only contain"},
+    {values: []string{"name=gpu", "name=gpu"}, err: "the pool gpu is given more than once"},
+  }
+  for _, test := range tests {
+    var pools agentPoolList
+    var err error
+    for _, value := range test.values {
+      if err = pools.Set(value); err != nil {
+        break
+      }
+    }
+    if test.err != "" {
+      if err == nil || !strings.Contains(err.Error(), test.err) {
+        t.Errorf("%q: got the error %v, want %q", test.values, err, test.err)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%q: %s", test.values, err.Error())
+    } else if !reflect.DeepEqual(pools, test.want) {
+      t.Errorf("%q: got %+v, want %+v", test.values, pools, test.want)
+    }
+  }
+}
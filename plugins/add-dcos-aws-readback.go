@@ -0,0 +1,360 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "net"
+  "regexp"
+  "sort"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// The parameters of module.dcos that are not read back as they are, because
+// they are given by other options
+var readBackReplacedBy map[string][]string = map[string][]string{
+  "admin_ips":                []string{"admin-ips", "admin-ip"},
+  "availability_zones":       []string{"availability-zones"},
+  "public_agents_access_ips": []string{"restrict-public-agents"},
+  "dcos_security":            []string{"strict-security"},
+  "dcos_instance_os":         []string{"os"},
+  "aws_ami":                  []string{"ami"},
+}
+
+// The generated files describing parts of the cluster that cannot be read
+// back, with the options that create them
+var readBackUnsupported [][]string = [][]string{
+  {"cluster-aws-keys.tf", "agents-ssh-key", "agent-pool"},
+  {"cluster-aws-loadbalancers.tf", "masters-target-groups", "public-agents-target-groups"},
+  {"cluster-aws-network.tf", "vpc-id"},
+}
+
+/**
+ * What was read back from the files of an existing cluster
+ */
+type clusterReadBack struct {
+  // The options that were read back
+  options []string
+
+  // The agents of module.dcos that were added by other commands (eg.
+  // add-aws-remote-agents), by the name of the parameter that installs them
+  externalAgents map[string][]string
+}
+
+/**
+ * Reads the options of the cluster back from the files generated by an
+ * earlier add-aws-cluster, for the ones that are not given again, so that
+ * only what is given on the command-line changes
+ */
+func readBackCluster(project *ProjectSandbox, tfc *TerraformFileConfig, fileName string, tags StringMap) (*clusterReadBack, error) {
+  given := make(map[string]bool)
+  tfc.Flags.Visit(func(f *flag.Flag) {
+    given[f.Name] = true
+  })
+  isGiven := func(names ...string) bool {
+    for _, name := range names {
+      if given[name] {
+        return true
+      }
+    }
+    return false
+  }
+
+  var readBack []string
+  set := func(name string, value string) error {
+    if err := tfc.Flags.Set(name, value); err != nil {
+      return fmt.Errorf("Could not read -%s back from %s: %s", name, fileName, err.Error())
+    }
+    readBack = append(readBack, name)
+    return nil
+  }
+
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return nil, err
+  }
+  generated := make(map[string]bool)
+  for _, file := range groups["add-aws-cluster"] {
+    generated[file] = project.HasFile(file)
+  }
+  for _, unsupported := range readBackUnsupported {
+    if generated[unsupported[0]] && !isGiven(unsupported[1:]...) {
+      return nil, fmt.Errorf("The cluster was created with -%s (see %s), that cannot be read back. Please give it again, or use -reset to start from the defaults", strings.Join(unsupported[1:], " or -"), unsupported[0])
+    }
+  }
+
+  ret := &clusterReadBack{externalAgents: make(map[string][]string)}
+  if !generated[fileName] {
+    return ret, nil
+  }
+  mod, err := readBackBlock(project, fileName, "module", "dcos")
+  if err != nil || mod == nil {
+    return ret, err
+  }
+  values, err := readBackVariables(project)
+  if err != nil {
+    return nil, err
+  }
+
+  // The spot agents keep the number of private agents
+  spot, err := readBackBlock(project, "agents-spot.tf", "resource", "aws_spot_instance_request", "spot-agents")
+  if err != nil {
+    return nil, err
+  }
+  if spot != nil && generated["agents-spot.tf"] && !isGiven("spot-agents") {
+    if err := set("spot-agents", "true"); err != nil {
+      return nil, err
+    }
+    if price, ok := spot.GetLiteral("spot_price"); ok && !isGiven("spot-max-price") {
+      if err := set("spot-max-price", price); err != nil {
+        return nil, err
+      }
+    }
+    if count, ok := spot.GetLiteral("count"); ok && !isGiven("num_private_agents") {
+      if err := set("num_private_agents", count); err != nil {
+        return nil, err
+      }
+    }
+    given["num_private_agents"] = true
+  }
+
+  for _, name := range mod.Attributes() {
+    f := tfc.Flags.Lookup(name)
+    if f == nil || tfc.IsIgnored(name) || name == DcosLicenseVariable || isGiven(name) || isGiven(readBackReplacedBy[name]...) {
+      continue
+    }
+
+    if tfc.IsList(name) {
+      list, ok := mod.GetList(name)
+      if !ok || strings.Contains(strings.Join(list, ","), "${") {
+        continue
+      }
+      if name == "admin_ips" && isCIDRList(list) {
+        if err := set("admin-ips", strings.Join(list, ",")); err != nil {
+          return nil, err
+        }
+        continue
+      }
+      if name == "public_agents_access_ips" && len(list) == 0 {
+        if err := set("restrict-public-agents", "true"); err != nil {
+          return nil, err
+        }
+      }
+      for _, item := range list {
+        if err := set(name, item); err != nil {
+          return nil, err
+        }
+      }
+      continue
+    }
+
+    value, ok := mod.GetLiteral(name)
+    if !ok {
+      continue
+    }
+    if strings.HasPrefix(value, "${var.") && strings.HasSuffix(value, "}") {
+      value, ok = values[strings.TrimSuffix(strings.TrimPrefix(value, "${var."), "}")]
+      if !ok {
+        continue
+      }
+    }
+    if strings.Contains(value, "${") || (name == "ansible_bundled_container" && value == windowsAnsibleContainer) {
+      continue
+    }
+    // The custom AMI is given to the spot agents too
+    if name == "aws_ami" {
+      name = "ami"
+    }
+    if err := set(name, value); err != nil {
+      return nil, err
+    }
+  }
+
+  // The tags of cloud-cleaner have their own options
+  if existing, ok := mod.GetMap("tags"); ok {
+    var keys []string
+    for key := range existing {
+      keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+      switch key {
+      case "expiration", "owner":
+        if !isGiven(key, "expires-in") {
+          if err := set(key, existing[key]); err != nil {
+            return nil, err
+          }
+        }
+      default:
+        if _, ok := tags[key]; !ok {
+          tags[key] = existing[key]
+          readBack = append(readBack, "tag")
+        }
+      }
+    }
+  }
+
+  if err := readBackExtraAgents(project, groups["add-aws-cluster"], isGiven, set); err != nil {
+    return nil, err
+  }
+  if generated["cluster-aws-encryption.tf"] && !isGiven("encrypt-volumes") {
+    if err := set("encrypt-volumes", "true"); err != nil {
+      return nil, err
+    }
+  }
+
+  // Only the agents of the files generated by add-aws-cluster are given
+  // again by the options
+  for _, name := range []string{"additional_private_agent_ips", "additional_public_agent_ips"} {
+    value, _ := mod.GetAttribute(name)
+    for _, m := range regexp.MustCompile(`module\.([A-Za-z0-9_-]+)\.[A-Za-z0-9_.*]*private_ips`).FindAllStringSubmatch(value, -1) {
+      if !strings.HasPrefix(m[1], "dcos-pool-") {
+        ret.externalAgents[name] = append(ret.externalAgents[name], m[0])
+      }
+    }
+  }
+
+  seen := make(map[string]bool)
+  for _, name := range readBack {
+    if !seen[name] {
+      ret.options = append(ret.options, name)
+      seen[name] = true
+    }
+  }
+  return ret, nil
+}
+
+/**
+ * Reads back the agent pools, the GPU agents and the Windows agents
+ */
+func readBackExtraAgents(project *ProjectSandbox, files []string, isGiven func(...string) bool, set func(string, string) error) error {
+  files = append([]string{}, files...)
+  sort.Strings(files)
+  windowsAgents := false
+  for _, file := range files {
+    windowsAgents = windowsAgents || file == "agents-windows.tf"
+    if !strings.HasPrefix(file, "agents-") || file == "agents-spot.tf" || file == "agents-windows.tf" || !project.HasFile(file) {
+      continue
+    }
+    name := strings.TrimSuffix(strings.TrimPrefix(file, "agents-"), ".tf")
+    pool, err := readBackBlock(project, file, "module", "dcos-pool-"+name)
+    if err != nil {
+      return err
+    }
+    if pool == nil {
+      continue
+    }
+
+    instanceType, _ := pool.GetLiteral("aws_instance_type")
+    count, public := pool.GetLiteral("num_public_agents")
+    if !public {
+      count, _ = pool.GetLiteral("num_private_agents")
+    }
+
+    // The GPU agents are a pool too, with their own options
+    if _, ok := pool.GetAttribute("user_data"); ok && name == "gpu" {
+      if isGiven("num-gpu-agents") {
+        continue
+      }
+      if err := set("num-gpu-agents", count); err != nil {
+        return err
+      }
+      if !isGiven("gpu-instance-type") {
+        if err := set("gpu-instance-type", instanceType); err != nil {
+          return err
+        }
+      }
+      if ami, ok := pool.GetLiteral("aws_ami"); ok && !strings.Contains(ami, "${") && !isGiven("gpu-agents-ami") {
+        if err := set("gpu-agents-ami", ami); err != nil {
+          return err
+        }
+      }
+      continue
+    }
+
+    if isGiven("agent-pool") {
+      continue
+    }
+    desc := fmt.Sprintf("name=%s,count=%s,type=%s", name, count, instanceType)
+    if public {
+      desc += ",public=true"
+    }
+    if err := set("agent-pool", desc); err != nil {
+      return err
+    }
+  }
+
+  if !windowsAgents || isGiven("num-windows-agents") {
+    return nil
+  }
+  windows, err := readBackBlock(project, "agents-windows.tf", "module", "dcos-windows-agents")
+  if err != nil || windows == nil {
+    return err
+  }
+  if count, ok := windows.GetLiteral("num"); ok {
+    if err := set("num-windows-agents", count); err != nil {
+      return err
+    }
+  }
+  if instanceType, ok := windows.GetLiteral("aws_instance_type"); ok && !isGiven("windows-agents-instance-type") {
+    if err := set("windows-agents-instance-type", instanceType); err != nil {
+      return err
+    }
+  }
+  if ami, ok := windows.GetLiteral("aws_ami"); ok && !isGiven("windows-agents-ami") {
+    if err := set("windows-agents-ami", ami); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func isCIDRList(list []string) bool {
+  for _, item := range list {
+    if _, _, err := net.ParseCIDR(item); err != nil {
+      return false
+    }
+  }
+  return len(list) > 0
+}
+
+/**
+ * Returns the block of a project file, or nil if the file or the block does
+ * not exist
+ */
+func readBackBlock(project *ProjectSandbox, fileName string, keys ...string) (*TerraformBlock, error) {
+  if !project.HasFile(fileName) {
+    return nil, nil
+  }
+  content, err := project.ReadFile(fileName)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", fileName, err.Error())
+  }
+  tf, err := ParseTerraformFile(content)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", fileName, err.Error())
+  }
+  return tf.Block(keys...), nil
+}
+
+/**
+ * Returns the values of the variables of the cluster, from the tfvars files
+ * of the current workspace
+ */
+func readBackVariables(project *ProjectSandbox) (map[string]string, error) {
+  values := make(map[string]string)
+  for _, file := range []string{SecretVariablesFile, "terraform.tfvars", GetWorkspaceVarsFile(project.GetWorkspace())} {
+    if file == "" || !project.HasFile(file) {
+      continue
+    }
+    tfvars, err := project.ReadTerraformFile(file)
+    if err != nil {
+      return nil, err
+    }
+    for name, value := range tfvars {
+      values[name] = fmt.Sprint(value)
+    }
+  }
+  return values, nil
+}
@@ -0,0 +1,240 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "regexp"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// The default subnet range of the dcos-terraform infrastructure
+var defaultDcosSubnetRange string = "172.12.0.0/16"
+
+type PluginDcosAwsCmdAddRemoteAgents struct {
+  parent *PluginDcosAws
+}
+
+func (p *PluginDcosAwsCmdAddRemoteAgents) GetName() string {
+  return "add-aws-remote-agents"
+}
+
+func (p *PluginDcosAwsCmdAddRemoteAgents) GetDescription() string {
+  return "Adds a pool of private agents living in another AWS account or VPC"
+}
+
+func (p *PluginDcosAwsCmdAddRemoteAgents) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fName := fSet.String("name", "burst", "The name of the agent pool")
+  fRegion := fSet.String("region", "us-west-2", "The AWS region to launch the agents in")
+  fRoleArn := fSet.String("role_arn", "", "The IAM role to assume in the other AWS account")
+  fProfile := fSet.String("profile", "", "The AWS profile to use for the other AWS account")
+  fNum := fSet.Int("num_private_agents", 1, "The number of private agents in the pool")
+  fInstanceType := CreateAWSInstanceTypeFlag("t2.medium")
+  fSet.Var(fInstanceType, "private_agents_instance_type", "The instance type of the agents")
+  fSubnetRange := fSet.String("subnet_range", "10.128.0.0/16", "The private IP space of the agent pool VPC (must not overlap with the cluster)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will generate a file that launches additional private agents",
+      "in a different AWS account (or region), peers their VPC with the cluster VPC",
+      "and installs them through the bootstrap node of the existing cluster.",
+      "",
+      "To use a different account, specify either the IAM role to assume in it",
+      "with -role_arn, or an AWS profile with -profile.",
+    }, fSet)
+    return nil
+  }
+
+  if !regexp.MustCompile(`^[a-z][a-z0-9-]*$`).MatchString(*fName) {
+    return fmt.Errorf("The pool name must only contain lower-case letters, digits and dashes")
+  }
+
+  // The agents are installed by the bootstrap node of the cluster, so we
+  // need a cluster to attach them to
+  mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
+  if len(mods) == 0 {
+    return fmt.Errorf("Could not find a DC/OS cluster in the project. Please use %s first", Bold("add-aws-cluster"))
+  }
+  if len(mods) > 1 {
+    return fmt.Errorf("Found more than one DC/OS cluster in the project, this is not supported")
+  }
+  cluster := mods[0]
+  clusterName := cluster["_name"].(string)
+
+  if _, ok := cluster["additional_private_agent_ips"]; ok {
+    return fmt.Errorf("The cluster already has additional private agents, please add the pool manually")
+  }
+
+  localRange := defaultDcosSubnetRange
+  if v, ok := cluster["subnet_range"].(string); ok {
+    localRange = v
+  }
+  if localRange == *fSubnetRange {
+    return fmt.Errorf("The subnet range %s is already used by the cluster, please pick another with -subnet_range", localRange)
+  }
+
+  clusterNameValue := "dcos-example"
+  if v, ok := cluster["cluster_name"].(string); ok {
+    clusterNameValue = v
+  }
+
+  sshKeyFile := "cluster-key.pub"
+  if v, ok := cluster["ssh_public_key_file"].(string); ok {
+    sshKeyFile = v
+  }
+
+  poolName := fmt.Sprintf("dcos-%s", *fName)
+  fileName := fmt.Sprintf("agents-%s.tf", *fName)
+  if project.HasFile(fileName) {
+    return fmt.Errorf("The agent pool %s already exists in %s", *fName, fileName)
+  }
+
+  lines := []string{
+    fmt.Sprintf(`// Agent pool "%s", attached to the cluster in module.%s`, *fName, clusterName),
+    `provider "aws" {`,
+    fmt.Sprintf(`  alias  = "%s"`, *fName),
+    fmt.Sprintf(`  region = "%s"`, *fRegion),
+  }
+  if *fProfile != "" {
+    lines = append(lines, fmt.Sprintf(`  profile = "%s"`, *fProfile))
+  }
+  if *fRoleArn != "" {
+    lines = append(lines,
+      ``,
+      `  assume_role {`,
+      fmt.Sprintf(`    role_arn     = "%s"`, *fRoleArn),
+      fmt.Sprintf(`    session_name = "terraform-wheels-%s"`, *fName),
+      `  }`,
+    )
+  }
+  lines = append(lines,
+    `}`,
+    ``,
+    fmt.Sprintf(`module "%s" {`, poolName),
+    GetModuleSource("dcos-terraform/infrastructure/aws", "0.2.0"),
+    ``,
+    `  providers = {`,
+    fmt.Sprintf(`    aws = "aws.%s"`, *fName),
+    `  }`,
+    ``,
+    fmt.Sprintf(`  cluster_name               = "%s"`, clusterNameValue),
+    fmt.Sprintf(`  name_prefix                = "%s"`, *fName),
+    fmt.Sprintf(`  ssh_public_key_file        = "%s"`, sshKeyFile),
+    fmt.Sprintf(`  subnet_range               = "%s"`, *fSubnetRange),
+    fmt.Sprintf(`  accepted_internal_networks = ["%s", "%s"]`, localRange, *fSubnetRange),
+  )
+  if _, ok := project.GetTerraformResources("data")["http"]; ok {
+    lines = append(lines, `  admin_ips                  = ["${data.http.whatismyip.body}/32"]`)
+  }
+  lines = append(lines,
+    ``,
+    `  num_masters                  = 0`,
+    `  num_public_agents            = 0`,
+    fmt.Sprintf(`  num_private_agents           = %d`, *fNum),
+    fmt.Sprintf(`  private_agents_instance_type = "%s"`, fInstanceType.Value),
+    `  lb_disable_masters           = true`,
+    `  lb_disable_public_agents     = true`,
+    `}`,
+    ``,
+    `// Peer the agent pool VPC with the cluster VPC. The connection is requested`,
+    `// from the cluster account and accepted from the agent pool account.`,
+    fmt.Sprintf(`data "aws_caller_identity" "%s" {`, *fName),
+    fmt.Sprintf(`  provider = "aws.%s"`, *fName),
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "aws_vpc_peering_connection" "%s" {`, *fName),
+    fmt.Sprintf(`  vpc_id        = "${module.%s.infrastructure.vpc.id}"`, clusterName),
+    fmt.Sprintf(`  peer_vpc_id   = "${module.%s.vpc.id}"`, poolName),
+    fmt.Sprintf(`  peer_owner_id = "${data.aws_caller_identity.%s.account_id}"`, *fName),
+    fmt.Sprintf(`  peer_region   = "%s"`, *fRegion),
+    ``,
+    `  tags = {`,
+    fmt.Sprintf(`    Name = "%s"`, poolName),
+    `  }`,
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "aws_vpc_peering_connection_accepter" "%s" {`, *fName),
+    fmt.Sprintf(`  provider                  = "aws.%s"`, *fName),
+    fmt.Sprintf(`  vpc_peering_connection_id = "${aws_vpc_peering_connection.%s.id}"`, *fName),
+    `  auto_accept               = true`,
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "aws_route" "%s-to-pool" {`, *fName),
+    fmt.Sprintf(`  route_table_id            = "${module.%s.infrastructure.vpc.main_route_table_id}"`, clusterName),
+    fmt.Sprintf(`  destination_cidr_block    = "%s"`, *fSubnetRange),
+    fmt.Sprintf(`  vpc_peering_connection_id = "${aws_vpc_peering_connection_accepter.%s.id}"`, *fName),
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "aws_route" "%s-to-cluster" {`, *fName),
+    fmt.Sprintf(`  provider                  = "aws.%s"`, *fName),
+    fmt.Sprintf(`  route_table_id            = "${module.%s.vpc.main_route_table_id}"`, poolName),
+    fmt.Sprintf(`  destination_cidr_block    = "%s"`, localRange),
+    fmt.Sprintf(`  vpc_peering_connection_id = "${aws_vpc_peering_connection_accepter.%s.id}"`, *fName),
+    `}`,
+    ``,
+    fmt.Sprintf(`output "%s-agents-ips" {`, *fName),
+    fmt.Sprintf(`  value = "${module.%s.private_agents.private_ips}"`, poolName),
+    `}`,
+  )
+
+  content, err := FormatTerraform([]byte(strings.Join(lines, "\n")))
+  if err != nil {
+    return fmt.Errorf("Could not format %s: %s", fileName, err.Error())
+  }
+
+  // Let the bootstrap node of the cluster install the new agents, and allow
+  // the traffic from the agent pool network
+  clusterLines := []string{
+    ``,
+    fmt.Sprintf(`  # Agent pool "%s"`, *fName),
+    fmt.Sprintf(`  additional_private_agent_ips = ["${module.%s.private_agents.private_ips}"]`, poolName),
+  }
+  if _, ok := cluster["accepted_internal_networks"]; ok {
+    PrintWarning("Please add %s to the accepted_internal_networks of module.%s", *fSubnetRange, clusterName)
+  } else {
+    clusterLines = append(clusterLines,
+      fmt.Sprintf(`  accepted_internal_networks   = ["%s", "%s"]`, localRange, *fSubnetRange))
+  }
+  clusterFile, clusterContent, err := project.PrepareTerraformBlockEdit("module", clusterName, func(block *TerraformBlock) error {
+    return block.AppendLines(clusterLines)
+  })
+  if err != nil {
+    return err
+  }
+
+  // Only once both files are ready, so a failure leaves the project as is
+  if err := project.WriteFormattedTerraformFile(fileName, content); err != nil {
+    return err
+  }
+  if err := project.WriteFormattedTerraformFile(clusterFile, clusterContent); err != nil {
+    return err
+  }
+  if err := project.ReloadTerraformProject(); err != nil {
+    return err
+  }
+
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing the remote agent pool"))
+  PrintMessage([]interface{}{
+    "",
+    fmt.Sprintf("The agents will be installed by the bootstrap node of module.%s once the", clusterName),
+    "VPC peering is established. Your next steps are:",
+    "",
+    fmt.Sprintf("  1. Open %s and adjust the configuration to your needs", fileName),
+    fmt.Sprintf("  2. %s init                   # To fetch the new modules", os.Args[0]),
+    fmt.Sprintf("  3. %s plan -out=plan.out     # To prepare your deployment", os.Args[0]),
+    fmt.Sprintf("  4. %s apply plan.out         # To launch the agents", os.Args[0]),
+    "",
+  })
+  return nil
+}
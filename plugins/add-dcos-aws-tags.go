@@ -0,0 +1,94 @@
+package plugins
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * The tags given to all the cloud resources of the cluster
+ */
+type resourceTags map[string]string
+
+/**
+ * Returns the keys of the tags, with the ones used by cloud-cleaner first
+ */
+func (t resourceTags) keys() []string {
+  var keys []string
+  for key := range t {
+    if key != "expiration" && key != "owner" {
+      keys = append(keys, key)
+    }
+  }
+  sort.Strings(keys)
+  for _, key := range []string{"owner", "expiration"} {
+    if _, ok := t[key]; ok {
+      keys = append([]string{key}, keys...)
+    }
+  }
+  return keys
+}
+
+/**
+ * Returns the lines of a tags block with the given indentation, starting
+ * with the Name of the resource if given
+ */
+func (t resourceTags) lines(indent string, name string) []string {
+  var kvs [][2]string
+  if name != "" {
+    kvs = append(kvs, [2]string{"Name", name})
+  }
+  for _, key := range t.keys() {
+    kvs = append(kvs, [2]string{key, t[key]})
+  }
+
+  width := 0
+  for _, kv := range kvs {
+    if len(FormatJSON(kv[0])) > width {
+      width = len(FormatJSON(kv[0]))
+    }
+  }
+  lines := []string{indent + `tags = {`}
+  for _, kv := range kvs {
+    lines = append(lines, fmt.Sprintf(`%s  %-*s = %s`, indent, width, FormatJSON(kv[0]), FormatJSON(kv[1])))
+  }
+  return append(lines, indent+`}`)
+}
+
+/**
+ * Returns the tags of the cluster: the defaults of .wheels.yaml, the ones
+ * given on the command-line with -tag and the ones of cloud-cleaner, that
+ * have their own options
+ */
+func getClusterTags(defaults map[string]string, tags StringMap, expiration string, owner string) (resourceTags, error) {
+  ret := make(resourceTags)
+  for key, value := range defaults {
+    if err := checkClusterTag(key); err != nil {
+      return nil, WithExitCode(ExitConfigError, fmt.Errorf("Invalid tag in %s: %s", WheelsConfigFile, err.Error()))
+    }
+    ret[strings.TrimSpace(key)] = value
+  }
+  for key, value := range tags {
+    if err := checkClusterTag(key); err != nil {
+      return nil, WithExitCode(ExitConfigError, fmt.Errorf("Invalid -tag %s=%s: %s", key, value, err.Error()))
+    }
+    ret[key] = value
+  }
+  ret["expiration"] = expiration
+  ret["owner"] = owner
+  return ret, nil
+}
+
+func checkClusterTag(key string) error {
+  key = strings.TrimSpace(key)
+  if key == "" {
+    return fmt.Errorf("The tag has no name")
+  }
+  if key == "expiration" || key == "owner" {
+    return fmt.Errorf("The %s tag is given with -%s", key, key)
+  }
+  return nil
+}
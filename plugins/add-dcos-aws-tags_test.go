@@ -0,0 +1,94 @@
+package plugins
+
+import (
+  "flag"
+  "io/ioutil"
+  "reflect"
+  "strings"
+  "testing"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+func TestClusterTagsFlag(t *testing.T) {
+  tests := []struct {
+    name string
+    args []string
+    want resourceTags
+    err  string
+  }{
+    {"repeated -tag", []string{"-tag=team=infra", "-tag=env=dev"}, resourceTags{"team": "infra", "env": "dev"}, ""},
+    {"-tags and -tag", []string{"-tags=team=infra", "-tag=env=dev"}, resourceTags{"team": "infra", "env": "dev"}, ""},
+    {"duplicate key", []string{"-tag=env=dev", "-tag=team=infra", "-tag=env=prod"}, resourceTags{"team": "infra", "env": "prod"}, ""},
+    {"value with an equal sign", []string{"-tag=query=a=b"}, resourceTags{"query": "a=b"}, ""},
+    {"spaces around the key", []string{"-tag= env =dev"}, resourceTags{"env": "dev"}, ""},
+    {"empty value", []string{"-tag=env="}, resourceTags{"env": ""}, ""},
+    {"malformed pair", []string{"-tag=team=infra", "-tag=env"}, nil, "expected key=value, got 'env'"},
+    {"empty key", []string{"-tag==dev"}, nil, "expected key=value, got '=dev'"},
+    {"cloud-cleaner tag", []string{"-tag=owner=me"}, nil, "The owner tag is given with -owner"},
+    {"cloud-cleaner tag with spaces", []string{"-tag= expiration =1h"}, nil, "The expiration tag is given with -expiration"},
+  }
+
+  for _, test := range tests {
+    given := make(StringMap)
+    fSet := flag.NewFlagSet("add-aws-cluster", flag.ContinueOnError)
+    fSet.SetOutput(ioutil.Discard)
+    fSet.Var(given, "tags", "")
+    fSet.Var(given, "tag", "")
+    err := fSet.Parse(test.args)
+    var tags resourceTags
+    if err == nil {
+      tags, err = getClusterTags(nil, given, "1h", "me")
+      delete(tags, "expiration")
+      delete(tags, "owner")
+    }
+    if test.err != "" {
+      if err == nil || !strings.Contains(err.Error(), test.err) {
+        t.Errorf("%s: got the error %v, want %q", test.name, err, test.err)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err.Error())
+    } else if !reflect.DeepEqual(tags, test.want) {
+      t.Errorf("%s: got the tags %v, want %v", test.name, tags, test.want)
+    }
+  }
+}
+
+func TestGetClusterTags(t *testing.T) {
+  tags, err := getClusterTags(
+    map[string]string{"team": "infra", "env": "dev"},
+    StringMap{"env": "prod", "cost-center": "42"},
+    "72h", "me")
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := resourceTags{"team": "infra", "env": "prod", "cost-center": "42", "expiration": "72h", "owner": "me"}
+  if !reflect.DeepEqual(tags, want) {
+    t.Errorf("got the tags %v, want %v", tags, want)
+  }
+  if keys := tags.keys(); !reflect.DeepEqual(keys, []string{"expiration", "owner", "cost-center", "env", "team"}) {
+    t.Errorf("got the keys %q, the ones of cloud-cleaner should come first", keys)
+  }
+
+  if _, err := getClusterTags(map[string]string{"owner": "someone"}, StringMap{}, "1h", "me"); err == nil {
+    t.Errorf("the owner tag of the config should be refused")
+  }
+}
+
+func TestResourceTagsLines(t *testing.T) {
+  tags := resourceTags{"owner": "me", "expiration": "1h", "team": "infra"}
+  got := tags.lines("  ", "my-cluster")
+  want := []string{
+    `  tags = {`,
+    `    "Name"       = "my-cluster"`,
+    `    "expiration" = "1h"`,
+    `    "owner"      = "me"`,
+    `    "team"       = "infra"`,
+    `  }`,
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("got the lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+  }
+}
@@ -0,0 +1,356 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * How the resources around the cluster refer to its infrastructure, which is
+ * either created by module.dcos or already exists (with -vpc-id)
+ */
+type awsClusterRefs struct {
+  keyName              string
+  subnetIDs            string
+  securityGroups       []string
+  publicSecurityGroups []string
+  agentProfile         string
+
+  mastersIPs            string
+  clusterAddress        string
+  publicAgentsAddress   string
+  mastersInstances      string
+  publicAgentsInstances string
+  privateAgentsIPs      string
+  publicAgentsIPs       string
+  osUser                string
+  bootstrapIP           string
+}
+
+var moduleDcosRefs awsClusterRefs = awsClusterRefs{
+  keyName:              "module.dcos.infrastructure.aws_key_name",
+  subnetIDs:            "module.dcos.infrastructure.vpc.subnet_ids",
+  securityGroups:       []string{"module.dcos.infrastructure.security_groups.internal", "module.dcos.infrastructure.security_groups.admin"},
+  publicSecurityGroups: []string{"module.dcos.infrastructure.security_groups.internal", "module.dcos.infrastructure.security_groups.admin", "module.dcos.infrastructure.security_groups.public_agents"},
+  agentProfile:         "module.dcos.infrastructure.iam.agent_profile",
+  mastersIPs:           "module.dcos.masters-ips",
+  clusterAddress:       "module.dcos.masters-loadbalancer",
+  publicAgentsAddress:  "module.dcos.public-agents-loadbalancer",
+
+  mastersInstances:      "module.dcos.infrastructure.masters.instances",
+  publicAgentsInstances: "module.dcos.infrastructure.public_agents.instances",
+  privateAgentsIPs:      "module.dcos.infrastructure.private_agents.private_ips",
+  publicAgentsIPs:       "module.dcos.infrastructure.public_agents.public_ips",
+  osUser:                "module.dcos.infrastructure.masters.os_user",
+  bootstrapIP:           "module.dcos.infrastructure.bootstrap.public_ip",
+}
+
+var existingNetworkRefs awsClusterRefs = awsClusterRefs{
+  keyName:              "local.aws_key_name",
+  subnetIDs:            "data.aws_subnet.existing.*.id",
+  securityGroups:       []string{"data.aws_security_group.existing.*.id"},
+  publicSecurityGroups: []string{"data.aws_security_group.existing.*.id"},
+  agentProfile:         "module.dcos-iam.aws_agent_instance_profile",
+  mastersIPs:           "module.dcos-masters.private_ips",
+  clusterAddress:       "element(concat(module.dcos-masters.public_ips, module.dcos-masters.private_ips), 0)",
+  publicAgentsAddress:  "element(concat(module.dcos-public-agents.public_ips, module.dcos-public-agents.private_ips), 0)",
+
+  mastersInstances:      "module.dcos-masters.instances",
+  publicAgentsInstances: "module.dcos-public-agents.instances",
+  privateAgentsIPs:      "module.dcos-private-agents.private_ips",
+  publicAgentsIPs:       "module.dcos-public-agents.public_ips",
+  osUser:                "module.dcos-masters.os_user",
+  bootstrapIP:           "module.dcos-bootstrap.public_ip",
+}
+
+/**
+ * Formats a list of expressions as a terraform list of interpolations
+ */
+func formatInterpolationList(exprs []string) string {
+  var items []string
+  for _, expr := range exprs {
+    items = append(items, fmt.Sprintf(`"${%s}"`, expr))
+  }
+  return "[" + strings.Join(items, ", ") + "]"
+}
+
+/**
+ * The pre-provisioned network given with -vpc-id, -subnet-ids and
+ * -security-group-ids
+ */
+type existingNetwork struct {
+  vpcID            string
+  subnetIDs        []string
+  securityGroupIDs []string
+}
+
+func splitIDs(value string) []string {
+  var ids []string
+  for _, id := range strings.Split(value, ",") {
+    if id = strings.TrimSpace(id); id != "" {
+      ids = append(ids, id)
+    }
+  }
+  return ids
+}
+
+/**
+ * Validates the existing network flags, returning nil if no -vpc-id is given
+ */
+func parseExistingNetwork(vpcID string, subnetIDs string, securityGroupIDs string) (*existingNetwork, error) {
+  if vpcID == "" {
+    if subnetIDs != "" || securityGroupIDs != "" {
+      return nil, fmt.Errorf("-subnet-ids and -security-group-ids can only be used together with -vpc-id")
+    }
+    return nil, nil
+  }
+
+  net := &existingNetwork{vpcID, splitIDs(subnetIDs), splitIDs(securityGroupIDs)}
+  if !regexp.MustCompile(`^vpc-[0-9a-f]+$`).MatchString(net.vpcID) {
+    return nil, fmt.Errorf("Invalid VPC '%s', expected an ID like vpc-0123456789abcdef0", net.vpcID)
+  }
+  if len(net.subnetIDs) == 0 {
+    return nil, fmt.Errorf("Please specify the subnets of the VPC to use with -subnet-ids=")
+  }
+  if len(net.securityGroupIDs) == 0 {
+    return nil, fmt.Errorf("Please specify the security groups of the nodes with -security-group-ids=")
+  }
+  for _, id := range net.subnetIDs {
+    if !regexp.MustCompile(`^subnet-[0-9a-f]+$`).MatchString(id) {
+      return nil, fmt.Errorf("Invalid subnet '%s', expected an ID like subnet-0123456789abcdef0", id)
+    }
+  }
+  for _, id := range net.securityGroupIDs {
+    if !regexp.MustCompile(`^sg-[0-9a-f]+$`).MatchString(id) {
+      return nil, fmt.Errorf("Invalid security group '%s', expected an ID like sg-0123456789abcdef0", id)
+    }
+  }
+  return net, nil
+}
+
+// The node settings that are moved from module.dcos to the node modules
+var existingNetworkNodeFlags []string = []string{
+  "cluster_name", "ssh_public_key_file", "aws_key_name", "aws_ami", "dcos_instance_os", "tags",
+  "num_masters", "num_private_agents", "num_public_agents",
+}
+
+// The node groups, with the module that creates them and their defaults
+var existingNetworkNodeGroups [][3]string = [][3]string{
+  {"bootstrap", "bootstrap", ""},
+  {"masters", "masters", "1"},
+  {"private_agents", "private-agents", "1"},
+  {"public_agents", "public-agents", "1"},
+}
+
+func isExistingNetworkNodeFlag(name string) bool {
+  for _, n := range existingNetworkNodeFlags {
+    if n == name {
+      return true
+    }
+  }
+  for _, group := range existingNetworkNodeGroups {
+    for _, suffix := range []string{"_instance_type", "_aws_ami", "_root_volume_size", "_associate_public_ip_address"} {
+      if name == group[0]+suffix {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func getFlagValue(tfc *TerraformFileConfig, name string, defaultValue string) string {
+  if f := tfc.Flags.Lookup(name); f != nil && f.Value.String() != "" {
+    return f.Value.String()
+  }
+  return defaultValue
+}
+
+/**
+ * @brief      Turns module.dcos into a plain DC/OS installation on nodes that
+ *             are created in an existing network, and returns the contents
+ *             of the file that describes the network and the nodes
+ *
+ * The dcos-terraform/dcos/aws module always creates its own VPC, so the
+ * nodes are created with the individual modules instead, referring to the
+ * network through data sources.
+ */
+func useExistingNetwork(tfc *TerraformFileConfig, net *existingNetwork, clusterName string, instanceOS string, tags resourceTags, agentsKeyName string, extraPrivateIps []string, extraPublicIps []string) ([]byte, error) {
+  // Only the DC/OS settings are still given to module.dcos
+  var unsupported []string
+  tfc.Flags.Visit(func(f *flag.Flag) {
+    if tfc.IsIgnored(f.Name) || isExistingNetworkNodeFlag(f.Name) {
+      return
+    }
+    if !strings.HasPrefix(f.Name, "dcos_") && !strings.HasPrefix(f.Name, "ansible_") {
+      unsupported = append(unsupported, "-"+f.Name)
+    }
+  })
+  if len(unsupported) > 0 {
+    sort.Strings(unsupported)
+    return nil, fmt.Errorf("%s cannot be used together with -vpc-id", strings.Join(unsupported, ", "))
+  }
+  tfc.Flags.VisitAll(func(f *flag.Flag) {
+    if isExistingNetworkNodeFlag(f.Name) {
+      tfc.IgnoreFlags = append(tfc.IgnoreFlags, f.Name)
+    }
+  })
+
+  var bodyLines []string
+  for _, line := range tfc.BodyLines {
+    m := regexp.MustCompile(`^\s*([a-z0-9_]+)\s*=`).FindStringSubmatch(line)
+    if m == nil || (strings.HasPrefix(m[1], "dcos_") && !isExistingNetworkNodeFlag(m[1])) {
+      bodyLines = append(bodyLines, line)
+    }
+  }
+  tfc.BodyLines = bodyLines
+
+  keyName := getFlagValue(tfc, "aws_key_name", "")
+
+  lines := []string{
+    `// The existing network the cluster is deployed in`,
+    `data "aws_vpc" "existing" {`,
+    fmt.Sprintf(`  id = "%s"`, net.vpcID),
+    `}`,
+    ``,
+    `data "aws_subnet" "existing" {`,
+    fmt.Sprintf(`  count  = %d`, len(net.subnetIDs)),
+    fmt.Sprintf(`  id     = "${element(list("%s"), count.index)}"`, strings.Join(net.subnetIDs, `", "`)),
+    `  vpc_id = "${data.aws_vpc.existing.id}"`,
+    `}`,
+    ``,
+    `data "aws_security_group" "existing" {`,
+    fmt.Sprintf(`  count  = %d`, len(net.securityGroupIDs)),
+    fmt.Sprintf(`  id     = "${element(list("%s"), count.index)}"`, strings.Join(net.securityGroupIDs, `", "`)),
+    `  vpc_id = "${data.aws_vpc.existing.id}"`,
+    `}`,
+    ``,
+  }
+  if keyName == "" {
+    lines = append(lines,
+      `resource "aws_key_pair" "cluster" {`,
+      fmt.Sprintf(`  key_name   = "%s-deployer-key"`, clusterName),
+      fmt.Sprintf(`  public_key = "${file("%s")}"`, getFlagValue(tfc, "ssh_public_key_file", "cluster-key.pub")),
+      `}`,
+      ``,
+    )
+    keyName = "${aws_key_pair.cluster.key_name}"
+  }
+  lines = append(lines,
+    `locals {`,
+    fmt.Sprintf(`  aws_key_name = "%s"`, keyName),
+    `}`,
+    ``,
+  )
+  lines = append(lines,
+    `module "dcos-iam" {`,
+    GetModuleSource("dcos-terraform/iam/aws", "0.2.0"),
+    ``,
+    `  providers = {`,
+    `    aws = "aws"`,
+    `  }`,
+    ``,
+    fmt.Sprintf(`  cluster_name = "%s"`, clusterName),
+    `}`,
+  )
+
+  for _, group := range existingNetworkNodeGroups {
+    profile := existingNetworkRefs.agentProfile
+    securityGroups := existingNetworkRefs.securityGroups
+    keyName := agentsKeyName
+    switch group[0] {
+    case "bootstrap":
+      profile = ""
+      keyName = existingNetworkRefs.keyName
+    case "masters":
+      profile = "module.dcos-iam.aws_master_instance_profile"
+      keyName = existingNetworkRefs.keyName
+    case "public_agents":
+      securityGroups = existingNetworkRefs.publicSecurityGroups
+    }
+
+    lines = append(lines,
+      ``,
+      fmt.Sprintf(`module "dcos-%s" {`, group[1]),
+      GetModuleSource(fmt.Sprintf("dcos-terraform/%s/aws", group[1]), "0.2.0"),
+      ``,
+      `  providers = {`,
+      `    aws = "aws"`,
+      `  }`,
+      ``,
+      fmt.Sprintf(`  cluster_name = "%s"`, clusterName),
+    )
+    if group[2] != "" {
+      lines = append(lines, fmt.Sprintf(`  num_%s = %s`, group[0], tfc.UseVariable("num_"+group[0], getFlagValue(tfc, "num_"+group[0], group[2]))))
+    }
+    lines = append(lines,
+      ``,
+      fmt.Sprintf(`  aws_instance_type = %s`, tfc.UseVariable(group[0]+"_instance_type", FormatJSON(getFlagValue(tfc, group[0]+"_instance_type", "t2.medium")))),
+      fmt.Sprintf(`  dcos_instance_os  = %s`, tfc.UseVariable("dcos_instance_os", FormatJSON(instanceOS))),
+    )
+    if ami := getFlagValue(tfc, group[0]+"_aws_ami", getFlagValue(tfc, "aws_ami", "")); ami != "" {
+      lines = append(lines, fmt.Sprintf(`  aws_ami           = "%s"`, ami))
+    }
+    if size := getFlagValue(tfc, group[0]+"_root_volume_size", ""); size != "" {
+      lines = append(lines, fmt.Sprintf(`  aws_root_volume_size = "%s"`, size))
+    }
+    lines = append(lines,
+      ``,
+      fmt.Sprintf(`  aws_key_name                    = "${%s}"`, keyName),
+      fmt.Sprintf(`  aws_subnet_ids                  = ["${%s}"]`, existingNetworkRefs.subnetIDs),
+      fmt.Sprintf(`  aws_security_group_ids          = %s`, formatInterpolationList(securityGroups)),
+      fmt.Sprintf(`  aws_associate_public_ip_address = %s`, getFlagValue(tfc, group[0]+"_associate_public_ip_address", "true")),
+    )
+    if profile != "" {
+      lines = append(lines, fmt.Sprintf(`  aws_iam_instance_profile        = "${%s}"`, profile))
+    }
+    lines = append(lines, ``)
+    lines = append(lines, tags.lines("  ", "")...)
+    lines = append(lines, `}`)
+  }
+
+  // The additional agents are given by their private IPs, and expose their
+  // public ones with the same name
+  privateIps := append([]string{"module.dcos-private-agents.private_ips"}, extraPrivateIps...)
+  publicIps := append([]string{"module.dcos-public-agents.private_ips"}, extraPublicIps...)
+  toPublic := func(exprs []string) []string {
+    var ret []string
+    for _, expr := range exprs {
+      ret = append(ret, strings.Replace(expr, ".private_ip", ".public_ip", 1))
+    }
+    return ret
+  }
+  tfc.PreLines = []string{
+    `module "dcos" {`,
+    GetModuleSource("dcos-terraform/dcos-install-remote-exec-ansible/null", "0.2.0"),
+    ``,
+    `  # The nodes in the existing network, see cluster-aws-network.tf`,
+    `  bootstrap_ip              = "${module.dcos-bootstrap.public_ip}"`,
+    `  bootstrap_private_ip      = "${module.dcos-bootstrap.private_ip}"`,
+    `  bootstrap_os_user         = "${module.dcos-bootstrap.os_user}"`,
+    `  master_ips                = ["${module.dcos-masters.public_ips}"]`,
+    `  master_private_ips        = ["${module.dcos-masters.private_ips}"]`,
+    `  masters_os_user           = "${module.dcos-masters.os_user}"`,
+    fmt.Sprintf(`  private_agent_ips         = ["${%s}"]`, concatExpr(toPublic(privateIps))),
+    fmt.Sprintf(`  private_agent_private_ips = ["${%s}"]`, concatExpr(privateIps)),
+    `  private_agents_os_user    = "${module.dcos-private-agents.os_user}"`,
+    fmt.Sprintf(`  public_agent_ips          = ["${%s}"]`, concatExpr(toPublic(publicIps))),
+    fmt.Sprintf(`  public_agent_private_ips  = ["${%s}"]`, concatExpr(publicIps)),
+    `  public_agents_os_user     = "${module.dcos-public-agents.os_user}"`,
+    ``,
+  }
+  tfc.PostLines = []string{`}`}
+
+  return []byte(strings.Join(lines, "\n")), nil
+}
+
+func concatExpr(exprs []string) string {
+  if len(exprs) == 1 {
+    return exprs[0]
+  }
+  return fmt.Sprintf("concat(%s)", strings.Join(exprs, ", "))
+}
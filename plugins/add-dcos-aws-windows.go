@@ -0,0 +1,92 @@
+package plugins
+
+import (
+  "fmt"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// The ansible bundle that knows how to install DC/OS on Windows over WinRM
+var windowsAnsibleContainer string = "mesosphere/dcos-ansible-bundle:windows-beta-support"
+
+/**
+ * Checks that the DC/OS version can run Windows agents
+ */
+func checkWindowsAgentsSupport(dcosVersion string) error {
+  ver, err := semver.NewVersion(dcosVersion)
+  if err != nil {
+    return fmt.Errorf("Could not check if DC/OS %s supports Windows agents: %s", dcosVersion, err.Error())
+  }
+  if ver.LessThan(semver.MustParse("2.1.0")) {
+    return fmt.Errorf("Windows agents need DC/OS 2.1 or later, please use -dcos_version")
+  }
+  return nil
+}
+
+/**
+ * Returns the contents of the file that describes the Windows agents, that
+ * are installed by the cluster over WinRM
+ */
+func generateWindowsAgents(count int, instanceType string, ami string, clusterName string, tags resourceTags, refs awsClusterRefs) []byte {
+  lines := []string{
+    `// Windows agents, installed by the cluster as additional private agents`,
+    `module "dcos-windows-agents" {`,
+    GetModuleSource("dcos-terraform/windows-instance/aws", "0.2.0"),
+    ``,
+    `  providers = {`,
+    `    aws = "aws"`,
+    `  }`,
+    ``,
+    fmt.Sprintf(`  cluster_name = "%s"`, clusterName),
+    `  name_prefix  = "windows"`,
+    ``,
+    fmt.Sprintf(`  num               = %d`, count),
+    fmt.Sprintf(`  aws_instance_type = "%s"`, instanceType),
+  }
+  if ami != "" {
+    lines = append(lines, fmt.Sprintf(`  aws_ami           = "%s"`, ami))
+  }
+  lines = append(lines,
+    ``,
+    fmt.Sprintf(`  aws_key_name             = "${%s}"`, refs.keyName),
+    fmt.Sprintf(`  aws_subnet_ids           = ["${%s}"]`, refs.subnetIDs),
+    fmt.Sprintf(`  aws_security_group_ids   = %s`, formatInterpolationList(refs.securityGroups)),
+    fmt.Sprintf(`  aws_iam_instance_profile = "${%s}"`, refs.agentProfile),
+    ``,
+  )
+  lines = append(lines, tags.lines("  ", "")...)
+  lines = append(lines,
+    `}`,
+    ``,
+    `output "windows-agents-ips" {`,
+    `  value = "${module.dcos-windows-agents.private_ips}"`,
+    `}`,
+  )
+
+  return []byte(strings.Join(lines, "\n"))
+}
+
+/**
+ * Returns the lines of module.dcos that install the Windows agents
+ */
+func getWindowsAgentsBodyLines(tfc *TerraformFileConfig) ([]string, error) {
+  for _, name := range []string{"additional_windows_private_agent_ips", "additional_windows_private_agent_passwords", "additional_windows_private_agent_os_user"} {
+    if getFlagValue(tfc, name, "") != "" {
+      return nil, fmt.Errorf("-%s cannot be used together with -num-windows-agents", name)
+    }
+  }
+
+  lines := []string{
+    ``,
+    `  # The Windows agents, see agents-windows.tf`,
+    `  additional_windows_private_agent_ips       = ["${module.dcos-windows-agents.private_ips}"]`,
+    `  additional_windows_private_agent_passwords = ["${module.dcos-windows-agents.windows_passwords}"]`,
+    `  additional_windows_private_agent_os_user   = "${module.dcos-windows-agents.os_user}"`,
+  }
+  if getFlagValue(tfc, "ansible_bundled_container", "") == "" {
+    lines = append(lines, fmt.Sprintf(`  ansible_bundled_container                  = "%s"`, windowsAnsibleContainer))
+  }
+  return lines, nil
+}
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+/**
+ * A group of nodes that the modules spread across the availability zones,
+ * placing the node N in the subnet (and so the zone) N modulo their number
+ */
+type zonePlacementGroup struct {
+  name  string
+  count int
+}
+
+/**
+ * Resolves -availability-zones, that is either a comma-separated list of
+ * zones, a number of zones to pick or "auto" to let the module use all of
+ * them. The available zones of the region are returned, with the list to
+ * give to the module (empty with "auto").
+ */
+func resolveAvailabilityZones(value string, region string) ([]string, []string, error) {
+  available, err := ListAWSAvailabilityZones(region)
+  if err != nil {
+    if value == "auto" || regexp.MustCompile(`^[0-9]+$`).MatchString(value) {
+      return nil, nil, err
+    }
+    PrintWarning("Could not check the availability zones: %s", err.Error())
+    zones := strings.Split(value, ",")
+    return zones, zones, nil
+  }
+
+  if value == "auto" {
+    return available, nil, nil
+  }
+  if n, err := strconv.Atoi(value); err == nil {
+    if n < 1 {
+      return nil, nil, fmt.Errorf("Invalid number of availability zones: %d", n)
+    }
+    if n > len(available) {
+      return nil, nil, fmt.Errorf("The region %s only has %d available zones (%s), cannot use %d", region, len(available), strings.Join(available, ", "), n)
+    }
+    return available[:n], available[:n], nil
+  }
+
+  zones := strings.Split(value, ",")
+  for i, zone := range zones {
+    zones[i] = strings.TrimSpace(zone)
+    found := false
+    for _, other := range available {
+      found = found || other == zones[i]
+    }
+    if !found {
+      return nil, nil, fmt.Errorf("The availability zone %s is not available in %s, the available ones are: %s", zones[i], region, strings.Join(available, ", "))
+    }
+  }
+  return zones, zones, nil
+}
+
+/**
+ * Prints where the nodes of every group are going to be placed, warning if
+ * the masters cannot survive the loss of a zone
+ */
+func printZonesTopology(zones []string, region string, groups []zonePlacementGroup) {
+  if len(zones) == 0 {
+    return
+  }
+
+  placed := make([][]string, len(zones))
+  for _, group := range groups {
+    counts := make([]int, len(zones))
+    for i := 0; i < group.count; i++ {
+      counts[i%len(zones)]++
+    }
+    for i, count := range counts {
+      if count == 0 {
+        continue
+      }
+      name := group.name
+      if count > 1 {
+        name += "s"
+      }
+      placed[i] = append(placed[i], fmt.Sprintf("%d %s", count, name))
+    }
+  }
+
+  PrintInfo("The nodes are spread across %d availability zones of %s:", len(zones), region)
+  var lines []interface{}
+  for i, zone := range zones {
+    nodes := strings.Join(placed[i], ", ")
+    if nodes == "" {
+      nodes = "-"
+    }
+    lines = append(lines, fmt.Sprintf("  %-16s %s", zone, nodes))
+  }
+  PrintMessage(lines)
+
+  for _, group := range groups {
+    if group.name == "master" && group.count >= 3 && len(zones) < 3 {
+      PrintWarning("With %d availability zones, losing one of them can lose the quorum of the masters", len(zones))
+    }
+  }
+}
@@ -4,8 +4,11 @@ import (
   "flag"
   "fmt"
   "os"
-  "os/exec"
   "os/user"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
 
   . "github.com/logrusorgru/aurora"
   . "github.com/mesosphere-incubator/terraform-wheels/utils"
@@ -15,18 +18,29 @@ import (
 )
 
 type PluginDcosAws struct {
+  pluginLogger
   showInstructions bool
   createdFile      string
+  spotFile         string
 }
 
 func CreatePluginDcosAws() *PluginDcosAws {
-  return &PluginDcosAws{false, ""}
+  return &PluginDcosAws{pluginLogger{}, false, "", ""}
 }
 
 func (p *PluginDcosAws) GetName() string {
   return "dcos-aws"
 }
 
+// The ssh-agent is started before the credential helper takes over the terminal
+func (p *PluginDcosAws) Requires() []string {
+  return []string{"ssh-agent"}
+}
+
+func (p *PluginDcosAws) Priority() int {
+  return 0
+}
+
 func (p *PluginDcosAws) IsUsed(project *ProjectSandbox) (bool, error) {
   // Check if we are using the AWS provider
   mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
@@ -34,36 +48,21 @@ func (p *PluginDcosAws) IsUsed(project *ProjectSandbox) (bool, error) {
 }
 
 func (p *PluginDcosAws) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
-  if !IsAWSCredsOK() {
-    // Check if we have maws and a profile already set. In which case we are
-    // going to transparently do a maws credential refresh
-    _, err := exec.LookPath("maws")
-    if err == nil {
-      profile, ok := os.LookupEnv("AWS_PROFILE")
-      if ok {
-        PrintInfo("Your AWS credentials have expired, going to refresh them using %s", Bold("maws"))
-
-        _, _, _, err := ExecuteAndCollect([]string{}, "maws", "login", profile)
-        if err != nil {
-          FatalError(fmt.Errorf("Failed to login with `maws`, please retry manually"))
-        }
-
-        // We should be OK by now
-        if !IsAWSCredsOK() {
-          FatalError(fmt.Errorf("Failed to refresh credentials with `maws`, please retry manually"))
-        }
-
-        // Everything looks good, don't continue with the prompt
-        return nil
-      }
+  // Refresh the credentials with maws (or the configured helper) if they
+  // expired or are about to. The other hooks keep running, so this fails
+  // like them instead of exiting.
+  if err := EnsureAWSCredentials(); err != nil {
+    if !initRun {
+      return err
     }
+    p.log.Warn(err.Error())
+  }
 
-    err = fmt.Errorf("Could not find (still valid) AWS credentials in your enviroment. Use `maws login` and make sure to export the AWS_PROFILE")
-    if initRun {
-      PrintWarning(err.Error())
-    } else {
-      FatalError(err)
-    }
+  // The stopped instances are not started by terraform
+  if paused, err := project.GetPausedCluster(); err != nil {
+    return err
+  } else if paused != nil && !initRun {
+    p.log.Warn("The cluster was paused on %s, resume it first with `%s wheels-resume`", paused.PausedAt.Local().Format(time.RFC1123), os.Args[0])
   }
 
   return nil
@@ -88,13 +87,59 @@ func (p *PluginDcosAws) AfterRun(project *ProjectSandbox, tf *TerraformWrapper,
       fmt.Sprintf("  3. %s apply plan.out      # To create the deployment\n", os.Args[0]),
       "",
     })
+    if p.spotFile != "" {
+      PrintMessage([]interface{}{
+        Bold("About the spot agents"),
+        "",
+        fmt.Sprintf("The private agents are launched as spot instances, described in %s.\n", p.spotFile),
+        "Keep in mind that:",
+        "",
+        "  - AWS can reclaim them at any time, with a 2 minute notice. The tasks",
+        "    running on them are lost and DC/OS re-schedules them elsewhere.",
+        "  - Reclaimed agents are NOT replaced automatically, run `apply` again",
+        "    to request new ones. The apply fails if there is no spot capacity",
+        "    at your maximum price.",
+        "  - Do not run stateful services (eg. with local persistent volumes) on",
+        "    them, since their data is lost with the instance.",
+        "",
+      })
+    }
+  }
+
+  // If configured, block until the cluster we just applied is reachable
+  gate := project.GetConfig().ReadinessGate
+  if gate.Enabled && tfErr == nil && tf.GetLastCommand() == "apply" {
+    if IsFastMode() {
+      p.log.Warn("Fast mode: not waiting for the cluster to become ready")
+      return nil
+    }
+    return p.waitForCluster(tf, gate)
   }
+
   return nil
 }
 
+func (p *PluginDcosAws) waitForCluster(tf *TerraformWrapper, gate ReadinessGateConfig) error {
+  address, masters, err := tf.GetClusterOutputs()
+  if err != nil {
+    return fmt.Errorf("readiness gate needs the cluster outputs: %s", err.Error())
+  }
+
+  return WaitForClusterReady(
+    address,
+    masters,
+    ParseConfigDuration(gate.Timeout, 30*time.Minute),
+    ParseConfigDuration(gate.Interval, 15*time.Second),
+  )
+}
+
 func (p *PluginDcosAws) GetCommands() []PluginCommand {
   return []PluginCommand{
     &PluginDcosAwsCmdAddCluster{p},
+    &PluginDcosAwsCmdAddRemoteAgents{p},
+    &PluginDcosAwsCmdReap{parent: p},
+    &PluginDcosAwsCmdPause{p},
+    &PluginDcosAwsCmdResume{parent: p},
   }
 }
 
@@ -168,7 +213,9 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   tfc.Flags.String("dcos_customer_key", "", "[Enterprise DC/OS] sets the customer key (optional)")
   tfc.Flags.String("dcos_dns_bind_ip_blacklist", "", "A list of IP addresses that DC/OS DNS resolvers cannot bind to. (optional)")
   tfc.Flags.String("num_public_agents", "", "Specify the amount of public agents. These agents will host marathon-lb and edgelb")
-  tfc.Flags.String("tags", "", "Add custom tags to all resources (use key=value format)")
+  customTags := make(StringMap)
+  tfc.Flags.Var(customTags, "tags", "Add custom tags to all resources (use key=value format, same as -tag)")
+  tfc.Flags.Var(customTags, "tag", "Add a custom tag to all the resources, as key=value (use multiple times to add multiple tags)")
   tfc.Flags.String("bootstrap_root_volume_size", "", "[BOOTSTRAP] Root volume size in GB")
   tfc.Flags.String("dcos_adminrouter_tls_1_1_enabled", "", "Indicates whether to enable TLSv1.1 support in Admin Router. (optional)")
   tfc.Flags.String("dcos_ca_certificate_key_path", "", "[Enterprise DC/OS] Path (relative to the $DCOS_INSTALL_DIR) to a file containing a single X.509 certificate private key in the OpenSSL PEM format. (optional)")
@@ -181,17 +228,17 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   tfc.Flags.String("dcos_enable_docker_gc", "", "Indicates whether to run the docker-gc script, a simple Docker container and image garbage collection script, once every hour to clean up stray Docker containers. (optional)")
   tfc.Flags.String("ssh_public_key_file", "", "Path to SSH public key. This is mandatory but can be set to an empty string if you want to use ssh_public_key with the key as string.")
   tfc.Flags.String("masters_aws_ami", "", "[MASTERS] AMI to be used")
-  tfc.Flags.String("public_agents_access_ips", "", "List of ips allowed access to public agents. admin_ips are joined to this list (use multiple times to add multiple values)")
+  tfc.ListFlag("public_agents_access_ips", "List of ips allowed access to public agents. admin_ips are joined to this list (use multiple times to add multiple values)")
   tfc.Flags.String("public_agents_acm_cert_arn", "", "ACM certifacte to be used for the public agents load balancer")
   tfc.Flags.String("dcos_master_list", "", "statically set your master nodes (not recommended but required with exhibitor_storage_backend set to static. Use aws_s3 or azure instead, that way you can replace masters in the cloud.)")
   tfc.Flags.String("dcos_calico_veth_mtu", "", "The MTU to set on the veth pair devices. (optional)")
   tfc.Flags.String("private_agents_user_data", "", "[PRIVATE AGENTS] User data to be used on these instances (cloud-init)")
-  tfc.Flags.String("accepted_internal_networks", "", "Subnet ranges for all internal networks (use multiple times to add multiple values)")
+  tfc.ListFlag("accepted_internal_networks", "Subnet ranges for all internal networks (use multiple times to add multiple values)")
   tfc.Flags.String("dcos_master_external_loadbalancer", "", "Allows DC/OS to configure certs around the External Load Balancer name. If not used SSL verfication issues will arrise. EE only. (recommended)")
   tfc.Flags.String("dcos_adminrouter_tls_cipher_suite", "", "[Enterprise DC/OS] Indicates whether to allow web browsers to send the DC/OS authentication cookie through a non-HTTPS connection. (optional)")
   tfc.Flags.String("dcos_auth_cookie_secure_flag", "", "[Enterprise DC/OS] allow web browsers to send the DC/OS authentication cookie through a non-HTTPS connection. (optional)")
   tfc.Flags.String("dcos_calico_vxlan_vni", "", "The virtual network ID used for calico VXLAN. (optional)")
-  tfc.Flags.String("masters_instance_type", "", "[MASTERS] Instance type")
+  tfc.Flags.Var(CreateAWSInstanceTypeFlag(""), "masters_instance_type", "[MASTERS] Instance type")
   tfc.Flags.String("private_agents_os", "", "[PRIVATE AGENTS] Operating system to use. Instead of using your own AMI you could use a provided OS.")
   tfc.Flags.String("public_agents_root_volume_size", "", "[PUBLIC AGENTS] Root volume size")
   tfc.Flags.String("dcos_previous_version", "", "DC/OS 1.9+ requires users to set this value to ensure users know the version. Terraform helps populate this value, but users can override it here. (recommended)")
@@ -220,7 +267,7 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   tfc.Flags.String("dcos_dns_search", "", "A space-separated list of domains that are tried when an unqualified domain is entered. (optional)")
   tfc.Flags.String("dcos_docker_remove_delay", "", "The amount of time to wait before removing stale Docker images stored on the agent nodes and the Docker image generated by the installer. (optional)")
   tfc.Flags.String("dcos_ip_detect_public_contents", "", "Allows DC/OS to be aware of your publicly routeable address for ease of use (recommended)")
-  tfc.Flags.String("bootstrap_instance_type", "", "[BOOTSTRAP] Instance type")
+  tfc.Flags.Var(CreateAWSInstanceTypeFlag(""), "bootstrap_instance_type", "[BOOTSTRAP] Instance type")
   tfc.Flags.String("dcos_exhibitor_address", "", "The address of the load balancer in front of the masters (recommended)")
   tfc.Flags.String("num_of_public_agents", "", "Specify the amount of public agents. These agents will host marathon-lb and edgelb")
   tfc.Flags.String("dcos_rexray_config_method", "", "The REX-Ray configuration method for enabling external persistent volumes in Marathon. (optional)")
@@ -242,7 +289,7 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   tfc.Flags.String("dcos_enable_mesos_input_plugin", "", "Indicates whether to enable Telegraf's Mesos input plugin to collect Mesos metrics from Mesos masters and agents. Options: `true` or `false` (optional)")
   tfc.Flags.String("dcos_versions_service_url", "", "DC/OS Versions Service allows to identify DC/OS versions")
   tfc.Flags.String("dcos_calico_ipinip_mtu", "", "The MTU to set on the Calico IPIP tunnel device. (optional)")
-  tfc.Flags.String("admin_ips", "", "List of CIDR admin IPs (use multiple times to add multiple values)")
+  tfc.ListFlag("admin_ips", "List of CIDR admin IPs (use multiple times to add multiple values)")
   tfc.Flags.String("private_agents_root_volume_type", "", "[PRIVATE AGENTS] Root volume type")
   tfc.Flags.String("dcos_zk_agent_credentials", "", "[Enterprise DC/OS] set the ZooKeeper agent credentials (recommended)")
   tfc.Flags.String("dcos_http_proxy", "", "http proxy (optional)")
@@ -282,15 +329,15 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   tfc.Flags.String("dcos_aws_access_key_id", "", "AWS key ID for exhibitor storage (optional but required with dcos_exhibitor_address)")
   tfc.Flags.String("dcos_adminrouter_tls_1_2_enabled", "", "Indicates whether to enable TLSv1.2 support in Admin Router. (optional)")
   tfc.Flags.String("dcos_public_agent_list", "", "statically set your public agents (not recommended)")
-  tfc.Flags.String("availability_zones", "", "List of availability_zones to be used as the same format that are required by the platform/cloud providers. i.e `['RegionZone']` (use multiple times to add multiple values)")
+  tfc.ListFlag("availability_zones", "List of availability_zones to be used as the same format that are required by the platform/cloud providers. i.e `['RegionZone']` (use multiple times to add multiple values)")
   tfc.Flags.String("dcos_instance_os", "", "Operating system to use. Instead of using your own AMI you could use a provided OS.")
-  tfc.Flags.String("private_agents_instance_type", "", "[PRIVATE AGENTS] Instance type")
+  tfc.Flags.Var(CreateAWSInstanceTypeFlag(""), "private_agents_instance_type", "[PRIVATE AGENTS] Instance type")
   tfc.Flags.String("dcos_skip_checks", "", "Upgrade option: Used to skip all dcos checks that may block an upgrade if any DC/OS component is unhealthly. (optional) applicable: 1.10+")
   tfc.Flags.String("dcos_aws_template_storage_bucket_path", "", "AWS CloudFormation bucket path (optional)")
   tfc.Flags.String("dcos_exhibitor_azure_account_key", "", "the azure account key for exhibitor storage (optional but required with dcos_exhibitor_address)")
   tfc.Flags.String("ssh_public_key", "", "SSH public key in authorized keys format (e.g. 'ssh-rsa ..') to be used with the instances. Make sure you added this key to your ssh-agent.")
   tfc.Flags.String("public_agents_aws_ami", "", "[PUBLIC AGENTS] AMI to be used")
-  tfc.Flags.String("public_agents_instance_type", "", "[PUBLIC AGENTS] Instance type")
+  tfc.Flags.Var(CreateAWSInstanceTypeFlag(""), "public_agents_instance_type", "[PUBLIC AGENTS] Instance type")
   tfc.Flags.String("subnet_range", "", "Private IP space to be used in CIDR format")
   tfc.Flags.String("dcos_exhibitor_azure_prefix", "", "the azure account name for exhibitor storage (optional but required with dcos_exhibitor_address)")
   tfc.Flags.String("dcos_mesos_dns_set_truncate_bit", "", "Indicates whether to set the truncate bit if the response is too large to fit in a single packet. (optional)")
@@ -300,18 +347,74 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   fPassword := tfc.Flags.String("dcos_superuser_password", "", "The plain-text password to encode")
   fOwner := tfc.Flags.String("owner", currUserStr, "The user-name that owns this cluster")
   fExpire := tfc.Flags.String("expiration", "1h", "How long to keep the cluster running before cloud-cleaner tears it down")
+  fExpiresIn := tfc.Flags.String("expires-in", "", "How long to keep the cluster running before it's reaped (eg. 72h, overrides -expiration)")
+  fSpotAgents := tfc.Flags.Bool("spot-agents", false, "Launch the private agents as (cheaper, but interruptible) spot instances")
+  fSpotMaxPrice := tfc.Flags.String("spot-max-price", "", "The maximum hourly price to pay for the spot agents (defaults to the on-demand price)")
+  fOS := tfc.Flags.String("os", "", "The operating system of the nodes: centos, rhel, coreos, flatcar or a specific release (eg. centos_7.6)")
+  fAMI := tfc.Flags.String("ami", "", "A custom AMI to use for all the nodes (must run the OS given with -os)")
+  fDcosVersion := tfc.Flags.String("dcos-version", "", "Same as -dcos_version, also accepting the aliases of wheels-dcos-versions: latest-stable or the latest release of a minor version (eg. 2.1-latest)")
+  fVpcID := tfc.Flags.String("vpc-id", "", "Deploy into this existing VPC, instead of creating one")
+  var subnetIDs, securityGroupIDs, mastersTargetGroups, publicAgentsTargetGroups StringSlice
+  tfc.Flags.Var(&subnetIDs, "subnet-ids", "The comma-separated subnets of the existing VPC to place the nodes in (can be repeated)")
+  tfc.Flags.Var(&securityGroupIDs, "security-group-ids", "The comma-separated security groups of the existing VPC to attach to the nodes (can be repeated)")
+  tfc.Flags.Var(&mastersTargetGroups, "masters-target-groups", "Register the masters with these comma-separated existing target groups (ARNs), instead of creating a load balancer (can be repeated)")
+  tfc.Flags.Var(&publicAgentsTargetGroups, "public-agents-target-groups", "Register the public agents with these comma-separated existing target groups (ARNs), instead of creating a load balancer (can be repeated)")
+  fAgentsSSHKey := tfc.Flags.String("agents-ssh-key", "", "The SSH public key file of the agents, if they should not use the key of the cluster (ssh_public_key_file)")
+  fWindowsAgents := tfc.Flags.Int("num-windows-agents", 0, "Add this many Windows private agents (needs DC/OS 2.1 or later)")
+  fWindowsType := CreateAWSInstanceTypeFlag("m5.xlarge")
+  tfc.Flags.Var(fWindowsType, "windows-agents-instance-type", "The instance type of the Windows agents")
+  fWindowsAMI := tfc.Flags.String("windows-agents-ami", "", "A custom Windows Server AMI for the Windows agents")
+  fGpuAgents := tfc.Flags.Int("num-gpu-agents", 0, "Add this many GPU private agents, in the gpu pool")
+  fGpuType := CreateAWSInstanceTypeFlag("p3.2xlarge")
+  tfc.Flags.Var(fGpuType, "gpu-instance-type", "The instance type of the GPU agents")
+  fGpuAMI := tfc.Flags.String("gpu-agents-ami", "", "A custom AMI for the GPU agents (must run the NVIDIA driver installer of gpu-agents.sh)")
+  var hardening clusterHardening
+  tfc.Flags.Var(&hardening.adminIPs, "admin-ips", "Only allow these comma-separated CIDRs to administer the cluster, instead of the public IP of this machine")
+  tfc.Flags.Var(&hardening.adminIPs, "admin-ip", "Same as -admin-ips, for one CIDR (use multiple times to add multiple CIDRs)")
+  tfc.Flags.BoolVar(&hardening.restrictPublicAgents, "restrict-public-agents", false, "Do not expose the load balancer of the public agents to the world, only to the admin IPs")
+  tfc.Flags.BoolVar(&hardening.strictSecurity, "strict-security", false, "[Enterprise DC/OS] Run DC/OS in the strict security mode")
+  tfc.Flags.BoolVar(&hardening.encryptVolumes, "encrypt-volumes", false, "Encrypt the EBS volumes of the nodes, by enabling the default EBS encryption of the region (for the whole AWS account)")
+  fReset := tfc.Flags.Bool("reset", false, "Do not keep the options of the existing cluster, start again from the defaults")
+  fZones := tfc.Flags.String("availability-zones", "", "Spread the nodes across these comma-separated availability zones, this many zones (eg. 3) or all of them (auto)")
+  var pools agentPoolList
+  tfc.Flags.Var(&pools, "agent-pool", "Add a pool of agents, eg. name=gpu,count=2,type=p3.2xlarge[,public=true][,ssh-key=gpu-key.pub] (use multiple times to add multiple pools)")
 
-  tfc.ListFlags = []string{"public_agents_access_ips", "accepted_internal_networks", "admin_ips", "availability_zones"}
-  tfc.MapFlags = []string{"tags"}
-  tfc.IgnoreFlags = []string{"owner", "expiration", "dcos_superuser_password"}
+  tfc.Variables = []string{
+    "num_masters", "num_private_agents", "num_public_agents",
+    "bootstrap_instance_type", "masters_instance_type", "private_agents_instance_type", "public_agents_instance_type",
+    "dcos_version", "dcos_variant", "dcos_instance_os",
+  }
+  tfc.SensitiveVariables = []string{
+    "dcos_superuser_password_hash", "dcos_license_key_contents", "dcos_customer_key",
+    "dcos_aws_secret_access_key", "dcos_aws_template_storage_secret_access_key", "dcos_exhibitor_azure_account_key",
+  }
+  tfc.IgnoreFlags = []string{"owner", "expiration", "expires-in", "spot-agents", "spot-max-price", "agents-ssh-key", "agent-pool", "os", "ami", "dcos-version", "vpc-id", "subnet-ids", "security-group-ids", "masters-target-groups", "public-agents-target-groups", "dcos_superuser_password", "num-windows-agents", "windows-agents-instance-type", "windows-agents-ami", "num-gpu-agents", "gpu-instance-type", "gpu-agents-ami", "availability-zones", "admin-ips", "admin-ip", "restrict-public-agents", "strict-security", "encrypt-volumes", "tags", "tag", "reset"}
 
   help := tfc.Flags.Bool("help", false, "Show this help message")
   tfc.Flags.BoolVar(help, "h", false, "Show this help message")
-  err := tfc.Flags.Parse(args)
+  err := ParseCommandFlags(tfc.Flags, args)
   if err != nil {
     FatalError(err)
   }
 
+  // An existing cluster is updated, only changing the options that are given
+  readBack := &clusterReadBack{}
+  if project.HasFile(fileName) && !*fReset && !*help {
+    readBack, err = readBackCluster(project, &tfc, fileName, customTags)
+    if err != nil {
+      return err
+    }
+    if len(readBack.options) > 0 {
+      PrintInfo("Updating the existing %s, only changing the given options (use -reset to start from the defaults)", fileName)
+    }
+  }
+  RegisterSecret(*fPassword)
+  tfc.Flags.Visit(func(f *flag.Flag) {
+    if tfc.IsVariable(f.Name) && IsSecretVariable(f.Name) {
+      RegisterSecret(f.Value.String())
+    }
+  })
+
   if *help {
     PrintHelp(p.GetName(), "", []interface{}{
       fmt.Sprintf("This command will generate a '%s' file in the project directory\n", fileName),
@@ -321,6 +424,112 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
     return nil
   }
 
+  // The same TTL is used by cloud-cleaner (through the tags) and wheels-reap
+  if *fExpiresIn != "" {
+    *fExpire = *fExpiresIn
+  }
+  if _, err := time.ParseDuration(*fExpire); err != nil {
+    return fmt.Errorf("Invalid expiration '%s', please use a duration like 72h", *fExpire)
+  }
+  tags, err := getClusterTags(project.GetConfig().Tags, customTags, *fExpire, *fOwner)
+  if err != nil {
+    return err
+  }
+
+  clusterName := "my-dcos-demo"
+  if f := tfc.Flags.Lookup("cluster_name"); f != nil && f.Value.String() != "" {
+    clusterName = f.Value.String()
+  }
+  dcosVersion, err := p.resolveDCOSVersion(project, &tfc, *fDcosVersion)
+  if err != nil {
+    return err
+  }
+
+  instanceOS, err := p.resolveInstanceOS(project, &tfc, *fOS, *fAMI, dcosVersion)
+  if err != nil {
+    return err
+  }
+
+  // Before generating anything, check that DC/OS can be installed like this
+  spec := CreateDCOSClusterSpec("module.dcos", project.GetAWSRegion(), func(name string) string {
+    return getFlagValue(&tfc, name, "")
+  })
+  spec.Version = dcosVersion
+  for _, ami := range []string{*fWindowsAMI, *fGpuAMI} {
+    if ami != "" {
+      spec.AMIs = append(spec.AMIs, ami)
+    }
+  }
+  if err := project.CheckDCOSCompatibility(spec); err != nil {
+    return err
+  }
+
+  network, err := parseExistingNetwork(*fVpcID, subnetIDs.String(), securityGroupIDs.String())
+  if err != nil {
+    return err
+  }
+  refs := moduleDcosRefs
+  if network != nil {
+    refs = existingNetworkRefs
+  }
+  lbs, err := parseExistingLoadBalancers(mastersTargetGroups.String(), publicAgentsTargetGroups.String())
+  if err != nil {
+    return err
+  }
+
+  // The nodes are placed round-robin in the subnets of the zones
+  var zones, moduleZones []string
+  region := project.GetAWSRegion()
+  if *fZones != "" {
+    if getFlagValue(&tfc, "availability_zones", "") != "" {
+      return fmt.Errorf("Please use either -availability-zones or -availability_zones, not both")
+    }
+    if network != nil {
+      return fmt.Errorf("-availability-zones cannot be used together with -vpc-id, the zones are the ones of the subnets")
+    }
+    zones, moduleZones, err = resolveAvailabilityZones(*fZones, region)
+    if err != nil {
+      return err
+    }
+  }
+  numPrivateAgents, _ := strconv.Atoi(getFlagValue(&tfc, "num_private_agents", "1"))
+
+  // The GPU agents are a pool, with the driver and the GPU isolation
+  var gpuAmiLines []byte
+  if *fGpuAgents < 0 {
+    return fmt.Errorf("Invalid number of GPU agents: %d", *fGpuAgents)
+  } else if *fGpuAgents > 0 {
+    for _, pool := range pools {
+      if pool.name == "gpu" {
+        return fmt.Errorf("-num-gpu-agents cannot be used together with an agent pool named gpu")
+      }
+    }
+    gpuPool, amiLines, err := getGpuAgentPool(*fGpuAgents, fGpuType.Value, *fGpuAMI, instanceOS)
+    if err != nil {
+      return err
+    }
+    pools = append(pools, gpuPool)
+    gpuAmiLines = amiLines
+    for _, name := range []string{"dcos_enable_gpu_isolation", "dcos_gpus_are_scarce"} {
+      if getFlagValue(&tfc, name, "") == "" {
+        tfc.Flags.Set(name, "true")
+      }
+    }
+  }
+
+  // The agents can use their own SSH keys, separate from the cluster one
+  keys := &sshKeyAssignment{*fAgentsSSHKey, make(map[string]string)}
+  if keys.agentsKey != "" {
+    if err := checkPublicKeyFile(keys.agentsKey); err != nil {
+      return err
+    }
+  }
+  for _, pool := range pools {
+    if pool.sshKey != "" {
+      keys.poolKeys[pool.name] = pool.sshKey
+    }
+  }
+
   // Hash password if given as hash input
   if *fPassword != "" {
     ctx := &passlib.Context{
@@ -338,19 +547,13 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
   }
 
   tfc.PreLines = []string{
-    `provider "aws" {`,
-    `  # Change your default region here`,
-    `  region = "us-west-2"`,
-    `}`,
-    ``,
     `# Used to determine your public IP for forwarding rules`,
     `data "http" "whatismyip" {`,
     `  url = "http://whatismyip.akamai.com/"`,
     `}`,
     ``,
     `module "dcos" {`,
-    `  source  = "dcos-terraform/dcos/aws"`,
-    fmt.Sprintf(`  version = "~> %s"`, GetLatestModuleVersion("0.2.0")),
+    GetModuleSource("dcos-terraform/dcos/aws", "0.2.0"),
     ``,
     `  providers = {`,
     `    aws = "aws"`,
@@ -367,7 +570,7 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
     `  num_private_agents = 1`,
     `  num_public_agents  = 1`,
     ``,
-    fmt.Sprintf(`  dcos_version = "%s"`, GetLatestDCOSVersion("open", "2.0.0")),
+    fmt.Sprintf(`  dcos_version = "%s"`, dcosVersion),
     ``,
     `  ## If you have a DC/OS enterprise license, comment-out the following`,
     `  ## lines and create a file "license.txt" in your project directory `,
@@ -389,35 +592,417 @@ func (p *PluginDcosAwsCmdAddCluster) Handle(args []string, project *ProjectSandb
     `  private_agents_instance_type = "t2.medium"`,
     `  public_agents_instance_type  = "t2.medium"`,
   }
-  tfc.PostLines = []string{
+  tfc.PostLines = append([]string{``}, tags.lines("  ", "")...)
+  tfc.PostLines = append(tfc.PostLines, `}`)
+
+  // Enterprise DC/OS gets the license kept by wheels-license, so it's not
+  // given on the command-line
+  useLicenseVariable := false
+  if getFlagValue(&tfc, "dcos_variant", "open") == "ee" {
+    if getFlagValue(&tfc, DcosLicenseVariable, "") != "" {
+      PrintWarning("The license given with -%s is kept in your shell history, consider using %s instead", DcosLicenseVariable, Bold("wheels-license set"))
+    } else {
+      useLicenseVariable = true
+
+      // Replace the instructions for giving the license by hand
+      var bodyLines []string
+      skipping := false
+      for _, line := range tfc.BodyLines {
+        if strings.Contains(line, "## If you have a DC/OS enterprise license") {
+          skipping = true
+        }
+        if !skipping {
+          bodyLines = append(bodyLines, line)
+        } else if strings.Contains(line, "# "+DcosLicenseVariable) {
+          skipping = false
+        }
+      }
+      tfc.BodyLines = append(bodyLines, ``, `  # The license is given by terraform-wheels, see wheels-license`, fmt.Sprintf(`  %s = "${var.%s}"`, DcosLicenseVariable, DcosLicenseVariable))
+      if license, err := GetDcosLicense(); err == nil && license == "" {
+        PrintWarning("You have not set a DC/OS Enterprise license yet, please do it with %s", Bold("wheels-license set <file>"))
+      }
+    }
+  }
+
+  // The load balancers managed elsewhere replace the ones of the module
+  extraFiles := make(map[string][]byte)
+  if lbs != nil {
+    tfc.BodyLines = lbs.apply(tfc.BodyLines, &refs)
+    extraFiles["cluster-aws-loadbalancers.tf"] = lbs.generate(refs)
+  }
+
+  // The spot agents and the agent pools are created outside of the module,
+  // and installed as additional agents
+  var extraPrivateIps, extraPublicIps []string
+  if *fSpotAgents {
+    spotRefs := refs
+    spotRefs.keyName = keys.getKeyName("", refs.keyName)
+    spotContents, err := p.generateSpotAgents(&tfc, *fSpotMaxPrice, *fAMI, instanceOS, tags, spotRefs)
+    if err != nil {
+      return err
+    }
+    extraFiles["agents-spot.tf"] = spotContents
+    extraPrivateIps = append(extraPrivateIps, "aws_spot_instance_request.spot-agents.*.private_ip")
+  } else if *fSpotMaxPrice != "" {
+    return fmt.Errorf("-spot-max-price can only be used together with -spot-agents")
+  }
+  for _, pool := range pools {
+    poolRefs := refs
+    poolRefs.keyName = keys.getKeyName(pool.name, refs.keyName)
+    extraFiles[fmt.Sprintf("agents-%s.tf", pool.name)] = generateAgentPool(pool, clusterName, instanceOS, tags, poolRefs)
+    if pool.name == "gpu" && *fGpuAgents > 0 {
+      extraFiles["agents-gpu.tf"] = append(gpuAmiLines, extraFiles["agents-gpu.tf"]...)
+    }
+    if pool.public {
+      extraPublicIps = append(extraPublicIps, fmt.Sprintf("module.dcos-pool-%s.private_ips", pool.name))
+    } else {
+      extraPrivateIps = append(extraPrivateIps, fmt.Sprintf("module.dcos-pool-%s.private_ips", pool.name))
+    }
+  }
+
+  if network != nil && (len(hardening.adminIPs) > 0 || hardening.restrictPublicAgents) {
+    return fmt.Errorf("-admin-ips and -restrict-public-agents cannot be used together with -vpc-id, the access is given by its security groups")
+  }
+  hardeningContents, err := hardening.apply(&tfc)
+  if err != nil {
+    return err
+  }
+  if hardeningContents != nil {
+    extraFiles["cluster-aws-encryption.tf"] = hardeningContents
+  }
+
+  extraPrivateIps = append(extraPrivateIps, readBack.externalAgents["additional_private_agent_ips"]...)
+  extraPublicIps = append(extraPublicIps, readBack.externalAgents["additional_public_agent_ips"]...)
+
+  if len(moduleZones) > 0 {
+    tfc.BodyLines = append(tfc.BodyLines, ``, fmt.Sprintf(`  availability_zones = %s`, FormatJSON(moduleZones)))
+  }
+
+  if *fWindowsAgents < 0 {
+    return fmt.Errorf("Invalid number of Windows agents: %d", *fWindowsAgents)
+  } else if *fWindowsAgents > 0 {
+    if network != nil {
+      return fmt.Errorf("-num-windows-agents cannot be used together with -vpc-id")
+    }
+    if err := checkWindowsAgentsSupport(dcosVersion); err != nil {
+      return err
+    }
+    windowsLines, err := getWindowsAgentsBodyLines(&tfc)
+    if err != nil {
+      return err
+    }
+    windowsRefs := refs
+    windowsRefs.keyName = keys.getKeyName("", refs.keyName)
+    extraFiles["agents-windows.tf"] = generateWindowsAgents(*fWindowsAgents, fWindowsType.Value, *fWindowsAMI, clusterName, tags, windowsRefs)
+    tfc.BodyLines = append(tfc.BodyLines, windowsLines...)
+  }
+
+  if network != nil {
+    // The nodes are created outside of module.dcos too, with the network
+    networkContents, err := useExistingNetwork(&tfc, network, clusterName, instanceOS, tags, keys.getKeyName("", refs.keyName), extraPrivateIps, extraPublicIps)
+    if err != nil {
+      return err
+    }
+    extraFiles["cluster-aws-network.tf"] = networkContents
+  } else {
+    // module.dcos creates its agents with the key of the cluster
+    if keys.agentsKey != "" && (getFlagValue(&tfc, "num_private_agents", "1") != "0" || getFlagValue(&tfc, "num_public_agents", "1") != "0") {
+      return fmt.Errorf("The agents of the cluster module always use the cluster key. To use -agents-ssh-key, either set -num_private_agents=0 and -num_public_agents=0 and use -agent-pool (or -spot-agents), or use -vpc-id")
+    }
+
+    extraIps := map[string][]string{"additional_private_agent_ips": extraPrivateIps, "additional_public_agent_ips": extraPublicIps}
+    if len(extraPrivateIps)+len(extraPublicIps) > 0 {
+      tfc.BodyLines = append(tfc.BodyLines, ``, `  # Agents created outside of this module, see the agents-*.tf files`)
+    }
+    for _, name := range []string{"additional_private_agent_ips", "additional_public_agent_ips"} {
+      ips := extraIps[name]
+      if len(ips) == 0 {
+        continue
+      }
+      if f := tfc.Flags.Lookup(name); f != nil && f.Value.String() != "" {
+        return fmt.Errorf("-%s cannot be used together with -spot-agents or -agent-pool", name)
+      }
+      tfc.BodyLines = append(tfc.BodyLines, fmt.Sprintf(`  %s = ["${%s}"]`, name, concatExpr(ips)))
+    }
+  }
+
+  contents, err := tfc.Generate()
+  if err != nil {
+    return err
+  }
+
+  if err := project.RegisterCluster(clusterName, *fExpire); err != nil {
+    PrintWarning("Could not register the cluster: %s", err.Error())
+  }
+
+  // The module, its outputs and the provider are written (and regenerated)
+  // together, along with the additional agents
+  group := CreateTerraformFileGroup(p.GetName())
+  group.AddFile(fileName, contents)
+  variables, tfvars, secrets := tfc.GenerateVariables()
+  if useLicenseVariable {
+    variables = append(variables, []byte(strings.Join([]string{
+      ``,
+      ``,
+      fmt.Sprintf(`variable "%s" {`, DcosLicenseVariable),
+      `  description = "The DC/OS Enterprise license, given by terraform-wheels from wheels-license"`,
+      `}`,
+    }, "\n"))...)
+  }
+  group.AddFile("cluster-aws-variables.tf", variables)
+  // Other workspaces keep their values on top of the ones of the default
+  // workspace, that are left untouched
+  envVarsFile := GetWorkspaceVarsFile(project.GetWorkspace())
+  if envVarsFile != "" && project.HasFile("terraform.tfvars") {
+    existing, err := project.ReadFile("terraform.tfvars")
+    if err != nil {
+      return err
+    }
+    group.AddFile("terraform.tfvars", existing)
+  } else {
+    group.AddFile("terraform.tfvars", tfvars)
+  }
+  if len(secrets) > 0 {
+    group.AddFile(SecretVariablesFile, secrets)
+  }
+  err = group.AddTemplates("aws-cluster", strings.TrimSuffix(fileName, ".tf"), awsClusterTemplateContext{
+    MastersIPs:          refs.mastersIPs,
+    ClusterAddress:      refs.clusterAddress,
+    PublicAgentsAddress: refs.publicAgentsAddress,
+    PrivateAgentsIPs:    refs.privateAgentsIPs,
+    PublicAgentsIPs:     refs.publicAgentsIPs,
+    OSUser:              refs.osUser,
+    BootstrapIP:         refs.bootstrapIP,
+    Region:              "us-west-2",
+    TerraformVersion:    RequiredTerraformVersionPrefix + "0",
+  })
+  if err != nil {
+    return err
+  }
+  if !keys.isEmpty() {
+    group.AddFile("cluster-aws-keys.tf", generateKeyPairs(keys, clusterName))
+  }
+  for extraFile, extraContents := range extraFiles {
+    group.AddFile(extraFile, extraContents)
+  }
+
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(strings.Join(group.GetFileNames(), ", "))), Bold(" containing information for deploying a DC/OS cluster on AWS"))
+  p.parent.showInstructions = true
+  p.parent.createdFile = fileName
+  if _, ok := extraFiles["agents-spot.tf"]; ok {
+    p.parent.spotFile = "agents-spot.tf"
+  }
+
+  if envVarsFile != "" {
+    PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(envVarsFile)), Bold(" with the variables of the '"+project.GetWorkspace()+"' workspace"))
+    if err := project.WriteFormattedTerraformFile(envVarsFile, tfvars); err != nil {
+      return err
+    }
+  }
+
+  if err := project.WriteTerraformFileGroup(group); err != nil {
+    return err
+  }
+
+  numMasters, _ := strconv.Atoi(getFlagValue(&tfc, "num_masters", "1"))
+  numPublicAgents, _ := strconv.Atoi(getFlagValue(&tfc, "num_public_agents", "1"))
+  privateName := "private agent"
+  if *fSpotAgents {
+    privateName = "spot agent"
+  }
+  placement := []zonePlacementGroup{{"master", numMasters}, {privateName, numPrivateAgents}, {"public agent", numPublicAgents}}
+  for _, pool := range pools {
+    placement = append(placement, zonePlacementGroup{pool.name + " agent", pool.count})
+  }
+  if *fWindowsAgents > 0 {
+    placement = append(placement, zonePlacementGroup{"windows agent", *fWindowsAgents})
+  }
+  printZonesTopology(zones, region, placement)
+  return nil
+}
+
+// The context shared by the templates of the aws-cluster set
+type awsClusterTemplateContext struct {
+  MastersIPs          string
+  ClusterAddress      string
+  PublicAgentsAddress string
+  PrivateAgentsIPs    string
+  PublicAgentsIPs     string
+  OSUser              string
+  BootstrapIP         string
+  Region              string
+  TerraformVersion    string
+}
+
+/**
+ * Moves the private agents of the cluster to spot instance requests, and
+ * returns the contents of the file that describes them
+ */
+func (p *PluginDcosAwsCmdAddCluster) generateSpotAgents(tfc *TerraformFileConfig, maxPrice string, ami string, instanceOS string, tags resourceTags, refs awsClusterRefs) ([]byte, error) {
+  if maxPrice != "" {
+    if _, err := strconv.ParseFloat(maxPrice, 64); err != nil {
+      return nil, fmt.Errorf("Invalid spot price '%s', please use a price in USD (eg. 0.05)", maxPrice)
+    }
+  }
+
+  count := "1"
+  if f := tfc.Flags.Lookup("num_private_agents"); f != nil && f.Value.String() != "" {
+    count = f.Value.String()
+  }
+  instanceType := "t2.medium"
+  if f := tfc.Flags.Lookup("private_agents_instance_type"); f != nil && f.Value.String() != "" {
+    instanceType = f.Value.String()
+  }
+
+  tfc.Flags.Set("num_private_agents", "0")
+
+  lines := []string{
+    `// Private agents running on spot instances. AWS can reclaim them at any`,
+    `// time, and they are not replaced until the next apply.`,
+  }
+  amiRef := fmt.Sprintf(`"%s"`, ami)
+  if ami == "" {
+    if !strings.HasPrefix(instanceOS, "centos_") {
+      PrintWarning("The spot agents are running CentOS, use -ami to run %s on them", instanceOS)
+    }
+    amiRef = `"${data.aws_ami.spot-agents.id}"`
+    lines = append(lines,
+      `data "aws_ami" "spot-agents" {`,
+      `  most_recent = true`,
+      `  owners      = ["aws-marketplace"]`,
+      ``,
+      `  // CentOS 7`,
+      `  filter {`,
+      `    name   = "product-code"`,
+      `    values = ["aw0evgkw8e5c1q413zgy5pjce"]`,
+      `  }`,
+      `}`,
+      ``,
+    )
+  }
+  lines = append(lines,
+    `resource "aws_spot_instance_request" "spot-agents" {`,
+    fmt.Sprintf(`  count         = %s`, count),
+    fmt.Sprintf(`  ami           = %s`, amiRef),
+    fmt.Sprintf(`  instance_type = "%s"`, instanceType),
+  )
+  if maxPrice != "" {
+    lines = append(lines, fmt.Sprintf(`  spot_price    = "%s"`, maxPrice))
+  }
+  lines = append(lines,
     ``,
-    `  tags = {`,
-    fmt.Sprintf(`    "expiration" = "%s"`, *fExpire),
-    fmt.Sprintf(`    "owner"      = %s`, FormatJSON(*fOwner)),
-    `  }`,
-    `}`,
+    `  spot_type                       = "one-time"`,
+    `  instance_interruption_behaviour = "terminate"`,
+    `  wait_for_fulfillment            = true`,
     ``,
-    `output "masters-ips" {`,
-    `  value = "${module.dcos.masters-ips}"`,
-    `}`,
+    fmt.Sprintf(`  key_name                    = "${%s}"`, refs.keyName),
+    fmt.Sprintf(`  subnet_id                   = "${element(%s, count.index)}"`, refs.subnetIDs),
+    fmt.Sprintf(`  vpc_security_group_ids      = %s`, formatInterpolationList(refs.securityGroups)),
+    fmt.Sprintf(`  iam_instance_profile        = "${%s}"`, refs.agentProfile),
+    `  associate_public_ip_address = true`,
     ``,
-    `output "cluster-address" {`,
-    `  value = "${module.dcos.masters-loadbalancer}"`,
+    `  root_block_device {`,
+    `    volume_size = 120`,
+    `    volume_type = "gp2"`,
+    `  }`,
+    ``,
+    `  // Only the spot request is tagged, not the instance itself`,
+  )
+  lines = append(lines, tags.lines("  ", "spot-agent-${count.index + 1}")...)
+  lines = append(lines,
     `}`,
     ``,
-    `output "public-agents-loadbalancer" {`,
-    `  value = "${module.dcos.public-agents-loadbalancer}"`,
+    `output "spot-agents-ips" {`,
+    `  value = "${aws_spot_instance_request.spot-agents.*.private_ip}"`,
     `}`,
+  )
+
+  return []byte(strings.Join(lines, "\n")), nil
+}
+
+/**
+ * Returns the DC/OS version to install, given with -dcos-version or
+ * -dcos_version (or the latest one), and resolves its alias (eg. 2.1-latest)
+ * to the concrete release, that is what the file pins
+ */
+func (p *PluginDcosAwsCmdAddCluster) resolveDCOSVersion(project *ProjectSandbox, tfc *TerraformFileConfig, version string) (string, error) {
+  if version == "" {
+    version = getFlagValue(tfc, "dcos_version", "")
+  }
+  if version == "" {
+    return GetLatestDCOSVersion("open", "2.0.0"), nil
   }
 
-  contents, err := tfc.Generate()
+  variant := getFlagValue(tfc, "dcos_variant", "open")
+  if IsDCOSVersionAlias(version) {
+    versions, err := project.GetDCOSVersions()
+    if err != nil {
+      return "", fmt.Errorf("Could not resolve the DC/OS version %s: %s", version, err.Error())
+    }
+    resolved, err := ResolveDCOSVersion(versions[variant], version)
+    if err != nil {
+      return "", err
+    }
+    installerURL := getFlagValue(tfc, "custom_dcos_download_path", GetDCOSInstallerURL(resolved, variant))
+    PrintInfo("Using DC/OS %s for %s, installed from %s", resolved, version, installerURL)
+    version = resolved
+  }
+  tfc.Flags.Set("dcos_version", version)
+
+  matrix, err := project.GetCompatMatrix()
   if err != nil {
-    return err
+    return "", err
+  }
+  if matrix.IsEndOfLife(version) {
+    PrintWarning("DC/OS %s reached its end of life, consider a newer release (see %s wheels-dcos-versions)", version, os.Args[0])
   }
+  return version, nil
+}
 
-  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying a DC/OS cluster on AWS"))
-  p.parent.showInstructions = true
-  p.parent.createdFile = fileName
+/**
+ * Picks the operating system of the nodes, from -os, -ami and
+ * -dcos_instance_os, warning if it's not supported by the DC/OS version
+ */
+func (p *PluginDcosAwsCmdAddCluster) resolveInstanceOS(project *ProjectSandbox, tfc *TerraformFileConfig, osName string, ami string, dcosVersion string) (string, error) {
+  if f := tfc.Flags.Lookup("dcos_instance_os"); f != nil && f.Value.String() != "" {
+    if osName != "" {
+      return "", fmt.Errorf("Please use either -os or -dcos_instance_os, not both")
+    }
+    osName = f.Value.String()
+  }
+  if ami != "" {
+    if !regexp.MustCompile(`^ami-[0-9a-f]+$`).MatchString(ami) {
+      return "", fmt.Errorf("Invalid AMI '%s', expected an ID like ami-0123456789abcdef0", ami)
+    }
+    if osName == "" {
+      PrintWarning("No -os given for the custom AMI, assuming it runs CentOS")
+    }
+    tfc.Flags.Set("aws_ami", ami)
+  }
+  defaulted := osName == ""
+  if defaulted {
+    osName = "centos"
+  }
+
+  matrix, err := project.GetCompatMatrix()
+  if err != nil {
+    return "", err
+  }
+  instanceOS, ok := matrix.ResolveInstanceOS(osName, dcosVersion)
+  if !ok {
+    supported := matrix.GetSupportedOS(dcosVersion)
+    if supported == nil {
+      PrintWarning("Could not check if %s is supported, DC/OS %s is not in the compatibility matrix", osName, dcosVersion)
+    } else {
+      PrintWarning("%s is not supported by DC/OS %s, the supported ones are: %s", osName, dcosVersion, strings.Join(supported, ", "))
+    }
+    if defaulted {
+      instanceOS = "centos_7.5"
+    } else if !strings.Contains(instanceOS, "_") {
+      return "", fmt.Errorf("Please specify the release of %s too (eg. %s_7.6)", osName, osName)
+    }
+  }
 
-  return project.WriteFormattedTerraformFile(fileName, contents)
+  tfc.Flags.Set("dcos_instance_os", instanceOS)
+  return instanceOS, nil
 }
@@ -0,0 +1,387 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginKubernetes struct {
+  pluginLogger
+}
+
+func CreatePluginKubernetes() *PluginKubernetes {
+  return &PluginKubernetes{}
+}
+
+func (p *PluginKubernetes) GetName() string {
+  return "kubernetes"
+}
+
+func (p *PluginKubernetes) Requires() []string {
+  return nil
+}
+
+func (p *PluginKubernetes) Priority() int {
+  return 0
+}
+
+func (p *PluginKubernetes) IsUsed(project *ProjectSandbox) (bool, error) {
+  return len(project.GetTerraformResourcesMatchingName("output", "kubeconfig-*")) > 0, nil
+}
+
+func (p *PluginKubernetes) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginKubernetes) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  if tfErr != nil || tf.GetLastCommand() != "apply" {
+    return nil
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return nil
+  }
+  var names []string
+  for name := range outputs {
+    if strings.HasPrefix(name, "kubeconfig-") {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    if command, ok := outputs[name].Value.(string); ok {
+      p.log.Info("Once the Kubernetes cluster %s is up, get its kubeconfig with:", Bold(strings.TrimPrefix(name, "kubeconfig-")))
+      PrintMessage([]interface{}{"  " + command})
+    }
+  }
+  return nil
+}
+
+func (p *PluginKubernetes) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginKubernetesCmdAddKubernetes{},
+    &PluginKubernetesCmdKubeconfig{},
+  }
+}
+
+type PluginKubernetesCmdAddKubernetes struct {
+}
+
+func (p *PluginKubernetesCmdAddKubernetes) GetName() string {
+  return "add-kubernetes"
+}
+
+func (p *PluginKubernetesCmdAddKubernetes) GetDescription() string {
+  return "Adds a Kubernetes cluster on top of DC/OS"
+}
+
+func (p *PluginKubernetesCmdAddKubernetes) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fName := fSet.String("name", "kubernetes-cluster", "The name of the Kubernetes cluster")
+  fWorkers := fSet.Int("workers", 1, "The number of private Kubernetes nodes")
+  fPublicWorkers := fSet.Int("public-workers", 0, "The number of public Kubernetes nodes")
+  fHA := fSet.Bool("ha", false, "Run a highly available control plane, with 3 etcd and API server instances")
+  fVersion := fSet.String("version", "latest", "The version of the kubernetes-cluster package to install")
+  fMkeVersion := fSet.String("mke-version", "latest", "The version of the kubernetes package, when it's not installed yet")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will generate a kubernetes-xxx.tf file in the project directory",
+      "that deploys a Kubernetes cluster with the DC/OS Kubernetes packages. The",
+      "kubernetes package, that manages the clusters, is added with the first one.",
+      "",
+      "On DC/OS Enterprise, the service accounts, their secrets and permissions",
+      "are created as well.",
+    }, fSet)
+    return nil
+  }
+
+  name := strings.Trim(*fName, "/")
+  if name == "" || strings.Contains(name, "/") {
+    return fmt.Errorf("Please specify a name without slashes with -name=")
+  }
+  if name == "kubernetes" {
+    return fmt.Errorf("The name kubernetes is taken by the engine that manages the clusters")
+  }
+  if *fWorkers < 1 {
+    return fmt.Errorf("A Kubernetes cluster needs at least one worker")
+  }
+  enterprise := getDcosVariant(project) == "ee"
+  addService := &PluginAddServiceCmdAddService{}
+
+  var lines []string
+  mkeFile := "kubernetes.tf"
+  if _, ok := project.GetTerraformResources("module")["kubernetes"]; !ok && !project.HasFile(mkeFile) {
+    config := map[string]interface{}{
+      "service": map[string]interface{}{
+        "name": "kubernetes",
+      },
+    }
+    mkeLines := []string{}
+    if enterprise {
+      // Referenced, so the secret is created before the service
+      config["service"].(map[string]interface{})["service_account"] = "${dcos_security_org_service_account.kubernetes.uid}"
+      config["service"].(map[string]interface{})["service_account_secret"] = "${dcos_security_secret.kubernetes.path}"
+      mkeLines = p.getServiceAccountLines("kubernetes", []string{
+        "dcos:mesos:master:reservation:role:kubernetes-role create",
+        "dcos:mesos:master:framework:role:kubernetes-role create",
+        "dcos:mesos:master:task:user:nobody create",
+      })
+    }
+
+    _, contents, err := addService.generateService(project, tf, "kubernetes", "kubernetes", *fMkeVersion, ServiceConfigToLines(config), "kubernetes")
+    if err != nil {
+      return err
+    }
+    contents = append(contents, []byte(strings.Join(mkeLines, "\n")+"\n")...)
+
+    PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(mkeFile)), Bold(" containing the DC/OS Kubernetes engine"))
+    if err := project.WriteFormattedTerraformFile(mkeFile, contents); err != nil {
+      return err
+    }
+  }
+
+  service := map[string]interface{}{
+    "name": name,
+  }
+  if enterprise {
+    service["service_account"] = fmt.Sprintf("${dcos_security_org_service_account.%s.uid}", name)
+    service["service_account_secret"] = fmt.Sprintf("${dcos_security_secret.%s.path}", name)
+    lines = p.getServiceAccountLines(name, []string{
+      fmt.Sprintf("dcos:mesos:master:framework:role:%s-role create", name),
+      fmt.Sprintf("dcos:mesos:master:reservation:role:%s-role create", name),
+      fmt.Sprintf("dcos:mesos:master:volume:role:%s-role create", name),
+      fmt.Sprintf("dcos:mesos:master:reservation:principal:%s-principal delete", name),
+      fmt.Sprintf("dcos:mesos:master:volume:principal:%s-principal delete", name),
+      fmt.Sprintf("dcos:mesos:master:framework:role:slave_public/%s-role create", name),
+      fmt.Sprintf("dcos:mesos:master:framework:role:slave_public/%s-role read", name),
+      fmt.Sprintf("dcos:mesos:master:reservation:role:slave_public/%s-role create", name),
+      fmt.Sprintf("dcos:mesos:master:volume:role:slave_public/%s-role create", name),
+      "dcos:mesos:master:framework:role:slave_public read",
+      "dcos:mesos:agent:framework:role:slave_public read",
+      "dcos:mesos:master:task:user:root create",
+      "dcos:mesos:agent:task:user:root create",
+      fmt.Sprintf("dcos:secrets:default:/%s/* full", name),
+      fmt.Sprintf("dcos:secrets:list:default:/%s read", name),
+      "dcos:adminrouter:ops:ca:rw full",
+      "dcos:adminrouter:ops:ca:ro full",
+    })
+  }
+  config := map[string]interface{}{
+    "service": service,
+    "kubernetes": map[string]interface{}{
+      "high_availability":  *fHA,
+      "private_node_count": *fWorkers,
+      "public_node_count":  *fPublicWorkers,
+    },
+  }
+
+  fileName := fmt.Sprintf("kubernetes-%s.tf", name)
+  _, contents, err := addService.generateService(project, tf, name, "kubernetes-cluster", *fVersion, ServiceConfigToLines(config), name)
+  if err != nil {
+    return err
+  }
+
+  // The command that fetches the kubeconfig, shown once applied
+  command := fmt.Sprintf("terraform-wheels wheels-kubeconfig %s", name)
+  lines = append(lines, []string{
+    ``,
+    fmt.Sprintf(`output "kubeconfig-%s" {`, name),
+    fmt.Sprintf(`  value = "%s"`, command),
+    `}`,
+  }...)
+  contents = append(contents, []byte(strings.Join(lines, "\n")+"\n")...)
+
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying Kubernetes on DC/OS"))
+  if err := project.WriteFormattedTerraformFile(fileName, contents); err != nil {
+    return err
+  }
+
+  PrintMessage([]interface{}{
+    "",
+    fmt.Sprintf("Once applied and the cluster is up, get its kubeconfig with %s", Bold(command)),
+  })
+  return nil
+}
+
+/**
+ * Returns the resources of the service account of an Enterprise service,
+ * with its key pair, the secret the service logs-in with and its permissions
+ */
+func (p *PluginKubernetesCmdAddKubernetes) getServiceAccountLines(name string, grants []string) []string {
+  id := strings.ReplaceAll(name, "/", "-")
+  lines := []string{
+    ``,
+    `// The service account the service logs-in with`,
+    fmt.Sprintf(`resource "tls_private_key" "%s" {`, id),
+    `  algorithm = "RSA"`,
+    `  rsa_bits  = 2048`,
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "dcos_security_org_service_account" "%s" {`, id),
+    fmt.Sprintf(`  uid         = "%s-principal"`, name),
+    fmt.Sprintf(`  description = "The service account of %s"`, name),
+    fmt.Sprintf(`  public_key  = "${tls_private_key.%s.public_key_pem}"`, id),
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "dcos_security_secret" "%s" {`, id),
+    fmt.Sprintf(`  path = "%s/sa"`, name),
+    ``,
+    `  value = "${jsonencode(map(`,
+    `    "scheme", "RS256",`,
+    fmt.Sprintf(`    "uid", "%s-principal",`, name),
+    fmt.Sprintf(`    "private_key", tls_private_key.%s.private_key_pem,`, id),
+    `    "login_endpoint", "https://leader.mesos/acs/api/v1/auth/login"`,
+    `  ))}"`,
+    `}`,
+    ``,
+    `locals {`,
+    fmt.Sprintf(`  %s-grants = [`, id),
+  }
+  for _, grant := range grants {
+    lines = append(lines, fmt.Sprintf(`    "%s",`, grant))
+  }
+  lines = append(lines, []string{
+    `  ]`,
+    `}`,
+    ``,
+    fmt.Sprintf(`resource "dcos_security_org_user_grant" "%s" {`, id),
+    fmt.Sprintf(`  count    = "${length(local.%s-grants)}"`, id),
+    fmt.Sprintf(`  uid      = "${dcos_security_org_service_account.%s.uid}"`, id),
+    fmt.Sprintf(`  resource = "${element(split(" ", element(local.%s-grants, count.index)), 0)}"`, id),
+    fmt.Sprintf(`  action   = "${element(split(" ", element(local.%s-grants, count.index)), 1)}"`, id),
+    `}`,
+  }...)
+  return lines
+}
+
+type PluginKubernetesCmdKubeconfig struct {
+}
+
+func (p *PluginKubernetesCmdKubeconfig) GetName() string {
+  return "wheels-kubeconfig"
+}
+
+func (p *PluginKubernetesCmdKubeconfig) GetDescription() string {
+  return "Adds a Kubernetes cluster deployed on DC/OS to your kubeconfig"
+}
+
+func (p *PluginKubernetesCmdKubeconfig) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fContext := fSet.String("context", "", "The name of the context (defaults to the name of the cluster)")
+  fKubeconfig := fSet.String("kubeconfig", "", "The kubeconfig to update (defaults to KUBECONFIG or ~/.kube/config)")
+  fServer := fSet.String("server", "", "The address of the API server, when exposed through edge-lb (defaults to the DC/OS API proxy)")
+  fInsecure := fSet.Bool("insecure-skip-tls-verify", false, "Do not verify the certificate of the API server (like the option of kubectl)")
+  fNoSwitch := fSet.Bool("no-switch", false, "Do not make it the current context")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "[<cluster-name>]", []interface{}{
+      "This command will add the given Kubernetes cluster, deployed with",
+      "add-kubernetes, to your kubeconfig as a context of the same name. The API",
+      "server is reached through the DC/OS API proxy, with the DC/OS token the",
+      "provider uses, unless its address is given with -server.",
+      "",
+      "The token expires with the DC/OS session, run it again to refresh it.",
+    }, fSet)
+    return nil
+  }
+
+  // The options can also come after the name
+  name := "kubernetes-cluster"
+  if fSet.NArg() > 0 {
+    name = fSet.Arg(0)
+    if err := ParseCommandFlags(fSet, fSet.Args()[1:]); err != nil {
+      return err
+    }
+  } else if outputs := project.GetTerraformResourcesMatchingName("output", "kubeconfig-*"); len(outputs) == 1 {
+    name = strings.TrimPrefix(outputs[0]["_name"].(string), "kubeconfig-")
+  }
+  context := *fContext
+  if context == "" {
+    context = name
+  }
+
+  clusterUrl := getDcosClusterURL(project, tf)
+  if clusterUrl == "" {
+    return fmt.Errorf("Could not find the DC/OS cluster, is it deployed?")
+  }
+  creds, err := project.ResolveDcosCredentials(clusterUrl)
+  if err != nil {
+    return err
+  }
+  if creds == nil {
+    return fmt.Errorf("No DC/OS credentials were found, please use `%s wheels-login`", os.Args[0])
+  }
+  if creds.Token == "" {
+    token, err := DcosLoginWithPassword(clusterUrl, creds.Username, creds.Password)
+    if err != nil {
+      return err
+    }
+    creds.Token = token
+  }
+  client := CreateDcosClient(clusterUrl, creds)
+
+  var plan struct {
+    Status string `json:"status"`
+  }
+  if err := client.Request("GET", fmt.Sprintf("/service/%s/v1/plans/deploy", name), nil, nil, &plan); err != nil {
+    return fmt.Errorf("Could not find the Kubernetes cluster %s: %s", name, err.Error())
+  }
+  if plan.Status != "COMPLETE" {
+    return fmt.Errorf("The Kubernetes cluster %s is not up yet (its deployment is %s), please try again later", name, plan.Status)
+  }
+
+  entry := KubeconfigEntry{
+    Name:     context,
+    Server:   *fServer,
+    Token:    creds.Token,
+    Insecure: *fInsecure,
+  }
+  if entry.Server == "" {
+    entry.Server = fmt.Sprintf("%s/service/%s/kube-apiserver", GetClusterURL(clusterUrl), name)
+  }
+  if !entry.Insecure {
+    ca, err := client.GetText("/ca/dcos-ca.crt")
+    if err != nil {
+      PrintWarning("Could not fetch the CA of the cluster, the certificate of the API server won't be verified: %s", err.Error())
+      entry.Insecure = true
+    }
+    entry.CA = []byte(ca)
+  }
+
+  path := *fKubeconfig
+  if path == "" {
+    path, err = GetDefaultKubeconfigPath()
+    if err != nil {
+      return err
+    }
+  }
+  if err := MergeKubeconfig(path, entry, !*fNoSwitch); err != nil {
+    return err
+  }
+
+  PrintInfo("Added the context %s to %s", Bold(context), Bold(path))
+  return nil
+}
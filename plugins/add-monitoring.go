@@ -0,0 +1,167 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginMonitoring struct {
+  pluginLogger
+}
+
+func CreatePluginMonitoring() *PluginMonitoring {
+  return &PluginMonitoring{}
+}
+
+func (p *PluginMonitoring) GetName() string {
+  return "monitoring"
+}
+
+func (p *PluginMonitoring) Requires() []string {
+  return nil
+}
+
+func (p *PluginMonitoring) Priority() int {
+  return 0
+}
+
+func (p *PluginMonitoring) IsUsed(project *ProjectSandbox) (bool, error) {
+  _, ok := project.GetTerraformResources("output")["grafana-url"]
+  return ok, nil
+}
+
+func (p *PluginMonitoring) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginMonitoring) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  if tfErr != nil || tf.GetLastCommand() != "apply" {
+    return nil
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return nil
+  }
+  if url, ok := outputs["grafana-url"].Value.(string); ok {
+    p.log.Info("Grafana is available at %s", Bold(url))
+  }
+  if url, ok := outputs["prometheus-url"].Value.(string); ok {
+    p.log.Info("Prometheus is available at %s", Bold(url))
+  }
+  return nil
+}
+
+func (p *PluginMonitoring) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginMonitoringCmdAddMonitoring{},
+  }
+}
+
+type PluginMonitoringCmdAddMonitoring struct {
+}
+
+func (p *PluginMonitoringCmdAddMonitoring) GetName() string {
+  return "add-monitoring"
+}
+
+func (p *PluginMonitoringCmdAddMonitoring) GetDescription() string {
+  return "Adds Prometheus and Grafana, with the DC/OS dashboards, to the cluster"
+}
+
+func (p *PluginMonitoringCmdAddMonitoring) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fAppId := fSet.String("appid", "dcos-monitoring", "The ID of the monitoring service")
+  fVersion := fSet.String("version", "latest", "The version of the dcos-monitoring package to install")
+  fStorage := fSet.Int("storage", 25, "The size of the Prometheus volume, in GB")
+  fPrometheusMem := fSet.Int("prometheus-mem", 4096, "The memory of Prometheus, in MB")
+  fDashboards := fSet.String("dashboards", "https://github.com/dcos/grafana-dashboards", "The git repository with the Grafana dashboards")
+  fDashboardsPath := fSet.String("dashboards-path", "/dashboards/1.13", "The directory of the dashboards in the repository")
+  fAlerts := fSet.String("alerts", "", "Optional git repository with the Prometheus alert rules")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will generate a service-xxx.tf file in the project directory",
+      "that deploys the dcos-monitoring package from universe, with Prometheus",
+      "scraping the cluster metrics and Grafana showing the DC/OS dashboards.",
+      "",
+      "The public URLs of Prometheus and Grafana are wired from the address of",
+      "the cluster, and are available as the prometheus-url and grafana-url",
+      "outputs once applied.",
+    }, fSet)
+    return nil
+  }
+
+  clusterUrl := getDcosClusterURLExpression(project, tf)
+  if clusterUrl == "" {
+    return fmt.Errorf("Could not find the DC/OS cluster, please add one with add-aws-cluster or set DCOS_URL")
+  }
+  serviceUrl := fmt.Sprintf("%s/service/%s", clusterUrl, strings.Trim(*fAppId, "/"))
+  prometheusUrl := serviceUrl + "/prometheus"
+  grafanaUrl := serviceUrl + "/grafana"
+
+  prometheus := map[string]interface{}{
+    "mem": *fPrometheusMem,
+    "volume": map[string]interface{}{
+      "size": *fStorage * 1024,
+    },
+    "admin_router_proxy": map[string]interface{}{
+      "enabled": true,
+      "url":     prometheusUrl,
+    },
+  }
+  if *fAlerts != "" {
+    prometheus["alert_rules_repository"] = map[string]interface{}{
+      "url": *fAlerts,
+    }
+  }
+  config := map[string]interface{}{
+    "service": map[string]interface{}{
+      "name": *fAppId,
+    },
+    "prometheus": prometheus,
+    "grafana": map[string]interface{}{
+      "admin_router_proxy": map[string]interface{}{
+        "enabled": true,
+        "url":     grafanaUrl,
+      },
+      "dashboard_config_repository": map[string]interface{}{
+        "url":  *fDashboards,
+        "path": *fDashboardsPath,
+      },
+    },
+  }
+
+  addService := &PluginAddServiceCmdAddService{}
+  fileName, contents, err := addService.generateService(project, tf, strings.ReplaceAll(strings.Trim(*fAppId, "/"), "/", "-"), "dcos-monitoring", *fVersion, ServiceConfigToLines(config), *fAppId)
+  if err != nil {
+    return err
+  }
+
+  // The URLs are outputs, so they can be used as datasources elsewhere
+  outputLines := []string{
+    ``,
+    `output "prometheus-url" {`,
+    fmt.Sprintf(`  value = "%s/"`, prometheusUrl),
+    `}`,
+    ``,
+    `output "grafana-url" {`,
+    fmt.Sprintf(`  value = "%s/"`, grafanaUrl),
+    `}`,
+  }
+  contents = append(contents, []byte(strings.Join(outputLines, "\n")+"\n")...)
+
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying Prometheus and Grafana on DC/OS"))
+  return project.WriteFormattedTerraformFile(fileName, contents)
+}
@@ -20,6 +20,14 @@ func (p *PluginAddService) GetName() string {
   return "add-service"
 }
 
+func (p *PluginAddService) Requires() []string {
+  return nil
+}
+
+func (p *PluginAddService) Priority() int {
+  return 0
+}
+
 func (p *PluginAddService) IsUsed(project *ProjectSandbox) (bool, error) {
   return false, nil
 }
@@ -57,10 +65,12 @@ func (p *PluginAddServiceCmdAddService) Handle(args []string, project *ProjectSa
   fPackageVersion := fSet.String("version", "latest", "The version of the package to install")
   fConfig := fSet.String("config", "", "Optional path to a configuration file to import")
   fAppId := fSet.String("appid", "", "The ID of the application to assign when deployed on DC/OS")
+  fPreset := fSet.String("preset", "", "Deploy a curated set of packages, instead of a single one")
+  fListPresets := fSet.Bool("list-presets", false, "List the available presets")
 
   help := fSet.Bool("help", false, "Show this help message")
   fSet.BoolVar(help, "h", false, "Show this help message")
-  err := fSet.Parse(args)
+  err := ParseCommandFlags(fSet, args)
   if err != nil {
     FatalError(err)
   }
@@ -69,10 +79,24 @@ func (p *PluginAddServiceCmdAddService) Handle(args []string, project *ProjectSa
     PrintHelp(p.GetName(), "", []interface{}{
       "This command will generate a service-xxx.tf file in the project directory",
       "that describes a deployment of a universe service on DC/OS.",
+      "",
+      "With -preset it generates one file for every package of the preset,",
+      "configured with tuned default options. Your own presets can be placed",
+      "in ~/.terraform-wheels/assets/presets.",
     }, fSet)
     return nil
   }
 
+  if *fListPresets {
+    return p.listPresets()
+  }
+  if *fPreset != "" {
+    if *fPackageName != "" || *fServiceName != "" || *fConfig != "" || *fAppId != "" {
+      return fmt.Errorf("The -preset flag cannot be combined with -package, -name, -config or -appid")
+    }
+    return p.addPreset(project, tf, *fPreset)
+  }
+
   if *fPackageName == "" {
     fSet.PrintDefaults()
     return fmt.Errorf("Please specify the package name with -package=")
@@ -92,41 +116,137 @@ func (p *PluginAddServiceCmdAddService) Handle(args []string, project *ProjectSa
     }
   }
 
-  var fileName string = fmt.Sprintf("service-%s.tf", *fServiceName)
+  fileName, contents, err := p.generateService(project, tf, *fServiceName, *fPackageName, *fPackageVersion, configLines, *fAppId)
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying a service on top of DC/OS"))
+  return project.WriteFormattedTerraformFile(fileName, contents)
+}
+
+/**
+ * Writes a service file for every package of the given preset
+ */
+func (p *PluginAddServiceCmdAddService) addPreset(project *ProjectSandbox, tf *TerraformWrapper, name string) error {
+  preset, err := LoadServicePreset(name)
+  if err != nil {
+    return err
+  }
+
+  for _, service := range preset.Services {
+    version := service.Version
+    if version == "" {
+      version = "latest"
+    }
+
+    fileName, contents, err := p.generateService(project, tf, service.Name, service.Package, version, ServiceConfigToLines(service.Options), service.Name)
+    if err != nil {
+      return err
+    }
+
+    PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying a service on top of DC/OS"))
+    if err := project.WriteFormattedTerraformFile(fileName, contents); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/**
+ * Prints the available presets with their description
+ */
+func (p *PluginAddServiceCmdAddService) listPresets() error {
+  names, err := ListServicePresets()
+  if err != nil {
+    return err
+  }
+
+  for _, name := range names {
+    preset, err := LoadServicePreset(name)
+    if err != nil {
+      PrintWarning("%s", err.Error())
+      continue
+    }
+
+    var packages []string
+    for _, service := range preset.Services {
+      packages = append(packages, service.Name)
+    }
+    Printf("%-16s %s (%s)\n", name, preset.Description, strings.Join(packages, ", "))
+  }
+  return nil
+}
+
+/**
+ * Returns the name and the contents of the file that deploys the given
+ * package as the given service
+ */
+func (p *PluginAddServiceCmdAddService) generateService(project *ProjectSandbox, tf *TerraformWrapper, serviceName string, packageName string, packageVersion string, configLines []string, appId string) (string, []byte, error) {
+  // Pin the version that the cluster would install, if we can reach it
+  if packageVersion == "latest" {
+    if version := p.resolvePackageVersion(project, tf, packageName); version != "" {
+      PrintInfo("Using %s version %s, as resolved by the cluster", packageName, Bold(version))
+      packageVersion = version
+    }
+  }
+
+  var fileName string = fmt.Sprintf("service-%s.tf", serviceName)
   var lines []string = []string{
     `// Specify which upstream repository to use for installing this package`,
-    fmt.Sprintf(`resource "dcos_package_repo" "%s" {`, *fServiceName),
+    fmt.Sprintf(`resource "dcos_package_repo" "%s" {`, serviceName),
     `  name = "Universe"`,
     `  url  = "https://universe.mesosphere.com/repo"`,
     `}`,
     ``,
     `// Select the package version to deploy`,
-    fmt.Sprintf(`data "dcos_package_version" "%s" {`, *fServiceName),
-    fmt.Sprintf(`  repo_url = "${dcos_package_repo.%s.url}"`, *fServiceName),
+    fmt.Sprintf(`data "dcos_package_version" "%s" {`, serviceName),
+    fmt.Sprintf(`  repo_url = "${dcos_package_repo.%s.url}"`, serviceName),
     ``,
-    fmt.Sprintf(`  name    = "%s"`, *fPackageName),
-    fmt.Sprintf(`  version = "%s"`, *fPackageVersion),
+    fmt.Sprintf(`  name    = "%s"`, packageName),
+    fmt.Sprintf(`  version = "%s"`, packageVersion),
     `}`,
     ``,
     `// Configure the service to deploy`,
-    fmt.Sprintf(`data "dcos_package_config" "%s" {`, *fServiceName),
-    fmt.Sprintf(`  version_spec = "${data.dcos_package_version.%s.spec}"`, *fServiceName),
+    fmt.Sprintf(`data "dcos_package_config" "%s" {`, serviceName),
+    fmt.Sprintf(`  version_spec = "${data.dcos_package_version.%s.spec}"`, serviceName),
   }
   lines = append(lines, configLines...)
   lines = append(lines, []string{
     `}`,
     ``,
     `// Deploy the service`,
-    fmt.Sprintf(`module "%s" {`, *fServiceName),
+    fmt.Sprintf(`module "%s" {`, serviceName),
     `  source = "github.com/mesosphere/data-services-terraform/modules/ds-deploy"`,
     ``,
-    fmt.Sprintf(`  config          = "${data.dcos_package_config.%s.config}"`, *fServiceName),
-    fmt.Sprintf(`  app_id          = "%s"`, *fAppId),
-    fmt.Sprintf(`  service_account = "%s-principal"`, strings.ReplaceAll(*fAppId, "/", "__")),
+    fmt.Sprintf(`  config          = "${data.dcos_package_config.%s.config}"`, serviceName),
+    fmt.Sprintf(`  app_id          = "%s"`, appId),
+    fmt.Sprintf(`  service_account = "%s-principal"`, strings.ReplaceAll(appId, "/", "__")),
     `}`,
   }...)
 
-  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(fileName)), Bold(" containing information for deploying a service on top of DC/OS"))
-  contents := []byte(strings.Join(lines, "\n") + "\n")
-  return project.WriteFormattedTerraformFile(fileName, contents)
+  return fileName, []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+/**
+ * Asks the deployed cluster for the latest version of the package, returning
+ * an empty string if that's not possible
+ */
+func (p *PluginAddServiceCmdAddService) resolvePackageVersion(project *ProjectSandbox, tf *TerraformWrapper, name string) string {
+  clusterUrl := getDcosClusterURL(project, tf)
+  if clusterUrl == "" {
+    return ""
+  }
+
+  creds, err := project.LookupDcosCredentials(clusterUrl)
+  if err != nil {
+    return ""
+  }
+
+  version, err := CreateDcosClient(clusterUrl, creds).DescribePackage(name, "")
+  if err != nil {
+    PrintWarning("Could not resolve the version of %s: %s", name, err.Error())
+    return ""
+  }
+  return version
 }
@@ -0,0 +1,136 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "path/filepath"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginClusterIdentity struct {
+  pluginLogger
+}
+
+func CreatePluginClusterIdentity() *PluginClusterIdentity {
+  return &PluginClusterIdentity{}
+}
+
+func (p *PluginClusterIdentity) GetName() string {
+  return "cluster-identity"
+}
+
+// The identity is fetched once the cluster is ready
+func (p *PluginClusterIdentity) Requires() []string {
+  return []string{"dcos-aws"}
+}
+
+func (p *PluginClusterIdentity) Priority() int {
+  return 0
+}
+
+func (p *PluginClusterIdentity) IsUsed(project *ProjectSandbox) (bool, error) {
+  return project.GetIdentityExportPath() != "", nil
+}
+
+func (p *PluginClusterIdentity) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginClusterIdentity) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  cmd := tf.GetLastCommand()
+  if tfErr != nil || (cmd != "apply" && cmd != "refresh") {
+    return nil
+  }
+
+  // Only refresh the exported identity if the cluster changed, since the
+  // automation watching it might re-configure everything
+  path := project.GetIdentityExportPath()
+  address, masters, err := tf.GetClusterOutputs()
+  if err != nil {
+    p.log.Warn("Could not refresh %s: %s", path, err.Error())
+    return nil
+  }
+  if identity, err := ReadClusterIdentity(path); err == nil && identity.Matches(address, masters) {
+    return nil
+  }
+
+  identity, err := FetchClusterIdentity(address, masters)
+  if err != nil {
+    p.log.Warn("Could not refresh %s: %s", path, err.Error())
+    return nil
+  }
+  if err := identity.WriteTo(path); err != nil {
+    return err
+  }
+  p.log.Info("The cluster changed, refreshed its identity in %s", Bold(path))
+  return nil
+}
+
+func (p *PluginClusterIdentity) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginClusterIdentityCmdExport{},
+  }
+}
+
+type PluginClusterIdentityCmdExport struct {
+}
+
+func (p *PluginClusterIdentityCmdExport) GetName() string {
+  return "export-cluster-identity"
+}
+
+func (p *PluginClusterIdentityCmdExport) GetDescription() string {
+  return "Exports the identity of the cluster (ID, CA, OIDC endpoints) as JSON"
+}
+
+func (p *PluginClusterIdentityCmdExport) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOutput := fSet.String("o", "cluster-identity.json", "The file to write, or - for the standard output")
+  fNoRefresh := fSet.Bool("no-refresh", false, "Do not refresh the file after every apply")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will write a JSON document with the cluster ID, the CA",
+      "certificate, the OIDC endpoints and the admin router URL of the cluster",
+      "deployed by the project, for the SSO and DNS automation. Unless written",
+      "to the standard output or with -no-refresh, the file is refreshed after",
+      "every apply that changes the cluster.",
+    }, fSet)
+    return nil
+  }
+
+  address, masters, err := tf.GetClusterOutputs()
+  if err != nil {
+    return fmt.Errorf("Could not find the cluster, is it deployed? %s", err.Error())
+  }
+  identity, err := FetchClusterIdentity(address, masters)
+  if err != nil {
+    return err
+  }
+
+  path := *fOutput
+  if path != "-" && !filepath.IsAbs(path) {
+    path = project.GetFilePath(path)
+  }
+  if err := identity.WriteTo(path); err != nil {
+    return err
+  }
+  if path == "-" {
+    return nil
+  }
+
+  PrintInfo("Wrote the identity of cluster %s to %s", Bold(identity.ClusterID), Bold(*fOutput))
+  if !*fNoRefresh {
+    return project.SetIdentityExportPath(path)
+  }
+  return nil
+}
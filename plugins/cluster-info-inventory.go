@@ -0,0 +1,320 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+  "gopkg.in/yaml.v3"
+)
+
+type PluginClusterInfoCmdInventory struct {
+}
+
+func (p *PluginClusterInfoCmdInventory) GetName() string {
+  return "wheels-inventory"
+}
+
+func (p *PluginClusterInfoCmdInventory) GetDescription() string {
+  return "Writes an Ansible inventory or an SSH config with the nodes of the cluster"
+}
+
+/**
+ * A node of the cluster, with its alias in the inventory
+ */
+type inventoryHost struct {
+  alias   string
+  address string
+}
+
+/**
+ * The nodes of the cluster by group (masters, private_agents, ...), and how
+ * to reach them: through the bootstrap node, with the keys of the project
+ */
+type clusterInventory struct {
+  user   string
+  keys   []string
+  jump   *inventoryHost
+  groups []string
+  hosts  map[string][]inventoryHost
+  // The groups of agents, that are also in the `agents` group
+  agentGroups []string
+}
+
+func (p *PluginClusterInfoCmdInventory) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fFormat := CreateEnumFlag("yaml", "yaml", "ini", "ssh-config")
+  fSet.Var(fFormat, "format", "The output format: yaml or ini (Ansible inventories), or ssh-config")
+  fOutput := fSet.String("output", "", "Write to the given file instead of the standard output")
+  fPrefix := fSet.String("prefix", "", "The prefix of the host aliases (default: the name of the project directory)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "Writes the nodes of the deployed cluster as an Ansible inventory (with the",
+      "masters, private_agents, public_agents and agents groups), or as SSH config",
+      "hosts. The nodes are reached through the bootstrap node, with the SSH user",
+      "and the keys of the project. For example:",
+      "",
+      fmt.Sprintf("  %s %s -output=inventory.yaml", os.Args[0], p.GetName()),
+      "  ansible -i inventory.yaml masters -m ping",
+      "",
+      fmt.Sprintf("  %s %s -format=ssh-config -output=ssh_config", os.Args[0], p.GetName()),
+      "  ssh -F ssh_config <prefix>-master-1",
+    }, fSet)
+    return nil
+  }
+
+  prefix := *fPrefix
+  if prefix == "" {
+    prefix = filepath.Base(project.GetFilePath(""))
+  }
+  prefix = regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(prefix, "-")
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return err
+  }
+  info, err := getClusterInfo(outputs)
+  if err != nil {
+    return err
+  }
+  bootstrapIP, _ := outputs["bootstrap-ip"].Value.(string)
+  inventory := createClusterInventory(info, bootstrapIP, prefix, getInventoryKeys(project))
+  if inventory.jump == nil {
+    PrintWarning("There is no `bootstrap-ip` output (see `%s add-aws-cluster`), reaching the nodes directly", os.Args[0])
+  }
+
+  var content string
+  switch fFormat.Value {
+  case "ini":
+    content = inventory.ini()
+  case "ssh-config":
+    content = inventory.sshConfig()
+  default:
+    content, err = inventory.yaml()
+    if err != nil {
+      return err
+    }
+  }
+
+  if *fOutput == "" {
+    Printf("%s", content)
+    return nil
+  }
+  if err := ioutil.WriteFile(*fOutput, []byte(content), 0644); err != nil {
+    return fmt.Errorf("Could not write %s: %s", *fOutput, err.Error())
+  }
+  PrintInfo("Wrote the %d nodes of the cluster in %s", inventory.count(), Bold(*fOutput))
+  return nil
+}
+
+/**
+ * Returns the private keys to SSH into the nodes: the one given with
+ * --ssh-key, or the ones next to the public keys of the project
+ */
+func getInventoryKeys(project *ProjectSandbox) []string {
+  sshKey := GetSSHKey()
+  if sshKey == "agent" {
+    return nil
+  } else if sshKey != "" {
+    if path, err := filepath.Abs(sshKey); err == nil {
+      return []string{path}
+    }
+  }
+
+  var keys []string
+  for _, key := range findSSHPublicKeys(project) {
+    privKey := project.GetFilePath(GetPrivateKeyNameFromPublic(key))
+    if _, err := os.Stat(privKey); err == nil {
+      keys = append(keys, privKey)
+    }
+  }
+  return keys
+}
+
+func createClusterInventory(info *clusterInfo, bootstrapIP string, prefix string, keys []string) *clusterInventory {
+  inventory := &clusterInventory{
+    user:  info.SSHUser,
+    keys:  keys,
+    hosts: make(map[string][]inventoryHost),
+  }
+  nonAlnum := regexp.MustCompile(`[^a-z0-9]+`)
+  addGroup := func(group string, alias string, addresses []string) {
+    if len(addresses) == 0 {
+      return
+    }
+    inventory.groups = append(inventory.groups, group)
+    for i, address := range addresses {
+      inventory.hosts[group] = append(inventory.hosts[group], inventoryHost{fmt.Sprintf("%s-%s-%d", prefix, alias, i+1), address})
+    }
+  }
+
+  if bootstrapIP != "" {
+    inventory.jump = &inventoryHost{prefix + "-bootstrap", bootstrapIP}
+    inventory.groups = append(inventory.groups, "bootstrap")
+    inventory.hosts["bootstrap"] = []inventoryHost{*inventory.jump}
+  }
+  addGroup("masters", "master", info.Masters)
+  addGroup("private_agents", "private-agent", info.PrivateAgents)
+  addGroup("public_agents", "public-agent", info.PublicAgents)
+  for _, name := range info.poolNames() {
+    id := nonAlnum.ReplaceAllString(strings.ToLower(name), "-")
+    addGroup(strings.ReplaceAll(id, "-", "_")+"_agents", id+"-agent", info.AgentPools[name])
+  }
+  for _, group := range inventory.groups {
+    if strings.HasSuffix(group, "_agents") {
+      inventory.agentGroups = append(inventory.agentGroups, group)
+    }
+  }
+  return inventory
+}
+
+func (i *clusterInventory) count() int {
+  count := 0
+  for _, hosts := range i.hosts {
+    count += len(hosts)
+  }
+  return count
+}
+
+/**
+ * The ssh options to reach the nodes. Through the bootstrap node, the keys
+ * must be given to the jump too, so it's a ProxyCommand and not a ProxyJump.
+ */
+func (i *clusterInventory) sshArgs(jump bool) string {
+  args := []string{"-o StrictHostKeyChecking=no", "-o UserKnownHostsFile=/dev/null"}
+  if jump && i.jump != nil {
+    proxy := "ssh -W %h:%p -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
+    for _, key := range i.keys {
+      proxy += " -i " + key
+    }
+    target := i.jump.address
+    if i.user != "" {
+      target = i.user + "@" + target
+    }
+    args = append(args, fmt.Sprintf(`-o ProxyCommand="%s %s"`, proxy, target))
+  }
+  return strings.Join(args, " ")
+}
+
+/**
+ * The variables of all the hosts of the Ansible inventory
+ */
+func (i *clusterInventory) vars() [][]string {
+  var vars [][]string
+  if i.user != "" {
+    vars = append(vars, []string{"ansible_user", i.user})
+  }
+  if len(i.keys) > 0 {
+    vars = append(vars, []string{"ansible_ssh_private_key_file", i.keys[0]})
+  }
+  vars = append(vars, []string{"ansible_ssh_common_args", i.sshArgs(true)})
+  return vars
+}
+
+func (i *clusterInventory) yaml() (string, error) {
+  vars := make(map[string]interface{})
+  for _, v := range i.vars() {
+    vars[v[0]] = v[1]
+  }
+
+  children := make(map[string]interface{})
+  for _, group := range i.groups {
+    hosts := make(map[string]interface{})
+    for _, host := range i.hosts[group] {
+      hostVars := map[string]interface{}{"ansible_host": host.address}
+      // The bootstrap node itself is reached directly
+      if group == "bootstrap" {
+        hostVars["ansible_ssh_common_args"] = i.sshArgs(false)
+      }
+      hosts[host.alias] = hostVars
+    }
+    children[group] = map[string]interface{}{"hosts": hosts}
+  }
+  if len(i.agentGroups) > 0 {
+    agents := make(map[string]interface{})
+    for _, group := range i.agentGroups {
+      agents[group] = map[string]interface{}{}
+    }
+    children["agents"] = map[string]interface{}{"children": agents}
+  }
+
+  content, err := yaml.Marshal(map[string]interface{}{
+    "all": map[string]interface{}{
+      "vars":     vars,
+      "children": children,
+    },
+  })
+  if err != nil {
+    return "", fmt.Errorf("Could not encode the inventory: %s", err.Error())
+  }
+  return string(content), nil
+}
+
+func (i *clusterInventory) ini() string {
+  quote := func(value string) string {
+    return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+  }
+
+  var lines []string
+  for _, group := range i.groups {
+    lines = append(lines, "["+group+"]")
+    for _, host := range i.hosts[group] {
+      line := fmt.Sprintf("%s ansible_host=%s", host.alias, host.address)
+      if group == "bootstrap" {
+        line += " ansible_ssh_common_args=" + quote(i.sshArgs(false))
+      }
+      lines = append(lines, line)
+    }
+    lines = append(lines, "")
+  }
+  if len(i.agentGroups) > 0 {
+    lines = append(lines, "[agents:children]")
+    lines = append(lines, i.agentGroups...)
+    lines = append(lines, "")
+  }
+
+  lines = append(lines, "[all:vars]")
+  for _, v := range i.vars() {
+    lines = append(lines, fmt.Sprintf("%s=%s", v[0], quote(v[1])))
+  }
+  return strings.Join(lines, "\n") + "\n"
+}
+
+/**
+ * Returns the SSH config hosts, to use with `ssh -F` or to include in
+ * ~/.ssh/config
+ */
+func (i *clusterInventory) sshConfig() string {
+  var lines []string
+  for _, group := range i.groups {
+    for _, host := range i.hosts[group] {
+      lines = append(lines, "Host "+host.alias, "  HostName "+host.address)
+      if i.user != "" {
+        lines = append(lines, "  User "+i.user)
+      }
+      for _, key := range i.keys {
+        lines = append(lines, "  IdentityFile "+key)
+      }
+      lines = append(lines, "  StrictHostKeyChecking no", "  UserKnownHostsFile /dev/null")
+      if i.jump != nil && host.alias != i.jump.alias {
+        lines = append(lines, "  ProxyJump "+i.jump.alias)
+      }
+      lines = append(lines, "")
+    }
+  }
+  return strings.Join(lines, "\n")
+}
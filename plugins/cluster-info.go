@@ -0,0 +1,382 @@
+package plugins
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net"
+  "os"
+  "os/exec"
+  "regexp"
+  "sort"
+  "strings"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+  "gopkg.in/yaml.v3"
+)
+
+type PluginClusterInfo struct {
+}
+
+func CreatePluginClusterInfo() *PluginClusterInfo {
+  return &PluginClusterInfo{}
+}
+
+func (p *PluginClusterInfo) GetName() string {
+  return "cluster-info"
+}
+
+func (p *PluginClusterInfo) Requires() []string {
+  return []string{}
+}
+
+func (p *PluginClusterInfo) Priority() int {
+  return 0
+}
+
+func (p *PluginClusterInfo) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginClusterInfo) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginClusterInfo) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginClusterInfo) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginClusterInfoCmdShow{},
+    &PluginClusterInfoCmdUI{},
+    &PluginClusterInfoCmdInventory{},
+  }
+}
+
+type PluginClusterInfoCmdShow struct {
+}
+
+func (p *PluginClusterInfoCmdShow) GetName() string {
+  return "cluster-info"
+}
+
+func (p *PluginClusterInfoCmdShow) GetDescription() string {
+  return "Shows how to reach the deployed cluster: its URL, its nodes and the SSH user"
+}
+
+/**
+ * The summary of a deployed cluster, from the outputs of the project
+ */
+type clusterInfo struct {
+  URL                      string              `json:"url" yaml:"url"`
+  PublicAgentsLoadBalancer string              `json:"public_agents_loadbalancer,omitempty" yaml:"public_agents_loadbalancer,omitempty"`
+  SSHUser                  string              `json:"ssh_user,omitempty" yaml:"ssh_user,omitempty"`
+  Masters                  []string            `json:"masters" yaml:"masters"`
+  PrivateAgents            []string            `json:"private_agents" yaml:"private_agents"`
+  PublicAgents             []string            `json:"public_agents" yaml:"public_agents"`
+  AgentPools               map[string][]string `json:"agent_pools,omitempty" yaml:"agent_pools,omitempty"`
+  Links                    map[string]string   `json:"links,omitempty" yaml:"links,omitempty"`
+  Workspace                string              `json:"workspace,omitempty" yaml:"workspace,omitempty"`
+}
+
+func (p *PluginClusterInfoCmdShow) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fFormat := CreateEnumFlag("text", "text", "json", "yaml", "env")
+  fSet.Var(fFormat, "format", "The output format: text, json, yaml or env (for eval)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "Shows the URL of the deployed cluster, the address of its public agents,",
+      "the IPs of its nodes and the user to SSH into them. For scripts, use eg.:",
+      "",
+      fmt.Sprintf("  eval \"$(%s %s -format=env)\"", os.Args[0], p.GetName()),
+    }, fSet)
+    return nil
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return err
+  }
+  info, err := getClusterInfo(outputs)
+  if err != nil {
+    return err
+  }
+
+  switch fFormat.Value {
+  case "json":
+    content, err := json.MarshalIndent(info, "", "  ")
+    if err != nil {
+      return fmt.Errorf("Could not encode the cluster info: %s", err.Error())
+    }
+    Println(string(content))
+  case "yaml":
+    content, err := yaml.Marshal(info)
+    if err != nil {
+      return fmt.Errorf("Could not encode the cluster info: %s", err.Error())
+    }
+    fmt.Print(string(content))
+  case "env":
+    for _, line := range info.envLines() {
+      Println(line)
+    }
+  default:
+    info.print()
+  }
+  return nil
+}
+
+/**
+ * Collects the summary of the cluster from the outputs of the project
+ */
+func getClusterInfo(outputs map[string]TerraformOutput) (*clusterInfo, error) {
+  address, ok := outputs["cluster-address"].Value.(string)
+  if !ok || address == "" {
+    return nil, fmt.Errorf("The cluster is not deployed, there is no `cluster-address` output. See `%s apply`", os.Args[0])
+  }
+
+  info := &clusterInfo{
+    URL:           GetClusterURL(address),
+    Masters:       getOutputList(outputs, "masters-ips"),
+    PrivateAgents: getOutputList(outputs, "private-agents-ips"),
+    PublicAgents:  getOutputList(outputs, "public-agents-ips"),
+    AgentPools:    make(map[string][]string),
+    Links:         make(map[string]string),
+  }
+  info.PublicAgentsLoadBalancer, _ = outputs["public-agents-loadbalancer"].Value.(string)
+  info.SSHUser, _ = outputs["ssh-user"].Value.(string)
+  info.Workspace, _ = outputs["workspace"].Value.(string)
+
+  // The agents created outside of the cluster module, and the services that
+  // can be reached through the cluster
+  poolRe := regexp.MustCompile(`^(.+)-agents-ips$`)
+  for name, output := range outputs {
+    if m := poolRe.FindStringSubmatch(name); m != nil && m[1] != "private" && m[1] != "public" {
+      info.AgentPools[m[1]] = getOutputList(outputs, name)
+    } else if value, ok := output.Value.(string); ok && strings.HasSuffix(name, "-url") && !output.Sensitive {
+      info.Links[strings.TrimSuffix(name, "-url")] = value
+    }
+  }
+  return info, nil
+}
+
+func getOutputList(outputs map[string]TerraformOutput, name string) []string {
+  ret := []string{}
+  if list, ok := outputs[name].Value.([]interface{}); ok {
+    for _, item := range list {
+      if str, ok := item.(string); ok && str != "" {
+        ret = append(ret, str)
+      }
+    }
+  }
+  return ret
+}
+
+func (i *clusterInfo) poolNames() []string {
+  var names []string
+  for name := range i.AgentPools {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+func (i *clusterInfo) print() {
+  orNone := func(values []string) string {
+    if len(values) == 0 {
+      return "-"
+    }
+    return strings.Join(values, ", ")
+  }
+
+  Printf("%-22s %s\n", Bold("Cluster URL:"), Green(i.URL))
+  if i.PublicAgentsLoadBalancer != "" {
+    Printf("%-22s %s\n", Bold("Public agents:"), i.PublicAgentsLoadBalancer)
+  }
+  if i.SSHUser != "" {
+    Printf("%-22s %s\n", Bold("SSH user:"), i.SSHUser)
+  }
+  if i.Workspace != "" && i.Workspace != "default" {
+    Printf("%-22s %s\n", Bold("Environment:"), i.Workspace)
+  }
+  Println()
+  Printf("%-22s %s\n", Bold("Masters:"), orNone(i.Masters))
+  Printf("%-22s %s\n", Bold("Private agents:"), orNone(i.PrivateAgents))
+  Printf("%-22s %s\n", Bold("Public agents IPs:"), orNone(i.PublicAgents))
+  for _, name := range i.poolNames() {
+    Printf("%-22s %s\n", Bold(fmt.Sprintf("Pool %s:", name)), orNone(i.AgentPools[name]))
+  }
+
+  if len(i.Links) > 0 {
+    var names []string
+    for name := range i.Links {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    Println()
+    for _, name := range names {
+      Printf("%-22s %s\n", Bold(strings.Title(name)+":"), i.Links[name])
+    }
+  }
+
+  if i.SSHUser != "" && len(i.Masters) > 0 {
+    Println()
+    Printf("To SSH into the first master: %s\n", Bold(fmt.Sprintf("ssh -i cluster-key %s@%s", i.SSHUser, i.Masters[0])))
+  }
+}
+
+/**
+ * Returns the lines of shell variables describing the cluster
+ */
+func (i *clusterInfo) envLines() []string {
+  quote := func(value string) string {
+    return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+  }
+  lines := []string{
+    "export DCOS_URL=" + quote(i.URL),
+    "export DCOS_PUBLIC_AGENTS_LB=" + quote(i.PublicAgentsLoadBalancer),
+    "export DCOS_SSH_USER=" + quote(i.SSHUser),
+    "export DCOS_MASTERS_IPS=" + quote(strings.Join(i.Masters, " ")),
+    "export DCOS_PRIVATE_AGENTS_IPS=" + quote(strings.Join(i.PrivateAgents, " ")),
+    "export DCOS_PUBLIC_AGENTS_IPS=" + quote(strings.Join(i.PublicAgents, " ")),
+  }
+  nonAlnum := regexp.MustCompile(`[^A-Z0-9]+`)
+  for _, name := range i.poolNames() {
+    envName := nonAlnum.ReplaceAllString(strings.ToUpper(name), "_")
+    lines = append(lines, fmt.Sprintf("export DCOS_%s_AGENTS_IPS=%s", envName, quote(strings.Join(i.AgentPools[name], " "))))
+  }
+  return lines
+}
+
+type PluginClusterInfoCmdUI struct {
+}
+
+func (p *PluginClusterInfoCmdUI) GetName() string {
+  return "wheels-ui"
+}
+
+func (p *PluginClusterInfoCmdUI) GetDescription() string {
+  return "Opens the DC/OS UI of the deployed cluster in the browser"
+}
+
+func (p *PluginClusterInfoCmdUI) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fTunnel := fSet.Bool("tunnel", false, "Reach the UI through an SSH tunnel to a master, for the clusters that are not public")
+  fVia := fSet.String("via", "", "The host to open the SSH tunnel to (default: the first master)")
+  fPort := CreateIntRange(8443, 1, 65535)
+  fSet.Var(fPort, "port", "The local port of the SSH tunnel")
+  fPrint := fSet.Bool("print", false, "Only print the URL of the UI, without opening the browser")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "Opens the DC/OS UI of the deployed cluster in the default browser. When the",
+      "cluster cannot be reached directly, use -tunnel to forward a local port to",
+      "the UI through SSH, until Ctrl+C is pressed.",
+    }, fSet)
+    return nil
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return err
+  }
+  info, err := getClusterInfo(outputs)
+  if err != nil {
+    return err
+  }
+  if !*fTunnel {
+    return openClusterUI(info.URL, *fPrint)
+  }
+
+  // Through another host (eg. a bastion), the tunnel goes on to a master
+  host, target := *fVia, "127.0.0.1"
+  if len(info.Masters) > 0 {
+    if host == "" {
+      host = info.Masters[0]
+    } else {
+      target = info.Masters[0]
+    }
+  } else if host == "" {
+    return fmt.Errorf("There is no `masters-ips` output to open the tunnel to, use -via")
+  }
+  if info.SSHUser != "" && !strings.Contains(host, "@") {
+    host = info.SSHUser + "@" + host
+  }
+
+  sshPath, err := exec.LookPath(ExecutableName("ssh"))
+  if err != nil {
+    return fmt.Errorf("Could not find ssh in your system")
+  }
+  local := fmt.Sprintf("127.0.0.1:%d", fPort.Value)
+  sshArgs := []string{"-N", "-o", "StrictHostKeyChecking=no", "-o", "ExitOnForwardFailure=yes", "-L", local + ":" + target + ":443"}
+  for _, key := range findSSHPublicKeys(project) {
+    privKey := project.GetFilePath(GetPrivateKeyNameFromPublic(key))
+    if _, err := os.Stat(privKey); err == nil {
+      sshArgs = append(sshArgs, "-i", privKey)
+    }
+  }
+  sshArgs = append(sshArgs, host)
+
+  // The browser is opened once the tunnel accepts connections
+  done := make(chan struct{})
+  opened := make(chan struct{})
+  go func() {
+    for {
+      select {
+      case <-done:
+        return
+      case <-time.After(500 * time.Millisecond):
+      }
+      if conn, err := net.DialTimeout("tcp", local, time.Second); err == nil {
+        conn.Close()
+        close(opened)
+        if err := openClusterUI("https://"+local, *fPrint); err != nil {
+          PrintWarning("%s", err.Error())
+        }
+        PrintInfo("Press Ctrl+C to close the tunnel")
+        return
+      }
+    }
+  }()
+
+  PrintInfo("Opening an SSH tunnel to %s on %s", Bold(host), Bold(local))
+  code, err := ExecuteAndPassthrough(nil, sshPath, sshArgs...)
+  close(done)
+  if err != nil {
+    return fmt.Errorf("Could not open the SSH tunnel: %s", err.Error())
+  }
+
+  // Once opened, the tunnel is closed with Ctrl+C
+  select {
+  case <-opened:
+    return nil
+  default:
+  }
+  return fmt.Errorf("Could not open the SSH tunnel to %s (ssh exited with code %d)", host, code)
+}
+
+func openClusterUI(url string, printOnly bool) error {
+  if printOnly {
+    Println(url)
+    return nil
+  }
+  PrintInfo("Opening %s", Bold(url))
+  return OpenBrowser(url)
+}
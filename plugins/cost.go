@@ -0,0 +1,99 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginCost struct {
+}
+
+func CreatePluginCost() *PluginCost {
+  return &PluginCost{}
+}
+
+func (p *PluginCost) GetName() string {
+  return "cost"
+}
+
+func (p *PluginCost) Requires() []string {
+  return nil
+}
+
+func (p *PluginCost) Priority() int {
+  return 0
+}
+
+func (p *PluginCost) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginCost) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginCost) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginCost) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginCostCmdCost{},
+  }
+}
+
+type PluginCostCmdCost struct {
+}
+
+func (p *PluginCostCmdCost) GetName() string {
+  return "wheels-cost"
+}
+
+func (p *PluginCostCmdCost) GetDescription() string {
+  return "Estimates the monthly cost of the cluster"
+}
+
+func (p *PluginCostCmdCost) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fPrices := fSet.String("prices", "", "A price table (file or URL) to use instead of the configured one")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will estimate the monthly on-demand cost of the machines",
+      "described in the project, using the defaults of the DC/OS modules for",
+      "everything that is not specified. Storage, traffic and load balancers",
+      "are not included.",
+    }, fSet)
+    return nil
+  }
+
+  var table PriceTable
+  if *fPrices != "" {
+    table, err = LoadPriceTable(*fPrices)
+  } else {
+    table, err = project.GetPriceTable()
+  }
+  if err != nil {
+    return err
+  }
+
+  items, err := project.EstimateCost(table)
+  if err != nil {
+    return err
+  }
+  if len(items) == 0 {
+    return fmt.Errorf("Could not find any machines in the project")
+  }
+
+  PrintCostEstimate(items)
+  return nil
+}
@@ -0,0 +1,143 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginDcosAwsCmdPause struct {
+  parent *PluginDcosAws
+}
+
+func (p *PluginDcosAwsCmdPause) GetName() string {
+  return "wheels-pause"
+}
+
+func (p *PluginDcosAwsCmdPause) GetDescription() string {
+  return "Stops the instances of the cluster, to save money while it's not used"
+}
+
+func (p *PluginDcosAwsCmdPause) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fYes := fSet.Bool("yes", false, "Do not ask for confirmation")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will stop the EC2 instances of the cluster, without changing",
+      "the terraform state, so a development cluster does not cost much while",
+      "it's not used (eg. overnight). Start them again with wheels-resume.",
+      "",
+      "The load balancers, the EBS volumes and the spot instances (that cannot",
+      "be stopped) are still billed. The instances get new public IPs when they",
+      "are started again, unless they have an Elastic IP.",
+    }, fSet)
+    return nil
+  }
+
+  paused, err := project.GetPausedCluster()
+  if err != nil {
+    return err
+  }
+  if paused != nil {
+    return fmt.Errorf("The cluster was already paused on %s", paused.PausedAt.Local().Format(time.RFC1123))
+  }
+  if err := EnsureAWSCredentials(); err != nil {
+    return err
+  }
+
+  instances, spots, err := tf.GetStateInstances(project.GetAWSRegion())
+  if err != nil {
+    return err
+  }
+  if len(instances) == 0 {
+    return fmt.Errorf("There are no instances to stop in the terraform state")
+  }
+  for _, spot := range spots {
+    PrintWarning("The spot instance of %s cannot be stopped, it keeps running", spot)
+  }
+  PrintWarning("The instances get new public IPs when they are resumed, the outputs are refreshed then")
+
+  if !*fYes && (!IsInteractive() || !ReadYN(fmt.Sprintf("Stop the %d instances of the cluster?", len(instances)))) {
+    return fmt.Errorf("Not stopping anything, use -yes to skip the confirmation")
+  }
+  if err := project.PauseCluster(instances); err != nil {
+    return err
+  }
+
+  PrintInfo("%s, resume it with %s", Bold("The cluster is paused"), Bold(os.Args[0]+" wheels-resume"))
+  return nil
+}
+
+type PluginDcosAwsCmdResume struct {
+  parent       *PluginDcosAws
+  runTerraform func(args []string) error
+}
+
+func (p *PluginDcosAwsCmdResume) GetName() string {
+  return "wheels-resume"
+}
+
+func (p *PluginDcosAwsCmdResume) GetDescription() string {
+  return "Starts the instances of a cluster paused with wheels-pause"
+}
+
+func (p *PluginDcosAwsCmdResume) SetTerraformRunner(run func(args []string) error) {
+  p.runTerraform = run
+}
+
+func (p *PluginDcosAwsCmdResume) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fWait := fSet.Bool("wait", false, "Wait for DC/OS to be up again, like the readiness gate")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will start the instances stopped by wheels-pause, and then",
+      "refresh the terraform state so the outputs have the new public IPs.",
+    }, fSet)
+    return nil
+  }
+
+  paused, err := project.GetPausedCluster()
+  if err != nil {
+    return err
+  }
+  if paused == nil {
+    return fmt.Errorf("The cluster is not paused")
+  }
+  if err := EnsureAWSCredentials(); err != nil {
+    return err
+  }
+
+  if err := project.ResumeCluster(paused); err != nil {
+    return err
+  }
+  PrintInfo("Refreshing the outputs with the new public IPs")
+  if err := p.runTerraform([]string{"refresh"}); err != nil {
+    return err
+  }
+
+  if *fWait {
+    return p.parent.waitForCluster(tf, project.GetConfig().ReadinessGate)
+  }
+  PrintInfo("%s, DC/OS takes a few minutes to be up again", Bold("The cluster is resumed"))
+  return nil
+}
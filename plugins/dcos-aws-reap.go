@@ -0,0 +1,140 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginDcosAwsCmdReap struct {
+  parent       *PluginDcosAws
+  runTerraform func(project *ProjectSandbox, tf *TerraformWrapper, args []string) error
+}
+
+func (p *PluginDcosAwsCmdReap) GetName() string {
+  return "wheels-reap"
+}
+
+func (p *PluginDcosAwsCmdReap) GetDescription() string {
+  return "Destroys the clusters that are past their expiration"
+}
+
+func (p *PluginDcosAwsCmdReap) SetProjectTerraformRunner(run func(project *ProjectSandbox, tf *TerraformWrapper, args []string) error) {
+  p.runTerraform = run
+}
+
+func (p *PluginDcosAwsCmdReap) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fDryRun := fSet.Bool("dry-run", false, "Only list the clusters, do not destroy anything")
+  fYes := fSet.Bool("yes", false, "Do not ask for confirmation")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will destroy all the clusters created on this machine that",
+      "are past the expiration given with `add-aws-cluster -expires-in`. The",
+      "expiration is counted from the first successful apply.",
+    }, fSet)
+    return nil
+  }
+
+  registry, err := LoadClusterRegistry()
+  if err != nil {
+    return err
+  }
+
+  var dirs []string
+  for dir, _ := range registry {
+    dirs = append(dirs, dir)
+  }
+  sort.Strings(dirs)
+
+  var expired []*RegisteredCluster
+  for _, dir := range dirs {
+    c := registry[dir]
+    status := "never expires"
+    if expires, ok := c.GetExpiration(); ok {
+      if c.IsExpired() {
+        status = fmt.Sprintf("%s", Red(fmt.Sprintf("expired %s ago", time.Since(expires).Round(time.Minute))))
+        expired = append(expired, c)
+      } else {
+        status = fmt.Sprintf("expires in %s", time.Until(expires).Round(time.Minute))
+      }
+    } else if c.TTL != "" {
+      status = "not applied yet"
+    }
+    PrintInfo("%s in %s: %s", Bold(c.Name), c.Dir, status)
+  }
+
+  if len(expired) == 0 {
+    PrintInfo("There are no expired clusters")
+    return nil
+  }
+  if *fDryRun {
+    return nil
+  }
+  if !*fYes && (!IsInteractive() || !ReadYN(fmt.Sprintf("Destroy %d expired cluster(s)?", len(expired)))) {
+    return fmt.Errorf("Not destroying anything, use -yes to skip the confirmation")
+  }
+
+  cwd, err := os.Getwd()
+  if err != nil {
+    return err
+  }
+  defer os.Chdir(cwd)
+
+  failed := 0
+  for _, c := range expired {
+    PrintInfo("Destroying %s in %s", Bold(c.Name), c.Dir)
+    if err := p.destroyCluster(c); err != nil {
+      PrintWarning("Could not destroy %s: %s", c.Name, err.Error())
+      failed++
+    }
+  }
+
+  if failed > 0 {
+    return fmt.Errorf("Could not destroy %d cluster(s)", failed)
+  }
+  return nil
+}
+
+func (p *PluginDcosAwsCmdReap) destroyCluster(c *RegisteredCluster) error {
+  if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+    PrintWarning("The project directory does not exist any more, forgetting the cluster")
+    return UpdateClusterRegistry(func(registry map[string]*RegisteredCluster) error {
+      delete(registry, c.Dir)
+      return nil
+    })
+  }
+
+  // Terraform works on the current directory
+  if err := os.Chdir(c.Dir); err != nil {
+    return err
+  }
+  sandbox, err := OpenSandbox(c.Dir)
+  if err != nil {
+    return err
+  }
+  defer sandbox.Unlock()
+  tf, err := sandbox.GetTerraform()
+  if err != nil {
+    return err
+  }
+
+  // Like `terraform destroy` in the project, which also forgets the cluster
+  if err := sandbox.CheckDestroyAllowed(); err != nil {
+    return err
+  }
+  return p.runTerraform(sandbox, tf, []string{"destroy", "-auto-approve"})
+}
@@ -1,7 +1,10 @@
 package plugins
 
 import (
+  "flag"
   "fmt"
+  "io/ioutil"
+  "os"
   "strings"
 
   . "github.com/logrusorgru/aurora"
@@ -9,6 +12,7 @@ import (
 )
 
 type PluginDcosProvider struct {
+  pluginLogger
 }
 
 func CreatePluginDcosProvider() *PluginDcosProvider {
@@ -19,13 +23,23 @@ func (p *PluginDcosProvider) GetName() string {
   return "dcos-provider"
 }
 
+// The provider is configured from the imported cluster
+func (p *PluginDcosProvider) Requires() []string {
+  return []string{"import-cluster"}
+}
+
+// Resolving the credentials talks to the cluster, start it early
+func (p *PluginDcosProvider) Priority() int {
+  return 10
+}
+
 func (p *PluginDcosProvider) IsUsed(project *ProjectSandbox) (bool, error) {
   dcos_data := project.GetTerraformResourcesMatchingName("data", "dcos_*")
   dcos_resource := project.GetTerraformResourcesMatchingName("resource", "dcos_*")
   return len(dcos_data) > 0 || len(dcos_resource) > 0, nil
 }
 
-func (p *PluginDcosProvider) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+func (p *PluginDcosProvider) Render(project *ProjectSandbox) error {
 
   // If we are missing a DC/OS provider file, create it now
   provider := project.GetTerraformResourcesMatchingName("provider", "dcos")
@@ -41,7 +55,67 @@ func (p *PluginDcosProvider) BeforeRun(project *ProjectSandbox, tf *TerraformWra
 
     PrintInfo("You are using dcos_ resources but you don't have a DC/OS provider. I created %s for you, please have a look", Bold(filename))
   }
+  return nil
+}
+
+func (p *PluginDcosProvider) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  if err := p.Render(project); err != nil {
+    return err
+  }
+  provider := project.GetTerraformResourcesMatchingName("provider", "dcos")
+
+  // Resolve the credentials the provider is going to use. The cluster might
+  // not exist yet during `init` or the first `plan`, in which case the
+  // provider is configured by the module outputs.
+  if initRun {
+    return nil
+  }
+  clusterUrl := getDcosClusterURL(project, tf)
+  if err := p.moveProviderSecrets(project, provider, clusterUrl); err != nil {
+    return err
+  }
+  creds, err := project.ResolveDcosCredentials(clusterUrl)
+  if err != nil {
+    return err
+  }
+  if clusterUrl != "" {
+    tf.SetEnv("DCOS_URL", GetClusterURL(clusterUrl))
+  }
+  if creds == nil && getDcosVariant(project) == "ee" {
+    // The superuser created by the module, until it's changed
+    creds = &DcosCredentials{Source: "default superuser", Username: "bootstrapuser", Password: "deleteme"}
+  }
+  if creds != nil {
+    p.log.Info("Using DC/OS credentials from %s", Bold(creds.Source))
+    creds.ExportTo(tf)
+  }
+
+  return nil
+}
 
+/**
+ * Moves the tokens written in plain text in the provider configuration to
+ * the credential store, so they can be removed from the project files
+ */
+func (p *PluginDcosProvider) moveProviderSecrets(project *ProjectSandbox, provider []map[string]interface{}, clusterUrl string) error {
+  for _, cfg := range provider {
+    for _, field := range []string{"dcos_acs_token", "password"} {
+      value, ok := cfg[field].(string)
+      if !ok || value == "" || strings.Contains(value, "${") {
+        continue
+      }
+      RegisterSecret(value)
+
+      if field == "dcos_acs_token" && clusterUrl != "" {
+        if err := project.CacheDcosToken(clusterUrl, value); err != nil {
+          return err
+        }
+        PrintWarning("The DC/OS provider has a token in plain text, it's now kept in the credential store. Please remove %s from the provider block.", Bold(field))
+      } else {
+        PrintWarning("The DC/OS provider has a %s in plain text, please remove it and use %s instead", Bold(field), Bold("wheels-login"))
+      }
+    }
+  }
   return nil
 }
 
@@ -50,7 +124,63 @@ func (p *PluginDcosProvider) AfterRun(project *ProjectSandbox, tf *TerraformWrap
 }
 
 func (p *PluginDcosProvider) GetCommands() []PluginCommand {
-  return []PluginCommand{}
+  return []PluginCommand{
+    &PluginDcosProviderCmdLogin{},
+    &PluginDcosProviderCmdLogout{},
+    &PluginDcosProviderCmdMock{},
+  }
+}
+
+/**
+ * Returns the URL of the DC/OS cluster the project is talking to, either
+ * explicitly configured or from the outputs of a deployed cluster
+ */
+func getDcosClusterURL(project *ProjectSandbox, tf *TerraformWrapper) string {
+  if v, ok := os.LookupEnv("DCOS_URL"); ok && v != "" {
+    return v
+  }
+  if url := project.GetConfig().Dcos.URL; url != "" {
+    return url
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    return ""
+  }
+  if address, ok := outputs["cluster-address"].Value.(string); ok {
+    return address
+  }
+  return ""
+}
+
+/**
+ * Returns a terraform expression for the URL of the DC/OS cluster, from the
+ * outputs of the deployment module when there is one, or an empty string
+ */
+func getDcosClusterURLExpression(project *ProjectSandbox, tf *TerraformWrapper) string {
+  mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
+  if len(mods) > 0 {
+    return fmt.Sprintf("https://${module.%s.masters-loadbalancer}", mods[0]["_name"].(string))
+  }
+  if url := getDcosClusterURL(project, tf); url != "" {
+    return GetClusterURL(url)
+  }
+  return ""
+}
+
+/**
+ * Returns the DC/OS variant of the cluster deployed by the project, "open"
+ * unless it's explicitly "ee"
+ */
+func getDcosVariant(project *ProjectSandbox) string {
+  mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
+  if len(mods) == 0 {
+    return "open"
+  }
+  if v, ok := project.ResolveTerraformValue(mods[0]["dcos_variant"]).(string); ok {
+    return v
+  }
+  return "open"
 }
 
 func (p *PluginDcosProvider) getProviderContents(project *ProjectSandbox) []string {
@@ -72,18 +202,173 @@ func (p *PluginDcosProvider) getProviderContents(project *ProjectSandbox) []stri
   awsModName := awsMod["_name"].(string)
   cfg = append(cfg, fmt.Sprintf(`  dcos_url = "${module.%s.masters-loadbalancer}"`, awsModName))
 
-  // Get variant
-  variant := "open"
-  if v, ok := awsMod["dcos_variant"]; ok {
-    variant = v.(string)
-  }
-
-  // If we have an ee variant, we can have password
-  if variant == "ee" {
-    cfg = append(cfg, fmt.Sprintf(`  user = "bootstrapuser"`))
-    cfg = append(cfg, fmt.Sprintf(`  password = "deleteme"`))
+  // The credentials are given through the environment, from the credential
+  // store (see wheels-login), so they are never written here
+  if getDcosVariant(project) == "ee" {
+    cfg = append(cfg, `  # Credentials from "wheels-login", or the default superuser`)
   }
 
   cfg = append(cfg, "}")
   return cfg
 }
+
+type PluginDcosProviderCmdLogin struct {
+}
+
+func (p *PluginDcosProviderCmdLogin) GetName() string {
+  return "wheels-login"
+}
+
+func (p *PluginDcosProviderCmdLogin) GetDescription() string {
+  return "Logs-in to the DC/OS cluster and caches the credentials"
+}
+
+func (p *PluginDcosProviderCmdLogin) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fUrl := fSet.String("url", "", "The URL of the cluster (defaults to the deployed cluster)")
+  fUsername := fSet.String("username", "", "The user to log-in as")
+  fServiceAccount := fSet.String("service-account", "", "Log-in using this service account instead")
+  fPrivateKey := fSet.String("private-key", "", "The private key of the service account")
+  fOIDC := fSet.Bool("oidc", false, "Log-in through the login page of the cluster in the browser (the default on DC/OS Open)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will log-in to the DC/OS cluster and remember the token, so",
+      "the dcos provider can use it for the following terraform runs. When the",
+      "token expires, the service accounts log-in again by themselves, and you",
+      "are asked to log-in again otherwise.",
+    }, fSet)
+    return nil
+  }
+
+  clusterUrl := *fUrl
+  if clusterUrl == "" {
+    clusterUrl = getDcosClusterURL(project, tf)
+  }
+  if clusterUrl == "" {
+    return fmt.Errorf("Could not find a deployed cluster, please specify one with -url=")
+  }
+
+  if *fServiceAccount != "" {
+    key, err := ioutil.ReadFile(*fPrivateKey)
+    if err != nil {
+      return fmt.Errorf("Could not read private key: %s", err.Error())
+    }
+    token, err := DcosLoginWithServiceAccount(clusterUrl, *fServiceAccount, key)
+    if err != nil {
+      return err
+    }
+    err = project.CacheDcosLogin(clusterUrl, token, DcosServiceAccountLogin(*fServiceAccount, *fPrivateKey))
+    if err != nil {
+      return err
+    }
+  } else if *fOIDC {
+    _, err := project.DcosBrowserLogin(clusterUrl)
+    if err != nil {
+      return err
+    }
+  } else {
+    _, err := project.DcosInteractiveLogin(clusterUrl, *fUsername)
+    if err != nil {
+      return err
+    }
+  }
+
+  PrintInfo("Logged-in to %s", Bold(GetClusterURL(clusterUrl)))
+  return nil
+}
+
+type PluginDcosProviderCmdLogout struct {
+}
+
+func (p *PluginDcosProviderCmdLogout) GetName() string {
+  return "wheels-logout"
+}
+
+func (p *PluginDcosProviderCmdLogout) GetDescription() string {
+  return "Forgets the cached credentials of the DC/OS cluster"
+}
+
+func (p *PluginDcosProviderCmdLogout) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fUrl := fSet.String("url", "", "The URL of the cluster (defaults to the deployed cluster)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will remove the cached DC/OS credentials of the cluster.",
+    }, fSet)
+    return nil
+  }
+
+  clusterUrl := *fUrl
+  if clusterUrl == "" {
+    clusterUrl = getDcosClusterURL(project, tf)
+  }
+  if clusterUrl == "" {
+    return fmt.Errorf("Could not find a deployed cluster, please specify one with -url=")
+  }
+
+  err = project.ForgetDcosToken(clusterUrl)
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("Logged-out from %s", Bold(GetClusterURL(clusterUrl)))
+  return nil
+}
+
+type PluginDcosProviderCmdMock struct {
+}
+
+func (p *PluginDcosProviderCmdMock) GetName() string {
+  return "wheels-dcos-mock"
+}
+
+func (p *PluginDcosProviderCmdMock) GetDescription() string {
+  return "Serves recorded DC/OS API responses as a mock cluster"
+}
+
+func (p *PluginDcosProviderCmdMock) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fDir := fSet.String("dir", "", "The directory with the recordings")
+  fListen := fSet.String("listen", "127.0.0.1:8080", "The address to listen on")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || *fDir == "" {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will serve the DC/OS API responses that were recorded from a",
+      "real cluster, so you can develop without one. To record them, run any",
+      "command against a real cluster with --dcos-record=<dir>. To replay them",
+      "within terraform-wheels itself, use --dcos-replay=<dir> instead.",
+    }, fSet)
+    return nil
+  }
+
+  if _, err := os.Stat(*fDir); err != nil {
+    return fmt.Errorf("Could not find the recordings in %s", *fDir)
+  }
+
+  PrintInfo("Serving the recordings of %s on %s", Bold(*fDir), Bold("http://"+*fListen))
+  PrintInfo("Point the DC/OS provider to it with: export DCOS_URL=http://%s", *fListen)
+  return ServeDcosMock(*fDir, *fListen)
+}
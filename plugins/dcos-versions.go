@@ -0,0 +1,167 @@
+package plugins
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginDcosVersions struct {
+}
+
+func CreatePluginDcosVersions() *PluginDcosVersions {
+  return &PluginDcosVersions{}
+}
+
+func (p *PluginDcosVersions) GetName() string {
+  return "dcos-versions"
+}
+
+func (p *PluginDcosVersions) Requires() []string {
+  return nil
+}
+
+func (p *PluginDcosVersions) Priority() int {
+  return 0
+}
+
+func (p *PluginDcosVersions) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginDcosVersions) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginDcosVersions) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginDcosVersions) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginDcosVersionsCmdList{},
+  }
+}
+
+type PluginDcosVersionsCmdList struct {
+}
+
+func (p *PluginDcosVersionsCmdList) GetName() string {
+  return "wheels-dcos-versions"
+}
+
+func (p *PluginDcosVersionsCmdList) GetDescription() string {
+  return "Lists the DC/OS releases that can be installed, and the aliases of add-aws-cluster"
+}
+
+/**
+ * A DC/OS release, as listed by wheels-dcos-versions
+ */
+type dcosRelease struct {
+  Version      string   `json:"version"`
+  Aliases      []string `json:"aliases,omitempty"`
+  PreRelease   bool     `json:"pre_release,omitempty"`
+  EndOfLife    bool     `json:"end_of_life"`
+  InstallerURL string   `json:"installer_url"`
+}
+
+func (p *PluginDcosVersionsCmdList) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fVariant := CreateEnumFlag("all", "all", "open", "ee")
+  fSet.Var(fVariant, "variant", "The DC/OS variant to list: all, open or ee")
+  fFormat := CreateEnumFlag("text", "text", "json")
+  fSet.Var(fFormat, "format", "The output format: text or json")
+  fURL := fSet.String("url", "", "The versions service (or file) to read the releases from, instead of the one of the project")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "Lists the DC/OS releases, the newest first, and marks the ones that",
+      "reached their end of life. The aliases of each release can be given to",
+      "add-aws-cluster instead of a version, eg.:",
+      "",
+      fmt.Sprintf("  %s add-aws-cluster -dcos-version=latest-stable", os.Args[0]),
+      fmt.Sprintf("  %s add-aws-cluster -dcos-version=2.1-latest", os.Args[0]),
+      "",
+      "They are resolved to a concrete release when the cluster is generated.",
+    }, fSet)
+    return nil
+  }
+
+  var versions map[string][]*semver.Version
+  if *fURL != "" {
+    versions, err = FetchDCOSVersions(*fURL)
+  } else {
+    versions, err = project.GetDCOSVersions()
+  }
+  if err != nil {
+    return err
+  }
+  matrix, err := project.GetCompatMatrix()
+  if err != nil {
+    return err
+  }
+
+  variants := []string{"open", "ee"}
+  if fVariant.Value != "all" {
+    variants = []string{fVariant.Value}
+  }
+  releases := make(map[string][]dcosRelease)
+  for _, variant := range variants {
+    releases[variant] = []dcosRelease{}
+    for _, ver := range versions[variant] {
+      releases[variant] = append(releases[variant], dcosRelease{
+        Version:      ver.Original(),
+        Aliases:      GetDCOSVersionAliases(versions[variant], ver),
+        PreRelease:   ver.Prerelease() != "",
+        EndOfLife:    matrix.IsEndOfLife(ver.Original()),
+        InstallerURL: GetDCOSInstallerURL(ver.Original(), variant),
+      })
+    }
+  }
+
+  if fFormat.Value == "json" {
+    content, err := json.MarshalIndent(releases, "", "  ")
+    if err != nil {
+      return fmt.Errorf("Could not encode the DC/OS releases: %s", err.Error())
+    }
+    Println(string(content))
+    return nil
+  }
+
+  for i, variant := range variants {
+    if i > 0 {
+      Println("")
+    }
+    Printf("%s\n", Bold(fmt.Sprintf("DC/OS %s", variant)))
+    if len(releases[variant]) == 0 {
+      Printf("  (no releases)\n")
+    }
+    for _, release := range releases[variant] {
+      var notes []string
+      if len(release.Aliases) > 0 {
+        notes = append(notes, Green(strings.Join(release.Aliases, ", ")).String())
+      }
+      if release.PreRelease {
+        notes = append(notes, Yellow("pre-release").String())
+      }
+      if release.EndOfLife {
+        notes = append(notes, Red("end of life").String())
+      }
+      Printf("  %-12s %s\n", release.Version, strings.Join(notes, "  "))
+    }
+  }
+  return nil
+}
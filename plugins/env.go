@@ -0,0 +1,189 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginEnv struct {
+}
+
+func CreatePluginEnv() *PluginEnv {
+  return &PluginEnv{}
+}
+
+func (p *PluginEnv) GetName() string {
+  return "env"
+}
+
+func (p *PluginEnv) Requires() []string {
+  return nil
+}
+
+func (p *PluginEnv) Priority() int {
+  return 0
+}
+
+func (p *PluginEnv) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginEnv) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginEnv) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginEnv) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginEnvCmdEnv{},
+  }
+}
+
+type PluginEnvCmdEnv struct {
+}
+
+func (p *PluginEnvCmdEnv) GetName() string {
+  return "wheels-env"
+}
+
+func (p *PluginEnvCmdEnv) GetDescription() string {
+  return "Manages the environments (dev, staging, prod...) of the cluster, as terraform workspaces"
+}
+
+func (p *PluginEnvCmdEnv) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fFrom := fSet.String("from", "", "When creating an environment, copy the variables of this one (defaults to the current one)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "list|show|new <name>|select <name>|delete <name>", []interface{}{
+      "This command manages the environments of the project, each one being a",
+      "terraform workspace with its own state. The variables of an environment",
+      "are kept in env-<name>.tfvars on top of terraform.tfvars, and are given",
+      "to terraform automatically when that environment is selected.",
+      "",
+      "The protected environments (prod and production, or the ones in the",
+      "`environments.protected` list of .wheels.yaml) can only be destroyed",
+      "with the --allow-protected-destroy flag.",
+    }, fSet)
+    return nil
+  }
+
+  action := fSet.Arg(0)
+  name := fSet.Arg(1)
+  if action != "list" && action != "show" && name == "" {
+    return fmt.Errorf("Please give the name of the environment to %s", action)
+  }
+
+  switch action {
+  case "list":
+    return tf.Invoke([]string{"workspace", "list"})
+
+  case "show":
+    return p.show(project, tf)
+
+  case "new":
+    from := *fFrom
+    if from == "" {
+      from = project.GetWorkspace()
+    }
+    if err := tf.Invoke([]string{"workspace", "new", name}); err != nil {
+      return err
+    }
+    return p.copyVariables(project, from, name)
+
+  case "select":
+    return tf.Invoke([]string{"workspace", "select", name})
+
+  case "delete":
+    if err := project.CheckWorkspaceDestroyAllowed(name); err != nil {
+      return err
+    }
+    if project.GetWorkspace() == name {
+      if err := tf.Invoke([]string{"workspace", "select", "default"}); err != nil {
+        return err
+      }
+    }
+    if err := tf.Invoke([]string{"workspace", "delete", name}); err != nil {
+      return err
+    }
+    if file := GetWorkspaceVarsFile(name); file != "" && project.HasFile(file) {
+      PrintInfo("The variables of the environment are still in %s, remove it if you don't need them", Bold(file))
+    }
+    return nil
+  }
+
+  return fmt.Errorf("Unknown action '%s', use %s %s -help to see the available ones", action, os.Args[0], p.GetName())
+}
+
+func (p *PluginEnvCmdEnv) show(project *ProjectSandbox, tf *TerraformWrapper) error {
+  ws := project.GetWorkspace()
+  Printf("%s %s\n", Bold("Environment:"), ws)
+  if project.IsProtectedWorkspace(ws) {
+    Printf("%s %s\n", Bold("Protected:"), Yellow("yes"))
+  } else {
+    Printf("%s %s\n", Bold("Protected:"), "no")
+  }
+  if file := GetWorkspaceVarsFile(ws); file != "" && project.HasFile(file) {
+    Printf("%s terraform.tfvars, %s\n", Bold("Variables:"), file)
+  } else {
+    Printf("%s terraform.tfvars\n", Bold("Variables:"))
+  }
+
+  outputs, err := tf.GetOutputs()
+  if err != nil || len(outputs) == 0 {
+    Printf("%s %s\n", Bold("Outputs:"), "none, the environment is not deployed")
+    return nil
+  }
+  var names []string
+  for name := range outputs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  Printf("%s\n", Bold("Outputs:"))
+  for _, name := range names {
+    if outputs[name].Sensitive {
+      Printf("  %s = <sensitive>\n", name)
+    } else {
+      Printf("  %s = %v\n", name, outputs[name].Value)
+    }
+  }
+  return nil
+}
+
+// Starts the variables of a new environment from the ones of another, so it
+// can be tweaked without affecting the others
+func (p *PluginEnvCmdEnv) copyVariables(project *ProjectSandbox, from string, to string) error {
+  src := GetWorkspaceVarsFile(from)
+  if src == "" || !project.HasFile(src) {
+    src = "terraform.tfvars"
+  }
+  if !project.HasFile(src) {
+    return nil
+  }
+
+  contents, err := project.ReadFile(src)
+  if err != nil {
+    return fmt.Errorf("Could not read %s: %s", src, err.Error())
+  }
+  dst := GetWorkspaceVarsFile(to)
+  if dst == "" || project.HasFile(dst) {
+    return nil
+  }
+  PrintInfo("Created %s from %s, edit it to customize the %s environment", Bold(dst), Bold(src), Bold(to))
+  return project.WriteFile(dst, contents)
+}
@@ -0,0 +1,123 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginSpecCmdExamples struct {
+}
+
+func (p *PluginSpecCmdExamples) GetName() string {
+  return "wheels-examples"
+}
+
+func (p *PluginSpecCmdExamples) GetDescription() string {
+  return "Lists the example clusters, or creates one to customize"
+}
+
+func (p *PluginSpecCmdExamples) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOutput := fSet.String("o", "", "Where to write the spec of the example (defaults to <name>.yaml)")
+  fSpecOnly := fSet.Bool("spec-only", false, "Only write the spec of the example, without creating the cluster files")
+  fForce := fSet.Bool("force", false, "Overwrite the spec if it already exists")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "list|use <name>", []interface{}{
+      "This command lists the example clusters shipped with the wrapper, or",
+      "writes the spec of one of them in the project and creates its cluster",
+      "files, as wheels-create does. Customize the spec, then run",
+      fmt.Sprintf("`%s wheels-create -f <name>.yaml` again to update the files.", os.Args[0]),
+    }, fSet)
+    return nil
+  }
+
+  switch fSet.Arg(0) {
+  case "list":
+    return p.list()
+
+  case "use":
+    name := fSet.Arg(1)
+    if name == "" {
+      return fmt.Errorf("Please give the name of the example, see `%s %s list`", os.Args[0], p.GetName())
+    }
+    return p.use(name, *fOutput, *fSpecOnly, *fForce, project, tf)
+  }
+
+  return fmt.Errorf("Unknown action '%s', use %s %s -help to see the available ones", fSet.Arg(0), os.Args[0], p.GetName())
+}
+
+/**
+ * Prints the available examples with their description
+ */
+func (p *PluginSpecCmdExamples) list() error {
+  names, err := ListClusterExamples()
+  if err != nil {
+    return err
+  }
+
+  for _, name := range names {
+    description, err := GetClusterExampleDescription(name)
+    if err != nil {
+      PrintWarning("%s", err.Error())
+      continue
+    }
+    Printf("%-16s %s\n", name, description)
+  }
+  return nil
+}
+
+/**
+ * Writes the spec of the given example in the project, and creates the
+ * cluster files from it
+ */
+func (p *PluginSpecCmdExamples) use(name string, output string, specOnly bool, force bool, project *ProjectSandbox, tf *TerraformWrapper) error {
+  content, err := ReadClusterExample(name)
+  if err != nil {
+    return err
+  }
+
+  if output == "" {
+    output = name + ".yaml"
+  }
+  if _, err := os.Stat(output); err == nil && !force {
+    return fmt.Errorf("%s already exists, use -force to overwrite it or -o to write the example elsewhere", output)
+  }
+  if err := ioutil.WriteFile(output, content, 0644); err != nil {
+    return fmt.Errorf("Could not write %s: %s", output, err.Error())
+  }
+  PrintInfo("%s%s", Bold("Wrote the spec of the example to "), Bold(Green(output)))
+  if specOnly {
+    PrintInfo("Customize it, then create the cluster files with %s", Bold(fmt.Sprintf("%s wheels-create -f %s", os.Args[0], output)))
+    return nil
+  }
+
+  spec, err := LoadClusterSpec(output)
+  if err != nil {
+    return err
+  }
+  cmdArgs, err := getClusterSpecArgs(spec)
+  if err != nil {
+    return err
+  }
+
+  cmd := &PluginDcosAwsCmdAddCluster{CreatePluginDcosAws()}
+  if err := cmd.Handle(cmdArgs, project, tf); err != nil {
+    return err
+  }
+  PrintInfo("Customize %s and run %s to update the cluster files", Bold(output), Bold(fmt.Sprintf("%s wheels-create -f %s", os.Args[0], output)))
+  PrintInfo("Review the cluster with %s, then create it with %s", Bold(os.Args[0]+" plan -out=plan.out"), Bold(os.Args[0]+" apply plan.out"))
+  return nil
+}
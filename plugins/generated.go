@@ -0,0 +1,110 @@
+package plugins
+
+import (
+  "flag"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginGenerated struct {
+}
+
+func CreatePluginGenerated() *PluginGenerated {
+  return &PluginGenerated{}
+}
+
+func (p *PluginGenerated) GetName() string {
+  return "generated"
+}
+
+func (p *PluginGenerated) Requires() []string {
+  return nil
+}
+
+func (p *PluginGenerated) Priority() int {
+  return 0
+}
+
+func (p *PluginGenerated) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginGenerated) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginGenerated) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginGenerated) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginGeneratedCmdGenerated{},
+  }
+}
+
+type PluginGeneratedCmdGenerated struct {
+}
+
+func (p *PluginGeneratedCmdGenerated) GetName() string {
+  return "wheels-generated"
+}
+
+func (p *PluginGeneratedCmdGenerated) GetDescription() string {
+  return "Lists (or removes) the files generated by the add-* commands"
+}
+
+func (p *PluginGeneratedCmdGenerated) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fRemove := fSet.String("remove", "", "Remove all the files generated by the given command (eg. add-aws-cluster)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will list the files in the project that were generated by",
+      "each add-* command. These files are regenerated together when the command",
+      "runs again, and can be removed together with -remove.",
+    }, fSet)
+    return nil
+  }
+
+  if *fRemove != "" {
+    files, err := project.RemoveTerraformFileGroup(*fRemove)
+    if err != nil {
+      return err
+    }
+    PrintInfo("Removed %s", Bold(strings.Join(files, ", ")))
+    return nil
+  }
+
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return err
+  }
+  if len(groups) == 0 {
+    PrintInfo("No files were generated in this project")
+    return nil
+  }
+
+  var names []string
+  for name := range groups {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    Printf("%s:\n", Bold(name))
+    for _, file := range groups[name] {
+      Printf("  %s\n", file)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,120 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os/exec"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginGitInit struct {
+}
+
+func CreatePluginGitInit() *PluginGitInit {
+  return &PluginGitInit{}
+}
+
+func (p *PluginGitInit) GetName() string {
+  return "git-init"
+}
+
+func (p *PluginGitInit) Requires() []string {
+  return nil
+}
+
+func (p *PluginGitInit) Priority() int {
+  return 0
+}
+
+func (p *PluginGitInit) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginGitInit) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginGitInit) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginGitInit) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginGitInitCmdInit{},
+  }
+}
+
+type PluginGitInitCmdInit struct {
+}
+
+func (p *PluginGitInitCmdInit) GetName() string {
+  return "wheels-git-init"
+}
+
+func (p *PluginGitInitCmdInit) GetDescription() string {
+  return "Initializes a git repository with the project, in a first commit"
+}
+
+func (p *PluginGitInitCmdInit) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fMessage := fSet.String("m", "Initial cluster configuration", "The message of the first commit")
+  fForce := fSet.Bool("force", false, "Commit even if there are secrets in plain text")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will initialize a git repository in the project directory,",
+      "with a .gitignore that keeps the state, the caches and the secrets out,",
+      "and commit the configuration. A README describing the cluster is added",
+      "if there is none.",
+    }, fSet)
+    return nil
+  }
+
+  if _, err := exec.LookPath("git"); err != nil {
+    return fmt.Errorf("Could not find git, please install it first")
+  }
+  dir := project.GetFilePath("")
+  if code, _, _, _ := ExecuteAndCollect(nil, "git", "-C", dir, "rev-parse", "--is-inside-work-tree"); code == 0 {
+    return fmt.Errorf("The project is already in a git repository")
+  }
+
+  if err := project.BootstrapRepository(); err != nil {
+    return err
+  }
+  findings, err := ScanSecrets(dir)
+  if err != nil {
+    return err
+  }
+  if len(findings) > 0 && !*fForce {
+    for _, finding := range findings {
+      Printf("%s %s:%d: %s\n", Red("Secret:"), finding.File, finding.Line, finding.Kind)
+    }
+    return fmt.Errorf("Found %d secret(s) that would be committed, move them out or use -force", len(findings))
+  }
+
+  for _, gitArgs := range [][]string{
+    {"init", "-q"},
+    {"add", "-A"},
+    {"commit", "-q", "-m", *fMessage},
+  } {
+    code, err := ExecuteInFolderAndPassthrough(dir, "git", gitArgs...)
+    if err != nil {
+      return fmt.Errorf("Could not run git %s: %s", gitArgs[0], err.Error())
+    }
+    if code != 0 {
+      return fmt.Errorf("git %s failed with exit code %d", gitArgs[0], code)
+    }
+  }
+
+  PrintInfo("Created a git repository in %s, you can now add a remote and push it", Bold(dir))
+  return nil
+}
@@ -0,0 +1,252 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os/exec"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// The nodes of the terraform graph that are not resources of the cluster
+var graphIgnoredKinds map[string]bool = map[string]bool{
+  "provider":    true,
+  "provisioner": true,
+  "var":         true,
+  "output":      true,
+  "local":       true,
+  "meta":        true,
+  "data":        true,
+  "root":        true,
+}
+
+var graphNodePattern *regexp.Regexp = regexp.MustCompile(`"\[root\] ([^"]+)"`)
+var graphEdgePattern *regexp.Regexp = regexp.MustCompile(`^\s*"\[root\] ([^"]+)" -> "\[root\] ([^"]+)"`)
+
+type PluginGraph struct {
+}
+
+func CreatePluginGraph() *PluginGraph {
+  return &PluginGraph{}
+}
+
+func (p *PluginGraph) GetName() string {
+  return "graph"
+}
+
+func (p *PluginGraph) Requires() []string {
+  return []string{}
+}
+
+func (p *PluginGraph) Priority() int {
+  return 0
+}
+
+func (p *PluginGraph) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginGraph) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginGraph) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginGraph) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginGraphCmdGraph{},
+  }
+}
+
+type PluginGraphCmdGraph struct {
+}
+
+func (p *PluginGraphCmdGraph) GetName() string {
+  return "wheels-graph"
+}
+
+func (p *PluginGraphCmdGraph) GetDescription() string {
+  return "Renders the graph of the components of the cluster"
+}
+
+func (p *PluginGraphCmdGraph) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOutput := fSet.String("o", "cluster-graph.svg", "The file to write, as SVG, PNG or DOT depending on its extension")
+  fDepth := fSet.Int("depth", 3, "How many levels of modules to show, the deeper ones are shown as their module")
+  fAll := fSet.Bool("all", false, "Show the whole terraform graph, with the providers and variables")
+  fOpen := fSet.Bool("open", false, "Open the graph once rendered")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will render the graph of the cluster, from `terraform graph`,",
+      "with only its resources and modules and how they depend on each other.",
+      "The SVG and PNG files are rendered with `dot`, from Graphviz.",
+    }, fSet)
+    return nil
+  }
+
+  format := strings.TrimPrefix(strings.ToLower(filepath.Ext(*fOutput)), ".")
+  if format != "svg" && format != "png" && format != "dot" {
+    return fmt.Errorf("Unknown format '%s', the file must end with .svg, .png or .dot", format)
+  }
+
+  graph, err := tf.GetGraph()
+  if err != nil {
+    return err
+  }
+  if !*fAll {
+    graph = filterClusterGraph(graph, *fDepth)
+  }
+
+  if format == "dot" {
+    if err := ioutil.WriteFile(*fOutput, []byte(graph), 0644); err != nil {
+      return fmt.Errorf("Could not write %s: %s", *fOutput, err.Error())
+    }
+  } else {
+    dotPath, err := exec.LookPath(ExecutableName("dot"))
+    if err != nil {
+      dotFile := strings.TrimSuffix(*fOutput, filepath.Ext(*fOutput)) + ".dot"
+      if err := ioutil.WriteFile(dotFile, []byte(graph), 0644); err != nil {
+        return fmt.Errorf("Could not write %s: %s", dotFile, err.Error())
+      }
+      return fmt.Errorf("Could not find `dot` to render the graph, please install Graphviz. The graph was written in %s", dotFile)
+    }
+
+    cmd := exec.Command(dotPath, "-T"+format, "-o", *fOutput)
+    cmd.Stdin = strings.NewReader(graph)
+    log := LogCommand(nil, dotPath, cmd.Args[1:])
+    out, err := cmd.CombinedOutput()
+    log.Exited(cmd, err)
+    if err != nil {
+      return fmt.Errorf("Could not render the graph: %s", strings.TrimSpace(string(out)))
+    }
+  }
+
+  PrintInfo("Rendered the graph of the cluster in %s", Bold(*fOutput))
+  if *fOpen {
+    path, err := filepath.Abs(*fOutput)
+    if err != nil {
+      path = *fOutput
+    }
+    return OpenBrowser(path)
+  }
+  return nil
+}
+
+/**
+ * Returns the component of the cluster a node of the graph belongs to: the
+ * resource itself, or the module that contains it beyond the given depth.
+ * The nodes that are not resources are skipped.
+ */
+func getGraphComponent(node string, depth int) (string, bool) {
+  if strings.HasSuffix(node, "(close)") {
+    return "", false
+  }
+  node = strings.Fields(node)[0]
+
+  parts := strings.Split(node, ".")
+  var component []string
+  for len(parts) > 2 && parts[0] == "module" {
+    if len(component)/2 == depth {
+      return strings.Join(component, "."), true
+    }
+    component = append(component, parts[0], parts[1])
+    parts = parts[2:]
+  }
+  if graphIgnoredKinds[parts[0]] || len(parts) < 2 {
+    return "", false
+  }
+  return strings.Join(append(component, parts[0], parts[1]), "."), true
+}
+
+/**
+ * Keeps only the resources and modules of the cluster in the terraform graph,
+ * with the dependencies that went through the other nodes (eg. variables)
+ */
+func filterClusterGraph(graph string, depth int) string {
+  edges := make(map[string][]string)
+  nodes := make(map[string]bool)
+  for _, line := range strings.Split(graph, "\n") {
+    for _, m := range graphNodePattern.FindAllStringSubmatch(line, -1) {
+      nodes[m[1]] = true
+    }
+    if m := graphEdgePattern.FindStringSubmatch(line); m != nil {
+      edges[m[1]] = append(edges[m[1]], m[2])
+    }
+  }
+
+  // The components each node depends on, through the skipped nodes
+  var dependencies func(node string, seen map[string]bool) []string
+  dependencies = func(node string, seen map[string]bool) []string {
+    var ret []string
+    for _, next := range edges[node] {
+      if seen[next] {
+        continue
+      }
+      seen[next] = true
+      if component, ok := getGraphComponent(next, depth); ok {
+        ret = append(ret, component)
+      } else {
+        ret = append(ret, dependencies(next, seen)...)
+      }
+    }
+    return ret
+  }
+
+  components := make(map[string]bool)
+  links := make(map[string]bool)
+  for node := range nodes {
+    from, ok := getGraphComponent(node, depth)
+    if !ok {
+      continue
+    }
+    components[from] = true
+    for _, to := range dependencies(node, map[string]bool{node: true}) {
+      if to != from {
+        links[fmt.Sprintf(`  "%s" -> "%s"`, from, to)] = true
+      }
+    }
+  }
+
+  lines := []string{
+    `digraph {`,
+    `  rankdir = "LR"`,
+    `  node [shape = "box", style = "rounded,filled", fillcolor = "white", fontname = "Helvetica"]`,
+  }
+  var names []string
+  for component := range components {
+    names = append(names, component)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    // The modules that are not expanded
+    attrs := ""
+    if parts := strings.Split(name, "."); parts[len(parts)-2] == "module" {
+      attrs = `, fillcolor = "lightblue"`
+    }
+    lines = append(lines, fmt.Sprintf(`  "%s" [label = "%s"%s]`, name, name, attrs))
+  }
+  var sortedLinks []string
+  for link := range links {
+    sortedLinks = append(sortedLinks, link)
+  }
+  sort.Strings(sortedLinks)
+  lines = append(lines, sortedLinks...)
+  lines = append(lines, `}`, ``)
+  return strings.Join(lines, "\n")
+}
@@ -59,6 +59,47 @@ type DcosLaunchInputConfig struct {
   IamRolePermissions     []map[string]interface{} `yaml:"iam_role_permissions"`
 }
 
+// The dcos_config options that have their own dcos_<name> variable in the
+// dcos-terraform modules
+var dcosConfigVariables []string = []string{
+  "adminrouter_tls_1_0_enabled", "adminrouter_tls_1_1_enabled",
+  "adminrouter_tls_1_2_enabled", "adminrouter_tls_cipher_suite",
+  "agent_list", "audit_logging", "auth_cookie_secure_flag", "aws_access_key_id",
+  "aws_region", "aws_secret_access_key", "aws_template_storage_access_key_id",
+  "aws_template_storage_bucket", "aws_template_storage_bucket_path",
+  "aws_template_storage_region_name", "aws_template_storage_secret_access_key",
+  "aws_template_upload", "bootstrap_port", "bouncer_expiration_auth_token_days",
+  "ca_certificate_chain_path", "ca_certificate_key_path", "ca_certificate_path",
+  "calico_ipinip_mtu", "calico_network_cidr", "calico_veth_mtu",
+  "calico_vxlan_enabled", "calico_vxlan_mtu", "calico_vxlan_port",
+  "calico_vxlan_vni", "check_time", "cluster_docker_credentials",
+  "cluster_docker_credentials_dcos_owned",
+  "cluster_docker_credentials_enabled",
+  "cluster_docker_credentials_write_to_etc",
+  "cluster_docker_registry_enabled",
+  "cluster_docker_registry_url", "cluster_name", "config", "custom_checks",
+  "customer_key", "dns_bind_ip_blacklist", "dns_forward_zones", "dns_search",
+  "docker_remove_delay", "download_url_checksum", "enable_docker_gc",
+  "enable_gpu_isolation", "enable_mesos_input_plugin", "exhibitor_address",
+  "exhibitor_azure_account_key", "exhibitor_azure_account_name", "exhibitor_azure_prefix",
+  "exhibitor_explicit_keys", "exhibitor_storage_backend", "exhibitor_zk_hosts",
+  "exhibitor_zk_path", "fault_domain_detect_contents", "fault_domain_enabled",
+  "gc_delay", "gpus_are_scarce", "http_proxy", "https_proxy", "image_commit",
+  "instance_os", "ip_detect_contents", "ip_detect_public_contents", "ip_detect_public_filename",
+  "l4lb_enable_ipv6", "license_key_contents", "log_directory", "master_discovery",
+  "master_dns_bindall", "master_external_loadbalancer", "master_list",
+  "mesos_container_log_sink", "mesos_dns_set_truncate_bit",
+  "mesos_max_completed_tasks_per_framework", "no_proxy", "num_masters",
+  "oauth_enabled", "overlay_config_attempts", "overlay_enable", "overlay_mtu",
+  "overlay_network", "package_storage_uri", "previous_version",
+  "previous_version_master_index", "process_timeout", "public_agent_list",
+  "resolvers", "rexray_config", "rexray_config_filename", "rexray_config_method",
+  "s3_bucket", "s3_prefix", "security", "skip_checks", "staged_package_storage_uri",
+  "superuser_password_hash", "superuser_username", "telemetry_enabled",
+  "ucr_default_bridge_subnet", "use_proxy", "variant", "version", "versions_service_url",
+  "zk_agent_credentials", "zk_master_credentials", "zk_super_credentials",
+}
+
 type PluginImportCluster struct {
 }
 
@@ -70,6 +111,14 @@ func (p *PluginImportCluster) GetName() string {
   return "import-cluster"
 }
 
+func (p *PluginImportCluster) Requires() []string {
+  return nil
+}
+
+func (p *PluginImportCluster) Priority() int {
+  return 0
+}
+
 func (p *PluginImportCluster) IsUsed(project *ProjectSandbox) (bool, error) {
   return false, nil
 }
@@ -85,6 +134,7 @@ func (p *PluginImportCluster) AfterRun(project *ProjectSandbox, tf *TerraformWra
 func (p *PluginImportCluster) GetCommands() []PluginCommand {
   return []PluginCommand{
     &PluginImportClusterCmdImport{},
+    &PluginImportClusterCmdImportLaunch{},
   }
 }
 
@@ -145,7 +195,7 @@ func (p *PluginImportClusterCmdImport) importSSHKeys(cfg *DcosLaunchInputConfig,
     fPublicKey = GetPublicKeyNameFromPrivate(cfg.SshPrivateKeyFilename)
     _, err := os.Stat(fPublicKey)
     if err != nil {
-      return nil, fmt.Errorf("Did not find the respective public key for %s (looking at %s)", fPublicKey)
+      return nil, fmt.Errorf("Did not find the respective public key for %s (looking at %s)", cfg.SshPrivateKeyFilename, fPublicKey)
     }
 
     return []string{
@@ -154,8 +204,6 @@ func (p *PluginImportClusterCmdImport) importSSHKeys(cfg *DcosLaunchInputConfig,
   } else {
     return nil, fmt.Errorf("Please use one of: `key_helper`, `ssh_private_key` or `ssh_private_key_filename`")
   }
-
-  return nil, nil
 }
 
 func (p *PluginImportClusterCmdImport) importDcosConfig(cfg map[string]interface{}, project *ProjectSandbox) ([]string, error) {
@@ -166,48 +214,9 @@ func (p *PluginImportClusterCmdImport) importDcosConfig(cfg map[string]interface
     return nil, nil
   }
 
-  mapVars := []string{
-    "adminrouter_tls_1_0_enabled", "adminrouter_tls_1_1_enabled",
-    "adminrouter_tls_1_2_enabled", "adminrouter_tls_cipher_suite",
-    "agent_list", "audit_logging", "auth_cookie_secure_flag", "aws_access_key_id",
-    "aws_region", "aws_secret_access_key", "aws_template_storage_access_key_id",
-    "aws_template_storage_bucket", "aws_template_storage_bucket_path",
-    "aws_template_storage_region_name", "aws_template_storage_secret_access_key",
-    "aws_template_upload", "bootstrap_port", "bouncer_expiration_auth_token_days",
-    "ca_certificate_chain_path", "ca_certificate_key_path", "ca_certificate_path",
-    "calico_ipinip_mtu", "calico_network_cidr", "calico_veth_mtu",
-    "calico_vxlan_enabled", "calico_vxlan_mtu", "calico_vxlan_port",
-    "calico_vxlan_vni", "check_time", "cluster_docker_credentials",
-    "cluster_docker_credentials_dcos_owned",
-    "cluster_docker_credentials_enabled",
-    "cluster_docker_credentials_write_to_etc",
-    "cluster_docker_registry_enabled",
-    "cluster_docker_registry_url", "cluster_name", "config", "custom_checks",
-    "customer_key", "dns_bind_ip_blacklist", "dns_forward_zones", "dns_search",
-    "docker_remove_delay", "download_url_checksum", "enable_docker_gc",
-    "enable_gpu_isolation", "enable_mesos_input_plugin", "exhibitor_address",
-    "exhibitor_azure_account_key", "exhibitor_azure_account_name", "exhibitor_azure_prefix",
-    "exhibitor_explicit_keys", "exhibitor_storage_backend", "exhibitor_zk_hosts",
-    "exhibitor_zk_path", "fault_domain_detect_contents", "fault_domain_enabled",
-    "gc_delay", "gpus_are_scarce", "http_proxy", "https_proxy", "image_commit",
-    "instance_os", "ip_detect_contents", "ip_detect_public_contents", "ip_detect_public_filename",
-    "l4lb_enable_ipv6", "license_key_contents", "log_directory", "master_discovery",
-    "master_dns_bindall", "master_external_loadbalancer", "master_list",
-    "mesos_container_log_sink", "mesos_dns_set_truncate_bit",
-    "mesos_max_completed_tasks_per_framework", "no_proxy", "num_masters",
-    "oauth_enabled", "overlay_config_attempts", "overlay_enable", "overlay_mtu",
-    "overlay_network", "package_storage_uri", "previous_version",
-    "previous_version_master_index", "process_timeout", "public_agent_list",
-    "resolvers", "rexray_config", "rexray_config_filename", "rexray_config_method",
-    "s3_bucket", "s3_prefix", "security", "skip_checks", "staged_package_storage_uri",
-    "superuser_password_hash", "superuser_username", "telemetry_enabled",
-    "ucr_default_bridge_subnet", "use_proxy", "variant", "version", "versions_service_url",
-    "zk_agent_credentials", "zk_master_credentials", "zk_super_credentials",
-  }
-
   for k, iv := range cfg {
     hasMapping := false
-    for _, n := range mapVars {
+    for _, n := range dcosConfigVariables {
       if n == k {
         hasMapping = true
         break
@@ -526,7 +535,7 @@ func (p *PluginImportClusterCmdImport) Handle(args []string, project *ProjectSan
 
   help := fSet.Bool("help", false, "Show this help message")
   fSet.BoolVar(help, "h", false, "Show this help message")
-  err := fSet.Parse(args)
+  err := ParseCommandFlags(fSet, args)
   if err != nil {
     return err
   }
@@ -594,8 +603,7 @@ func (p *PluginImportClusterCmdImport) Handle(args []string, project *ProjectSan
     `}`,
     ``,
     `module "dcos" {`,
-    `  source  = "dcos-terraform/dcos/aws"`,
-    fmt.Sprintf(`  version = "~> %s"`, GetLatestModuleVersion("0.2.0")),
+    GetModuleSource("dcos-terraform/dcos/aws", "0.2.0"),
     ``,
     `  providers = {`,
     `    aws = "aws"`,
@@ -0,0 +1,409 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "regexp"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+  "gopkg.in/yaml.v3"
+)
+
+type PluginImportClusterCmdImportLaunch struct {
+}
+
+func (p *PluginImportClusterCmdImportLaunch) GetName() string {
+  return "import-dcos-launch"
+}
+
+func (p *PluginImportClusterCmdImportLaunch) GetDescription() string {
+  return "Converts a dcos-launch YAML configuration to the equivalent add-aws-cluster"
+}
+
+func (p *PluginImportClusterCmdImportLaunch) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  var helpCmdline = "filename.yaml"
+  var helpMessage = []interface{}{
+    "This command converts the given dcos-launch YAML configuration file into the",
+    "equivalent add-aws-cluster flags, prints the command line and runs it. Unlike",
+    "import-cluster, the result is a regular terraform-wheels cluster that can be",
+    "regenerated and extended like any other.",
+    "",
+    "Both the `onprem` provider on the `aws` platform and the CloudFormation",
+    "templates (`aws` provider) are supported. What cannot be converted is",
+    "reported as a warning.",
+  }
+
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fPrint := fSet.Bool("print", false, "Only print the equivalent command line, without creating the cluster files")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), helpCmdline, helpMessage, fSet)
+    return nil
+  }
+
+  if len(fSet.Args()) < 1 {
+    PrintHelp(p.GetName(), helpCmdline, helpMessage, fSet)
+    return fmt.Errorf("Please specify the path to the configuration YAML to load")
+  }
+
+  cfgFilename := fSet.Args()[0]
+  configContents, err := ioutil.ReadFile(cfgFilename)
+  if err != nil {
+    return fmt.Errorf("Could not load %s: %s", cfgFilename, err.Error())
+  }
+
+  var inputConfig DcosLaunchInputConfig
+  err = yaml.Unmarshal(configContents, &inputConfig)
+  if err != nil {
+    return fmt.Errorf("Could not parse %s: %s", cfgFilename, err.Error())
+  }
+
+  var cmdArgs []string
+  switch inputConfig.Provider {
+  case "onprem":
+    if inputConfig.Platform != "aws" {
+      return fmt.Errorf("Unsupported platform '%s' we only support: aws", inputConfig.Platform)
+    }
+    cmdArgs, err = convertLaunchOnpremAws(&inputConfig, *fPrint)
+  case "aws":
+    cmdArgs, err = convertLaunchTemplate(&inputConfig)
+  default:
+    return fmt.Errorf("Unsupported provider '%s' we only support: onprem, aws", inputConfig.Provider)
+  }
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("The equivalent of %s is:", Bold(cfgFilename))
+  Println(formatCommandLine(append([]string{os.Args[0], "add-aws-cluster"}, cmdArgs...)))
+  if *fPrint {
+    return nil
+  }
+
+  cmd := &PluginDcosAwsCmdAddCluster{CreatePluginDcosAws()}
+  if err := cmd.Handle(cmdArgs, project, tf); err != nil {
+    return err
+  }
+  PrintInfo("Review the cluster with %s, then create it with %s", Bold(os.Args[0]+" plan -out=plan.out"), Bold(os.Args[0]+" apply plan.out"))
+  return nil
+}
+
+/**
+ * Converts the `onprem` provider on the `aws` platform, that installs DC/OS
+ * on plain instances like the universal installer does
+ */
+func convertLaunchOnpremAws(cfg *DcosLaunchInputConfig, printOnly bool) ([]string, error) {
+  var args []string
+
+  if cfg.GenconfDir != "" {
+    return nil, fmt.Errorf("Custom `genconf_dir` is not supported with terraform")
+  }
+
+  if cfg.DeploymentName != "" {
+    args = append(args, "-cluster_name="+cfg.DeploymentName)
+  }
+  if cfg.NumMasters != 0 {
+    args = append(args, fmt.Sprintf("-num_masters=%d", cfg.NumMasters))
+  }
+  if cfg.NumPrivateAgents != 0 {
+    args = append(args, fmt.Sprintf("-num_private_agents=%d", cfg.NumPrivateAgents))
+  }
+  if cfg.NumPublicAgents != 0 {
+    args = append(args, fmt.Sprintf("-num_public_agents=%d", cfg.NumPublicAgents))
+  }
+  if cfg.InstanceType != "" {
+    args = append(args,
+      "-masters_instance_type="+cfg.InstanceType,
+      "-private_agents_instance_type="+cfg.InstanceType,
+      "-public_agents_instance_type="+cfg.InstanceType,
+    )
+  }
+
+  if cfg.OsName != "" {
+    if osName := guessLaunchOS(cfg.OsName); osName != "" {
+      args = append(args, "-os="+osName)
+    } else {
+      PrintWarning("Not importing `os_name: %s`, use -os or -ami to choose the operating system", cfg.OsName)
+    }
+  }
+
+  if cfg.DcosInstallerUrl != "" {
+    args = append(args, "-custom_dcos_download_path="+cfg.DcosInstallerUrl)
+  }
+  if cfg.DcosVersion != "" {
+    args = append(args, "-dcos_version="+cfg.DcosVersion)
+  }
+
+  // Guess DC/OS variant, like import-cluster does
+  if _, ok := cfg.DcosConfig["variant"]; !ok {
+    _, hasLicense := cfg.DcosConfig["license_key_contents"]
+    if cfg.Enterprise || hasLicense || strings.Contains(cfg.DcosInstallerUrl, ".ee.") {
+      args = append(args, "-dcos_variant=ee")
+    }
+  }
+
+  if cfg.AdminLocation != "" {
+    args = append(args, "-admin_ips="+cfg.AdminLocation)
+  }
+  if cfg.AwsRegion != "" && cfg.AwsRegion != "us-west-2" {
+    PrintWarning("The cluster is created in us-west-2, change the `region` of the aws provider to %s", cfg.AwsRegion)
+  }
+
+  sshArgs, err := convertLaunchSSHKeys(cfg, printOnly)
+  if err != nil {
+    return nil, err
+  }
+  args = append(args, sshArgs...)
+
+  configArgs, err := convertLaunchDcosConfig(cfg.DcosConfig)
+  if err != nil {
+    return nil, err
+  }
+  args = append(args, configArgs...)
+  args = append(args, convertLaunchTags(cfg.Tags)...)
+
+  if len(cfg.AwsBlockDeviceMappings) > 0 {
+    PrintWarning("Not importing `aws_block_device_mappings`, use import-cluster to keep the extra volumes")
+  }
+  if len(cfg.IamRolePermissions) > 0 {
+    PrintWarning("Not importing `iam_role_permissions`, use -private_agents_iam_instance_profile with an existing profile instead")
+  }
+  if cfg.PrereqsScriptFilename != "" {
+    PrintWarning("Not importing `prereqs_script_filename`, the prerequisites are installed by the universal installer")
+  }
+  if cfg.FaultDomainHelper != "" {
+    PrintWarning("Not importing `fault_domain_helper`, use -dcos_fault_domain_detect_contents instead")
+  }
+
+  return args, nil
+}
+
+/**
+ * Converts the `aws` provider, that deploys the DC/OS CloudFormation
+ * templates, to the closest universal installer cluster
+ */
+func convertLaunchTemplate(cfg *DcosLaunchInputConfig) ([]string, error) {
+  var args []string
+
+  if cfg.TemplateUrl == "" {
+    return nil, fmt.Errorf("Missing `template_url` for the aws provider")
+  }
+  if strings.Contains(cfg.TemplateUrl, "single-master") {
+    args = append(args, "-num_masters=1")
+  } else if strings.Contains(cfg.TemplateUrl, "multi-master") {
+    args = append(args, "-num_masters=3")
+  } else {
+    PrintWarning("Could not guess the number of masters from the template %s", cfg.TemplateUrl)
+  }
+  if m := regexp.MustCompile(`/(\d+\.\d+(\.\d+)?)/`).FindStringSubmatch(cfg.TemplateUrl); m != nil {
+    args = append(args, "-dcos_version="+m[1])
+  }
+  if cfg.Enterprise || strings.Contains(cfg.TemplateUrl, "/ee/") || strings.Contains(cfg.TemplateUrl, "downloads.mesosphere.com") {
+    args = append(args, "-dcos_variant=ee")
+  }
+
+  // The parameters of the templates, in the order they are converted
+  params := []struct {
+    name string
+    flag string
+  }{
+    {"SlaveInstanceCount", "num_private_agents"},
+    {"PublicSlaveInstanceCount", "num_public_agents"},
+    {"MasterInstanceType", "masters_instance_type"},
+    {"PrivateAgentInstanceType", "private_agents_instance_type"},
+    {"PublicAgentInstanceType", "public_agents_instance_type"},
+    {"AdminLocation", "admin_ips"},
+    {"KeyName", "aws_key_name"},
+  }
+  converted := make(map[string]bool)
+  for _, param := range params {
+    v, ok := cfg.TemplateParameters[param.name]
+    if !ok {
+      continue
+    }
+    args = append(args, fmt.Sprintf("-%s=%v", param.flag, v))
+    if param.flag == "aws_key_name" {
+      args = append(args, "-ssh_public_key_file=")
+    }
+    converted[param.name] = true
+  }
+
+  var ignored []string
+  for name := range cfg.TemplateParameters {
+    if !converted[name] {
+      ignored = append(ignored, name)
+    }
+  }
+  if len(ignored) > 0 {
+    sort.Strings(ignored)
+    PrintWarning("Not importing the template parameters: %s", strings.Join(ignored, ", "))
+  }
+
+  args = append(args, convertLaunchTags(cfg.Tags)...)
+  return args, nil
+}
+
+/**
+ * Converts the SSH key of the cluster. A key given by contents is written
+ * to the project, unless only the command line is printed.
+ */
+func convertLaunchSSHKeys(cfg *DcosLaunchInputConfig, printOnly bool) ([]string, error) {
+  if cfg.AwsKeyName != "" {
+    return []string{"-aws_key_name=" + cfg.AwsKeyName, "-ssh_public_key_file="}, nil
+  }
+
+  if cfg.SshPrivateKeyFilename != "" {
+    fPublicKey := GetPublicKeyNameFromPrivate(cfg.SshPrivateKeyFilename)
+    if _, err := os.Stat(fPublicKey); err != nil {
+      return nil, fmt.Errorf("Did not find the respective public key for %s (looking at %s)", cfg.SshPrivateKeyFilename, fPublicKey)
+    }
+    return []string{"-ssh_public_key_file=" + fPublicKey}, nil
+  }
+
+  if cfg.SshPrivateKey != "" {
+    fPublicKey := "cluster-key.pub"
+    fPrivateKey := GetPrivateKeyNameFromPublic(fPublicKey)
+    if printOnly {
+      PrintWarning("The `ssh_private_key` is written to %s when the cluster is imported", fPrivateKey)
+      return nil, nil
+    }
+    if _, err := os.Stat(fPrivateKey); err == nil {
+      return nil, fmt.Errorf("Not overwriting the existing %s with `ssh_private_key`", fPrivateKey)
+    }
+
+    PrintInfo("Dumping private/public key pair from private key contents")
+    privateKeyBytes := []byte(cfg.SshPrivateKey)
+    if err := ioutil.WriteFile(fPrivateKey, privateKeyBytes, 0600); err != nil {
+      return nil, fmt.Errorf("Error writing private key %s: %s", fPrivateKey, err.Error())
+    }
+    if err := CreatePublicRSAKeyFromPrivate(privateKeyBytes, fPublicKey); err != nil {
+      return nil, fmt.Errorf("Error writing public key %s: %s", fPublicKey, err.Error())
+    }
+    return nil, nil
+  }
+
+  // With `key_helper` (or nothing) the cluster key of the project is used
+  return nil, nil
+}
+
+/**
+ * Converts the DC/OS configuration. The options with their own variable are
+ * given with their flag, the others are kept in -dcos_config.
+ */
+func convertLaunchDcosConfig(cfg map[string]interface{}) ([]string, error) {
+  var args []string
+  rawDcosConfig := make(map[string]interface{})
+
+  var keys []string
+  for k := range cfg {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
+  for _, k := range keys {
+    hasMapping := false
+    for _, n := range dcosConfigVariables {
+      if n == k {
+        hasMapping = true
+        break
+      }
+    }
+
+    switch v := cfg[k].(type) {
+    case map[string]interface{}, []interface{}:
+      // The flags can only give strings
+      rawDcosConfig[k] = v
+    default:
+      if k == "license_key_contents" {
+        PrintWarning("Not importing the license, keep it with %s instead", Bold("wheels-license set"))
+      } else if hasMapping {
+        args = append(args, fmt.Sprintf("-dcos_%s=%v", k, v))
+      } else {
+        rawDcosConfig[k] = v
+      }
+    }
+  }
+
+  if len(rawDcosConfig) > 0 {
+    bytes, err := yaml.Marshal(rawDcosConfig)
+    if err != nil {
+      return nil, fmt.Errorf("Could not encode raw DC/OS options: %s", err.Error())
+    }
+    args = append(args, "-dcos_config="+string(bytes))
+  }
+
+  return args, nil
+}
+
+/**
+ * Converts the tags that add-aws-cluster knows about
+ */
+func convertLaunchTags(tags map[string]interface{}) []string {
+  var args []string
+  var ignored []string
+
+  for k, v := range tags {
+    switch k {
+    case "owner":
+      args = append(args, fmt.Sprintf("-owner=%v", v))
+    case "expiration":
+      args = append(args, fmt.Sprintf("-expiration=%v", v))
+    default:
+      ignored = append(ignored, fmt.Sprintf("%s=%v", k, v))
+    }
+  }
+  sort.Strings(args)
+
+  if len(ignored) > 0 {
+    sort.Strings(ignored)
+    PrintWarning("Not importing the tags %s, add them to the `tags` of the cluster module", strings.Join(ignored, ", "))
+  }
+  return args
+}
+
+/**
+ * Guesses the -os from the dcos-launch `os_name`, eg. cent-os-7-dcos-prereqs
+ */
+func guessLaunchOS(name string) string {
+  name = strings.ToLower(name)
+  switch {
+  case strings.Contains(name, "cent"):
+    return "centos"
+  case strings.Contains(name, "rhel"):
+    return "rhel"
+  case strings.Contains(name, "flatcar"):
+    return "flatcar"
+  case strings.Contains(name, "coreos"):
+    return "coreos"
+  }
+  return ""
+}
+
+/**
+ * Formats the arguments as a command line that can be pasted in a shell
+ */
+func formatCommandLine(args []string) string {
+  safe := regexp.MustCompile(`^[A-Za-z0-9_./:=,@%+-]+$`)
+
+  var quoted []string
+  for _, arg := range args {
+    if safe.MatchString(arg) {
+      quoted = append(quoted, arg)
+    } else {
+      quoted = append(quoted, "'"+strings.Replace(arg, "'", `'\''`, -1)+"'")
+    }
+  }
+  return strings.Join(quoted, " ")
+}
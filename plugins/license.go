@@ -0,0 +1,159 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginLicense struct {
+  pluginLogger
+}
+
+func CreatePluginLicense() *PluginLicense {
+  return &PluginLicense{}
+}
+
+func (p *PluginLicense) GetName() string {
+  return "license"
+}
+
+func (p *PluginLicense) Requires() []string {
+  return nil
+}
+
+func (p *PluginLicense) Priority() int {
+  return 0
+}
+
+func (p *PluginLicense) IsUsed(project *ProjectSandbox) (bool, error) {
+  // The license is given to the projects that declare its variable
+  _, ok := project.GetTerraformResources("variable")[DcosLicenseVariable]
+  return ok, nil
+}
+
+func (p *PluginLicense) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  license, err := GetDcosLicense()
+  if err != nil {
+    return err
+  }
+  if license == "" {
+    err := fmt.Errorf("This project needs a DC/OS Enterprise license, please set it with `%s wheels-license set <file>`", os.Args[0])
+    if initRun {
+      p.log.Warn(err.Error())
+      return nil
+    }
+    return err
+  }
+
+  // Given through the environment, so it's never written in the project
+  tf.SetEnv("TF_VAR_"+DcosLicenseVariable, license)
+  return nil
+}
+
+func (p *PluginLicense) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginLicense) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginLicenseCmdLicense{},
+  }
+}
+
+type PluginLicenseCmdLicense struct {
+}
+
+func (p *PluginLicenseCmdLicense) GetName() string {
+  return "wheels-license"
+}
+
+func (p *PluginLicenseCmdLicense) GetDescription() string {
+  return "Keeps your DC/OS Enterprise license in the OS keychain"
+}
+
+func (p *PluginLicenseCmdLicense) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fReveal := fSet.Bool("reveal", false, "Show the whole license with `show`")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "set [<file>] | show | unset", []interface{}{
+      "This command will keep your DC/OS Enterprise license in the OS keychain",
+      "(or an encrypted file when there is none), so it does not need to be given",
+      "on the command-line, where it would land in the shell history.",
+      "",
+      "The license is read from the given file, or prompted for. The projects",
+      "created with `add-aws-cluster -dcos_variant=ee` receive it through the",
+      fmt.Sprintf("%s variable.", DcosLicenseVariable),
+    }, fSet)
+    return nil
+  }
+
+  // The options can also come after the action
+  action := fSet.Arg(0)
+  if err := ParseCommandFlags(fSet, fSet.Args()[1:]); err != nil {
+    return err
+  }
+
+  switch action {
+  case "set":
+    var license string
+    if file := fSet.Arg(0); file != "" {
+      content, err := ioutil.ReadFile(file)
+      if err != nil {
+        return fmt.Errorf("Could not read the license: %s", err.Error())
+      }
+      license = string(content)
+    } else if IsInteractive() {
+      license, err = ReadPassword("DC/OS Enterprise license")
+      if err != nil {
+        return err
+      }
+    } else {
+      content, err := ioutil.ReadAll(os.Stdin)
+      if err != nil {
+        return fmt.Errorf("Could not read the license: %s", err.Error())
+      }
+      license = string(content)
+    }
+    if err := SetDcosLicense(license); err != nil {
+      return err
+    }
+    PrintInfo("The license was saved, you can now remove the file it came from")
+
+  case "show":
+    license, err := GetDcosLicense()
+    if err != nil {
+      return err
+    }
+    if license == "" {
+      return fmt.Errorf("No license was set, use `%s wheels-license set <file>`", os.Args[0])
+    }
+    if *fReveal {
+      Println(license)
+    } else {
+      PrintInfo("The license is %s (use -reveal to see it all)", Bold(MaskSecret(license)))
+    }
+
+  case "unset":
+    if err := DeleteDcosLicense(); err != nil {
+      return err
+    }
+    PrintInfo("The license was removed")
+
+  default:
+    return fmt.Errorf("Unknown action '%s', expecting one of: set, show, unset", action)
+  }
+  return nil
+}
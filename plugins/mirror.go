@@ -0,0 +1,165 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginMirror struct {
+}
+
+func CreatePluginMirror() *PluginMirror {
+  return &PluginMirror{}
+}
+
+func (p *PluginMirror) GetName() string {
+  return "mirror"
+}
+
+func (p *PluginMirror) Requires() []string {
+  return nil
+}
+
+func (p *PluginMirror) Priority() int {
+  return 0
+}
+
+func (p *PluginMirror) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginMirror) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginMirror) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginMirror) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginMirrorCmdMirror{},
+  }
+}
+
+type PluginMirrorCmdMirror struct {
+}
+
+func (p *PluginMirrorCmdMirror) GetName() string {
+  return "wheels-mirror"
+}
+
+func (p *PluginMirrorCmdMirror) GetDescription() string {
+  return "Downloads the providers of the project in a local mirror directory"
+}
+
+func (p *PluginMirrorCmdMirror) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fDir := fSet.String("dir", "", "The mirror directory (defaults to the offline mirror)")
+  fPlatforms := fSet.String("platform", GetCurrentPlatform(), "Comma-separated platforms to download the providers for")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command finds the providers required by the project and its modules,",
+      "downloads the most recent compatible version of each one in the mirror",
+      "directory, and writes a terraform CLI configuration that uses it.",
+      "",
+      "The next `init` only downloads the providers missing from the mirror, and",
+      "the mirror can be copied to machines without internet access and used",
+      "with `--offline`.",
+    }, fSet)
+    return nil
+  }
+
+  if IsOffline() {
+    return fmt.Errorf("Cannot populate the mirror in offline mode")
+  }
+
+  dir := *fDir
+  if dir == "" {
+    dir, err = GetMirrorDir()
+    if err != nil {
+      return err
+    }
+  }
+  dir, err = filepath.Abs(dir)
+  if err != nil {
+    return err
+  }
+
+  var platforms []string
+  for _, platform := range strings.Split(*fPlatforms, ",") {
+    if platform = strings.TrimSpace(platform); platform != "" {
+      platforms = append(platforms, platform)
+    }
+  }
+  if len(platforms) == 0 {
+    return fmt.Errorf("Please give at least one platform")
+  }
+
+  // The providers of the modules are only known when they are installed
+  if err := tf.Invoke([]string{"get"}); err != nil {
+    return fmt.Errorf("Could not install the modules: %s", err.Error())
+  }
+  providers, err := tf.ListProviders()
+  if err != nil {
+    return err
+  }
+  if len(providers) == 0 {
+    PrintInfo("The project does not use any provider")
+    return nil
+  }
+
+  var names []string
+  for name := range providers {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var mirrored []MirroredProvider
+  for _, name := range names {
+    version, err := ResolveProviderVersion(name, providers[name], platforms)
+    if err != nil {
+      return err
+    }
+    for _, platform := range platforms {
+      provider, err := MirrorProvider(dir, name, version, platform)
+      if err != nil {
+        return err
+      }
+      mirrored = append(mirrored, provider)
+    }
+  }
+
+  cliConfig, err := WriteMirrorCLIConfig(dir)
+  if err != nil {
+    return err
+  }
+
+  Printf("%-20s %-12s %-16s %s\n", "PROVIDER", "VERSION", "PLATFORM", "STATUS")
+  for _, provider := range mirrored {
+    status := "already mirrored"
+    if provider.Downloaded {
+      status = "downloaded"
+    }
+    Printf("%-20s %-12s %-16s %s\n", provider.Name, provider.Version, provider.Platform, status)
+  }
+
+  PrintInfo("The providers are mirrored in %s", Bold(filepath.Join(dir, "plugins")))
+  PrintInfo("%s uses them from now on, for plain terraform use: export TF_CLI_CONFIG_FILE=%s", os.Args[0], cliConfig)
+  return nil
+}
@@ -0,0 +1,288 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginModules struct {
+}
+
+func CreatePluginModules() *PluginModules {
+  return &PluginModules{}
+}
+
+func (p *PluginModules) GetName() string {
+  return "modules"
+}
+
+func (p *PluginModules) Requires() []string {
+  return nil
+}
+
+func (p *PluginModules) Priority() int {
+  return 0
+}
+
+func (p *PluginModules) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginModules) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginModules) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginModules) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginModulesCmdModules{releases: make(map[string][]ModuleRelease)},
+  }
+}
+
+type PluginModulesCmdModules struct {
+  releases map[string][]ModuleRelease
+}
+
+func (p *PluginModulesCmdModules) GetName() string {
+  return "wheels-modules"
+}
+
+func (p *PluginModulesCmdModules) GetDescription() string {
+  return "Lists the dcos-terraform modules of the project, and upgrades their versions"
+}
+
+func (p *PluginModulesCmdModules) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fTo := fSet.String("to", "", "Pin exactly this version, instead of the latest one")
+  fYes := fSet.Bool("yes", false, "Keep the new versions without asking, after showing the plan")
+  fNoPlan := fSet.Bool("no-plan", false, "Do not show the plan of the upgrade")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "list|changelog <module>|upgrade [module...]", []interface{}{
+      "This command will list the dcos-terraform modules used by the project,",
+      "the version their pin resolves to and the latest release on GitHub.",
+      "",
+      "`changelog` shows the release notes between the current version of a",
+      "module and the latest one (or the one given with -to). `upgrade`",
+      "rewrites the `version` constraints (or the `ref` of the git sources) of",
+      "the given modules, or of all the outdated ones, and shows the plan of",
+      "the changes, so the upgrade can be kept or reverted.",
+    }, fSet)
+    return nil
+  }
+
+  modules, err := project.ListDcosModules()
+  if err != nil {
+    return err
+  }
+  if len(modules) == 0 {
+    PrintInfo("The project does not use any dcos-terraform module")
+    return nil
+  }
+
+  var target *semver.Version
+  if *fTo != "" {
+    if target, err = semver.NewVersion(*fTo); err != nil {
+      return fmt.Errorf("Could not parse the version '%s': %s", *fTo, err.Error())
+    }
+  }
+
+  switch fSet.Arg(0) {
+  case "list":
+    return p.list(modules)
+
+  case "changelog":
+    module, err := findModule(modules, fSet.Arg(1))
+    if err != nil {
+      return err
+    }
+    current, release, err := p.getUpgrade(module, target)
+    if err != nil {
+      return err
+    }
+    printModuleChangelog(p.releases[module.Repository], current, release)
+    return nil
+
+  case "upgrade":
+    selected := modules
+    if fSet.NArg() > 1 {
+      selected = nil
+      for _, name := range fSet.Args()[1:] {
+        module, err := findModule(modules, name)
+        if err != nil {
+          return err
+        }
+        selected = append(selected, module)
+      }
+    }
+    return p.upgrade(project, tf, selected, target, *fYes, *fNoPlan)
+  }
+
+  return fmt.Errorf("Unknown action '%s', use %s %s -help to see the available ones", fSet.Arg(0), os.Args[0], p.GetName())
+}
+
+func findModule(modules []ModuleReference, name string) (ModuleReference, error) {
+  for _, module := range modules {
+    if module.Name == name {
+      return module, nil
+    }
+  }
+  return ModuleReference{}, fmt.Errorf("There is no dcos-terraform module named '%s' in the project", name)
+}
+
+// Returns the release the module is at, and the one to upgrade it to
+func (p *PluginModulesCmdModules) getUpgrade(module ModuleReference, target *semver.Version) (*ModuleRelease, *ModuleRelease, error) {
+  releases, ok := p.releases[module.Repository]
+  if !ok {
+    var err error
+    if releases, err = GetModuleReleases(module.Repository); err != nil {
+      return nil, nil, err
+    }
+    p.releases[module.Repository] = releases
+  }
+  if len(releases) == 0 {
+    return nil, nil, fmt.Errorf("%s has no releases", module.Repository)
+  }
+
+  current := module.ResolvePin(releases)
+  if target == nil {
+    return current, &releases[0], nil
+  }
+  for i := range releases {
+    if releases[i].Version.Equal(target) {
+      return current, &releases[i], nil
+    }
+  }
+  return nil, nil, fmt.Errorf("%s has no %s release", module.Repository, target)
+}
+
+func printModuleChangelog(releases []ModuleRelease, current *ModuleRelease, target *ModuleRelease) {
+  var from *semver.Version
+  if current != nil {
+    from = current.Version
+  }
+  changes := GetModuleChangelog(releases, from, target.Version)
+  if len(changes) == 0 {
+    PrintInfo("Nothing changed between the two versions")
+    return
+  }
+  for _, rls := range changes {
+    Printf("%s\n", Bold(rls.Tag))
+    if rls.Changelog == "" {
+      Printf("  (no release notes)\n\n")
+      continue
+    }
+    for _, line := range strings.Split(rls.Changelog, "\n") {
+      Printf("  %s\n", strings.TrimRight(line, "\r"))
+    }
+    Println()
+  }
+}
+
+func (p *PluginModulesCmdModules) list(modules []ModuleReference) error {
+  Printf("%-20s %-24s %-12s %-10s %s\n", "MODULE", "FILE", "PIN", "CURRENT", "LATEST")
+  for _, module := range modules {
+    pin := module.Pin
+    if pin == "" {
+      pin = "(none)"
+    }
+    current, latest, err := p.getUpgrade(module, nil)
+    if err != nil {
+      PrintWarning("%s: %s", module.Name, err.Error())
+      continue
+    }
+    currentTag := "?"
+    if current != nil {
+      currentTag = current.Tag
+    }
+    latestTag := latest.Tag
+    if current == nil || latest.Version.GreaterThan(current.Version) {
+      latestTag = fmt.Sprintf("%s", Yellow(latest.Tag))
+    }
+    Printf("%-20s %-24s %-12s %-10s %s\n", module.Name, module.File, pin, currentTag, latestTag)
+  }
+  return nil
+}
+
+func (p *PluginModulesCmdModules) upgrade(project *ProjectSandbox, tf *TerraformWrapper, modules []ModuleReference, target *semver.Version, yes bool, noPlan bool) error {
+  originals := make(map[string][]byte)
+  for _, module := range modules {
+    current, release, err := p.getUpgrade(module, target)
+    if err != nil {
+      return err
+    }
+    if current != nil && current.Version.Equal(release.Version) {
+      continue
+    }
+
+    from := "an unknown version"
+    if current != nil {
+      from = current.Tag
+    }
+    if current != nil && current.Version.GreaterThan(release.Version) {
+      PrintInfo("Downgrading %s from %s to %s", Bold(module.Name), from, Bold(release.Tag))
+    } else {
+      PrintInfo("Upgrading %s from %s to %s", Bold(module.Name), from, Bold(release.Tag))
+      printModuleChangelog(p.releases[module.Repository], current, release)
+    }
+
+    // An explicit version is pinned exactly, a constraint could resolve to
+    // a more recent one
+    original, err := project.PinModule(module, *release, target != nil)
+    if err != nil {
+      return err
+    }
+    if _, ok := originals[module.File]; !ok {
+      originals[module.File] = original
+    }
+  }
+  if len(originals) == 0 {
+    PrintInfo("The modules are up to date")
+    return nil
+  }
+  if noPlan {
+    PrintInfo("Updated the pins, run `%s init` and `%s plan` to see what changes", os.Args[0], os.Args[0])
+    return nil
+  }
+
+  // Preview what the new versions change in the cluster
+  if err := AttachAWSCredentials(tf); err != nil {
+    return err
+  }
+  err := tf.Invoke([]string{"get", "-update"})
+  if err == nil {
+    err = tf.Invoke(project.AddWorkspaceVarFile([]string{"plan"}))
+  }
+  if err == nil && (yes || (IsInteractive() && ReadYN("Keep the new module versions?"))) {
+    PrintInfo("Kept the new module versions, run `%s apply` to upgrade the cluster", os.Args[0])
+    return nil
+  }
+
+  for file, content := range originals {
+    if werr := project.WriteFile(file, content); werr != nil {
+      return werr
+    }
+  }
+  tf.Invoke([]string{"get", "-update"})
+  if err != nil {
+    return fmt.Errorf("Reverted the module versions, the plan failed: %s", err.Error())
+  }
+  PrintInfo("Reverted the module versions, use -yes to keep them")
+  return nil
+}
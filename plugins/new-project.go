@@ -0,0 +1,110 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginNewProject struct {
+}
+
+func CreatePluginNewProject() *PluginNewProject {
+  return &PluginNewProject{}
+}
+
+func (p *PluginNewProject) GetName() string {
+  return "new-project"
+}
+
+func (p *PluginNewProject) Requires() []string {
+  return nil
+}
+
+func (p *PluginNewProject) Priority() int {
+  return 0
+}
+
+func (p *PluginNewProject) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginNewProject) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginNewProject) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginNewProject) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginNewProjectCmdNew{},
+  }
+}
+
+type PluginNewProjectCmdNew struct {
+}
+
+func (p *PluginNewProjectCmdNew) GetName() string {
+  return "new"
+}
+
+func (p *PluginNewProjectCmdNew) GetDescription() string {
+  return "Creates a new project directory, optionally with the skeleton of a cluster"
+}
+
+func (p *PluginNewProjectCmdNew) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fTemplate := fSet.String("template", "", "The skeleton of the cluster, one of "+strings.Join(GetProjectTemplates(), ", "))
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() != 1 {
+    PrintHelp(p.GetName(), "[-template=aws|gcp|azure|onprem] <name>", []interface{}{
+      "This command will create a project in the <name> directory, with a",
+      ".wheels.yaml, a .gitignore and a versions.tf that pins the versions of",
+      "terraform and of the providers. With a template, the project also gets",
+      "the skeleton of a cluster in cluster.tf, to adjust before applying it.",
+      "",
+      "Without a template, use add-aws-cluster in the project to generate a",
+      "complete cluster on AWS.",
+    }, fSet)
+    if *help {
+      return nil
+    }
+    return fmt.Errorf("Please give the name of the project")
+  }
+
+  name := fSet.Arg(0)
+  files, err := CreateProject(name, *fTemplate)
+  if err != nil {
+    return err
+  }
+  PrintInfo("%s%s%s", Bold("Created "), Bold(Green(name)), Bold(" with "+strings.Join(files, ", ")))
+
+  next := fmt.Sprintf("%s add-aws-cluster", os.Args[0])
+  if *fTemplate != "" {
+    next = "edit cluster.tf"
+  }
+  PrintMessage([]interface{}{
+    "",
+    "Your next steps are:",
+    "",
+    fmt.Sprintf("  1. cd %s", name),
+    fmt.Sprintf("  2. %s", next),
+    fmt.Sprintf("  3. %s init", os.Args[0]),
+    fmt.Sprintf("  4. %s plan -out=plan.out", os.Args[0]),
+    "",
+  })
+  return nil
+}
@@ -0,0 +1,222 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginPlan struct {
+}
+
+func CreatePluginPlan() *PluginPlan {
+  return &PluginPlan{}
+}
+
+func (p *PluginPlan) GetName() string {
+  return "plan"
+}
+
+func (p *PluginPlan) Requires() []string {
+  return nil
+}
+
+func (p *PluginPlan) Priority() int {
+  return 0
+}
+
+func (p *PluginPlan) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginPlan) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginPlan) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginPlan) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginPlanCmdPlan{},
+    &PluginPlanCmdApprove{},
+    &PluginPlanCmdApply{},
+  }
+}
+
+func printPlanRecord(project *ProjectSandbox, record *PlanRecord) {
+  Printf("%s %s\n", Bold("Plan:"), record.ID)
+  Printf("%s %s on %s\n", Bold("Created:"), record.CreatedBy, record.CreatedAt.Local().Format("2006-01-02 15:04"))
+  if record.GitCommit != "" {
+    dirty := ""
+    if record.GitDirty {
+      dirty = " (with uncommitted changes)"
+    }
+    Printf("%s %s%s\n", Bold("Commit:"), record.GitCommit, dirty)
+  }
+  Printf("%s %d change(s), %s impact\n", Bold("Changes:"), len(record.Changes), record.Impact)
+  Printf("%s %d of %d\n", Bold("Approvals:"), len(record.Approvals), project.GetRequiredApprovals(record))
+}
+
+type PluginPlanCmdPlan struct {
+}
+
+func (p *PluginPlanCmdPlan) GetName() string {
+  return "wheels-plan"
+}
+
+func (p *PluginPlanCmdPlan) GetDescription() string {
+  return "Saves a plan to apply later with wheels-apply-plan, archiving it with who made it and from which commit"
+}
+
+func (p *PluginPlanCmdPlan) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOut := fSet.String("out", "plan.out", "Where to save the plan")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "[-- <plan arguments>]", []interface{}{
+      "This command will run `terraform plan` and save the plan, archiving a copy",
+      "of it in .wheels/plans with a JSON record of who made it, when, from",
+      "which git commit, and the changes it contains.",
+      "",
+      "The plan can then be approved with wheels-approve-plan, if the project",
+      "requires approvals (`plans.required_approvals` in .wheels.yaml), and",
+      "applied with wheels-apply-plan.",
+    }, fSet)
+    return nil
+  }
+
+  if err := AttachAWSCredentials(tf); err != nil {
+    return err
+  }
+  planArgs := append([]string{"plan", "-detailed-exitcode", "-input=false", "-out=" + *fOut}, fSet.Args()...)
+  tf.Invoke(project.AddWorkspaceVarFile(planArgs))
+  if code := tf.GetLastExitCode(); code == 0 {
+    PrintInfo("There is nothing to change, no plan was archived")
+    return nil
+  } else if code != 2 {
+    return fmt.Errorf("The plan failed with exit code %d", code)
+  }
+
+  record, err := project.ArchivePlan(*fOut, tf.GetLastOutput(), fSet.Args())
+  if err != nil {
+    return err
+  }
+  Println()
+  printPlanRecord(project, record)
+  if project.GetRequiredApprovals(record) > 0 {
+    PrintInfo("The plan needs approvals: %s wheels-approve-plan %s", os.Args[0], *fOut)
+  } else {
+    PrintInfo("To apply it: %s wheels-apply-plan %s", os.Args[0], *fOut)
+  }
+  return nil
+}
+
+type PluginPlanCmdApprove struct {
+}
+
+func (p *PluginPlanCmdApprove) GetName() string {
+  return "wheels-approve-plan"
+}
+
+func (p *PluginPlanCmdApprove) GetDescription() string {
+  return "Approves a plan made with wheels-plan"
+}
+
+func (p *PluginPlanCmdApprove) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fBy := fSet.String("by", GetCurrentUserName(), "Who approves the plan")
+  fComment := fSet.String("m", "", "A comment to record with the approval (eg. a change ticket)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() != 1 {
+    PrintHelp(p.GetName(), "<plan file>", []interface{}{
+      "This command will record the approval of a plan made with wheels-plan.",
+      "Unless `plans.allow_self_approval` is set in .wheels.yaml, the plan has",
+      "to be approved by somebody else than its author.",
+    }, fSet)
+    return nil
+  }
+
+  record, err := project.FindPlanRecord(fSet.Arg(0))
+  if err != nil {
+    return err
+  }
+  if err := project.ApprovePlan(record, *fBy, *fComment); err != nil {
+    return err
+  }
+  PrintInfo("Approved the plan as %s", Bold(*fBy))
+  printPlanRecord(project, record)
+  return nil
+}
+
+type PluginPlanCmdApply struct {
+}
+
+func (p *PluginPlanCmdApply) GetName() string {
+  return "wheels-apply-plan"
+}
+
+func (p *PluginPlanCmdApply) GetDescription() string {
+  return "Applies a plan made with wheels-plan, once it has the approvals it needs"
+}
+
+func (p *PluginPlanCmdApply) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() != 1 {
+    PrintHelp(p.GetName(), "<plan file>", []interface{}{
+      "This command will apply a plan made with wheels-plan, after checking that",
+      "it was not modified nor applied already, that it's for the selected",
+      "workspace, that it's not older than `plans.max_age` and that it has the",
+      "approvals it needs, that it follows the policies of the project and that",
+      "the AWS quotas leave room for it (with `preflight.quotas`).",
+    }, fSet)
+    return nil
+  }
+
+  planFile := fSet.Arg(0)
+  record, err := project.FindPlanRecord(planFile)
+  if err != nil {
+    return err
+  }
+  if err := project.CheckPlanApplicable(record); err != nil {
+    return err
+  }
+
+  if err := AttachAWSCredentials(tf); err != nil {
+    return err
+  }
+  if err := project.CheckBeforeApply(tf, []string{"apply", planFile}); err != nil {
+    return err
+  }
+  project.BackupStateBefore(tf, "apply")
+  if err := tf.Invoke([]string{"apply", "-input=false", planFile}); err != nil {
+    return err
+  }
+  return project.MarkPlanApplied(record)
+}
@@ -0,0 +1,151 @@
+package plugins
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "path/filepath"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginPolicy struct {
+}
+
+func CreatePluginPolicy() *PluginPolicy {
+  return &PluginPolicy{}
+}
+
+func (p *PluginPolicy) GetName() string {
+  return "policy"
+}
+
+func (p *PluginPolicy) Requires() []string {
+  return nil
+}
+
+func (p *PluginPolicy) Priority() int {
+  return 0
+}
+
+func (p *PluginPolicy) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginPolicy) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginPolicy) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginPolicy) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginPolicyCmdPolicy{},
+  }
+}
+
+type PluginPolicyCmdPolicy struct {
+}
+
+func (p *PluginPolicyCmdPolicy) GetName() string {
+  return "wheels-policy"
+}
+
+func (p *PluginPolicyCmdPolicy) GetDescription() string {
+  return "Lists the policies of the project, or checks a plan against them"
+}
+
+func (p *PluginPolicyCmdPolicy) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  action := "list"
+  if fSet.NArg() > 0 {
+    action = fSet.Arg(0)
+  }
+  if *help || fSet.NArg() > 2 || (action != "list" && action != "check" && action != "input") || (action == "list" && fSet.NArg() > 1) {
+    PrintHelp(p.GetName(), "[list|check|input] [plan file]", []interface{}{
+      "The policies in .wheels/policies are checked against the plan before",
+      "every apply, that is refused if it breaks any of them. They are YAML",
+      "rules (*.yaml), or rego policies (*.rego) evaluated with `opa`.",
+      "",
+      "This command lists the policies (list), checks the plan of the project",
+      "or a saved plan against them (check), or prints the JSON document they",
+      "are evaluated against (input), to write rego policies.",
+    }, fSet)
+    return nil
+  }
+
+  if action == "list" {
+    return listPolicies(project)
+  }
+
+  if !project.HasPolicies() && action == "check" {
+    PrintInfo("The project has no policies in .wheels/policies")
+    return nil
+  }
+  if err := AttachAWSCredentials(tf); err != nil {
+    return err
+  }
+  planArgs := project.AddWorkspaceVarFile([]string{"apply"})
+  if fSet.NArg() == 2 {
+    planArgs = []string{"apply", fSet.Arg(1)}
+  }
+
+  if action == "check" {
+    if err := project.CheckPlanPolicies(tf, planArgs); err != nil {
+      return err
+    }
+    PrintInfo("The plan follows the policies of the project")
+    return nil
+  }
+
+  output, err := project.GetApplyPlanOutput(tf, planArgs)
+  if err != nil {
+    return err
+  }
+  input, err := project.CreatePolicyInput(tf, output)
+  if err != nil {
+    return err
+  }
+  content, err := json.MarshalIndent(input, "", "  ")
+  if err != nil {
+    return fmt.Errorf("Could not encode the policy input: %s", err.Error())
+  }
+  Println(string(content))
+  return nil
+}
+
+func listPolicies(project *ProjectSandbox) error {
+  policies, regoFiles, err := project.LoadPolicies()
+  if err != nil {
+    return err
+  }
+  if len(policies) == 0 && len(regoFiles) == 0 {
+    PrintInfo("The project has no policies in .wheels/policies")
+    return nil
+  }
+
+  for _, policy := range policies {
+    Printf("%s\n", Bold(filepath.Base(policy.Path)))
+    for _, rule := range policy.Rules {
+      if rule.Description != "" {
+        Printf("  %s: %s\n", rule.Name, rule.Description)
+      } else {
+        Printf("  %s\n", rule.Name)
+      }
+    }
+  }
+  for _, file := range regoFiles {
+    Printf("%s (rego)\n", Bold(filepath.Base(file)))
+  }
+  return nil
+}
@@ -0,0 +1,149 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginPRComment struct {
+}
+
+func CreatePluginPRComment() *PluginPRComment {
+  return &PluginPRComment{}
+}
+
+func (p *PluginPRComment) GetName() string {
+  return "pr-comment"
+}
+
+func (p *PluginPRComment) Requires() []string {
+  return nil
+}
+
+func (p *PluginPRComment) Priority() int {
+  return 0
+}
+
+func (p *PluginPRComment) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginPRComment) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginPRComment) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginPRComment) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginPRCommentCmdComment{},
+  }
+}
+
+type PluginPRCommentCmdComment struct {
+}
+
+func (p *PluginPRCommentCmdComment) GetName() string {
+  return "wheels-pr-comment"
+}
+
+func (p *PluginPRCommentCmdComment) GetDescription() string {
+  return "Runs a plan and summarizes it in Markdown for a pull request comment"
+}
+
+func (p *PluginPRCommentCmdComment) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOutput := fSet.String("o", "", "Write the comment to this file (- for the standard output)")
+  fTitle := fSet.String("title", "Terraform plan", "The title of the comment")
+  fBase := fSet.String("base", "", "The git revision to compare the cost with (eg. origin/master)")
+  fPlanOut := fSet.String("out", "", "Also save the plan in this file, to apply it later")
+  fWebhook := fSet.String("webhook", "", "Post the comment as JSON to this URL")
+  fRepo := fSet.String("github-repo", os.Getenv("GITHUB_REPOSITORY"), "Post the comment to a pull request of this GitHub repository (owner/name), with $GITHUB_TOKEN")
+  fPR := fSet.Int("github-pr", 0, "The number of the GitHub pull request")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "[options] [-- plan arguments]", []interface{}{
+      "This command will run `terraform plan -detailed-exitcode` and render a",
+      "Markdown summary of it: the changes, how risky they are, and (with -base)",
+      "how the estimated monthly cost changes. The summary can be written to a",
+      "file, or posted to a webhook or to a GitHub pull request.",
+      "",
+      "The command fails if the plan fails, after posting the summary.",
+    }, fSet)
+    return nil
+  }
+
+  token := os.Getenv("GITHUB_TOKEN")
+  if *fPR != 0 && (*fRepo == "" || token == "") {
+    return fmt.Errorf("Posting to GitHub needs -github-repo and the GITHUB_TOKEN environment variable")
+  }
+  if *fOutput == "" && *fWebhook == "" && *fPR == 0 {
+    *fOutput = "-"
+  }
+
+  if err := AttachAWSCredentials(tf); err != nil {
+    return err
+  }
+  planArgs := []string{"plan", "-detailed-exitcode", "-input=false", "-no-color"}
+  if *fPlanOut != "" {
+    planArgs = append(planArgs, "-out="+*fPlanOut)
+  }
+  tf.Invoke(append(planArgs, fSet.Args()...))
+
+  summary := &PlanSummary{ExitCode: tf.GetLastExitCode(), Output: tf.GetLastOutput()}
+  summary.Changes = ParsePlanChanges(summary.Output)
+  if table, err := project.GetPriceTable(); err != nil {
+    PrintWarning("Could not estimate the cost: %s", err.Error())
+  } else if items, err := project.EstimateCost(table); err == nil && len(items) > 0 {
+    after := GetMonthlyCost(items)
+    summary.CostAfter = &after
+    if *fBase != "" {
+      if before, err := project.EstimateCostAtRevision(table, *fBase); err != nil {
+        PrintWarning("Could not estimate the cost at %s: %s", *fBase, err.Error())
+      } else {
+        summary.CostBefore = &before
+      }
+    }
+  }
+  markdown := summary.RenderMarkdown(*fTitle)
+
+  if *fOutput == "-" {
+    fmt.Print(markdown)
+  } else if *fOutput != "" {
+    if err := ioutil.WriteFile(*fOutput, []byte(markdown), 0644); err != nil {
+      return fmt.Errorf("Could not write %s: %s", *fOutput, err.Error())
+    }
+    PrintInfo("Wrote the plan summary to %s", Bold(*fOutput))
+  }
+  if *fWebhook != "" {
+    if err := PostCommentToWebhook(*fWebhook, markdown); err != nil {
+      return err
+    }
+    PrintInfo("Posted the plan summary to the webhook")
+  }
+  if *fPR != 0 {
+    if err := PostCommentToGitHub(*fRepo, *fPR, token, markdown); err != nil {
+      return err
+    }
+    PrintInfo("Posted the plan summary to %s#%d", Bold(*fRepo), *fPR)
+  }
+
+  if code := summary.ExitCode; code != 0 && code != 2 {
+    return fmt.Errorf("The plan failed with exit code %d", code)
+  }
+  return nil
+}
@@ -0,0 +1,52 @@
+package plugins
+
+import (
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginRecovery struct {
+}
+
+func CreatePluginRecovery() *PluginRecovery {
+  return &PluginRecovery{}
+}
+
+func (p *PluginRecovery) GetName() string {
+  return "recovery"
+}
+
+func (p *PluginRecovery) Requires() []string {
+  return nil
+}
+
+func (p *PluginRecovery) Priority() int {
+  return 0
+}
+
+func (p *PluginRecovery) IsUsed(project *ProjectSandbox) (bool, error) {
+  return len(project.GetTerraformResources("module")) > 0 || len(project.GetTerraformResources("resource")) > 0, nil
+}
+
+func (p *PluginRecovery) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginRecovery) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  cmd := tf.GetLastCommand()
+  if tfErr == nil || (cmd != "apply" && cmd != "destroy") {
+    return nil
+  }
+
+  recovery := AnalyzeFailedRun(tf)
+  if len(recovery.Completed)+len(recovery.Interrupted)+len(recovery.Modified)+len(recovery.Failed) == 0 {
+    return nil
+  }
+
+  // The fail-fast mode already summarized the errors
+  recovery.Print(tf.IsFailFastEnabled())
+  return nil
+}
+
+func (p *PluginRecovery) GetCommands() []PluginCommand {
+  return []PluginCommand{}
+}
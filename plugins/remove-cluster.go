@@ -0,0 +1,198 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginRemoveCluster struct {
+}
+
+func CreatePluginRemoveCluster() *PluginRemoveCluster {
+  return &PluginRemoveCluster{}
+}
+
+func (p *PluginRemoveCluster) GetName() string {
+  return "remove-cluster"
+}
+
+func (p *PluginRemoveCluster) Requires() []string {
+  return nil
+}
+
+func (p *PluginRemoveCluster) Priority() int {
+  return 0
+}
+
+func (p *PluginRemoveCluster) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginRemoveCluster) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginRemoveCluster) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginRemoveCluster) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginRemoveClusterCmdRemove{},
+  }
+}
+
+type PluginRemoveClusterCmdRemove struct {
+  runTerraform func(project *ProjectSandbox, tf *TerraformWrapper, args []string) error
+}
+
+func (p *PluginRemoveClusterCmdRemove) GetName() string {
+  return "remove-cluster"
+}
+
+func (p *PluginRemoveClusterCmdRemove) GetDescription() string {
+  return "Destroys the cluster and removes everything that was generated for it"
+}
+
+func (p *PluginRemoveClusterCmdRemove) SetProjectTerraformRunner(run func(project *ProjectSandbox, tf *TerraformWrapper, args []string) error) {
+  p.runTerraform = run
+}
+
+func (p *PluginRemoveClusterCmdRemove) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fYes := fSet.Bool("yes", false, "Do not ask for confirmation")
+  fDeleteBackend := fSet.Bool("delete-backend", false, "Also delete the state from the remote backend (only s3 is supported)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "[name]", []interface{}{
+      "This command will destroy the cluster of the project (or the cluster with",
+      "the given name, created anywhere on this machine), make sure nothing is",
+      "left in the terraform state, and then remove the generated files, the",
+      "cached credentials and state, and the cluster from the registry.",
+      "",
+      "The destroy runs like `terraform destroy`, with the credentials, the",
+      "plugins, the state backup and the before_destroy hooks. Nothing is",
+      "removed if the state is empty, unless the cluster was never applied.",
+    }, fSet)
+    return nil
+  }
+
+  dir, err := os.Getwd()
+  if err != nil {
+    return err
+  }
+  if name := fSet.Arg(0); name != "" {
+    c, err := FindRegisteredCluster(name)
+    if err != nil {
+      return err
+    }
+    dir = c.Dir
+
+    // Terraform works on the current directory
+    if err := os.Chdir(dir); err != nil {
+      return err
+    }
+    project, err = OpenSandbox(dir)
+    if err != nil {
+      return err
+    }
+    defer project.Unlock()
+    tf, err = project.GetTerraform()
+    if err != nil {
+      return err
+    }
+  }
+
+  if err := project.CheckDestroyAllowed(); err != nil {
+    return err
+  }
+  if !*fYes && (!IsInteractive() || !ReadYN(fmt.Sprintf("Destroy the cluster in %s and remove its files?", dir))) {
+    return fmt.Errorf("Not removing anything, use -yes to skip the confirmation")
+  }
+
+  resources, err := tf.ListStateResources()
+  if err != nil {
+    return err
+  }
+  clusterUrl := ""
+  if len(resources) == 0 {
+    // An empty state is only expected from a cluster that was never applied,
+    // otherwise it's likely the wrong backend or workspace
+    c, err := project.GetRegisteredCluster()
+    if err != nil {
+      return err
+    }
+    if c == nil || c.AppliedAt != nil {
+      return fmt.Errorf("The terraform state is empty, but the cluster might exist: not removing anything (check the backend and the workspace)")
+    }
+  } else {
+    clusterUrl = getDcosClusterURL(project, tf)
+
+    PrintInfo("Destroying %d resource(s)", len(resources))
+    if err := p.runTerraform(project, tf, []string{"destroy", "-auto-approve"}); err != nil {
+      return err
+    }
+
+    // Never remove anything that is needed to retry the destroy
+    resources, err = tf.ListStateResources()
+    if err != nil {
+      return err
+    }
+    if len(resources) > 0 {
+      return fmt.Errorf("The state still contains %s, not removing anything", strings.Join(resources, ", "))
+    }
+  }
+
+  if clusterUrl != "" {
+    if err := project.ForgetDcosToken(clusterUrl); err != nil {
+      PrintWarning("Could not forget the DC/OS credentials: %s", err.Error())
+    }
+  }
+
+  if *fDeleteBackend {
+    if err := project.DeleteBackendState(); err != nil {
+      return err
+    }
+  } else if backend, err := project.GetBackend(); err == nil && backend != nil {
+    PrintInfo("The (empty) state is kept in the %s backend, use -delete-backend to delete it", backend.Type)
+  }
+
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return err
+  }
+  for name := range groups {
+    files, err := project.RemoveTerraformFileGroup(name)
+    if err != nil {
+      return err
+    }
+    PrintInfo("Removed %s, generated by %s", Bold(strings.Join(files, ", ")), name)
+  }
+
+  if err := project.UnregisterCluster(); err != nil {
+    PrintWarning("Could not remove the cluster from the registry: %s", err.Error())
+  }
+
+  removed, err := project.CleanArtifacts()
+  if len(removed) > 0 {
+    PrintInfo("Removed %s", Bold(strings.Join(removed, ", ")))
+  }
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("The cluster in %s was removed", dir)
+  return nil
+}
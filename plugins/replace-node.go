@@ -0,0 +1,148 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strconv"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// The first terraform version with `apply -replace=<address>`
+var replaceOptionVersion *semver.Version = semver.MustParse("0.15.2")
+
+type PluginReplaceNode struct {
+}
+
+func CreatePluginReplaceNode() *PluginReplaceNode {
+  return &PluginReplaceNode{}
+}
+
+func (p *PluginReplaceNode) GetName() string {
+  return "replace-node"
+}
+
+func (p *PluginReplaceNode) Requires() []string {
+  return nil
+}
+
+func (p *PluginReplaceNode) Priority() int {
+  return 0
+}
+
+func (p *PluginReplaceNode) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginReplaceNode) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginReplaceNode) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginReplaceNode) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginReplaceNodeCmdReplace{},
+  }
+}
+
+type PluginReplaceNodeCmdReplace struct {
+  runTerraform func(args []string) error
+}
+
+func (p *PluginReplaceNodeCmdReplace) GetName() string {
+  return "wheels-replace-node"
+}
+
+func (p *PluginReplaceNodeCmdReplace) GetDescription() string {
+  return "Re-creates a master or an agent of the cluster"
+}
+
+func (p *PluginReplaceNodeCmdReplace) SetTerraformRunner(run func(args []string) error) {
+  p.runTerraform = run
+}
+
+func (p *PluginReplaceNodeCmdReplace) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fYes := fSet.Bool("yes", false, "Do not ask for confirmation, and apply without reviewing the plan")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() != 2 {
+    PrintHelp(p.GetName(), "<master|agent|public-agent> <index>", []interface{}{
+      "This command will destroy the instance of the given node and create a new",
+      "one in its place, by tainting its resource and applying the project. The",
+      "nodes are counted from 0, like the index of their terraform resource.",
+      "",
+      "Replacing a master needs the other masters to keep the quorum of the",
+      "cluster, so only one master must be replaced at a time, once the others",
+      "are healthy. Replacing an agent restarts its tasks on the other agents.",
+    }, fSet)
+    if *help {
+      return nil
+    }
+    return fmt.Errorf("Please give the kind of node and its index")
+  }
+
+  kind := fSet.Arg(0)
+  index, err := strconv.Atoi(fSet.Arg(1))
+  if err != nil || index < 0 {
+    return fmt.Errorf("Invalid node index '%s'", fSet.Arg(1))
+  }
+  address, nodes, err := project.FindNodeAddress(tf, kind, index)
+  if err != nil {
+    return err
+  }
+
+  // DC/OS keeps its state (ZooKeeper, Mesos and Marathon) on the masters
+  switch {
+  case kind == "master" && len(nodes) == 1:
+    PrintWarning("This is the only master, %s", Bold("the cluster is going to lose all its state (services, jobs, secrets, ...)"))
+  case kind == "master" && len(nodes)%2 == 0:
+    PrintWarning("The cluster has %d masters, it loses its quorum if another master fails during the replacement", len(nodes))
+  case kind == "master":
+    PrintWarning("The cluster keeps its quorum only if the %d other masters are healthy, only replace one master at a time", len(nodes)-1)
+  default:
+    PrintWarning("The tasks running on this agent are going to be restarted elsewhere, you can drain it first with `dcos node drain`")
+  }
+
+  PrintInfo("Replacing %s", Bold(address))
+  if !*fYes && (!IsInteractive() || !ReadYN(fmt.Sprintf("Destroy and re-create the %s %d?", kind, index))) {
+    return fmt.Errorf("Not replacing anything, use -yes to skip the confirmation")
+  }
+
+  applyArgs := []string{"apply"}
+  if *fYes {
+    applyArgs = append(applyArgs, "-auto-approve")
+  }
+
+  version, err := tf.GetVersion()
+  if err != nil {
+    return err
+  }
+  if ver, err := semver.NewVersion(version); err == nil && !ver.LessThan(replaceOptionVersion) {
+    return p.runTerraform(append(applyArgs, "-replace="+address))
+  }
+
+  // Without -replace, the node is tainted first. It's only destroyed by the
+  // apply, and can be untainted until then.
+  if err := p.runTerraform(append([]string{"taint"}, GetTaintArgs(address)...)); err != nil {
+    return err
+  }
+  if err := p.runTerraform(applyArgs); err != nil {
+    PrintInfo("The node is still tainted, run `%s apply` to replace it or `%s untaint %s` to keep it", os.Args[0], os.Args[0], strings.Join(GetTaintArgs(address), " "))
+    return err
+  }
+  return nil
+}
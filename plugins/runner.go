@@ -0,0 +1,143 @@
+package plugins
+
+import (
+  "fmt"
+  "strings"
+  "sync"
+
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+// How many BeforeRun hooks can run at the same time
+var maxConcurrentBeforeRun int = 4
+
+/**
+ * Returns the plugins (among the used ones) that must complete their hooks
+ * before the ones of the given plugin
+ */
+func getPluginDependencies(plugin Plugin, used map[string]bool) []string {
+  var deps []string
+  for _, name := range plugin.Requires() {
+    if used[name] {
+      deps = append(deps, name)
+    }
+  }
+  return deps
+}
+
+/**
+ * @brief      Orders the plugins so that every plugin comes after the ones it
+ *             requires, and the highest priority first otherwise
+ *
+ * Plugins that require each other (even indirectly) are an error, since their
+ * hooks would wait for each other forever.
+ */
+func SortPlugins(plugins []Plugin) ([]Plugin, error) {
+  used := make(map[string]bool)
+  for _, plugin := range plugins {
+    used[plugin.GetName()] = true
+  }
+
+  var sorted []Plugin
+  placed := make(map[string]bool)
+  for len(sorted) < len(plugins) {
+    var next Plugin
+    for _, plugin := range plugins {
+      if placed[plugin.GetName()] {
+        continue
+      }
+      ready := true
+      for _, dep := range getPluginDependencies(plugin, used) {
+        if !placed[dep] {
+          ready = false
+        }
+      }
+      if !ready {
+        continue
+      }
+      if next == nil || plugin.Priority() > next.Priority() ||
+        (plugin.Priority() == next.Priority() && plugin.GetName() < next.GetName()) {
+        next = plugin
+      }
+    }
+
+    if next == nil {
+      var names []string
+      for _, plugin := range plugins {
+        if !placed[plugin.GetName()] {
+          names = append(names, plugin.GetName())
+        }
+      }
+      return nil, fmt.Errorf("The plugins %s require each other", strings.Join(names, ", "))
+    }
+    placed[next.GetName()] = true
+    sorted = append(sorted, next)
+  }
+  return sorted, nil
+}
+
+/**
+ * @brief      Runs the BeforeRun of the given plugins concurrently, and
+ *             returns the errors of all the ones that failed
+ *
+ * A plugin waits for the ones it requires, and is skipped if any of them
+ * failed. The others take the free slots by priority.
+ */
+func RunBeforeRun(plugins []Plugin, project *ProjectSandbox, tf *TerraformWrapper, initRun bool) []error {
+  used := make(map[string]bool)
+  done := make(map[string]chan struct{})
+  for _, plugin := range plugins {
+    used[plugin.GetName()] = true
+    done[plugin.GetName()] = make(chan struct{})
+  }
+  plugins, err := SortPlugins(plugins)
+  if err != nil {
+    return []error{err}
+  }
+
+  var failedMutex sync.Mutex
+  failed := make(map[string]bool)
+  hasFailed := func(names []string) bool {
+    failedMutex.Lock()
+    defer failedMutex.Unlock()
+    for _, name := range names {
+      if failed[name] {
+        return true
+      }
+    }
+    return false
+  }
+
+  group := CreateTaskGroup(maxConcurrentBeforeRun)
+  for _, plugin := range plugins {
+    plugin := plugin
+    deps := getPluginDependencies(plugin, used)
+
+    group.Go(func() {
+      for _, dep := range deps {
+        <-done[dep]
+      }
+    }, func() error {
+      defer close(done[plugin.GetName()])
+      var err error
+      if hasFailed(deps) {
+        err = fmt.Errorf("Not starting %s, because a plugin it depends on failed", plugin.GetName())
+      } else {
+        span := StartSpan("plugin", plugin.GetName()+" before run")
+        if err = plugin.BeforeRun(project, tf, initRun); err != nil {
+          // Wrapped, to keep the exit code of the error
+          err = fmt.Errorf("Could not start %s: %w", plugin.GetName(), err)
+        }
+        span.End()
+      }
+
+      if err != nil {
+        failedMutex.Lock()
+        failed[plugin.GetName()] = true
+        failedMutex.Unlock()
+      }
+      return err
+    })
+  }
+  return group.Wait()
+}
@@ -0,0 +1,123 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginScanSecrets struct {
+}
+
+func CreatePluginScanSecrets() *PluginScanSecrets {
+  return &PluginScanSecrets{}
+}
+
+func (p *PluginScanSecrets) GetName() string {
+  return "scan-secrets"
+}
+
+func (p *PluginScanSecrets) Requires() []string {
+  return nil
+}
+
+func (p *PluginScanSecrets) Priority() int {
+  return 0
+}
+
+func (p *PluginScanSecrets) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginScanSecrets) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginScanSecrets) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginScanSecrets) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginScanSecretsCmdScan{},
+  }
+}
+
+type PluginScanSecretsCmdScan struct {
+}
+
+func (p *PluginScanSecretsCmdScan) GetName() string {
+  return "wheels-scan-secrets"
+}
+
+func (p *PluginScanSecretsCmdScan) GetDescription() string {
+  return "Looks for secrets kept in plain text in the project"
+}
+
+func (p *PluginScanSecretsCmdScan) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fAll := fSet.Bool("all", false, "Scan all the clusters created on this machine, instead of the current project")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will look for tokens, passwords, license keys and private",
+      "keys that are kept in plain text in the terraform files of the project,",
+      "where they could be committed or shared. The gitignored files (like the",
+      "secrets.auto.tfvars we generate) are not reported.",
+    }, fSet)
+    return nil
+  }
+
+  dirs := []string{project.GetFilePath("")}
+  if *fAll {
+    registry, err := LoadClusterRegistry()
+    if err != nil {
+      return err
+    }
+    dirs = nil
+    for dir := range registry {
+      dirs = append(dirs, dir)
+    }
+    sort.Strings(dirs)
+  }
+
+  total := 0
+  for _, dir := range dirs {
+    if _, err := os.Stat(dir); err != nil {
+      PrintWarning("Skipping %s, it no longer exists", dir)
+      continue
+    }
+    findings, err := ScanSecrets(dir)
+    if err != nil {
+      return err
+    }
+    if *fAll {
+      PrintInfo("%s: %d secret(s) found", Bold(dir), len(findings))
+    }
+    for _, finding := range findings {
+      location := finding.File
+      if finding.Line > 0 {
+        location = fmt.Sprintf("%s:%d", finding.File, finding.Line)
+      }
+      Printf("%s %s: %s\n", Red("Secret:"), location, finding.Kind)
+    }
+    total += len(findings)
+  }
+
+  if total > 0 {
+    return WithExitCode(ExitValidationFailed, fmt.Errorf("Found %d secret(s) in plain text, move them to variables or add the files to .gitignore", total))
+  }
+  PrintInfo("No secrets found in plain text")
+  return nil
+}
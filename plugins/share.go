@@ -0,0 +1,197 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginShare struct {
+}
+
+func CreatePluginShare() *PluginShare {
+  return &PluginShare{}
+}
+
+func (p *PluginShare) GetName() string {
+  return "share"
+}
+
+func (p *PluginShare) Requires() []string {
+  return nil
+}
+
+func (p *PluginShare) Priority() int {
+  return 0
+}
+
+func (p *PluginShare) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginShare) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginShare) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginShare) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginShareCmdShare{},
+    &PluginShareCmdJoin{},
+  }
+}
+
+/**
+ * Returns the passphrase of a bundle, either from the environment or by
+ * prompting the user
+ */
+func readSharePassphrase(confirm bool) (string, error) {
+  if v, ok := os.LookupEnv("TERRAFORM_WHEELS_SHARE_PASSPHRASE"); ok && v != "" {
+    return v, nil
+  }
+  if !IsInteractive() {
+    return "", fmt.Errorf("Please specify the passphrase with TERRAFORM_WHEELS_SHARE_PASSPHRASE")
+  }
+
+  pass, err := ReadPassword("Passphrase")
+  if err != nil {
+    return "", fmt.Errorf("Could not read passphrase: %s", err.Error())
+  }
+  if pass == "" {
+    return "", fmt.Errorf("The passphrase cannot be empty")
+  }
+  if confirm {
+    again, err := ReadPassword("Confirm passphrase")
+    if err != nil {
+      return "", fmt.Errorf("Could not read passphrase: %s", err.Error())
+    }
+    if again != pass {
+      return "", fmt.Errorf("The passphrases do not match")
+    }
+  }
+
+  return pass, nil
+}
+
+type PluginShareCmdShare struct {
+}
+
+func (p *PluginShareCmdShare) GetName() string {
+  return "wheels-share"
+}
+
+func (p *PluginShareCmdShare) GetDescription() string {
+  return "Creates an encrypted bundle to give a teammate access to the cluster"
+}
+
+func (p *PluginShareCmdShare) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOut := fSet.String("out", "cluster.wheels-share", "The file to write the bundle to")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will create a passphrase-protected bundle with the remote",
+      "backend, the workspace and the project files. A teammate can then use",
+      fmt.Sprintf("%s to operate the same cluster from their machine.", Bold("wheels-join")),
+      "",
+      "The terraform state, variable files and private keys are never included.",
+    }, fSet)
+    return nil
+  }
+
+  bundle, err := project.CreateShareBundle()
+  if err != nil {
+    return err
+  }
+
+  passphrase, err := readSharePassphrase(true)
+  if err != nil {
+    return err
+  }
+
+  content, err := SealShareBundle(bundle, passphrase)
+  if err != nil {
+    return fmt.Errorf("Could not encrypt bundle: %s", err.Error())
+  }
+  err = ioutil.WriteFile(*fOut, content, 0600)
+  if err != nil {
+    return fmt.Errorf("Could not write %s: %s", *fOut, err.Error())
+  }
+
+  PrintInfo("Created %s with %d files from the '%s' workspace", Bold(*fOut), len(bundle.Files), bundle.Workspace)
+  PrintInfo("Send it to your teammate and share the passphrase over a different channel. They can use it with:")
+  Printf("  %s wheels-join %s\n", os.Args[0], filepath.Base(*fOut))
+  return nil
+}
+
+type PluginShareCmdJoin struct {
+}
+
+func (p *PluginShareCmdJoin) GetName() string {
+  return "wheels-join"
+}
+
+func (p *PluginShareCmdJoin) GetDescription() string {
+  return "Sets-up the project from a bundle created with wheels-share"
+}
+
+func (p *PluginShareCmdJoin) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fForce := fSet.Bool("force", false, "Overwrite the existing project files")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() != 1 {
+    PrintHelp(p.GetName(), "<bundle>", []interface{}{
+      "This command will decrypt a bundle created with wheels-share and prepare",
+      "the current directory to operate the same cluster.",
+    }, fSet)
+    return nil
+  }
+
+  content, err := ioutil.ReadFile(fSet.Arg(0))
+  if err != nil {
+    return fmt.Errorf("Could not read %s: %s", fSet.Arg(0), err.Error())
+  }
+
+  passphrase, err := readSharePassphrase(false)
+  if err != nil {
+    return err
+  }
+
+  bundle, err := OpenShareBundle(content, passphrase)
+  if err != nil {
+    return err
+  }
+
+  err = project.ApplyShareBundle(bundle, *fForce)
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("Joined the project shared by %s on %s", Bold(bundle.CreatedBy), bundle.CreatedAt.Local().Format("2006-01-02 15:04"))
+  for _, line := range bundle.Instructions {
+    PrintInfo("%s", line)
+  }
+  return nil
+}
@@ -0,0 +1,556 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginSpec struct {
+}
+
+func CreatePluginSpec() *PluginSpec {
+  return &PluginSpec{}
+}
+
+func (p *PluginSpec) GetName() string {
+  return "spec"
+}
+
+func (p *PluginSpec) Requires() []string {
+  return nil
+}
+
+func (p *PluginSpec) Priority() int {
+  return 0
+}
+
+func (p *PluginSpec) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginSpec) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginSpec) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginSpec) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginSpecCmdExport{},
+    &PluginSpecCmdCreate{},
+    &PluginSpecCmdUp{},
+    &PluginSpecCmdExamples{},
+  }
+}
+
+type PluginSpecCmdExport struct {
+}
+
+func (p *PluginSpecCmdExport) GetName() string {
+  return "wheels-export"
+}
+
+func (p *PluginSpecCmdExport) GetDescription() string {
+  return "Exports the cluster of the project to a portable YAML spec"
+}
+
+func (p *PluginSpecCmdExport) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fOutput := fSet.String("o", "cluster-spec.yaml", "The file to write, or - for the standard output")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command reads the cluster definition of the project (the generated",
+      "terraform files and their variables) and writes it as a portable YAML spec:",
+      "the cloud, the number of nodes, the DC/OS release, the agent pools and the",
+      "other add-aws-cluster options. The secrets are never exported.",
+      "",
+      fmt.Sprintf("Use `%s wheels-create -f <spec>` to create the same cluster elsewhere.", os.Args[0]),
+    }, fSet)
+    return nil
+  }
+
+  spec, err := exportClusterSpec(project)
+  if err != nil {
+    return err
+  }
+  if err := spec.Write(*fOutput); err != nil {
+    return err
+  }
+  if *fOutput != "-" {
+    PrintInfo("%s%s", Bold("Exported the cluster to "), Bold(Green(*fOutput)))
+  }
+  return nil
+}
+
+type PluginSpecCmdCreate struct {
+}
+
+func (p *PluginSpecCmdCreate) GetName() string {
+  return "wheels-create"
+}
+
+func (p *PluginSpecCmdCreate) GetDescription() string {
+  return "Creates the cluster files from a YAML spec exported with wheels-export"
+}
+
+func (p *PluginSpecCmdCreate) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fFile := fSet.String("f", "", "The cluster spec to create the cluster from")
+  fPrint := fSet.Bool("print", false, "Only print the equivalent command line, without creating the cluster files")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "-f spec.yaml", []interface{}{
+      "This command creates the terraform files of the cluster described by the",
+      "given spec, as written by wheels-export, in the project directory. The",
+      "cluster is created with the usual plan and apply.",
+    }, fSet)
+    return nil
+  }
+  if *fFile == "" {
+    return fmt.Errorf("Please give the cluster spec with -f, see `%s %s -help`", os.Args[0], p.GetName())
+  }
+
+  spec, err := LoadClusterSpec(*fFile)
+  if err != nil {
+    return err
+  }
+  cmdArgs, err := getClusterSpecArgs(spec)
+  if err != nil {
+    return err
+  }
+
+  PrintInfo("The equivalent of %s is:", Bold(*fFile))
+  Println(formatCommandLine(append([]string{os.Args[0], "add-aws-cluster"}, cmdArgs...)))
+  if *fPrint {
+    return nil
+  }
+
+  cmd := &PluginDcosAwsCmdAddCluster{CreatePluginDcosAws()}
+  if err := cmd.Handle(cmdArgs, project, tf); err != nil {
+    return err
+  }
+  PrintInfo("Review the cluster with %s, then create it with %s", Bold(os.Args[0]+" plan -out=plan.out"), Bold(os.Args[0]+" apply plan.out"))
+  return nil
+}
+
+type PluginSpecCmdUp struct {
+  runTerraform func(args []string) error
+}
+
+func (p *PluginSpecCmdUp) GetName() string {
+  return "up"
+}
+
+func (p *PluginSpecCmdUp) GetDescription() string {
+  return "Creates or updates the cluster and its services to match a YAML spec"
+}
+
+func (p *PluginSpecCmdUp) SetTerraformRunner(run func(args []string) error) {
+  p.runTerraform = run
+}
+
+func (p *PluginSpecCmdUp) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fFile := fSet.String("f", "", "The cluster spec to converge to")
+  fAutoApprove := fSet.Bool("auto-approve", false, "Apply the changes without asking for a confirmation")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "-f cluster.yaml", []interface{}{
+      "This command brings the project to the state described by the given spec:",
+      "it (re)generates the cluster files, initializes the project and applies",
+      "them, then does the same for the services of the spec once the cluster is",
+      "up. Running it again with the same spec changes nothing, and with a",
+      "modified spec only applies the differences, including the removal of the",
+      "services that are no longer in it.",
+      "",
+      "The spec is the one of wheels-export, with an optional list of services:",
+      "",
+      "  services:",
+      "  - package: kafka",
+      "    version: 2.9.0-2.4.0    # Defaults to the latest one",
+      "    config: kafka.json      # Relative to the spec",
+    }, fSet)
+    return nil
+  }
+  if *fFile == "" {
+    return fmt.Errorf("Please give the cluster spec with -f, see `%s %s -help`", os.Args[0], p.GetName())
+  }
+
+  spec, err := LoadClusterSpec(*fFile)
+  if err != nil {
+    return err
+  }
+  cmdArgs, err := getClusterSpecArgs(spec)
+  if err != nil {
+    return err
+  }
+
+  applyArgs := []string{"apply"}
+  if *fAutoApprove {
+    applyArgs = append(applyArgs, "-auto-approve")
+  }
+
+  // The cluster first, since the services are installed through it
+  cmd := &PluginDcosAwsCmdAddCluster{CreatePluginDcosAws()}
+  if err := cmd.Handle(cmdArgs, project, tf); err != nil {
+    return err
+  }
+  if err := p.runTerraform(project.GetInitArgs()); err != nil {
+    return err
+  }
+  if err := p.runTerraform(applyArgs); err != nil {
+    return err
+  }
+
+  changed, err := p.writeServices(spec, project, tf)
+  if err != nil {
+    return err
+  }
+  if changed {
+    if err := p.runTerraform(project.GetInitArgs()); err != nil {
+      return err
+    }
+    if err := p.runTerraform(applyArgs); err != nil {
+      return err
+    }
+  }
+
+  PrintInfo("%s%s", Bold("The project matches "), Bold(Green(*fFile)))
+  return nil
+}
+
+/**
+ * Generates the files of the services of the spec, removing the ones that
+ * were generated for services no longer in it. Returns false if there are
+ * no services, now or before.
+ */
+func (p *PluginSpecCmdUp) writeServices(spec *ClusterSpec, project *ProjectSandbox, tf *TerraformWrapper) (bool, error) {
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return false, err
+  }
+  if len(spec.Services) == 0 && len(groups[p.GetName()]) == 0 {
+    return false, nil
+  }
+
+  generator := &PluginAddServiceCmdAddService{}
+  group := CreateTerraformFileGroup(p.GetName())
+  for _, service := range spec.Services {
+    name := service.Name
+    if name == "" {
+      name = service.Package
+    }
+    version := service.Version
+    if version == "" {
+      version = "latest"
+    }
+    appId := service.AppID
+    if appId == "" {
+      appId = name
+    }
+
+    var configLines []string
+    if service.Config != "" {
+      configLines, err = LoadServiceJsonToConfigLines(service.Config)
+      if err != nil {
+        return false, fmt.Errorf("Could not load config from %s: %s", service.Config, err.Error())
+      }
+    }
+
+    fileName, contents, err := generator.generateService(project, tf, name, service.Package, version, configLines, appId)
+    if err != nil {
+      return false, err
+    }
+    group.AddFile(fileName, contents)
+  }
+
+  if len(spec.Services) > 0 {
+    PrintInfo("%s%s%s", Bold("Writing "), Bold(Green(strings.Join(group.GetFileNames(), ", "))), Bold(" containing information for deploying the services on top of DC/OS"))
+  }
+  return true, project.WriteTerraformFileGroup(group)
+}
+
+// The generated files that describe parts of the cluster the spec does not
+// cover
+var unexportedClusterFiles map[string]string = map[string]string{
+  "agents-spot.tf":               "the spot agents",
+  "cluster-aws-network.tf":       "the existing VPC",
+  "cluster-aws-loadbalancers.tf": "the existing load balancers",
+}
+
+/**
+ * Reads the cluster of the project back from the generated files
+ */
+func exportClusterSpec(project *ProjectSandbox) (*ClusterSpec, error) {
+  mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws*")
+  if len(mods) == 0 {
+    return nil, fmt.Errorf("The project does not define a DC/OS cluster on AWS, see `%s add-aws-cluster -help`", os.Args[0])
+  }
+  mod := mods[0]
+
+  spec := &ClusterSpec{
+    Version: ClusterSpecVersion,
+    Cloud:   "aws",
+    Tags:    make(map[string]string),
+    Options: make(map[string]interface{}),
+  }
+
+  var keys []string
+  for k := range mod {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
+  for _, k := range keys {
+    v := project.ResolveTerraformValue(mod[k])
+    var err error
+    var n int
+
+    switch k {
+    case "_name", "source", "version", "providers":
+    case "additional_private_agent_ips", "additional_public_agent_ips":
+      // Given by the agent pools
+    case "cluster_name":
+      spec.Name = fmt.Sprint(v)
+    case "num_masters":
+      n, err = getSpecCount(k, v)
+      spec.Masters = &n
+    case "num_private_agents":
+      n, err = getSpecCount(k, v)
+      spec.PrivateAgents = &n
+    case "num_public_agents":
+      n, err = getSpecCount(k, v)
+      spec.PublicAgents = &n
+    case "dcos_version":
+      spec.Dcos.Version = fmt.Sprint(v)
+    case "dcos_variant":
+      spec.Dcos.Variant = fmt.Sprint(v)
+    case "dcos_instance_os":
+      spec.Dcos.InstanceOS = fmt.Sprint(v)
+    case "tags":
+      for tk, tv := range getSpecMap(v) {
+        spec.Tags[tk] = fmt.Sprint(tv)
+      }
+    default:
+      if k == DcosLicenseVariable {
+        // The license is given again by wheels-license
+      } else if IsSecretVariable(k) && !strings.Contains(k, "public_key") {
+        PrintWarning("Not exporting the secret %s", k)
+      } else if value, ok := getSpecOption(v); ok {
+        spec.Options[k] = value
+      } else if k != "admin_ips" {
+        // The admin IPs default to the public IP of whoever creates the cluster
+        PrintWarning("Not exporting %s, it depends on the project", k)
+      }
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  for _, pool := range project.GetTerraformResourcesMatchingName("module", "dcos-pool-*") {
+    specPool := ClusterSpecPool{
+      Name: strings.TrimPrefix(fmt.Sprint(pool["_name"]), "dcos-pool-"),
+      Type: fmt.Sprint(pool["aws_instance_type"]),
+    }
+    count := pool["num_private_agents"]
+    public := false
+    if c, ok := pool["num_public_agents"]; ok {
+      count = c
+      public = true
+    }
+    n, err := getSpecCount(specPool.Name+" agents", count)
+    if err != nil {
+      return nil, err
+    }
+    specPool.Count = n
+    specPool.Public = public
+    spec.Pools = append(spec.Pools, specPool)
+  }
+  sort.Slice(spec.Pools, func(i, j int) bool {
+    return spec.Pools[i].Name < spec.Pools[j].Name
+  })
+
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return nil, err
+  }
+  for group, files := range groups {
+    if group != "add-aws-cluster" {
+      PrintWarning("Not exporting the files generated by %s: %s", group, strings.Join(files, ", "))
+      continue
+    }
+    for _, file := range files {
+      if what, ok := unexportedClusterFiles[file]; ok {
+        PrintWarning("Not exporting %s in %s", what, file)
+      }
+    }
+  }
+
+  return spec, nil
+}
+
+func getSpecCount(name string, value interface{}) (int, error) {
+  n, err := strconv.Atoi(fmt.Sprint(value))
+  if err != nil {
+    return 0, fmt.Errorf("Could not read the number of %s: '%v' is not a number", name, value)
+  }
+  return n, nil
+}
+
+/**
+ * Returns the contents of a map argument, that HCL decodes as a list of maps
+ */
+func getSpecMap(value interface{}) map[string]interface{} {
+  ret := make(map[string]interface{})
+  switch v := value.(type) {
+  case map[string]interface{}:
+    return v
+  case []map[string]interface{}:
+    for _, m := range v {
+      for k, e := range m {
+        ret[k] = e
+      }
+    }
+  }
+  return ret
+}
+
+/**
+ * Converts an argument of the module to an option of the spec. Only plain
+ * values and lists of plain values can be given with flags, and nothing that
+ * refers to the rest of the project.
+ */
+func getSpecOption(value interface{}) (interface{}, bool) {
+  switch v := value.(type) {
+  case []interface{}:
+    var list []string
+    for _, e := range v {
+      item, ok := getSpecOption(e)
+      if !ok {
+        return nil, false
+      }
+      list = append(list, fmt.Sprint(item))
+    }
+    return list, true
+  case string:
+    if strings.Contains(v, "${") {
+      return nil, false
+    }
+    return v, true
+  case int, int64, float64, bool:
+    return fmt.Sprint(v), true
+  }
+  return nil, false
+}
+
+/**
+ * Converts the cluster spec to the equivalent add-aws-cluster arguments
+ */
+func getClusterSpecArgs(spec *ClusterSpec) ([]string, error) {
+  var args []string
+
+  if spec.Cloud != "aws" {
+    return nil, fmt.Errorf("Unsupported cloud '%s' we only support: aws", spec.Cloud)
+  }
+
+  if spec.Name != "" {
+    args = append(args, "-cluster_name="+spec.Name)
+  }
+  if spec.Masters != nil {
+    args = append(args, fmt.Sprintf("-num_masters=%d", *spec.Masters))
+  }
+  if spec.PrivateAgents != nil {
+    args = append(args, fmt.Sprintf("-num_private_agents=%d", *spec.PrivateAgents))
+  }
+  if spec.PublicAgents != nil {
+    args = append(args, fmt.Sprintf("-num_public_agents=%d", *spec.PublicAgents))
+  }
+  if spec.Dcos.Version != "" {
+    args = append(args, "-dcos_version="+spec.Dcos.Version)
+  }
+  if spec.Dcos.Variant != "" {
+    args = append(args, "-dcos_variant="+spec.Dcos.Variant)
+  }
+  if spec.Dcos.InstanceOS != "" {
+    args = append(args, "-dcos_instance_os="+spec.Dcos.InstanceOS)
+  }
+
+  for _, pool := range spec.Pools {
+    desc := fmt.Sprintf("name=%s,count=%d", pool.Name, pool.Count)
+    if pool.Type != "" {
+      desc += ",type=" + pool.Type
+    }
+    if pool.Public {
+      desc += ",public=true"
+    }
+    args = append(args, "-agent-pool="+desc)
+  }
+
+  var tags []string
+  for k := range spec.Tags {
+    tags = append(tags, k)
+  }
+  sort.Strings(tags)
+  for _, k := range tags {
+    switch k {
+    case "owner":
+      args = append(args, "-owner="+spec.Tags[k])
+    case "expiration":
+      args = append(args, "-expiration="+spec.Tags[k])
+    default:
+      args = append(args, fmt.Sprintf("-tags=%s=%s", k, spec.Tags[k]))
+    }
+  }
+
+  var options []string
+  for k := range spec.Options {
+    options = append(options, k)
+  }
+  sort.Strings(options)
+  for _, k := range options {
+    switch v := spec.Options[k].(type) {
+    case []interface{}:
+      for _, e := range v {
+        args = append(args, fmt.Sprintf("-%s=%v", k, e))
+      }
+    case map[string]interface{}:
+      return nil, fmt.Errorf("The option %s cannot be a map", k)
+    default:
+      args = append(args, fmt.Sprintf("-%s=%v", k, v))
+    }
+  }
+
+  return args, nil
+}
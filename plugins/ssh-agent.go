@@ -1,80 +1,199 @@
 package plugins
 
 import (
+  "flag"
   "fmt"
   "os"
+  "regexp"
+  "sort"
+  "strings"
 
   . "github.com/logrusorgru/aurora"
   . "github.com/mesosphere-incubator/terraform-wheels/utils"
 )
 
 type PluginSSHAgent struct {
+  pluginLogger
   agent *SSHAgentWrapper
 }
 
 func CreatePluginSSHAgent() *PluginSSHAgent {
-  return &PluginSSHAgent{nil}
+  return &PluginSSHAgent{pluginLogger{}, nil}
 }
 
 func (p *PluginSSHAgent) GetName() string {
   return "ssh-agent"
 }
 
+func (p *PluginSSHAgent) Requires() []string {
+  return nil
+}
+
+// Others wait for the agent to be started
+func (p *PluginSSHAgent) Priority() int {
+  return 10
+}
+
 func (p *PluginSSHAgent) IsUsed(project *ProjectSandbox) (bool, error) {
   // We are loading the SSH-Agent plugin when the dcos-aws module is used
-  // and has a public ssh key specified
+  // and has a public ssh key specified, or when a key pair is created from
+  // a public key file
+  return len(findSSHPublicKeys(project)) > 0, nil
+}
+
+/**
+ * Finds the public SSH key files used in the project, either by the dcos-aws
+ * module or by the aws_key_pair resources (eg. the keys of the agents)
+ */
+func findSSHPublicKeys(project *ProjectSandbox) []string {
+  found := make(map[string]bool)
   mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
-  used := false
   for _, mod := range mods {
-    if _, ok := mod["ssh_public_key_file"]; ok {
-      used = true
-      break
+    if sshKeyVar, ok := mod["ssh_public_key_file"]; ok {
+      if sshKey, ok := sshKeyVar.(string); ok && sshKey != "" {
+        found[sshKey] = true
+      }
     }
   }
-  return used, nil
+
+  // The resources are grouped by type, with a list of blocks for each name
+  re := regexp.MustCompile(`^\$\{file\("([^"]+)"\)\}$`)
+  for name, keyPairs := range project.GetTerraformResources("resource")["aws_key_pair"] {
+    if name == "_name" {
+      continue
+    }
+    blocks, ok := keyPairs.([]map[string]interface{})
+    if !ok {
+      continue
+    }
+    for _, block := range blocks {
+      if publicKey, ok := block["public_key"].(string); ok {
+        if m := re.FindStringSubmatch(publicKey); m != nil {
+          found[m[1]] = true
+        }
+      }
+    }
+  }
+
+  var keys []string
+  for key := range found {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
 }
 
 func (p *PluginSSHAgent) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
-  sshagent, err := CreateSSHAgentWrapper()
-  if err != nil {
+  // Find the SSH keys used in the project
+  if err := p.Render(project); err != nil {
     return err
   }
+  pubSSHKeys := findSSHPublicKeys(project)
 
-  socketPath, err := project.GetTemporaryPath("tmp/ssh-agent.socket")
+  sshKey := GetSSHKey()
+  sessionAgent, err := GetSessionSSHAgent()
   if err != nil {
     return err
   }
 
-  _, err = os.Stat(socketPath)
-  if err == nil {
-    err = os.Remove(socketPath)
-    if err != nil {
-      return fmt.Errorf("Could not delete old ssh-agent socket: %s", err.Error())
+  // The keys that are not in files (eg. on a hardware token) are used from
+  // the agent of the session, which we are not touching
+  if sshKey == "agent" {
+    if sessionAgent == nil {
+      return WithExitCode(ExitConfigError, fmt.Errorf("There is no ssh-agent running for --ssh-key=agent, check SSH_AUTH_SOCK"))
+    }
+    p.useAgent(tf, sessionAgent)
+    return p.checkKeysLoaded(sessionAgent, pubSSHKeys)
+  }
+  if sshKey == "" && sessionAgent != nil {
+    if missing, err := sessionAgent.MissingKeys(pubSSHKeys); err == nil && len(missing) == 0 {
+      p.log.Debug("The SSH keys of the project are already loaded in the ssh-agent on %s", sessionAgent.Socket)
+      p.useAgent(tf, sessionAgent)
+      return nil
     }
   }
 
-  err = sshagent.Start(socketPath)
+  // The agent of the project is kept running between the runs, so the keys
+  // are only loaded once, and the global agent of the user is left alone
+  sshagent, err := project.StartProjectSSHAgent()
   if err != nil {
     return err
   }
+  p.log.Debug("Using ssh-agent with pid %d on %s", sshagent.Pid, sshagent.Socket)
+  p.useAgent(tf, sshagent)
 
-  p.agent = sshagent
-  tf.SetEnv("SSH_AUTH_SOCK", sshagent.Socket)
-  tf.SetEnv("SSH_AGENT_PID", fmt.Sprintf("%d", sshagent.Pid))
+  // Only the keys that are not loaded yet are added
+  missing, err := sshagent.MissingKeys(pubSSHKeys)
+  if err != nil {
+    return err
+  }
+  if sshKey != "" && len(missing) > 0 {
+    if _, err := os.Stat(sshKey); err != nil {
+      return WithExitCode(ExitConfigError, fmt.Errorf("Could not find the SSH key %s given with --ssh-key", Bold(sshKey)))
+    }
+    if err := sshagent.AddKey(sshKey); err != nil {
+      return err
+    }
+    stillMissing, err := sshagent.MissingKeys(missing)
+    if err != nil {
+      return err
+    }
+    if len(stillMissing) == len(missing) {
+      return WithExitCode(ExitConfigError, fmt.Errorf("The SSH key %s does not match the keys the cluster expects (%s)", Bold(sshKey), Bold(strings.Join(missing, ", "))))
+    }
+    p.log.Info("Loaded private key %s in ssh-agent", Bold(sshKey))
+    missing = stillMissing
+  }
 
-  // Find the SSH keys used in the project
-  var pubSSHKeys []string = nil
-  mods := project.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")
-  for _, mod := range mods {
-    if sshKeyVar, ok := mod["ssh_public_key_file"]; ok {
-      if sshKey, ok := sshKeyVar.(string); ok {
-        pubSSHKeys = append(pubSSHKeys, sshKey)
-      }
+  for _, sshKey := range missing {
+    // Try to deduce the private key from the public key
+    privKey := GetPrivateKeyNameFromPublic(sshKey)
+    _, err = os.Stat(privKey)
+    if err != nil {
+      return WithExitCode(ExitConfigError, fmt.Errorf("Could not find private key for %s (searching for %s). If it's in an ssh-agent (eg. on a hardware token) use --ssh-key=agent, or give its file with --ssh-key=<path>", Bold(sshKey), privKey))
+    }
+
+    // Add it to the SSH agent
+    p.log.Info("Loaded private key %s in ssh-agent", Bold(privKey))
+    err = sshagent.AddKey(privKey)
+    if err != nil {
+      return err
     }
   }
 
-  // Validate keys
-  for _, sshKey := range pubSSHKeys {
+  return nil
+}
+
+/**
+ * Makes terraform (and the provisioners) use the given agent
+ */
+func (p *PluginSSHAgent) useAgent(tf *TerraformWrapper, sshagent *SSHAgentWrapper) {
+  p.agent = sshagent
+  if sshagent.Socket != "" {
+    tf.SetEnv("SSH_AUTH_SOCK", sshagent.Socket)
+  }
+  if sshagent.Pid != 0 {
+    tf.SetEnv("SSH_AGENT_PID", fmt.Sprintf("%d", sshagent.Pid))
+  }
+}
+
+/**
+ * Fails before running terraform if one of the keys the cluster expects is
+ * not in the agent, instead of the provisioners timing out
+ */
+func (p *PluginSSHAgent) checkKeysLoaded(sshagent *SSHAgentWrapper, pubSSHKeys []string) error {
+  missing, err := sshagent.MissingKeys(pubSSHKeys)
+  if err != nil {
+    return err
+  }
+  if len(missing) == 0 {
+    return nil
+  }
+  return WithExitCode(ExitConfigError, fmt.Errorf("The key the cluster expects (%s) is not loaded in the ssh-agent on %s, add it with `ssh-add` (or plug in its hardware token)", Bold(strings.Join(missing, ", ")), sshagent.Socket))
+}
+
+func (p *PluginSSHAgent) Render(project *ProjectSandbox) error {
+  for _, sshKey := range findSSHPublicKeys(project) {
     // Check if this is a file in the sandbox that is just missing
     // in which case we will exploit the opportunity to create it
     if project.IsFileInSandbox(sshKey) && !project.HasFile(sshKey) {
@@ -86,33 +205,160 @@ func (p *PluginSSHAgent) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper
       if err != nil {
         return fmt.Errorf("Could not create RSA keypair: %s", err.Error())
       }
+      if err := project.EnsureGitIgnored(GetPrivateKeyNameFromPublic(sshKey)); err != nil {
+        return err
+      }
     }
+  }
+  return nil
+}
 
-    // Try to deduce the private key from the public key
-    privKey := GetPrivateKeyNameFromPublic(sshKey)
-    _, err = os.Stat(privKey)
-    if err != nil {
-      return fmt.Errorf("Could not find private key for %s (searching for %s)", Bold(sshKey), privKey)
-    }
+func (p *PluginSSHAgent) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  // The agent is stopped with `wheels-agent stop`
+  return nil
+}
 
-    // Add it to the SSH agent
-    PrintInfo("Loaded private key %s in ssh-agent", Bold(privKey))
-    err = sshagent.AddKey(privKey)
+func (p *PluginSSHAgent) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginSSHAgentCmdRotateKey{},
+    &PluginSSHAgentCmdAgent{},
+  }
+}
+
+type PluginSSHAgentCmdAgent struct {
+}
+
+func (p *PluginSSHAgentCmdAgent) GetName() string {
+  return "wheels-agent"
+}
+
+func (p *PluginSSHAgentCmdAgent) GetDescription() string {
+  return "Shows or stops the ssh-agent of the project"
+}
+
+func (p *PluginSSHAgentCmdAgent) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  action := "status"
+  if fSet.NArg() > 0 {
+    action = fSet.Arg(0)
+  }
+  if *help || fSet.NArg() > 1 || (action != "status" && action != "stop") {
+    PrintHelp(p.GetName(), "[status|stop]", []interface{}{
+      "The SSH keys of the project are loaded in an ssh-agent started for this",
+      "project, that is kept running and reused by the next runs. This command",
+      "shows where it's listening (status), or stops it (stop).",
+    }, fSet)
+    return nil
+  }
+
+  if action == "stop" {
+    stopped, err := project.StopProjectSSHAgent()
     if err != nil {
       return err
     }
+    if stopped {
+      PrintInfo("Stopped the ssh-agent of the project")
+    } else {
+      PrintInfo("The ssh-agent of the project is not running")
+    }
+    return nil
   }
 
+  state, err := project.GetSSHAgentState()
+  if err != nil {
+    return err
+  }
+  if state == nil {
+    PrintInfo("The ssh-agent of the project is not running")
+    return nil
+  }
+  agent, err := CreateSSHAgentWrapper()
+  if err != nil {
+    return err
+  }
+  agent.Socket = state.Socket
+  agent.Pid = state.Pid
+  if !agent.IsRunning() {
+    PrintInfo("The ssh-agent of the project is not running anymore")
+    return nil
+  }
+  PrintInfo("The ssh-agent of the project is running since %s (pid=%d)", state.StartedAt.Local().Format("2006-01-02 15:04"), state.Pid)
+  Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK\n", state.Socket)
   return nil
 }
 
-func (p *PluginSSHAgent) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
-  err := p.agent.Stop()
-  return err
+type PluginSSHAgentCmdRotateKey struct {
 }
 
-func (p *PluginSSHAgent) GetCommands() []PluginCommand {
-  return []PluginCommand{}
+func (p *PluginSSHAgentCmdRotateKey) GetName() string {
+  return "rotate-ssh-key"
+}
+
+func (p *PluginSSHAgentCmdRotateKey) GetDescription() string {
+  return "Replaces one of the SSH keys of the cluster with a new one"
+}
+
+func (p *PluginSSHAgentCmdRotateKey) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  keys := findSSHPublicKeys(project)
+  if *help || fSet.NArg() != 1 {
+    PrintHelp(p.GetName(), "<public key file>", []interface{}{
+      "This command will create a new key pair in place of the given one, keeping",
+      "the old one with a .old suffix. The next apply replaces the AWS key pair,",
+      "and the nodes that are using it, without touching the nodes that are using",
+      "the other keys.",
+      "",
+      fmt.Sprintf("The SSH keys of this project are: %v", keys),
+    }, fSet)
+    return nil
+  }
+
+  sshKey := fSet.Arg(0)
+  known := false
+  for _, key := range keys {
+    if key == sshKey {
+      known = true
+    }
+  }
+  if !known {
+    return fmt.Errorf("The key %s is not used in this project", sshKey)
+  }
+
+  for _, file := range []string{sshKey, GetPrivateKeyNameFromPublic(sshKey)} {
+    path := project.GetFilePath(file)
+    if _, err := os.Stat(path); err != nil {
+      continue
+    }
+    os.Remove(path + ".old")
+    if err := os.Rename(path, path+".old"); err != nil {
+      return fmt.Errorf("Could not keep the old key: %s", err.Error())
+    }
+  }
+
+  err = CreateRSAKeyPair(project.GetFilePath(GetPrivateKeyNameFromPublic(sshKey)), project.GetFilePath(sshKey))
+  if err != nil {
+    return fmt.Errorf("Could not create RSA keypair: %s", err.Error())
+  }
+  if err := project.EnsureGitIgnored(GetPrivateKeyNameFromPublic(sshKey), GetPrivateKeyNameFromPublic(sshKey)+".old"); err != nil {
+    return err
+  }
+
+  PrintInfo("Created a new key pair in %s, run %s to use it", Bold(sshKey), Bold(os.Args[0]+" apply"))
+  return nil
 }
 
 func (p *PluginSSHAgent) ensureSSHKey() {
@@ -0,0 +1,131 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginState struct {
+}
+
+func CreatePluginState() *PluginState {
+  return &PluginState{}
+}
+
+func (p *PluginState) GetName() string {
+  return "state"
+}
+
+func (p *PluginState) Requires() []string {
+  return nil
+}
+
+func (p *PluginState) Priority() int {
+  return 0
+}
+
+func (p *PluginState) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginState) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginState) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginState) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginStateCmdState{},
+  }
+}
+
+type PluginStateCmdState struct {
+}
+
+func (p *PluginStateCmdState) GetName() string {
+  return "wheels-state"
+}
+
+func (p *PluginStateCmdState) GetDescription() string {
+  return "Takes, lists and restores snapshots of the terraform state"
+}
+
+func (p *PluginStateCmdState) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fYes := fSet.Bool("yes", false, "Do not ask for confirmation before restoring")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "snapshot|list|restore <name|latest>", []interface{}{
+      "This command manages the snapshots of the terraform state, that are kept",
+      "in .wheels/state-backups/<workspace>. A snapshot is also taken before",
+      "every apply and destroy. The state is pulled from the backend, so the",
+      "remote states are saved locally too.",
+      "",
+      "Restoring a snapshot pushes it back to the backend, after taking a",
+      "snapshot of the current state.",
+    }, fSet)
+    return nil
+  }
+
+  switch fSet.Arg(0) {
+  case "snapshot":
+    name, err := project.SnapshotState(tf, "manual")
+    if err != nil {
+      return err
+    }
+    if name == "" {
+      PrintInfo("The state did not change since the last snapshot (or there is none yet)")
+    } else {
+      PrintInfo("Saved the state in the %s snapshot", Bold(name))
+    }
+    return nil
+
+  case "list":
+    snapshots, err := project.ListStateSnapshots()
+    if err != nil {
+      return err
+    }
+    if len(snapshots) == 0 {
+      PrintInfo("There are no state snapshots in the '%s' workspace", project.GetWorkspace())
+      return nil
+    }
+    Printf("%-44s %-20s %6s %9s\n", "SNAPSHOT", "TAKEN", "SERIAL", "RESOURCES")
+    for _, snapshot := range snapshots {
+      Printf("%-44s %-20s %6d %9d\n", snapshot.Name, snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"), snapshot.Serial, snapshot.Resources)
+    }
+    return nil
+
+  case "restore":
+    if fSet.Arg(1) == "" {
+      return fmt.Errorf("Please give the snapshot to restore, or `latest`")
+    }
+    snapshot, err := project.FindStateSnapshot(fSet.Arg(1))
+    if err != nil {
+      return err
+    }
+    if !*fYes && (!IsInteractive() || !ReadYN(fmt.Sprintf("Replace the state of the '%s' workspace with %s?", project.GetWorkspace(), snapshot.Name))) {
+      return fmt.Errorf("Not restoring anything, use -yes to skip the confirmation")
+    }
+    if err := project.RestoreStateSnapshot(tf, snapshot); err != nil {
+      return err
+    }
+    PrintInfo("Restored the state from %s, run `%s plan` to see how it differs from the infrastructure", Bold(snapshot.Name), os.Args[0])
+    return nil
+  }
+
+  return fmt.Errorf("Unknown action '%s', use %s %s -help to see the available ones", fSet.Arg(0), os.Args[0], p.GetName())
+}
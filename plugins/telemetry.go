@@ -0,0 +1,144 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginTelemetry struct {
+}
+
+func CreatePluginTelemetry() *PluginTelemetry {
+  return &PluginTelemetry{}
+}
+
+func (p *PluginTelemetry) GetName() string {
+  return "telemetry"
+}
+
+func (p *PluginTelemetry) Requires() []string {
+  return nil
+}
+
+func (p *PluginTelemetry) Priority() int {
+  return 0
+}
+
+func (p *PluginTelemetry) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginTelemetry) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginTelemetry) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginTelemetry) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginTelemetryCmdTelemetry{},
+  }
+}
+
+type PluginTelemetryCmdTelemetry struct {
+}
+
+func (p *PluginTelemetryCmdTelemetry) GetName() string {
+  return "wheels-telemetry"
+}
+
+func (p *PluginTelemetryCmdTelemetry) GetDescription() string {
+  return "Turns the anonymous usage metrics on or off, and shows what they contain"
+}
+
+func (p *PluginTelemetryCmdTelemetry) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fEndpoint := fSet.String("endpoint", "", "Send the metrics to this URL (as a JSON POST) instead of a local file")
+  fFile := fSet.String("file", "", "Append the metrics to this file (defaults to ~/.terraform-wheels/telemetry.jsonl)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help || fSet.NArg() == 0 {
+    PrintHelp(p.GetName(), "on|off|show", []interface{}{
+      "This command turns on (opt-in) or off the recording of anonymous usage",
+      "metrics: the command that was run, how long it took, the exit code of",
+      "terraform and the plugins used by the project. The arguments, the paths",
+      "and anything about the clusters are never recorded.",
+      "",
+      "The metrics are appended to a local file, or sent to the given endpoint.",
+      "Set TERRAFORM_WHEELS_TELEMETRY=0 (or DO_NOT_TRACK=1) to turn them off for",
+      "a single run.",
+    }, fSet)
+    return nil
+  }
+
+  settings, err := LoadTelemetrySettings()
+  if err != nil {
+    return err
+  }
+
+  switch fSet.Arg(0) {
+  case "on":
+    settings.Enabled = true
+    if *fEndpoint != "" || *fFile != "" {
+      settings.Endpoint = *fEndpoint
+      settings.File = *fFile
+    }
+    if err := SaveTelemetrySettings(settings); err != nil {
+      return err
+    }
+    PrintInfo("Thank you! The usage metrics are recorded to %s", Bold(getTelemetryDestination(settings)))
+    return nil
+
+  case "off":
+    settings.Enabled = false
+    if err := SaveTelemetrySettings(settings); err != nil {
+      return err
+    }
+    PrintInfo("The usage metrics are not recorded any more")
+    return nil
+
+  case "show":
+    status := "off"
+    if settings.Enabled {
+      status = "on"
+      if IsTelemetryDisabledByEnv() {
+        status = "on, but turned off by the environment"
+      }
+    }
+    PrintInfo("Telemetry: %s", Bold(status))
+    if settings.Enabled {
+      PrintInfo("Recorded to: %s", getTelemetryDestination(settings))
+    }
+    PrintInfo("This is what is recorded for this command:")
+    Println(FormatJSON(GetTelemetryEvent(settings, false)))
+    return nil
+  }
+
+  return fmt.Errorf("Unknown action '%s', use %s %s -help to see the available ones", fSet.Arg(0), os.Args[0], p.GetName())
+}
+
+func getTelemetryDestination(settings *TelemetrySettings) string {
+  if settings.Endpoint != "" {
+    return settings.Endpoint
+  }
+  if settings.File != "" {
+    return settings.File
+  }
+  file, err := GetDefaultTelemetryFile()
+  if err != nil {
+    return err.Error()
+  }
+  return file
+}
@@ -0,0 +1,222 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginTFEBackend struct {
+}
+
+func CreatePluginTFEBackend() *PluginTFEBackend {
+  return &PluginTFEBackend{}
+}
+
+func (p *PluginTFEBackend) GetName() string {
+  return "tfe-backend"
+}
+
+func (p *PluginTFEBackend) Requires() []string {
+  return nil
+}
+
+func (p *PluginTFEBackend) Priority() int {
+  return 0
+}
+
+func (p *PluginTFEBackend) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginTFEBackend) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginTFEBackend) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginTFEBackend) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginTFEBackendCmdAdd{},
+  }
+}
+
+type PluginTFEBackendCmdAdd struct {
+}
+
+func (p *PluginTFEBackendCmdAdd) GetName() string {
+  return "add-tfe-backend"
+}
+
+func (p *PluginTFEBackendCmdAdd) GetDescription() string {
+  return "Keeps the state (and runs) in a Terraform Cloud / Enterprise workspace"
+}
+
+func (p *PluginTFEBackendCmdAdd) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  cfg := project.GetConfig().TFE
+  if cfg.Hostname == "" {
+    cfg.Hostname = DefaultTFEHostname
+  }
+  dir, err := os.Getwd()
+  if err != nil {
+    return err
+  }
+
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fHostname := fSet.String("hostname", cfg.Hostname, "The Terraform Cloud / Enterprise host")
+  fOrganization := fSet.String("organization", cfg.Organization, "The organization of the workspace")
+  fWorkspace := fSet.String("workspace", filepath.Base(dir), "The name of the workspace")
+  fPrefix := fSet.String("prefix", "", "Use a workspace per environment (see wheels-env) with this prefix, instead of a single workspace")
+  fLocal := fSet.Bool("local", false, "Only keep the state in the workspace, running terraform locally")
+  fPushVariables := fSet.Bool("push-variables", false, "Push the credentials and the sensitive variables to the workspace, for its runs")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err = ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will generate the `remote` backend configuration of the",
+      "project, and create the workspace in Terraform Cloud (or Enterprise) if",
+      "it does not exist. Unless -local is given, the runs happen in the",
+      "workspace, and they need the credentials. With -push-variables, the AWS",
+      "credentials and the DC/OS token are pushed as sensitive environment",
+      "variables, and the sensitive variables of the project as sensitive",
+      "terraform variables. Temporary AWS credentials are never pushed.",
+      "",
+      "The API token is read from TFE_TOKEN, from the `tfe.token_file` of",
+      ".wheels.yaml, or from the terraform CLI configuration.",
+    }, fSet)
+    return nil
+  }
+
+  if *fOrganization == "" {
+    return fmt.Errorf("Please give the organization with -organization, or `tfe.organization` in .wheels.yaml")
+  }
+  workspace := *fWorkspace
+  if *fPrefix != "" {
+    workspace = *fPrefix + project.GetWorkspace()
+  }
+
+  token, terraformHasToken, err := project.GetTFEToken(*fHostname)
+  if err != nil {
+    return err
+  }
+  client := CreateTFEClient(*fHostname, token)
+  wsID, created, err := client.EnsureWorkspace(*fOrganization, workspace, !*fLocal)
+  if err != nil {
+    return err
+  }
+  if created {
+    PrintInfo("Created the workspace %s in %s", Bold(workspace), Bold(*fOrganization))
+  } else {
+    PrintInfo("Using the existing workspace %s in %s", Bold(workspace), Bold(*fOrganization))
+  }
+
+  if !*fLocal && *fPushVariables {
+    variables, err := p.collectVariables(project, tf)
+    if err != nil {
+      return err
+    }
+    var names []string
+    for _, v := range variables {
+      names = append(names, v.Key)
+    }
+    if len(names) > 0 {
+      // Everybody with access to the workspace can use them
+      PrintInfo("Pushing %s to the workspace", Bold(strings.Join(names, ", ")))
+    }
+    if err := client.SetVariables(wsID, variables); err != nil {
+      return err
+    }
+  } else if !*fLocal {
+    PrintInfo("The runs in the workspace need the AWS credentials and the sensitive variables, set them in the workspace or use -push-variables")
+  }
+
+  workspaceLine := fmt.Sprintf(`      name = %s`, FormatJSON(*fWorkspace))
+  if *fPrefix != "" {
+    workspaceLine = fmt.Sprintf(`      prefix = %s`, FormatJSON(*fPrefix))
+  }
+  group := CreateTerraformFileGroup(p.GetName())
+  group.AddFile("backend-tfe.tf", []byte(strings.Join([]string{
+    `terraform {`,
+    `  backend "remote" {`,
+    fmt.Sprintf(`    hostname     = %s`, FormatJSON(*fHostname)),
+    fmt.Sprintf(`    organization = %s`, FormatJSON(*fOrganization)),
+    ``,
+    `    workspaces {`,
+    workspaceLine,
+    `    }`,
+    `  }`,
+    `}`,
+  }, "\n")))
+  PrintInfo("%s%s%s", Bold("Writing "), Bold(Green("backend-tfe.tf")), Bold(" with the backend configuration"))
+  if err := project.WriteTerraformFileGroup(group); err != nil {
+    return err
+  }
+
+  if !terraformHasToken {
+    PrintWarning("Terraform reads the token from its CLI configuration, please add it to %s:", GetTerraformCLIConfigFile())
+    Printf("\n  credentials %s {\n    token = \"...\"\n  }\n\n", FormatJSON(*fHostname))
+  }
+  PrintInfo("Run `%s init` to move the state to the workspace", os.Args[0])
+  return nil
+}
+
+// What the runs in the workspace need, that is not in the project files
+func (p *PluginTFEBackendCmdAdd) collectVariables(project *ProjectSandbox, tf *TerraformWrapper) ([]TFEVariable, error) {
+  var variables []TFEVariable
+
+  if sess, err := GetAWSSession(""); err == nil {
+    if creds, err := sess.Config.Credentials.Get(); err == nil {
+      // The runs would fail once they expire
+      if creds.SessionToken != "" {
+        PrintWarning("The AWS credentials are temporary, not pushing them, please set long-lived ones in the workspace")
+      } else {
+        variables = append(variables,
+          TFEVariable{Key: "AWS_ACCESS_KEY_ID", Value: creds.AccessKeyID, Category: "env"},
+          TFEVariable{Key: "AWS_SECRET_ACCESS_KEY", Value: creds.SecretAccessKey, Category: "env", Sensitive: true},
+        )
+      }
+    }
+  }
+
+  // The cluster might not exist yet, in which case the provider is
+  // configured by the module outputs
+  clusterUrl := project.GetConfig().Dcos.URL
+  if tf != nil {
+    clusterUrl = getDcosClusterURL(project, tf)
+  }
+  if creds, err := project.LookupDcosCredentials(clusterUrl); err != nil {
+    return nil, err
+  } else if creds != nil && creds.Token != "" {
+    variables = append(variables, TFEVariable{Key: "DCOS_ACS_TOKEN", Value: creds.Token, Category: "env", Sensitive: true})
+  }
+
+  if project.HasFile(SecretVariablesFile) {
+    values, err := project.ReadTfvarsValues(SecretVariablesFile)
+    if err != nil {
+      return nil, err
+    }
+    var names []string
+    for name := range values {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+      variables = append(variables, TFEVariable{Key: name, Value: values[name], Category: "terraform", Sensitive: true})
+    }
+  }
+  return variables, nil
+}
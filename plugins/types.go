@@ -13,9 +13,48 @@ type PluginCommand interface {
 type Plugin interface {
 	GetName() string
 
+	// The plugins (by name) whose hooks must run before the ones of this
+	// plugin, when they are used
+	Requires() []string
+	// Orders the plugins that do not require each other, the highest first
+	Priority() int
+
 	IsUsed(project *ProjectSandbox) (bool, error)
 	BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error
 	AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error
 
 	GetCommands() []PluginCommand
 }
+
+// Plugins that generate files before terraform runs, so the files can also
+// be generated without running it (with wheels-render)
+type PluginRenderer interface {
+	Render(project *ProjectSandbox) error
+}
+
+// Commands that run terraform themselves, with the hooks of the plugins of
+// the project (eg. to apply what they generated)
+type PluginCommandWithTerraform interface {
+	SetTerraformRunner(run func(args []string) error)
+}
+
+// Commands that run terraform on other projects than the current one (eg. the
+// registered clusters), with the hooks of the plugins of those projects
+type PluginCommandWithProjectTerraform interface {
+	SetProjectTerraformRunner(run func(project *ProjectSandbox, tf *TerraformWrapper, args []string) error)
+}
+
+// Plugins that log with a leveled logger, so their messages are attributed to
+// them in the log of the project. It's given to them before their hooks run.
+type PluginWithLogger interface {
+	SetLogger(logger *Logger)
+}
+
+// Embedded by the plugins that log with the logger they are given
+type pluginLogger struct {
+	log *Logger
+}
+
+func (p *pluginLogger) SetLogger(logger *Logger) {
+	p.log = logger
+}
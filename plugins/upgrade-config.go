@@ -0,0 +1,143 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginUpgradeConfig struct {
+}
+
+func CreatePluginUpgradeConfig() *PluginUpgradeConfig {
+  return &PluginUpgradeConfig{}
+}
+
+func (p *PluginUpgradeConfig) GetName() string {
+  return "upgrade-config"
+}
+
+func (p *PluginUpgradeConfig) Requires() []string {
+  return nil
+}
+
+func (p *PluginUpgradeConfig) Priority() int {
+  return 0
+}
+
+func (p *PluginUpgradeConfig) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginUpgradeConfig) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginUpgradeConfig) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginUpgradeConfig) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginUpgradeConfigCmdUpgrade{},
+  }
+}
+
+type PluginUpgradeConfigCmdUpgrade struct {
+}
+
+func (p *PluginUpgradeConfigCmdUpgrade) GetName() string {
+  return "wheels-upgrade-config"
+}
+
+func (p *PluginUpgradeConfigCmdUpgrade) GetDescription() string {
+  return "Checks if the project is ready to be upgraded to terraform 0.12"
+}
+
+// The first terraform release with the `0.12checklist` command
+var checklistTerraformVersion string = "0.11.14"
+
+func (p *PluginUpgradeConfigCmdUpgrade) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fNoPlan := fSet.Bool("no-plan", false, "Do not check that the plan is empty (eg. without cloud credentials)")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will run the `terraform 0.12checklist` of the project, to",
+      "find what must be changed before upgrading to terraform 0.12, and check",
+      "that the plan is empty, so that the changes of the upgrade itself can be",
+      "told apart. It also lists the files generated by terraform-wheels, that",
+      "are rewritten with the add-* commands instead of by hand.",
+      "",
+      fmt.Sprintf("terraform-wheels still generates and runs terraform %sx, so the", RequiredTerraformVersionPrefix),
+      "upgrade itself (`terraform 0.12upgrade`) is not run yet.",
+    }, fSet)
+    return nil
+  }
+
+  version, err := tf.GetVersion()
+  if err != nil {
+    return err
+  }
+  if v, err := semver.NewVersion(version); err == nil && v.LessThan(semver.MustParse(checklistTerraformVersion)) {
+    return WithExitCode(ExitConfigError, fmt.Errorf("The 0.12 checklist needs terraform v%s or later, but the project uses v%s", checklistTerraformVersion, version))
+  }
+
+  // The checklist only reports what would block `0.12upgrade`
+  PrintInfo("Running the terraform 0.12 checklist")
+  if err := tf.Invoke([]string{"0.12checklist"}); err != nil {
+    return err
+  }
+  ready := strings.Contains(tf.GetLastOutput(), "Looks good!")
+
+  if !*fNoPlan {
+    if err := AttachAWSCredentials(tf); err != nil {
+      return err
+    }
+    PrintInfo("Checking that there are no pending changes")
+    tf.Invoke(project.AddWorkspaceVarFile([]string{"plan", "-detailed-exitcode", "-input=false"}))
+    if code := tf.GetLastExitCode(); code == 2 {
+      PrintWarning("The plan is not empty, apply it (or revert the changes) before upgrading")
+      ready = false
+    } else if code != 0 {
+      return WithExitCode(ExitTerraformFailed, fmt.Errorf("The plan failed with exit code %d", code))
+    }
+  }
+
+  groups, err := project.LoadFileGroups()
+  if err != nil {
+    return err
+  }
+  if len(groups) > 0 {
+    var names []string
+    for name := range groups {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    Println()
+    Printf("%s\n", Bold("Generated by terraform-wheels (rewrite them with their command, not by hand):"))
+    for _, name := range names {
+      Printf("  %-24s %s\n", name, strings.Join(groups[name], ", "))
+    }
+    Println()
+  }
+
+  if !ready {
+    return WithExitCode(ExitValidationFailed, fmt.Errorf("The project is not ready for terraform 0.12 yet, see above"))
+  }
+  PrintInfo("The project is ready for terraform 0.12. terraform-wheels still runs terraform %sx, so keep using `%s` until it supports 0.12", RequiredTerraformVersionPrefix, os.Args[0])
+  return nil
+}
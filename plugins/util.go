@@ -4,6 +4,7 @@ import (
   "encoding/json"
   "fmt"
   "io/ioutil"
+  "sort"
 )
 
 func ToJson(iface interface{}) string {
@@ -38,7 +39,15 @@ func interfaceToLines(iface interface{}, path string, lines []string) []string {
 
   case map[string]interface{}:
 
-    for k, v := range v {
+    // Sorted, so the generated files do not change between runs
+    var keys []string
+    for k := range v {
+      keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    for _, k := range keys {
+      v := v[k]
       switch sv := v.(type) {
       case string:
         segLines = append(segLines, fmt.Sprintf("    %s = %s,", k, ToJson(sv)))
@@ -93,10 +102,20 @@ func LoadServiceJsonToConfigLines(filename string) ([]string, error) {
     return nil, err
   }
 
+  return ServiceConfigToLines(config), nil
+}
+
+func ServiceConfigToLines(config map[string]interface{}) []string {
+  var keys []string
+  for k := range config {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
   var lines []string
-  for k, v := range config {
-    lines = interfaceToLines(v, k, lines)
+  for _, k := range keys {
+    lines = interfaceToLines(config[k], k, lines)
   }
 
-  return lines, nil
+  return lines
 }
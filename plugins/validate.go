@@ -0,0 +1,122 @@
+package plugins
+
+import (
+  "flag"
+  "fmt"
+
+  . "github.com/logrusorgru/aurora"
+  . "github.com/mesosphere-incubator/terraform-wheels/utils"
+)
+
+type PluginValidate struct {
+}
+
+func CreatePluginValidate() *PluginValidate {
+  return &PluginValidate{}
+}
+
+func (p *PluginValidate) GetName() string {
+  return "validate"
+}
+
+func (p *PluginValidate) Requires() []string {
+  return nil
+}
+
+func (p *PluginValidate) Priority() int {
+  return 0
+}
+
+func (p *PluginValidate) IsUsed(project *ProjectSandbox) (bool, error) {
+  return false, nil
+}
+
+func (p *PluginValidate) BeforeRun(project *ProjectSandbox, tf *TerraformWrapper, initRun bool) error {
+  return nil
+}
+
+func (p *PluginValidate) AfterRun(project *ProjectSandbox, tf *TerraformWrapper, tfErr error) error {
+  return nil
+}
+
+func (p *PluginValidate) GetCommands() []PluginCommand {
+  return []PluginCommand{
+    &PluginValidateCmdValidate{},
+  }
+}
+
+type PluginValidateCmdValidate struct {
+}
+
+func (p *PluginValidateCmdValidate) GetName() string {
+  return "wheels-validate"
+}
+
+func (p *PluginValidateCmdValidate) GetDescription() string {
+  return "Checks the cluster configuration against the DC/OS constraints"
+}
+
+func (p *PluginValidateCmdValidate) Handle(args []string, project *ProjectSandbox, tf *TerraformWrapper) error {
+  fSet := flag.NewFlagSet(p.GetName(), flag.ContinueOnError)
+  fSkipTerraform := fSet.Bool("skip-terraform", false, "Do not run `terraform validate` first")
+  fSkipCloud := fSet.Bool("skip-cloud", false, "Do not check the instance types and availability zones with the AWS API")
+
+  help := fSet.Bool("help", false, "Show this help message")
+  fSet.BoolVar(help, "h", false, "Show this help message")
+  err := ParseCommandFlags(fSet, args)
+  if err != nil {
+    return err
+  }
+
+  if *help {
+    PrintHelp(p.GetName(), "", []interface{}{
+      "This command will run `terraform validate`, and then check the cluster",
+      "against the DC/OS constraints: an odd number of masters, a license for",
+      "Enterprise DC/OS, and (using the AWS API) instance types and availability",
+      "zones that are available in the region. All the problems found are",
+      "reported together.",
+    }, fSet)
+    return nil
+  }
+
+  var problems []string
+  if !*fSkipTerraform {
+    if project.HasFile(".terraform") {
+      if err := tf.Invoke([]string{"validate"}); err != nil {
+        problems = append(problems, fmt.Sprintf("terraform validate: %s", err.Error()))
+      }
+    } else {
+      PrintInfo("The project is not initialized, skipping %s", Bold("terraform validate"))
+    }
+  }
+
+  checkCloud := !*fSkipCloud
+  if checkCloud && IsOffline() {
+    PrintInfo("Running in offline mode, skipping the checks with the AWS API")
+    checkCloud = false
+  } else if checkCloud && !IsAWSCredsOK() {
+    PrintWarning("Could not find (still valid) AWS credentials, skipping the checks with the AWS API")
+    checkCloud = false
+  }
+
+  table, err := project.GetPriceTable()
+  if err != nil {
+    return err
+  }
+  issues, err := project.ValidateProject(table, checkCloud)
+  if err != nil {
+    return err
+  }
+  for _, issue := range issues {
+    problems = append(problems, fmt.Sprintf("%s: %s", issue.Address, issue.Message))
+  }
+
+  if len(problems) == 0 {
+    PrintInfo("The cluster configuration is valid")
+    return nil
+  }
+  for _, problem := range problems {
+    Printf("%s %s\n", Red("Problem:"), problem)
+  }
+  return WithExitCode(ExitValidationFailed, fmt.Errorf("Found %d problem(s) in the cluster configuration", len(problems)))
+}
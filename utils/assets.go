@@ -0,0 +1,81 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path"
+  "path/filepath"
+  "sort"
+
+  "github.com/mesosphere-incubator/terraform-wheels/assets"
+)
+
+/**
+ * Returns the directory where the user can override the assets that are
+ * bundled in the binary
+ */
+func GetAssetsOverrideDir() (string, error) {
+  if dir, ok := os.LookupEnv("TERRAFORM_WHEELS_ASSETS"); ok && dir != "" {
+    return dir, nil
+  }
+
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "assets"), nil
+}
+
+/**
+ * Reads the given asset (eg. `completion/terraform-wheels.bash`), preferring
+ * the copy in the override directory over the one bundled in the binary
+ */
+func ReadAsset(name string) ([]byte, error) {
+  if dir, err := GetAssetsOverrideDir(); err == nil {
+    content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+    if err == nil {
+      return content, nil
+    }
+    if !os.IsNotExist(err) {
+      return nil, fmt.Errorf("Could not read %s from %s: %s", name, dir, err.Error())
+    }
+  }
+
+  content, err := assets.Files.ReadFile(path.Clean(name))
+  if err != nil {
+    return nil, fmt.Errorf("Could not find the asset %s", name)
+  }
+  return content, nil
+}
+
+/**
+ * Lists the names of the assets in the given directory, including the ones
+ * that only exist in the override directory
+ */
+func ListAssets(dir string) ([]string, error) {
+  found := make(map[string]bool)
+  if entries, err := assets.Files.ReadDir(path.Clean(dir)); err == nil {
+    for _, entry := range entries {
+      if !entry.IsDir() {
+        found[entry.Name()] = true
+      }
+    }
+  }
+  if overrideDir, err := GetAssetsOverrideDir(); err == nil {
+    if entries, err := ioutil.ReadDir(filepath.Join(overrideDir, filepath.FromSlash(dir))); err == nil {
+      for _, entry := range entries {
+        if !entry.IsDir() {
+          found[entry.Name()] = true
+        }
+      }
+    }
+  }
+
+  var names []string
+  for name := range found {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names, nil
+}
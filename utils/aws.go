@@ -1,18 +1,118 @@
 package utils
 
 import (
-  "github.com/aws/aws-sdk-go/aws/session"
+  "fmt"
+  "regexp"
+
   "github.com/aws/aws-sdk-go/service/sts"
 )
 
 func IsAWSCredsOK() bool {
-  svc := sts.New(session.New())
+  sess, err := GetAWSSession("")
+  if err != nil {
+    return false
+  }
+  svc := sts.New(sess)
   input := &sts.GetCallerIdentityInput{}
 
-  _, err := svc.GetCallerIdentity(input)
+  _, err = svc.GetCallerIdentity(input)
   if err != nil {
     return false
   }
 
   return true
 }
+
+// The families and the sizes of the EC2 instance types
+var awsInstanceFamilies []string = []string{
+  "a1", "c1", "c3", "c4", "c5", "c5a", "c5ad", "c5d", "c5n", "c6g", "c6gd", "c6gn", "c6i",
+  "cc2", "d2", "d3", "d3en", "f1", "g2", "g3", "g3s", "g4ad", "g4dn", "g5", "h1", "i2",
+  "i3", "i3en", "inf1", "m1", "m2", "m3", "m4", "m5", "m5a", "m5ad", "m5d", "m5dn", "m5n",
+  "m5zn", "m6g", "m6gd", "m6i", "p2", "p3", "p3dn", "p4d", "r3", "r4", "r5", "r5a", "r5ad",
+  "r5b", "r5d", "r5dn", "r5n", "r6g", "r6gd", "r6i", "t1", "t2", "t3", "t3a", "t4g", "x1",
+  "x1e", "x2gd", "z1d", "c6a", "c7g", "c7i", "g6", "m6a", "m7a", "m7g", "m7i", "r6a", "r7g",
+  "r7i",
+}
+
+var awsInstanceSizes []string = []string{
+  "nano", "micro", "small", "medium", "large", "xlarge", "2xlarge", "3xlarge", "4xlarge",
+  "6xlarge", "8xlarge", "9xlarge", "10xlarge", "12xlarge", "16xlarge", "18xlarge",
+  "24xlarge", "32xlarge", "48xlarge", "metal",
+}
+
+// The format of the names of the instance types, eg. m5.xlarge or u-6tb1.metal
+var awsInstanceTypeFormat *regexp.Regexp = regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`)
+
+var allowUnknownInstanceTypes bool = false
+
+/**
+ * Accepts the instance types that are not known (eg. a family that is newer
+ * than this version of terraform-wheels), with a warning
+ */
+func SetAllowUnknownInstanceTypes(enabled bool) {
+  allowUnknownInstanceTypes = enabled
+}
+
+/**
+ * Returns the names of the EC2 instance types (every size of every family,
+ * even if some of them do not exist), to catch the typos in the flags
+ */
+func GetAWSInstanceTypes() []string {
+  var types []string
+  for _, family := range awsInstanceFamilies {
+    for _, size := range awsInstanceSizes {
+      types = append(types, family+"."+size)
+    }
+  }
+  return types
+}
+
+/**
+ * Checks that the value is the name of a known instance type (family.size),
+ * suggesting the closest one on a typo. AWS keeps adding new ones, so the
+ * unknown ones are only a warning with --allow-unknown-instance-types
+ * (wheels-validate checks that they exist in the region).
+ */
+func CheckAWSInstanceType(value string) error {
+  if !awsInstanceTypeFormat.MatchString(value) {
+    return fmt.Errorf("expected an instance type like m5.xlarge")
+  }
+  known := GetAWSInstanceTypes()
+  if containsString(known, value) {
+    return nil
+  }
+  if allowUnknownInstanceTypes {
+    PrintWarning("%s is not a known instance type, using it anyway", value)
+    return nil
+  }
+  if suggestion := SuggestValue(value, known); suggestion != "" {
+    return fmt.Errorf("did you mean '%s'? (use --allow-unknown-instance-types for a newer type)", suggestion)
+  }
+  return fmt.Errorf("not a known instance type (use --allow-unknown-instance-types for a newer type)")
+}
+
+/**
+ * A flag that takes the name of an instance type
+ */
+type AWSInstanceTypeFlag struct {
+  Value string
+}
+
+func CreateAWSInstanceTypeFlag(value string) *AWSInstanceTypeFlag {
+  return &AWSInstanceTypeFlag{value}
+}
+
+func (f *AWSInstanceTypeFlag) String() string {
+  if f == nil {
+    return ""
+  }
+  return f.Value
+}
+
+func (f *AWSInstanceTypeFlag) Set(value string) error {
+  if err := CheckAWSInstanceType(value); err != nil {
+    return err
+  }
+  f.Value = value
+  return nil
+}
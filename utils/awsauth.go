@@ -0,0 +1,277 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "os/user"
+  "strings"
+  "time"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/credentials"
+  "github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+  "github.com/aws/aws-sdk-go/aws/session"
+  . "github.com/logrusorgru/aurora"
+)
+
+var awsProfileFlag string = ""
+
+/**
+ * Uses the given AWS profile, instead of the one in .wheels.yaml
+ */
+func SetAWSProfile(profile string) {
+  awsProfileFlag = profile
+}
+
+/**
+ * The AWS credentials to give to terraform, in .wheels.yaml
+ */
+type AWSAuthConfig struct {
+  Profile         string `yaml:"profile"`
+  RoleARN         string `yaml:"role_arn"`
+  MFASerial       string `yaml:"mfa_serial"`
+  ExternalID      string `yaml:"external_id"`
+  SessionDuration string `yaml:"session_duration"`
+  RefreshBefore   string `yaml:"refresh_before"`
+
+  // A command that fetches short-lived credentials (eg. `maws login {profile}`)
+  CredentialHelper      string `yaml:"credential_helper"`
+  HelperSessionDuration string `yaml:"helper_session_duration"`
+}
+
+/**
+ * Temporary credentials, as they are cached between runs
+ */
+type cachedAWSSession struct {
+  AccessKeyID     string    `json:"access_key_id"`
+  SecretAccessKey string    `json:"secret_access_key"`
+  SessionToken    string    `json:"session_token"`
+  Expiration      time.Time `json:"expiration"`
+}
+
+/**
+ * @brief      Resolves the AWS credentials of a profile or an assumed role,
+ *             and keeps them fresh
+ *
+ * The temporary credentials are cached in the user credential store until
+ * they are about to expire, so the MFA code is only asked once per session.
+ */
+type AWSCredentialBroker struct {
+  cfg           AWSAuthConfig
+  profile       string
+  refreshBefore time.Duration
+
+  sess     *session.Session
+  creds    *credentials.Credentials
+  exported string
+}
+
+var awsBroker *AWSCredentialBroker = nil
+
+/**
+ * Configures the AWS credentials from the --aws-profile flag and the given
+ * configuration. Without a profile or a role, the ambient credentials are
+ * used as they are.
+ */
+func ConfigureAWSAuth(cfg AWSAuthConfig) {
+  awsAuthConfig = cfg
+  profile := cfg.Profile
+  if awsProfileFlag != "" {
+    profile = awsProfileFlag
+  }
+  if profile == "" && cfg.RoleARN == "" {
+    awsBroker = nil
+    return
+  }
+
+  awsBroker = &AWSCredentialBroker{
+    cfg:           cfg,
+    profile:       profile,
+    refreshBefore: ParseConfigDuration(cfg.RefreshBefore, 15*time.Minute),
+  }
+}
+
+/**
+ * Returns an AWS session using the configured credentials, in the given
+ * region (or the one of the profile if empty)
+ */
+func GetAWSSession(region string) (*session.Session, error) {
+  var config aws.Config
+  if region != "" {
+    config.Region = aws.String(region)
+  }
+  if awsBroker == nil {
+    return session.NewSession(&config)
+  }
+
+  sess, err := awsBroker.getSession()
+  if err != nil {
+    return nil, err
+  }
+  return sess.Copy(&config), nil
+}
+
+/**
+ * Gives the configured AWS credentials to terraform, refreshing them (with
+ * the credential helper if there is one) before every run that would
+ * outlive them
+ */
+func AttachAWSCredentials(tf *TerraformWrapper) error {
+  if awsBroker == nil && getCredentialHelper() == nil {
+    return nil
+  }
+  if awsBroker != nil {
+    if err := awsBroker.exportTo(tf, false); err != nil {
+      return err
+    }
+  }
+  tf.SetCredentialRefresher(func(force bool) error {
+    if getCredentialHelper() != nil && (force || isHelperSessionExpiring()) {
+      if err := RunCredentialHelper(); err != nil {
+        return err
+      }
+      force = true
+    }
+    if awsBroker != nil {
+      return awsBroker.exportTo(tf, force)
+    }
+    return nil
+  })
+  return nil
+}
+
+func readMFAToken() (string, error) {
+  if !IsInteractive() {
+    return "", fmt.Errorf("An MFA code is required, but we are not running in a terminal")
+  }
+  return strings.TrimSpace(ReadPrompt("MFA code")), nil
+}
+
+func (b *AWSCredentialBroker) getSession() (*session.Session, error) {
+  if b.sess != nil {
+    return b.sess, nil
+  }
+
+  duration := ParseConfigDuration(b.cfg.SessionDuration, time.Hour)
+  sess, err := session.NewSessionWithOptions(session.Options{
+    Profile:                 b.profile,
+    SharedConfigState:       session.SharedConfigEnable,
+    AssumeRoleTokenProvider: readMFAToken,
+    AssumeRoleDuration:      duration,
+  })
+  if err != nil {
+    return nil, fmt.Errorf("Could not load the AWS profile '%s': %s", b.profile, err.Error())
+  }
+
+  if b.cfg.RoleARN != "" {
+    sessionName := "terraform-wheels"
+    if u, err := user.Current(); err == nil {
+      sessionName += "-" + u.Username
+    }
+    b.creds = stscreds.NewCredentials(sess, b.cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+      p.Duration = duration
+      p.RoleSessionName = sessionName
+      if b.cfg.MFASerial != "" {
+        p.SerialNumber = aws.String(b.cfg.MFASerial)
+        p.TokenProvider = readMFAToken
+      }
+      if b.cfg.ExternalID != "" {
+        p.ExternalID = aws.String(b.cfg.ExternalID)
+      }
+    })
+    sess = sess.Copy(&aws.Config{Credentials: b.creds})
+  } else {
+    b.creds = sess.Config.Credentials
+  }
+
+  b.sess = sess
+  return sess, nil
+}
+
+func (b *AWSCredentialBroker) cacheKey() string {
+  return fmt.Sprintf("aws-session:%s:%s", b.profile, b.cfg.RoleARN)
+}
+
+/**
+ * Returns credentials that are valid for at least the refresh window,
+ * from the cache or from AWS
+ */
+func (b *AWSCredentialBroker) retrieve(force bool) (*cachedAWSSession, error) {
+  store, err := GetUserCredentialStore()
+  if err != nil {
+    return nil, err
+  }
+  if !force {
+    if content, err := store.Get(b.cacheKey()); err == nil && content != "" {
+      var cached cachedAWSSession
+      if json.Unmarshal([]byte(content), &cached) == nil && time.Until(cached.Expiration) > b.refreshBefore {
+        return &cached, nil
+      }
+    }
+  }
+
+  if _, err := b.getSession(); err != nil {
+    return nil, err
+  }
+  if force {
+    b.creds.Expire()
+  }
+  value, err := b.creds.Get()
+  if err != nil {
+    return nil, fmt.Errorf("Could not get the AWS credentials of %s: %s", b.describe(), err.Error())
+  }
+  ret := &cachedAWSSession{value.AccessKeyID, value.SecretAccessKey, value.SessionToken, time.Time{}}
+
+  // Long-term credentials never expire, and they are already on disk
+  expiration, err := b.creds.ExpiresAt()
+  if err != nil {
+    return ret, nil
+  }
+  if !force && time.Until(expiration) <= b.refreshBefore {
+    return b.retrieve(true)
+  }
+  ret.Expiration = expiration
+
+  content, _ := json.Marshal(ret)
+  if err := store.Set(b.cacheKey(), string(content)); err != nil {
+    PrintWarning("Could not cache the AWS session: %s", err.Error())
+  }
+  return ret, nil
+}
+
+func (b *AWSCredentialBroker) exportTo(tf *TerraformWrapper, force bool) error {
+  if _, err := b.getSession(); err != nil {
+    return err
+  }
+  creds, err := b.retrieve(force)
+  if err != nil {
+    return err
+  }
+
+  tf.SetEnv("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
+  tf.SetEnv("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
+  tf.SetEnv("AWS_SESSION_TOKEN", creds.SessionToken)
+  if os.Getenv("AWS_REGION") == "" && os.Getenv("AWS_DEFAULT_REGION") == "" && b.sess.Config.Region != nil && *b.sess.Config.Region != "" {
+    tf.SetEnv("AWS_DEFAULT_REGION", *b.sess.Config.Region)
+  }
+
+  // Only tell when the credentials change
+  if creds.AccessKeyID == b.exported {
+    return nil
+  }
+  b.exported = creds.AccessKeyID
+  if creds.Expiration.IsZero() {
+    PrintInfo("Using the AWS credentials of profile %s", Bold(b.profile))
+  } else {
+    PrintInfo("Using temporary AWS credentials (%s), valid until %s", Bold(b.describe()), creds.Expiration.Local().Format("15:04"))
+  }
+  return nil
+}
+
+func (b *AWSCredentialBroker) describe() string {
+  if b.cfg.RoleARN != "" {
+    return "role " + b.cfg.RoleARN
+  }
+  return "profile " + b.profile
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+// The AWS configuration of the project, also when there is no broker
+var awsAuthConfig AWSAuthConfig
+
+// Where we remember when the credential helper last ran, by profile
+var awsHelperRunsFile string = "aws-helper-runs.json"
+
+// Only one hook checks (and refreshes) the credentials at a time, the others
+// wait for it and find them valid
+var awsCredentialsMutex sync.Mutex
+
+/**
+ * Returns the AWS profile in use, from --aws-profile, .wheels.yaml or the
+ * environment
+ */
+func getAWSProfile() string {
+  if awsProfileFlag != "" {
+    return awsProfileFlag
+  }
+  if awsAuthConfig.Profile != "" {
+    return awsAuthConfig.Profile
+  }
+  return os.Getenv("AWS_PROFILE")
+}
+
+/**
+ * @brief      Returns the command that fetches fresh short-lived credentials,
+ *             or nil if there is none
+ *
+ * It's the `credential_helper` of .wheels.yaml, where `{profile}` is replaced
+ * with the profile in use. Without one, `maws login <profile>` is used when
+ * maws is installed and a profile is selected.
+ */
+func getCredentialHelper() []string {
+  profile := getAWSProfile()
+  if awsAuthConfig.CredentialHelper != "" {
+    return strings.Fields(strings.Replace(awsAuthConfig.CredentialHelper, "{profile}", profile, -1))
+  }
+  if _, err := exec.LookPath("maws"); err == nil && profile != "" {
+    return []string{"maws", "login", profile}
+  }
+  return nil
+}
+
+func loadAWSHelperRuns() (map[string]time.Time, string) {
+  runs := make(map[string]time.Time)
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return runs, ""
+  }
+  path := filepath.Join(home, awsHelperRunsFile)
+  if content, err := ioutil.ReadFile(path); err == nil {
+    json.Unmarshal(content, &runs)
+  }
+  return runs, path
+}
+
+/**
+ * Checks if the credentials of the helper are about to expire, knowing when
+ * it last ran and how long its sessions last
+ */
+func isHelperSessionExpiring() bool {
+  runs, _ := loadAWSHelperRuns()
+  lastRun, ok := runs[getAWSProfile()]
+  if !ok {
+    return false
+  }
+  duration := ParseConfigDuration(awsAuthConfig.HelperSessionDuration, time.Hour)
+  refreshBefore := ParseConfigDuration(awsAuthConfig.RefreshBefore, 15*time.Minute)
+  return time.Until(lastRun.Add(duration)) < refreshBefore
+}
+
+/**
+ * Runs the credential helper, that can prompt the user
+ */
+func RunCredentialHelper() error {
+  helper := getCredentialHelper()
+  if helper == nil {
+    return fmt.Errorf("There is no credential helper, please configure aws.credential_helper in %s", WheelsConfigFile)
+  }
+
+  unlock := LockTerminal()
+  PrintInfo("Refreshing the AWS credentials with %s", Bold(strings.Join(helper, " ")))
+  code, err := ExecuteAndPassthrough(nil, helper[0], helper[1:]...)
+  unlock()
+  if err != nil {
+    return fmt.Errorf("Could not run %s: %s", helper[0], err.Error())
+  }
+  if code != 0 {
+    return fmt.Errorf("%s failed with exit code %d, please retry manually", strings.Join(helper, " "), code)
+  }
+
+  // The sessions have to load the new credentials
+  if awsBroker != nil {
+    awsBroker.sess = nil
+  }
+
+  runs, path := loadAWSHelperRuns()
+  if path != "" {
+    runs[getAWSProfile()] = time.Now()
+    content, _ := json.Marshal(runs)
+    if err := ioutil.WriteFile(path, content, 0644); err != nil {
+      PrintWarning("Could not remember the credential refresh: %s", err.Error())
+    }
+  }
+  return nil
+}
+
+/**
+ * @brief      Makes sure that there are AWS credentials that are valid for a
+ *             while, running the credential helper if needed
+ *
+ * It's safe to call from the hooks that run concurrently: the helper runs
+ * once, with the terminal to itself.
+ */
+func EnsureAWSCredentials() error {
+  awsCredentialsMutex.Lock()
+  defer awsCredentialsMutex.Unlock()
+
+  helper := getCredentialHelper()
+  if IsAWSCredsOK() {
+    if helper != nil && isHelperSessionExpiring() {
+      return RunCredentialHelper()
+    }
+    return nil
+  }
+
+  if helper == nil {
+    return fmt.Errorf("Could not find (still valid) AWS credentials in your enviroment. Use `maws login` and make sure to export the AWS_PROFILE, or configure aws.credential_helper in %s", WheelsConfigFile)
+  }
+  PrintInfo("Your AWS credentials have expired")
+  if err := RunCredentialHelper(); err != nil {
+    return err
+  }
+  if !IsAWSCredsOK() {
+    return fmt.Errorf("Failed to refresh credentials with `%s`, please retry manually", helper[0])
+  }
+  return nil
+}
+
+/**
+ * Tells how to continue after the credentials expired during a run
+ */
+func printExpiredCredentialsGuidance() {
+  refresh := "refresh your AWS credentials"
+  if helper := getCredentialHelper(); helper != nil {
+    refresh = fmt.Sprintf("refresh your AWS credentials (%s)", strings.Join(helper, " "))
+  }
+  PrintWarning("The AWS credentials expired during the run. To resume, %s and run %s again: terraform only changes what is still missing.", refresh, Bold("plan"))
+}
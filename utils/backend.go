@@ -0,0 +1,82 @@
+package utils
+
+import (
+  "fmt"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/session"
+  "github.com/aws/aws-sdk-go/service/dynamodb"
+  "github.com/aws/aws-sdk-go/service/s3"
+)
+
+/**
+ * @brief      Deletes the (destroyed) state of the current workspace from the
+ *             remote backend of the project
+ *
+ * Only the S3 backend is supported. The bucket and the lock table themselves
+ * are left in place, since they are usually shared with other projects.
+ */
+func (s *ProjectSandbox) DeleteBackendState() error {
+  backend, err := s.GetBackend()
+  if err != nil {
+    return err
+  }
+  if backend == nil {
+    return nil
+  }
+  if backend.Type != "s3" {
+    return fmt.Errorf("Deleting the state from a %s backend is not supported, please remove it manually", backend.Type)
+  }
+
+  config := make(map[string]string)
+  for k, v := range backend.Config {
+    if str, ok := v.(string); ok {
+      config[k] = str
+    }
+  }
+  if config["bucket"] == "" || config["key"] == "" {
+    return fmt.Errorf("The s3 backend has no bucket or key configured")
+  }
+
+  key := config["key"]
+  if ws := s.GetWorkspace(); ws != "default" {
+    prefix := config["workspace_key_prefix"]
+    if prefix == "" {
+      prefix = "env:"
+    }
+    key = fmt.Sprintf("%s/%s/%s", prefix, ws, key)
+  }
+
+  opts := session.Options{Profile: config["profile"], SharedConfigState: session.SharedConfigEnable}
+  if config["region"] != "" {
+    opts.Config.Region = aws.String(config["region"])
+  }
+  sess, err := session.NewSessionWithOptions(opts)
+  if err != nil {
+    return fmt.Errorf("Could not connect to AWS: %s", err.Error())
+  }
+
+  _, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{
+    Bucket: aws.String(config["bucket"]),
+    Key:    aws.String(key),
+  })
+  if err != nil {
+    return fmt.Errorf("Could not delete s3://%s/%s: %s", config["bucket"], key, err.Error())
+  }
+  PrintInfo("Deleted the state in s3://%s/%s", config["bucket"], key)
+
+  // The lock table also keeps a checksum of the state
+  if table := config["dynamodb_table"]; table != "" {
+    _, err = dynamodb.New(sess).DeleteItem(&dynamodb.DeleteItemInput{
+      TableName: aws.String(table),
+      Key: map[string]*dynamodb.AttributeValue{
+        "LockID": {S: aws.String(fmt.Sprintf("%s/%s-md5", config["bucket"], key))},
+      },
+    })
+    if err != nil {
+      return fmt.Errorf("Could not delete the state checksum from %s: %s", table, err.Error())
+    }
+  }
+
+  return nil
+}
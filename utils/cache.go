@@ -0,0 +1,139 @@
+package utils
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+/**
+ * Returns the directory where terraform-wheels keeps the user-wide data that
+ * is shared between all project sandboxes.
+ */
+func GetWheelsHomeDir() (string, error) {
+  if dir, ok := os.LookupEnv("TERRAFORM_WHEELS_HOME"); ok && dir != "" {
+    return dir, nil
+  }
+
+  home, err := os.UserHomeDir()
+  if err != nil {
+    return "", fmt.Errorf("could not find the user home directory: %s", err.Error())
+  }
+
+  return filepath.Join(home, ".terraform-wheels"), nil
+}
+
+/**
+ * Returns (and creates) the given directory in the global cache
+ */
+func GetCacheDir(name string) (string, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+
+  dir := filepath.Join(home, "cache", name)
+  if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+    return "", fmt.Errorf("could not create cache directory %s: %s", dir, err.Error())
+  }
+
+  return dir, nil
+}
+
+/**
+ * Acquires the exclusive lock that guards the given cache directory. Other
+ * processes that are trying to use the same cache will block until the
+ * returned lock is released (or the process holding it exits).
+ */
+func LockCacheDir(dir string) (*FileLock, error) {
+  return AcquireFileLock(filepath.Join(dir, ".lock"))
+}
+
+/**
+ * Locks the terraform provider cache, used while running `terraform init`
+ */
+func LockPluginCache() (*FileLock, error) {
+  dir, err := GetCacheDir("plugins")
+  if err != nil {
+    return nil, err
+  }
+  return LockCacheDir(dir)
+}
+
+/**
+ * Calculates the SHA256 checksum of the given file
+ */
+func FileChecksum(path string) (string, error) {
+  f, err := os.Open(path)
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+
+  hasher := sha256.New()
+  if _, err := io.Copy(hasher, f); err != nil {
+    return "", err
+  }
+
+  return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+/**
+ * Moves the file `src` to `dst` and records its checksum next to it, so the
+ * integrity of the cached file can be validated later. Both files are renamed
+ * into place, so concurrent readers never see a partially written file.
+ */
+func InstallCachedFile(src string, dst string) error {
+  csum, err := FileChecksum(src)
+  if err != nil {
+    return fmt.Errorf("could not checksum %s: %s", src, err.Error())
+  }
+
+  tmpSum := src + ".sha256"
+  if err := ioutil.WriteFile(tmpSum, []byte(csum+"\n"), 0644); err != nil {
+    return fmt.Errorf("could not write checksum: %s", err.Error())
+  }
+
+  // Remove the previous checksum first, so an interrupted install is always
+  // detected as a corrupted entry
+  os.Remove(dst + ".sha256")
+  if err := os.Rename(src, dst); err != nil {
+    return fmt.Errorf("could not install %s: %s", dst, err.Error())
+  }
+  if err := os.Rename(tmpSum, dst+".sha256"); err != nil {
+    return fmt.Errorf("could not install checksum of %s: %s", dst, err.Error())
+  }
+
+  return nil
+}
+
+/**
+ * Checks if the given cached file exists and its contents match the checksum
+ * that was recorded when it was installed
+ */
+func IsCachedFileValid(path string) bool {
+  expected, err := ioutil.ReadFile(path + ".sha256")
+  if err != nil {
+    return false
+  }
+
+  csum, err := FileChecksum(path)
+  if err != nil {
+    return false
+  }
+
+  return csum == strings.TrimSpace(string(expected))
+}
+
+/**
+ * Removes the given file and it's checksum from the cache
+ */
+func RemoveCachedFile(path string) {
+  os.Remove(path)
+  os.Remove(path + ".sha256")
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+
+  . "github.com/mattn/go-colorable"
+  "golang.org/x/crypto/ssh/terminal"
+)
+
+// always, never, or auto to only use colors on a terminal
+var colorMode string = "auto"
+
+// The terraform commands that accept -no-color
+var noColorCommands []string = []string{
+  "apply", "destroy", "import", "init", "plan", "refresh", "show", "validate",
+}
+
+func init() {
+  applyColorMode()
+}
+
+/**
+ * Chooses when the output has colors, with `--color=always|never|auto`
+ */
+func SetColorMode(mode string) error {
+  if mode != "always" && mode != "never" && mode != "auto" {
+    return fmt.Errorf("Invalid --color '%s', use always, never or auto", mode)
+  }
+  colorMode = mode
+  applyColorMode()
+  return nil
+}
+
+/**
+ * Checks if what is printed on the given stream has colors: with `auto`, only
+ * on a terminal and when NO_COLOR is not set (see https://no-color.org)
+ */
+func useColors(file *os.File) bool {
+  switch colorMode {
+  case "always":
+    return true
+  case "never":
+    return false
+  }
+  if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+    return false
+  }
+  return terminal.IsTerminal(int(file.Fd()))
+}
+
+/**
+ * The escape codes of the colors are removed from the streams that must not
+ * have colors
+ */
+func applyColorMode() {
+  if useColors(os.Stdout) {
+    colorableStdout = NewColorableStdout()
+  } else {
+    colorableStdout = NewNonColorable(os.Stdout)
+  }
+  if useColors(os.Stderr) {
+    colorableStderr = NewColorableStderr()
+  } else {
+    colorableStderr = NewNonColorable(os.Stderr)
+  }
+}
+
+/**
+ * Adds -no-color to the terraform commands that support it, when the output
+ * must not have colors
+ */
+func addNoColorArg(args []string) []string {
+  if useColors(os.Stdout) || containsString(args, "-no-color") {
+    return args
+  }
+
+  parsed := ParseTerraformArgs(args)
+  if !containsString(noColorCommands, parsed.Command) {
+    return args
+  }
+  ret := append([]string{}, args[:parsed.CommandIndex+1]...)
+  ret = append(ret, "-no-color")
+  return append(ret, args[parsed.CommandIndex+1:]...)
+}
+
+/**
+ * Prints on stdout like fmt.Printf, without the colors if they are disabled
+ */
+func Printf(format string, a ...interface{}) {
+  colorableStdout.Write([]byte(fmt.Sprintf(format, a...)))
+}
+
+/**
+ * Prints on stdout like fmt.Println, without the colors if they are disabled
+ */
+func Println(a ...interface{}) {
+  colorableStdout.Write([]byte(fmt.Sprintln(a...)))
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "sort"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+  "github.com/gobwas/glob"
+)
+
+/**
+ * What works with what, bundled in compat.json
+ */
+type CompatMatrix struct {
+  // The operating systems supported by each DC/OS minor version
+  InstanceOS map[string][]string `json:"dcos_instance_os"`
+  // How to find the AMIs of the operating systems (by glob), in any region
+  Images map[string]CompatImage `json:"images"`
+  // The combinations that are known not to work
+  KnownBad []CompatKnownBad `json:"known_bad"`
+  // The DC/OS minor versions that reached their end of life
+  EndOfLife []string `json:"end_of_life"`
+}
+
+/**
+ * The owners and the name (where {release} is the release of the OS) of the
+ * AMIs of an operating system
+ */
+type CompatImage struct {
+  Owners []string `json:"owners"`
+  Name   string   `json:"name"`
+}
+
+/**
+ * A combination that does not work: the DC/OS versions (a constraint like
+ * `< 1.13`, or `*`), the variant and the operating systems (a glob)
+ */
+type CompatKnownBad struct {
+  DCOSVersion string `json:"dcos_version"`
+  Variant     string `json:"dcos_variant,omitempty"`
+  OS          string `json:"os,omitempty"`
+  Reason      string `json:"reason"`
+}
+
+/**
+ * Loads the bundled (or overridden) compatibility matrix
+ */
+func LoadCompatMatrix() (*CompatMatrix, error) {
+  content, err := ReadAsset("compat.json")
+  if err != nil {
+    return nil, err
+  }
+
+  matrix := &CompatMatrix{}
+  if err := json.Unmarshal(content, matrix); err != nil {
+    return nil, fmt.Errorf("Could not parse the compatibility matrix: %s", err.Error())
+  }
+  return matrix, nil
+}
+
+/**
+ * Loads the compatibility matrix from the given file or URL, on top of the
+ * bundled one. A matrix that was downloaded is cached, and used when it
+ * cannot be downloaded again.
+ */
+func LoadCompatMatrixFrom(source string) (*CompatMatrix, error) {
+  defaultMatrix, err := LoadCompatMatrix()
+  if err != nil || source == "" {
+    return defaultMatrix, err
+  }
+
+  var content []byte
+  if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+    cacheDir, err := GetCacheDir("compat")
+    if err != nil {
+      return nil, err
+    }
+    sum := sha256.Sum256([]byte(source))
+    cacheFile := filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+".json")
+
+    content, err = Download(source, WithDefaults).EventuallyReadAll()
+    if err != nil {
+      PrintWarning("Could not download the compatibility matrix: %s", err.Error())
+      content, err = ioutil.ReadFile(cacheFile)
+      if err != nil {
+        PrintWarning("There is no cached compatibility matrix either, using the bundled one")
+        return defaultMatrix, nil
+      }
+    } else if err := ioutil.WriteFile(cacheFile, content, 0644); err != nil {
+      PrintWarning("Could not cache the compatibility matrix: %s", err.Error())
+    }
+  } else {
+    content, err = ioutil.ReadFile(source)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read the compatibility matrix %s: %s", source, err.Error())
+    }
+  }
+
+  matrix := &CompatMatrix{}
+  if err := json.Unmarshal(content, matrix); err != nil {
+    return nil, fmt.Errorf("Could not parse the compatibility matrix %s: %s", source, err.Error())
+  }
+
+  // Fill-in the versions and the images that it does not know about, but
+  // its known-bad combinations and end of life versions replace the bundled
+  // ones
+  if matrix.InstanceOS == nil {
+    matrix.InstanceOS = make(map[string][]string)
+  }
+  for version, oses := range defaultMatrix.InstanceOS {
+    if _, ok := matrix.InstanceOS[version]; !ok {
+      matrix.InstanceOS[version] = oses
+    }
+  }
+  if matrix.Images == nil {
+    matrix.Images = make(map[string]CompatImage)
+  }
+  for pattern, image := range defaultMatrix.Images {
+    if _, ok := matrix.Images[pattern]; !ok {
+      matrix.Images[pattern] = image
+    }
+  }
+  if matrix.KnownBad == nil {
+    matrix.KnownBad = defaultMatrix.KnownBad
+  }
+  if matrix.EndOfLife == nil {
+    matrix.EndOfLife = defaultMatrix.EndOfLife
+  }
+  return matrix, nil
+}
+
+/**
+ * Returns the compatibility matrix of the project: the bundled one, updated
+ * with `preflight.compat_matrix` of .wheels.yaml (a file or a URL)
+ */
+func (s *ProjectSandbox) GetCompatMatrix() (*CompatMatrix, error) {
+  source := s.GetConfig().Preflight.CompatMatrix
+  if source != "" && !strings.Contains(source, "://") && !filepath.IsAbs(source) {
+    source = s.GetFilePath(source)
+  }
+  return LoadCompatMatrixFrom(source)
+}
+
+/**
+ * Checks if the minor version of the given DC/OS release reached its end of
+ * life
+ */
+func (m *CompatMatrix) IsEndOfLife(dcosVersion string) bool {
+  ver, err := semver.NewVersion(dcosVersion)
+  if err != nil {
+    return false
+  }
+  minor := fmt.Sprintf("%d.%d", ver.Major(), ver.Minor())
+  for _, eol := range m.EndOfLife {
+    if eol == minor {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Returns why the given DC/OS version, variant and operating system are
+ * known not to work together, if they are
+ */
+func (m *CompatMatrix) FindKnownIssues(dcosVersion string, variant string, instanceOS string) []string {
+  var reasons []string
+  ver, verErr := semver.NewVersion(dcosVersion)
+  for _, bad := range m.KnownBad {
+    if bad.DCOSVersion != "" && bad.DCOSVersion != "*" {
+      constraint, err := semver.NewConstraint(bad.DCOSVersion)
+      if err != nil || verErr != nil || !constraint.Check(ver) {
+        continue
+      }
+    }
+    if bad.Variant != "" && bad.Variant != variant {
+      continue
+    }
+    if bad.OS != "" {
+      g, err := glob.Compile(bad.OS)
+      if err != nil || !g.Match(instanceOS) {
+        continue
+      }
+    }
+    reasons = append(reasons, bad.Reason)
+  }
+  return reasons
+}
+
+/**
+ * Returns how to find the AMIs of the given operating system (eg.
+ * `rhel_7.6`), with the {release} of their name replaced, or nil if it's not
+ * known
+ */
+func (m *CompatMatrix) GetImage(instanceOS string) *CompatImage {
+  var patterns []string
+  for pattern := range m.Images {
+    patterns = append(patterns, pattern)
+  }
+  sort.Strings(patterns)
+
+  release := ""
+  if parts := strings.SplitN(instanceOS, "_", 2); len(parts) == 2 {
+    release = parts[1]
+  }
+  for _, pattern := range patterns {
+    if g, err := glob.Compile(pattern); err == nil && g.Match(instanceOS) {
+      image := m.Images[pattern]
+      image.Name = strings.Replace(image.Name, "{release}", release, -1)
+      return &image
+    }
+  }
+  return nil
+}
+
+/**
+ * Returns the operating systems supported by the given DC/OS version, or nil
+ * if the version is unknown
+ */
+func (m *CompatMatrix) GetSupportedOS(dcosVersion string) []string {
+  ver, err := semver.NewVersion(dcosVersion)
+  if err != nil {
+    return nil
+  }
+  return m.InstanceOS[fmt.Sprintf("%d.%d", ver.Major(), ver.Minor())]
+}
+
+/**
+ * @brief      Resolves an OS given by the user (eg. `centos` or `rhel_7.6`) to
+ *             a `dcos_instance_os` value
+ *
+ * A bare OS family picks the newest release supported by the DC/OS version.
+ * Returns false if the OS is not known to be supported by it.
+ */
+func (m *CompatMatrix) ResolveInstanceOS(name string, dcosVersion string) (string, bool) {
+  supported := m.GetSupportedOS(dcosVersion)
+
+  if strings.Contains(name, "_") {
+    for _, os := range supported {
+      if os == name {
+        return name, true
+      }
+    }
+    return name, false
+  }
+
+  var candidates []string
+  for _, os := range supported {
+    if strings.HasPrefix(os, name+"_") {
+      candidates = append(candidates, os)
+    }
+  }
+  if len(candidates) == 0 {
+    return name, false
+  }
+
+  // The newest release of that family
+  sort.Slice(candidates, func(i, j int) bool {
+    a, errA := semver.NewVersion(strings.SplitN(candidates[i], "_", 2)[1])
+    b, errB := semver.NewVersion(strings.SplitN(candidates[j], "_", 2)[1])
+    if errA != nil || errB != nil {
+      return candidates[i] < candidates[j]
+    }
+    return a.LessThan(b)
+  })
+  return candidates[len(candidates)-1], true
+}
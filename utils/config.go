@@ -0,0 +1,146 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "time"
+
+  "gopkg.in/yaml.v3"
+)
+
+var WheelsConfigFile string = ".wheels.yaml"
+
+type ReadinessGateConfig struct {
+  Enabled  bool   `yaml:"enabled"`
+  Timeout  string `yaml:"timeout"`
+  Interval string `yaml:"interval"`
+}
+
+type DcosAuthConfig struct {
+  URL                   string `yaml:"url"`
+  TokenFile             string `yaml:"token_file"`
+  ServiceAccount        string `yaml:"service_account"`
+  ServiceAccountKeyFile string `yaml:"service_account_key_file"`
+}
+
+type NetworkConfig struct {
+  CABundle string `yaml:"ca_bundle"`
+  Insecure bool   `yaml:"insecure"`
+}
+
+type RetryConfig struct {
+  MaxRetries   *int     `yaml:"max_retries"`
+  InitialDelay string   `yaml:"initial_delay"`
+  MaxDelay     string   `yaml:"max_delay"`
+  Patterns     []string `yaml:"patterns"`
+}
+
+type FailFastConfig struct {
+  Enabled  bool     `yaml:"enabled"`
+  Patterns []string `yaml:"patterns"`
+}
+
+type OfflineConfig struct {
+  Enabled   bool   `yaml:"enabled"`
+  MirrorDir string `yaml:"mirror_dir"`
+}
+
+type PlansConfig struct {
+  RequiredApprovals int    `yaml:"required_approvals"`
+  HighImpactOnly    bool   `yaml:"high_impact_only"`
+  AllowSelfApproval bool   `yaml:"allow_self_approval"`
+  MaxAge            string `yaml:"max_age"`
+}
+
+type StateConfig struct {
+  KeepSnapshots *int `yaml:"keep_snapshots"`
+}
+
+type EnvironmentsConfig struct {
+  Protected []string `yaml:"protected"`
+}
+
+type HooksConfig struct {
+  AfterApply    []string `yaml:"after_apply"`
+  BeforeDestroy []string `yaml:"before_destroy"`
+}
+
+type PreflightConfig struct {
+  Quotas        *bool  `yaml:"quotas"`
+  Compatibility *bool  `yaml:"compatibility"`
+  CompatMatrix  string `yaml:"compat_matrix"`
+  DcosVersions  string `yaml:"dcos_versions"`
+}
+
+type CostConfig struct {
+  PriceTable  string `yaml:"price_table"`
+  BeforeApply bool   `yaml:"before_apply"`
+}
+
+/**
+ * The per-project terraform-wheels configuration, found in .wheels.yaml
+ */
+type WheelsConfig struct {
+  ReadinessGate ReadinessGateConfig `yaml:"readiness_gate"`
+  Dcos          DcosAuthConfig      `yaml:"dcos"`
+  SecretStore   string              `yaml:"secret_store"`
+  Offline       OfflineConfig       `yaml:"offline"`
+  Network       NetworkConfig       `yaml:"network"`
+  Retry         RetryConfig         `yaml:"retry"`
+  FailFast      FailFastConfig      `yaml:"fail_fast"`
+  Cost          CostConfig          `yaml:"cost"`
+  AWS           AWSAuthConfig       `yaml:"aws"`
+  Environments  EnvironmentsConfig  `yaml:"environments"`
+  State         StateConfig         `yaml:"state"`
+  Plans         PlansConfig         `yaml:"plans"`
+  TFE           TFEConfig           `yaml:"tfe"`
+  Tags          map[string]string   `yaml:"tags"`
+  Hooks         HooksConfig         `yaml:"hooks"`
+  Preflight     PreflightConfig     `yaml:"preflight"`
+
+  RequiredWheelsVersion string `yaml:"required_wheels_version"`
+  UpdateCheck           *bool  `yaml:"update_check"`
+}
+
+/**
+ * Loads the wheels configuration from the given directory. If the file does
+ * not exist, the default configuration is returned.
+ */
+func LoadWheelsConfig(baseDir string) (*WheelsConfig, error) {
+  cfg := &WheelsConfig{}
+
+  content, err := ioutil.ReadFile(filepath.Join(baseDir, WheelsConfigFile))
+  if err != nil {
+    if os.IsNotExist(err) {
+      return cfg, nil
+    }
+    return nil, fmt.Errorf("Could not read %s: %s", WheelsConfigFile, err.Error())
+  }
+
+  err = yaml.Unmarshal(content, cfg)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", WheelsConfigFile, err.Error())
+  }
+
+  return cfg, nil
+}
+
+/**
+ * Parses the given duration string, falling back to the default value if
+ * it's missing or invalid
+ */
+func ParseConfigDuration(value string, defaultValue time.Duration) time.Duration {
+  if value == "" {
+    return defaultValue
+  }
+
+  d, err := time.ParseDuration(value)
+  if err != nil {
+    PrintWarning("Invalid duration '%s' in %s, using %s instead", value, WheelsConfigFile, defaultValue)
+    return defaultValue
+  }
+
+  return d
+}
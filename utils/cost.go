@@ -0,0 +1,359 @@
+package utils
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/gobwas/glob"
+)
+
+// Used to turn hourly prices into monthly ones
+var hoursPerMonth float64 = 730
+
+/**
+ * On-demand hourly prices in USD, by cloud and instance type
+ */
+type PriceTable map[string]map[string]float64
+
+
+/**
+ * Returns the price table that is bundled with the binary (or overridden
+ * in the assets directory)
+ */
+func getDefaultPriceTable() (PriceTable, error) {
+  content, err := ReadAsset("prices.json")
+  if err != nil {
+    return nil, err
+  }
+
+  table := make(PriceTable)
+  if err := json.Unmarshal(content, &table); err != nil {
+    return nil, fmt.Errorf("Could not parse the bundled price table: %s", err.Error())
+  }
+  return table, nil
+}
+
+/**
+ * A group of identical machines in the project
+ */
+type CostItem struct {
+  Address      string
+  Cloud        string
+  InstanceType string
+  Count        int
+  HourlyPrice  float64
+  Priced       bool
+}
+
+/**
+ * A group of machines created by one of the DC/OS modules, with the defaults
+ * of the module
+ */
+type costModuleGroup struct {
+  name         string
+  countField   string
+  defaultCount int
+  typeField    string
+  defaultType  string
+}
+
+type costModuleSpec struct {
+  sourceGlob string
+  cloud      string
+  groups     []costModuleGroup
+}
+
+var costModuleSpecs []costModuleSpec = []costModuleSpec{
+  {"*dcos-terraform/dcos/aws", "aws", []costModuleGroup{
+    {"bootstrap", "", 1, "bootstrap_instance_type", "t2.medium"},
+    {"masters", "num_masters", 3, "masters_instance_type", "m4.xlarge"},
+    {"private_agents", "num_private_agents", 2, "private_agents_instance_type", "m4.xlarge"},
+    {"public_agents", "num_public_agents", 1, "public_agents_instance_type", "m4.xlarge"},
+  }},
+  {"*dcos-terraform/infrastructure/aws", "aws", []costModuleGroup{
+    {"bootstrap", "", 1, "bootstrap_instance_type", "t2.medium"},
+    {"masters", "num_masters", 3, "masters_instance_type", "m4.xlarge"},
+    {"private_agents", "num_private_agents", 2, "private_agents_instance_type", "m4.xlarge"},
+    {"public_agents", "num_public_agents", 1, "public_agents_instance_type", "m4.xlarge"},
+  }},
+  {"*dcos-terraform/dcos/gcp", "gcp", []costModuleGroup{
+    {"bootstrap", "", 1, "bootstrap_machine_type", "n1-standard-2"},
+    {"masters", "num_masters", 3, "masters_machine_type", "n1-standard-8"},
+    {"private_agents", "num_private_agents", 2, "private_agents_machine_type", "n1-standard-8"},
+    {"public_agents", "num_public_agents", 1, "public_agents_machine_type", "n1-standard-8"},
+  }},
+  {"*dcos-terraform/dcos/azurerm", "azure", []costModuleGroup{
+    {"bootstrap", "", 1, "bootstrap_vm_size", "Standard_B2s"},
+    {"masters", "num_masters", 3, "masters_vm_size", "Standard_D4s_v3"},
+    {"private_agents", "num_private_agents", 2, "private_agents_vm_size", "Standard_D4s_v3"},
+    {"public_agents", "num_public_agents", 1, "public_agents_vm_size", "Standard_D4s_v3"},
+  }},
+  {"*dcos-terraform/bootstrap/aws", "aws", []costModuleGroup{
+    {"bootstrap", "", 1, "aws_instance_type", "t2.medium"},
+  }},
+  {"*dcos-terraform/masters/aws", "aws", []costModuleGroup{
+    {"masters", "num_masters", 3, "aws_instance_type", "m4.xlarge"},
+  }},
+  {"*dcos-terraform/private-agents/aws", "aws", []costModuleGroup{
+    {"private_agents", "num_private_agents", 1, "aws_instance_type", "m4.xlarge"},
+  }},
+  {"*dcos-terraform/public-agents/aws", "aws", []costModuleGroup{
+    {"public_agents", "num_public_agents", 1, "aws_instance_type", "m4.xlarge"},
+  }},
+}
+
+// Plain resources that are billed per instance, and the field with their type
+var costResourceSpecs map[string][2]string = map[string][2]string{
+  "aws_instance":            {"aws", "instance_type"},
+  "google_compute_instance": {"gcp", "machine_type"},
+  "azurerm_virtual_machine": {"azure", "vm_size"},
+  // Priced on-demand, as the worst case
+  "aws_spot_instance_request": {"aws", "instance_type"},
+}
+
+/**
+ * Loads the price table from the given file or URL. Downloaded tables are
+ * cached, so they remain available in offline mode. Without a source, the
+ * bundled table is used.
+ */
+func LoadPriceTable(source string) (PriceTable, error) {
+  defaultPriceTable, err := getDefaultPriceTable()
+  if err != nil || source == "" {
+    return defaultPriceTable, err
+  }
+
+  var content []byte
+  if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+    cacheDir, err := GetCacheDir("prices")
+    if err != nil {
+      return nil, err
+    }
+    sum := sha256.Sum256([]byte(source))
+    cacheFile := filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+".json")
+
+    content, err = Download(source, WithDefaults).EventuallyReadAll()
+    if err != nil {
+      PrintWarning("Could not download the price table: %s", err.Error())
+      content, err = ioutil.ReadFile(cacheFile)
+      if err != nil {
+        PrintWarning("There is no cached price table either, using the bundled one")
+        return defaultPriceTable, nil
+      }
+    } else if err := ioutil.WriteFile(cacheFile, content, 0644); err != nil {
+      PrintWarning("Could not cache the price table: %s", err.Error())
+    }
+  } else {
+    content, err = ioutil.ReadFile(source)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read price table %s: %s", source, err.Error())
+    }
+  }
+
+  table := make(PriceTable)
+  if err := json.Unmarshal(content, &table); err != nil {
+    return nil, fmt.Errorf("Could not parse price table %s: %s", source, err.Error())
+  }
+
+  // Fill-in whatever the custom table does not know about
+  for cloud, prices := range defaultPriceTable {
+    if _, ok := table[cloud]; !ok {
+      table[cloud] = make(map[string]float64)
+    }
+    for instanceType, price := range prices {
+      if _, ok := table[cloud][instanceType]; !ok {
+        table[cloud][instanceType] = price
+      }
+    }
+  }
+
+  return table, nil
+}
+
+/**
+ * Returns the price table configured for the project
+ */
+func (s *ProjectSandbox) GetPriceTable() (PriceTable, error) {
+  source := s.GetConfig().Cost.PriceTable
+  if source != "" && !strings.Contains(source, "://") && !filepath.IsAbs(source) {
+    source = s.GetFilePath(source)
+  }
+  return LoadPriceTable(source)
+}
+
+/**
+ * Reads an instance count from a module or resource, returning false if it
+ * is computed at apply time
+ */
+func getCostCount(value interface{}, defaultCount int) (int, bool) {
+  switch v := value.(type) {
+  case nil:
+    return defaultCount, true
+  case int:
+    return v, true
+  case int64:
+    return int(v), true
+  case float64:
+    return int(v), true
+  case string:
+    n, err := strconv.Atoi(v)
+    if err != nil {
+      return defaultCount, false
+    }
+    return n, true
+  }
+  return defaultCount, false
+}
+
+func (t PriceTable) newCostItem(address string, cloud string, instanceType string, count int) CostItem {
+  price, ok := t[cloud][instanceType]
+  return CostItem{address, cloud, instanceType, count, price, ok}
+}
+
+/**
+ * Returns the `resource` blocks of the given type, by name
+ */
+func (s *ProjectSandbox) getResourcesOfType(resType string) map[string]map[string]interface{} {
+  ret := make(map[string]map[string]interface{})
+  for name, value := range s.GetTerraformResources("resource")[resType] {
+    blocks, ok := value.([]map[string]interface{})
+    if !ok {
+      continue
+    }
+    res := make(map[string]interface{})
+    for _, block := range blocks {
+      for k, v := range block {
+        res[k] = v
+      }
+    }
+    ret[name] = res
+  }
+  return ret
+}
+
+/**
+ * @brief      Estimates the cost of the machines described in the project
+ *
+ * Terraform 0.11 cannot export a plan as JSON, so the estimate is based on
+ * the configuration, using the defaults of the DC/OS modules for everything
+ * that is not specified.
+ */
+func (s *ProjectSandbox) EstimateCost(table PriceTable) ([]CostItem, error) {
+  var items []CostItem
+
+  for _, spec := range costModuleSpecs {
+    g := glob.MustCompile(spec.sourceGlob)
+    for name, mod := range s.GetTerraformResources("module") {
+      if source, ok := mod["source"].(string); !ok || !g.Match(source) {
+        continue
+      }
+
+      for _, group := range spec.groups {
+        count := group.defaultCount
+        if group.countField != "" {
+          var ok bool
+          count, ok = getCostCount(s.ResolveTerraformValue(mod[group.countField]), group.defaultCount)
+          if !ok {
+            PrintWarning("Cannot resolve module.%s.%s, assuming %d", name, group.countField, count)
+          }
+        }
+        if count == 0 {
+          continue
+        }
+
+        instanceType := group.defaultType
+        if v, ok := s.ResolveTerraformValue(mod[group.typeField]).(string); ok && v != "" {
+          instanceType = v
+        }
+
+        items = append(items, table.newCostItem(
+          fmt.Sprintf("module.%s (%s)", name, group.name), spec.cloud, instanceType, count))
+      }
+    }
+  }
+
+  for resType, spec := range costResourceSpecs {
+    for name, res := range s.getResourcesOfType(resType) {
+      count, ok := getCostCount(s.ResolveTerraformValue(res["count"]), 1)
+      if !ok {
+        PrintWarning("Cannot resolve %s.%s.count, assuming %d", resType, name, count)
+      }
+      if count == 0 {
+        continue
+      }
+
+      instanceType, _ := s.ResolveTerraformValue(res[spec[1]]).(string)
+      items = append(items, table.newCostItem(
+        fmt.Sprintf("%s.%s", resType, name), spec[0], instanceType, count))
+    }
+  }
+
+  sort.Slice(items, func(i, j int) bool {
+    return items[i].Address < items[j].Address
+  })
+  return items, nil
+}
+
+/**
+ * Returns the monthly cost of the given items (without the unpriced ones)
+ */
+func GetMonthlyCost(items []CostItem) float64 {
+  total := 0.0
+  for _, item := range items {
+    if item.Priced {
+      total += item.HourlyPrice * float64(item.Count) * hoursPerMonth
+    }
+  }
+  return total
+}
+
+/**
+ * Prints the estimated cost of the given items, returning the monthly total
+ */
+func PrintCostEstimate(items []CostItem) float64 {
+  total := 0.0
+  unpriced := false
+
+  fmt.Printf("%-48s %-18s %5s %12s\n", "COMPONENT", "TYPE", "COUNT", "MONTHLY")
+  for _, item := range items {
+    monthly := "?"
+    if item.Priced {
+      cost := item.HourlyPrice * float64(item.Count) * hoursPerMonth
+      total += cost
+      monthly = fmt.Sprintf("$%.2f", cost)
+    } else {
+      unpriced = true
+    }
+    fmt.Printf("%-48s %-18s %5d %12s\n", item.Address, item.InstanceType, item.Count, monthly)
+  }
+  fmt.Printf("%-48s %-18s %5s %12s\n", "TOTAL", "", "", fmt.Sprintf("$%.2f", total))
+
+  if unpriced {
+    PrintWarning("Some instance types are missing from the price table, the total is incomplete")
+  }
+  return total
+}
+
+/**
+ * Prints the estimated cost of the project with its configured price table
+ */
+func (s *ProjectSandbox) PrintProjectCost() error {
+  table, err := s.GetPriceTable()
+  if err != nil {
+    return err
+  }
+  items, err := s.EstimateCost(table)
+  if err != nil || len(items) == 0 {
+    return err
+  }
+
+  PrintInfo("Estimated monthly cost of the cluster:")
+  PrintCostEstimate(items)
+  return nil
+}
@@ -0,0 +1,285 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// The cached tokens are refreshed when they expire within this delay, so
+// they are still valid at the end of the terraform run
+var dcosTokenRefreshMargin time.Duration = 30 * time.Minute
+
+/**
+ * The credentials used by the DC/OS provider, and where they were found
+ */
+type DcosCredentials struct {
+  Source   string
+  Token    string
+  Username string
+  Password string
+}
+
+func dcosTokenKey(clusterUrl string) string {
+  return "dcos-token:" + GetClusterURL(clusterUrl)
+}
+
+// How the cached token was obtained, to log-in again when it expires: eg.
+// `password:<uid>`, `oidc` or `service-account:<uid>:<key file>`
+func dcosLoginKey(clusterUrl string) string {
+  return "dcos-login:" + GetClusterURL(clusterUrl)
+}
+
+/**
+ * Resolves the DC/OS credentials to use for the given cluster, trying in order:
+ *
+ *  1. The DCOS_ACS_TOKEN or DCOS_USER/DCOS_PASSWORD environment variables
+ *  2. The token file configured in .wheels.yaml (or DCOS_TOKEN_FILE)
+ *  3. The service account configured in .wheels.yaml
+ *  4. A token cached from a previous `wheels-login`, that is refreshed when
+ *     it's about to expire
+ *  5. An interactive login, if we are running in a terminal
+ *
+ * If nothing was found, `nil` is returned.
+ */
+func (s *ProjectSandbox) ResolveDcosCredentials(clusterUrl string) (*DcosCredentials, error) {
+  return s.resolveDcosCredentials(clusterUrl, true)
+}
+
+/**
+ * Like ResolveDcosCredentials, but never prompts the user
+ */
+func (s *ProjectSandbox) LookupDcosCredentials(clusterUrl string) (*DcosCredentials, error) {
+  return s.resolveDcosCredentials(clusterUrl, false)
+}
+
+func (s *ProjectSandbox) resolveDcosCredentials(clusterUrl string, interactive bool) (*DcosCredentials, error) {
+  cfg := s.GetConfig().Dcos
+
+  // Environment
+  if token, ok := os.LookupEnv("DCOS_ACS_TOKEN"); ok && token != "" {
+    return &DcosCredentials{Source: "environment", Token: token}, nil
+  }
+  if user, ok := os.LookupEnv("DCOS_USER"); ok && user != "" {
+    return &DcosCredentials{Source: "environment", Username: user, Password: os.Getenv("DCOS_PASSWORD")}, nil
+  }
+
+  // Token file
+  tokenFile := cfg.TokenFile
+  if v, ok := os.LookupEnv("DCOS_TOKEN_FILE"); ok && v != "" {
+    tokenFile = v
+  }
+  if tokenFile != "" {
+    content, err := ioutil.ReadFile(tokenFile)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read token file %s: %s", tokenFile, err.Error())
+    }
+    return &DcosCredentials{Source: tokenFile, Token: strings.TrimSpace(string(content))}, nil
+  }
+
+  if clusterUrl == "" {
+    return nil, nil
+  }
+
+  // Service account
+  if cfg.ServiceAccount != "" {
+    key, err := ioutil.ReadFile(cfg.ServiceAccountKeyFile)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read service account key: %s", err.Error())
+    }
+    token, err := DcosLoginWithServiceAccount(clusterUrl, cfg.ServiceAccount, key)
+    if err != nil {
+      return nil, fmt.Errorf("Could not login as %s: %s", cfg.ServiceAccount, err.Error())
+    }
+    return &DcosCredentials{Source: "service account " + cfg.ServiceAccount, Token: token}, nil
+  }
+
+  // Cached login
+  store, err := s.GetCredentialStore()
+  if err != nil {
+    return nil, err
+  }
+  token, err := store.Get(dcosTokenKey(clusterUrl))
+  if err != nil {
+    return nil, err
+  }
+  if token != "" {
+    expiry, ok := DcosTokenExpiry(token)
+    if !ok || time.Now().Add(dcosTokenRefreshMargin).Before(expiry) {
+      return &DcosCredentials{Source: "cached login", Token: token}, nil
+    }
+    creds, err := s.refreshDcosLogin(clusterUrl, interactive)
+    if err != nil || creds != nil {
+      return creds, err
+    }
+  }
+
+  // Interactive login
+  if interactive && IsInteractive() {
+    PrintInfo("Please log-in to the DC/OS cluster at %s", GetClusterURL(clusterUrl))
+    return s.DcosInteractiveLogin(clusterUrl, "")
+  }
+
+  return nil, nil
+}
+
+/**
+ * Logs-in again to the cluster when its cached token is about to expire, the
+ * same way it was obtained. Without a terminal, only the service accounts
+ * can log-in again, and `nil` is returned for the others.
+ */
+func (s *ProjectSandbox) refreshDcosLogin(clusterUrl string, interactive bool) (*DcosCredentials, error) {
+  store, err := s.GetCredentialStore()
+  if err != nil {
+    return nil, err
+  }
+  method, err := store.Get(dcosLoginKey(clusterUrl))
+  if err != nil {
+    return nil, err
+  }
+
+  if parts := strings.SplitN(method, ":", 3); len(parts) == 3 && parts[0] == "service-account" {
+    key, err := ioutil.ReadFile(parts[2])
+    if err != nil {
+      return nil, fmt.Errorf("Could not read service account key: %s", err.Error())
+    }
+    token, err := DcosLoginWithServiceAccount(clusterUrl, parts[1], key)
+    if err != nil {
+      return nil, fmt.Errorf("Could not login as %s: %s", parts[1], err.Error())
+    }
+    return &DcosCredentials{Source: "refreshed login of " + parts[1], Token: token}, s.CacheDcosLogin(clusterUrl, token, method)
+  }
+
+  if !interactive || !IsInteractive() {
+    PrintWarning("The cached DC/OS token of %s has expired, please run `%s wheels-login`", GetClusterURL(clusterUrl), os.Args[0])
+    return nil, nil
+  }
+  PrintInfo("The DC/OS token of %s has expired, please log-in again", GetClusterURL(clusterUrl))
+  if method == "oidc" {
+    return s.DcosBrowserLogin(clusterUrl)
+  }
+  return s.DcosInteractiveLogin(clusterUrl, strings.TrimPrefix(method, "password:"))
+}
+
+/**
+ * Prompts the user for username and password, logs-in to the cluster and
+ * caches the resulting token for later use. The clusters that only support
+ * the login through the browser (eg. DC/OS Open) use DcosBrowserLogin.
+ */
+func (s *ProjectSandbox) DcosInteractiveLogin(clusterUrl string, username string) (*DcosCredentials, error) {
+  if username == "" && DcosBrowserLoginURL(clusterUrl) != "" {
+    return s.DcosBrowserLogin(clusterUrl)
+  }
+
+  defer LockTerminal()()
+  if username == "" {
+    username = ReadPrompt("Username")
+  }
+  password, err := ReadPassword("Password")
+  if err != nil {
+    return nil, fmt.Errorf("Could not read password: %s", err.Error())
+  }
+
+  token, err := DcosLoginWithPassword(clusterUrl, username, password)
+  if err != nil {
+    return nil, err
+  }
+
+  return &DcosCredentials{Source: "login", Token: token}, s.CacheDcosLogin(clusterUrl, token, "password:"+username)
+}
+
+/**
+ * Opens the login page of the cluster in the browser, and logs-in with the
+ * OpenID Connect token the user pastes from it
+ */
+func (s *ProjectSandbox) DcosBrowserLogin(clusterUrl string) (*DcosCredentials, error) {
+  loginUrl := DcosBrowserLoginURL(clusterUrl)
+  if loginUrl == "" {
+    loginUrl = GetClusterURL(clusterUrl) + "/login?redirect_uri=urn:ietf:wg:oauth:2.0:oob"
+  }
+
+  defer LockTerminal()()
+  PrintInfo("Log-in at %s and paste the authentication token it gives you", loginUrl)
+  if err := OpenBrowser(loginUrl); err != nil {
+    PrintWarning("%s, please open the page yourself", err.Error())
+  }
+  idToken, err := ReadPassword("Authentication token")
+  if err != nil {
+    return nil, fmt.Errorf("Could not read the token: %s", err.Error())
+  }
+
+  token, err := DcosLoginWithOIDC(clusterUrl, strings.TrimSpace(idToken))
+  if err != nil {
+    return nil, err
+  }
+
+  return &DcosCredentials{Source: "login", Token: token}, s.CacheDcosLogin(clusterUrl, token, "oidc")
+}
+
+/**
+ * Remembers the token to use for the given cluster, and how it was obtained
+ * to log-in again when it expires
+ */
+func (s *ProjectSandbox) CacheDcosLogin(clusterUrl string, token string, method string) error {
+  if err := s.CacheDcosToken(clusterUrl, token); err != nil {
+    return err
+  }
+  store, err := s.GetCredentialStore()
+  if err != nil {
+    return err
+  }
+  return store.Set(dcosLoginKey(clusterUrl), method)
+}
+
+/**
+ * Returns the login method of a service account, with the absolute path of
+ * its key so it can be read from any directory
+ */
+func DcosServiceAccountLogin(uid string, keyFile string) string {
+  if abs, err := filepath.Abs(keyFile); err == nil {
+    keyFile = abs
+  }
+  return fmt.Sprintf("service-account:%s:%s", uid, keyFile)
+}
+
+/**
+ * Remembers the token to use for the given cluster
+ */
+func (s *ProjectSandbox) CacheDcosToken(clusterUrl string, token string) error {
+  store, err := s.GetCredentialStore()
+  if err != nil {
+    return err
+  }
+  return store.Set(dcosTokenKey(clusterUrl), token)
+}
+
+/**
+ * Forgets the cached token of the given cluster
+ */
+func (s *ProjectSandbox) ForgetDcosToken(clusterUrl string) error {
+  store, err := s.GetCredentialStore()
+  if err != nil {
+    return err
+  }
+  if err := store.Delete(dcosLoginKey(clusterUrl)); err != nil {
+    return err
+  }
+  return store.Delete(dcosTokenKey(clusterUrl))
+}
+
+/**
+ * Exports the credentials to the environment of the terraform process, where
+ * the DC/OS provider is going to pick them up
+ */
+func (c *DcosCredentials) ExportTo(tf *TerraformWrapper) {
+  if c.Token != "" {
+    tf.SetEnv("DCOS_ACS_TOKEN", c.Token)
+  }
+  if c.Username != "" {
+    tf.SetEnv("DCOS_USER", c.Username)
+    tf.SetEnv("DCOS_PASSWORD", c.Password)
+  }
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"fmt"
+	"os/exec"
 	"runtime"
 )
 
@@ -10,3 +12,24 @@ func ExecutableName(name string) string {
 	}
 	return name
 }
+
+/**
+ * Opens the given URL in the default browser of the user, without waiting
+ * for it to be closed
+ */
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Could not open the browser: %s", err.Error())
+	}
+	go cmd.Wait()
+	return nil
+}
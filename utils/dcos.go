@@ -1,10 +1,28 @@
 package utils
 
 import (
+  "crypto/sha256"
+  "encoding/hex"
   "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+
   "github.com/Masterminds/semver/v3"
 )
 
+// The service that lists the DC/OS releases
+var dcosVersionsURL string = "https://versions.d2iq.com/version"
+
+// The names of the DC/OS variants (as in dcos_variant) in the versions service
+var dcosVersionsVariants map[string][]string = map[string][]string{
+  "open": {"open"},
+  "ee":   {"ee", "enterprise"},
+}
+
 type DCOSPackageVersion struct {
   Version string
 }
@@ -15,34 +33,15 @@ type GithubRelease struct {
 }
 
 func GetLatestDCOSVersion(variant string, defaultVersion string) string {
-  buf, err := Download("https://versions.d2iq.com/version", WithDefaults).EventuallyReadAll()
+  versions, err := FetchDCOSVersions("")
   if err != nil {
     return defaultVersion
   }
-
-  data := make(map[string][]DCOSPackageVersion)
-  err = json.Unmarshal(buf, &data)
+  version, err := ResolveDCOSVersion(versions[variant], "latest-stable")
   if err != nil {
     return defaultVersion
   }
-
-  pkgs, ok := data[variant]
-  if !ok {
-    return defaultVersion
-  }
-
-  found := pkgs[0]
-  foundVer := semver.MustParse(found.Version)
-
-  for _, pkg := range pkgs {
-    ver := semver.MustParse(pkg.Version)
-    if ver.Compare(foundVer) > 0 {
-      foundVer = ver
-      found = pkg
-    }
-  }
-
-  return found.Version
+  return version
 }
 
 func GetLatestModuleVersion(defaultVersion string) string {
@@ -74,3 +73,129 @@ func GetLatestModuleVersion(defaultVersion string) string {
 
   return found
 }
+
+/**
+ * Returns the releases of each DC/OS variant (open and ee), the newest
+ * first, from the given file or URL (or the D2iQ versions service). The
+ * downloaded ones are cached, and used when they cannot be downloaded again.
+ */
+func FetchDCOSVersions(source string) (map[string][]*semver.Version, error) {
+  if source == "" {
+    source = dcosVersionsURL
+  }
+
+  var content []byte
+  var err error
+  if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+    cacheDir, err := GetCacheDir("dcos-versions")
+    if err != nil {
+      return nil, err
+    }
+    sum := sha256.Sum256([]byte(source))
+    cacheFile := filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+".json")
+
+    content, err = Download(source, WithDefaults).EventuallyReadAll()
+    if err != nil {
+      cached, cerr := ioutil.ReadFile(cacheFile)
+      if cerr != nil {
+        return nil, fmt.Errorf("Could not download the DC/OS versions: %s", err.Error())
+      }
+      PrintWarning("Could not download the DC/OS versions, using the cached ones: %s", err.Error())
+      content = cached
+    } else if err := ioutil.WriteFile(cacheFile, content, 0644); err != nil {
+      PrintWarning("Could not cache the DC/OS versions: %s", err.Error())
+    }
+  } else {
+    content, err = ioutil.ReadFile(source)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read the DC/OS versions %s: %s", source, err.Error())
+    }
+  }
+
+  data := make(map[string][]DCOSPackageVersion)
+  if err := json.Unmarshal(content, &data); err != nil {
+    return nil, fmt.Errorf("Could not parse the DC/OS versions of %s: %s", source, err.Error())
+  }
+
+  versions := make(map[string][]*semver.Version)
+  for variant, names := range dcosVersionsVariants {
+    seen := make(map[string]bool)
+    for _, name := range names {
+      for _, pkg := range data[name] {
+        ver, err := semver.NewVersion(pkg.Version)
+        if err != nil || seen[ver.String()] {
+          continue
+        }
+        seen[ver.String()] = true
+        versions[variant] = append(versions[variant], ver)
+      }
+    }
+    sort.Sort(sort.Reverse(semver.Collection(versions[variant])))
+  }
+  return versions, nil
+}
+
+/**
+ * Checks if the given DC/OS version is an alias: `latest-stable` (or
+ * `latest`), or the latest release of a minor version (eg. `2.1-latest`)
+ */
+func IsDCOSVersionAlias(version string) bool {
+  version = strings.ToLower(version)
+  return version == "latest" || version == "latest-stable" || regexp.MustCompile(`^\d+\.\d+-latest$`).MatchString(version)
+}
+
+/**
+ * Returns the aliases that resolve to the given release (eg. `2.1-latest`),
+ * among the given releases (the newest first)
+ */
+func GetDCOSVersionAliases(versions []*semver.Version, ver *semver.Version) []string {
+  var aliases []string
+  for _, alias := range []string{"latest-stable", fmt.Sprintf("%d.%d-latest", ver.Major(), ver.Minor())} {
+    if resolved, err := ResolveDCOSVersion(versions, alias); err == nil && resolved == ver.Original() {
+      aliases = append(aliases, alias)
+    }
+  }
+  return aliases
+}
+
+/**
+ * Resolves a DC/OS version alias to the release it stands for, among the
+ * given releases (the newest first). The pre-releases are never picked.
+ * Other versions are returned as-is.
+ */
+func ResolveDCOSVersion(versions []*semver.Version, version string) (string, error) {
+  if !IsDCOSVersionAlias(version) {
+    return version, nil
+  }
+
+  minor := ""
+  if m := regexp.MustCompile(`^(\d+\.\d+)-latest$`).FindStringSubmatch(strings.ToLower(version)); m != nil {
+    minor = m[1]
+  }
+  var minors []string
+  for _, ver := range versions {
+    if ver.Prerelease() != "" {
+      continue
+    }
+    verMinor := fmt.Sprintf("%d.%d", ver.Major(), ver.Minor())
+    if minor == "" || verMinor == minor {
+      return ver.Original(), nil
+    }
+    if len(minors) == 0 || minors[len(minors)-1] != verMinor {
+      minors = append(minors, verMinor)
+    }
+  }
+
+  if minor == "" {
+    return "", fmt.Errorf("There is no stable DC/OS release to resolve %s", version)
+  }
+  return "", fmt.Errorf("There is no stable DC/OS %s release, the stable minor versions are: %s", minor, strings.Join(minors, ", "))
+}
+
+/**
+ * Returns the releases of each DC/OS variant, from the versions service of
+ * the project (`preflight.dcos_versions`) or the D2iQ one
+ */
+func (s *ProjectSandbox) GetDCOSVersions() (map[string][]*semver.Version, error) {
+  return FetchDCOSVersions(s.GetConfig().Preflight.DcosVersions)
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strings"
+)
+
+/**
+ * A minimal client for the DC/OS APIs behind the admin router
+ */
+type DcosClient struct {
+  url    string
+  token  string
+  client *http.Client
+}
+
+func CreateDcosClient(clusterUrl string, creds *DcosCredentials) *DcosClient {
+  token := ""
+  if creds != nil {
+    token = creds.Token
+  }
+  return &DcosClient{GetClusterURL(clusterUrl), token, getClusterHttpClient()}
+}
+
+/**
+ * Performs a request against the cluster and decodes the JSON response
+ */
+func (c *DcosClient) Request(method string, path string, headers map[string]string, body interface{}, response interface{}) error {
+  var reader io.Reader
+  if body != nil {
+    payload, err := json.Marshal(body)
+    if err != nil {
+      return err
+    }
+    reader = bytes.NewReader(payload)
+  }
+
+  req, err := http.NewRequest(method, c.url+path, reader)
+  if err != nil {
+    return err
+  }
+  if c.token != "" {
+    req.Header.Set("Authorization", "token="+c.token)
+  }
+  for k, v := range headers {
+    req.Header.Set(k, v)
+  }
+
+  resp, err := c.client.Do(req)
+  if err != nil {
+    return fmt.Errorf("could not reach the cluster: %s", err.Error())
+  }
+  defer resp.Body.Close()
+
+  content, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return fmt.Errorf("could not read response: %s", err.Error())
+  }
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return fmt.Errorf("%s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+  }
+
+  if response != nil {
+    err = json.Unmarshal(content, response)
+    if err != nil {
+      return fmt.Errorf("could not parse response of %s: %s", path, err.Error())
+    }
+  }
+  return nil
+}
+
+/**
+ * Fetches a plain-text document from the cluster
+ */
+func (c *DcosClient) GetText(path string) (string, error) {
+  req, err := http.NewRequest("GET", c.url+path, nil)
+  if err != nil {
+    return "", err
+  }
+  if c.token != "" {
+    req.Header.Set("Authorization", "token="+c.token)
+  }
+
+  resp, err := c.client.Do(req)
+  if err != nil {
+    return "", fmt.Errorf("could not reach the cluster: %s", err.Error())
+  }
+  defer resp.Body.Close()
+
+  content, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return "", fmt.Errorf("could not read response: %s", err.Error())
+  }
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return "", fmt.Errorf("GET %s failed: %s", path, resp.Status)
+  }
+  return string(content), nil
+}
+
+/**
+ * Asks Cosmos for the version of a package that would be installed
+ */
+func (c *DcosClient) DescribePackage(name string, version string) (string, error) {
+  body := map[string]string{"packageName": name}
+  if version != "" && version != "latest" {
+    body["packageVersion"] = version
+  }
+
+  var res struct {
+    Package struct {
+      Version string `json:"version"`
+    } `json:"package"`
+  }
+  err := c.Request("POST", "/package/describe", map[string]string{
+    "Content-Type": "application/vnd.dcos.package.describe-request+json;charset=utf-8;version=v1",
+    "Accept":       "application/vnd.dcos.package.describe-response+json;charset=utf-8;version=v3",
+  }, body, &res)
+  if err != nil {
+    return "", err
+  }
+
+  return res.Package.Version, nil
+}
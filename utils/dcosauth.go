@@ -0,0 +1,178 @@
+package utils
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "strings"
+  "time"
+)
+
+/**
+ * Performs a login against the IAM service of the given cluster and returns
+ * the ACS token on success
+ */
+func dcosLogin(clusterUrl string, body map[string]string) (string, error) {
+  payload, err := json.Marshal(body)
+  if err != nil {
+    return "", err
+  }
+
+  client := getClusterHttpClient()
+  resp, err := client.Post(GetClusterURL(clusterUrl)+"/acs/api/v1/auth/login", "application/json", bytes.NewReader(payload))
+  if err != nil {
+    return "", fmt.Errorf("could not reach the cluster: %s", err.Error())
+  }
+  defer resp.Body.Close()
+
+  content, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return "", fmt.Errorf("could not read login response: %s", err.Error())
+  }
+  if resp.StatusCode != 200 {
+    return "", fmt.Errorf("login failed: %s", resp.Status)
+  }
+
+  var res struct {
+    Token string `json:"token"`
+  }
+  err = json.Unmarshal(content, &res)
+  if err != nil || res.Token == "" {
+    return "", fmt.Errorf("login failed: invalid response from the cluster")
+  }
+
+  return res.Token, nil
+}
+
+/**
+ * Logs-in using the given username and password
+ */
+func DcosLoginWithPassword(clusterUrl string, uid string, password string) (string, error) {
+  return dcosLogin(clusterUrl, map[string]string{
+    "uid":      uid,
+    "password": password,
+  })
+}
+
+/**
+ * Logs-in using a service account and it's private key, by signing a short
+ * lived login token
+ */
+func DcosLoginWithServiceAccount(clusterUrl string, uid string, privateKey []byte) (string, error) {
+  key, err := parsePrivateKey(privateKey, "")
+  if err != nil {
+    return "", err
+  }
+
+  loginToken, err := signRS256Token(key, map[string]interface{}{
+    "uid": uid,
+    "exp": time.Now().Add(5 * time.Minute).Unix(),
+  })
+  if err != nil {
+    return "", fmt.Errorf("could not sign login token: %s", err.Error())
+  }
+
+  return dcosLogin(clusterUrl, map[string]string{
+    "uid":   uid,
+    "token": loginToken,
+  })
+}
+
+/**
+ * Logs-in using the OpenID Connect token that was given by the login page of
+ * the cluster (eg. on DC/OS Open)
+ */
+func DcosLoginWithOIDC(clusterUrl string, idToken string) (string, error) {
+  return dcosLogin(clusterUrl, map[string]string{
+    "token": idToken,
+  })
+}
+
+/**
+ * Returns the URL of the login page of the cluster when it only supports the
+ * OpenID Connect login through the browser, or an empty string otherwise
+ */
+func DcosBrowserLoginURL(clusterUrl string) string {
+  client := getClusterHttpClient()
+  resp, err := client.Get(GetClusterURL(clusterUrl) + "/acs/api/v1/auth/providers")
+  if err != nil {
+    return ""
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != 200 {
+    return ""
+  }
+
+  var providers map[string]struct {
+    ClientMethod string `json:"client-method"`
+    Config       struct {
+      StartFlowURL string `json:"start_flow_url"`
+    } `json:"config"`
+  }
+  if err := json.NewDecoder(resp.Body).Decode(&providers); err != nil {
+    return ""
+  }
+  loginUrl := ""
+  for _, provider := range providers {
+    if provider.ClientMethod != "browser-prompt-oidcidtoken-get-authtoken" {
+      return ""
+    }
+    loginUrl = provider.Config.StartFlowURL
+  }
+  if strings.HasPrefix(loginUrl, "/") {
+    loginUrl = GetClusterURL(clusterUrl) + loginUrl
+  }
+  return loginUrl
+}
+
+/**
+ * Returns when the given ACS token expires, if it can be found
+ */
+func DcosTokenExpiry(token string) (time.Time, bool) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return time.Time{}, false
+  }
+  body, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+  if err != nil {
+    return time.Time{}, false
+  }
+  var claims struct {
+    Exp int64 `json:"exp"`
+  }
+  if err := json.Unmarshal(body, &claims); err != nil || claims.Exp == 0 {
+    return time.Time{}, false
+  }
+  return time.Unix(claims.Exp, 0), true
+}
+
+/**
+ * Creates a JWT with the given claims, signed using RS256
+ */
+func signRS256Token(key *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+  enc := base64.RawURLEncoding
+
+  header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+  if err != nil {
+    return "", err
+  }
+  body, err := json.Marshal(claims)
+  if err != nil {
+    return "", err
+  }
+
+  unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(body)
+  sum := sha256.Sum256([]byte(unsigned))
+  sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+  if err != nil {
+    return "", err
+  }
+
+  return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+var dcosRecordDir string = ""
+var dcosReplayDir string = ""
+
+/**
+ * A request/response pair recorded from a real cluster
+ */
+type dcosRecording struct {
+  Method      string `json:"method"`
+  Path        string `json:"path"`
+  Status      int    `json:"status"`
+  ContentType string `json:"content_type"`
+  Body        string `json:"body"`
+}
+
+/**
+ * Records all the DC/OS API calls in the given directory
+ */
+func SetDcosRecordDir(dir string) {
+  dcosRecordDir = dir
+}
+
+/**
+ * Replays the DC/OS API calls from the given directory, instead of talking
+ * to a real cluster
+ */
+func SetDcosReplayDir(dir string) {
+  dcosReplayDir = dir
+}
+
+/**
+ * Returns the file a request is recorded in. Only the method, path, query
+ * and body are considered, so recordings are independent of the cluster
+ * address. The credentials of a login are ignored.
+ */
+func getDcosRecordingPath(dir string, method string, path string, body []byte) string {
+  if strings.HasPrefix(path, "/acs/api/v1/auth/login") {
+    body = nil
+  }
+  sum := sha256.Sum256(append([]byte(method+" "+path+"\n"), body...))
+  name := regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(strings.SplitN(path, "?", 2)[0], "_")
+  if len(name) > 60 {
+    name = name[:60]
+  }
+  return filepath.Join(dir, fmt.Sprintf("%s%s_%s.json", method, name, hex.EncodeToString(sum[:4])))
+}
+
+/**
+ * Removes the secrets from a recorded response body
+ */
+func redactDcosRecording(body string) string {
+  var obj map[string]interface{}
+  if err := json.Unmarshal([]byte(body), &obj); err != nil {
+    return body
+  }
+  if _, ok := obj["token"]; !ok {
+    return body
+  }
+  obj["token"] = "recorded-token"
+  redacted, err := json.Marshal(obj)
+  if err != nil {
+    return body
+  }
+  return string(redacted)
+}
+
+func (r *dcosRecording) toResponse(req *http.Request) *http.Response {
+  header := make(http.Header)
+  if r.ContentType != "" {
+    header.Set("Content-Type", r.ContentType)
+  }
+  return &http.Response{
+    Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+    StatusCode:    r.Status,
+    Proto:         "HTTP/1.1",
+    ProtoMajor:    1,
+    ProtoMinor:    1,
+    Header:        header,
+    Body:          ioutil.NopCloser(strings.NewReader(r.Body)),
+    ContentLength: int64(len(r.Body)),
+    Request:       req,
+  }
+}
+
+/**
+ * Reads the recorded response of the given request
+ */
+func loadDcosRecording(dir string, method string, path string, body []byte) (*dcosRecording, error) {
+  content, err := ioutil.ReadFile(getDcosRecordingPath(dir, method, path, body))
+  if err != nil {
+    return nil, fmt.Errorf("there is no recorded response for %s %s in %s", method, path, dir)
+  }
+  rec := &dcosRecording{}
+  if err := json.Unmarshal(content, rec); err != nil {
+    return nil, fmt.Errorf("could not parse the recorded response for %s %s: %s", method, path, err.Error())
+  }
+  return rec, nil
+}
+
+/**
+ * An HTTP transport that saves every response it gets in a directory
+ */
+type dcosRecordingTransport struct {
+  base http.RoundTripper
+  dir  string
+}
+
+/**
+ * Reads the body of a request, leaving it in place to be sent
+ */
+func readRequestBody(req *http.Request) ([]byte, error) {
+  if req.Body == nil {
+    return nil, nil
+  }
+  body, err := ioutil.ReadAll(req.Body)
+  req.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+  req.Body = ioutil.NopCloser(bytes.NewReader(body))
+  return body, nil
+}
+
+func (t *dcosRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  reqBody, err := readRequestBody(req)
+  if err != nil {
+    return nil, err
+  }
+
+  resp, err := t.base.RoundTrip(req)
+  if err != nil {
+    return nil, err
+  }
+
+  body, err := ioutil.ReadAll(resp.Body)
+  resp.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+  resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+  rec := &dcosRecording{
+    Method:      req.Method,
+    Path:        req.URL.RequestURI(),
+    Status:      resp.StatusCode,
+    ContentType: resp.Header.Get("Content-Type"),
+    Body:        redactDcosRecording(string(body)),
+  }
+  content, err := json.MarshalIndent(rec, "", "  ")
+  if err == nil {
+    err = os.MkdirAll(t.dir, os.ModePerm)
+  }
+  if err == nil {
+    err = ioutil.WriteFile(getDcosRecordingPath(t.dir, rec.Method, rec.Path, reqBody), content, 0644)
+  }
+  if err != nil {
+    PrintWarning("Could not record %s %s: %s", rec.Method, rec.Path, err.Error())
+  }
+
+  return resp, nil
+}
+
+/**
+ * An HTTP transport that responds with the recordings of a directory,
+ * without ever reaching the network
+ */
+type dcosReplayTransport struct {
+  dir string
+}
+
+func (t *dcosReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  body, err := readRequestBody(req)
+  if err != nil {
+    return nil, err
+  }
+  rec, err := loadDcosRecording(t.dir, req.Method, req.URL.RequestURI(), body)
+  if err != nil {
+    return nil, err
+  }
+  return rec.toResponse(req), nil
+}
+
+/**
+ * Wraps the transport used for the DC/OS API calls, if we are recording or
+ * replaying them
+ */
+func wrapDcosTransport(base http.RoundTripper) http.RoundTripper {
+  replayDir := dcosReplayDir
+  if replayDir == "" {
+    replayDir = os.Getenv("TERRAFORM_WHEELS_DCOS_REPLAY")
+  }
+  recordDir := dcosRecordDir
+  if recordDir == "" {
+    recordDir = os.Getenv("TERRAFORM_WHEELS_DCOS_RECORD")
+  }
+
+  if replayDir != "" {
+    return &dcosReplayTransport{replayDir}
+  }
+  if recordDir != "" {
+    return &dcosRecordingTransport{base, recordDir}
+  }
+  return base
+}
+
+/**
+ * Serves the recordings of the given directory as a mock DC/OS cluster, so
+ * terraform (and the DC/OS provider) can be pointed to it
+ */
+func ServeDcosMock(dir string, listen string) error {
+  handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    body, err := readRequestBody(req)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+    rec, err := loadDcosRecording(dir, req.Method, req.URL.RequestURI(), body)
+    if err != nil {
+      PrintWarning("%s", err.Error())
+      http.Error(w, err.Error(), http.StatusNotFound)
+      return
+    }
+    if rec.ContentType != "" {
+      w.Header().Set("Content-Type", rec.ContentType)
+    }
+    w.WriteHeader(rec.Status)
+    w.Write([]byte(rec.Body))
+  })
+
+  return http.ListenAndServe(listen, handler)
+}
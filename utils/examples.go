@@ -0,0 +1,56 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+
+  "gopkg.in/yaml.v3"
+)
+
+/**
+ * Returns the names of the bundled example clusters, and of the ones in the
+ * assets override directory
+ */
+func ListClusterExamples() ([]string, error) {
+  files, err := ListAssets("examples")
+  if err != nil {
+    return nil, err
+  }
+
+  var names []string
+  for _, file := range files {
+    if strings.HasSuffix(file, ".yaml") {
+      names = append(names, strings.TrimSuffix(file, ".yaml"))
+    }
+  }
+  return names, nil
+}
+
+/**
+ * Returns the cluster spec of the given example, as it's written in the
+ * project to be customized
+ */
+func ReadClusterExample(name string) ([]byte, error) {
+  content, err := ReadAsset("examples/" + name + ".yaml")
+  if err != nil {
+    names, _ := ListClusterExamples()
+    return nil, fmt.Errorf("Unknown example '%s', the available ones are: %s", name, strings.Join(names, ", "))
+  }
+  return content, nil
+}
+
+/**
+ * Returns the description of the given example
+ */
+func GetClusterExampleDescription(name string) (string, error) {
+  content, err := ReadClusterExample(name)
+  if err != nil {
+    return "", err
+  }
+
+  spec := &ClusterSpec{}
+  if err := yaml.Unmarshal(content, spec); err != nil {
+    return "", fmt.Errorf("Could not parse the example %s: %s", name, err.Error())
+  }
+  return spec.Description, nil
+}
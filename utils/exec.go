@@ -6,11 +6,25 @@ import (
   "io/ioutil"
   "os"
   "os/exec"
-  "os/signal"
   "strings"
+  "sync"
   "syscall"
 )
 
+/**
+ * Serializes the writes of multiple goroutines
+ */
+type syncWriter struct {
+  mu sync.Mutex
+  w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.w.Write(p)
+}
+
 func updateEnv(a []string, b []string) []string {
   merged := make(map[string]string)
 
@@ -35,6 +49,25 @@ func updateEnv(a []string, b []string) []string {
  * Run the given command and pipe stdout/stderr
  */
 func ExecuteAndPassthrough(env []string, binary string, args ...string) (int, error) {
+  return ExecuteAndPassthroughWithCapture(env, nil, binary, args...)
+}
+
+/**
+ * Run the given command and pipe stdout/stderr, while also copying them to
+ * the given writer (if not nil)
+ */
+func ExecuteAndPassthroughWithCapture(env []string, capture io.Writer, binary string, args ...string) (int, error) {
+  return ExecuteAndPassthroughWithInterrupt(env, capture, nil, binary, args...)
+}
+
+/**
+ * Same as ExecuteAndPassthroughWithCapture, but also interrupts the command
+ * (as with Ctrl+C) when the given channel is closed
+ */
+func ExecuteAndPassthroughWithInterrupt(env []string, capture io.Writer, interrupt <-chan struct{}, binary string, args ...string) (code int, err error) {
+  log := LogCommand(env, binary, args)
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command(binary, args...)
   cmd.Stdin = os.Stdin
   cmd.Env = updateEnv(os.Environ(), env)
@@ -51,31 +84,62 @@ func ExecuteAndPassthrough(env []string, binary string, args ...string) (int, er
     return 0, err
   }
 
-  // Async readers of the Stdout/Err
+  // Async readers of the Stdout/Err, that never show the secrets. Each
+  // stream is redacted on its own, so that their lines are not mixed.
+  var outTarget io.Writer = colorableStdout
+  var errTarget io.Writer = colorableStderr
+  if capture != nil {
+    // Both streams are written concurrently
+    capture = &syncWriter{w: capture}
+    outTarget = io.MultiWriter(outTarget, capture)
+    errTarget = io.MultiWriter(errTarget, capture)
+  }
+  outWriter := NewRedactingWriter(outTarget)
+  errWriter := NewRedactingWriter(errTarget)
+
+  var readers sync.WaitGroup
+  readers.Add(2)
   go func() {
-    _, _ = io.Copy(colorableStdout, stdout)
+    _, _ = io.Copy(outWriter, stdout)
+    _ = outWriter.Close()
+    readers.Done()
   }()
   go func() {
-    _, _ = io.Copy(colorableStderr, stderr)
+    _, _ = io.Copy(errWriter, stderr)
+    _ = errWriter.Close()
+    readers.Done()
   }()
 
-  // Forward interrupt signals to the launched process
-  sigs := make(chan os.Signal, 1)
-  signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-  go func() {
-    for {
-      sig := <-sigs
-      if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
-        return
+  // Ctrl+C goes to the launched process (see HandleInterrupts), and so do
+  // the interruptions that we decide on
+  killed := make(chan struct{})
+  defer setRunningCommand(cmd, killed)()
+  stopped := make(chan struct{})
+  defer close(stopped)
+  if interrupt != nil {
+    go func() {
+      select {
+      case <-interrupt:
+        if err := cmd.Process.Signal(os.Interrupt); err != nil && err != os.ErrProcessDone {
+          PrintWarning("Could not interrupt %s: %s", binary, err.Error())
+        }
+      case <-stopped:
       }
-      cmd.Process.Signal(sig)
-    }
-  }()
+    }()
+  }
 
-  // Wait until the command is completed and remove the signal handlers
+  // Wait until the command is completed. The output of a killed command is
+  // not waited for, the processes it started might still hold it.
+  output := make(chan struct{})
+  go func() {
+    readers.Wait()
+    close(output)
+  }()
+  select {
+  case <-output:
+  case <-killed:
+  }
   err = cmd.Wait()
-  signal.Reset(syscall.SIGINT, syscall.SIGTERM)
-  sigs <- syscall.SIGINT
 
   if err != nil {
     // Get exit code on non-zero exits
@@ -94,7 +158,10 @@ func ExecuteAndPassthrough(env []string, binary string, args ...string) (int, er
 /**
  * Change directory and run the given command and pipe stdout/stderr
  */
-func ExecuteInFolderAndPassthrough(workDir string, binary string, args ...string) (int, error) {
+func ExecuteInFolderAndPassthrough(workDir string, binary string, args ...string) (code int, err error) {
+  log := LogCommand(nil, binary, args)
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command(binary, args...)
   cmd.Env = os.Environ()
   cmd.Dir = workDir
@@ -110,6 +177,7 @@ func ExecuteInFolderAndPassthrough(workDir string, binary string, args ...string
   if err := cmd.Start(); err != nil {
     return 0, err
   }
+  defer setRunningCommand(cmd, nil)()
 
   // Async readers of the Stdout/Err
   go func() {
@@ -136,7 +204,10 @@ func ExecuteInFolderAndPassthrough(workDir string, binary string, args ...string
 /**
  * Change directory and run the given command and pipe stdout/stderr
  */
-func ExecuteAndCollect(env []string, binary string, args ...string) (int, string, string, error) {
+func ExecuteAndCollect(env []string, binary string, args ...string) (code int, sout string, serr string, err error) {
+  log := LogCommand(env, binary, args)
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command(binary, args...)
   cmd.Env = updateEnv(os.Environ(), env)
 
@@ -181,7 +252,10 @@ func ExecuteAndCollect(env []string, binary string, args ...string) (int, string
 /**
  * Change directory and run the given command and pipe stdout/stderr
  */
-func ShellExecuteInFolderAndPassthrough(workDir string, cmdline string) (int, error) {
+func ShellExecuteInFolderAndPassthrough(workDir string, cmdline string) (code int, err error) {
+  log := LogCommand(nil, "sh", []string{"-c", cmdline})
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command("sh", "-c", cmdline)
   cmd.Env = os.Environ()
   cmd.Dir = workDir
@@ -197,6 +271,7 @@ func ShellExecuteInFolderAndPassthrough(workDir string, cmdline string) (int, er
   if err := cmd.Start(); err != nil {
     return 0, err
   }
+  defer setRunningCommand(cmd, nil)()
 
   // Async readers of the Stdout/Err
   go func() {
@@ -223,7 +298,10 @@ func ShellExecuteInFolderAndPassthrough(workDir string, cmdline string) (int, er
 /**
  * Execute silently and return exit code
  */
-func ExecuteSilently(binary string, args ...string) (int, error) {
+func ExecuteSilently(binary string, args ...string) (code int, err error) {
+  log := LogCommand(nil, binary, args)
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command(binary, args...)
   cmd.Env = os.Environ()
   if err := cmd.Start(); err != nil {
@@ -247,7 +325,10 @@ func ExecuteSilently(binary string, args ...string) (int, error) {
 /**
  * Execute silently on a shell terminal return exit code
  */
-func ShellExecuteSilently(cmdline string) (int, error) {
+func ShellExecuteSilently(cmdline string) (code int, err error) {
+  log := LogCommand(nil, "sh", []string{"-c", cmdline})
+  defer func() { log.Done(code, err) }()
+
   cmd := exec.Command("sh", "-c", cmdline)
   cmd.Env = os.Environ()
   if err := cmd.Start(); err != nil {
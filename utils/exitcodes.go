@@ -0,0 +1,86 @@
+package utils
+
+import (
+  "errors"
+  "os"
+)
+
+/**
+ * The exit codes of terraform-wheels, so scripts can tell the failures apart
+ */
+const (
+  ExitOK    = 0
+  ExitError = 1
+  // `plan -detailed-exitcode` found changes to apply, as with terraform
+  ExitDriftDetected = 2
+  // Invalid command line, .wheels.yaml or project
+  ExitConfigError = 3
+  ExitTerraformFailed = 4
+  // A plugin or a hook of .wheels.yaml failed
+  ExitPluginFailed     = 5
+  ExitValidationFailed = 6
+  // Interrupted with Ctrl+C, as with the shells (128 + SIGINT)
+  ExitInterrupted = 130
+)
+
+/**
+ * An error that makes terraform-wheels exit with the given code
+ */
+type ExitCodeError struct {
+  Code int
+  Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+  return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+  return e.Err
+}
+
+/**
+ * Gives an exit code to the error
+ */
+func WithExitCode(code int, err error) error {
+  if err == nil {
+    return nil
+  }
+  return &ExitCodeError{code, err}
+}
+
+/**
+ * Gives an exit code to the error, unless it already has one
+ */
+func WithDefaultExitCode(code int, err error) error {
+  var coded *ExitCodeError
+  if err == nil || errors.As(err, &coded) {
+    return err
+  }
+  return &ExitCodeError{code, err}
+}
+
+/**
+ * Returns the code to exit with because of the error
+ */
+func GetExitCode(err error) int {
+  if err == nil {
+    return ExitOK
+  }
+  var coded *ExitCodeError
+  if errors.As(err, &coded) {
+    return coded.Code
+  }
+  return ExitError
+}
+
+/**
+ * Exits with the given code, once what was registered with RegisterCleanup
+ * is cleaned up, and the trace and the telemetry are sent
+ */
+func Exit(code int) {
+  runCleanups()
+  WriteTrace()
+  SendTelemetry(code != ExitOK)
+  os.Exit(code)
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+  "bytes"
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+  "sync"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+var failFastMode bool = false
+
+// Errors from AWS that will not go away, no matter how long terraform keeps
+// creating the other resources
+var fatalErrorPatterns []string = []string{
+  `InvalidAMIID\.(Malformed|NotFound|Unavailable)`,
+  `UnauthorizedOperation`,
+  `AuthFailure`,
+  `InvalidClientTokenId`,
+  `ExpiredToken`,
+  `SignatureDoesNotMatch`,
+  `AccessDenied`,
+  `OptInRequired`,
+  `InstanceLimitExceeded`,
+  `VcpuLimitExceeded`,
+  `InvalidKeyPair\.NotFound`,
+}
+
+/**
+ * Checks if the output of terraform contains an error that will not go away
+ * by trying again
+ */
+func IsFatalError(output string) bool {
+  for _, pattern := range fatalErrorPatterns {
+    if regexp.MustCompile(pattern).MatchString(output) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Enables the interruption of terraform on the first fatal error
+ */
+func SetFailFastMode(enabled bool) {
+  failFastMode = enabled
+}
+
+/**
+ * Checks if terraform should be interrupted on the first fatal error
+ */
+func IsFailFastMode() bool {
+  return failFastMode
+}
+
+/**
+ * A writer that scans the output of terraform line by line, and closes the
+ * interrupt channel on the first fatal error
+ */
+type fatalErrorWatcher struct {
+  patterns  []*regexp.Regexp
+  interrupt chan struct{}
+  once      sync.Once
+  line      bytes.Buffer
+}
+
+func createFatalErrorWatcher(extraPatterns []string) *fatalErrorWatcher {
+  w := &fatalErrorWatcher{interrupt: make(chan struct{})}
+  for _, pattern := range append(fatalErrorPatterns, extraPatterns...) {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+      PrintWarning("Invalid fail-fast pattern '%s' in %s: %s", pattern, WheelsConfigFile, err.Error())
+      continue
+    }
+    w.patterns = append(w.patterns, re)
+  }
+  return w
+}
+
+func (w *fatalErrorWatcher) Write(p []byte) (int, error) {
+  for _, b := range p {
+    if b != '\n' {
+      w.line.WriteByte(b)
+      continue
+    }
+    w.checkLine(w.line.String())
+    w.line.Reset()
+  }
+  return len(p), nil
+}
+
+func (w *fatalErrorWatcher) checkLine(line string) {
+  for _, re := range w.patterns {
+    if match := re.FindString(line); match != "" {
+      w.once.Do(func() {
+        PrintWarning("Fatal error %s, interrupting terraform (the resources in flight are stopped)", Bold(match))
+        close(w.interrupt)
+      })
+      return
+    }
+  }
+}
+
+/**
+ * Returns true if the interrupt channel was closed
+ */
+func (w *fatalErrorWatcher) isInterrupted() bool {
+  select {
+  case <-w.interrupt:
+    return true
+  default:
+    return false
+  }
+}
+
+/**
+ * The resources that failed with the same error
+ */
+type TerraformErrorGroup struct {
+  Code      string
+  Message   string
+  Addresses []string
+}
+
+/**
+ * @brief      Groups the errors in the output of a failed terraform run by
+ *             their cause
+ *
+ * Terraform 0.11 lists the errors as `* <address>: <message>`, and the AWS
+ * errors contain a code (eg. `InvalidAMIID.Malformed: ...`) that is shared by
+ * all the resources failing for the same reason.
+ */
+func SummarizeTerraformErrors(output string) []TerraformErrorGroup {
+  reItem := regexp.MustCompile(`^\* ([A-Za-z0-9_.\-\[\]"]+): (.*)$`)
+  reCode := regexp.MustCompile(`\b([A-Z][A-Za-z]+(\.[A-Za-z]+)?): (.*)$`)
+  reColors := regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+  groups := make(map[string]*TerraformErrorGroup)
+  var order []string
+  for _, line := range strings.Split(reColors.ReplaceAllString(output, ""), "\n") {
+    m := reItem.FindStringSubmatch(strings.TrimSpace(line))
+    if m == nil {
+      continue
+    }
+    code, message := "", m[2]
+    if c := reCode.FindStringSubmatch(m[2]); c != nil {
+      code, message = c[1], c[3]
+    }
+
+    key := code
+    if key == "" {
+      key = message
+    }
+    group, ok := groups[key]
+    if !ok {
+      group = &TerraformErrorGroup{Code: code, Message: message}
+      groups[key] = group
+      order = append(order, key)
+    }
+    group.Addresses = append(group.Addresses, m[1])
+  }
+
+  var ret []TerraformErrorGroup
+  for _, key := range order {
+    sort.Strings(groups[key].Addresses)
+    ret = append(ret, *groups[key])
+  }
+  return ret
+}
+
+/**
+ * Prints the errors of a failed terraform run, grouped by their cause
+ */
+func PrintErrorSummary(output string) {
+  groups := SummarizeTerraformErrors(output)
+  if len(groups) == 0 {
+    return
+  }
+
+  PrintInfo("Summary of the errors:")
+  for _, group := range groups {
+    cause := group.Message
+    if group.Code != "" {
+      cause = fmt.Sprintf("%s: %s", Bold(group.Code), group.Message)
+    }
+    Printf("  %s\n", cause)
+    for _, address := range group.Addresses {
+      Printf("    - %s\n", address)
+    }
+  }
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  "strings"
+
+  "github.com/hashicorp/hcl/hcl/ast"
+  "github.com/hashicorp/hcl/hcl/parser"
+  "github.com/hashicorp/hcl/hcl/printer"
+)
+
+var fastMode bool = false
+
+// Where we remember what the project looked like on the last apply
+var appliedBlocksFile string = "applied-blocks.json"
+
+/**
+ * Enables the fast (and unsafe) mode for iterative development
+ */
+func SetFastMode(enabled bool) {
+  fastMode = enabled
+}
+
+/**
+ * Checks if we are running in fast mode
+ */
+func IsFastMode() bool {
+  return fastMode
+}
+
+/**
+ * Returns the terraform address of a top-level block (eg. `module.dcos` or
+ * `aws_instance.foo`)
+ */
+func getBlockAddress(item *ast.ObjectItem) string {
+  var keys []string
+  for _, k := range item.Keys {
+    if v, ok := k.Token.Value().(string); ok {
+      keys = append(keys, v)
+    } else {
+      keys = append(keys, k.Token.Text)
+    }
+  }
+
+  if len(keys) == 3 && keys[0] == "resource" {
+    return keys[1] + "." + keys[2]
+  }
+  return strings.Join(keys, ".")
+}
+
+/**
+ * Computes a hash of every top-level block in the project, by address
+ */
+func (s *ProjectSandbox) getBlockHashes() (map[string]string, error) {
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return nil, fmt.Errorf("Could not enumerate files: %s", err.Error())
+  }
+
+  hashes := make(map[string]string)
+  for _, file := range files {
+    if !strings.HasSuffix(file.Name(), ".tf") {
+      continue
+    }
+
+    content, err := s.ReadFile(file.Name())
+    if err != nil {
+      return nil, fmt.Errorf("Could not read %s: %s", file.Name(), err.Error())
+    }
+    root, err := parser.Parse(content)
+    if err != nil {
+      return nil, fmt.Errorf("Could not parse %s: %s", file.Name(), err.Error())
+    }
+    list, ok := root.Node.(*ast.ObjectList)
+    if !ok {
+      continue
+    }
+
+    for _, item := range list.Items {
+      addr := getBlockAddress(item)
+
+      var buf bytes.Buffer
+      if err := printer.Fprint(&buf, item); err != nil {
+        return nil, fmt.Errorf("Could not format %s: %s", addr, err.Error())
+      }
+
+      // Blocks like `provider` or `locals` can be repeated
+      sum := sha256.Sum256(append([]byte(hashes[addr]), buf.Bytes()...))
+      hashes[addr] = hex.EncodeToString(sum[:])
+    }
+  }
+
+  return hashes, nil
+}
+
+/**
+ * @brief      Remembers the current project blocks as applied, so the next
+ *             fast run can target only what changed since then
+ */
+func (s *ProjectSandbox) RecordAppliedBlocks() error {
+  hashes, err := s.getBlockHashes()
+  if err != nil {
+    return err
+  }
+
+  content, err := json.MarshalIndent(hashes, "", "  ")
+  if err != nil {
+    return err
+  }
+
+  path, err := s.GetWheelsPath(appliedBlocksFile)
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(path, content, 0644)
+}
+
+/**
+ * @brief      Forgets the applied blocks (eg. after a destroy)
+ */
+func (s *ProjectSandbox) ForgetAppliedBlocks() error {
+  path, err := s.GetWheelsPath(appliedBlocksFile)
+  if err != nil {
+    return err
+  }
+  if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
+
+/**
+ * Returns the addresses that changed since the last apply, or nil if they
+ * cannot be narrowed down
+ */
+func (s *ProjectSandbox) getChangedTargets() ([]string, error) {
+  path, err := s.GetWheelsPath(appliedBlocksFile)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    PrintWarning("There is no record of a previous apply, cannot narrow down the targets")
+    return nil, nil
+  }
+
+  applied := make(map[string]string)
+  if err := json.Unmarshal(content, &applied); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", path, err.Error())
+  }
+
+  current, err := s.getBlockHashes()
+  if err != nil {
+    return nil, err
+  }
+
+  // Both changed and removed blocks are targeted
+  changed := make(map[string]bool)
+  for addr, hash := range current {
+    if applied[addr] != hash {
+      changed[addr] = true
+    }
+  }
+  for addr, _ := range applied {
+    if _, ok := current[addr]; !ok {
+      changed[addr] = true
+    }
+  }
+
+  targets := []string{}
+  for addr, _ := range changed {
+    switch strings.SplitN(addr, ".", 2)[0] {
+    case "output":
+      // Outputs are always refreshed
+    case "provider", "variable", "locals", "terraform":
+      PrintWarning("The %s block changed, cannot narrow down the targets", addr)
+      return nil, nil
+    default:
+      targets = append(targets, addr)
+    }
+  }
+
+  sort.Strings(targets)
+  return targets, nil
+}
+
+/**
+ * @brief      Adjusts the arguments of a `plan` or `apply` for the fast mode:
+ *             skips the refresh and targets only the changed components
+ */
+func (s *ProjectSandbox) ApplyFastMode(args []string) ([]string, error) {
+  parsed := ParseTerraformArgs(args)
+  if parsed.Command != "plan" && parsed.Command != "apply" {
+    return args, nil
+  }
+  if len(parsed.Positional) > 0 {
+    PrintInfo("Fast mode has no effect when applying a plan file")
+    return args, nil
+  }
+  userTargets := parsed.HasOption("target")
+  cmdIdx := parsed.CommandIndex
+
+  var targets []string
+  if !userTargets {
+    changed, err := s.getChangedTargets()
+    if err != nil {
+      return nil, err
+    }
+    // An untargeted run without a refresh would not catch anything, a normal
+    // run at least catches the drift
+    if changed != nil && len(changed) == 0 {
+      PrintInfo("Nothing changed since the last apply, running a normal %s with a refresh", parsed.Command)
+      return args, nil
+    }
+    targets = changed
+  }
+
+  PrintWarning("Fast mode: skipping the refresh and only targeting the changed components. This is UNSAFE for production!")
+  extra := []string{"-refresh=false", "-parallelism=20"}
+  for _, target := range targets {
+    PrintInfo("Targeting %s", target)
+    extra = append(extra, "-target="+target)
+  }
+
+  var ret []string
+  ret = append(ret, args[:cmdIdx+1]...)
+  ret = append(ret, extra...)
+  ret = append(ret, args[cmdIdx+1:]...)
+  return ret, nil
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path"
+  "sort"
+  "strings"
+  "text/template"
+)
+
+// Where the files of every generated group are tracked, in .wheels
+var fileGroupsFile string = "generated.json"
+
+/**
+ * A set of coordinated files produced by a generator (eg. the module, its
+ * outputs and its version constraints), managed as a whole
+ */
+type TerraformFileGroup struct {
+  Name  string
+  files map[string][]byte
+}
+
+func CreateTerraformFileGroup(name string) *TerraformFileGroup {
+  return &TerraformFileGroup{name, make(map[string][]byte)}
+}
+
+/**
+ * Adds a file to the group, replacing any previous one with the same name
+ */
+func (g *TerraformFileGroup) AddFile(name string, contents []byte) {
+  g.files[name] = contents
+}
+
+/**
+ * Returns the names of the files in the group, sorted
+ */
+func (g *TerraformFileGroup) GetFileNames() []string {
+  var names []string
+  for name := range g.files {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+/**
+ * @brief      Renders all the templates of the given set, sharing the same
+ *             context
+ *
+ * The templates are the `templates/<set>/<name>.tf.tmpl` assets, and each one
+ * becomes the `<prefix>-<name>.tf` file of the group. They can be customized
+ * like any other asset.
+ */
+func (g *TerraformFileGroup) AddTemplates(set string, prefix string, context interface{}) error {
+  files, err := RenderTemplates(set, context)
+  if err != nil {
+    return err
+  }
+  for name, content := range files {
+    g.AddFile(fmt.Sprintf("%s-%s", prefix, name), content)
+  }
+  return nil
+}
+
+/**
+ * Renders the `templates/<set>/<name>.tf.tmpl` assets with the given context,
+ * returning the contents by file name (eg. `<name>.tf`)
+ */
+func RenderTemplates(set string, context interface{}) (map[string][]byte, error) {
+  names, err := ListAssets("templates/" + set)
+  if err != nil {
+    return nil, err
+  }
+  if len(names) == 0 {
+    return nil, fmt.Errorf("There are no templates in the %s set", set)
+  }
+
+  files := make(map[string][]byte)
+  for _, name := range names {
+    if !strings.HasSuffix(name, ".tf.tmpl") {
+      continue
+    }
+    content, err := ReadAsset(path.Join("templates", set, name))
+    if err != nil {
+      return nil, err
+    }
+
+    tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+    if err != nil {
+      return nil, fmt.Errorf("Could not parse template %s/%s: %s", set, name, err.Error())
+    }
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, context); err != nil {
+      return nil, fmt.Errorf("Could not render template %s/%s: %s", set, name, err.Error())
+    }
+    files[strings.TrimSuffix(name, ".tmpl")] = out.Bytes()
+  }
+  return files, nil
+}
+
+/**
+ * Loads the files of every group generated in the project, by group name
+ */
+func (s *ProjectSandbox) LoadFileGroups() (map[string][]string, error) {
+  groups := make(map[string][]string)
+  groupsPath, err := s.GetWheelsPath(fileGroupsFile)
+  if err != nil {
+    return nil, err
+  }
+
+  content, err := ioutil.ReadFile(groupsPath)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return groups, nil
+    }
+    return nil, fmt.Errorf("Could not read the generated files: %s", err.Error())
+  }
+  if err := json.Unmarshal(content, &groups); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", groupsPath, err.Error())
+  }
+  return groups, nil
+}
+
+func (s *ProjectSandbox) saveFileGroups(groups map[string][]string) error {
+  groupsPath, err := s.GetWheelsPath(fileGroupsFile)
+  if err != nil {
+    return err
+  }
+  content, err := json.MarshalIndent(groups, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(groupsPath, content, 0644); err != nil {
+    return fmt.Errorf("Could not save the generated files: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * @brief      Writes all the files of the group, replacing a previous
+ *             generation of it
+ *
+ * Files of the previous generation that are no longer part of the group are
+ * removed. Files that exist but do not belong to the group are never
+ * overwritten.
+ */
+func (s *ProjectSandbox) WriteTerraformFileGroup(g *TerraformFileGroup) error {
+  groups, err := s.LoadFileGroups()
+  if err != nil {
+    return err
+  }
+
+  owned := make(map[string]bool)
+  for _, name := range groups[g.Name] {
+    owned[name] = true
+  }
+  for _, name := range g.GetFileNames() {
+    if s.HasFile(name) && !owned[name] {
+      return fmt.Errorf("The file %s already exists and was not generated by %s", name, g.Name)
+    }
+  }
+
+  for _, name := range g.GetFileNames() {
+    if err := s.WriteFormattedTerraformFile(name, g.files[name]); err != nil {
+      return err
+    }
+    if name == SecretVariablesFile {
+      // Only readable by the user, and never committed
+      if err := os.Chmod(s.GetFilePath(name), 0600); err != nil {
+        return fmt.Errorf("Could not restrict the permissions of %s: %s", name, err.Error())
+      }
+      if err := s.EnsureGitIgnored(name); err != nil {
+        return err
+      }
+    }
+    delete(owned, name)
+  }
+  for name := range owned {
+    PrintInfo("Removing %s, it's no longer part of %s", name, g.Name)
+    if err := os.Remove(s.GetFilePath(name)); err != nil && !os.IsNotExist(err) {
+      return fmt.Errorf("Could not remove %s: %s", name, err.Error())
+    }
+  }
+
+  groups[g.Name] = g.GetFileNames()
+  return s.saveFileGroups(groups)
+}
+
+/**
+ * Removes all the files of a generated group, returning their names
+ */
+func (s *ProjectSandbox) RemoveTerraformFileGroup(name string) ([]string, error) {
+  groups, err := s.LoadFileGroups()
+  if err != nil {
+    return nil, err
+  }
+  files, ok := groups[name]
+  if !ok {
+    return nil, fmt.Errorf("There are no files generated by %s", name)
+  }
+
+  for _, file := range files {
+    if err := os.Remove(s.GetFilePath(file)); err != nil && !os.IsNotExist(err) {
+      return nil, fmt.Errorf("Could not remove %s: %s", file, err.Error())
+    }
+  }
+
+  delete(groups, name)
+  return files, s.saveFileGroups(groups)
+}
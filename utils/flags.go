@@ -0,0 +1,211 @@
+package utils
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strings"
+)
+
+/**
+ * An option of terraform-wheels itself, that can be given anywhere in the
+ * command-line and it's removed before the arguments reach terraform
+ */
+type globalFlag struct {
+  name        string
+  hasValue    bool
+  description string
+  apply       func(value string)
+}
+
+var globalFlags []globalFlag = []globalFlag{
+  {"offline", false, "Do not download anything, use the mirror directory instead", func(value string) {
+    SetOfflineMode(true)
+  }},
+  {"fast", false, "Skip the refresh and only target the changed components (UNSAFE)", func(value string) {
+    SetFastMode(true)
+  }},
+  {"dcos-record", true, "Record the DC/OS API calls in the given directory", func(value string) {
+    SetDcosRecordDir(value)
+  }},
+  {"dcos-replay", true, "Replay the DC/OS API calls from the given directory", func(value string) {
+    SetDcosReplayDir(value)
+  }},
+  {"verbose", false, "Log the commands and the HTTP requests that are run, and how long they took", func(value string) {
+    SetVerboseMode(true)
+  }},
+  {"v", false, "Same as --verbose", func(value string) {
+    SetVerboseMode(true)
+  }},
+  {"log-commands", false, "Log only the commands that are run, with their environment, duration and exit code", func(value string) {
+    SetLogCommandsMode(true)
+  }},
+  {"quiet", false, "Only print the errors (the log of the project still has everything)", func(value string) {
+    SetQuietMode(true)
+  }},
+  {"color", true, "Use colors: always, never or auto (only on a terminal, unless NO_COLOR is set)", func(value string) {
+    if err := SetColorMode(value); err != nil {
+      FatalError(err)
+    }
+  }},
+  {"trace", true, "Record the timing of the operations as a Chrome trace in the given file", func(value string) {
+    SetTraceFile(value)
+  }},
+  {"fail-fast", false, "Interrupt terraform on the first fatal cloud error (eg. invalid AMI)", func(value string) {
+    SetFailFastMode(true)
+  }},
+  {"aws-profile", true, "Use the credentials of the given AWS profile (or the role it assumes)", func(value string) {
+    SetAWSProfile(value)
+  }},
+  {"ssh-key", true, "Use the given private key, or `agent` for the keys of the running ssh-agent (eg. a YubiKey)", func(value string) {
+    SetSSHKey(value)
+  }},
+  {"allow-unknown-instance-types", false, "Accept the instance types that are not known yet, with a warning", func(value string) {
+    SetAllowUnknownInstanceTypes(true)
+  }},
+  {"allow-protected-destroy", false, "Allow destroying the protected workspaces (eg. prod)", func(value string) {
+    SetAllowProtectedDestroy(true)
+  }},
+  {"target-masters", false, "Only plan or apply the masters of the cluster", func(value string) {
+    AddTargetGroup("masters")
+  }},
+  {"target-agents", false, "Only plan or apply the agents of the cluster (all their pools)", func(value string) {
+    AddTargetGroup("agents")
+  }},
+  {"only-services", false, "Only plan or apply the DC/OS services (eg. add-package)", func(value string) {
+    AddTargetGroup("services")
+  }},
+  {"skip-preflight", false, "Do not check the AWS quotas, the DC/OS installer and the AMIs before generating or applying", func(value string) {
+    SetSkipPreflight(true)
+  }},
+  {"no-hooks", false, "Do not run the hooks of .wheels.yaml (eg. after_apply)", func(value string) {
+    SetHooksDisabled(true)
+  }},
+  {"project-dir", true, "Use the project in the given directory instead of the current one", func(value string) {
+    SetProjectDir(value)
+  }},
+  {"force-unlock-wheels", false, "Use the project even if another run still holds its lock", func(value string) {
+    SetForceUnlockWheels(true)
+  }},
+  {"insecure", false, "Do not verify TLS certificates (for TLS-intercepting proxies)", func(value string) {
+    SetInsecureTLS(true)
+  }},
+}
+
+/**
+ * Returns the global flag that matches the given argument, and the value
+ * given to it with `--name=value`
+ */
+func findGlobalFlag(arg string) (*globalFlag, string, bool) {
+  if !strings.HasPrefix(arg, "-") {
+    return nil, "", false
+  }
+  name := strings.TrimLeft(arg, "-")
+  value := ""
+  if idx := strings.Index(name, "="); idx >= 0 {
+    value = name[idx+1:]
+    name = name[:idx]
+  }
+
+  for i, flag := range globalFlags {
+    if flag.name == name {
+      return &globalFlags[i], value, strings.Contains(arg, "=")
+    }
+  }
+  return nil, "", false
+}
+
+/**
+ * Processes the global flags in the given arguments and returns the
+ * remaining ones, that should be passed down to the command
+ */
+func ParseGlobalFlags(args []string) []string {
+  var ret []string
+  for i := 0; i < len(args); i++ {
+    if args[i] == "--" {
+      ret = append(ret, args[i:]...)
+      break
+    }
+
+    flag, value, hasValue := findGlobalFlag(args[i])
+    if flag == nil {
+      ret = append(ret, args[i])
+      continue
+    }
+
+    if flag.hasValue && !hasValue && i+1 < len(args) {
+      i++
+      value = args[i]
+    }
+    flag.apply(value)
+  }
+
+  return ret
+}
+
+/**
+ * Prints the usage of the global flags, as part of the help screen
+ */
+func PrintGlobalFlags() {
+  for _, flag := range globalFlags {
+    name := "--" + flag.name
+    if len(flag.name) == 1 {
+      name = "-" + flag.name
+    }
+    if flag.hasValue {
+      name += "=<value>"
+    }
+    Printf("    %-18s %s\n", name, flag.description)
+  }
+}
+
+/**
+ * Parses the options of a command, failing with a short error that points
+ * to its help screen on an unknown or invalid option
+ */
+func ParseCommandFlags(fSet *flag.FlagSet, args []string) error {
+  fSet.SetOutput(ioutil.Discard)
+  err := fSet.Parse(args)
+  fSet.SetOutput(nil)
+  if err != nil {
+    if name := strings.TrimPrefix(err.Error(), "flag provided but not defined: "); name != err.Error() {
+      if suggestions := suggestCommandFlags(fSet, name); len(suggestions) > 0 {
+        return WithExitCode(ExitConfigError, fmt.Errorf("Unknown option %s of %s, %s See `%s %s -help`", name, fSet.Name(), FormatSuggestions(suggestions), os.Args[0], fSet.Name()))
+      }
+    }
+    return WithExitCode(ExitConfigError, fmt.Errorf("%s, see `%s %s -help`", err.Error(), os.Args[0], fSet.Name()))
+  }
+  return nil
+}
+
+/**
+ * Returns the options of the command, and the global ones (that may have
+ * been mistyped), that are close to the given unknown option
+ */
+func suggestCommandFlags(fSet *flag.FlagSet, name string) []string {
+  var candidates []string
+  fSet.VisitAll(func(f *flag.Flag) {
+    candidates = append(candidates, "-"+f.Name)
+  })
+  for _, flag := range globalFlags {
+    if len(flag.name) > 1 {
+      candidates = append(candidates, "--"+flag.name)
+    }
+  }
+
+  // The dashes don't count as typos
+  bare := make(map[string]string)
+  var bareCandidates []string
+  for _, candidate := range candidates {
+    if _, ok := bare[strings.TrimLeft(candidate, "-")]; !ok {
+      bare[strings.TrimLeft(candidate, "-")] = candidate
+      bareCandidates = append(bareCandidates, strings.TrimLeft(candidate, "-"))
+    }
+  }
+  var suggestions []string
+  for _, suggestion := range SuggestValues(strings.TrimLeft(name, "-"), bareCandidates) {
+    suggestions = append(suggestions, bare[suggestion])
+  }
+  return suggestions
+}
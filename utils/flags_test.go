@@ -0,0 +1,56 @@
+package utils
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestParseGlobalFlagsKeepsCommandOptions(t *testing.T) {
+  defer SetProjectDir("")
+
+  tests := []struct {
+    name       string
+    args       []string
+    want       []string
+    projectDir string
+  }{
+    {"wheels-mirror -dir", []string{"wheels-mirror", "-dir", "/tmp/mirror"}, []string{"wheels-mirror", "-dir", "/tmp/mirror"}, ""},
+    {"wheels-dcos-mock -dir=", []string{"wheels-dcos-mock", "-dir=./recordings"}, []string{"wheels-dcos-mock", "-dir=./recordings"}, ""},
+    {"--project-dir", []string{"--project-dir", "clusters/staging", "wheels-mirror", "-dir", "/tmp/mirror"}, []string{"wheels-mirror", "-dir", "/tmp/mirror"}, "clusters/staging"},
+    {"--project-dir=", []string{"plan", "--project-dir=clusters/staging", "-out=plan.out"}, []string{"plan", "-out=plan.out"}, "clusters/staging"},
+    {"after --", []string{"tf", "--", "--project-dir=x"}, []string{"tf", "--", "--project-dir=x"}, ""},
+  }
+  for _, test := range tests {
+    SetProjectDir("")
+    got := ParseGlobalFlags(test.args)
+    if !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: got the arguments %q, want %q", test.name, got, test.want)
+    }
+    if projectDir != test.projectDir {
+      t.Errorf("%s: got the project directory %q, want %q", test.name, projectDir, test.projectDir)
+    }
+  }
+}
+
+func TestParseGlobalFlagsKeepsKubeconfigInsecure(t *testing.T) {
+  args := []string{"wheels-kubeconfig", "-insecure-skip-tls-verify", "dev"}
+  got := ParseGlobalFlags(args)
+  if !reflect.DeepEqual(got, args) {
+    t.Errorf("got the arguments %q, want %q", got, args)
+  }
+  if insecureTLS {
+    t.Errorf("the TLS verification was disabled for the whole process")
+  }
+}
+
+func TestParseGlobalFlagsLogCommands(t *testing.T) {
+  defer SetLogCommandsMode(false)
+
+  got := ParseGlobalFlags([]string{"--log-commands", "plan"})
+  if !reflect.DeepEqual(got, []string{"plan"}) {
+    t.Errorf("got the arguments %q", got)
+  }
+  if !IsLoggingCommands() {
+    t.Errorf("the commands are not logged")
+  }
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// The allowed values are only listed in the errors when there are few
+var maxListedEnumValues int = 10
+
+/**
+ * A flag that can be given multiple times, each time with one or more
+ * comma-separated values (eg. `-admin-ips=a,b -admin-ips=c`)
+ */
+type StringSlice []string
+
+func (s *StringSlice) String() string {
+  if s == nil {
+    return ""
+  }
+  return strings.Join(*s, ",")
+}
+
+func (s *StringSlice) Set(value string) error {
+  for _, item := range strings.Split(value, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      *s = append(*s, item)
+    }
+  }
+  return nil
+}
+
+/**
+ * A flag that can be given multiple times as key=value, the last value of a
+ * key winning
+ */
+type StringMap map[string]string
+
+func (m StringMap) String() string {
+  var keys []string
+  for key := range m {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+
+  var kv []string
+  for _, key := range keys {
+    kv = append(kv, fmt.Sprintf("%s=%s", key, m[key]))
+  }
+  return strings.Join(kv, ",")
+}
+
+func (m StringMap) Set(value string) error {
+  kv := strings.SplitN(value, "=", 2)
+  if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+    return fmt.Errorf("expected key=value, got '%s'", value)
+  }
+  m[strings.TrimSpace(kv[0])] = kv[1]
+  return nil
+}
+
+/**
+ * An integer flag that must be between Min and Max (included)
+ */
+type IntRange struct {
+  Value int
+  Min   int
+  Max   int
+}
+
+func CreateIntRange(value int, min int, max int) *IntRange {
+  return &IntRange{value, min, max}
+}
+
+func (r *IntRange) String() string {
+  if r == nil {
+    return ""
+  }
+  return strconv.Itoa(r.Value)
+}
+
+func (r *IntRange) Set(value string) error {
+  n, err := strconv.Atoi(value)
+  if err != nil {
+    return fmt.Errorf("expected a number, got '%s'", value)
+  }
+  if n < r.Min || n > r.Max {
+    return fmt.Errorf("%d is out of range, expected %d to %d", n, r.Min, r.Max)
+  }
+  r.Value = n
+  return nil
+}
+
+/**
+ * A string flag that only accepts the given values, suggesting the closest
+ * one when it's given an invalid value
+ */
+type EnumFlag struct {
+  Value   string
+  Allowed []string
+}
+
+func CreateEnumFlag(value string, allowed ...string) *EnumFlag {
+  return &EnumFlag{value, allowed}
+}
+
+func (e *EnumFlag) String() string {
+  if e == nil {
+    return ""
+  }
+  return e.Value
+}
+
+func (e *EnumFlag) Set(value string) error {
+  if err := CheckEnumValue(value, e.Allowed); err != nil {
+    return err
+  }
+  e.Value = value
+  return nil
+}
+
+/**
+ * Checks that the value is one of the allowed ones, and otherwise returns an
+ * error that suggests the closest one
+ */
+func CheckEnumValue(value string, allowed []string) error {
+  if containsString(allowed, value) {
+    return nil
+  }
+  if suggestion := SuggestValue(value, allowed); suggestion != "" {
+    return fmt.Errorf("did you mean '%s'?", suggestion)
+  } else if len(allowed) <= maxListedEnumValues {
+    return fmt.Errorf("expected %s", joinAlternatives(allowed))
+  }
+  return fmt.Errorf("not a known value")
+}
+
+/**
+ * Returns "a, b or c"
+ */
+func joinAlternatives(values []string) string {
+  if len(values) < 2 {
+    return strings.Join(values, "")
+  }
+  return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
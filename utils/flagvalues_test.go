@@ -0,0 +1,180 @@
+package utils
+
+import (
+  "flag"
+  "io/ioutil"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestCheckEnumValue(t *testing.T) {
+  tests := []struct {
+    value   string
+    allowed []string
+    err     string
+  }{
+    {"json", []string{"text", "json", "yaml"}, ""},
+    {"jsno", []string{"text", "json", "yaml"}, "did you mean 'json'?"},
+    {"xml", []string{"text", "json", "yaml"}, "expected text, json or yaml"},
+    {"JSON", []string{"text", "json"}, "did you mean 'json'?"},
+    {"", []string{"text", "json"}, "expected text or json"},
+    {"unknown", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}, "not a known value"},
+  }
+  for _, test := range tests {
+    err := CheckEnumValue(test.value, test.allowed)
+    if test.err == "" && err != nil {
+      t.Errorf("%q: unexpected error %s", test.value, err.Error())
+    } else if test.err != "" && (err == nil || err.Error() != test.err) {
+      t.Errorf("%q: got the error %v, want %q", test.value, err, test.err)
+    }
+  }
+}
+
+func TestEnumFlag(t *testing.T) {
+  fSet := flag.NewFlagSet("test", flag.ContinueOnError)
+  fSet.SetOutput(ioutil.Discard)
+  fFormat := CreateEnumFlag("text", "text", "json")
+  fSet.Var(fFormat, "format", "")
+
+  if err := fSet.Parse(nil); err != nil || fFormat.Value != "text" {
+    t.Errorf("got %q (%v), want the default text", fFormat.Value, err)
+  }
+  if err := fSet.Parse([]string{"-format=json"}); err != nil || fFormat.Value != "json" {
+    t.Errorf("got %q (%v), want json", fFormat.Value, err)
+  }
+  err := fSet.Parse([]string{"-format=jsn"})
+  if err == nil || !strings.Contains(err.Error(), "did you mean 'json'?") {
+    t.Errorf("got the error %v, want a suggestion", err)
+  }
+  if fFormat.Value != "json" {
+    t.Errorf("an invalid value changed the flag to %q", fFormat.Value)
+  }
+}
+
+func TestIntRange(t *testing.T) {
+  tests := []struct {
+    value string
+    want  int
+    err   string
+  }{
+    {"1", 1, ""},
+    {"5", 5, ""},
+    {"3", 3, ""},
+    {"0", 3, "0 is out of range, expected 1 to 5"},
+    {"6", 3, "6 is out of range, expected 1 to 5"},
+    {"three", 3, "expected a number, got 'three'"},
+  }
+  for _, test := range tests {
+    r := CreateIntRange(3, 1, 5)
+    err := r.Set(test.value)
+    if test.err == "" && err != nil {
+      t.Errorf("%q: unexpected error %s", test.value, err.Error())
+    } else if test.err != "" && (err == nil || err.Error() != test.err) {
+      t.Errorf("%q: got the error %v, want %q", test.value, err, test.err)
+    }
+    if r.Value != test.want {
+      t.Errorf("%q: got %d, want %d", test.value, r.Value, test.want)
+    }
+  }
+}
+
+func TestStringSlice(t *testing.T) {
+  var s StringSlice
+  for _, value := range []string{"a,b", " c ", "", "d,,e"} {
+    if err := s.Set(value); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if want := (StringSlice{"a", "b", "c", "d", "e"}); !reflect.DeepEqual(s, want) {
+    t.Errorf("got %q, want %q", s, want)
+  }
+  if s.String() != "a,b,c,d,e" {
+    t.Errorf("got %q", s.String())
+  }
+}
+
+func TestStringMap(t *testing.T) {
+  tests := []struct {
+    values []string
+    want   StringMap
+    err    string
+  }{
+    {[]string{"a=1", "b=2"}, StringMap{"a": "1", "b": "2"}, ""},
+    {[]string{"a=1", "a=2"}, StringMap{"a": "2"}, ""},
+    {[]string{"a=b=c"}, StringMap{"a": "b=c"}, ""},
+    {[]string{" a =1"}, StringMap{"a": "1"}, ""},
+    {[]string{"a="}, StringMap{"a": ""}, ""},
+    {[]string{"a"}, StringMap{}, "expected key=value, got 'a'"},
+    {[]string{"=1"}, StringMap{}, "expected key=value, got '=1'"},
+  }
+  for _, test := range tests {
+    m := make(StringMap)
+    var err error
+    for _, value := range test.values {
+      if err = m.Set(value); err != nil {
+        break
+      }
+    }
+    if test.err == "" && err != nil {
+      t.Errorf("%q: unexpected error %s", test.values, err.Error())
+    } else if test.err != "" && (err == nil || err.Error() != test.err) {
+      t.Errorf("%q: got the error %v, want %q", test.values, err, test.err)
+    }
+    if !reflect.DeepEqual(m, test.want) {
+      t.Errorf("%q: got %v, want %v", test.values, m, test.want)
+    }
+  }
+
+  if got := (StringMap{"b": "2", "a": "1"}).String(); got != "a=1,b=2" {
+    t.Errorf("got %q, the keys should be sorted", got)
+  }
+}
+
+func TestCheckAWSInstanceType(t *testing.T) {
+  tests := []struct {
+    value string
+    err   bool
+  }{
+    {"t2.medium", false},
+    {"m5.xlarge", false},
+    {"m7i.large", false},
+    // Not known, but they look like instance types
+    {"t2.meduim", true},
+    {"m8g.large", true},
+    {"u-6tb1.metal", true},
+    {"t2", true},
+    {"t2.", true},
+    {"M5.large", true},
+    {"m5 large", true},
+    {"", true},
+  }
+  for _, test := range tests {
+    err := CheckAWSInstanceType(test.value)
+    if (err != nil) != test.err {
+      t.Errorf("%q: got the error %v", test.value, err)
+    }
+  }
+
+  if err := CheckAWSInstanceType("t2.meduim"); err == nil || !strings.Contains(err.Error(), "did you mean 't2.medium'?") {
+    t.Errorf("got the error %v, want a suggestion", err)
+  }
+  SetAllowUnknownInstanceTypes(true)
+  for _, value := range []string{"t2.meduim", "m8g.large", "u-6tb1.metal"} {
+    if err := CheckAWSInstanceType(value); err != nil {
+      t.Errorf("%q: got the error %v with the unknown types allowed", value, err)
+    }
+  }
+  if err := CheckAWSInstanceType("t2"); err == nil {
+    t.Errorf("an invalid value is accepted with the unknown types allowed")
+  }
+  SetAllowUnknownInstanceTypes(false)
+
+  f := CreateAWSInstanceTypeFlag("t2.medium")
+  if err := f.Set("t2"); err == nil || f.Value != "t2.medium" {
+    t.Errorf("an invalid value changed the flag to %q (%v)", f.Value, err)
+  }
+  if err := f.Set("c6a.large"); err != nil || f.Value != "c6a.large" {
+    t.Errorf("got %q (%v), want c6a.large", f.Value, err)
+  }
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// What never belongs in version control: the local state, the caches, the
+// plans, the downloaded binaries and the secrets
+var gitIgnoredArtifacts []string = []string{
+  ".terraform/", ".wheels/*", "!.wheels/policies/", "terraform.tfstate", "terraform.tfstate.backup", "*.tfstate",
+  "plan.out", "*.tfplan", "crash.log", "/terraform", "/terraform.exe",
+  "*.pem", "*.old",
+}
+
+/**
+ * @brief      Adds the given patterns to the .gitignore of the project, if
+ *             they are not there already
+ *
+ * The file is created if it does not exist, so the project can be committed
+ * without leaking the files that contain secrets.
+ */
+func (s *ProjectSandbox) EnsureGitIgnored(patterns ...string) error {
+  path := s.GetFilePath(".gitignore")
+  content, err := ioutil.ReadFile(path)
+  if err != nil && !os.IsNotExist(err) {
+    return fmt.Errorf("Could not read .gitignore: %s", err.Error())
+  }
+
+  existing := make(map[string]bool)
+  for _, line := range strings.Split(string(content), "\n") {
+    existing[strings.TrimSpace(line)] = true
+  }
+
+  var missing []string
+  for _, pattern := range patterns {
+    if !existing[pattern] && !existing["/"+pattern] {
+      missing = append(missing, pattern)
+    }
+  }
+  if len(missing) == 0 {
+    return nil
+  }
+
+  text := string(content)
+  if text != "" && !strings.HasSuffix(text, "\n") {
+    text += "\n"
+  }
+  text += strings.Join(missing, "\n") + "\n"
+  if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+    return fmt.Errorf("Could not write .gitignore: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * Checks if the given file of the project in the given directory is listed
+ * in its .gitignore. Only the plain names and the `*.ext` patterns are
+ * recognized.
+ */
+func isGitIgnored(dir string, file string) bool {
+  content, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+  if err != nil {
+    return false
+  }
+  for _, line := range strings.Split(string(content), "\n") {
+    pattern := strings.TrimPrefix(strings.TrimSpace(line), "/")
+    if pattern == "" || strings.HasPrefix(pattern, "#") {
+      continue
+    }
+    if pattern == file || (strings.HasPrefix(pattern, "*") && strings.HasSuffix(file, pattern[1:])) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Returns the private keys in the project, that are recognized by their
+ * contents since they can have any name
+ */
+func (s *ProjectSandbox) findPrivateKeys() []string {
+  var keys []string
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return nil
+  }
+  for _, file := range files {
+    if file.IsDir() || file.Size() > 64*1024 {
+      continue
+    }
+    content, err := s.ReadFile(file.Name())
+    if err == nil && secretScanPatterns[len(secretScanPatterns)-1].re.Match(content) {
+      keys = append(keys, file.Name())
+    }
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+/**
+ * @brief      Prepares the project to be kept in version control, with a
+ *             .gitignore and a README (if there is none)
+ *
+ * It's called when the first terraform files are created, and before the
+ * first commit of wheels-git-init.
+ */
+func (s *ProjectSandbox) BootstrapRepository() error {
+  patterns := append(append([]string{}, gitIgnoredArtifacts...), SecretVariablesFile)
+  if err := s.EnsureGitIgnored(append(patterns, s.findPrivateKeys()...)...); err != nil {
+    return err
+  }
+
+  if s.HasFile("README.md") {
+    return nil
+  }
+  return s.WriteFile("README.md", []byte(s.generateReadme()))
+}
+
+/**
+ * Describes the project and how to use it, for the README
+ */
+func (s *ProjectSandbox) generateReadme() string {
+  lines := []string{
+    fmt.Sprintf("# %s", filepath.Base(s.baseDir)),
+    "",
+  }
+
+  mods := s.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/*")
+  if len(mods) > 0 {
+    mod := mods[0]
+    describe := func(field string, fallback string) string {
+      if v := s.ResolveTerraformValue(mod[field]); v != nil {
+        return fmt.Sprintf("%v", v)
+      }
+      return fallback
+    }
+    lines = append(lines,
+      fmt.Sprintf("A DC/OS %s (%s) cluster, deployed with [terraform-wheels](https://github.com/mesosphere-incubator/terraform-wheels):", describe("dcos_version", "latest"), describe("dcos_variant", "open")),
+      "",
+      fmt.Sprintf("- Masters: %s", describe("num_masters", "default")),
+      fmt.Sprintf("- Private agents: %s", describe("num_private_agents", "default")),
+      fmt.Sprintf("- Public agents: %s", describe("num_public_agents", "default")),
+      "",
+    )
+  } else {
+    lines = append(lines, "A terraform project, managed with [terraform-wheels](https://github.com/mesosphere-incubator/terraform-wheels).", "")
+  }
+
+  lines = append(lines,
+    "## Usage",
+    "",
+    "```sh",
+    "terraform-wheels plan -out=plan.out   # See what is going to change",
+    "terraform-wheels apply plan.out       # Apply the changes",
+    "terraform-wheels destroy              # Remove everything",
+    "```",
+    "",
+    "The state, the plans and the secrets are not part of the repository (see `.gitignore`).",
+  )
+  return strings.Join(lines, "\n") + "\n"
+}
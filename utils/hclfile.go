@@ -0,0 +1,391 @@
+package utils
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "github.com/hashicorp/hcl/hcl/ast"
+  "github.com/hashicorp/hcl/hcl/parser"
+  "github.com/hashicorp/hcl/hcl/printer"
+)
+
+/**
+ * A terraform file that is edited through its syntax tree. Only the parts
+ * that are changed are rewritten, so the comments and the layout of the
+ * rest of the file are kept.
+ */
+type TerraformFile struct {
+  content []byte
+  root    *ast.File
+}
+
+/**
+ * A block of a terraform file, eg. `module "dcos" { }`, found by its keys
+ */
+type TerraformBlock struct {
+  file *TerraformFile
+  keys []string
+}
+
+/**
+ * Parses the contents of a terraform file
+ */
+func ParseTerraformFile(content []byte) (*TerraformFile, error) {
+  f := &TerraformFile{}
+  if err := f.update(content); err != nil {
+    return nil, err
+  }
+  return f, nil
+}
+
+func (f *TerraformFile) update(content []byte) error {
+  root, err := parser.Parse(content)
+  if err != nil {
+    return fmt.Errorf("Could not parse the terraform file: %s", err.Error())
+  }
+  f.content = content
+  f.root = root
+  return nil
+}
+
+/**
+ * Returns the contents of the file, formatted
+ */
+func (f *TerraformFile) Bytes() ([]byte, error) {
+  content, err := FormatTerraform(f.content)
+  if err != nil {
+    return nil, fmt.Errorf("Could not format the terraform file: %s", err.Error())
+  }
+  return content, nil
+}
+
+/**
+ * Formats the terraform file like `terraform fmt`. The printer loses the
+ * blank lines between the items that come after a free-standing comment,
+ * so they are put back where the original file has them.
+ */
+func FormatTerraform(content []byte) ([]byte, error) {
+  formatted, err := printer.Format(content)
+  if err != nil {
+    return nil, err
+  }
+  before, err := itemStartLines(content)
+  if err != nil {
+    return nil, err
+  }
+  after, err := itemStartLines(formatted)
+  if err != nil || len(before) != len(after) {
+    return formatted, nil
+  }
+
+  origLines := strings.Split(string(content), "\n")
+  lines := strings.Split(string(formatted), "\n")
+  isBlank := func(lines []string, line int) bool {
+    // The lines are numbered from 1, this checks the one before
+    return line > 1 && strings.TrimSpace(lines[line-2]) == ""
+  }
+  // From the end, so the line numbers stay valid
+  for i := len(after) - 1; i >= 0; i-- {
+    if isBlank(origLines, before[i]) && !isBlank(lines, after[i]) {
+      lines = append(lines[:after[i]-1], append([]string{""}, lines[after[i]-1:]...)...)
+    }
+  }
+  return []byte(strings.Join(lines, "\n")), nil
+}
+
+/**
+ * Returns the first line of every item of the file, with its comments
+ */
+func itemStartLines(content []byte) ([]int, error) {
+  root, err := parser.Parse(content)
+  if err != nil {
+    return nil, err
+  }
+  var ret []int
+  ast.Walk(root.Node, func(n ast.Node) (ast.Node, bool) {
+    if item, ok := n.(*ast.ObjectItem); ok {
+      line := item.Pos().Line
+      if item.LeadComment != nil {
+        line = item.LeadComment.Pos().Line
+      }
+      ret = append(ret, line)
+    }
+    return n, true
+  })
+  return ret, nil
+}
+
+/**
+ * Returns the keys of the top-level blocks, eg. ["module", "dcos"]
+ */
+func (f *TerraformFile) Blocks() [][]string {
+  var ret [][]string
+  for _, item := range f.root.Node.(*ast.ObjectList).Items {
+    if _, ok := item.Val.(*ast.ObjectType); ok {
+      ret = append(ret, itemKeys(item))
+    }
+  }
+  return ret
+}
+
+/**
+ * Returns the top-level block with the given keys, or nil if there is none
+ */
+func (f *TerraformFile) Block(keys ...string) *TerraformBlock {
+  b := &TerraformBlock{f, keys}
+  if b.object() == nil {
+    return nil
+  }
+  return b
+}
+
+/**
+ * Returns the last top-level block that starts at the given line or before
+ */
+func (f *TerraformFile) blockAtLine(line int) *TerraformBlock {
+  var ret *TerraformBlock
+  for _, item := range f.root.Node.(*ast.ObjectList).Items {
+    if _, ok := item.Val.(*ast.ObjectType); ok && item.Keys[0].Pos().Line <= line {
+      ret = &TerraformBlock{f, itemKeys(item)}
+    }
+  }
+  return ret
+}
+
+/**
+ * Returns the keys of an item, without their quotes
+ */
+func itemKeys(item *ast.ObjectItem) []string {
+  var keys []string
+  for _, key := range item.Keys {
+    text := key.Token.Text
+    if unquoted, err := strconv.Unquote(text); err == nil {
+      text = unquoted
+    }
+    keys = append(keys, text)
+  }
+  return keys
+}
+
+/**
+ * Returns the offset right after the value of an item
+ */
+func itemEnd(item *ast.ObjectItem) int {
+  switch v := item.Val.(type) {
+  case *ast.ObjectType:
+    return v.Rbrace.Offset + 1
+  case *ast.ListType:
+    return v.Rbrack.Offset + 1
+  case *ast.LiteralType:
+    // Heredocs include their trailing new line
+    return v.Token.Pos.Offset + len(strings.TrimRight(v.Token.Text, "\n"))
+  }
+  return item.Val.Pos().Offset
+}
+
+/**
+ * Returns the syntax tree of the block, that changes with every edit
+ */
+func (b *TerraformBlock) object() *ast.ObjectType {
+  for _, item := range b.file.root.Node.(*ast.ObjectList).Items {
+    obj, ok := item.Val.(*ast.ObjectType)
+    if !ok {
+      continue
+    }
+    keys := itemKeys(item)
+    if len(keys) != len(b.keys) {
+      continue
+    }
+    found := true
+    for i := range keys {
+      found = found && keys[i] == b.keys[i]
+    }
+    if found {
+      return obj
+    }
+  }
+  return nil
+}
+
+/**
+ * Returns the items of the block with the given name
+ */
+func (b *TerraformBlock) items(name string) []*ast.ObjectItem {
+  var ret []*ast.ObjectItem
+  obj := b.object()
+  if obj == nil {
+    return nil
+  }
+  for _, item := range obj.List.Items {
+    if keys := itemKeys(item); len(keys) == 1 && keys[0] == name {
+      ret = append(ret, item)
+    }
+  }
+  return ret
+}
+
+/**
+ * Returns the names of the attributes of the block, in their order
+ */
+func (b *TerraformBlock) Attributes() []string {
+  var ret []string
+  seen := make(map[string]bool)
+  if obj := b.object(); obj != nil {
+    for _, item := range obj.List.Items {
+      if keys := itemKeys(item); len(keys) == 1 && !seen[keys[0]] {
+        ret = append(ret, keys[0])
+        seen[keys[0]] = true
+      }
+    }
+  }
+  return ret
+}
+
+/**
+ * Returns the value of the attribute in HCL syntax, eg. `"t2.medium"`. When
+ * the attribute is given more than once, the last value wins.
+ */
+func (b *TerraformBlock) GetAttribute(name string) (string, bool) {
+  items := b.items(name)
+  if len(items) == 0 {
+    return "", false
+  }
+  item := items[len(items)-1]
+  return string(b.file.content[item.Val.Pos().Offset:itemEnd(item)]), true
+}
+
+/**
+ * Returns the value of the attribute when it's a literal, eg. `t2.medium`
+ * for `"t2.medium"`, and `false` if it's anything else
+ */
+func (b *TerraformBlock) GetLiteral(name string) (string, bool) {
+  items := b.items(name)
+  if len(items) == 0 {
+    return "", false
+  }
+  lit, ok := items[len(items)-1].Val.(*ast.LiteralType)
+  if !ok {
+    return "", false
+  }
+  if s, ok := lit.Token.Value().(string); ok {
+    return s, true
+  }
+  return lit.Token.Text, true
+}
+
+/**
+ * Returns the items of the attribute when it's a list of literals
+ */
+func (b *TerraformBlock) GetList(name string) ([]string, bool) {
+  items := b.items(name)
+  if len(items) == 0 {
+    return nil, false
+  }
+  list, ok := items[len(items)-1].Val.(*ast.ListType)
+  if !ok {
+    return nil, false
+  }
+  ret := []string{}
+  for _, node := range list.List {
+    lit, ok := node.(*ast.LiteralType)
+    if !ok {
+      return nil, false
+    }
+    ret = append(ret, fmt.Sprint(lit.Token.Value()))
+  }
+  return ret, true
+}
+
+/**
+ * Returns the entries of the attribute when it's a map of literals
+ */
+func (b *TerraformBlock) GetMap(name string) (map[string]string, bool) {
+  items := b.items(name)
+  if len(items) == 0 {
+    return nil, false
+  }
+  obj, ok := items[len(items)-1].Val.(*ast.ObjectType)
+  if !ok {
+    return nil, false
+  }
+  ret := make(map[string]string)
+  for _, item := range obj.List.Items {
+    lit, ok := item.Val.(*ast.LiteralType)
+    keys := itemKeys(item)
+    if !ok || len(keys) != 1 {
+      return nil, false
+    }
+    ret[keys[0]] = fmt.Sprint(lit.Token.Value())
+  }
+  return ret, true
+}
+
+/**
+ * Sets the value of the attribute, given in HCL syntax. An existing
+ * attribute is replaced where it's first defined, and its other
+ * definitions removed. A new one is added at the end of the block.
+ */
+func (b *TerraformBlock) SetAttribute(name string, value string) error {
+  items := b.items(name)
+  if len(items) == 0 {
+    return b.AppendLines([]string{fmt.Sprintf("%s = %s", name, value)})
+  }
+
+  for i := len(items) - 1; i > 0; i-- {
+    if err := b.RemoveAttribute(name); err != nil {
+      return err
+    }
+  }
+  item := b.items(name)[0]
+  return b.splice(item.Keys[0].Pos().Offset, itemEnd(item), fmt.Sprintf("%s = %s", item.Keys[0].Token.Text, value))
+}
+
+/**
+ * Removes the last definition of the attribute, with its line
+ */
+func (b *TerraformBlock) RemoveAttribute(name string) error {
+  items := b.items(name)
+  if len(items) == 0 {
+    return nil
+  }
+  item := items[len(items)-1]
+  start, end := item.Keys[0].Pos().Offset, itemEnd(item)
+  if item.LeadComment != nil {
+    start = item.LeadComment.Pos().Offset
+  }
+
+  // Take the whole lines when nothing else is on them
+  content := b.file.content
+  lineStart := strings.LastIndex(string(content[:start]), "\n") + 1
+  if strings.TrimSpace(string(content[lineStart:start])) == "" {
+    start = lineStart
+  }
+  if lineEnd := strings.Index(string(content[end:]), "\n"); lineEnd >= 0 {
+    rest := strings.TrimSpace(string(content[end : end+lineEnd]))
+    if rest == "" || strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "//") {
+      end += lineEnd + 1
+    }
+  }
+  return b.splice(start, end, "")
+}
+
+/**
+ * Adds the given lines at the end of the block
+ */
+func (b *TerraformBlock) AppendLines(lines []string) error {
+  obj := b.object()
+  if obj == nil {
+    return fmt.Errorf("Could not find %s in the terraform file", strings.Join(b.keys, " "))
+  }
+  end := obj.Rbrace.Offset
+  return b.splice(end, end, "\n"+strings.Join(lines, "\n")+"\n")
+}
+
+func (b *TerraformBlock) splice(start int, end int, text string) error {
+  var content []byte
+  content = append(content, b.file.content[:start]...)
+  content = append(content, []byte(text)...)
+  content = append(content, b.file.content[end:]...)
+  return b.file.update(content)
+}
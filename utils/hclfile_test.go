@@ -0,0 +1,111 @@
+package utils
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestFormatTerraform(t *testing.T) {
+  tests := []struct {
+    name string
+    in   []string
+    want []string
+  }{
+    {
+      name: "aligned",
+      in:   []string{`module "dcos" {`, `  num_masters = 1`, `  cluster_name = "demo"`, `}`},
+      want: []string{`module "dcos" {`, `  num_masters  = 1`, `  cluster_name = "demo"`, `}`, ``},
+    },
+    {
+      name: "blank lines after a free-standing comment",
+      in: []string{
+        `module "dcos" {`,
+        `  num_masters = 1`,
+        ``,
+        `  # dcos_variant = "ee"`,
+        ``,
+        `  instance_type = "t2.medium"`,
+        ``,
+        `  # Agents created outside of this module`,
+        `  additional_private_agent_ips = ["10.0.0.1"]`,
+        ``,
+        `  tags = {`,
+        `    "owner" = "me"`,
+        `  }`,
+        `}`,
+      },
+      want: []string{
+        `module "dcos" {`,
+        `  num_masters = 1`,
+        ``,
+        `  # dcos_variant = "ee"`,
+        ``,
+        `  instance_type = "t2.medium"`,
+        ``,
+        `  # Agents created outside of this module`,
+        `  additional_private_agent_ips = ["10.0.0.1"]`,
+        ``,
+        `  tags = {`,
+        `    "owner" = "me"`,
+        `  }`,
+        `}`,
+        ``,
+      },
+    },
+  }
+  for _, test := range tests {
+    got, err := FormatTerraform([]byte(strings.Join(test.in, "\n")))
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err.Error())
+    } else if want := strings.Join(test.want, "\n"); string(got) != want {
+      t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+    }
+  }
+}
+
+func TestTerraformBlockAppendLines(t *testing.T) {
+  in := strings.Join([]string{
+    `module "dcos" {`,
+    `  cluster_name = "a}b"`,
+    `  # not the end }`,
+    `  custom_data = "${format("{%s}", var.x)}"`,
+    `}`,
+    ``,
+    `output "ips" {`,
+    `  value = "}"`,
+    `}`,
+  }, "\n")
+  tf, err := ParseTerraformFile([]byte(in))
+  if err != nil {
+    t.Fatal(err)
+  }
+  block := tf.Block("module", "dcos")
+  if block == nil {
+    t.Fatal("module.dcos not found")
+  }
+  if err := block.AppendLines([]string{`  num_masters = 3`}); err != nil {
+    t.Fatal(err)
+  }
+  got, err := tf.Bytes()
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // The braces in the strings and the comments do not end the block
+  edited, err := ParseTerraformFile(got)
+  if err != nil {
+    t.Fatalf("%s\n%s", err.Error(), got)
+  }
+  if value, _ := edited.Block("module", "dcos").GetLiteral("num_masters"); value != "3" {
+    t.Errorf("num_masters is not in module.dcos:\n%s", got)
+  }
+  if value, _ := edited.Block("module", "dcos").GetLiteral("cluster_name"); value != "a}b" {
+    t.Errorf("got the cluster_name %q", value)
+  }
+  if value, _ := edited.Block("output", "ips").GetLiteral("value"); value != "}" {
+    t.Errorf("got the output value %q", value)
+  }
+  if tf.Block("module", "missing") != nil {
+    t.Errorf("found a block that does not exist")
+  }
+}
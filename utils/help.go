@@ -0,0 +1,50 @@
+package utils
+
+import (
+  "fmt"
+
+  "gopkg.in/yaml.v3"
+)
+
+/**
+ * A topic of `help <topic>`, with the commands it covers
+ */
+type HelpTopic struct {
+  Name        string   `yaml:"name"`
+  Description string   `yaml:"description"`
+  Text        string   `yaml:"text,omitempty"`
+  Commands    []string `yaml:"commands"`
+}
+
+/**
+ * Returns the help topics, in the order they are listed
+ */
+func ReadHelpTopics() ([]HelpTopic, error) {
+  content, err := ReadAsset("help/topics.yaml")
+  if err != nil {
+    return nil, err
+  }
+
+  var topics []HelpTopic
+  if err := yaml.Unmarshal(content, &topics); err != nil {
+    return nil, fmt.Errorf("Could not parse the help topics: %s", err.Error())
+  }
+  return topics, nil
+}
+
+/**
+ * Returns the examples of the given command, as the arguments that follow
+ * the name of the binary
+ */
+func ReadCommandExamples(name string) ([]string, error) {
+  content, err := ReadAsset("help/examples.yaml")
+  if err != nil {
+    return nil, err
+  }
+
+  examples := make(map[string][]string)
+  if err := yaml.Unmarshal(content, &examples); err != nil {
+    return nil, fmt.Errorf("Could not parse the examples of the commands: %s", err.Error())
+  }
+  return examples[name], nil
+}
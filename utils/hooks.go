@@ -0,0 +1,164 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "os/exec"
+  "regexp"
+  "runtime"
+  "sort"
+  "strings"
+  "syscall"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+var hooksDisabled bool = false
+
+/**
+ * Disables the hooks of .wheels.yaml, eg. to destroy a broken cluster
+ */
+func SetHooksDisabled(disabled bool) {
+  hooksDisabled = disabled
+}
+
+/**
+ * Runs the hooks of .wheels.yaml for the given event (eg. after_apply), one
+ * after the other, and stops at the first one that fails. They are run in
+ * the project directory, with the environment of terraform and the outputs
+ * of the project as TF_OUTPUT_<name> variables.
+ */
+func (s *ProjectSandbox) RunHooks(tf *TerraformWrapper, event string, hooks []string) error {
+  if len(hooks) == 0 {
+    return nil
+  }
+  if hooksDisabled {
+    PrintWarning("Not running the %s hooks (--no-hooks)", event)
+    return nil
+  }
+  defer StartSpan("hooks", event).End()
+
+  env := append(tf.getEnv(), "WHEELS_HOOK="+event, "WHEELS_WORKSPACE="+s.GetWorkspace())
+  outputs, err := tf.GetOutputs()
+  if err != nil {
+    PrintWarning("Could not give the outputs to the %s hooks: %s", event, err.Error())
+  } else {
+    env = append(env, getOutputsEnv(outputs)...)
+  }
+
+  for _, hook := range hooks {
+    PrintInfo("Running the %s hook %s", event, Bold(hook))
+    code, err := shellExecuteWithEnv(s.baseDir, env, hook)
+    if err != nil {
+      return fmt.Errorf("Could not run the %s hook %s: %s", event, hook, err.Error())
+    }
+    if code != 0 {
+      return fmt.Errorf("The %s hook %s failed with exit code %d", event, hook, code)
+    }
+  }
+  return nil
+}
+
+/**
+ * Returns the outputs as environment variables, eg. TF_OUTPUT_MASTERS_IPS for
+ * `masters-ips`. The lists are separated by spaces and the maps are given
+ * as JSON. The address of the cluster is also given as DCOS_URL.
+ */
+func getOutputsEnv(outputs map[string]TerraformOutput) []string {
+  var names []string
+  for name := range outputs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var env []string
+  nonAlnum := regexp.MustCompile(`[^A-Z0-9]+`)
+  for _, name := range names {
+    var value string
+    switch v := outputs[name].Value.(type) {
+    case string:
+      value = v
+    case []interface{}:
+      var items []string
+      for _, item := range v {
+        items = append(items, fmt.Sprint(item))
+      }
+      value = strings.Join(items, " ")
+    default:
+      content, err := json.Marshal(v)
+      if err != nil {
+        continue
+      }
+      value = string(content)
+    }
+    env = append(env, fmt.Sprintf("TF_OUTPUT_%s=%s", nonAlnum.ReplaceAllString(strings.ToUpper(name), "_"), value))
+  }
+
+  if address, ok := outputs["cluster-address"].Value.(string); ok && address != "" {
+    env = append(env, "DCOS_URL="+GetClusterURL(address))
+  }
+  return env
+}
+
+/**
+ * Runs the given command line with the shell of the system, in the given
+ * directory and with the given environment, and pipes stdout/stderr
+ */
+func shellExecuteWithEnv(workDir string, env []string, cmdline string) (code int, err error) {
+  var cmd *exec.Cmd
+  if runtime.GOOS == "windows" {
+    cmd = exec.Command("cmd", "/C", cmdline)
+  } else {
+    cmd = exec.Command("sh", "-c", cmdline)
+  }
+  log := LogCommand(env, cmd.Args[0], cmd.Args[1:])
+  defer func() { log.Done(code, err) }()
+  cmd.Env = updateEnv(os.Environ(), env)
+  cmd.Dir = workDir
+  cmd.Stdin = os.Stdin
+
+  stdout, err := cmd.StdoutPipe()
+  if err != nil {
+    return 0, fmt.Errorf("Unable to open StdOut Pipe: %s", err.Error())
+  }
+  stderr, err := cmd.StderrPipe()
+  if err != nil {
+    return 0, fmt.Errorf("Unable to open StdErr Pipe: %s", err.Error())
+  }
+  if err := cmd.Start(); err != nil {
+    return 0, err
+  }
+  defer setRunningCommand(cmd, nil)()
+
+  // Async readers of the Stdout/Err, that never show the secrets
+  done := make(chan struct{}, 2)
+  go func() {
+    writer := NewRedactingWriter(colorableStdout)
+    _, _ = io.Copy(writer, stdout)
+    _ = writer.Close()
+    done <- struct{}{}
+  }()
+  go func() {
+    writer := NewRedactingWriter(colorableStderr)
+    _, _ = io.Copy(writer, stderr)
+    _ = writer.Close()
+    done <- struct{}{}
+  }()
+  <-done
+  <-done
+
+  if err := cmd.Wait(); err != nil {
+    // Get exit code on non-zero exits
+    if exiterr, ok := err.(*exec.ExitError); ok {
+      if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+        return status.ExitStatus(), nil
+      }
+    } else {
+      return 0, err
+    }
+  }
+
+  return 0, nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strings"
+  "time"
+)
+
+// Where the path of the exported identity is kept, so it can be refreshed
+var identityExportFile string = "identity-export"
+
+/**
+ * The endpoints for authenticating against the cluster. DC/OS issues its
+ * own tokens, that can be verified with the keys of the JWKS endpoint.
+ */
+type ClusterOIDC struct {
+  Issuer        string `json:"issuer"`
+  LoginURL      string `json:"login_url"`
+  TokenEndpoint string `json:"token_endpoint"`
+  JwksURI       string `json:"jwks_uri"`
+}
+
+/**
+ * What the external automation (SSO, DNS) needs to know about a cluster
+ */
+type ClusterIdentity struct {
+  ClusterID      string      `json:"cluster_id"`
+  AdminRouterURL string      `json:"admin_router_url"`
+  MastersIPs     []string    `json:"masters_ips"`
+  CACertificate  string      `json:"ca_certificate,omitempty"`
+  OIDC           ClusterOIDC `json:"oidc"`
+  GeneratedAt    time.Time   `json:"generated_at"`
+}
+
+/**
+ * @brief      Collects the identity of the cluster behind the given address
+ *
+ * The cluster ID comes from the metadata of the admin router. The CA
+ * certificate is only available on the clusters that have one, so it's left
+ * empty when it cannot be fetched.
+ */
+func FetchClusterIdentity(address string, masters []string) (*ClusterIdentity, error) {
+  client := CreateDcosClient(address, nil)
+  clusterUrl := GetClusterURL(address)
+
+  var metadata struct {
+    ClusterID string `json:"CLUSTER_ID"`
+  }
+  if err := client.Request("GET", "/metadata", nil, nil, &metadata); err != nil {
+    return nil, fmt.Errorf("Could not read the cluster metadata: %s", err.Error())
+  }
+
+  ca, err := client.GetText("/ca/dcos-ca.crt")
+  if err != nil {
+    PrintWarning("Could not read the CA certificate of the cluster: %s", err.Error())
+  }
+
+  return &ClusterIdentity{
+    ClusterID:      metadata.ClusterID,
+    AdminRouterURL: clusterUrl,
+    MastersIPs:     masters,
+    CACertificate:  strings.TrimSpace(ca),
+    OIDC: ClusterOIDC{
+      Issuer:        clusterUrl,
+      LoginURL:      clusterUrl + "/login",
+      TokenEndpoint: clusterUrl + "/acs/api/v1/auth/login",
+      JwksURI:       clusterUrl + "/acs/api/v1/auth/jwks",
+    },
+    GeneratedAt: time.Now().UTC(),
+  }, nil
+}
+
+/**
+ * Checks if the identity was generated for the cluster with the given
+ * address and masters
+ */
+func (i *ClusterIdentity) Matches(address string, masters []string) bool {
+  return i.AdminRouterURL == GetClusterURL(address) && strings.Join(i.MastersIPs, ",") == strings.Join(masters, ",")
+}
+
+/**
+ * Reads a previously exported identity
+ */
+func ReadClusterIdentity(path string) (*ClusterIdentity, error) {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  var identity ClusterIdentity
+  if err := json.Unmarshal(content, &identity); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", path, err.Error())
+  }
+  return &identity, nil
+}
+
+/**
+ * Writes the identity to the given file, or to the standard output with "-"
+ */
+func (i *ClusterIdentity) WriteTo(path string) error {
+  content, err := json.MarshalIndent(i, "", "  ")
+  if err != nil {
+    return err
+  }
+  if path == "-" {
+    fmt.Println(string(content))
+    return nil
+  }
+  if err := ioutil.WriteFile(path, append(content, '\n'), 0644); err != nil {
+    return fmt.Errorf("Could not write %s: %s", path, err.Error())
+  }
+  return nil
+}
+
+/**
+ * Remembers where the identity of the cluster was exported, so it's kept
+ * up to date after every apply
+ */
+func (s *ProjectSandbox) SetIdentityExportPath(path string) error {
+  fPath, err := s.GetWheelsPath(identityExportFile)
+  if err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(fPath, []byte(path+"\n"), 0644); err != nil {
+    return fmt.Errorf("Could not save the export path: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * Returns where the identity of the cluster was exported, or an empty
+ * string if it never was
+ */
+func (s *ProjectSandbox) GetIdentityExportPath() string {
+  fPath, err := s.GetWheelsPath(identityExportFile)
+  if err != nil {
+    return ""
+  }
+  content, err := ioutil.ReadFile(fPath)
+  if err != nil {
+    if !os.IsNotExist(err) {
+      PrintWarning("Could not read %s: %s", fPath, err.Error())
+    }
+    return ""
+  }
+  return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+  "errors"
+  "os"
+  "os/exec"
+  "os/signal"
+  "path/filepath"
+  "sync"
+  "syscall"
+
+  "golang.org/x/crypto/ssh/terminal"
+)
+
+var errInterrupted error = errors.New("Interrupted")
+
+var interruptMutex sync.Mutex
+var interruptCount int = 0
+var runningCommand *exec.Cmd = nil
+var runningKilled chan struct{} = nil
+
+var cleanupMutex sync.Mutex
+var cleanupFuncs map[int]func() = make(map[int]func())
+var nextCleanupId int = 0
+
+/**
+ * Handles Ctrl+C (and SIGTERM) for the whole run. While a command is running,
+ * the first one is forwarded to it once, so terraform can stop gracefully and
+ * release the state lock, and the hooks still run after it. The second one
+ * kills the command. Otherwise, we clean up and exit right away.
+ */
+func HandleInterrupts() {
+  sigs := make(chan os.Signal, 2)
+  signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+  go func() {
+    for sig := range sigs {
+      handleInterrupt(sig)
+    }
+  }()
+}
+
+func handleInterrupt(sig os.Signal) {
+  interruptMutex.Lock()
+  interruptCount += 1
+  count := interruptCount
+  cmd := runningCommand
+  killed := runningKilled
+  interruptMutex.Unlock()
+
+  if cmd == nil || cmd.Process == nil || count > 2 {
+    colorableStderr.Write([]byte("\n"))
+    PrintError(errInterrupted)
+    Exit(ExitInterrupted)
+    return
+  }
+
+  name := filepath.Base(cmd.Path)
+  if count == 1 {
+    PrintWarning("Interrupting %s, waiting for it to stop gracefully (press Ctrl+C again to kill it)", name)
+    // The terminal already sent Ctrl+C to all the processes in the foreground
+    if sig == os.Interrupt && terminal.IsTerminal(int(os.Stdin.Fd())) {
+      return
+    }
+    if err := cmd.Process.Signal(sig); err != nil && err != os.ErrProcessDone {
+      PrintWarning("Could not interrupt %s: %s", name, err.Error())
+    }
+    return
+  }
+
+  PrintWarning("Killing %s", name)
+  if err := cmd.Process.Kill(); err != nil && err != os.ErrProcessDone {
+    PrintWarning("Could not kill %s: %s", name, err.Error())
+  }
+  if killed != nil {
+    close(killed)
+  }
+}
+
+/**
+ * Returns true if the run was interrupted with Ctrl+C
+ */
+func IsInterrupted() bool {
+  interruptMutex.Lock()
+  defer interruptMutex.Unlock()
+  return interruptCount > 0
+}
+
+/**
+ * Makes the given command receive the interruptions, until it exits. The
+ * given channel (if not nil) is closed when the command is killed.
+ */
+func setRunningCommand(cmd *exec.Cmd, killed chan struct{}) func() {
+  interruptMutex.Lock()
+  previous, previousKilled := runningCommand, runningKilled
+  runningCommand, runningKilled = cmd, killed
+  interruptMutex.Unlock()
+
+  return func() {
+    interruptMutex.Lock()
+    runningCommand, runningKilled = previous, previousKilled
+    interruptMutex.Unlock()
+  }
+}
+
+/**
+ * Registers something to clean up if we exit early (eg. on Ctrl+C or on a
+ * fatal error). Call the returned function once it's cleaned up normally.
+ */
+func RegisterCleanup(cleanup func()) func() {
+  cleanupMutex.Lock()
+  defer cleanupMutex.Unlock()
+  id := nextCleanupId
+  nextCleanupId += 1
+  cleanupFuncs[id] = cleanup
+
+  return func() {
+    cleanupMutex.Lock()
+    defer cleanupMutex.Unlock()
+    delete(cleanupFuncs, id)
+  }
+}
+
+/**
+ * Runs the cleanups that are still registered
+ */
+func runCleanups() {
+  cleanupMutex.Lock()
+  funcs := cleanupFuncs
+  cleanupFuncs = make(map[int]func())
+  cleanupMutex.Unlock()
+
+  for _, cleanup := range funcs {
+    cleanup()
+  }
+}
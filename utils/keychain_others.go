@@ -0,0 +1,128 @@
+// +build !windows
+
+package utils
+
+import (
+  "encoding/hex"
+  "fmt"
+  "os"
+  "os/exec"
+  "runtime"
+  "strconv"
+  "strings"
+)
+
+var keychainService string = "terraform-wheels"
+
+/**
+ * The macOS keychain, through the `security` tool
+ */
+type macKeychain struct {
+  binary string
+}
+
+func (k *macKeychain) Get(key string) (string, error) {
+  code, sout, _, err := ExecuteAndCollect([]string{}, k.binary,
+    "find-generic-password", "-s", keychainService, "-a", key, "-w")
+  if err != nil {
+    return "", fmt.Errorf("Could not query the keychain: %s", err.Error())
+  }
+  if code != 0 {
+    // Item not found
+    return "", nil
+  }
+  return strings.TrimSpace(sout), nil
+}
+
+func (k *macKeychain) Set(key string, value string) error {
+  RegisterSecret(value)
+
+  // The command is given on stdin to the interactive mode of `security`, so
+  // the secret does not show up in `ps`. It's hex-encoded (-X), so it needs
+  // no quoting.
+  command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+    strconv.Quote(keychainService), strconv.Quote(key), hex.EncodeToString([]byte(value)))
+  cmd := exec.Command(k.binary, "-i")
+  cmd.Env = os.Environ()
+  cmd.Stdin = strings.NewReader(command)
+  log := LogCommand(nil, k.binary, cmd.Args[1:])
+  out, err := cmd.CombinedOutput()
+  log.Exited(cmd, err)
+  if err != nil {
+    return fmt.Errorf("Could not update the keychain: %s: %s", err.Error(), strings.TrimSpace(string(out)))
+  }
+  // The interactive mode does not fail when a command does, the errors are
+  // only printed like "security: SecKeychainItemCreateFromContent: ..."
+  if strings.Contains(string(out), "security: ") {
+    return fmt.Errorf("Could not update the keychain: %s", strings.TrimSpace(string(out)))
+  }
+  return nil
+}
+
+func (k *macKeychain) Delete(key string) error {
+  _, _, _, err := ExecuteAndCollect([]string{}, k.binary,
+    "delete-generic-password", "-s", keychainService, "-a", key)
+  return err
+}
+
+/**
+ * The freedesktop secret service (gnome-keyring, kwallet), through the
+ * `secret-tool` utility
+ */
+type secretServiceKeychain struct {
+  binary string
+}
+
+func (k *secretServiceKeychain) Get(key string) (string, error) {
+  code, sout, _, err := ExecuteAndCollect([]string{}, k.binary,
+    "lookup", "service", keychainService, "key", key)
+  if err != nil {
+    return "", fmt.Errorf("Could not query the secret service: %s", err.Error())
+  }
+  if code != 0 {
+    return "", nil
+  }
+  return strings.TrimSuffix(sout, "\n"), nil
+}
+
+func (k *secretServiceKeychain) Set(key string, value string) error {
+  // The secret is passed through stdin, so it does not show up in `ps`
+  cmd := exec.Command(k.binary, "store", "--label", keychainService+": "+key,
+    "service", keychainService, "key", key)
+  cmd.Env = os.Environ()
+  cmd.Stdin = strings.NewReader(value)
+  log := LogCommand(nil, k.binary, cmd.Args[1:])
+  out, err := cmd.CombinedOutput()
+  log.Exited(cmd, err)
+  if err != nil {
+    return fmt.Errorf("Could not update the secret service: %s: %s", err.Error(), strings.TrimSpace(string(out)))
+  }
+  return nil
+}
+
+func (k *secretServiceKeychain) Delete(key string) error {
+  _, _, _, err := ExecuteAndCollect([]string{}, k.binary,
+    "clear", "service", keychainService, "key", key)
+  return err
+}
+
+/**
+ * Returns the OS keychain, or nil if there is none available
+ */
+func newPlatformKeychain() CredentialStore {
+  if runtime.GOOS == "darwin" {
+    if path, err := exec.LookPath("security"); err == nil {
+      return &macKeychain{path}
+    }
+  } else if runtime.GOOS == "linux" {
+    // The secret service is only reachable within a desktop session
+    if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+      return nil
+    }
+    if path, err := exec.LookPath("secret-tool"); err == nil {
+      return &secretServiceKeychain{path}
+    }
+  }
+
+  return nil
+}
@@ -0,0 +1,73 @@
+// +build windows
+
+package utils
+
+import (
+  "fmt"
+  "path/filepath"
+  "syscall"
+  "unsafe"
+
+  "golang.org/x/sys/windows"
+)
+
+var (
+  crypt32                = syscall.NewLazyDLL("crypt32.dll")
+  procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+  procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+)
+
+type dataBlob struct {
+  cbData uint32
+  pbData *byte
+}
+
+func newDataBlob(d []byte) *dataBlob {
+  if len(d) == 0 {
+    return &dataBlob{}
+  }
+  return &dataBlob{uint32(len(d)), &d[0]}
+}
+
+func (b *dataBlob) bytes() []byte {
+  d := make([]byte, b.cbData)
+  copy(d, (*[1 << 30]byte)(unsafe.Pointer(b.pbData))[:b.cbData:b.cbData])
+  return d
+}
+
+/**
+ * Encrypts the secrets with DPAPI, using the credentials of the current user
+ */
+type dpapiCipher struct {
+}
+
+func (c *dpapiCipher) Seal(plain []byte) ([]byte, error) {
+  var out dataBlob
+  r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(plain))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+  if r == 0 {
+    return nil, fmt.Errorf("CryptProtectData failed: %s", err.Error())
+  }
+  defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.pbData)))
+  return out.bytes(), nil
+}
+
+func (c *dpapiCipher) Open(sealed []byte) ([]byte, error) {
+  var out dataBlob
+  r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(sealed))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+  if r == 0 {
+    return nil, fmt.Errorf("CryptUnprotectData failed: %s", err.Error())
+  }
+  defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.pbData)))
+  return out.bytes(), nil
+}
+
+/**
+ * Returns a DPAPI-protected store in the user's terraform-wheels directory
+ */
+func newPlatformKeychain() CredentialStore {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return nil
+  }
+  return &fileCredentialStore{filepath.Join(home, "keychain.dat"), &dpapiCipher{}}
+}
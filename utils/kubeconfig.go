@@ -0,0 +1,110 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/base64"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+
+  "gopkg.in/yaml.v3"
+)
+
+/**
+ * The access to a Kubernetes cluster, as a named context of a kubeconfig
+ */
+type KubeconfigEntry struct {
+  Name     string
+  Server   string
+  CA       []byte
+  Token    string
+  Insecure bool
+}
+
+/**
+ * Returns the kubeconfig kubectl uses, the first one of KUBECONFIG or
+ * ~/.kube/config
+ */
+func GetDefaultKubeconfigPath() (string, error) {
+  if v := os.Getenv("KUBECONFIG"); v != "" {
+    return filepath.SplitList(v)[0], nil
+  }
+  home, err := os.UserHomeDir()
+  if err != nil {
+    return "", fmt.Errorf("Could not find the home directory: %s", err.Error())
+  }
+  return filepath.Join(home, ".kube", "config"), nil
+}
+
+/**
+ * Adds the cluster, user and context of the entry to the given kubeconfig,
+ * replacing the ones with the same name and keeping everything else
+ */
+func MergeKubeconfig(path string, entry KubeconfigEntry, setCurrent bool) error {
+  config := map[string]interface{}{
+    "apiVersion": "v1",
+    "kind":       "Config",
+  }
+  content, err := ioutil.ReadFile(path)
+  if err != nil && !os.IsNotExist(err) {
+    return fmt.Errorf("Could not read %s: %s", path, err.Error())
+  }
+  if len(strings.TrimSpace(string(content))) > 0 {
+    if err := yaml.Unmarshal(content, &config); err != nil {
+      return fmt.Errorf("Could not parse %s: %s", path, err.Error())
+    }
+  }
+
+  cluster := map[string]interface{}{
+    "server": entry.Server,
+  }
+  if entry.Insecure {
+    cluster["insecure-skip-tls-verify"] = true
+  } else if len(entry.CA) > 0 {
+    cluster["certificate-authority-data"] = base64.StdEncoding.EncodeToString(entry.CA)
+  }
+  setKubeconfigItem(config, "clusters", "cluster", entry.Name, cluster)
+  setKubeconfigItem(config, "users", "user", entry.Name, map[string]interface{}{
+    "token": entry.Token,
+  })
+  setKubeconfigItem(config, "contexts", "context", entry.Name, map[string]interface{}{
+    "cluster": entry.Name,
+    "user":    entry.Name,
+  })
+  if setCurrent {
+    config["current-context"] = entry.Name
+  }
+
+  var buf bytes.Buffer
+  encoder := yaml.NewEncoder(&buf)
+  encoder.SetIndent(2)
+  if err := encoder.Encode(config); err != nil {
+    return fmt.Errorf("Could not encode the kubeconfig: %s", err.Error())
+  }
+  if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+    return fmt.Errorf("Could not create %s: %s", filepath.Dir(path), err.Error())
+  }
+  // It contains the token
+  if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+    return fmt.Errorf("Could not write %s: %s", path, err.Error())
+  }
+  return os.Chmod(path, 0600)
+}
+
+func setKubeconfigItem(config map[string]interface{}, list string, field string, name string, value map[string]interface{}) {
+  item := map[string]interface{}{
+    "name": name,
+    field:  value,
+  }
+
+  items, _ := config[list].([]interface{})
+  for i, existing := range items {
+    if m, ok := existing.(map[string]interface{}); ok && m["name"] == name {
+      items[i] = item
+      return
+    }
+  }
+  config[list] = append(items, item)
+}
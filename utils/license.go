@@ -0,0 +1,60 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+)
+
+// The terraform variable that receives the license kept by wheels-license
+var DcosLicenseVariable string = "dcos_license_key_contents"
+
+var dcosLicenseKey string = "dcos-license"
+
+/**
+ * Returns the DC/OS Enterprise license of the user, or an empty string if
+ * none was set
+ */
+func GetDcosLicense() (string, error) {
+  store, err := GetUserCredentialStore()
+  if err != nil {
+    return "", err
+  }
+  return store.Get(dcosLicenseKey)
+}
+
+/**
+ * Keeps the DC/OS Enterprise license of the user in the secure store
+ */
+func SetDcosLicense(license string) error {
+  license = strings.TrimSpace(license)
+  if license == "" {
+    return fmt.Errorf("The license is empty")
+  }
+
+  store, err := GetUserCredentialStore()
+  if err != nil {
+    return err
+  }
+  return store.Set(dcosLicenseKey, license)
+}
+
+/**
+ * Removes the DC/OS Enterprise license of the user from the secure store
+ */
+func DeleteDcosLicense() error {
+  store, err := GetUserCredentialStore()
+  if err != nil {
+    return err
+  }
+  return store.Delete(dcosLicenseKey)
+}
+
+/**
+ * Hides most of a secret, so it can be recognized without being revealed
+ */
+func MaskSecret(secret string) string {
+  if len(secret) <= 8 {
+    return strings.Repeat("*", len(secret))
+  }
+  return secret[:4] + strings.Repeat("*", len(secret)-8) + secret[len(secret)-4:]
+}
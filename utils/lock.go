@@ -0,0 +1,55 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+)
+
+type FileLock struct {
+  path string
+  file *os.File
+}
+
+/**
+ * Acquires an exclusive, cross-process lock on the given file, blocking until
+ * it becomes available. The lock is automatically released by the operating
+ * system if the process exits without releasing it.
+ */
+func AcquireFileLock(path string) (*FileLock, error) {
+  if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+    return nil, fmt.Errorf("could not create lock directory: %s", err.Error())
+  }
+
+  f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+  if err != nil {
+    return nil, fmt.Errorf("could not open lock file %s: %s", path, err.Error())
+  }
+
+  // Try first without blocking, so we can let the user know why we are waiting
+  err = lockFile(f, false)
+  if err == errLockBusy {
+    PrintInfo("Waiting for another process to release %s", path)
+    err = lockFile(f, true)
+  }
+  if err != nil {
+    f.Close()
+    return nil, fmt.Errorf("could not lock %s: %s", path, err.Error())
+  }
+
+  return &FileLock{path, f}, nil
+}
+
+/**
+ * Releases the lock
+ */
+func (l *FileLock) Release() error {
+  if l.file == nil {
+    return nil
+  }
+
+  err := unlockFile(l.file)
+  l.file.Close()
+  l.file = nil
+  return err
+}
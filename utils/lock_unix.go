@@ -0,0 +1,38 @@
+// +build !windows
+
+package utils
+
+import (
+  "fmt"
+  "os"
+  "syscall"
+)
+
+var errLockBusy = fmt.Errorf("lock is held by another process")
+
+func lockFile(f *os.File, blocking bool) error {
+  how := syscall.LOCK_EX
+  if !blocking {
+    how |= syscall.LOCK_NB
+  }
+
+  err := syscall.Flock(int(f.Fd()), how)
+  if err == syscall.EWOULDBLOCK {
+    return errLockBusy
+  }
+  return err
+}
+
+func unlockFile(f *os.File) error {
+  return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func isProcessRunning(pid int) bool {
+  proc, err := os.FindProcess(pid)
+  if err != nil {
+    return false
+  }
+  // It exists but it's not ours with EPERM
+  err = proc.Signal(syscall.Signal(0))
+  return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,48 @@
+// +build windows
+
+package utils
+
+import (
+  "fmt"
+  "os"
+
+  "golang.org/x/sys/windows"
+)
+
+var errLockBusy = fmt.Errorf("lock is held by another process")
+
+func lockFile(f *os.File, blocking bool) error {
+  var flags uint32 = windows.LOCKFILE_EXCLUSIVE_LOCK
+  if !blocking {
+    flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+  }
+
+  ol := new(windows.Overlapped)
+  err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol)
+  if err == windows.ERROR_LOCK_VIOLATION {
+    return errLockBusy
+  }
+  return err
+}
+
+func unlockFile(f *os.File) error {
+  ol := new(windows.Overlapped)
+  return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
+
+// The exit code of the processes that did not exit yet
+const stillActive = 259
+
+func isProcessRunning(pid int) bool {
+  h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+  if err != nil {
+    return err == windows.ERROR_ACCESS_DENIED
+  }
+  defer windows.CloseHandle(h)
+
+  var code uint32
+  if err := windows.GetExitCodeProcess(h, &code); err != nil {
+    return true
+  }
+  return code == stillActive
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "strings"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+type LogLevel int
+
+const (
+  LogDebug LogLevel = iota
+  LogInfo
+  LogWarn
+  LogError
+)
+
+var logLevelNames []string = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// The log of terraform-wheels itself, in the .wheels directory
+var sandboxLogName string = "wheels.log"
+
+// The log is started over (keeping the previous one) when it grows bigger
+var maxSandboxLogSize int64 = 1024 * 1024
+
+var quietMode bool = false
+var sandboxLog io.Writer = nil
+
+/**
+ * Only prints the errors, with `--quiet`
+ */
+func SetQuietMode(quiet bool) {
+  quietMode = quiet
+}
+
+/**
+ * Checks if only the errors are printed, either because of `--quiet` or the
+ * TERRAFORM_WHEELS_QUIET environment variable
+ */
+func IsQuiet() bool {
+  if !quietMode {
+    v := os.Getenv("TERRAFORM_WHEELS_QUIET")
+    quietMode = v != "" && v != "0" && v != "false"
+  }
+  return quietMode
+}
+
+/**
+ * Starts writing all the messages of terraform-wheels and its plugins, of all
+ * levels, in the .wheels/wheels.log file of the project
+ */
+func (s *ProjectSandbox) OpenLog() error {
+  path, err := s.GetWheelsPath(sandboxLogName)
+  if err != nil {
+    return err
+  }
+  if stat, err := os.Stat(path); err == nil && stat.Size() > maxSandboxLogSize {
+    os.Rename(path, path+".1")
+  }
+
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+  if err != nil {
+    return fmt.Errorf("Could not open the log %s: %s", path, err.Error())
+  }
+  sandboxLog = &syncWriter{w: file}
+  writeSandboxLog("", LogDebug, "Running "+formatCommandLine(os.Args[0], os.Args[1:]))
+  return nil
+}
+
+func writeSandboxLog(name string, level LogLevel, message string) {
+  if sandboxLog == nil {
+    return
+  }
+  message = ansiColorPattern.ReplaceAllString(Redact(message), "")
+  if name != "" {
+    message = "[" + name + "] " + message
+  }
+  for _, line := range strings.Split(strings.TrimRight(message, "\n"), "\n") {
+    fmt.Fprintf(sandboxLog, "%s %-5s %s\n", time.Now().Format(time.RFC3339), logLevelNames[level], line)
+  }
+}
+
+/**
+ * Writes the message in the log of the project and prints it, unless it's
+ * filtered out by `--quiet` (everything but the errors) or `--verbose` (the
+ * debug messages are only printed with it)
+ */
+func logMessage(name string, level LogLevel, message string) {
+  writeSandboxLog(name, level, message)
+  if (level < LogError && IsQuiet()) || (level == LogDebug && !IsVerbose()) {
+    return
+  }
+
+  switch level {
+  case LogDebug:
+    colorableStderr.Write([]byte(Redact(fmt.Sprintf("%s %s\n", Faint("Debug:"), message))))
+  case LogInfo:
+    colorableStdout.Write([]byte(Redact(fmt.Sprintf("%s %s\n", Cyan("Info: "), message))))
+  case LogWarn:
+    colorableStdout.Write([]byte(Redact(fmt.Sprintf("%s %s\n", Bold(Yellow("Warn: ")), message))))
+  case LogError:
+    colorableStderr.Write([]byte(Redact(fmt.Sprintf("%s %s\n", Red("Error:"), message))))
+  }
+}
+
+/**
+ * A leveled logger, that tells which plugin the messages come from in the log
+ * of the project. The nil logger logs for terraform-wheels itself.
+ */
+type Logger struct {
+  name string
+}
+
+func CreateLogger(name string) *Logger {
+  return &Logger{name}
+}
+
+func (l *Logger) Log(level LogLevel, format string, a ...interface{}) {
+  name := ""
+  if l != nil {
+    name = l.name
+  }
+  logMessage(name, level, fmt.Sprintf(format, a...))
+}
+
+func (l *Logger) Debug(format string, a ...interface{}) {
+  l.Log(LogDebug, format, a...)
+}
+
+func (l *Logger) Info(format string, a ...interface{}) {
+  l.Log(LogInfo, format, a...)
+}
+
+func (l *Logger) Warn(format string, a ...interface{}) {
+  l.Log(LogWarn, format, a...)
+}
+
+func (l *Logger) Error(format string, a ...interface{}) {
+  l.Log(LogError, format, a...)
+}
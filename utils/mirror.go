@@ -0,0 +1,262 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "runtime"
+  "sort"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+)
+
+// Where terraform v0.11 finds the provider versions and their downloads
+var providerRegistryURL string = "https://registry.terraform.io/v1/providers/-"
+
+// The plugin protocol spoken by terraform v0.11
+var providerProtocolVersion string = "4"
+
+/**
+ * A provider plugin in the mirror directory
+ */
+type MirroredProvider struct {
+  Name       string
+  Version    string
+  Platform   string
+  Downloaded bool
+}
+
+type providerVersionsResponse struct {
+  Versions []struct {
+    Version   string   `json:"version"`
+    Protocols []string `json:"protocols"`
+    Platforms []struct {
+      OS   string `json:"os"`
+      Arch string `json:"arch"`
+    } `json:"platforms"`
+  } `json:"versions"`
+}
+
+type providerDownloadResponse struct {
+  Filename    string `json:"filename"`
+  DownloadURL string `json:"download_url"`
+  Shasum      string `json:"shasum"`
+}
+
+var pessimisticConstraintPattern *regexp.Regexp = regexp.MustCompile(`^~>\s*([0-9]+)\.([0-9]+)$`)
+
+/**
+ * Returns the platform of the running binary, as used in the plugin
+ * directories (eg. `linux_amd64`)
+ */
+func GetCurrentPlatform() string {
+  return fmt.Sprintf("%s_%s", runtime.GOOS, runtime.GOARCH)
+}
+
+/**
+ * Returns the directory with the providers of the mirror for the given
+ * platform
+ */
+func GetMirrorPluginDir(mirrorDir string, platform string) string {
+  return filepath.Join(mirrorDir, "plugins", platform)
+}
+
+/**
+ * @brief      Converts the terraform version constraints to a semver one
+ *
+ * Terraform reads `~> 1.2` as `>= 1.2, < 2.0`, while for semver it's
+ * `>= 1.2, < 1.3`, so that form is expanded.
+ */
+func parseProviderConstraints(constraints []string) (*semver.Constraints, error) {
+  var parts []string
+  for _, c := range constraints {
+    for _, part := range strings.Split(c, ",") {
+      part = strings.TrimSpace(part)
+      if m := pessimisticConstraintPattern.FindStringSubmatch(part); m != nil {
+        major, _ := semver.NewVersion(m[1])
+        part = fmt.Sprintf(">= %s.%s, < %s", m[1], m[2], major.IncMajor().String())
+      }
+      if part != "" {
+        parts = append(parts, part)
+      }
+    }
+  }
+  if len(parts) == 0 {
+    parts = append(parts, "*")
+  }
+  return semver.NewConstraint(strings.Join(parts, ", "))
+}
+
+/**
+ * @brief      Returns the most recent version of the provider that matches
+ *             the constraints, speaks the protocol of terraform v0.11 and
+ *             is built for all the given platforms
+ */
+func ResolveProviderVersion(name string, constraints []string, platforms []string) (string, error) {
+  cons, err := parseProviderConstraints(constraints)
+  if err != nil {
+    return "", fmt.Errorf("Could not parse the version constraints of provider %s: %s", name, err.Error())
+  }
+
+  buf, err := Download(fmt.Sprintf("%s/%s/versions", providerRegistryURL, name), WithDefaults).EventuallyReadAll()
+  if err != nil {
+    return "", fmt.Errorf("Could not list the versions of provider %s: %s", name, err.Error())
+  }
+  var resp providerVersionsResponse
+  if err := json.Unmarshal(buf, &resp); err != nil {
+    return "", fmt.Errorf("Could not parse the versions of provider %s: %s", name, err.Error())
+  }
+
+  var candidates []*semver.Version
+  for _, v := range resp.Versions {
+    ver, err := semver.NewVersion(v.Version)
+    if err != nil || ver.Prerelease() != "" || !cons.Check(ver) {
+      continue
+    }
+
+    compatible := false
+    for _, proto := range v.Protocols {
+      if strings.Split(proto, ".")[0] == providerProtocolVersion {
+        compatible = true
+      }
+    }
+
+    built := make(map[string]bool)
+    for _, p := range v.Platforms {
+      built[fmt.Sprintf("%s_%s", p.OS, p.Arch)] = true
+    }
+    for _, platform := range platforms {
+      if !built[platform] {
+        compatible = false
+      }
+    }
+
+    if compatible {
+      candidates = append(candidates, ver)
+    }
+  }
+  if len(candidates) == 0 {
+    return "", fmt.Errorf("There is no version of provider %s compatible with terraform v%sx matching '%s' for %s", name, RequiredTerraformVersionPrefix, strings.Join(constraints, ", "), strings.Join(platforms, ", "))
+  }
+
+  sort.Slice(candidates, func(i, j int) bool {
+    return candidates[i].GreaterThan(candidates[j])
+  })
+  return candidates[0].Original(), nil
+}
+
+/**
+ * Returns the binaries of the given provider version in the plugin directory
+ */
+func findProviderBinaries(pluginDir string, name string, version string) []string {
+  files, _ := filepath.Glob(filepath.Join(pluginDir, fmt.Sprintf("terraform-provider-%s_v%s_x*", name, version)))
+  return files
+}
+
+/**
+ * @brief      Downloads the given provider version in the mirror directory,
+ *             unless it's already there
+ *
+ * The archive is extracted in a temporary directory, and moved into place
+ * only when its checksum is verified.
+ */
+func MirrorProvider(mirrorDir string, name string, version string, platform string) (MirroredProvider, error) {
+  ret := MirroredProvider{name, version, platform, false}
+  pluginDir := GetMirrorPluginDir(mirrorDir, platform)
+  if len(findProviderBinaries(pluginDir, name, version)) > 0 {
+    return ret, nil
+  }
+
+  osArch := strings.SplitN(platform, "_", 2)
+  if len(osArch) != 2 {
+    return ret, fmt.Errorf("Invalid platform '%s', expecting <os>_<arch>", platform)
+  }
+  buf, err := Download(fmt.Sprintf("%s/%s/%s/download/%s/%s", providerRegistryURL, name, version, osArch[0], osArch[1]), WithDefaults).EventuallyReadAll()
+  if err != nil {
+    return ret, fmt.Errorf("Could not find the download of provider %s v%s for %s: %s", name, version, platform, err.Error())
+  }
+  var dl providerDownloadResponse
+  if err := json.Unmarshal(buf, &dl); err != nil {
+    return ret, fmt.Errorf("Could not parse the download of provider %s v%s: %s", name, version, err.Error())
+  }
+
+  if err := os.MkdirAll(pluginDir, 0755); err != nil {
+    return ret, fmt.Errorf("Could not create %s: %s", pluginDir, err.Error())
+  }
+  tmpDir, err := ioutil.TempDir(pluginDir, ".download-")
+  if err != nil {
+    return ret, fmt.Errorf("Unable to create download directory: %s", err.Error())
+  }
+  defer os.RemoveAll(tmpDir)
+
+  err = Download(dl.DownloadURL, InParallel).
+    AndShowProgress(fmt.Sprintf("Downloading %s", dl.Filename)).
+    AndValidateChecksum(dl.Shasum).
+    EventuallyUnzipTo(tmpDir, 0)
+  if err != nil {
+    return ret, fmt.Errorf("Could not download provider %s v%s: %s", name, version, err.Error())
+  }
+
+  files, err := ioutil.ReadDir(tmpDir)
+  if err != nil {
+    return ret, err
+  }
+  for _, file := range files {
+    if err := os.Rename(filepath.Join(tmpDir, file.Name()), filepath.Join(pluginDir, file.Name())); err != nil {
+      return ret, fmt.Errorf("Could not move %s to the mirror: %s", file.Name(), err.Error())
+    }
+  }
+  if len(findProviderBinaries(pluginDir, name, version)) == 0 {
+    return ret, fmt.Errorf("The archive of provider %s v%s does not contain a terraform v%sx plugin", name, version, RequiredTerraformVersionPrefix)
+  }
+
+  ret.Downloaded = true
+  return ret, nil
+}
+
+/**
+ * Returns the terraform CLI configuration that uses the mirror directory
+ */
+func GetMirrorCLIConfigFile(mirrorDir string) string {
+  return filepath.Join(mirrorDir, "terraformrc")
+}
+
+/**
+ * @brief      Writes the terraform CLI configuration that makes terraform
+ *             take the providers from the mirror directory
+ *
+ * The providers are kept with the layout of a plugin cache, so `init` only
+ * downloads the ones that are missing from the mirror.
+ */
+func WriteMirrorCLIConfig(mirrorDir string) (string, error) {
+  fPath := GetMirrorCLIConfigFile(mirrorDir)
+  content := strings.Join([]string{
+    "# Generated by terraform-wheels (wheels-mirror), use it with:",
+    fmt.Sprintf("#   export TF_CLI_CONFIG_FILE=%s", fPath),
+    fmt.Sprintf(`plugin_cache_dir = "%s"`, filepath.ToSlash(filepath.Join(mirrorDir, "plugins"))),
+    "",
+  }, "\n")
+  if err := ioutil.WriteFile(fPath, []byte(content), 0644); err != nil {
+    return "", fmt.Errorf("Could not write %s: %s", fPath, err.Error())
+  }
+  return fPath, nil
+}
+
+/**
+ * Returns the plugin directory of the mirror, if it was populated with
+ * `wheels-mirror`, or an empty string otherwise
+ */
+func getMirrorPluginCache() string {
+  dir, err := GetMirrorDir()
+  if err != nil {
+    return ""
+  }
+  if _, err := os.Stat(GetMirrorCLIConfigFile(dir)); err != nil {
+    return ""
+  }
+  return filepath.Join(dir, "plugins")
+}
@@ -0,0 +1,274 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/Masterminds/semver/v3"
+)
+
+/**
+ * A dcos-terraform module used by the project, and how its version is
+ * pinned: with a `version` constraint (registry modules) or a `ref` in the
+ * source (git modules)
+ */
+type ModuleReference struct {
+  Name       string
+  File       string
+  Source     string
+  Repository string
+  Pin        string
+  PinKind    string
+}
+
+/**
+ * A release of a module, with its changelog
+ */
+type ModuleRelease struct {
+  Tag       string
+  Version   *semver.Version
+  Changelog string
+}
+
+var moduleBlockPattern *regexp.Regexp = regexp.MustCompile(`^\s*module\s+"([^"]+)"\s*\{`)
+var moduleSourcePattern *regexp.Regexp = regexp.MustCompile(`^\s*source\s*=\s*"([^"]*)"`)
+var moduleVersionPattern *regexp.Regexp = regexp.MustCompile(`^(\s*version\s*=\s*)"([^"]*)"`)
+var moduleGitRepoPattern *regexp.Regexp = regexp.MustCompile(`github\.com[/:](dcos-terraform/[A-Za-z0-9_.-]+?)(\.git)?([/?]|$)`)
+var moduleRefPattern *regexp.Regexp = regexp.MustCompile(`([?&]ref=)([^&"]+)`)
+
+/**
+ * Returns the GitHub repository of a dcos-terraform module source, or an
+ * empty string if it's not one
+ */
+func getModuleRepository(source string) string {
+  if m := moduleGitRepoPattern.FindStringSubmatch(source); m != nil {
+    return m[1]
+  }
+
+  // Registry sources are <namespace>/<name>/<provider>
+  parts := strings.Split(strings.TrimPrefix(source, "registry.terraform.io/"), "/")
+  if len(parts) == 3 && parts[0] == "dcos-terraform" {
+    return fmt.Sprintf("dcos-terraform/terraform-%s-%s", parts[2], parts[1])
+  }
+  return ""
+}
+
+/**
+ * Calls the given function with the lines of every module block in the
+ * terraform files of the project, with their index in the file
+ */
+func (s *ProjectSandbox) visitModuleBlocks(visit func(file string, lines []string, name string, start int, end int)) error {
+  files, err := filepath.Glob(filepath.Join(s.baseDir, "*.tf"))
+  if err != nil {
+    return err
+  }
+  sort.Strings(files)
+
+  for _, file := range files {
+    content, err := ioutil.ReadFile(file)
+    if err != nil {
+      return fmt.Errorf("Could not read %s: %s", file, err.Error())
+    }
+    lines := strings.Split(string(content), "\n")
+    for i := 0; i < len(lines); i++ {
+      m := moduleBlockPattern.FindStringSubmatch(lines[i])
+      if m == nil {
+        continue
+      }
+      depth := 0
+      end := i
+      for ; end < len(lines); end++ {
+        depth += strings.Count(lines[end], "{") - strings.Count(lines[end], "}")
+        if depth <= 0 {
+          break
+        }
+      }
+      visit(filepath.Base(file), lines, m[1], i, end)
+      i = end
+    }
+  }
+  return nil
+}
+
+/**
+ * @brief      Returns the dcos-terraform modules used by the project
+ */
+func (s *ProjectSandbox) ListDcosModules() ([]ModuleReference, error) {
+  var modules []ModuleReference
+  err := s.visitModuleBlocks(func(file string, lines []string, name string, start int, end int) {
+    ref := ModuleReference{Name: name, File: file}
+    for _, line := range lines[start:end] {
+      if m := moduleSourcePattern.FindStringSubmatch(line); m != nil {
+        ref.Source = m[1]
+      } else if m := moduleVersionPattern.FindStringSubmatch(line); m != nil {
+        ref.Pin = m[2]
+        ref.PinKind = "version"
+      }
+    }
+    if ref.Repository = getModuleRepository(ref.Source); ref.Repository == "" {
+      return
+    }
+    if m := moduleRefPattern.FindStringSubmatch(ref.Source); m != nil {
+      ref.Pin = m[2]
+      ref.PinKind = "ref"
+    }
+    modules = append(modules, ref)
+  })
+  return modules, err
+}
+
+/**
+ * @brief      Returns the releases of the given module repository, the most
+ *             recent first
+ *
+ * The pre-releases and the tags that are not versions are skipped.
+ */
+func GetModuleReleases(repository string) ([]ModuleRelease, error) {
+  buf, err := Download(fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=100", repository), WithDefaults).EventuallyReadAll()
+  if err != nil {
+    return nil, fmt.Errorf("Could not list the releases of %s: %s", repository, err.Error())
+  }
+  var releases []GithubRelease
+  if err := json.Unmarshal(buf, &releases); err != nil {
+    return nil, fmt.Errorf("Could not parse the releases of %s: %s", repository, err.Error())
+  }
+
+  var ret []ModuleRelease
+  for _, rls := range releases {
+    ver, err := semver.NewVersion(rls.TagName)
+    if err != nil || ver.Prerelease() != "" {
+      continue
+    }
+    ret = append(ret, ModuleRelease{rls.TagName, ver, strings.TrimSpace(rls.Body)})
+  }
+  sort.Slice(ret, func(i, j int) bool {
+    return ret[i].Version.GreaterThan(ret[j].Version)
+  })
+  return ret, nil
+}
+
+/**
+ * @brief      Returns the release the pin of the module currently resolves
+ *             to, among the given ones, or nil if none matches
+ */
+func (m *ModuleReference) ResolvePin(releases []ModuleRelease) *ModuleRelease {
+  if m.PinKind == "ref" {
+    ver, err := semver.NewVersion(m.Pin)
+    if err != nil {
+      return nil
+    }
+    for i := range releases {
+      if releases[i].Version.Equal(ver) {
+        return &releases[i]
+      }
+    }
+    return nil
+  }
+
+  // No constraint gets the latest release
+  pin := m.Pin
+  if pin == "" {
+    pin = "*"
+  }
+  constraint, err := semver.NewConstraint(pin)
+  if err != nil {
+    return nil
+  }
+  for i := range releases {
+    if constraint.Check(releases[i].Version) {
+      return &releases[i]
+    }
+  }
+  return nil
+}
+
+/**
+ * Returns the releases after `from` up to `to` (included), the most recent
+ * first, to show what changed between the two
+ */
+func GetModuleChangelog(releases []ModuleRelease, from *semver.Version, to *semver.Version) []ModuleRelease {
+  var ret []ModuleRelease
+  for _, rls := range releases {
+    if (from == nil || rls.Version.GreaterThan(from)) && !rls.Version.GreaterThan(to) {
+      ret = append(ret, rls)
+    }
+  }
+  return ret
+}
+
+/**
+ * @brief      Rewrites the pin of the given module to the given release,
+ *             keeping the style of the existing pin
+ *
+ * A `~>` constraint stays one, for the new version, unless the exact
+ * version is asked. The original contents of the file are returned, so the
+ * change can be reverted.
+ */
+func (s *ProjectSandbox) PinModule(module ModuleReference, release ModuleRelease, exact bool) ([]byte, error) {
+  original, err := s.ReadFile(module.File)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", module.File, err.Error())
+  }
+
+  pinned := false
+  var rewritten []string
+  err = s.visitModuleBlocks(func(file string, lines []string, name string, start int, end int) {
+    if file != module.File || name != module.Name {
+      return
+    }
+    for i := start; i <= end && i < len(lines); i++ {
+      // Git modules without a ref get one in their source, and the others
+      // a version constraint after it
+      if module.PinKind == "" && moduleSourcePattern.MatchString(lines[i]) && moduleGitRepoPattern.MatchString(module.Source) {
+        separator := "?"
+        if strings.Contains(module.Source, "?") {
+          separator = "&"
+        }
+        lines[i] = strings.Replace(lines[i], module.Source, module.Source+separator+"ref="+release.Tag, 1)
+        pinned = true
+        break
+      }
+      if module.PinKind == "" && moduleSourcePattern.MatchString(lines[i]) {
+        indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+        version := fmt.Sprintf(`%sversion = "~> %s"`, indent, release.Version.String())
+        if exact {
+          version = fmt.Sprintf(`%sversion = "%s"`, indent, release.Version.String())
+        }
+        lines = append(lines[:i+1], append([]string{version}, lines[i+1:]...)...)
+        pinned = true
+        break
+      }
+      if module.PinKind == "ref" && moduleSourcePattern.MatchString(lines[i]) {
+        lines[i] = moduleRefPattern.ReplaceAllString(lines[i], "${1}"+release.Tag)
+        pinned = true
+      } else if module.PinKind == "version" {
+        if m := moduleVersionPattern.FindStringSubmatch(lines[i]); m != nil {
+          version := release.Version.String()
+          if strings.HasPrefix(strings.TrimSpace(m[2]), "~>") && !exact {
+            version = "~> " + version
+          }
+          lines[i] = moduleVersionPattern.ReplaceAllString(lines[i], fmt.Sprintf(`${1}"%s"`, version))
+          pinned = true
+        }
+      }
+    }
+    rewritten = lines
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  if !pinned {
+    return nil, fmt.Errorf("Could not find the pin of the module %s in %s", module.Name, module.File)
+  }
+
+  if err := s.WriteFile(module.File, []byte(strings.Join(rewritten, "\n"))); err != nil {
+    return nil, err
+  }
+  return original, nil
+}
@@ -4,7 +4,6 @@ import (
   "archive/tar"
   "archive/zip"
   "bufio"
-  "bytes"
   "compress/bzip2"
   "compress/gzip"
   "crypto"
@@ -21,6 +20,8 @@ import (
   "strconv"
   "strings"
   "time"
+
+  "golang.org/x/crypto/ssh/terminal"
 )
 
 /**
@@ -44,27 +45,37 @@ const (
   WithDefaults       DownloadFlags = 0
   WithoutCompression DownloadFlags = 1
   IgnoreErrors       DownloadFlags = 2
+  // Large files are downloaded over several connections
+  InParallel DownloadFlags = 4
 )
 
 /**
  * A customized HTTP client
  */
 func getHttpClient(disableCompression bool) *http.Client {
-  tr := &http.Transport{
-    MaxIdleConns:       10,
-    IdleConnTimeout:    30 * time.Second,
-    DisableCompression: disableCompression,
-  }
-  return &http.Client{Transport: tr}
+  return &http.Client{Transport: wrapLoggingTransport(newHttpTransport(disableCompression))}
 }
 
 /**
  * Start a network stream
  */
 func Download(url string, flags DownloadFlags) NetworkStreamChain {
+  if IsOffline() {
+    return NetworkStreamChain{
+      nil,
+      fmt.Errorf("could not request %s: network access is disabled in offline mode", url),
+      StreamMeta{},
+      func() error {
+        return nil
+      },
+    }
+  }
+
+  span := StartSpan("network", "download").SetArg("url", url)
   client := getHttpClient((flags & WithoutCompression) != 0)
   resp, err := client.Get(url)
   if err != nil {
+    span.End()
     return NetworkStreamChain{
       nil,
       fmt.Errorf("could not request %s: %s", url, err.Error()),
@@ -83,6 +94,7 @@ func Download(url string, flags DownloadFlags) NetworkStreamChain {
         fmt.Errorf("server responded with: %s", resp.Status),
         StreamMeta{},
         func() error {
+          span.End()
           return resp.Body.Close()
         },
       }
@@ -98,20 +110,73 @@ func Download(url string, flags DownloadFlags) NetworkStreamChain {
   // Extract content type
   contentEncoding := resp.Header.Get("Content-Encoding")
 
+  if (flags&InParallel) != 0 && canDownloadInParallel(resp) {
+    if download, err := newParallelDownload(url, client, resp); err == nil {
+      return NetworkStreamChain{
+        download,
+        nil,
+        StreamMeta{
+          contentLength,
+          contentEncoding,
+        },
+        func() error {
+          span.End()
+          return download.Close()
+        },
+      }
+    }
+  }
+
+  // Reconnect where the download was interrupted, when the server allows it
+  var body io.ReadCloser = resp.Body
+  if isResumable(resp) {
+    body = newResumableBody(client, resp)
+  }
+
   // Return a network stream with meta
   return NetworkStreamChain{
-    resp.Body,
+    body,
     nil,
     StreamMeta{
       contentLength,
       contentEncoding,
     },
     func() error {
-      return resp.Body.Close()
+      span.End()
+      return body.Close()
     },
   }
 }
 
+/**
+ * Checks that the given URL exists, without downloading it. The servers that
+ * do not allow HEAD requests are asked for its first byte. Returns an error
+ * if it could not be asked at all.
+ */
+func CheckURLExists(url string) (bool, error) {
+  if IsOffline() {
+    return false, fmt.Errorf("could not request %s: network access is disabled in offline mode", url)
+  }
+
+  defer StartSpan("network", "check").SetArg("url", url).End()
+  client := getHttpClient(false)
+  resp, err := client.Head(url)
+  if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusForbidden) {
+    resp.Body.Close()
+    req, rerr := http.NewRequest("GET", url, nil)
+    if rerr != nil {
+      return false, rerr
+    }
+    req.Header.Set("Range", "bytes=0-0")
+    resp, err = client.Do(req)
+  }
+  if err != nil {
+    return false, fmt.Errorf("could not request %s: %s", url, err.Error())
+  }
+  resp.Body.Close()
+  return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
 /**
  * Also calculate incoming stream and validate it
  */
@@ -192,18 +257,54 @@ func (stream NetworkStreamChain) AndShowProgress(prefix string) NetworkStreamCha
     return stream
   }
 
-  // Create progress bar
+  // Create progress bar, with the size, speed and time left. It's only
+  // redrawn on a terminal, elsewhere (eg. CI logs) only the start and the
+  // end of the download are printed.
   bar := pb.New(stream.Meta.ContentLength).SetUnits(pb.U_BYTES).Prefix(prefix)
+  bar.ShowSpeed = true
+  bar.ShowTimeLeft = true
+  label := prefix
+  if label == "" {
+    label = "Downloading"
+  }
+  started := time.Now()
+  isTerminal := terminal.IsTerminal(int(os.Stderr.Fd())) && !IsQuiet()
+  if isTerminal {
+    bar.Output = colorableStderr
+  } else if IsQuiet() {
+    bar.NotPrint = true
+  } else {
+    bar.NotPrint = true
+    size := "unknown size"
+    if stream.Meta.ContentLength > 0 {
+      size = pb.Format(int64(stream.Meta.ContentLength)).To(pb.U_BYTES).String()
+    }
+    colorableStderr.Write([]byte(fmt.Sprintf("%s (%s)...\n", label, size)))
+  }
   bar.Start()
-  proxyReader := bar.NewProxyReader(stream.Reader)
+
+  // The downloads over several connections tell how much they received,
+  // before it can be read in order
+  var reader io.Reader
+  if reporter, ok := stream.Reader.(progressReporter); ok {
+    reporter.ReportProgressTo(func(n int) {
+      bar.Add(n)
+    })
+    reader = stream.Reader
+  } else {
+    reader = bar.NewProxyReader(stream.Reader)
+  }
 
   // Return chain
   return NetworkStreamChain{
-    proxyReader,
+    reader,
     nil,
     stream.Meta,
     func() error {
       bar.Finish()
+      if !isTerminal && !IsQuiet() {
+        colorableStderr.Write([]byte(fmt.Sprintf("%s: done in %s\n", label, time.Since(started).Round(100*time.Millisecond))))
+      }
       return stream.Close()
     },
   }
@@ -295,169 +396,106 @@ func (stream NetworkStreamChain) AndDecompressIfCompressed() NetworkStreamChain
 }
 
 /**
- * De-compress the stream on the given directory
+ * Downloads the whole stream in a temporary file, and closes it so it's
+ * verified (eg. its checksum) before anything is extracted from it. The
+ * caller removes the file with removeTempFile.
  */
-func (stream NetworkStreamChain) EventuallyUnzipTo(prefix string, stripComponents int) error {
+func (stream NetworkStreamChain) spoolToTempFile() (*os.File, int64, error) {
   if stream.Err != nil {
-    return stream.Err
+    return nil, 0, stream.Err
   }
 
-  // Removes `stripComponents` parts from the path given
-  applyStrip := func(src string) string {
-    parts := strings.Split(src, string(os.PathSeparator))
-    if stripComponents >= len(parts) {
-      return ""
-    }
-    return filepath.Join(parts[stripComponents:]...)
-  }
-
-  // Read the whole archive in memory (not a good idea for very big files)
-  buff := bytes.NewBuffer([]byte{})
-  size, err := io.Copy(buff, stream.Reader)
+  f, err := ioutil.TempFile("", "terraform-wheels-download-")
   if err != nil {
-    return fmt.Errorf("unzip failed: could not buffer file in memory: %s", err.Error())
+    stream.Close()
+    return nil, 0, fmt.Errorf("could not create a temporary file: %s", err.Error())
   }
-
-  // Oopen the zip stream
-  zipReader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), size)
+  size, err := io.Copy(f, stream.Reader)
   if err != nil {
-    return fmt.Errorf("unzip failed: %s", err.Error())
+    stream.Close()
+    removeTempFile(f)
+    return nil, 0, fmt.Errorf("could not download: %s", err.Error())
   }
 
-  // Iterate over the file records
-  for _, file := range zipReader.File {
-    fName := applyStrip(file.Name)
-    fDstPath := filepath.Join(prefix, fName)
-
-    // Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
-    if !strings.HasPrefix(fDstPath, filepath.Clean(prefix)+string(os.PathSeparator)) {
-      return fmt.Errorf("unzip failed: illegal path: %s", fDstPath)
-    }
-
-    if file.FileInfo().IsDir() {
-      if err := os.Mkdir(fDstPath, os.ModePerm); err != nil {
-        stream.Close()
-        return fmt.Errorf("unzip failed: cannot create directory %s: %s", fDstPath, err.Error())
-      }
-      continue
-    }
-
-    // Make File
-    if err = os.MkdirAll(filepath.Dir(fDstPath), os.ModePerm); err != nil {
-      return fmt.Errorf("unzip failed: cannot create directory %s: %s", filepath.Dir(fDstPath), err.Error())
-    }
-
-    outFile, err := os.OpenFile(fDstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-    if err != nil {
-      return fmt.Errorf("unzip failed: cannot open %s for writing: %s", fDstPath, err.Error())
-    }
-
-    rc, err := file.Open()
-    if err != nil {
-      return fmt.Errorf("unzip failed: cannot open %s for reading: %s", err.Error())
-    }
+  // Close the stream and return any final errors that might have occurred
+  if err := stream.Close(); err != nil {
+    removeTempFile(f)
+    return nil, 0, err
+  }
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    removeTempFile(f)
+    return nil, 0, err
+  }
+  return f, size, nil
+}
 
-    _, err = io.Copy(outFile, rc)
+func removeTempFile(f *os.File) {
+  f.Close()
+  os.Remove(f.Name())
+}
 
-    // Close the file without defer to close before next iteration of loop
-    outFile.Close()
-    rc.Close()
+/**
+ * Checks that an extracted file does not escape the directory it's
+ * extracted to (eg. with ../)
+ */
+func isInsideDir(dir string, path string) bool {
+  rel, err := filepath.Rel(dir, path)
+  return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
 
-    if err != nil {
-      return fmt.Errorf("unzip failed: cannot write %s: %s", fDstPath, err.Error())
+func containsString(list []string, value string) bool {
+  for _, item := range list {
+    if item == value {
+      return true
     }
   }
+  return false
+}
 
-  // Close the stream and return any final errors that might have occurred
-  return stream.Close()
+/**
+ * De-compress the stream on the given directory
+ */
+func (stream NetworkStreamChain) EventuallyUnzipTo(prefix string, stripComponents int) error {
+  return stream.EventuallyUnzipOnlyTo(prefix, nil, stripComponents)
 }
 
 /**
  * De-compress the stream on the given directory
  */
 func (stream NetworkStreamChain) EventuallyUntarTo(prefix string, stripComponents int) ([]string, error) {
-  var files []string = nil
-  if stream.Err != nil {
-    return files, stream.Err
-  }
+  return stream.untarTo(prefix, nil, stripComponents)
+}
 
-  // Removes `stripComponents` parts from the path given
-  applyStrip := func(src string) string {
-    parts := strings.Split(src, string(os.PathSeparator))
-    if stripComponents >= len(parts) {
-      return ""
-    }
-    return filepath.Join(parts[stripComponents:]...)
+/**
+ * De-compress the stream on the given directory
+ */
+func (stream NetworkStreamChain) EventuallyUntarOnlyTo(prefix string, filenames []string, stripComponents int) error {
+  files, err := stream.untarTo(prefix, filenames, stripComponents)
+  if err != nil {
+    return err
   }
-
-  // Open the tar stream
-  tarReader := tar.NewReader(stream.Reader)
-  for true {
-    header, err := tarReader.Next()
-    if err == io.EOF {
-      break
-    }
-
-    if err != nil {
-      stream.Close()
-      return files, fmt.Errorf("untar failed: cannot get next entry: %s", err.Error())
-    }
-
-    switch header.Typeflag {
-
-    // Directory
-    case tar.TypeDir:
-      fName := applyStrip(header.Name)
-      if fName != "" {
-        if err := os.Mkdir(prefix+fName, 0755); err != nil {
-          stream.Close()
-          return files, fmt.Errorf("untar failed: cannot create directory: %s", err.Error())
-        }
-      }
-
-    // File
-    case tar.TypeReg:
-      fName := applyStrip(header.Name)
-      if fName != "" {
-        outFile, err := os.Create(prefix + fName)
-        if err != nil {
-          stream.Close()
-          return files, fmt.Errorf("untar failed: cannot create file: %s", err.Error())
-        }
-        defer outFile.Close()
-        if _, err := io.Copy(outFile, tarReader); err != nil {
-          stream.Close()
-          return files, fmt.Errorf("untar failed: cannot copy file contents: %s", err.Error())
-        }
-
-        files = append(files, fName)
-      }
-
-    // Other/Unknown
-    default:
-      // return files, errors.New(
-      //   fmt.Sprintf("ExtractTarGz: uknown type: %s in %s",
-      //     header.Typeflag,
-      //     header.Name))
-    }
+  if len(files) == 0 {
+    return fmt.Errorf("Did not find any matching file in the archive")
   }
-
-  // Close the stream and return any final errors that might have occurred
-  return files, stream.Close()
+  return nil
 }
 
 /**
- * De-compress the stream on the given directory
+ * Extracts the given files of the tar stream (or all of them if nil) once
+ * it's completely downloaded and verified
  */
-func (stream NetworkStreamChain) EventuallyUntarOnlyTo(prefix string, filenames []string, stripComponents int) error {
-  var extractedFiles int = 0
-  if stream.Err != nil {
-    return stream.Err
+func (stream NetworkStreamChain) untarTo(prefix string, filenames []string, stripComponents int) ([]string, error) {
+  var files []string = nil
+  archive, _, err := stream.spoolToTempFile()
+  if err != nil {
+    return files, err
   }
+  defer removeTempFile(archive)
 
-  // Removes `stripComponents` parts from the path given
+  // Removes `stripComponents` parts from the path given. Archive entries
+  // always use forward slashes, regardless of the platform.
   applyStrip := func(src string) string {
-    parts := strings.Split(src, string(os.PathSeparator))
+    parts := strings.Split(src, "/")
     if stripComponents >= len(parts) {
       return ""
     }
@@ -465,7 +503,7 @@ func (stream NetworkStreamChain) EventuallyUntarOnlyTo(prefix string, filenames
   }
 
   // Open the tar stream
-  tarReader := tar.NewReader(stream.Reader)
+  tarReader := tar.NewReader(archive)
   for true {
     header, err := tarReader.Next()
     if err == io.EOF {
@@ -473,61 +511,48 @@ func (stream NetworkStreamChain) EventuallyUntarOnlyTo(prefix string, filenames
     }
 
     if err != nil {
-      stream.Close()
-      return fmt.Errorf("untar failed: cannot get next entry: %s", err.Error())
+      return files, fmt.Errorf("untar failed: cannot get next entry: %s", err.Error())
+    }
+
+    fName := applyStrip(header.Name)
+    if fName == "" || (filenames != nil && !containsString(filenames, fName)) {
+      continue
+    }
+    if !isInsideDir(prefix, filepath.Join(prefix, fName)) {
+      return files, fmt.Errorf("untar failed: illegal path: %s", header.Name)
     }
 
     switch header.Typeflag {
 
+    // Directory
+    case tar.TypeDir:
+      if err := os.MkdirAll(filepath.Join(prefix, fName), 0755); err != nil {
+        return files, fmt.Errorf("untar failed: cannot create directory: %s", err.Error())
+      }
+
     // File
     case tar.TypeReg:
-      fName := applyStrip(header.Name)
-
-      // Check if that file should be created
-      found := false
-      for _, rqName := range filenames {
-        if rqName == fName {
-          found = true
-          break
-        }
+      fDstName := filepath.Join(prefix, fName)
+      if err = os.MkdirAll(filepath.Dir(fDstName), os.ModePerm); err != nil {
+        return files, fmt.Errorf("untar failed: cannot create directory %s: %s", filepath.Dir(fDstName), err.Error())
       }
 
-      if found {
-        fDstName := prefix + fName
-
-        if err = os.MkdirAll(filepath.Dir(fDstName), os.ModePerm); err != nil {
-          return fmt.Errorf("untar failed: cannot create directory %s: %s", filepath.Dir(fDstName), err.Error())
-        }
-
-        outFile, err := os.Create(fDstName)
-        if err != nil {
-          stream.Close()
-          return fmt.Errorf("untar failed: cannot create file: %s", err.Error())
-        }
-        defer outFile.Close()
-        if _, err := io.Copy(outFile, tarReader); err != nil {
-          stream.Close()
-          return fmt.Errorf("untar failed: cannot copy file contents: %s", err.Error())
-        }
-
-        extractedFiles += 1
+      outFile, err := os.Create(fDstName)
+      if err != nil {
+        return files, fmt.Errorf("untar failed: cannot create file: %s", err.Error())
+      }
+      _, err = io.Copy(outFile, tarReader)
+      outFile.Close()
+      if err != nil {
+        os.Remove(fDstName)
+        return files, fmt.Errorf("untar failed: cannot copy file contents: %s", err.Error())
       }
 
-    // Other/Unknown
-    default:
-      // return errors.New(
-      //   fmt.Sprintf("ExtractTarGz: uknown type: %s in %s",
-      //     header.Typeflag,
-      //     header.Name))
+      files = append(files, fName)
     }
   }
 
-  if extractedFiles == 0 {
-    return fmt.Errorf("Did not find any matching file in the archive")
-  }
-
-  // Close the stream and return any final errors that might have occurred
-  return stream.Close()
+  return files, nil
 }
 
 /**
@@ -535,28 +560,24 @@ func (stream NetworkStreamChain) EventuallyUntarOnlyTo(prefix string, filenames
  */
 func (stream NetworkStreamChain) EventuallyUnzipOnlyTo(prefix string, filenames []string, stripComponents int) error {
   var extractedFiles int = 0
-  if stream.Err != nil {
-    return stream.Err
+  archive, size, err := stream.spoolToTempFile()
+  if err != nil {
+    return err
   }
+  defer removeTempFile(archive)
 
-  // Removes `stripComponents` parts from the path given
+  // Removes `stripComponents` parts from the path given. Archive entries
+  // always use forward slashes, regardless of the platform.
   applyStrip := func(src string) string {
-    parts := strings.Split(src, string(os.PathSeparator))
+    parts := strings.Split(src, "/")
     if stripComponents >= len(parts) {
       return ""
     }
     return filepath.Join(parts[stripComponents:]...)
   }
 
-  // Read the whole archive in memory (not a good idea for very big files)
-  buff := bytes.NewBuffer([]byte{})
-  size, err := io.Copy(buff, stream.Reader)
-  if err != nil {
-    return fmt.Errorf("unzip failed: could not buffer file in memory: %s", err.Error())
-  }
-
-  // Oopen the zip stream
-  zipReader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), size)
+  // Open the zip stream
+  zipReader, err := zip.NewReader(archive, size)
   if err != nil {
     return fmt.Errorf("unzip failed: %s", err.Error())
   }
@@ -566,27 +587,18 @@ func (stream NetworkStreamChain) EventuallyUnzipOnlyTo(prefix string, filenames
     fName := applyStrip(file.Name)
 
     // Check if that file should be created
-    found := false
-    for _, rqName := range filenames {
-      if rqName == fName {
-        found = true
-        break
-      }
-    }
-
-    if !found {
+    if filenames != nil && !containsString(filenames, fName) {
       continue
     }
 
     // Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
     fDstPath := filepath.Join(prefix, fName)
-    if !strings.HasPrefix(fDstPath, filepath.Clean(prefix)+string(os.PathSeparator)) {
+    if !isInsideDir(prefix, fDstPath) {
       return fmt.Errorf("unzip failed: illegal path: %s", fDstPath)
     }
 
     if file.FileInfo().IsDir() {
-      if err := os.Mkdir(fDstPath, os.ModePerm); err != nil {
-        stream.Close()
+      if err := os.MkdirAll(fDstPath, os.ModePerm); err != nil {
         return fmt.Errorf("unzip failed: cannot create directory %s: %s", fDstPath, err.Error())
       }
       continue
@@ -604,7 +616,8 @@ func (stream NetworkStreamChain) EventuallyUnzipOnlyTo(prefix string, filenames
 
     rc, err := file.Open()
     if err != nil {
-      return fmt.Errorf("unzip failed: cannot open %s for reading: %s", err.Error())
+      outFile.Close()
+      return fmt.Errorf("unzip failed: cannot open %s for reading: %s", fDstPath, err.Error())
     }
 
     _, err = io.Copy(outFile, rc)
@@ -614,45 +627,55 @@ func (stream NetworkStreamChain) EventuallyUnzipOnlyTo(prefix string, filenames
     rc.Close()
 
     if err != nil {
+      os.Remove(fDstPath)
       return fmt.Errorf("unzip failed: cannot write %s: %s", fDstPath, err.Error())
     }
     extractedFiles += 1
   }
 
-  if extractedFiles == 0 {
+  if filenames != nil && extractedFiles == 0 {
     return fmt.Errorf("Did not find any matching file in the archive")
   }
-
-  // Close the stream and return any final errors that might have occurred
-  return stream.Close()
+  return nil
 }
 
 /**
- * Write the stream into the designated filename
+ * Write the stream into the designated filename. It's written next to it
+ * first, and only moved into place once it's verified.
  */
 func (stream NetworkStreamChain) EventuallyWriteTo(filename string) error {
   if stream.Err != nil {
     return stream.Err
   }
 
-  // Download contents to /tool
-  f, err := os.Create(filename)
+  f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".part-")
   if err != nil {
     stream.Close()
     return fmt.Errorf("could create destination file: %s", err.Error())
   }
-  defer f.Close()
 
-  // Try GZip and fall-back to plaintext
   fileStream := bufio.NewWriter(f)
-  if _, err := io.Copy(fileStream, stream.Reader); err != nil {
+  _, err = io.Copy(fileStream, stream.Reader)
+  if err == nil {
+    err = fileStream.Flush()
+  }
+  if err != nil {
     stream.Close()
+    removeTempFile(f)
     return fmt.Errorf("could not create file contents: %s", err.Error())
   }
-  fileStream.Flush()
 
   // Close the stream and return any final errors that might have occurred
-  return stream.Close()
+  if err := stream.Close(); err != nil {
+    removeTempFile(f)
+    return err
+  }
+  f.Close()
+  if err := os.Rename(f.Name(), filename); err != nil {
+    os.Remove(f.Name())
+    return fmt.Errorf("could not move the download to %s: %s", filename, err.Error())
+  }
+  return nil
 }
 
 /**
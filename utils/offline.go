@@ -0,0 +1,140 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+var offlineMode bool = false
+var offlineMirrorDir string = ""
+
+/**
+ * Enables or disables the offline (air-gapped) mode, where nothing is
+ * downloaded from the internet
+ */
+func SetOfflineMode(enabled bool) {
+  offlineMode = enabled
+}
+
+/**
+ * Checks if we are running in offline mode, either because of `--offline`,
+ * the TERRAFORM_WHEELS_OFFLINE environment variable or the project config
+ */
+func IsOffline() bool {
+  if v, ok := os.LookupEnv("TERRAFORM_WHEELS_OFFLINE"); ok && v != "" && v != "0" && v != "false" {
+    return true
+  }
+  return offlineMode
+}
+
+/**
+ * Returns the directory with the pre-seeded terraform binary, providers and
+ * modules, that are used instead of downloading them in offline mode:
+ *
+ *   <mirror>/terraform          The terraform binary
+ *   <mirror>/plugins/           The provider plugins
+ *   <mirror>/modules/<source>   The modules, by their registry source
+ */
+func GetMirrorDir() (string, error) {
+  if dir, ok := os.LookupEnv("TERRAFORM_WHEELS_MIRROR"); ok && dir != "" {
+    return filepath.Abs(dir)
+  }
+  if offlineMirrorDir != "" {
+    return offlineMirrorDir, nil
+  }
+
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "mirror"), nil
+}
+
+/**
+ * Applies the offline settings of the project configuration
+ */
+func applyOfflineConfig(baseDir string, cfg OfflineConfig) {
+  if cfg.Enabled {
+    offlineMode = true
+  }
+  if cfg.MirrorDir != "" {
+    if filepath.IsAbs(cfg.MirrorDir) {
+      offlineMirrorDir = cfg.MirrorDir
+    } else {
+      offlineMirrorDir = filepath.Join(baseDir, cfg.MirrorDir)
+    }
+  }
+}
+
+/**
+ * Returns the pre-seeded terraform binary from the mirror directory
+ */
+func getMirrorTerraform() (string, error) {
+  dir, err := GetMirrorDir()
+  if err != nil {
+    return "", err
+  }
+
+  fPath := filepath.Join(dir, ExecutableName("terraform"))
+  if _, err := os.Stat(fPath); err != nil {
+    return "", fmt.Errorf("Running in offline mode, but there is no terraform v%sx in your PATH or in %s", RequiredTerraformVersionPrefix, fPath)
+  }
+  return fPath, nil
+}
+
+/**
+ * Makes `terraform init` use the providers in the mirror directory instead
+ * of downloading them
+ */
+func AddOfflineInitArgs(args []string) ([]string, error) {
+  dir, err := GetMirrorDir()
+  if err != nil {
+    return nil, err
+  }
+
+  pluginDir := filepath.Join(dir, "plugins")
+  if _, err := os.Stat(pluginDir); err != nil {
+    return nil, fmt.Errorf("Running in offline mode, but the provider mirror %s does not exist", pluginDir)
+  }
+  // Terraform does not look into the platform directories of `-plugin-dir`
+  platformDir := GetMirrorPluginDir(dir, GetCurrentPlatform())
+  if _, err := os.Stat(platformDir); err == nil {
+    pluginDir = platformDir
+  }
+
+  var ret []string
+  for i, arg := range args {
+    ret = append(ret, arg)
+    if arg == "init" {
+      ret = append(ret, fmt.Sprintf("-plugin-dir=%s", pluginDir))
+      ret = append(ret, args[i+1:]...)
+      break
+    }
+  }
+  return ret, nil
+}
+
+/**
+ * Returns the `source` (and `version`) lines of a module, pointing to the
+ * terraform registry, or to the mirror directory in offline mode
+ */
+func GetModuleSource(source string, defaultVersion string) string {
+  if IsOffline() {
+    dir, err := GetMirrorDir()
+    if err == nil {
+      path := filepath.ToSlash(filepath.Join(dir, "modules", filepath.FromSlash(source)))
+      return fmt.Sprintf(`  source  = "%s"`, path)
+    }
+  }
+
+  version := defaultVersion
+  if source == "dcos-terraform/dcos/aws" {
+    version = GetLatestModuleVersion(defaultVersion)
+  }
+  return strings.Join([]string{
+    fmt.Sprintf(`  source  = "%s"`, source),
+    fmt.Sprintf(`  version = "~> %s"`, version),
+  }, "\n")
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+  "os"
+  "os/exec"
+  "runtime"
+  "strings"
+
+  . "github.com/mattn/go-colorable"
+  "golang.org/x/crypto/ssh/terminal"
+)
+
+/**
+ * Returns the pager command: TERRAFORM_WHEELS_PAGER or PAGER, or less (but
+ * not on Windows, where `more` does not understand the colors)
+ */
+func getPagerCommand() []string {
+  for _, name := range []string{"TERRAFORM_WHEELS_PAGER", "PAGER"} {
+    if v, ok := os.LookupEnv(name); ok {
+      return strings.Fields(v)
+    }
+  }
+  if runtime.GOOS == "windows" {
+    return nil
+  }
+  return []string{"less"}
+}
+
+/**
+ * Pipes what is printed on stdout through a pager, when it's a terminal (set
+ * PAGER=cat to disable it). Call the returned function once everything is
+ * printed, to wait until the pager is closed.
+ */
+func StartPager() func() {
+  pager := getPagerCommand()
+  if len(pager) == 0 || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+    return func() {}
+  }
+  path, err := exec.LookPath(pager[0])
+  if err != nil {
+    return func() {}
+  }
+
+  cmd := exec.Command(path, pager[1:]...)
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+  // Quit right away if it fits on the screen, and keep the colors
+  cmd.Env = os.Environ()
+  if _, ok := os.LookupEnv("LESS"); !ok {
+    cmd.Env = append(cmd.Env, "LESS=FRX")
+  }
+  stdin, err := cmd.StdinPipe()
+  if err != nil {
+    return func() {}
+  }
+  if err := cmd.Start(); err != nil {
+    PrintWarning("Could not start the pager %s: %s", pager[0], err.Error())
+    return func() {}
+  }
+
+  previous := colorableStdout
+  if useColors(os.Stdout) {
+    colorableStdout = stdin
+  } else {
+    colorableStdout = NewNonColorable(stdin)
+  }
+
+  stop := func() {
+    colorableStdout = previous
+    stdin.Close()
+    cmd.Wait()
+  }
+  unregisterCleanup := RegisterCleanup(stop)
+  return func() {
+    unregisterCleanup()
+    stop()
+  }
+}
+
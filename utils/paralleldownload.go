@@ -0,0 +1,251 @@
+package utils
+
+import (
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "sync"
+)
+
+// How many connections download a large artifact
+var parallelDownloadConnections int = 4
+
+// The artifacts smaller than this are downloaded over a single connection
+var parallelDownloadThreshold int = 8 * 1024 * 1024
+
+/**
+ * The streams that know how much was downloaded better than how much was
+ * read from them, eg. the downloads over several connections
+ */
+type progressReporter interface {
+  ReportProgressTo(add func(n int))
+}
+
+/**
+ * A part of a file, downloaded over its own connection
+ */
+type downloadSegment struct {
+  start   int64
+  end     int64
+  written int64
+  body    io.ReadCloser
+}
+
+/**
+ * A download over several connections, with a range of the file each. The
+ * parts are written in a temporary file as they arrive, and read back in
+ * order as soon as they are available.
+ */
+type parallelDownload struct {
+  url       string
+  validator string
+  client    *http.Client
+  file      *os.File
+  segments  []*downloadSegment
+  total     int64
+  offset    int64
+  err       error
+  closed    bool
+  progress  func(n int)
+
+  mutex   sync.Mutex
+  cond    *sync.Cond
+  started sync.Once
+  wg      sync.WaitGroup
+}
+
+/**
+ * Returns true if the response is for a file big enough to be downloaded over
+ * several connections, by a server that accepts ranges
+ */
+func canDownloadInParallel(resp *http.Response) bool {
+  return isResumable(resp) &&
+    resp.ContentLength >= int64(parallelDownloadThreshold) &&
+    parallelDownloadConnections > 1
+}
+
+/**
+ * Downloads the rest of the file of the given response over several
+ * connections. The response is used for the first part.
+ */
+func newParallelDownload(url string, client *http.Client, resp *http.Response) (*parallelDownload, error) {
+  file, err := ioutil.TempFile("", "terraform-wheels-download-")
+  if err != nil {
+    return nil, fmt.Errorf("could not create a temporary file: %s", err.Error())
+  }
+
+  // The other parts are requested where the first one was redirected to
+  if resp.Request != nil && resp.Request.URL != nil {
+    url = resp.Request.URL.String()
+  }
+  d := &parallelDownload{url: url, client: client, file: file, total: resp.ContentLength, validator: getRangeValidator(resp)}
+  d.cond = sync.NewCond(&d.mutex)
+
+  size := (d.total + int64(parallelDownloadConnections) - 1) / int64(parallelDownloadConnections)
+  for start := int64(0); start < d.total; start += size {
+    end := start + size
+    if end > d.total {
+      end = d.total
+    }
+    d.segments = append(d.segments, &downloadSegment{start: start, end: end})
+  }
+  d.segments[0].body = resp.Body
+  return d, nil
+}
+
+func (d *parallelDownload) ReportProgressTo(add func(n int)) {
+  d.progress = add
+}
+
+/**
+ * Starts downloading all the parts
+ */
+func (d *parallelDownload) start() {
+  for _, segment := range d.segments {
+    d.wg.Add(1)
+    go func(segment *downloadSegment) {
+      defer d.wg.Done()
+      if err := d.downloadSegment(segment); err != nil {
+        d.mutex.Lock()
+        if d.err == nil && !d.closed {
+          d.err = err
+        }
+        d.cond.Broadcast()
+        d.mutex.Unlock()
+      }
+    }(segment)
+  }
+}
+
+func (d *parallelDownload) downloadSegment(segment *downloadSegment) error {
+  buf := make([]byte, 32*1024)
+  attempts := 0
+  for pos := segment.start; pos < segment.end; {
+    // Connect, or reconnect where the part was interrupted
+    if segment.body == nil {
+      resp, err := requestRange(d.client, d.url, pos, segment.end-1, d.validator)
+      if err != nil {
+        if attempts >= downloadResumeAttempts {
+          return err
+        }
+        attempts += 1
+        waitBeforeResuming(attempts)
+        continue
+      }
+
+      d.mutex.Lock()
+      segment.body = resp.Body
+      closed := d.closed
+      d.mutex.Unlock()
+      if closed {
+        resp.Body.Close()
+        return nil
+      }
+    }
+
+    want := int64(len(buf))
+    if segment.end-pos < want {
+      want = segment.end - pos
+    }
+    n, err := segment.body.Read(buf[:want])
+    if n > 0 {
+      if _, err := d.file.WriteAt(buf[:n], pos); err != nil {
+        return fmt.Errorf("could not write the download: %s", err.Error())
+      }
+      pos += int64(n)
+
+      d.mutex.Lock()
+      segment.written += int64(n)
+      d.cond.Broadcast()
+      d.mutex.Unlock()
+      if d.progress != nil {
+        d.progress(n)
+      }
+    }
+    if err == io.EOF && pos < segment.end {
+      err = io.ErrUnexpectedEOF
+    }
+    if err == nil || err == io.EOF {
+      continue
+    }
+
+    d.mutex.Lock()
+    closed := d.closed
+    d.mutex.Unlock()
+    if closed {
+      return nil
+    }
+    if attempts >= downloadResumeAttempts {
+      return fmt.Errorf("could not download %s: %s (after resuming %d times)", d.url, err.Error(), attempts)
+    }
+    attempts += 1
+    PrintWarning("The download of %s was interrupted (%s), resuming it", d.url, err.Error())
+    d.mutex.Lock()
+    segment.body.Close()
+    segment.body = nil
+    d.mutex.Unlock()
+    waitBeforeResuming(attempts)
+  }
+
+  d.mutex.Lock()
+  segment.body.Close()
+  d.mutex.Unlock()
+  return nil
+}
+
+/**
+ * Reads the file in order, waiting for its next part to arrive
+ */
+func (d *parallelDownload) Read(p []byte) (int, error) {
+  d.started.Do(d.start)
+
+  d.mutex.Lock()
+  defer d.mutex.Unlock()
+  for {
+    if d.offset >= d.total {
+      return 0, io.EOF
+    }
+    if d.err != nil {
+      return 0, d.err
+    }
+
+    for _, segment := range d.segments {
+      if d.offset < segment.start || d.offset >= segment.end {
+        continue
+      }
+      available := segment.start + segment.written - d.offset
+      if available > 0 {
+        if int64(len(p)) > available {
+          p = p[:available]
+        }
+        n, err := d.file.ReadAt(p, d.offset)
+        d.offset += int64(n)
+        if err == io.EOF {
+          err = nil
+        }
+        return n, err
+      }
+    }
+    d.cond.Wait()
+  }
+}
+
+/**
+ * Stops the download and removes the temporary file
+ */
+func (d *parallelDownload) Close() error {
+  d.mutex.Lock()
+  d.closed = true
+  for _, segment := range d.segments {
+    if segment.body != nil {
+      segment.body.Close()
+    }
+  }
+  d.mutex.Unlock()
+
+  d.wg.Wait()
+  d.file.Close()
+  return os.Remove(d.file.Name())
+}
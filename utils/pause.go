@@ -0,0 +1,194 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "time"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Where the instances of the paused clusters are kept, per workspace
+var pausedClustersDir string = "paused"
+
+/**
+ * An EC2 instance of the cluster, from the terraform state
+ */
+type ClusterInstance struct {
+  Address string `json:"address"`
+  ID      string `json:"id"`
+  Region  string `json:"region"`
+}
+
+/**
+ * The instances that were stopped by wheels-pause
+ */
+type PausedCluster struct {
+  PausedAt  time.Time         `json:"paused_at"`
+  Instances []ClusterInstance `json:"instances"`
+}
+
+/**
+ * @brief      Returns the EC2 instances in the terraform state, and the spot
+ *             instance requests, that cannot be stopped
+ *
+ * The region of every instance is the one of its availability zone, since
+ * the remote agents can be in another region than the project.
+ */
+func (w *TerraformWrapper) GetStateInstances(defaultRegion string) ([]ClusterInstance, []string, error) {
+  content, err := w.PullState()
+  if err != nil || content == nil {
+    return nil, nil, err
+  }
+
+  var state struct {
+    Modules []struct {
+      Path      []string `json:"path"`
+      Resources map[string]struct {
+        Type    string `json:"type"`
+        Primary struct {
+          ID         string            `json:"id"`
+          Attributes map[string]string `json:"attributes"`
+        } `json:"primary"`
+      } `json:"resources"`
+    } `json:"modules"`
+  }
+  if err := json.Unmarshal(content, &state); err != nil {
+    return nil, nil, fmt.Errorf("Could not parse the terraform state: %s", err.Error())
+  }
+
+  var instances []ClusterInstance
+  var spots []string
+  for _, module := range state.Modules {
+    prefix := ""
+    for _, name := range module.Path {
+      if name != "root" {
+        prefix += "module." + name + "."
+      }
+    }
+    for address, resource := range module.Resources {
+      switch resource.Type {
+      case "aws_instance":
+        region := defaultRegion
+        if zone := resource.Primary.Attributes["availability_zone"]; len(zone) > 1 {
+          region = zone[:len(zone)-1]
+        }
+        instances = append(instances, ClusterInstance{prefix + address, resource.Primary.ID, region})
+      case "aws_spot_instance_request":
+        spots = append(spots, prefix+address)
+      }
+    }
+  }
+  sort.Slice(instances, func(i, j int) bool {
+    return instances[i].Address < instances[j].Address
+  })
+  sort.Strings(spots)
+  return instances, spots, nil
+}
+
+func (s *ProjectSandbox) getPausedClusterPath() (string, error) {
+  return s.GetWheelsPath(filepath.Join(pausedClustersDir, s.GetWorkspace()+".json"))
+}
+
+/**
+ * Returns the instances stopped by wheels-pause, or nil if the cluster of
+ * the current workspace is not paused
+ */
+func (s *ProjectSandbox) GetPausedCluster() (*PausedCluster, error) {
+  path, err := s.getPausedClusterPath()
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  var paused PausedCluster
+  if err := json.Unmarshal(content, &paused); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", path, err.Error())
+  }
+  return &paused, nil
+}
+
+/**
+ * Stops the given instances, and remembers them to start them again with
+ * ResumeCluster
+ */
+func (s *ProjectSandbox) PauseCluster(instances []ClusterInstance) error {
+  path, err := s.getPausedClusterPath()
+  if err != nil {
+    return err
+  }
+
+  // Remembered first, so a failure halfway can be resumed
+  content, err := json.MarshalIndent(&PausedCluster{time.Now().UTC(), instances}, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(path, content, 0644); err != nil {
+    return fmt.Errorf("Could not write %s: %s", path, err.Error())
+  }
+  return setInstancesRunning(instances, false)
+}
+
+/**
+ * Starts the instances stopped by PauseCluster, and waits for them to run
+ */
+func (s *ProjectSandbox) ResumeCluster(paused *PausedCluster) error {
+  if err := setInstancesRunning(paused.Instances, true); err != nil {
+    return err
+  }
+  path, err := s.getPausedClusterPath()
+  if err != nil {
+    return err
+  }
+  return os.Remove(path)
+}
+
+/**
+ * Starts or stops the given instances, region by region, and waits for them
+ * to be running or stopped
+ */
+func setInstancesRunning(instances []ClusterInstance, running bool) error {
+  byRegion := make(map[string][]*string)
+  for _, instance := range instances {
+    byRegion[instance.Region] = append(byRegion[instance.Region], aws.String(instance.ID))
+  }
+
+  for region, ids := range byRegion {
+    sess, err := GetAWSSession(region)
+    if err != nil {
+      return err
+    }
+    svc := ec2.New(sess)
+    describe := &ec2.DescribeInstancesInput{InstanceIds: ids}
+
+    if running {
+      PrintInfo("Starting %d instance(s) in %s", len(ids), region)
+      if _, err := svc.StartInstances(&ec2.StartInstancesInput{InstanceIds: ids}); err != nil {
+        return fmt.Errorf("Could not start the instances: %s", err.Error())
+      }
+      if err := svc.WaitUntilInstanceRunning(describe); err != nil {
+        return fmt.Errorf("The instances did not start: %s", err.Error())
+      }
+    } else {
+      PrintInfo("Stopping %d instance(s) in %s", len(ids), region)
+      if _, err := svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: ids}); err != nil {
+        return fmt.Errorf("Could not stop the instances: %s", err.Error())
+      }
+      if err := svc.WaitUntilInstanceStopped(describe); err != nil {
+        return fmt.Errorf("The instances did not stop: %s", err.Error())
+      }
+    }
+  }
+  return nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "regexp"
+  "runtime"
+  "strings"
+)
+
+/**
+ * The package manager that installed terraform-wheels, that must be used to
+ * upgrade it instead of replacing its files
+ */
+type PackageManager struct {
+  Name    string
+  Package string
+}
+
+// The formula of a Homebrew installation, eg. /opt/homebrew/Cellar/<formula>/0.4.1/bin
+var brewCellarPattern *regexp.Regexp = regexp.MustCompile(`/Cellar/([^/]+)/`)
+
+/**
+ * Finds out from the location of the binary if it was installed with
+ * Homebrew or apt, or returns nil
+ */
+func DetectPackageManager() *PackageManager {
+  exe, err := os.Executable()
+  if err != nil {
+    return nil
+  }
+  if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+    exe = resolved
+  }
+  exe = filepath.ToSlash(exe)
+
+  if m := brewCellarPattern.FindStringSubmatch(exe); m != nil {
+    return &PackageManager{"Homebrew", m[1]}
+  }
+  if strings.HasPrefix(exe, "/opt/homebrew/") || strings.Contains(exe, "/.linuxbrew/") {
+    return &PackageManager{"Homebrew", "terraform-wheels"}
+  }
+
+  // Only the system directories are managed by dpkg, not /usr/local
+  if runtime.GOOS != "linux" || strings.HasPrefix(exe, "/usr/local/") {
+    return nil
+  }
+  if !strings.HasPrefix(exe, "/usr/") && !strings.HasPrefix(exe, "/bin/") && !strings.HasPrefix(exe, "/opt/") {
+    return nil
+  }
+  if _, err := exec.LookPath("dpkg"); err != nil {
+    return nil
+  }
+  code, sout, _, err := ExecuteAndCollect([]string{}, "dpkg", "-S", exe)
+  if err != nil || code != 0 {
+    return nil
+  }
+
+  // eg. "terraform-wheels: /usr/bin/terraform-wheels"
+  pkg := strings.TrimSpace(strings.SplitN(sout, ":", 2)[0])
+  if pkg == "" {
+    return nil
+  }
+  return &PackageManager{"apt", pkg}
+}
+
+/**
+ * Returns the commands that upgrade terraform-wheels with the package
+ * manager, to the given version or to the latest one if empty
+ */
+func (m *PackageManager) GetUpgradeCommands(version string) ([][]string, error) {
+  switch m.Name {
+  case "Homebrew":
+    if version != "" {
+      return nil, fmt.Errorf("Homebrew cannot install a specific version of %s", m.Package)
+    }
+    return [][]string{{"brew", "upgrade", m.Package}}, nil
+
+  case "apt":
+    update := []string{"sudo", "apt-get", "update"}
+    if version != "" {
+      return [][]string{update, {"sudo", "apt-get", "install", "--allow-downgrades", m.Package + "=" + strings.TrimPrefix(version, "v")}}, nil
+    }
+    return [][]string{update, {"sudo", "apt-get", "install", "--only-upgrade", m.Package}}, nil
+  }
+  return nil, fmt.Errorf("Unknown package manager %s", m.Name)
+}
+
+/**
+ * Returns the upgrade commands as a single shell command line
+ */
+func FormatUpgradeCommands(cmds [][]string) string {
+  var lines []string
+  for _, cmd := range cmds {
+    lines = append(lines, strings.Join(cmd, " "))
+  }
+  return strings.Join(lines, " && ")
+}
+
+/**
+ * Runs the upgrade commands, stopping at the first one that fails
+ */
+func RunUpgradeCommands(cmds [][]string) error {
+  for _, cmd := range cmds {
+    code, err := ExecuteAndPassthrough([]string{}, cmd[0], cmd[1:]...)
+    if err != nil {
+      return fmt.Errorf("Could not run %s: %s", cmd[0], err.Error())
+    }
+    if code != 0 {
+      return fmt.Errorf("`%s` exited with code %d", strings.Join(cmd, " "), code)
+    }
+  }
+  return nil
+}
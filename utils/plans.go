@@ -0,0 +1,230 @@
+package utils
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os/user"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// Where the saved plans are archived, with what is known about them
+var plansArchiveDir string = "plans"
+
+/**
+ * Somebody who approved a plan
+ */
+type PlanApproval struct {
+  By      string    `json:"by"`
+  At      time.Time `json:"at"`
+  Comment string    `json:"comment,omitempty"`
+}
+
+/**
+ * The archived record of a saved plan, from its creation to its apply
+ */
+type PlanRecord struct {
+  ID        string         `json:"id"`
+  PlanFile  string         `json:"plan_file"`
+  Checksum  string         `json:"sha256"`
+  Workspace string         `json:"workspace"`
+  CreatedBy string         `json:"created_by"`
+  CreatedAt time.Time      `json:"created_at"`
+  GitCommit string         `json:"git_commit,omitempty"`
+  GitDirty  bool           `json:"git_dirty,omitempty"`
+  Args      []string       `json:"args,omitempty"`
+  Impact    string         `json:"impact"`
+  Changes   []PlanChange   `json:"changes"`
+  Approvals []PlanApproval `json:"approvals,omitempty"`
+  AppliedBy string         `json:"applied_by,omitempty"`
+  AppliedAt *time.Time     `json:"applied_at,omitempty"`
+}
+
+/**
+ * Returns the name of the user running terraform-wheels
+ */
+func GetCurrentUserName() string {
+  if u, err := user.Current(); err == nil {
+    return u.Username
+  }
+  return "somebody"
+}
+
+func getFileChecksum(path string) (string, error) {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return "", fmt.Errorf("Could not read %s: %s", path, err.Error())
+  }
+  sum := sha256.Sum256(content)
+  return hex.EncodeToString(sum[:]), nil
+}
+
+/**
+ * Returns the git commit of the project and if it has uncommitted changes,
+ * or an empty commit if the project is not in a git repository
+ */
+func (s *ProjectSandbox) getGitCommit() (string, bool) {
+  code, sout, _, err := ExecuteAndCollect(nil, "git", "-C", s.baseDir, "rev-parse", "HEAD")
+  if err != nil || code != 0 {
+    return "", false
+  }
+  _, status, _, _ := ExecuteAndCollect(nil, "git", "-C", s.baseDir, "status", "--porcelain", "--", ".")
+  return strings.TrimSpace(sout), strings.TrimSpace(status) != ""
+}
+
+/**
+ * @brief      Archives the given saved plan in .wheels/plans, with who made
+ *             it, when, from which git commit, and the changes it contains
+ */
+func (s *ProjectSandbox) ArchivePlan(planFile string, output string, args []string) (*PlanRecord, error) {
+  checksum, err := getFileChecksum(planFile)
+  if err != nil {
+    return nil, err
+  }
+
+  now := time.Now().UTC()
+  record := &PlanRecord{
+    ID:        fmt.Sprintf("%s-%s", now.Format("20060102-150405"), checksum[:8]),
+    PlanFile:  planFile,
+    Checksum:  checksum,
+    Workspace: s.GetWorkspace(),
+    CreatedBy: GetCurrentUserName(),
+    CreatedAt: now,
+    Args:      args,
+    Changes:   ParsePlanChanges(output),
+  }
+  record.Impact = GetPlanImpact(record.Changes)
+  record.GitCommit, record.GitDirty = s.getGitCommit()
+
+  // Keep a copy of the plan, so it can be inspected even if the file is
+  // replaced by another plan
+  planCopy, err := s.GetWheelsPath(filepath.Join(plansArchiveDir, record.ID+".tfplan"))
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(planFile)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", planFile, err.Error())
+  }
+  if err := ioutil.WriteFile(planCopy, content, 0600); err != nil {
+    return nil, fmt.Errorf("Could not archive the plan: %s", err.Error())
+  }
+
+  return record, s.SavePlanRecord(record)
+}
+
+/**
+ * Writes the given plan record next to the archived plan
+ */
+func (s *ProjectSandbox) SavePlanRecord(record *PlanRecord) error {
+  fPath, err := s.GetWheelsPath(filepath.Join(plansArchiveDir, record.ID+".json"))
+  if err != nil {
+    return err
+  }
+  content, err := json.MarshalIndent(record, "", "  ")
+  if err != nil {
+    return fmt.Errorf("Could not encode the plan record: %s", err.Error())
+  }
+  if err := ioutil.WriteFile(fPath, content, 0644); err != nil {
+    return fmt.Errorf("Could not save the plan record: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * @brief      Finds the archived record of the given plan file, by its
+ *             contents
+ */
+func (s *ProjectSandbox) FindPlanRecord(planFile string) (*PlanRecord, error) {
+  checksum, err := getFileChecksum(planFile)
+  if err != nil {
+    return nil, err
+  }
+
+  files, _ := filepath.Glob(filepath.Join(s.baseDir, ".wheels", plansArchiveDir, "*.json"))
+  for _, file := range files {
+    content, err := ioutil.ReadFile(file)
+    if err != nil {
+      continue
+    }
+    var record PlanRecord
+    if err := json.Unmarshal(content, &record); err != nil {
+      PrintWarning("Could not parse %s: %s", file, err.Error())
+      continue
+    }
+    if record.Checksum == checksum {
+      return &record, nil
+    }
+  }
+  return nil, fmt.Errorf("%s was not created with wheels-plan, or it was modified since", planFile)
+}
+
+/**
+ * Returns how many approvals the given plan needs
+ */
+func (s *ProjectSandbox) GetRequiredApprovals(record *PlanRecord) int {
+  cfg := s.GetConfig().Plans
+  if cfg.HighImpactOnly && record.Impact != "high" {
+    return 0
+  }
+  return cfg.RequiredApprovals
+}
+
+/**
+ * @brief      Records the approval of a plan by the given user
+ */
+func (s *ProjectSandbox) ApprovePlan(record *PlanRecord, by string, comment string) error {
+  if record.AppliedAt != nil {
+    return fmt.Errorf("The plan was already applied")
+  }
+  if by == record.CreatedBy && !s.GetConfig().Plans.AllowSelfApproval {
+    return fmt.Errorf("The plan was created by %s, it has to be approved by somebody else", by)
+  }
+  for _, approval := range record.Approvals {
+    if approval.By == by {
+      return fmt.Errorf("The plan was already approved by %s", by)
+    }
+  }
+
+  record.Approvals = append(record.Approvals, PlanApproval{By: by, At: time.Now().UTC(), Comment: comment})
+  return s.SavePlanRecord(record)
+}
+
+/**
+ * @brief      Checks that the given plan can be applied: it was not applied
+ *             yet, it's for the current workspace, it's not too old and it
+ *             has all the approvals it needs
+ */
+func (s *ProjectSandbox) CheckPlanApplicable(record *PlanRecord) error {
+  if record.AppliedAt != nil {
+    return fmt.Errorf("The plan was already applied by %s on %s", record.AppliedBy, record.AppliedAt.Local().Format("2006-01-02 15:04"))
+  }
+  if ws := s.GetWorkspace(); record.Workspace != ws {
+    return fmt.Errorf("The plan was made for the '%s' workspace, but '%s' is selected", record.Workspace, ws)
+  }
+
+  if maxAge := ParseConfigDuration(s.GetConfig().Plans.MaxAge, 0); maxAge > 0 {
+    if age := time.Since(record.CreatedAt); age > maxAge {
+      return fmt.Errorf("The plan is %s old, more than the %s allowed, please make a new one", age.Round(time.Minute), maxAge)
+    }
+  }
+
+  if required := s.GetRequiredApprovals(record); len(record.Approvals) < required {
+    return fmt.Errorf("The plan has %d of the %d approval(s) it needs, use wheels-approve-plan to approve it", len(record.Approvals), required)
+  }
+  return nil
+}
+
+/**
+ * Remembers that the plan was applied, so it's not applied twice
+ */
+func (s *ProjectSandbox) MarkPlanApplied(record *PlanRecord) error {
+  now := time.Now().UTC()
+  record.AppliedBy = GetCurrentUserName()
+  record.AppliedAt = &now
+  return s.SavePlanRecord(record)
+}
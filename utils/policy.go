@@ -0,0 +1,566 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/gobwas/glob"
+  "gopkg.in/yaml.v3"
+)
+
+// The policies of the project are in .wheels/policies, as YAML rules or rego
+var policiesDir string = "policies"
+
+// The rego policies give their violations in `deny`, in the wheels package
+var regoQuery string = "data.wheels.deny"
+
+// The attributes of the providers that the policies can check (never their
+// credentials)
+var policyProviderAttributes []string = []string{"region", "zone", "project", "location"}
+
+/**
+ * A rule of a YAML policy. It applies to the resources matching Resource
+ * (their type) and Address, or to a Provider, and either limits their number
+ * (MaxCount) or the values of their Attribute (Allowed or Denied), with globs.
+ */
+type PolicyRule struct {
+  Name        string   `yaml:"name"`
+  Description string   `yaml:"description,omitempty"`
+  Resource    string   `yaml:"resource,omitempty"`
+  Address     string   `yaml:"address,omitempty"`
+  Provider    string   `yaml:"provider,omitempty"`
+  Attribute   string   `yaml:"attribute,omitempty"`
+  Allowed     []string `yaml:"allowed,omitempty"`
+  Denied      []string `yaml:"denied,omitempty"`
+  MaxCount    *int     `yaml:"max_count,omitempty"`
+}
+
+/**
+ * A YAML policy, with its rules
+ */
+type PolicyFile struct {
+  Path  string       `yaml:"-"`
+  Rules []PolicyRule `yaml:"rules"`
+}
+
+/**
+ * A rule that the plan does not follow
+ */
+type PolicyViolation struct {
+  Policy  string
+  Address string
+  Message string
+}
+
+func (v PolicyViolation) String() string {
+  if v.Address == "" {
+    return fmt.Sprintf("%s: %s", v.Policy, v.Message)
+  }
+  return fmt.Sprintf("%s: %s %s", v.Policy, v.Address, v.Message)
+}
+
+/**
+ * A resource of the project, as it's addressed by terraform
+ */
+type PlanResource struct {
+  Address string `json:"address"`
+  Module  string `json:"module,omitempty"`
+  Type    string `json:"type"`
+  Name    string `json:"name"`
+}
+
+/**
+ * A change of a resource in the plan, with its (flattened) attributes before
+ * and after it. The values that are not known yet are null.
+ */
+type PlanResourceChange struct {
+  PlanResource
+  Action string                 `json:"action"`
+  Before map[string]interface{} `json:"before"`
+  After  map[string]interface{} `json:"after"`
+}
+
+/**
+ * What the policies are evaluated against: the changes of the plan, and the
+ * resources of the project once it's applied
+ */
+type PolicyInput struct {
+  Workspace       string                            `json:"workspace"`
+  Providers       map[string]map[string]interface{} `json:"providers"`
+  ResourceChanges []PlanResourceChange              `json:"resource_changes"`
+  Resources       []PlanResource                    `json:"resources"`
+}
+
+/**
+ * Returns the module, the type and the name of a resource address (eg.
+ * `module.dcos.aws_instance.agent[2]`)
+ */
+func parseResourceAddress(address string) PlanResource {
+  resource := PlanResource{Address: address}
+  parts := strings.Split(address, ".")
+  var modules []string
+  for len(parts) > 2 && parts[0] == "module" {
+    modules = append(modules, "module."+parts[1])
+    parts = parts[2:]
+  }
+  resource.Module = strings.Join(modules, ".")
+  if len(parts) > 2 && parts[0] == "data" {
+    parts = parts[1:]
+  }
+  if len(parts) > 0 {
+    resource.Type = parts[0]
+  }
+  if len(parts) > 1 {
+    resource.Name = regexp.MustCompile(`\[.*\]$`).ReplaceAllString(parts[1], "")
+  }
+  return resource
+}
+
+/**
+ * Parses a value of the plan output: "value", <computed>, or the number of
+ * elements of a list
+ */
+func parsePlanValue(value string) interface{} {
+  value = strings.TrimSpace(value)
+  if strings.HasPrefix(value, `"`) {
+    if unquoted, err := strconv.Unquote(value); err == nil {
+      return unquoted
+    }
+    return strings.Trim(value, `"`)
+  }
+  if strings.HasPrefix(value, "<") {
+    return nil
+  }
+  return value
+}
+
+/**
+ * Extracts the resource changes, with their attributes, from the (uncolored)
+ * output of `terraform plan` or `terraform show <plan>`
+ */
+func ParsePlanResourceChanges(output string) []PlanResourceChange {
+  reResource := regexp.MustCompile(`^\s*(-/\+|\+/-|<=|\+|-|~)\s+([A-Za-z0-9_.\-\[\]"]+)(\s+\(.*\))?$`)
+  reAttribute := regexp.MustCompile(`^\s+([^\s:]+):\s+(.*?)(\s+\(forces new resource\))?$`)
+  reColors := regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+  var changes []PlanResourceChange
+  var current *PlanResourceChange
+  for _, line := range strings.Split(reColors.ReplaceAllString(output, ""), "\n") {
+    if m := reResource.FindStringSubmatch(line); m != nil && strings.Contains(m[2], ".") {
+      changes = append(changes, PlanResourceChange{
+        PlanResource: parseResourceAddress(m[2]),
+        Action:       planActions[m[1]],
+        Before:       make(map[string]interface{}),
+        After:        make(map[string]interface{}),
+      })
+      current = &changes[len(changes)-1]
+      continue
+    }
+    if strings.TrimSpace(line) == "" {
+      current = nil
+      continue
+    }
+
+    m := reAttribute.FindStringSubmatch(line)
+    if current == nil || m == nil {
+      continue
+    }
+    values := strings.SplitN(m[2], " => ", 2)
+    switch {
+    case len(values) == 2:
+      current.Before[m[1]] = parsePlanValue(values[0])
+      current.After[m[1]] = parsePlanValue(values[1])
+    case current.Action == "destroy":
+      current.Before[m[1]] = parsePlanValue(values[0])
+    default:
+      current.After[m[1]] = parsePlanValue(values[0])
+    }
+  }
+  return changes
+}
+
+/**
+ * Checks if the project has policies in .wheels/policies
+ */
+func (s *ProjectSandbox) HasPolicies() bool {
+  files, _ := s.listPolicyFiles()
+  return len(files) > 0
+}
+
+func (s *ProjectSandbox) listPolicyFiles() ([]string, error) {
+  dir := filepath.Join(s.baseDir, ".wheels", policiesDir)
+  entries, err := ioutil.ReadDir(dir)
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, fmt.Errorf("Could not list the policies: %s", err.Error())
+  }
+
+  var files []string
+  for _, entry := range entries {
+    ext := filepath.Ext(entry.Name())
+    if !entry.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".rego") {
+      files = append(files, filepath.Join(dir, entry.Name()))
+    }
+  }
+  sort.Strings(files)
+  return files, nil
+}
+
+/**
+ * Returns the YAML policies of the project, and the paths of the rego ones
+ */
+func (s *ProjectSandbox) LoadPolicies() ([]PolicyFile, []string, error) {
+  files, err := s.listPolicyFiles()
+  if err != nil {
+    return nil, nil, err
+  }
+
+  var policies []PolicyFile
+  var regoFiles []string
+  for _, file := range files {
+    if filepath.Ext(file) == ".rego" {
+      regoFiles = append(regoFiles, file)
+      continue
+    }
+
+    content, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, nil, fmt.Errorf("Could not read the policy %s: %s", filepath.Base(file), err.Error())
+    }
+    policy := PolicyFile{Path: file}
+    if err := yaml.Unmarshal(content, &policy); err != nil {
+      return nil, nil, WithExitCode(ExitConfigError, fmt.Errorf("Could not parse the policy %s: %s", filepath.Base(file), err.Error()))
+    }
+    for i, rule := range policy.Rules {
+      if err := rule.check(); err != nil {
+        return nil, nil, WithExitCode(ExitConfigError, fmt.Errorf("Invalid rule %d of the policy %s: %s", i+1, filepath.Base(file), err.Error()))
+      }
+    }
+    policies = append(policies, policy)
+  }
+  return policies, regoFiles, nil
+}
+
+func (r *PolicyRule) check() error {
+  if r.Name == "" {
+    return fmt.Errorf("it has no name")
+  }
+  checks := 0
+  if r.MaxCount != nil {
+    checks += 1
+  }
+  if len(r.Allowed) > 0 || len(r.Denied) > 0 {
+    checks += 1
+    if r.Attribute == "" {
+      return fmt.Errorf("allowed and denied need an attribute")
+    }
+  }
+  if checks != 1 {
+    return fmt.Errorf("it needs either max_count, or allowed or denied values")
+  }
+  if r.Provider != "" && r.MaxCount != nil {
+    return fmt.Errorf("max_count only applies to resources, not to a provider")
+  }
+  for _, pattern := range append(append([]string{r.Resource, r.Address, r.Attribute}, r.Allowed...), r.Denied...) {
+    if _, err := glob.Compile(pattern); err != nil {
+      return fmt.Errorf("invalid pattern '%s': %s", pattern, err.Error())
+    }
+  }
+  return nil
+}
+
+func matchesGlob(pattern string, value string) bool {
+  return pattern == "" || glob.MustCompile(pattern).Match(value)
+}
+
+func matchesAnyGlob(patterns []string, value string) bool {
+  for _, pattern := range patterns {
+    if glob.MustCompile(pattern).Match(value) {
+      return true
+    }
+  }
+  return false
+}
+
+func (r *PolicyRule) matchesResource(resource PlanResource) bool {
+  return r.Provider == "" && matchesGlob(r.Resource, resource.Type) && matchesGlob(r.Address, resource.Address)
+}
+
+/**
+ * Returns why the value of the attribute breaks the rule, or an empty string
+ */
+func (r *PolicyRule) checkValue(name string, value interface{}) string {
+  str := fmt.Sprintf("%v", value)
+  if len(r.Allowed) > 0 && !matchesAnyGlob(r.Allowed, str) {
+    return fmt.Sprintf("has %s = %s, expected %s", name, str, joinAlternatives(r.Allowed))
+  }
+  if matchesAnyGlob(r.Denied, str) {
+    return fmt.Sprintf("has %s = %s, that is not allowed", name, str)
+  }
+  return ""
+}
+
+func (r *PolicyRule) evaluate(input *PolicyInput) []PolicyViolation {
+  var violations []PolicyViolation
+  addViolation := func(address string, message string) {
+    if r.Description != "" {
+      message += " (" + r.Description + ")"
+    }
+    violations = append(violations, PolicyViolation{r.Name, address, message})
+  }
+
+  switch {
+  case r.MaxCount != nil:
+    count := 0
+    for _, resource := range input.Resources {
+      if r.matchesResource(resource) {
+        count += 1
+      }
+    }
+    if count > *r.MaxCount {
+      addViolation("", fmt.Sprintf("%d resources match, at most %d are allowed", count, *r.MaxCount))
+    }
+
+  case r.Provider != "":
+    for name, value := range input.Providers[r.Provider] {
+      if matchesGlob(r.Attribute, name) {
+        if reason := r.checkValue(name, value); reason != "" {
+          addViolation("provider."+r.Provider, reason)
+        }
+      }
+    }
+
+  default:
+    for _, change := range input.ResourceChanges {
+      if change.Action == "destroy" || change.Action == "read" || !r.matchesResource(change.PlanResource) {
+        continue
+      }
+      var names []string
+      for name := range change.After {
+        names = append(names, name)
+      }
+      sort.Strings(names)
+      for _, name := range names {
+        value := change.After[name]
+        if value == nil || !matchesGlob(r.Attribute, name) {
+          continue
+        }
+        if reason := r.checkValue(name, value); reason != "" {
+          addViolation(change.Address, reason)
+        }
+      }
+    }
+  }
+
+  return violations
+}
+
+/**
+ * Returns what the policies are evaluated against, from the output of the
+ * plan (or of `terraform show <plan>`)
+ */
+func (s *ProjectSandbox) CreatePolicyInput(tf *TerraformWrapper, planOutput string) (*PolicyInput, error) {
+  input := &PolicyInput{
+    Workspace:       s.GetWorkspace(),
+    Providers:       make(map[string]map[string]interface{}),
+    ResourceChanges: ParsePlanResourceChanges(planOutput),
+    Resources:       []PlanResource{},
+  }
+  if input.ResourceChanges == nil {
+    input.ResourceChanges = []PlanResourceChange{}
+  }
+
+  for name, provider := range s.GetTerraformResources("provider") {
+    attributes := make(map[string]interface{})
+    for _, key := range policyProviderAttributes {
+      if value, ok := s.ResolveTerraformValue(provider[key]).(string); ok && value != "" {
+        attributes[key] = value
+      }
+    }
+    if name == "aws" {
+      attributes["region"] = s.GetAWSRegion()
+    }
+    input.Providers[name] = attributes
+  }
+
+  // The resources once applied: the ones of the state, with the changes
+  existing, err := tf.ListStateResources()
+  if err != nil {
+    return nil, err
+  }
+  addresses := make(map[string]bool)
+  for _, address := range existing {
+    addresses[address] = true
+  }
+  for _, change := range input.ResourceChanges {
+    switch change.Action {
+    case "create", "replace":
+      addresses[change.Address] = true
+    case "destroy":
+      delete(addresses, change.Address)
+    }
+  }
+  var sorted []string
+  for address := range addresses {
+    if !strings.HasPrefix(address, "data.") && !strings.Contains(address, ".data.") {
+      sorted = append(sorted, address)
+    }
+  }
+  sort.Strings(sorted)
+  for _, address := range sorted {
+    input.Resources = append(input.Resources, parseResourceAddress(address))
+  }
+
+  // The aws provider is often only configured by the modules
+  if _, ok := input.Providers["aws"]; !ok {
+    var types []string
+    for _, change := range input.ResourceChanges {
+      types = append(types, change.Type)
+    }
+    for _, resource := range input.Resources {
+      types = append(types, resource.Type)
+    }
+    for _, resType := range types {
+      if strings.HasPrefix(resType, "aws_") {
+        input.Providers["aws"] = map[string]interface{}{"region": s.GetAWSRegion()}
+        break
+      }
+    }
+  }
+  return input, nil
+}
+
+/**
+ * Evaluates the policies of the project against the given input, returning
+ * all the violations
+ */
+func (s *ProjectSandbox) EvaluatePolicies(input *PolicyInput) ([]PolicyViolation, error) {
+  policies, regoFiles, err := s.LoadPolicies()
+  if err != nil {
+    return nil, err
+  }
+
+  var violations []PolicyViolation
+  for _, policy := range policies {
+    for _, rule := range policy.Rules {
+      violations = append(violations, rule.evaluate(input)...)
+    }
+  }
+
+  for _, file := range regoFiles {
+    regoViolations, err := evaluateRegoPolicy(file, input)
+    if err != nil {
+      return nil, err
+    }
+    violations = append(violations, regoViolations...)
+  }
+  return violations, nil
+}
+
+/**
+ * Evaluates a rego policy with `opa`. Its `deny` rule (in the wheels package)
+ * gives the messages of the violations, or objects with a msg and an address.
+ */
+func evaluateRegoPolicy(file string, input *PolicyInput) ([]PolicyViolation, error) {
+  name := strings.TrimSuffix(filepath.Base(file), ".rego")
+  opa, err := exec.LookPath("opa")
+  if err != nil {
+    return nil, WithExitCode(ExitConfigError, fmt.Errorf("The policy %s needs `opa` (https://www.openpolicyagent.org/docs/latest/#running-opa) in the PATH", filepath.Base(file)))
+  }
+
+  content, err := json.Marshal(input)
+  if err != nil {
+    return nil, fmt.Errorf("Could not encode the policy input: %s", err.Error())
+  }
+  inputFile, err := ioutil.TempFile("", "wheels-policy-*.json")
+  if err != nil {
+    return nil, fmt.Errorf("Could not create the policy input: %s", err.Error())
+  }
+  defer os.Remove(inputFile.Name())
+  inputFile.Write(content)
+  inputFile.Close()
+
+  code, sout, serr, err := ExecuteAndCollect(nil, opa, "eval", "--format=json", "--data", file, "--input", inputFile.Name(), regoQuery)
+  if err != nil {
+    return nil, err
+  }
+  if code != 0 {
+    return nil, fmt.Errorf("Could not evaluate the policy %s: %s", filepath.Base(file), strings.TrimSpace(serr+sout))
+  }
+
+  var result struct {
+    Result []struct {
+      Expressions []struct {
+        Value []interface{} `json:"value"`
+      } `json:"expressions"`
+    } `json:"result"`
+  }
+  if err := json.Unmarshal([]byte(sout), &result); err != nil {
+    return nil, fmt.Errorf("Could not parse the result of the policy %s: %s", filepath.Base(file), err.Error())
+  }
+
+  var violations []PolicyViolation
+  for _, r := range result.Result {
+    for _, expression := range r.Expressions {
+      for _, value := range expression.Value {
+        switch v := value.(type) {
+        case string:
+          violations = append(violations, PolicyViolation{name, "", v})
+        case map[string]interface{}:
+          msg, _ := v["msg"].(string)
+          address, _ := v["address"].(string)
+          violations = append(violations, PolicyViolation{name, address, msg})
+        }
+      }
+    }
+  }
+  return violations, nil
+}
+
+/**
+ * Prints the violations of the policies
+ */
+func PrintPolicyViolations(violations []PolicyViolation) {
+  for _, v := range violations {
+    PrintWarning("Policy %s", v.String())
+  }
+}
+
+/**
+ * Checks the plan of the given `apply` run against the policies of the
+ * project, returning an error if it breaks any of them
+ */
+func (s *ProjectSandbox) CheckPlanPolicies(tf *TerraformWrapper, args []string) error {
+  output, err := s.GetApplyPlanOutput(tf, args)
+  if err != nil {
+    return err
+  }
+  return s.checkPlanOutputPolicies(tf, output)
+}
+
+func (s *ProjectSandbox) checkPlanOutputPolicies(tf *TerraformWrapper, output string) error {
+  PrintInfo("Checking the plan against the policies of the project")
+  input, err := s.CreatePolicyInput(tf, output)
+  if err != nil {
+    return err
+  }
+  violations, err := s.EvaluatePolicies(input)
+  if err != nil {
+    return err
+  }
+  if len(violations) == 0 {
+    return nil
+  }
+
+  PrintPolicyViolations(violations)
+  return WithExitCode(ExitValidationFailed, fmt.Errorf("The plan breaks %d policy rule(s) of .wheels/policies, not applying it", len(violations)))
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+/**
+ * A change of a resource in a terraform plan
+ */
+type PlanChange struct {
+  Action  string `json:"action"`
+  Address string `json:"address"`
+  Impact  string `json:"impact"`
+}
+
+// The actions of the plan output, by their symbol
+var planActions map[string]string = map[string]string{
+  "+":   "create",
+  "-":   "destroy",
+  "~":   "update",
+  "-/+": "replace",
+  "+/-": "replace",
+  "<=":  "read",
+}
+
+// Resources that take data or the cluster with them when destroyed
+var criticalResourcePattern *regexp.Regexp = regexp.MustCompile(`master|bootstrap|aws_s3_bucket|aws_ebs_volume|aws_db_instance|aws_lb|aws_elb|aws_vpc|aws_subnet|aws_efs`)
+
+// Resources whose changes affect the access to the cluster
+var accessResourcePattern *regexp.Regexp = regexp.MustCompile(`security_group|aws_iam|aws_lb|aws_elb|aws_key_pair|aws_route`)
+
+/**
+ * Returns the impact of a change: "high" when it can lose data or take the
+ * cluster down, "medium" when it affects running machines or the access to
+ * the cluster, "low" otherwise
+ */
+func classifyPlanChange(action string, address string) string {
+  switch action {
+  case "destroy", "replace":
+    if criticalResourcePattern.MatchString(address) {
+      return "high"
+    }
+    return "medium"
+  case "update":
+    if accessResourcePattern.MatchString(address) || strings.Contains(address, "aws_instance") {
+      return "medium"
+    }
+  }
+  return "low"
+}
+
+/**
+ * Extracts the resource changes from the (uncolored) output of
+ * `terraform plan`
+ */
+func ParsePlanChanges(output string) []PlanChange {
+  re := regexp.MustCompile(`^\s*(-/\+|\+/-|<=|\+|-|~)\s+([A-Za-z0-9_.\-\[\]"]+)(\s+\(.*\))?$`)
+  reColors := regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+  var changes []PlanChange
+  for _, line := range strings.Split(reColors.ReplaceAllString(output, ""), "\n") {
+    m := re.FindStringSubmatch(line)
+    if m == nil || !strings.Contains(m[2], ".") {
+      continue
+    }
+    action := planActions[m[1]]
+    changes = append(changes, PlanChange{action, m[2], classifyPlanChange(action, m[2])})
+  }
+  return changes
+}
+
+/**
+ * Returns the highest impact of the given changes
+ */
+func GetPlanImpact(changes []PlanChange) string {
+  impact := "none"
+  for _, change := range changes {
+    if change.Impact == "high" {
+      return "high"
+    }
+    if change.Impact == "medium" || impact == "none" {
+      impact = change.Impact
+    }
+  }
+  return impact
+}
+
+/**
+ * What goes into a plan summary
+ */
+type PlanSummary struct {
+  ExitCode int
+  Output   string
+  Changes  []PlanChange
+
+  // The estimated monthly costs, before and after, if they are known
+  CostBefore *float64
+  CostAfter  *float64
+}
+
+var impactIcons map[string]string = map[string]string{
+  "none":   ":white_check_mark:",
+  "low":    ":large_blue_circle:",
+  "medium": ":warning:",
+  "high":   ":rotating_light:",
+}
+
+/**
+ * @brief      Renders the plan as Markdown, for a pull request comment
+ *
+ * The status follows `terraform plan -detailed-exitcode`: 0 means that there
+ * are no changes, 2 that there are some, anything else that the plan failed.
+ */
+func (p *PlanSummary) RenderMarkdown(title string) string {
+  var lines []string
+  lines = append(lines, fmt.Sprintf("### %s", title), "")
+
+  switch p.ExitCode {
+  case 0:
+    lines = append(lines, fmt.Sprintf("%s **No changes.** The infrastructure matches the configuration.", impactIcons["none"]))
+  case 2:
+    impact := GetPlanImpact(p.Changes)
+    counts := make(map[string]int)
+    for _, change := range p.Changes {
+      counts[change.Action]++
+    }
+    lines = append(lines, fmt.Sprintf("%s **%d change(s), %s impact:** %d to create, %d to update, %d to replace, %d to destroy.",
+      impactIcons[impact], len(p.Changes)-counts["read"], impact, counts["create"], counts["update"], counts["replace"], counts["destroy"]))
+  default:
+    lines = append(lines, fmt.Sprintf(":x: **The plan failed** (exit code %d).", p.ExitCode))
+  }
+
+  if p.CostBefore != nil && p.CostAfter != nil && *p.CostBefore != *p.CostAfter {
+    lines = append(lines, "", fmt.Sprintf("**Estimated monthly cost:** $%.2f → $%.2f (%+.2f)", *p.CostBefore, *p.CostAfter, *p.CostAfter-*p.CostBefore))
+  } else if p.CostAfter != nil {
+    lines = append(lines, "", fmt.Sprintf("**Estimated monthly cost:** $%.2f (unchanged)", *p.CostAfter))
+  }
+
+  if len(p.Changes) > 0 {
+    lines = append(lines, "", "| Action | Resource | Impact |", "|---|---|---|")
+    for _, change := range p.Changes {
+      lines = append(lines, fmt.Sprintf("| %s | `%s` | %s %s |", change.Action, change.Address, impactIcons[change.Impact], change.Impact))
+    }
+  }
+
+  if p.ExitCode != 0 && p.ExitCode != 2 {
+    var errors []string
+    for _, group := range SummarizeTerraformErrors(p.Output) {
+      errors = append(errors, fmt.Sprintf("- %s: %s (%s)", group.Code, group.Message, strings.Join(group.Addresses, ", ")))
+    }
+    if len(errors) > 0 {
+      lines = append(lines, "", "**Errors:**", "")
+      lines = append(lines, errors...)
+    }
+  }
+
+  lines = append(lines, "", "<details><summary>Plan output</summary>", "", "```", strings.TrimSpace(Redact(p.Output)), "```", "</details>")
+  return strings.Join(lines, "\n") + "\n"
+}
+
+/**
+ * @brief      Estimates the monthly cost of the project as it is in the
+ *             given git revision
+ *
+ * The terraform files of the project are extracted from git in a temporary
+ * directory, so the estimate uses the same price table and defaults.
+ */
+func (s *ProjectSandbox) EstimateCostAtRevision(table PriceTable, rev string) (float64, error) {
+  code, sout, serr, err := ExecuteAndCollect(nil, "git", "-C", s.baseDir, "ls-tree", "--name-only", rev, "./")
+  if err != nil {
+    return 0, err
+  }
+  if code != 0 {
+    return 0, fmt.Errorf("Could not list the files at %s: %s", rev, strings.TrimSpace(serr))
+  }
+
+  dir, err := ioutil.TempDir("", "wheels-cost")
+  if err != nil {
+    return 0, err
+  }
+  defer os.RemoveAll(dir)
+
+  for _, name := range strings.Split(strings.TrimSpace(sout), "\n") {
+    name = filepath.Base(name)
+    if !strings.HasSuffix(name, ".tf") && !strings.HasSuffix(name, ".tfvars") {
+      continue
+    }
+    _, content, _, err := ExecuteAndCollect(nil, "git", "-C", s.baseDir, "show", fmt.Sprintf("%s:./%s", rev, name))
+    if err != nil {
+      return 0, err
+    }
+    if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+      return 0, err
+    }
+  }
+
+  base, err := OpenSandbox(dir)
+  if err != nil {
+    return 0, err
+  }
+  items, err := base.EstimateCost(table)
+  if err != nil {
+    return 0, err
+  }
+  return GetMonthlyCost(items), nil
+}
+
+/**
+ * Posts the comment to a webhook, as a JSON object with the Markdown in both
+ * the `text` and `body` fields (that most chat and CI services understand)
+ */
+func PostCommentToWebhook(url string, markdown string) error {
+  return postJSON(url, nil, map[string]string{"text": markdown, "body": markdown})
+}
+
+/**
+ * Posts the comment to a GitHub pull request, with the given token
+ */
+func PostCommentToGitHub(repo string, pr int, token string, markdown string) error {
+  url := fmt.Sprintf("https://api.github.com/repos/%s/issues/%d/comments", repo, pr)
+  return postJSON(url, map[string]string{
+    "Authorization": "token " + token,
+    "Accept":        "application/vnd.github.v3+json",
+  }, map[string]string{"body": markdown})
+}
+
+func postJSON(url string, headers map[string]string, body interface{}) error {
+  payload, err := json.Marshal(body)
+  if err != nil {
+    return err
+  }
+  req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  for k, v := range headers {
+    req.Header.Set(k, v)
+  }
+
+  resp, err := getHttpClient(false).Do(req)
+  if err != nil {
+    return fmt.Errorf("Could not post the comment: %s", err.Error())
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    content, _ := ioutil.ReadAll(resp.Body)
+    return fmt.Errorf("Could not post the comment: %s: %s", resp.Status, strings.TrimSpace(string(content)))
+  }
+  return nil
+}
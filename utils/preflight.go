@@ -0,0 +1,408 @@
+package utils
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Skip the checks of the cloud before generating or applying (eg. the quotas)
+var skipPreflight bool = false
+
+// The AMIs of the nodes of the dcos-terraform AWS module, by role
+var dcosRoleAMIVariables []string = []string{"bootstrap_aws_ami", "masters_aws_ami", "private_agents_aws_ami", "public_agents_aws_ami"}
+
+/**
+ * A DC/OS cluster of the project, as far as its installation is concerned
+ */
+type DCOSClusterSpec struct {
+  Address      string
+  Region       string
+  Version      string
+  Variant      string
+  InstanceOS   string
+  AMIs         []string
+  // Some nodes run the AMIs of the operating system, not custom ones
+  UsesOSImages bool
+  InstallerURL string
+}
+
+/**
+ * Skips the checks of the cloud before generating or applying, eg. when they
+ * are wrong
+ */
+func SetSkipPreflight(skip bool) {
+  skipPreflight = skip
+}
+
+/**
+ * Checks if the project creates AWS resources, itself or with the DC/OS
+ * modules
+ */
+func (s *ProjectSandbox) usesAWS() bool {
+  if _, ok := s.GetTerraformResources("provider")["aws"]; ok {
+    return true
+  }
+  return len(s.GetTerraformResourcesMatchingName("resource", "aws_*")) > 0 ||
+    len(s.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws")) > 0
+}
+
+/**
+ * Checks if the AWS quotas are checked before applying: on AWS projects,
+ * when enabled with `preflight.quotas: true` and not skipped with
+ * --skip-preflight
+ */
+func (s *ProjectSandbox) isQuotaCheckEnabled() bool {
+  quotas := s.GetConfig().Preflight.Quotas
+  return !skipPreflight && !IsOffline() && quotas != nil && *quotas && s.usesAWS()
+}
+
+/**
+ * Checks if the DC/OS versions, operating systems and AMIs are checked
+ * before generating or applying, unless disabled with
+ * `preflight.compatibility: false` or --skip-preflight
+ */
+func (s *ProjectSandbox) isCompatCheckEnabled() bool {
+  compat := s.GetConfig().Preflight.Compatibility
+  return !skipPreflight && !IsOffline() && (compat == nil || *compat)
+}
+
+/**
+ * Returns the URL of the installer of the given DC/OS version
+ */
+func GetDCOSInstallerURL(version string, variant string) string {
+  if variant == "ee" {
+    return fmt.Sprintf("https://downloads.mesosphere.com/dcos-enterprise/stable/%s/dcos_generate_config.ee.sh", version)
+  }
+  return fmt.Sprintf("https://downloads.dcos.io/dcos/stable/%s/dcos_generate_config.sh", version)
+}
+
+/**
+ * Returns the DC/OS cluster configured by the given variables of the
+ * dcos-terraform AWS module (or the flags of add-aws-cluster)
+ */
+func CreateDCOSClusterSpec(address string, region string, getValue func(name string) string) DCOSClusterSpec {
+  spec := DCOSClusterSpec{
+    Address:      address,
+    Region:       region,
+    Version:      getValue("dcos_version"),
+    Variant:      getValue("dcos_variant"),
+    InstanceOS:   getValue("dcos_instance_os"),
+    InstallerURL: getValue("custom_dcos_download_path"),
+  }
+  if spec.Variant == "" {
+    spec.Variant = "open"
+  }
+
+  // aws_ami is the AMI of all the nodes, unless they have one of their own
+  spec.UsesOSImages = getValue("aws_ami") == ""
+  roleAMIs := 0
+  for _, name := range dcosRoleAMIVariables {
+    if getValue(name) != "" {
+      roleAMIs += 1
+    }
+  }
+  if roleAMIs == len(dcosRoleAMIVariables) {
+    spec.UsesOSImages = false
+  }
+  for _, name := range append([]string{"aws_ami"}, dcosRoleAMIVariables...) {
+    // The AMIs given by interpolation (eg. a data source) are checked by terraform
+    if ami := getValue(name); strings.HasPrefix(ami, "ami-") {
+      spec.AMIs = append(spec.AMIs, ami)
+    }
+  }
+  return spec
+}
+
+/**
+ * Returns the DC/OS clusters of the project, from the dcos-terraform AWS
+ * module
+ */
+func (s *ProjectSandbox) GetDCOSClusterSpecs() []DCOSClusterSpec {
+  var specs []DCOSClusterSpec
+  for _, mod := range s.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws") {
+    specs = append(specs, CreateDCOSClusterSpec(fmt.Sprintf("module.%s", mod["_name"]), s.GetAWSRegion(), func(name string) string {
+      str, _ := s.ResolveTerraformValue(mod[name]).(string)
+      return str
+    }))
+  }
+  sort.SliceStable(specs, func(i, j int) bool {
+    return specs[i].Address < specs[j].Address
+  })
+  return specs
+}
+
+/**
+ * Checks that a DC/OS cluster can be installed: that its version and its
+ * operating system are not a known-bad combination, that its installer can
+ * be downloaded, and that its AMIs (or the ones of its operating system)
+ * exist in its region. The checks that cannot be done (eg. without the AWS
+ * permissions) are skipped with a warning.
+ */
+func (s *ProjectSandbox) CheckDCOSCompatibility(spec DCOSClusterSpec) error {
+  if !s.isCompatCheckEnabled() || spec.Version == "" {
+    return nil
+  }
+  matrix, err := s.GetCompatMatrix()
+  if err != nil {
+    return err
+  }
+
+  if spec.InstanceOS != "" {
+    PrintInfo("Checking that DC/OS %s can be installed on %s in %s", spec.Version, spec.InstanceOS, spec.Region)
+  } else {
+    PrintInfo("Checking that DC/OS %s can be installed in %s", spec.Version, spec.Region)
+  }
+  var problems []string
+  for _, reason := range matrix.FindKnownIssues(spec.Version, spec.Variant, spec.InstanceOS) {
+    problems = append(problems, fmt.Sprintf("DC/OS %s does not work on %s: %s", spec.Version, spec.InstanceOS, reason))
+  }
+
+  installerURL := spec.InstallerURL
+  if installerURL == "" {
+    installerURL = GetDCOSInstallerURL(spec.Version, spec.Variant)
+  }
+  // The nodes download it themselves, so it's only wrong if it's not there
+  if exists, err := CheckURLExists(installerURL); err != nil {
+    PrintWarning("Could not check the installer of DC/OS %s: %s", spec.Version, err.Error())
+  } else if !exists {
+    problems = append(problems, fmt.Sprintf("There is no installer of DC/OS %s at %s", spec.Version, installerURL))
+  }
+
+  amiProblems, err := checkAWSImages(spec, matrix)
+  if err != nil {
+    PrintWarning("Could not check the AMIs: %s", err.Error())
+  }
+  problems = append(problems, amiProblems...)
+
+  if len(problems) == 0 {
+    return nil
+  }
+  for _, problem := range problems {
+    PrintWarning("%s", problem)
+  }
+  return WithExitCode(ExitValidationFailed, fmt.Errorf("DC/OS %s cannot be installed as configured in %s (use --skip-preflight to try anyway)", spec.Version, spec.Address))
+}
+
+/**
+ * Returns the AMIs of the cluster that do not exist in its region, or its
+ * operating system if there is no AMI of it there (and no custom AMI)
+ */
+func checkAWSImages(spec DCOSClusterSpec, matrix *CompatMatrix) ([]string, error) {
+  image := matrix.GetImage(spec.InstanceOS)
+  checkOSImages := spec.UsesOSImages && spec.InstanceOS != "" && image != nil
+  if len(spec.AMIs) == 0 && !checkOSImages {
+    return nil, nil
+  }
+  sess, err := GetAWSSession(spec.Region)
+  if err != nil {
+    return nil, err
+  }
+  svc := ec2.New(sess)
+
+  var problems []string
+  if len(spec.AMIs) > 0 {
+    out, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+      Filters: []*ec2.Filter{{Name: aws.String("image-id"), Values: aws.StringSlice(spec.AMIs)}},
+    })
+    if err != nil {
+      return nil, fmt.Errorf("Could not describe the AMIs: %s", err.Error())
+    }
+    found := make(map[string]bool)
+    for _, img := range out.Images {
+      found[aws.StringValue(img.ImageId)] = true
+    }
+    for _, ami := range spec.AMIs {
+      if !found[ami] {
+        problems = append(problems, fmt.Sprintf("The AMI %s does not exist in %s (or is not shared with this account)", ami, spec.Region))
+      }
+    }
+  }
+  if !checkOSImages {
+    return problems, nil
+  }
+
+  out, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+    Owners:  aws.StringSlice(image.Owners),
+    Filters: []*ec2.Filter{{Name: aws.String("name"), Values: aws.StringSlice([]string{image.Name})}},
+  })
+  if err != nil {
+    return problems, fmt.Errorf("Could not look for the AMIs of %s: %s", spec.InstanceOS, err.Error())
+  }
+  if len(out.Images) == 0 {
+    problems = append(problems, fmt.Sprintf("There is no AMI of %s in %s, use another operating system or a custom AMI", spec.InstanceOS, spec.Region))
+  }
+  return problems, nil
+}
+
+/**
+ * Returns the arguments of the plan that shows what the given `apply` would
+ * do, or the saved plan file that it applies
+ */
+func getApplyPlanArgs(args []string) ([]string, string) {
+  parsed := ParseTerraformArgs(args)
+  if planFile := parsed.GetSavedPlan(); planFile != "" {
+    return nil, planFile
+  }
+
+  planArgs := []string{"plan"}
+  for _, opt := range parsed.Options {
+    switch opt.Name {
+    case "auto-approve", "backup", "state-out", "no-color", "out", "detailed-exitcode":
+    default:
+      planArgs = append(planArgs, opt.Args...)
+    }
+  }
+  return append(planArgs, "-no-color", "-input=false"), ""
+}
+
+/**
+ * Returns the output of the plan of the given `apply` run (or `plan`), that
+ * is run without showing it
+ */
+func (s *ProjectSandbox) GetApplyPlanOutput(tf *TerraformWrapper, args []string) (string, error) {
+  planArgs, planFile := getApplyPlanArgs(args)
+  if planFile != "" {
+    return tf.InvokeAndCollect([]string{"show", "-no-color", planFile})
+  }
+  PrintInfo("Planning the changes, to check them before applying them")
+  return tf.InvokeAndCollect(planArgs)
+}
+
+/**
+ * Checks if the plans are checked before they are applied, against the
+ * policies of the project or the AWS quotas
+ */
+func (s *ProjectSandbox) HasPlanChecks() bool {
+  return s.HasPolicies() || s.isQuotaCheckEnabled()
+}
+
+/**
+ * Checks the output of `terraform show <plan>` against the policies of the
+ * project and the AWS quotas
+ */
+func (s *ProjectSandbox) checkPlanOutput(tf *TerraformWrapper, output string) error {
+  if s.HasPolicies() {
+    if err := s.checkPlanOutputPolicies(tf, output); err != nil {
+      return err
+    }
+  }
+  if s.isQuotaCheckEnabled() {
+    if err := s.CheckAWSQuotas(ParsePlanResourceChanges(output)); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/**
+ * Checks the given `apply` run before it changes anything: that DC/OS can be
+ * installed as configured and, when it applies a saved plan, that the plan
+ * follows the policies of the project and that the AWS quotas leave room
+ * for what it creates. The other runs are planned and checked by
+ * PlanCheckedApply.
+ */
+func (s *ProjectSandbox) CheckBeforeApply(tf *TerraformWrapper, args []string) error {
+  for _, spec := range s.GetDCOSClusterSpecs() {
+    if err := s.CheckDCOSCompatibility(spec); err != nil {
+      return err
+    }
+  }
+
+  planFile := ParseTerraformArgs(args).GetSavedPlan()
+  if planFile == "" || !s.HasPlanChecks() {
+    return nil
+  }
+  output, err := tf.InvokeAndCollect([]string{"show", "-no-color", planFile})
+  if err != nil {
+    return err
+  }
+  return s.checkPlanOutput(tf, output)
+}
+
+// The plan of the `apply` run that is checked, and then applied
+var checkedPlanFile string = "checked.tfplan"
+
+/**
+ * Returns the arguments that apply the given plan file, with the options of
+ * the given `apply` that still apply to it
+ */
+func getCheckedApplyArgs(args []string, planFile string) []string {
+  applyArgs := []string{"apply"}
+  for _, opt := range ParseTerraformArgs(args).Options {
+    switch opt.Name {
+    case "backup", "lock", "lock-timeout", "no-color", "parallelism", "state", "state-out":
+      applyArgs = append(applyArgs, opt.Args...)
+    }
+  }
+  return append(applyArgs, "-input=false", planFile)
+}
+
+/**
+ * @brief      Plans the given `apply` run in a plan file and checks it,
+ *             returning the arguments that apply this same plan
+ *
+ * Nothing can change between the checks and the apply, and the project is
+ * only refreshed once. Unless -auto-approve is given, the plan is shown and
+ * has to be confirmed, like terraform does.
+ */
+func (s *ProjectSandbox) PlanCheckedApply(tf *TerraformWrapper, args []string) ([]string, error) {
+  parsed := ParseTerraformArgs(args)
+  autoApprove, noInput := false, false
+  for _, opt := range parsed.Options {
+    switch opt.Name {
+    case "auto-approve":
+      autoApprove = opt.Value != "false"
+    case "input":
+      noInput = opt.Value == "false"
+    }
+  }
+  if noInput && !autoApprove {
+    return nil, WithExitCode(ExitConfigError, fmt.Errorf("The plan cannot be approved with -input=false, use -auto-approve"))
+  }
+
+  planFile, err := s.GetWheelsPath(checkedPlanFile)
+  if err != nil {
+    return nil, err
+  }
+  planArgs, _ := getApplyPlanArgs(args)
+  PrintInfo("Planning the changes, to check them before applying them")
+  if _, err := tf.InvokeAndCollect(append(planArgs, "-out="+planFile)); err != nil {
+    return nil, err
+  }
+  output, err := tf.InvokeAndCollect([]string{"show", "-no-color", planFile})
+  if err != nil {
+    return nil, err
+  }
+  if err := s.checkPlanOutput(tf, output); err != nil {
+    return nil, err
+  }
+
+  if !autoApprove && len(ParsePlanResourceChanges(output)) > 0 {
+    if err := tf.Invoke([]string{"show", planFile}); err != nil {
+      return nil, err
+    }
+    Println("Do you want to perform these actions?")
+    Println("  Terraform will perform the actions described above.")
+    Println("  Only 'yes' will be accepted to approve.")
+    Println("")
+    if ReadPrompt("  Enter a value") != "yes" {
+      Println("")
+      return nil, WithExitCode(ExitTerraformFailed, fmt.Errorf("Apply cancelled"))
+    }
+  }
+  return getCheckedApplyArgs(args, planFile), nil
+}
+
+/**
+ * Removes the plan of the last checked `apply`, that contains the values of
+ * the variables
+ */
+func (s *ProjectSandbox) RemoveCheckedPlan() {
+  os.Remove(filepath.Join(s.baseDir, ".wheels", checkedPlanFile))
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+
+  "gopkg.in/yaml.v3"
+)
+
+/**
+ * A package of a preset, with the options it's installed with
+ */
+type ServicePresetService struct {
+  Name    string                 `yaml:"name,omitempty"`
+  Package string                 `yaml:"package"`
+  Version string                 `yaml:"version,omitempty"`
+  Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+/**
+ * A curated set of packages, installed together with `add-package -preset`.
+ * A preset can extend another one, replacing its services with the same name
+ * and leaving out the excluded ones.
+ */
+type ServicePreset struct {
+  Description string                 `yaml:"description"`
+  Extends     string                 `yaml:"extends,omitempty"`
+  Exclude     []string               `yaml:"exclude,omitempty"`
+  Services    []ServicePresetService `yaml:"services"`
+}
+
+/**
+ * Returns the names of the bundled presets, and of the ones in the assets
+ * override directory
+ */
+func ListServicePresets() ([]string, error) {
+  files, err := ListAssets("presets")
+  if err != nil {
+    return nil, err
+  }
+
+  var names []string
+  for _, file := range files {
+    if strings.HasSuffix(file, ".yaml") {
+      names = append(names, strings.TrimSuffix(file, ".yaml"))
+    }
+  }
+  return names, nil
+}
+
+/**
+ * Loads the given preset, with the services of the ones it extends
+ */
+func LoadServicePreset(name string) (*ServicePreset, error) {
+  return loadServicePreset(name, nil)
+}
+
+func loadServicePreset(name string, seen []string) (*ServicePreset, error) {
+  for _, other := range seen {
+    if other == name {
+      return nil, fmt.Errorf("The presets %s extend each other", strings.Join(append(seen, name), ", "))
+    }
+  }
+
+  content, err := ReadAsset("presets/" + name + ".yaml")
+  if err != nil {
+    names, _ := ListServicePresets()
+    return nil, fmt.Errorf("Unknown preset '%s', the available ones are: %s", name, strings.Join(names, ", "))
+  }
+
+  preset := &ServicePreset{}
+  if err := yaml.Unmarshal(content, preset); err != nil {
+    return nil, fmt.Errorf("Could not parse the preset %s: %s", name, err.Error())
+  }
+  for i, service := range preset.Services {
+    if service.Package == "" {
+      return nil, fmt.Errorf("The service #%d of the preset %s does not specify the package", i+1, name)
+    }
+    if service.Name == "" {
+      preset.Services[i].Name = service.Package
+    }
+  }
+  if preset.Extends == "" {
+    return preset, nil
+  }
+
+  base, err := loadServicePreset(preset.Extends, append(seen, name))
+  if err != nil {
+    return nil, err
+  }
+
+  excluded := make(map[string]bool)
+  for _, name := range preset.Exclude {
+    excluded[name] = true
+  }
+  overrides := make(map[string]ServicePresetService)
+  for _, service := range preset.Services {
+    overrides[service.Name] = service
+  }
+
+  var services []ServicePresetService
+  for _, service := range base.Services {
+    if excluded[service.Name] {
+      continue
+    }
+    if override, ok := overrides[service.Name]; ok {
+      service = override
+      delete(overrides, service.Name)
+    }
+    services = append(services, service)
+  }
+  for _, service := range preset.Services {
+    if _, ok := overrides[service.Name]; ok {
+      services = append(services, service)
+    }
+  }
+
+  preset.Services = services
+  return preset, nil
+}
@@ -0,0 +1,332 @@
+package utils
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/service/ec2"
+  "github.com/aws/aws-sdk-go/service/servicequotas"
+)
+
+/**
+ * An AWS quota of the region that the cluster uses
+ */
+type awsQuota struct {
+  name    string
+  service string
+  code    string
+  unit    string
+}
+
+/**
+ * A class of EC2 instance types, that share a quota of on-demand vCPUs
+ */
+type awsInstanceClass struct {
+  quota    awsQuota
+  prefixes []string
+}
+
+// The classes, by the first letters of the families of their instance types.
+// Inf has to be matched before the standard I instances.
+var awsInstanceClasses []awsInstanceClass = []awsInstanceClass{
+  {awsQuota{"Running On-Demand Inf instances", "ec2", "L-1945791B", "vCPUs"}, []string{"inf"}},
+  {awsQuota{"Running On-Demand Standard (A, C, D, H, I, M, R, T, Z) instances", "ec2", "L-1216C47A", "vCPUs"}, []string{"a", "c", "d", "h", "i", "m", "r", "t", "z"}},
+  {awsQuota{"Running On-Demand F instances", "ec2", "L-74FC7D96", "vCPUs"}, []string{"f"}},
+  {awsQuota{"Running On-Demand G instances", "ec2", "L-DB2E81BA", "vCPUs"}, []string{"g"}},
+  {awsQuota{"Running On-Demand P instances", "ec2", "L-417A185B", "vCPUs"}, []string{"p"}},
+  {awsQuota{"Running On-Demand X instances", "ec2", "L-7295265B", "vCPUs"}, []string{"x"}},
+}
+
+var awsElasticIPQuota awsQuota = awsQuota{"EC2-VPC Elastic IPs", "ec2", "L-0263D0A3", "addresses"}
+var awsVPCQuota awsQuota = awsQuota{"VPCs per Region", "vpc", "L-F678F1CE", "VPCs"}
+
+/**
+ * A quota of the region that is too low for what the apply creates
+ */
+type QuotaShortfall struct {
+  Name      string
+  Region    string
+  Requested int
+  Used      int
+  Limit     int
+  Unit      string
+  URL       string
+}
+
+func (q QuotaShortfall) String() string {
+  available := q.Limit - q.Used
+  if available < 0 {
+    available = 0
+  }
+  return fmt.Sprintf("%s: the cluster needs %d %s, but only %d of %d are left in %s (%d in use)", q.Name, q.Requested, q.Unit, available, q.Limit, q.Region, q.Used)
+}
+
+/**
+ * Returns the vCPU quota of an instance type, or nil if it's not limited by
+ * one (the high memory and mac instances, that run on dedicated hosts)
+ */
+func getAWSVCPUQuota(instanceType string) *awsQuota {
+  family := strings.ToLower(strings.SplitN(instanceType, ".", 2)[0])
+  if strings.Contains(family, "-") || strings.HasPrefix(family, "mac") {
+    return nil
+  }
+  for i, class := range awsInstanceClasses {
+    for _, prefix := range class.prefixes {
+      if strings.HasPrefix(family, prefix) {
+        return &awsInstanceClasses[i].quota
+      }
+    }
+  }
+  return nil
+}
+
+/**
+ * Returns the number of vCPUs of the given instance types
+ */
+func getAWSInstanceVCPUs(svc *ec2.EC2, instanceTypes []string) (map[string]int, error) {
+  vcpus := make(map[string]int)
+  for start := 0; start < len(instanceTypes); start += 100 {
+    end := start + 100
+    if end > len(instanceTypes) {
+      end = len(instanceTypes)
+    }
+    input := &ec2.DescribeInstanceTypesInput{InstanceTypes: aws.StringSlice(instanceTypes[start:end])}
+    for {
+      page, err := svc.DescribeInstanceTypes(input)
+      if err != nil {
+        return nil, fmt.Errorf("Could not describe the instance types: %s", err.Error())
+      }
+      for _, info := range page.InstanceTypes {
+        if info.VCpuInfo != nil {
+          vcpus[aws.StringValue(info.InstanceType)] = int(aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+        }
+      }
+      if page.NextToken == nil {
+        break
+      }
+      input.NextToken = page.NextToken
+    }
+  }
+  return vcpus, nil
+}
+
+/**
+ * Returns the value of a quota for the account, or its default if it was
+ * never changed
+ */
+func getAWSQuotaValue(svc *servicequotas.ServiceQuotas, quota *awsQuota) (int, error) {
+  out, err := svc.GetServiceQuota(&servicequotas.GetServiceQuotaInput{
+    ServiceCode: aws.String(quota.service),
+    QuotaCode:   aws.String(quota.code),
+  })
+  if aerr, ok := err.(awserr.Error); ok && aerr.Code() == servicequotas.ErrCodeNoSuchResourceException {
+    var defaultOut *servicequotas.GetAWSDefaultServiceQuotaOutput
+    defaultOut, err = svc.GetAWSDefaultServiceQuota(&servicequotas.GetAWSDefaultServiceQuotaInput{
+      ServiceCode: aws.String(quota.service),
+      QuotaCode:   aws.String(quota.code),
+    })
+    if err == nil {
+      return int(aws.Float64Value(defaultOut.Quota.Value)), nil
+    }
+  }
+  if err != nil {
+    return 0, fmt.Errorf("Could not read the quota %s: %s", quota.name, err.Error())
+  }
+  return int(aws.Float64Value(out.Quota.Value)), nil
+}
+
+/**
+ * Returns what the resources created by the plan need from the quotas, with
+ * the instance types of the created instances
+ */
+func getAWSQuotaRequests(changes []PlanResourceChange) (map[string]int, map[string]int) {
+  requests := make(map[string]int)
+  instanceTypes := make(map[string]int)
+  for _, change := range changes {
+    // The replaced resources are destroyed first, and give back their quota
+    if change.Action != "create" {
+      continue
+    }
+    switch change.Type {
+    case "aws_instance":
+      if instanceType, ok := change.After["instance_type"].(string); ok && getAWSVCPUQuota(instanceType) != nil {
+        instanceTypes[instanceType] += 1
+      }
+    case "aws_eip":
+      requests[awsElasticIPQuota.code] += 1
+    case "aws_vpc":
+      requests[awsVPCQuota.code] += 1
+    }
+  }
+  return requests, instanceTypes
+}
+
+/**
+ * Checks that the AWS quotas of the region of the project leave enough room
+ * for the instances (their vCPUs), Elastic IPs and VPCs created by the plan,
+ * so the apply does not fail half-way through. The quotas that cannot be
+ * read (eg. without the permission to) are skipped with a warning.
+ */
+func (s *ProjectSandbox) CheckAWSQuotas(changes []PlanResourceChange) error {
+  requests, instanceTypes := getAWSQuotaRequests(changes)
+  if len(requests) == 0 && len(instanceTypes) == 0 {
+    return nil
+  }
+
+  region := s.GetAWSRegion()
+  PrintInfo("Checking the AWS quotas of %s", region)
+  defer StartSpan("aws", "check quotas").End()
+  sess, err := GetAWSSession(region)
+  if err != nil {
+    PrintWarning("Could not check the AWS quotas: %s", err.Error())
+    return nil
+  }
+  ec2Svc := ec2.New(sess)
+  quotasSvc := servicequotas.New(sess)
+
+  var shortfalls []QuotaShortfall
+  check := func(quota *awsQuota, requested int, used func() (int, error)) {
+    limit, err := getAWSQuotaValue(quotasSvc, quota)
+    if err != nil {
+      PrintWarning("Could not check the AWS quotas: %s", err.Error())
+      return
+    }
+    count, err := used()
+    if err != nil {
+      PrintWarning("Could not check the AWS quotas: %s", err.Error())
+      return
+    }
+    if count+requested > limit {
+      shortfalls = append(shortfalls, createQuotaShortfall(quota, region, requested, count, limit))
+    }
+  }
+
+  if len(instanceTypes) > 0 {
+    if vcpuRequests, err := getAWSVCPURequests(ec2Svc, instanceTypes); err != nil {
+      PrintWarning("Could not check the AWS quotas: %s", err.Error())
+    } else if usage, err := getAWSVCPUUsage(ec2Svc); err != nil {
+      PrintWarning("Could not check the AWS quotas: %s", err.Error())
+    } else {
+      for quota, requested := range vcpuRequests {
+        check(quota, requested, func() (int, error) {
+          return usage[quota], nil
+        })
+      }
+    }
+  }
+
+  if requested := requests[awsElasticIPQuota.code]; requested > 0 {
+    check(&awsElasticIPQuota, requested, func() (int, error) {
+      out, err := ec2Svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+        Filters: []*ec2.Filter{{Name: aws.String("domain"), Values: aws.StringSlice([]string{"vpc"})}},
+      })
+      if err != nil {
+        return 0, fmt.Errorf("Could not list the Elastic IPs: %s", err.Error())
+      }
+      return len(out.Addresses), nil
+    })
+  }
+
+  if requested := requests[awsVPCQuota.code]; requested > 0 {
+    check(&awsVPCQuota, requested, func() (int, error) {
+      out, err := ec2Svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+      if err != nil {
+        return 0, fmt.Errorf("Could not list the VPCs: %s", err.Error())
+      }
+      return len(out.Vpcs), nil
+    })
+  }
+
+  if len(shortfalls) == 0 {
+    return nil
+  }
+  sort.SliceStable(shortfalls, func(i, j int) bool {
+    return shortfalls[i].Name < shortfalls[j].Name
+  })
+  for _, shortfall := range shortfalls {
+    PrintWarning("%s", shortfall.String())
+    PrintWarning("  Request an increase at %s", shortfall.URL)
+  }
+  return WithExitCode(ExitValidationFailed, fmt.Errorf("The cluster needs more AWS quota than is left in %s, not applying it (use --skip-preflight to try anyway)", region))
+}
+
+func createQuotaShortfall(quota *awsQuota, region string, requested int, used int, limit int) QuotaShortfall {
+  return QuotaShortfall{
+    Name:      quota.name,
+    Region:    region,
+    Requested: requested,
+    Used:      used,
+    Limit:     limit,
+    Unit:      quota.unit,
+    URL:       fmt.Sprintf("https://console.aws.amazon.com/servicequotas/home?region=%s#!/services/%s/quotas/%s", region, quota.service, quota.code),
+  }
+}
+
+/**
+ * Returns the vCPUs that the given instances (by instance type) need, by quota
+ */
+func getAWSVCPURequests(svc *ec2.EC2, instanceTypes map[string]int) (map[*awsQuota]int, error) {
+  var names []string
+  for instanceType := range instanceTypes {
+    names = append(names, instanceType)
+  }
+  sort.Strings(names)
+  vcpus, err := getAWSInstanceVCPUs(svc, names)
+  if err != nil {
+    return nil, err
+  }
+
+  requests := make(map[*awsQuota]int)
+  for instanceType, count := range instanceTypes {
+    // The unknown types are reported by wheels-validate
+    if n, ok := vcpus[instanceType]; ok {
+      requests[getAWSVCPUQuota(instanceType)] += n * count
+    }
+  }
+  return requests, nil
+}
+
+/**
+ * Returns the vCPUs of the on-demand instances running in the region, by
+ * quota. The spot instances have quotas of their own.
+ */
+func getAWSVCPUUsage(svc *ec2.EC2) (map[*awsQuota]int, error) {
+  running := make(map[string]int)
+  input := &ec2.DescribeInstancesInput{
+    Filters: []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running"})}},
+  }
+  err := svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+    for _, reservation := range page.Reservations {
+      for _, instance := range reservation.Instances {
+        if aws.StringValue(instance.InstanceLifecycle) != ec2.InstanceLifecycleTypeSpot {
+          running[aws.StringValue(instance.InstanceType)] += 1
+        }
+      }
+    }
+    return true
+  })
+  if err != nil {
+    return nil, fmt.Errorf("Could not list the running instances: %s", err.Error())
+  }
+
+  var names []string
+  for instanceType := range running {
+    if getAWSVCPUQuota(instanceType) != nil {
+      names = append(names, instanceType)
+    }
+  }
+  sort.Strings(names)
+  vcpus, err := getAWSInstanceVCPUs(svc, names)
+  if err != nil {
+    return nil, err
+  }
+
+  usage := make(map[*awsQuota]int)
+  for _, instanceType := range names {
+    usage[getAWSVCPUQuota(instanceType)] += vcpus[instanceType] * running[instanceType]
+  }
+  return usage, nil
+}
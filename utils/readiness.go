@@ -0,0 +1,78 @@
+package utils
+
+import (
+  "crypto/tls"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+/**
+ * An HTTP client for talking to the cluster endpoints. Freshly deployed
+ * clusters are using self-signed certificates, so they are not verified.
+ */
+func getClusterHttpClient() *http.Client {
+  tr := &http.Transport{
+    Proxy:           http.ProxyFromEnvironment,
+    TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+  }
+  return &http.Client{Transport: wrapLoggingTransport(wrapDcosTransport(tr)), Timeout: 10 * time.Second}
+}
+
+/**
+ * Checks if the given URL responds with a 2xx status code
+ */
+func isEndpointReady(client *http.Client, url string) bool {
+  resp, err := client.Get(url)
+  if err != nil {
+    return false
+  }
+  resp.Body.Close()
+  return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+/**
+ * Returns a full URL for the given cluster address, that could be just a
+ * hostname as returned by the load balancer outputs
+ */
+func GetClusterURL(address string) string {
+  if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+    return strings.TrimSuffix(address, "/")
+  }
+  return "https://" + strings.TrimSuffix(address, "/")
+}
+
+/**
+ * Polls the DC/OS cluster until both the admin UI and all the Mesos masters
+ * are reachable, or until the timeout expires.
+ */
+func WaitForClusterReady(address string, masters []string, timeout time.Duration, interval time.Duration) error {
+  client := getClusterHttpClient()
+  clusterUrl := GetClusterURL(address)
+  deadline := time.Now().Add(timeout)
+
+  PrintInfo("Waiting up to %s for the cluster at %s to become ready", timeout, clusterUrl)
+  for {
+    var pending []string
+
+    if !isEndpointReady(client, clusterUrl+"/") {
+      pending = append(pending, "admin UI")
+    }
+    for _, master := range masters {
+      if !isEndpointReady(client, fmt.Sprintf("http://%s:5050/health", master)) {
+        pending = append(pending, "master "+master)
+      }
+    }
+
+    if len(pending) == 0 {
+      PrintInfo("Cluster at %s is ready", clusterUrl)
+      return nil
+    }
+    if time.Now().After(deadline) {
+      return fmt.Errorf("cluster did not become ready within %s, still waiting for: %s", timeout, strings.Join(pending, ", "))
+    }
+
+    time.Sleep(interval)
+  }
+}
@@ -0,0 +1,254 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "regexp"
+  "sort"
+  "strings"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+var resourceStartedPattern *regexp.Regexp = regexp.MustCompile(`(?m)^\s*([^\s:]+): (Creating|Modifying|Destroying)\.\.\.`)
+var resourceCompletedPattern *regexp.Regexp = regexp.MustCompile(`(?m)^\s*([^\s:]+): (?:Creation|Modifications|Destruction) complete`)
+var nestedErrorsPattern *regexp.Regexp = regexp.MustCompile(`^[0-9]+ error\(s\) occurred:?$`)
+
+/**
+ * What a failed apply or destroy left behind, and how to continue
+ */
+type RunRecovery struct {
+  Command     string
+  Completed   []string
+  Interrupted []string
+  Modified    []string
+  Failed      []TerraformErrorGroup
+  Tainted     []string
+  Remaining   int
+  NextSteps   []string
+}
+
+/**
+ * Returns the resources of the terraform state that are tainted, and are
+ * going to be re-created by the next apply
+ */
+func (w *TerraformWrapper) GetTaintedResources() ([]string, error) {
+  content, err := w.PullState()
+  if err != nil || content == nil {
+    return nil, err
+  }
+
+  var state struct {
+    Modules []struct {
+      Path      []string `json:"path"`
+      Resources map[string]struct {
+        Primary struct {
+          Tainted bool `json:"tainted"`
+        } `json:"primary"`
+      } `json:"resources"`
+    } `json:"modules"`
+  }
+  if err := json.Unmarshal(content, &state); err != nil {
+    return nil, fmt.Errorf("Could not parse the terraform state: %s", err.Error())
+  }
+
+  var tainted []string
+  for _, module := range state.Modules {
+    prefix := ""
+    for _, name := range module.Path {
+      if name != "root" {
+        prefix += "module." + name + "."
+      }
+    }
+    for address, resource := range module.Resources {
+      if resource.Primary.Tainted {
+        tainted = append(tainted, prefix+address)
+      }
+    }
+  }
+  sort.Strings(tainted)
+  return tainted, nil
+}
+
+/**
+ * @brief      Finds out which resources the last (failed) run completed,
+ *             which ones failed and why, and what to run next
+ *
+ * The resources are followed in the output of terraform, and the tainted
+ * ones are read from the state, since terraform re-creates them on the next
+ * apply.
+ */
+func AnalyzeFailedRun(tf *TerraformWrapper) *RunRecovery {
+  output := ansiColorPattern.ReplaceAllString(tf.GetLastOutput(), "")
+  r := &RunRecovery{Command: tf.GetLastCommand()}
+
+  completed := make(map[string]bool)
+  for _, m := range resourceCompletedPattern.FindAllStringSubmatch(output, -1) {
+    if !completed[m[1]] {
+      completed[m[1]] = true
+      r.Completed = append(r.Completed, m[1])
+    }
+  }
+  started := make(map[string]bool)
+  for _, m := range resourceStartedPattern.FindAllStringSubmatch(output, -1) {
+    if completed[m[1]] || started[m[1]] {
+      continue
+    }
+    started[m[1]] = true
+    if m[2] == "Modifying" {
+      r.Modified = append(r.Modified, m[1])
+    } else {
+      r.Interrupted = append(r.Interrupted, m[1])
+    }
+  }
+
+  // Terraform 0.11 nests the errors of the modules
+  for _, group := range SummarizeTerraformErrors(output) {
+    if group.Code == "" && nestedErrorsPattern.MatchString(group.Message) {
+      continue
+    }
+    r.Failed = append(r.Failed, group)
+  }
+
+  var err error
+  if r.Tainted, err = tf.GetTaintedResources(); err != nil {
+    PrintWarning("%s", err.Error())
+  }
+
+  // The resources that failed were not interrupted
+  var interrupted []string
+  for _, address := range r.Interrupted {
+    if !r.hasFailed(address) {
+      interrupted = append(interrupted, address)
+    }
+  }
+  r.Interrupted = interrupted
+  if resources, err := tf.ListStateResources(); err == nil {
+    r.Remaining = len(resources)
+  }
+
+  r.NextSteps = r.getNextSteps()
+  return r
+}
+
+/**
+ * Checks if the resource is tainted or in the errors, where terraform 0.11
+ * gives the address relative to the module (eg. `aws_instance.instance.0`)
+ */
+func (r *RunRecovery) hasFailed(address string) bool {
+  for _, tainted := range r.Tainted {
+    if tainted == address {
+      return true
+    }
+  }
+  for _, group := range r.Failed {
+    for _, failed := range group.Addresses {
+      if address == failed || strings.HasSuffix(address, "."+failed) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+/**
+ * Returns true if one of the errors will not go away by retrying
+ */
+func (r *RunRecovery) hasFatalErrors() bool {
+  for _, group := range r.Failed {
+    for _, pattern := range fatalErrorPatterns {
+      if regexp.MustCompile(pattern).MatchString(group.Code + ": " + group.Message) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func (r *RunRecovery) getNextSteps() []string {
+  if r.Command == "destroy" {
+    return []string{
+      fmt.Sprintf("Run `%s destroy` again to remove the %d remaining resources", os.Args[0], r.Remaining),
+    }
+  }
+
+  var steps []string
+  if r.hasFatalErrors() {
+    steps = append(steps,
+      fmt.Sprintf("Fix the cause of the errors above, then run `%s apply`", os.Args[0]),
+      fmt.Sprintf("Or run `%s destroy` to remove the %d resources that were created", os.Args[0], r.Remaining),
+    )
+    return steps
+  }
+
+  // The resources that failed in place are not tainted by terraform
+  for _, address := range r.Modified {
+    steps = append(steps, fmt.Sprintf("Run `%s taint %s` to re-create %s", os.Args[0], strings.Join(GetTaintArgs(address), " "), address))
+  }
+  if len(r.Tainted) > 0 {
+    steps = append(steps, fmt.Sprintf("Run `%s apply` to retry, the %d tainted resources will be re-created", os.Args[0], len(r.Tainted)))
+  } else {
+    steps = append(steps, fmt.Sprintf("Run `%s apply` to retry", os.Args[0]))
+  }
+  return steps
+}
+
+/**
+ * Returns the arguments of `terraform taint` (0.11) for a resource address:
+ * the resources in modules are given with `-module=`, and their index after
+ * a dot (eg. `-module=dcos aws_instance.instance.0`)
+ */
+func GetTaintArgs(address string) []string {
+  address = strings.Replace(strings.Replace(address, "[", ".", -1), "]", "", -1)
+  var modules []string
+  parts := strings.Split(address, ".")
+  for len(parts) > 2 && parts[0] == "module" {
+    modules = append(modules, parts[1])
+    parts = parts[2:]
+  }
+  if len(modules) == 0 {
+    return []string{address}
+  }
+  return []string{"-module=" + strings.Join(modules, "."), strings.Join(parts, ".")}
+}
+
+/**
+ * Prints what the failed run completed, what failed and what to do next. The
+ * errors are not repeated when they were already summarized.
+ */
+func (r *RunRecovery) Print(errorsShown bool) {
+  Println()
+  PrintWarning("The %s did not complete, %d resources were completed", r.Command, Bold(len(r.Completed)))
+  printRecoveryList("Interrupted:", r.Interrupted)
+  printRecoveryList("Failed to modify:", r.Modified)
+  printRecoveryList("Tainted (re-created by the next apply):", r.Tainted)
+  if !errorsShown && len(r.Failed) > 0 {
+    Printf("  %s\n", Bold("Failed:"))
+    for _, group := range r.Failed {
+      cause := group.Message
+      if group.Code != "" {
+        cause = fmt.Sprintf("%s: %s", Bold(group.Code), group.Message)
+      }
+      Printf("    %s\n", cause)
+      for _, address := range group.Addresses {
+        Printf("      - %s\n", address)
+      }
+    }
+  }
+
+  PrintInfo("Next steps:")
+  for _, step := range r.NextSteps {
+    Printf("  - %s\n", step)
+  }
+}
+
+func printRecoveryList(title string, addresses []string) {
+  if len(addresses) == 0 {
+    return
+  }
+  Printf("  %s\n", Bold(title))
+  for _, address := range addresses {
+    Printf("    - %s\n", address)
+  }
+}
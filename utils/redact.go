@@ -0,0 +1,155 @@
+package utils
+
+import (
+  "bytes"
+  "io"
+  "os"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+)
+
+var redactedText string = "[REDACTED]"
+
+var secretsMutex sync.Mutex
+var knownSecrets []string
+
+// Secrets that are recognized by their format, even if we never saw them
+var secretPatterns []*regexp.Regexp = []*regexp.Regexp{
+  // JSON web tokens, like the DC/OS authentication tokens
+  regexp.MustCompile(`eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+  // SHA-512 crypt hashes, like the DC/OS superuser password hash
+  regexp.MustCompile(`\$6\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{86}`),
+}
+
+// The environment variables with secrets, that are always redacted
+var secretEnvironment []string = []string{
+  "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "DCOS_ACS_TOKEN", "DCOS_PASSWORD",
+}
+
+func init() {
+  for _, name := range secretEnvironment {
+    RegisterSecret(os.Getenv(name))
+  }
+}
+
+/**
+ * Remembers a secret, so it's redacted from everything we print
+ */
+func RegisterSecret(secret string) {
+  secret = strings.TrimSpace(secret)
+  // Too short to be recognized without hiding unrelated text
+  if len(secret) < 6 {
+    return
+  }
+
+  secretsMutex.Lock()
+  defer secretsMutex.Unlock()
+  for _, known := range knownSecrets {
+    if known == secret {
+      return
+    }
+  }
+  knownSecrets = append(knownSecrets, secret)
+}
+
+/**
+ * Checks if the given environment variable is expected to contain a secret
+ */
+func IsSecretVariable(name string) bool {
+  name = strings.ToUpper(name)
+  for _, word := range []string{"TOKEN", "PASSWORD", "SECRET", "LICENSE", "_KEY"} {
+    if strings.Contains(name, word) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Replaces the secrets in the given text
+ */
+func Redact(text string) string {
+  secretsMutex.Lock()
+  secrets := knownSecrets
+  secretsMutex.Unlock()
+
+  for _, secret := range secrets {
+    text = strings.Replace(text, secret, redactedText, -1)
+  }
+  for _, re := range secretPatterns {
+    text = re.ReplaceAllString(text, redactedText)
+  }
+  return text
+}
+
+/**
+ * How long a line that is not ended is held, before it's written anyway.
+ * The prompts of terraform do not end their line, and must show.
+ */
+var redactingWriterDelay = 200 * time.Millisecond
+
+/**
+ * A writer that redacts the secrets from what goes through it. The output is
+ * redacted line by line, so that a secret split between two writes is still
+ * recognized. What is left of a line is written on Close, or when nothing
+ * follows it for a moment.
+ */
+type redactingWriter struct {
+  w     io.Writer
+  mutex sync.Mutex
+  line  []byte
+  timer *time.Timer
+}
+
+func NewRedactingWriter(w io.Writer) io.WriteCloser {
+  return &redactingWriter{w: w}
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+
+  r.line = append(r.line, p...)
+  if idx := bytes.LastIndexByte(r.line, '\n'); idx >= 0 {
+    lines := r.line[:idx+1]
+    r.line = append([]byte{}, r.line[idx+1:]...)
+    if _, err := r.w.Write([]byte(Redact(string(lines)))); err != nil {
+      return 0, err
+    }
+  }
+
+  if len(r.line) > 0 {
+    if r.timer == nil {
+      r.timer = time.AfterFunc(redactingWriterDelay, func() { _ = r.flush() })
+    } else {
+      r.timer.Reset(redactingWriterDelay)
+    }
+  }
+  return len(p), nil
+}
+
+func (r *redactingWriter) flush() error {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  if len(r.line) == 0 {
+    return nil
+  }
+  line := r.line
+  r.line = nil
+  _, err := r.w.Write([]byte(Redact(string(line))))
+  return err
+}
+
+/**
+ * Writes what is left of the last line
+ */
+func (r *redactingWriter) Close() error {
+  r.mutex.Lock()
+  if r.timer != nil {
+    r.timer.Stop()
+  }
+  r.mutex.Unlock()
+  return r.flush()
+}
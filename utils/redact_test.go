@@ -0,0 +1,40 @@
+package utils
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestRedactingWriterSplitSecret(t *testing.T) {
+  RegisterSecret("split-writer-secret")
+
+  var out bytes.Buffer
+  writer := NewRedactingWriter(&out)
+  writer.Write([]byte("token: split-wri"))
+  writer.Write([]byte("ter-secret\nnext: split-writer"))
+  writer.Write([]byte("-secret"))
+  if err := writer.Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  want := "token: " + redactedText + "\nnext: " + redactedText
+  if out.String() != want {
+    t.Errorf("got %q, want %q", out.String(), want)
+  }
+}
+
+func TestRedactingWriterShowsPrompts(t *testing.T) {
+  var out bytes.Buffer
+  writer := NewRedactingWriter(&syncWriter{w: &out})
+  defer writer.Close()
+  writer.Write([]byte("  Enter a value: "))
+
+  time.Sleep(redactingWriterDelay * 3)
+  writer.(*redactingWriter).mutex.Lock()
+  defer writer.(*redactingWriter).mutex.Unlock()
+  if !strings.Contains(out.String(), "Enter a value") {
+    t.Errorf("the prompt was not written, got %q", out.String())
+  }
+}
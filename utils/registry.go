@@ -0,0 +1,187 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+/**
+ * A cluster that was created with terraform-wheels on this machine
+ */
+type RegisteredCluster struct {
+  Dir       string     `json:"dir"`
+  Name      string     `json:"name"`
+  TTL       string     `json:"ttl,omitempty"`
+  AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+/**
+ * Returns when the cluster expires, or false if it never does (or it was
+ * not applied yet)
+ */
+func (c *RegisteredCluster) GetExpiration() (time.Time, bool) {
+  if c.TTL == "" || c.AppliedAt == nil {
+    return time.Time{}, false
+  }
+  ttl, err := time.ParseDuration(c.TTL)
+  if err != nil {
+    return time.Time{}, false
+  }
+  return c.AppliedAt.Add(ttl), true
+}
+
+/**
+ * Checks if the cluster is past its TTL
+ */
+func (c *RegisteredCluster) IsExpired() bool {
+  expires, ok := c.GetExpiration()
+  return ok && time.Now().After(expires)
+}
+
+func getClusterRegistryPath() (string, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "clusters.json"), nil
+}
+
+/**
+ * Loads the user-wide registry of clusters, by project directory
+ */
+func LoadClusterRegistry() (map[string]*RegisteredCluster, error) {
+  path, err := getClusterRegistryPath()
+  if err != nil {
+    return nil, err
+  }
+
+  registry := make(map[string]*RegisteredCluster)
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return registry, nil
+    }
+    return nil, fmt.Errorf("Could not read the cluster registry: %s", err.Error())
+  }
+
+  if err := json.Unmarshal(content, &registry); err != nil {
+    return nil, fmt.Errorf("Could not parse the cluster registry %s: %s", path, err.Error())
+  }
+  return registry, nil
+}
+
+/**
+ * Updates the cluster registry, while making sure no other process does so
+ * at the same time
+ */
+func UpdateClusterRegistry(update func(registry map[string]*RegisteredCluster) error) error {
+  path, err := getClusterRegistryPath()
+  if err != nil {
+    return err
+  }
+
+  lock, err := AcquireFileLock(path + ".lock")
+  if err != nil {
+    return err
+  }
+  defer lock.Release()
+
+  registry, err := LoadClusterRegistry()
+  if err != nil {
+    return err
+  }
+  if err := update(registry); err != nil {
+    return err
+  }
+
+  content, err := json.MarshalIndent(registry, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(path, content, 0644); err != nil {
+    return fmt.Errorf("Could not write the cluster registry: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * @brief      Registers the cluster of this project, with the given TTL (or
+ *             none if empty), counted from the first successful apply
+ */
+func (s *ProjectSandbox) RegisterCluster(name string, ttl string) error {
+  return UpdateClusterRegistry(func(registry map[string]*RegisteredCluster) error {
+    registry[s.baseDir] = &RegisteredCluster{s.baseDir, name, ttl, nil}
+    return nil
+  })
+}
+
+/**
+ * @brief      Starts the TTL of the cluster of this project, if registered
+ */
+func (s *ProjectSandbox) MarkClusterApplied() error {
+  return UpdateClusterRegistry(func(registry map[string]*RegisteredCluster) error {
+    if c, ok := registry[s.baseDir]; ok && c.AppliedAt == nil {
+      now := time.Now().UTC()
+      c.AppliedAt = &now
+    }
+    return nil
+  })
+}
+
+/**
+ * @brief      Removes the cluster of this project from the registry
+ */
+func (s *ProjectSandbox) UnregisterCluster() error {
+  return UpdateClusterRegistry(func(registry map[string]*RegisteredCluster) error {
+    delete(registry, s.baseDir)
+    return nil
+  })
+}
+
+/**
+ * @brief      Returns the cluster of this project from the registry, or nil if
+ *             it's not registered
+ */
+func (s *ProjectSandbox) GetRegisteredCluster() (*RegisteredCluster, error) {
+  registry, err := LoadClusterRegistry()
+  if err != nil {
+    return nil, err
+  }
+  return registry[s.baseDir], nil
+}
+
+/**
+ * @brief      Finds the registered cluster with the given name, failing if
+ *             there is none or more than one
+ */
+func FindRegisteredCluster(name string) (*RegisteredCluster, error) {
+  registry, err := LoadClusterRegistry()
+  if err != nil {
+    return nil, err
+  }
+
+  var found []*RegisteredCluster
+  for _, c := range registry {
+    if c.Name == name {
+      found = append(found, c)
+    }
+  }
+  if len(found) == 0 {
+    return nil, fmt.Errorf("There is no cluster named %s on this machine", name)
+  }
+  if len(found) > 1 {
+    var dirs []string
+    for _, c := range found {
+      dirs = append(dirs, c.Dir)
+    }
+    sort.Strings(dirs)
+    return nil, fmt.Errorf("There are %d clusters named %s, please run the command in one of: %s", len(found), name, strings.Join(dirs, ", "))
+  }
+  return found[0], nil
+}
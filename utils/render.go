@@ -0,0 +1,55 @@
+package utils
+
+import (
+  "crypto/sha256"
+  "fmt"
+  "io/ioutil"
+  "sort"
+)
+
+/**
+ * @brief      Returns a checksum of every file in the project directory, to
+ *             find out what changed after generating files
+ *
+ * The directories (like .terraform and .wheels) are not part of what gets
+ * committed, so they are skipped.
+ */
+func (s *ProjectSandbox) SnapshotProjectFiles() (map[string]string, error) {
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return nil, fmt.Errorf("Could not list the project files: %s", err.Error())
+  }
+
+  snapshot := make(map[string]string)
+  for _, f := range files {
+    if f.IsDir() {
+      continue
+    }
+    content, err := s.ReadFile(f.Name())
+    if err != nil {
+      return nil, fmt.Errorf("Could not read %s: %s", f.Name(), err.Error())
+    }
+    snapshot[f.Name()] = fmt.Sprintf("%x", sha256.Sum256(content))
+  }
+  return snapshot, nil
+}
+
+/**
+ * Returns the files that were created, modified or removed between the two
+ * snapshots, sorted
+ */
+func GetChangedProjectFiles(before map[string]string, after map[string]string) []string {
+  var changed []string
+  for name, sum := range after {
+    if before[name] != sum {
+      changed = append(changed, name)
+    }
+  }
+  for name := range before {
+    if _, ok := after[name]; !ok {
+      changed = append(changed, name)
+    }
+  }
+  sort.Strings(changed)
+  return changed
+}
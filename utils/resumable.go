@@ -0,0 +1,156 @@
+package utils
+
+import (
+  "fmt"
+  "io"
+  "net/http"
+  "regexp"
+  "strconv"
+  "time"
+)
+
+// How many times an interrupted download is resumed before giving up
+var downloadResumeAttempts int = 5
+
+var contentRangePattern *regexp.Regexp = regexp.MustCompile(`^bytes ([0-9]+)-`)
+
+/**
+ * Returns what identifies the version of the file of the response, to make
+ * sure that the ranges requested later are from the same file
+ */
+func getRangeValidator(resp *http.Response) string {
+  if etag := resp.Header.Get("ETag"); etag != "" && etag[0] == '"' {
+    return etag
+  }
+  return resp.Header.Get("Last-Modified")
+}
+
+/**
+ * Returns true if the download of the response can be resumed with a range
+ * request when it's interrupted
+ */
+func isResumable(resp *http.Response) bool {
+  return resp.Header.Get("Accept-Ranges") == "bytes" &&
+    resp.Header.Get("Content-Encoding") == "" && !resp.Uncompressed
+}
+
+/**
+ * Requests the given range of the file (up to its end if end < 0), failing
+ * if the server does not return that exact range of the same file
+ */
+func requestRange(client *http.Client, url string, start int64, end int64, validator string) (*http.Response, error) {
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return nil, err
+  }
+  if end < 0 {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+  } else {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+  }
+  if validator != "" {
+    req.Header.Set("If-Range", validator)
+  }
+
+  resp, err := client.Do(req)
+  if err != nil {
+    return nil, fmt.Errorf("could not request %s: %s", url, err.Error())
+  }
+  if resp.StatusCode == http.StatusOK {
+    resp.Body.Close()
+    return nil, fmt.Errorf("%s changed on the server during the download", url)
+  }
+  if resp.StatusCode != http.StatusPartialContent {
+    resp.Body.Close()
+    return nil, fmt.Errorf("server responded to a range request with: %s", resp.Status)
+  }
+  m := contentRangePattern.FindStringSubmatch(resp.Header.Get("Content-Range"))
+  if m == nil || m[1] != strconv.FormatInt(start, 10) {
+    resp.Body.Close()
+    return nil, fmt.Errorf("server responded with the wrong range: %s", resp.Header.Get("Content-Range"))
+  }
+  return resp, nil
+}
+
+/**
+ * Waits before the given attempt to resume a download
+ */
+func waitBeforeResuming(attempt int) {
+  time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
+}
+
+/**
+ * The body of a download that reconnects where it was interrupted, with a
+ * range request, when the connection is lost
+ */
+type resumableBody struct {
+  client    *http.Client
+  url       string
+  body      io.ReadCloser
+  offset    int64
+  total     int64
+  validator string
+  attempts  int
+}
+
+func newResumableBody(client *http.Client, resp *http.Response) *resumableBody {
+  return &resumableBody{
+    client:    client,
+    url:       resp.Request.URL.String(),
+    body:      resp.Body,
+    total:     resp.ContentLength,
+    validator: getRangeValidator(resp),
+  }
+}
+
+func (r *resumableBody) Read(p []byte) (int, error) {
+  for {
+    n, err := r.body.Read(p)
+    r.offset += int64(n)
+    if err == io.EOF && r.total >= 0 && r.offset < r.total {
+      err = io.ErrUnexpectedEOF
+    }
+    if err == nil || err == io.EOF {
+      return n, err
+    }
+    // Give what was received, the error comes again with the next read
+    if n > 0 {
+      return n, nil
+    }
+
+    if r.attempts >= downloadResumeAttempts {
+      return 0, fmt.Errorf("%s (after resuming %d times)", err.Error(), r.attempts)
+    }
+    r.attempts += 1
+    PrintWarning("The download of %s was interrupted (%s), resuming it", r.url, err.Error())
+    r.body.Close()
+    waitBeforeResuming(r.attempts)
+
+    resp, rerr := requestRange(r.client, r.url, r.offset, -1, r.validator)
+    if rerr != nil {
+      // Keep the connection error, to try again with the next read
+      r.body = &failedBody{rerr}
+      continue
+    }
+    r.body = resp.Body
+  }
+}
+
+func (r *resumableBody) Close() error {
+  return r.body.Close()
+}
+
+/**
+ * A body that fails to read, until the download is resumed
+ */
+type failedBody struct {
+  err error
+}
+
+func (b *failedBody) Read(p []byte) (int, error) {
+  return 0, b.err
+}
+
+func (b *failedBody) Close() error {
+  return nil
+}
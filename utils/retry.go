@@ -0,0 +1,168 @@
+package utils
+
+import (
+  "math/rand"
+  "regexp"
+  "time"
+)
+
+// Errors from AWS that usually go away if we try again a bit later
+var transientErrorPatterns []string = []string{
+  `RequestLimitExceeded`,
+  `Throttling`,
+  `Rate exceeded`,
+  `TooManyRequestsException`,
+  `ServiceUnavailable`,
+  `InternalError`,
+  `RequestError: send request failed`,
+  `connection reset by peer`,
+  // Eventual consistency: resources that were just created are not yet
+  // visible to the other AWS services. A missing AMI or key pair is not
+  // going to show up, see fatalErrorPatterns.
+  `Invalid(Instance|Subnet|Vpc|RouteTable|NetworkInterface|InternetGateway|Allocation|Association|VpcPeeringConnection)ID\.NotFound`,
+  `InvalidGroup\.NotFound`,
+  `InvalidParameterValue: Value \(.*\) for parameter iamInstanceProfile`,
+  `NoSuchEntity`,
+}
+
+// Errors from AWS when the credentials expired during a run
+var expiredCredentialsPatterns []*regexp.Regexp = []*regexp.Regexp{
+  regexp.MustCompile(`ExpiredToken`),
+  regexp.MustCompile(`RequestExpired`),
+  regexp.MustCompile(`security token included in the request is expired`),
+}
+
+/**
+ * Checks if the output of a failed terraform run contains an error that is
+ * worth retrying, and none that is fatal
+ */
+func IsTransientError(output string, extraPatterns []string) bool {
+  // Trying again does not help when something else failed for good
+  if IsFatalError(output) {
+    return false
+  }
+  for _, pattern := range append(transientErrorPatterns, extraPatterns...) {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+      PrintWarning("Invalid retry pattern '%s' in %s: %s", pattern, WheelsConfigFile, err.Error())
+      continue
+    }
+    if re.MatchString(output) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Checks if a terraform run failed because the credentials expired
+ */
+func isExpiredCredentialsError(output string) bool {
+  for _, re := range expiredCredentialsPatterns {
+    if re.MatchString(output) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Checks if the arguments apply a saved plan, that is stale after a partial
+ * apply and cannot be run again
+ */
+func hasSavedPlan(args []string) bool {
+  return ParseTerraformArgs(args).GetSavedPlan() != ""
+}
+
+/**
+ * Returns how long to wait before the given (zero-based) retry, doubling
+ * every time up to the maximum, with a bit of jitter
+ */
+func getRetryDelay(attempt int, initial time.Duration, max time.Duration) time.Duration {
+  delay := initial
+  for i := 0; i < attempt && delay < max; i++ {
+    delay *= 2
+  }
+  if delay > max {
+    delay = max
+  }
+  return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+/**
+ * Invokes terraform, after preparing the `apply` runs that do not apply a
+ * saved plan. Returns false if it was not run because the preparation
+ * failed.
+ */
+func (w *TerraformWrapper) invokePrepared(args []string) (bool, error) {
+  if w.prepareApply == nil || GetTerraformCommand(args) != "apply" || hasSavedPlan(args) {
+    return true, w.Invoke(args)
+  }
+  prepared, err := w.prepareApply(args)
+  if err != nil {
+    return false, err
+  }
+  return true, w.Invoke(prepared)
+}
+
+/**
+ * Invokes terraform and, if this is an `apply` that failed because of a
+ * transient cloud error, runs it again with an exponential backoff
+ */
+func (w *TerraformWrapper) InvokeWithRetry(args []string, cfg RetryConfig) error {
+  maxRetries := 3
+  if cfg.MaxRetries != nil {
+    maxRetries = *cfg.MaxRetries
+  }
+
+  ran, err := w.invokePrepared(args)
+  if !ran {
+    return err
+  }
+
+  // Long runs can outlive temporary credentials, that we can refresh
+  if err != nil && isExpiredCredentialsError(w.GetLastOutput()) {
+    if w.refreshCredentials == nil || hasSavedPlan(args) {
+      printExpiredCredentialsGuidance()
+      return err
+    }
+    PrintWarning("The AWS credentials expired during the run, refreshing them and running again")
+    if rerr := w.refreshCredentials(true); rerr != nil {
+      printExpiredCredentialsGuidance()
+      return rerr
+    }
+    if ran, err = w.invokePrepared(args); !ran {
+      return err
+    }
+  }
+
+  if err == nil || w.GetLastCommand() != "apply" || maxRetries <= 0 || w.WasLastInterrupted() {
+    return err
+  }
+
+  // A saved plan is stale after a partial apply, so it cannot be retried
+  if hasSavedPlan(args) {
+    if IsTransientError(w.GetLastOutput(), cfg.Patterns) {
+      PrintWarning("This looks like a transient error, but a saved plan cannot be retried. Please run `plan` again.")
+    }
+    return err
+  }
+
+  initial := ParseConfigDuration(cfg.InitialDelay, 10*time.Second)
+  max := ParseConfigDuration(cfg.MaxDelay, 2*time.Minute)
+  for attempt := 0; attempt < maxRetries; attempt++ {
+    if !IsTransientError(w.GetLastOutput(), cfg.Patterns) {
+      return err
+    }
+
+    delay := getRetryDelay(attempt, initial, max)
+    PrintWarning("The apply failed because of a transient error, retrying in %s (%d/%d)", delay.Round(time.Second), attempt+1, maxRetries)
+    time.Sleep(delay)
+
+    if ran, err = w.invokePrepared(args); !ran || err == nil || w.WasLastInterrupted() {
+      return err
+    }
+  }
+
+  return err
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+  "testing"
+)
+
+func TestIsTransientError(t *testing.T) {
+  tests := []struct {
+    output    string
+    transient bool
+  }{
+    {"Error: RequestLimitExceeded: Request limit exceeded.", true},
+    {"Error: Throttling: Rate exceeded", true},
+    {"Error: InvalidInstanceID.NotFound: The instance ID 'i-123' does not exist", true},
+    {"Error: InvalidSubnetID.NotFound: The subnet ID 'subnet-1' does not exist", true},
+    {"Error: InvalidGroup.NotFound: The security group 'sg-1' does not exist", true},
+    {"Error: InvalidAMIID.NotFound: The image id '[ami-123]' does not exist", false},
+    {"Error: InvalidKeyPair.NotFound: The key pair 'dcos' does not exist", false},
+    // Another resource failed for good, retrying would fail again
+    {"Error: RequestLimitExceeded\nError: InvalidAMIID.NotFound: The image id '[ami-123]' does not exist", false},
+    {"Error: InvalidParameterValue: Invalid value for instance type", false},
+  }
+  for _, test := range tests {
+    if got := IsTransientError(test.output, nil); got != test.transient {
+      t.Errorf("%q: got %v, want %v", test.output, got, test.transient)
+    }
+  }
+
+  if !IsTransientError("Error: MyCustomThrottle", []string{"MyCustomThrottle"}) {
+    t.Errorf("the patterns of .wheels.yaml are not used")
+  }
+}
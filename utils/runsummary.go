@@ -0,0 +1,148 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+// Where the output of the terraform runs is kept, in the .wheels directory
+var runLogsDir string = "logs"
+
+// How many run logs are kept
+var keepRunLogs int = 20
+
+// How many of the slowest resources are shown
+var showSlowestResources int = 5
+
+var runCountsPattern *regexp.Regexp = regexp.MustCompile(`(?:Apply|Destroy) complete! Resources: ([^.\n]+)\.|Plan: ([^.\n]+)\.|(No changes)\. Infrastructure is up-to-date`)
+var resourceTimingPattern *regexp.Regexp = regexp.MustCompile(`(?m)^\s*([^\s:]+): (Creation|Modifications|Destruction) complete after ([0-9hms.]+)`)
+var ansiColorPattern *regexp.Regexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+/**
+ * How long terraform took to create, change or destroy a resource
+ */
+type ResourceTiming struct {
+  Address  string
+  Action   string
+  Duration time.Duration
+}
+
+/**
+ * What a terraform run did, from its output
+ */
+type RunSummary struct {
+  Command  string
+  Duration time.Duration
+  Counts   string
+  Slowest  []ResourceTiming
+  LogFile  string
+}
+
+/**
+ * Summarizes the output of a terraform run: the number of resources that
+ * changed (eg. "3 added, 1 changed, 0 destroyed") and the slowest ones
+ */
+func ParseRunSummary(command string, output string) *RunSummary {
+  output = ansiColorPattern.ReplaceAllString(output, "")
+  summary := &RunSummary{Command: command}
+
+  if m := runCountsPattern.FindAllStringSubmatch(output, -1); m != nil {
+    last := m[len(m)-1]
+    summary.Counts = strings.TrimSpace(last[1] + last[2] + last[3])
+  }
+
+  for _, m := range resourceTimingPattern.FindAllStringSubmatch(output, -1) {
+    d, err := time.ParseDuration(m[3])
+    if err != nil {
+      continue
+    }
+    summary.Slowest = append(summary.Slowest, ResourceTiming{m[1], strings.ToLower(m[2]), d})
+  }
+  sort.SliceStable(summary.Slowest, func(i, j int) bool {
+    return summary.Slowest[i].Duration > summary.Slowest[j].Duration
+  })
+  if len(summary.Slowest) > showSlowestResources {
+    summary.Slowest = summary.Slowest[:showSlowestResources]
+  }
+  return summary
+}
+
+/**
+ * @brief      Keeps the output of the last terraform run in the .wheels/logs
+ *             directory, returning the path of the log relative to the
+ *             project
+ */
+func (s *ProjectSandbox) SaveRunLog(tf *TerraformWrapper) (string, error) {
+  name := fmt.Sprintf("%s-%s.log", time.Now().UTC().Format("20060102-150405"), tf.GetLastCommand())
+  path, err := s.GetWheelsPath(filepath.Join(runLogsDir, name))
+  if err != nil {
+    return "", err
+  }
+
+  // The output is already redacted, but can still tell a lot about the
+  // cluster
+  content := ansiColorPattern.ReplaceAllString(tf.GetLastOutput(), "")
+  if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+    return "", fmt.Errorf("Could not save the log of the run: %s", err.Error())
+  }
+
+  // Remove the oldest logs
+  files, err := ioutil.ReadDir(filepath.Dir(path))
+  if err == nil && len(files) > keepRunLogs {
+    var names []string
+    for _, file := range files {
+      names = append(names, file.Name())
+    }
+    sort.Strings(names)
+    for _, old := range names[:len(names)-keepRunLogs] {
+      os.Remove(filepath.Join(filepath.Dir(path), old))
+    }
+  }
+
+  return filepath.Join(".wheels", runLogsDir, name), nil
+}
+
+/**
+ * Prints what the last terraform run did and how long it took, keeping its
+ * output in a log file
+ */
+func (s *ProjectSandbox) PrintRunSummary(tf *TerraformWrapper) {
+  summary := ParseRunSummary(tf.GetLastCommand(), tf.GetLastOutput())
+  summary.Duration = tf.GetLastDuration()
+  if logFile, err := s.SaveRunLog(tf); err != nil {
+    PrintWarning("%s", err.Error())
+  } else {
+    summary.LogFile = logFile
+  }
+  if IsQuiet() {
+    return
+  }
+
+  status := Green("completed")
+  if tf.HasLastFailed() {
+    status = Red("failed")
+  }
+  Println()
+  Printf("%s %s %s in %s", Bold("Summary:"), summary.Command, status, Bold(summary.Duration.Round(time.Second)))
+  if summary.Counts != "" {
+    Printf(" (%s)", summary.Counts)
+  }
+  Println()
+  if len(summary.Slowest) > 0 {
+    Println("  The slowest resources were:")
+    for _, timing := range summary.Slowest {
+      Printf("    %-10s %s (%s)\n", timing.Duration.Round(time.Second), timing.Address, timing.Action)
+    }
+  }
+  if summary.LogFile != "" {
+    Printf("  The full output is in %s\n", summary.LogFile)
+  }
+}
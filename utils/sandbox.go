@@ -9,11 +9,11 @@ import (
   "os/user"
   "path/filepath"
   // "reflect"
+  "regexp"
   "strings"
 
   "github.com/gobwas/glob"
   "github.com/hashicorp/hcl"
-  "github.com/hashicorp/hcl/hcl/printer"
   "github.com/imdario/mergo"
 )
 
@@ -21,13 +21,38 @@ var RequiredTerraformVersionPrefix string = "0.11."
 
 type ProjectSandbox struct {
   baseDir string
+  config  *WheelsConfig
 
   // Structure is:
   // { resourceType: { resourceName: { .. merged fields .. } } }
   tfProject map[string]map[string]map[string]interface{}
+
+  // If it holds the lock of the project
+  locked bool
 }
 
+/**
+ * Opens the project in the given directory, locking it until the end of the
+ * run (or until Unlock), so no other run can use it at the same time
+ */
 func OpenSandbox(baseDir string) (*ProjectSandbox, error) {
+  sandbox, err := OpenSandboxWithoutLock(baseDir)
+  if err != nil {
+    return nil, err
+  }
+  if err := sandbox.Lock(); err != nil {
+    return nil, err
+  }
+  return sandbox, nil
+}
+
+/**
+ * Opens the project without locking it, to only read it (eg. for the help
+ * or `terraform output`)
+ */
+func OpenSandboxWithoutLock(baseDir string) (*ProjectSandbox, error) {
+  defer StartSpan("sandbox", "open sandbox").End()
+
   fPath, err := filepath.Abs(baseDir)
   if err != nil {
     return nil, fmt.Errorf("could not compute absolute path: %s", err.Error())
@@ -45,7 +70,14 @@ func OpenSandbox(baseDir string) (*ProjectSandbox, error) {
     }
   }
 
-  sandbox := &ProjectSandbox{fPath, make(map[string]map[string]map[string]interface{})}
+  config, err := LoadWheelsConfig(fPath)
+  if err != nil {
+    return nil, err
+  }
+  applyOfflineConfig(fPath, config.Offline)
+  applyNetworkConfig(fPath, config.Network)
+
+  sandbox := &ProjectSandbox{fPath, config, make(map[string]map[string]map[string]interface{}), false}
   err = sandbox.ReloadTerraformProject()
   if err != nil {
     return nil, err
@@ -64,6 +96,13 @@ func (s *ProjectSandbox) ReloadTerraformProject() error {
   return nil
 }
 
+/**
+ * @brief      Returns the project configuration from .wheels.yaml
+ */
+func (s *ProjectSandbox) GetConfig() *WheelsConfig {
+  return s.config
+}
+
 /**
  * @brief      Return the full path to the given file
  */
@@ -85,6 +124,70 @@ func (s *ProjectSandbox) GetTemporaryPath(name string) (string, error) {
   return fullPath, nil
 }
 
+/**
+ * @brief      Returns a file in the .wheels directory, where terraform-wheels
+ *             keeps the project state that is not part of terraform.
+ */
+func (s *ProjectSandbox) GetWheelsPath(name string) (string, error) {
+  fullPath := filepath.Join(s.baseDir, ".wheels", name)
+
+  if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+    return "", fmt.Errorf("Unable to create .wheels directory")
+  }
+
+  return fullPath, nil
+}
+
+// What terraform and terraform-wheels leave behind in a project
+var sandboxArtifacts []string = []string{
+  ".terraform", ".wheels", "terraform.tfstate", "terraform.tfstate.backup", "plan.out",
+}
+
+/**
+ * @brief      Removes the local state, the caches and the plans from the
+ *             project, returning the ones that were there
+ */
+func (s *ProjectSandbox) CleanArtifacts() ([]string, error) {
+  var removed []string
+  // The socket of the agent and the lock are in .wheels
+  if _, err := s.StopProjectSSHAgent(); err != nil {
+    return removed, err
+  }
+  releaseSandboxLock(s.baseDir)
+  for _, name := range sandboxArtifacts {
+    if !s.HasFile(name) {
+      continue
+    }
+    if err := s.removeArtifact(name); err != nil {
+      return removed, fmt.Errorf("Could not remove %s: %s", name, err.Error())
+    }
+    removed = append(removed, name)
+  }
+  return removed, nil
+}
+
+/**
+ * Removes an artifact of the project, but not the policies in .wheels, that
+ * are part of the project
+ */
+func (s *ProjectSandbox) removeArtifact(name string) error {
+  if name != ".wheels" || !s.HasFile(filepath.Join(".wheels", policiesDir)) {
+    return os.RemoveAll(s.GetFilePath(name))
+  }
+  entries, err := ioutil.ReadDir(s.GetFilePath(name))
+  if err != nil {
+    return err
+  }
+  for _, entry := range entries {
+    if entry.Name() != policiesDir {
+      if err := os.RemoveAll(filepath.Join(s.GetFilePath(name), entry.Name())); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
 /**
  * @brief      Checks if a file exists
  */
@@ -111,9 +214,12 @@ func (s *ProjectSandbox) IsEmpty() (bool, error) {
     if info.IsDir() {
       return nil
     }
-    if info.Name()[0] == '.' || strings.Contains(path, "/.") { // Ignore hidden
+    if info.Name()[0] == '.' || strings.Contains(path, string(filepath.Separator)+".") { // Ignore hidden
       return nil
     }
+    if info.IsDir() && path != s.baseDir && isProjectDir(path) { // Ignore the projects in sub-directories
+      return filepath.SkipDir
+    }
 
     foundFile = true
     return nil
@@ -128,9 +234,12 @@ func (s *ProjectSandbox) IsEmpty() (bool, error) {
 func (s *ProjectSandbox) HasTerraformFiles() (bool, error) {
   hasTf := false
   err := filepath.Walk(s.baseDir, func(path string, info os.FileInfo, err error) error {
-    if info.Name()[0] == '.' || strings.Contains(path, "/.") { // Ignore hidden
+    if info.Name()[0] == '.' || strings.Contains(path, string(filepath.Separator)+".") { // Ignore hidden
       return nil
     }
+    if info.IsDir() && path != s.baseDir && isProjectDir(path) { // Ignore the projects in sub-directories
+      return filepath.SkipDir
+    }
     if strings.HasSuffix(path, ".tf") {
       hasTf = true
     }
@@ -150,8 +259,6 @@ func (s *ProjectSandbox) HasTerraformFiles() (bool, error) {
  * @return     True if system terraform, False otherwise.
  */
 func (s *ProjectSandbox) HasTerraform() bool {
-  terraformDir := filepath.Join(s.baseDir, ".terraform")
-
   path, err := exec.LookPath(ExecutableName("terraform"))
   if err == nil {
     w := CreateTeraformWrapper(path)
@@ -162,9 +269,17 @@ func (s *ProjectSandbox) HasTerraform() bool {
     }
   }
 
-  fBinPath := filepath.Join(terraformDir, "bin")
-  fPath := filepath.Join(fBinPath, ExecutableName("terraform"))
-  _, err = os.Stat(fPath)
+  if IsOffline() {
+    _, err := getMirrorTerraform()
+    return err == nil
+  }
+
+  cacheDir, err := GetCacheDir(filepath.Join("terraform", upstreamTerraformVersion))
+  if err != nil {
+    return false
+  }
+
+  _, err = os.Stat(filepath.Join(cacheDir, ExecutableName("terraform")))
   return err == nil
 }
 
@@ -173,7 +288,7 @@ func (s *ProjectSandbox) HasTerraform() bool {
  *             sandbox directory.
  */
 func (s *ProjectSandbox) GetTerraform() (*TerraformWrapper, error) {
-  terraformDir := filepath.Join(s.baseDir, ".terraform")
+  defer StartSpan("sandbox", "get terraform").End()
 
   // First lookup terraform in the environment
   path, err := exec.LookPath(ExecutableName("terraform"))
@@ -182,51 +297,123 @@ func (s *ProjectSandbox) GetTerraform() (*TerraformWrapper, error) {
     if ver, err := w.GetVersion(); err == nil {
       if strings.HasPrefix(ver, RequiredTerraformVersionPrefix) {
         PrintInfo("Using system terraform v%s", ver)
-        return w, nil
+        if IsOffline() {
+          w.SetEnv("CHECKPOINT_DISABLE", "1")
+          return w, nil
+        }
+        return w, s.usePluginCache(w)
       }
     }
   }
 
-  fBinPath := filepath.Join(terraformDir, "bin")
-  fPath := filepath.Join(fBinPath, ExecutableName("terraform"))
-  if err = os.MkdirAll(fBinPath, os.ModePerm); err != nil {
-    return nil, fmt.Errorf("Unable to create terraform directory")
+  // In offline mode we can only use the pre-seeded binary
+  if IsOffline() {
+    fPath, err := getMirrorTerraform()
+    if err != nil {
+      return nil, err
+    }
+    w := CreateTeraformWrapper(fPath)
+    ver, err := w.GetVersion()
+    if err != nil || !strings.HasPrefix(ver, RequiredTerraformVersionPrefix) {
+      return nil, fmt.Errorf("The terraform binary in %s is not compatible with our %sx requirements", fPath, RequiredTerraformVersionPrefix)
+    }
+    PrintInfo("Using offline terraform v%s", ver)
+    w.SetEnv("CHECKPOINT_DISABLE", "1")
+    return w, nil
   }
 
-  _, err = os.Stat(fPath)
+  // Otherwise use the binary from the global cache, that is shared between
+  // all the sandboxes (and possibly between parallel CI jobs)
+  cacheDir, err := GetCacheDir(filepath.Join("terraform", upstreamTerraformVersion))
   if err != nil {
-    if !os.IsNotExist(err) {
-      return nil, fmt.Errorf("Could not check if terraform binary exists: %s", err.Error())
-    }
+    return nil, err
+  }
 
-    // Findt he upstream URL to use
-    url, checksum, err := upstreamGetTerraform()
-    if err != nil {
-      return nil, err
-    }
+  lock, err := LockCacheDir(cacheDir)
+  if err != nil {
+    return nil, err
+  }
+  defer lock.Release()
 
-    // Download terraform
-    err = Download(url, WithDefaults).
-      AndShowProgress("Downloading terraform").
-      AndValidateChecksum(checksum).
-      EventuallyUnzipTo(fBinPath, 0)
+  fPath := filepath.Join(cacheDir, ExecutableName("terraform"))
+  _, err = os.Stat(fPath)
+  if err == nil && !IsCachedFileValid(fPath) {
+    PrintWarning("The cached terraform binary is corrupted, downloading it again")
+    RemoveCachedFile(fPath)
+  } else if err != nil && !os.IsNotExist(err) {
+    return nil, fmt.Errorf("Could not check if terraform binary exists: %s", err.Error())
+  }
+
+  if !IsCachedFileValid(fPath) {
+    err = downloadTerraformTo(cacheDir, fPath)
     if err != nil {
-      FatalError(err)
+      return nil, err
     }
   }
 
   // Try to use the binary
   w := CreateTeraformWrapper(fPath)
   ver, err := w.GetVersion()
+  if err != nil || ver != upstreamTerraformVersion {
+    RemoveCachedFile(fPath)
+    return nil, fmt.Errorf("The cached terraform binary is not usable and was removed. Please re-run again.")
+  }
+
+  PrintInfo("Using cached terraform v%s", upstreamTerraformVersion)
+  return w, s.usePluginCache(w)
+}
+
+/**
+ * Downloads terraform in a temporary directory next to `fPath` and atomically
+ * moves it into place when the download is verified
+ */
+func downloadTerraformTo(cacheDir string, fPath string) error {
+  // Findt he upstream URL to use
+  url, checksum, err := upstreamGetTerraform()
+  if err != nil {
+    return err
+  }
+
+  tmpDir, err := ioutil.TempDir(cacheDir, ".download-")
+  if err != nil {
+    return fmt.Errorf("Unable to create download directory: %s", err.Error())
+  }
+  defer os.RemoveAll(tmpDir)
+
+  // Download terraform
+  err = Download(url, InParallel).
+    AndShowProgress("Downloading terraform").
+    AndValidateChecksum(checksum).
+    EventuallyUnzipTo(tmpDir, 0)
   if err != nil {
-    return nil, fmt.Errorf("Unable to execute the cached terraform binary. Try deleting .terraform directory and re-run again.")
+    return fmt.Errorf("Could not download terraform: %s", err.Error())
+  }
+
+  return InstallCachedFile(filepath.Join(tmpDir, ExecutableName("terraform")), fPath)
+}
+
+/**
+ * Points terraform to the shared provider cache, unless the user has
+ * configured one already
+ */
+func (s *ProjectSandbox) usePluginCache(w *TerraformWrapper) error {
+  if _, ok := os.LookupEnv("TF_PLUGIN_CACHE_DIR"); ok {
+    return nil
+  }
+
+  // The mirror populated with `wheels-mirror` has the same layout
+  if dir := getMirrorPluginCache(); dir != "" {
+    w.SetEnv("TF_PLUGIN_CACHE_DIR", dir)
+    return nil
   }
-  if ver != upstreamTerraformVersion {
-    return nil, fmt.Errorf("Unexpected cached terraform version. Try deleting .terraform directory and re-run again")
+
+  dir, err := GetCacheDir("plugins")
+  if err != nil {
+    return err
   }
 
-  PrintInfo("Using project-local terraform v%s", upstreamTerraformVersion)
-  return w, nil
+  w.SetEnv("TF_PLUGIN_CACHE_DIR", dir)
+  return nil
 }
 
 /**
@@ -251,8 +438,7 @@ func (s *ProjectSandbox) InitProject() error {
     `}`,
     ``,
     `module "dcos" {`,
-    `  source  = "dcos-terraform/dcos/aws"`,
-    fmt.Sprintf(`  version = "~> %s"`, GetLatestModuleVersion("0.2.0")),
+    GetModuleSource("dcos-terraform/dcos/aws", "0.2.0"),
     ``,
     `  providers = {`,
     `    aws = "aws"`,
@@ -332,12 +518,74 @@ func (s *ProjectSandbox) WriteFile(file string, contents []byte) error {
 }
 
 func (s *ProjectSandbox) WriteFormattedTerraformFile(file string, contents []byte) error {
-  contents, err := printer.Format(contents)
+  contents, err := FormatTerraform(contents)
   if err != nil {
     return fmt.Errorf("Could not format output: %s", err.Error())
   }
 
-  return s.WriteFile(file, contents)
+  s.checkProvenance()
+  return s.WriteFile(file, stampProvenance(contents))
+}
+
+/**
+ * @brief      Edits the existing `blockType "name" { }` block, in whichever
+ *             file it is defined, keeping the rest of the file as is
+ */
+func (s *ProjectSandbox) EditTerraformBlock(blockType string, name string, edit func(*TerraformBlock) error) error {
+  file, content, err := s.PrepareTerraformBlockEdit(blockType, name, edit)
+  if err != nil {
+    return err
+  }
+  if err := s.WriteFormattedTerraformFile(file, content); err != nil {
+    return err
+  }
+  return s.ReloadTerraformProject()
+}
+
+/**
+ * @brief      Edits the existing `blockType "name" { }` block without writing
+ *             it, returning the file it is defined in and its new content
+ */
+func (s *ProjectSandbox) PrepareTerraformBlockEdit(blockType string, name string, edit func(*TerraformBlock) error) (string, []byte, error) {
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return "", nil, fmt.Errorf("Could not enumerate files: %s", err.Error())
+  }
+
+  for _, file := range files {
+    if !strings.HasSuffix(file.Name(), ".tf") {
+      continue
+    }
+
+    content, err := s.ReadFile(file.Name())
+    if err != nil {
+      return "", nil, fmt.Errorf("Could not read %s: %s", file.Name(), err.Error())
+    }
+    tf, err := ParseTerraformFile(content)
+    if err != nil {
+      return "", nil, fmt.Errorf("Could not parse %s: %s", file.Name(), err.Error())
+    }
+
+    block := tf.Block(blockType, name)
+    if block == nil {
+      continue
+    }
+    if err := edit(block); err != nil {
+      return "", nil, err
+    }
+
+    updated, err := tf.Bytes()
+    if err != nil {
+      return "", nil, err
+    }
+    updated, err = FormatTerraform(updated)
+    if err != nil {
+      return "", nil, fmt.Errorf("Could not format %s: %s", file.Name(), err.Error())
+    }
+    return file.Name(), updated, nil
+  }
+
+  return "", nil, fmt.Errorf("Could not find %s \"%s\" in the project files", blockType, name)
 }
 
 func (s *ProjectSandbox) ReadFile(file string) ([]byte, error) {
@@ -399,6 +647,9 @@ func (s *ProjectSandbox) ReadTerraformProject() (map[string]map[string]map[strin
                   return nil, fmt.Errorf("Could not merge resource '%s.%s': %s", resType, resName, err.Error())
                 }
               }
+            } else if resType == "terraform" || resType == "locals" {
+              // Blocks without labels, their attributes are not resources
+              continue
             } else {
               return nil, fmt.Errorf("Unexpected resource '%s.%s' type: %v", resType, resName, resValueMapArray)
             }
@@ -474,10 +725,35 @@ func (s *ProjectSandbox) GetTerraformResourcesMatchingName(resType string, nameG
   return ret
 }
 
-func (s *ProjectSandbox) PrintVariableDefs() {
-  var listNames []string
-  var mapNames []string
+/**
+ * @brief      Resolves a value that is a plain reference to a variable (eg.
+ *             "${var.num_masters}") to its value in terraform.tfvars, or to
+ *             its default. Any other value is returned as-is.
+ */
+func (s *ProjectSandbox) ResolveTerraformValue(value interface{}) interface{} {
+  str, ok := value.(string)
+  if !ok {
+    return value
+  }
+  m := regexp.MustCompile(`^\$\{var\.([A-Za-z0-9_-]+)\}$`).FindStringSubmatch(str)
+  if m == nil {
+    return value
+  }
 
+  if s.HasFile("terraform.tfvars") {
+    if tfvars, err := s.ReadTerraformFile("terraform.tfvars"); err == nil {
+      if v, ok := tfvars[m[1]]; ok {
+        return v
+      }
+    }
+  }
+  if v, ok := s.GetTerraformResources("variable")[m[1]]["default"]; ok {
+    return v
+  }
+  return value
+}
+
+func (s *ProjectSandbox) PrintVariableDefs() {
   fmt.Printf("var tfc TerraformFileConfig\n")
   fmt.Printf("tfc.Flags = flag.NewFlagSet(p.GetName(), flag.ContinueOnError)\n")
 
@@ -504,20 +780,15 @@ func (s *ProjectSandbox) PrintVariableDefs() {
     if ftype == "list" {
       desc += " (use multiple times to add multiple values)"
       jv, _ := json.Marshal(desc)
-      fmt.Printf("tfc.Flags.String(\"%s\", \"\", %s)\n", k, string(jv))
-      listNames = append(listNames, k)
+      fmt.Printf("tfc.ListFlag(\"%s\", %s)\n", k, string(jv))
     }
     if ftype == "map" {
-      desc += " (use key=value format)"
+      desc += " (use key=value format, multiple times to add multiple values)"
       jv, _ := json.Marshal(desc)
-      fmt.Printf("tfc.Flags.String(\"%s\", \"\", %s)\n", k, string(jv))
-      mapNames = append(listNames, k)
+      fmt.Printf("tfc.MapFlag(\"%s\", %s)\n", k, string(jv))
     }
   }
 
-  fmt.Printf("\n")
-  fmt.Printf("tfc.ListFlags = %#v\n", listNames)
-  fmt.Printf("tfc.MapFlags = %#v\n", mapNames)
   fmt.Printf("\n")
   fmt.Printf("tfc.PreLines = []string{}\n")
   fmt.Printf("tfc.BodyLines = []string{}\n")
@@ -0,0 +1,208 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+)
+
+// Locked while a run uses the project, with who holds it next to it (the lock
+// file itself can't be read while it's locked on Windows)
+var sandboxLockName string = "wheels.lock"
+var sandboxLockInfoName string = "wheels.lock.json"
+
+// Break the lock of the project, even if its holder is still running
+var forceUnlockWheels bool = false
+
+/**
+ * A project locked by this process, with the number of sandboxes using it
+ */
+type sandboxLock struct {
+  lock              *FileLock
+  count             int
+  unregisterCleanup func()
+}
+
+var sandboxLocksMutex sync.Mutex
+var sandboxLocks map[string]*sandboxLock = make(map[string]*sandboxLock)
+
+/**
+ * The run that holds the lock of a project
+ */
+type SandboxLockInfo struct {
+  Pid       int       `json:"pid"`
+  Host      string    `json:"host"`
+  Command   string    `json:"command"`
+  StartedAt time.Time `json:"started_at"`
+}
+
+/**
+ * Breaks the lock of the project, if another run still holds it
+ */
+func SetForceUnlockWheels(enabled bool) {
+  forceUnlockWheels = enabled
+}
+
+func (i *SandboxLockInfo) String() string {
+  return fmt.Sprintf("pid %d on %s, running `%s` since %s", i.Pid, i.Host, i.Command, time.Since(i.StartedAt).Round(time.Second))
+}
+
+/**
+ * Checks if the run that holds the lock is gone. It can only be told for the
+ * runs of this machine.
+ */
+func (i *SandboxLockInfo) isStale() bool {
+  host, _ := os.Hostname()
+  return i.Host == host && !isProcessRunning(i.Pid)
+}
+
+/**
+ * Returns who holds the lock of the project, or nil if it's not known
+ */
+func (s *ProjectSandbox) GetLockInfo() *SandboxLockInfo {
+  path, err := s.GetWheelsPath(sandboxLockInfoName)
+  if err != nil {
+    return nil
+  }
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil
+  }
+  info := &SandboxLockInfo{}
+  if err := json.Unmarshal(content, info); err != nil || info.Pid == 0 {
+    return nil
+  }
+  return info
+}
+
+func tryLockFile(path string) (*FileLock, error) {
+  f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+  if err != nil {
+    return nil, fmt.Errorf("Could not open %s: %s", path, err.Error())
+  }
+  if err := lockFile(f, false); err != nil {
+    f.Close()
+    return nil, err
+  }
+  return &FileLock{path, f}, nil
+}
+
+/**
+ * Locks the project, so two runs can't generate files or run terraform in it
+ * at the same time. A lock whose run is gone is taken over, and any lock is
+ * broken with --force-unlock-wheels.
+ */
+func (s *ProjectSandbox) Lock() error {
+  sandboxLocksMutex.Lock()
+  defer sandboxLocksMutex.Unlock()
+  if s.locked {
+    return nil
+  }
+  if held, ok := sandboxLocks[s.baseDir]; ok {
+    held.count += 1
+    s.locked = true
+    return nil
+  }
+
+  path, err := s.GetWheelsPath(sandboxLockName)
+  if err != nil {
+    return err
+  }
+  lock, err := tryLockFile(path)
+  if err == errLockBusy {
+    info := s.GetLockInfo()
+    holder := "an unknown run"
+    if info != nil {
+      holder = info.String()
+    }
+
+    if forceUnlockWheels {
+      PrintWarning("Breaking the lock of the project, held by %s", holder)
+    } else if info != nil && info.isStale() {
+      PrintWarning("Taking over the lock of the project, its run is gone (%s)", holder)
+    } else {
+      return WithExitCode(ExitConfigError, fmt.Errorf("Another %s is using this project (%s), wait for it to finish, or use --force-unlock-wheels if it's stuck", os.Args[0], holder))
+    }
+
+    // Whoever still has the old lock file open keeps it locked, so lock a new one
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+      return fmt.Errorf("Could not remove the lock %s: %s", path, err.Error())
+    }
+    lock, err = tryLockFile(path)
+    if err == errLockBusy {
+      return WithExitCode(ExitConfigError, fmt.Errorf("Another %s locked this project in the meantime", os.Args[0]))
+    }
+  }
+  if err != nil {
+    return fmt.Errorf("Could not lock the project: %s", err.Error())
+  }
+
+  host, _ := os.Hostname()
+  info := &SandboxLockInfo{os.Getpid(), host, GetTerraformCommand(os.Args[1:]), time.Now()}
+  if content, err := json.Marshal(info); err == nil {
+    if infoPath, err := s.GetWheelsPath(sandboxLockInfoName); err == nil {
+      ioutil.WriteFile(infoPath, content, 0644)
+    }
+  }
+
+  baseDir := s.baseDir
+  sandboxLocks[baseDir] = &sandboxLock{lock: lock, count: 1}
+  sandboxLocks[baseDir].unregisterCleanup = RegisterCleanup(func() {
+    releaseSandboxLock(baseDir)
+  })
+  s.locked = true
+  return nil
+}
+
+/**
+ * Releases the lock of the project, once no sandbox of this process uses it
+ */
+func (s *ProjectSandbox) Unlock() {
+  sandboxLocksMutex.Lock()
+  defer sandboxLocksMutex.Unlock()
+  if !s.locked {
+    return
+  }
+  s.locked = false
+
+  held, ok := sandboxLocks[s.baseDir]
+  if !ok {
+    return
+  }
+  held.count -= 1
+  if held.count == 0 {
+    held.unregisterCleanup()
+    delete(sandboxLocks, s.baseDir)
+    releaseLockFile(s.baseDir, held.lock)
+  }
+}
+
+/**
+ * Releases the lock of the project, even if other sandboxes of this process
+ * still use it (eg. before removing the .wheels directory)
+ */
+func releaseSandboxLock(baseDir string) {
+  sandboxLocksMutex.Lock()
+  defer sandboxLocksMutex.Unlock()
+  if held, ok := sandboxLocks[baseDir]; ok {
+    held.unregisterCleanup()
+    delete(sandboxLocks, baseDir)
+    releaseLockFile(baseDir, held.lock)
+  }
+}
+
+func releaseLockFile(baseDir string, lock *FileLock) {
+  // Without creating the .wheels directory again if it was removed
+  infoPath := filepath.Join(baseDir, ".wheels", sandboxLockInfoName)
+  if content, err := ioutil.ReadFile(infoPath); err == nil {
+    info := &SandboxLockInfo{}
+    if json.Unmarshal(content, info) == nil && info.Pid == os.Getpid() {
+      os.Remove(infoPath)
+    }
+  }
+  lock.Release()
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+/**
+ * A provider of a new project, pinned to a version
+ */
+type projectProvider struct {
+  Name     string
+  Version  string
+  Settings []string
+}
+
+/**
+ * The skeleton of a cluster a new project can start with
+ */
+type projectTemplate struct {
+  source    string
+  providers []projectProvider
+}
+
+var projectTemplates map[string]projectTemplate = map[string]projectTemplate{
+  "aws": {"dcos-terraform/dcos/aws", []projectProvider{
+    {"aws", "~> 2.0", []string{`region = "us-west-2"`}},
+  }},
+  "gcp": {"dcos-terraform/dcos/gcp", []projectProvider{
+    {"google", "~> 2.0", []string{`project = "my-project"`, `region  = "us-west1"`}},
+  }},
+  "azure": {"dcos-terraform/dcos/azurerm", []projectProvider{
+    {"azurerm", "~> 1.44", nil},
+  }},
+  "onprem": {"dcos-terraform/dcos-install-remote-exec-ansible/null", []projectProvider{
+    {"null", "~> 2.1", nil},
+  }},
+}
+
+// The context shared by the templates of a new project
+type newProjectTemplateContext struct {
+  Name             string
+  TerraformVersion string
+  ModuleSource     string
+  Providers        []projectProvider
+}
+
+/**
+ * Returns the names of the cluster skeletons of `new --template`
+ */
+func GetProjectTemplates() []string {
+  var names []string
+  for name := range projectTemplates {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+/**
+ * @brief      Creates a project in a new directory, with a .wheels.yaml, a
+ *             .gitignore, the versions of terraform and the providers, and
+ *             the skeleton of a cluster if a template is given. Returns the
+ *             files that were created.
+ *
+ * The files are meant to be edited, so they are not tracked as generated.
+ */
+func CreateProject(dir string, templateName string) ([]string, error) {
+  tmpl, ok := projectTemplates[templateName]
+  if templateName != "" && !ok {
+    return nil, fmt.Errorf("Unknown template '%s', expecting one of %s", templateName, strings.Join(GetProjectTemplates(), ", "))
+  }
+  if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+    return nil, fmt.Errorf("The directory %s already exists and is not empty", dir)
+  }
+
+  project, err := OpenSandbox(dir)
+  if err != nil {
+    return nil, err
+  }
+
+  context := newProjectTemplateContext{
+    Name:             filepath.Base(project.baseDir),
+    TerraformVersion: RequiredTerraformVersionPrefix + "0",
+    Providers:        tmpl.providers,
+  }
+  files, err := RenderTemplates("new", context)
+  if err != nil {
+    return nil, err
+  }
+  if templateName != "" {
+    context.ModuleSource = GetModuleSource(tmpl.source, "0.2.0")
+    cluster, err := RenderTemplates("new-"+templateName, context)
+    if err != nil {
+      return nil, err
+    }
+    for name, content := range cluster {
+      files[name] = content
+    }
+  }
+
+  var names []string
+  for name := range files {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    if err := project.WriteFormattedTerraformFile(name, files[name]); err != nil {
+      return nil, err
+    }
+  }
+
+  config := []string{
+    "# The configuration of terraform-wheels for this project, see the README of",
+    "# https://github.com/mesosphere-incubator/terraform-wheels for all the options",
+  }
+  if ver := getWheelsSemver(); ver != nil {
+    config = append(config, fmt.Sprintf(`required_wheels_version: ">= %d.%d.0"`, ver.Major(), ver.Minor()))
+  }
+  if err := project.WriteFile(WheelsConfigFile, []byte(strings.Join(config, "\n")+"\n")); err != nil {
+    return nil, err
+  }
+
+  if err := project.ReloadTerraformProject(); err != nil {
+    return nil, err
+  }
+  if err := project.BootstrapRepository(); err != nil {
+    return nil, err
+  }
+  return append([]string{WheelsConfigFile, ".gitignore", "README.md"}, names...), nil
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+)
+
+/**
+ * A secret found in plain text in a project
+ */
+type SecretFinding struct {
+  File string
+  Line int
+  Kind string
+}
+
+type secretScanPattern struct {
+  kind string
+  re   *regexp.Regexp
+}
+
+// What we look for in the files of a project. The values that come from
+// variables (`${...}`) are not secrets by themselves.
+var secretScanPatterns []secretScanPattern = []secretScanPattern{
+  {"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+  {"AWS secret key", regexp.MustCompile(`(?i)secret_access_key\s*=\s*"[A-Za-z0-9/+=]{40}"`)},
+  {"authentication token", secretPatterns[0]},
+  {"password hash", secretPatterns[1]},
+  {"password", regexp.MustCompile(`(?i)password[a-z_]*\s*=\s*"[^"$]+"`)},
+  {"token", regexp.MustCompile(`(?i)_token\s*=\s*"[^"$]+"`)},
+  {"license key", regexp.MustCompile(`(?i)license_key_contents\s*=\s*"[^"$]+"`)},
+  // Must be the last one, it's the only one checked in the other files
+  {"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+}
+
+/**
+ * @brief      Looks for secrets kept in plain text in the project in the
+ *             given directory
+ *
+ * The terraform files and variables are scanned, unless they are gitignored
+ * (like the secrets.auto.tfvars we generate), as well as the leftovers of
+ * earlier versions (eg. the plain-text credentials cache).
+ */
+func ScanSecrets(dir string) ([]SecretFinding, error) {
+  entries, err := ioutil.ReadDir(dir)
+  if err != nil {
+    return nil, fmt.Errorf("Could not enumerate files: %s", err.Error())
+  }
+
+  var findings []SecretFinding
+  for _, entry := range entries {
+    name := entry.Name()
+    if entry.IsDir() || isGitIgnored(dir, name) {
+      continue
+    }
+    patterns := secretScanPatterns
+    if !strings.HasSuffix(name, ".tf") && !strings.HasSuffix(name, ".tfvars") && !strings.HasSuffix(name, ".tf.json") {
+      // Other files can only be private keys, that are small
+      if entry.Size() > 64*1024 {
+        continue
+      }
+      patterns = secretScanPatterns[len(secretScanPatterns)-1:]
+    }
+    found, err := scanSecretsInFile(filepath.Join(dir, name), name, patterns)
+    if err != nil {
+      return nil, err
+    }
+    findings = append(findings, found...)
+  }
+
+  sort.SliceStable(findings, func(i, j int) bool {
+    return findings[i].File < findings[j].File
+  })
+  return findings, nil
+}
+
+func scanSecretsInFile(path string, name string, patterns []secretScanPattern) ([]SecretFinding, error) {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", name, err.Error())
+  }
+
+  var findings []SecretFinding
+  for i, line := range strings.Split(string(content), "\n") {
+    trimmed := strings.TrimSpace(line)
+    if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+      continue
+    }
+    for _, pattern := range patterns {
+      if pattern.re.MatchString(line) {
+        findings = append(findings, SecretFinding{name, i + 1, pattern.kind})
+        break
+      }
+    }
+  }
+  return findings, nil
+}
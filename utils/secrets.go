@@ -0,0 +1,226 @@
+package utils
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+)
+
+/**
+ * A place where we can keep secrets between invocations
+ */
+type CredentialStore interface {
+  Get(key string) (string, error)
+  Set(key string, value string) error
+  Delete(key string) error
+}
+
+/**
+ * Encrypts or decrypts the contents of a secrets file
+ */
+type secretCipher interface {
+  Seal(plain []byte) ([]byte, error)
+  Open(sealed []byte) ([]byte, error)
+}
+
+/**
+ * Stores all the secrets in a single, encrypted JSON file
+ */
+type fileCredentialStore struct {
+  path   string
+  cipher secretCipher
+}
+
+func (s *fileCredentialStore) load() (map[string]string, error) {
+  values := make(map[string]string)
+
+  content, err := ioutil.ReadFile(s.path)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return values, nil
+    }
+    return nil, fmt.Errorf("Could not read credentials: %s", err.Error())
+  }
+
+  content, err = s.cipher.Open(content)
+  if err != nil {
+    return nil, fmt.Errorf("Could not decrypt credentials: %s", err.Error())
+  }
+
+  err = json.Unmarshal(content, &values)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse credentials: %s", err.Error())
+  }
+
+  return values, nil
+}
+
+func (s *fileCredentialStore) save(values map[string]string) error {
+  content, err := json.Marshal(values)
+  if err != nil {
+    return err
+  }
+
+  content, err = s.cipher.Seal(content)
+  if err != nil {
+    return fmt.Errorf("Could not encrypt credentials: %s", err.Error())
+  }
+
+  if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+    return fmt.Errorf("Could not create %s: %s", filepath.Dir(s.path), err.Error())
+  }
+  err = ioutil.WriteFile(s.path, content, 0600)
+  if err != nil {
+    return fmt.Errorf("Could not write credentials: %s", err.Error())
+  }
+  return nil
+}
+
+func (s *fileCredentialStore) Get(key string) (string, error) {
+  values, err := s.load()
+  if err != nil {
+    return "", err
+  }
+  return values[key], nil
+}
+
+func (s *fileCredentialStore) Set(key string, value string) error {
+  values, err := s.load()
+  if err != nil {
+    return err
+  }
+  values[key] = value
+  return s.save(values)
+}
+
+func (s *fileCredentialStore) Delete(key string) error {
+  values, err := s.load()
+  if err != nil {
+    return err
+  }
+  delete(values, key)
+  return s.save(values)
+}
+
+/**
+ * AES-GCM encryption with a random key that is kept in the user's
+ * terraform-wheels home directory, readable only by the user
+ */
+type aesFileCipher struct {
+  aead cipher.AEAD
+}
+
+func createAesFileCipher() (*aesFileCipher, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return nil, err
+  }
+
+  keyFile := filepath.Join(home, "secret.key")
+  key, err := ioutil.ReadFile(keyFile)
+  if os.IsNotExist(err) {
+    key = make([]byte, 32)
+    if _, err := io.ReadFull(rand.Reader, key); err != nil {
+      return nil, fmt.Errorf("Could not generate encryption key: %s", err.Error())
+    }
+    if err := os.MkdirAll(home, os.ModePerm); err != nil {
+      return nil, fmt.Errorf("Could not create %s: %s", home, err.Error())
+    }
+    if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+      return nil, fmt.Errorf("Could not write encryption key: %s", err.Error())
+    }
+  } else if err != nil {
+    return nil, fmt.Errorf("Could not read encryption key: %s", err.Error())
+  }
+
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid encryption key %s: %s", keyFile, err.Error())
+  }
+  aead, err := cipher.NewGCM(block)
+  if err != nil {
+    return nil, err
+  }
+
+  return &aesFileCipher{aead}, nil
+}
+
+func (c *aesFileCipher) Seal(plain []byte) ([]byte, error) {
+  nonce := make([]byte, c.aead.NonceSize())
+  if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+    return nil, err
+  }
+  return c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *aesFileCipher) Open(sealed []byte) ([]byte, error) {
+  if len(sealed) < c.aead.NonceSize() {
+    return nil, fmt.Errorf("file is too short")
+  }
+  size := c.aead.NonceSize()
+  return c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+}
+
+/**
+ * @brief      Returns the store where secrets are kept. This is the OS
+ *             keychain when available, otherwise an encrypted file in the
+ *             sandbox.
+ */
+func (s *ProjectSandbox) GetCredentialStore() (CredentialStore, error) {
+  var store CredentialStore
+
+  backend := s.GetConfig().SecretStore
+  switch backend {
+  case "", "auto":
+    store = newPlatformKeychain()
+  case "keychain":
+    store = newPlatformKeychain()
+    if store == nil {
+      return nil, fmt.Errorf("The OS keychain is not available on this system")
+    }
+  case "file":
+  default:
+    return nil, fmt.Errorf("Unknown secret_store '%s', expecting one of: auto, keychain, file", backend)
+  }
+
+  if store == nil {
+    path, err := s.GetWheelsPath("credentials.enc")
+    if err != nil {
+      return nil, err
+    }
+    c, err := createAesFileCipher()
+    if err != nil {
+      return nil, err
+    }
+    store = &fileCredentialStore{path, c}
+  }
+
+  return store, nil
+}
+
+/**
+ * Returns the store where the secrets of the user (rather than of a project)
+ * are kept. This is the OS keychain when available, otherwise an encrypted
+ * file in the user's terraform-wheels directory.
+ */
+func GetUserCredentialStore() (CredentialStore, error) {
+  if store := newPlatformKeychain(); store != nil {
+    return store, nil
+  }
+
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return nil, err
+  }
+  c, err := createAesFileCipher()
+  if err != nil {
+    return nil, err
+  }
+  return &fileCredentialStore{filepath.Join(home, "credentials.enc"), c}, nil
+}
@@ -0,0 +1,349 @@
+package utils
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "encoding/json"
+  "encoding/pem"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "os/user"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/scrypt"
+)
+
+var shareBundleVersion int = 1
+var shareBundlePemType string = "TERRAFORM WHEELS SHARE"
+
+// The file where the backend configuration of a joined project is kept,
+// passed to `terraform init` with -backend-config
+var shareBackendConfigFile string = "backend.hcl"
+
+/**
+ * The remote backend the terraform state is kept in
+ */
+type ShareBackend struct {
+  Type   string                 `json:"type"`
+  Config map[string]interface{} `json:"config"`
+}
+
+/**
+ * Everything a teammate needs to operate the same cluster, without the
+ * terraform state or any secrets
+ */
+type ShareBundle struct {
+  Version      int               `json:"version"`
+  CreatedAt    time.Time         `json:"created_at"`
+  CreatedBy    string            `json:"created_by"`
+  Workspace    string            `json:"workspace"`
+  Backend      *ShareBackend     `json:"backend"`
+  Files        map[string]string `json:"files"`
+  Instructions []string          `json:"instructions"`
+}
+
+/**
+ * Checks if a backend configuration field could contain a secret
+ */
+func isSecretBackendField(name string) bool {
+  name = strings.ToLower(name)
+  for _, pat := range []string{"secret", "password", "token", "access_key"} {
+    if strings.Contains(name, pat) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * @brief      Returns the backend the project was initialized with, or nil
+ *             if the project keeps its state locally
+ */
+func (s *ProjectSandbox) GetBackend() (*ShareBackend, error) {
+  content, err := s.ReadFile(filepath.Join(".terraform", "terraform.tfstate"))
+  if err != nil {
+    if os.IsNotExist(err) {
+      return nil, nil
+    }
+    return nil, fmt.Errorf("Could not read backend state: %s", err.Error())
+  }
+
+  var state struct {
+    Backend *ShareBackend `json:"backend"`
+  }
+  err = json.Unmarshal(content, &state)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse backend state: %s", err.Error())
+  }
+  if state.Backend == nil || state.Backend.Type == "" || state.Backend.Type == "local" {
+    return nil, nil
+  }
+
+  return state.Backend, nil
+}
+
+/**
+ * @brief      Returns the name of the currently selected terraform workspace
+ */
+func (s *ProjectSandbox) GetWorkspace() string {
+  if v, ok := os.LookupEnv("TF_WORKSPACE"); ok && v != "" {
+    return v
+  }
+  content, err := s.ReadFile(filepath.Join(".terraform", "environment"))
+  if err != nil {
+    return "default"
+  }
+  if ws := strings.TrimSpace(string(content)); ws != "" {
+    return ws
+  }
+  return "default"
+}
+
+/**
+ * @brief      Returns the arguments to use when initializing the project,
+ *             including the backend configuration of a joined project
+ */
+func (s *ProjectSandbox) GetInitArgs() []string {
+  args := []string{"init"}
+
+  path := filepath.Join(s.baseDir, ".wheels", shareBackendConfigFile)
+  if _, err := os.Stat(path); err == nil {
+    args = append(args, fmt.Sprintf("-backend-config=%s", path))
+  }
+
+  return args
+}
+
+/**
+ * @brief      Collects the backend pointer, the workspace and the non-secret
+ *             project files in a bundle that can be shared with a teammate
+ */
+func (s *ProjectSandbox) CreateShareBundle() (*ShareBundle, error) {
+  backend, err := s.GetBackend()
+  if err != nil {
+    return nil, err
+  }
+  if backend == nil {
+    return nil, fmt.Errorf("The project keeps its terraform state locally, so nobody else can operate the cluster. Please configure a remote backend and run `init` first")
+  }
+
+  // Strip credentials from the backend config, the teammate will use their own
+  config := make(map[string]interface{})
+  for k, v := range backend.Config {
+    if isSecretBackendField(k) || v == nil {
+      continue
+    }
+    config[k] = v
+  }
+
+  createdBy := "somebody"
+  if u, err := user.Current(); err == nil {
+    createdBy = u.Username
+  }
+
+  bundle := &ShareBundle{
+    Version:   shareBundleVersion,
+    CreatedAt: time.Now().UTC(),
+    CreatedBy: createdBy,
+    Workspace: s.GetWorkspace(),
+    Backend:   &ShareBackend{backend.Type, config},
+    Files:     make(map[string]string),
+  }
+
+  // Only the project definition and public keys are shared. Variable files
+  // and private keys are likely to contain secrets.
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return nil, fmt.Errorf("Could not enumerate files: %s", err.Error())
+  }
+  var privateKeys []string
+  for _, file := range files {
+    name := file.Name()
+    if file.IsDir() {
+      continue
+    }
+    if !strings.HasSuffix(name, ".tf") && !strings.HasSuffix(name, ".pub") && name != WheelsConfigFile {
+      continue
+    }
+
+    content, err := s.ReadFile(name)
+    if err != nil {
+      return nil, fmt.Errorf("Could not read %s: %s", name, err.Error())
+    }
+    bundle.Files[name] = string(content)
+
+    if strings.HasSuffix(name, ".pub") && s.HasFile(GetPrivateKeyNameFromPublic(name)) {
+      privateKeys = append(privateKeys, GetPrivateKeyNameFromPublic(name))
+    }
+  }
+
+  bundle.Instructions = []string{
+    fmt.Sprintf("This project was shared by %s and uses the '%s' workspace of the %s backend.", createdBy, bundle.Workspace, backend.Type),
+    "Make sure you have your own credentials for the backend and the cloud provider configured.",
+  }
+  if len(privateKeys) > 0 {
+    bundle.Instructions = append(bundle.Instructions, fmt.Sprintf(
+      "The SSH private keys are not part of the bundle, ask %s for: %s", createdBy, strings.Join(privateKeys, ", ")))
+  }
+  bundle.Instructions = append(bundle.Instructions,
+    "Run `plan` to confirm you are looking at the same cluster before changing anything.")
+
+  return bundle, nil
+}
+
+/**
+ * @brief      Writes the project files of the bundle in the sandbox and points
+ *             it to the shared backend and workspace
+ */
+func (s *ProjectSandbox) ApplyShareBundle(bundle *ShareBundle, overwrite bool) error {
+  if bundle.Version > shareBundleVersion {
+    return fmt.Errorf("The bundle was created by a newer version of terraform-wheels, please upgrade")
+  }
+
+  var names []string
+  for name, _ := range bundle.Files {
+    if name != filepath.Base(name) {
+      return fmt.Errorf("Refusing to extract '%s' outside the project directory", name)
+    }
+    if s.HasFile(name) && !overwrite {
+      return fmt.Errorf("The file %s already exists in the project", name)
+    }
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    err := s.WriteFile(name, []byte(bundle.Files[name]))
+    if err != nil {
+      return err
+    }
+  }
+
+  if bundle.Backend != nil {
+    path, err := s.GetWheelsPath(shareBackendConfigFile)
+    if err != nil {
+      return err
+    }
+
+    var keys []string
+    for k, _ := range bundle.Backend.Config {
+      keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    var lines []string
+    for _, k := range keys {
+      v, err := json.Marshal(bundle.Backend.Config[k])
+      if err != nil {
+        return fmt.Errorf("Could not encode backend field %s: %s", k, err.Error())
+      }
+      lines = append(lines, fmt.Sprintf("%s = %s", k, string(v)))
+    }
+
+    err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+    if err != nil {
+      return fmt.Errorf("Could not write backend configuration: %s", err.Error())
+    }
+  }
+
+  // Terraform picks the workspace to use from this file during `init`
+  if bundle.Workspace != "" && bundle.Workspace != "default" {
+    path, err := s.GetTemporaryPath("environment")
+    if err != nil {
+      return err
+    }
+    err = ioutil.WriteFile(path, []byte(bundle.Workspace), 0644)
+    if err != nil {
+      return fmt.Errorf("Could not select workspace: %s", err.Error())
+    }
+  }
+
+  return s.ReloadTerraformProject()
+}
+
+func deriveShareKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+  key, err := scrypt.Key([]byte(passphrase), salt, 32768, 8, 1, 32)
+  if err != nil {
+    return nil, err
+  }
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    return nil, err
+  }
+  return cipher.NewGCM(block)
+}
+
+/**
+ * @brief      Encrypts the bundle with the given passphrase, returning a
+ *             PEM-armored text that is safe to paste around
+ */
+func SealShareBundle(bundle *ShareBundle, passphrase string) ([]byte, error) {
+  plain, err := json.Marshal(bundle)
+  if err != nil {
+    return nil, err
+  }
+
+  salt := make([]byte, 16)
+  if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+    return nil, fmt.Errorf("Could not generate salt: %s", err.Error())
+  }
+  aead, err := deriveShareKey(passphrase, salt)
+  if err != nil {
+    return nil, fmt.Errorf("Could not derive key: %s", err.Error())
+  }
+  nonce := make([]byte, aead.NonceSize())
+  if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+    return nil, fmt.Errorf("Could not generate nonce: %s", err.Error())
+  }
+
+  sealed := append(salt, nonce...)
+  sealed = aead.Seal(sealed, nonce, plain, nil)
+
+  return pem.EncodeToMemory(&pem.Block{
+    Type:    shareBundlePemType,
+    Headers: map[string]string{"Version": fmt.Sprintf("%d", shareBundleVersion)},
+    Bytes:   sealed,
+  }), nil
+}
+
+/**
+ * @brief      Decrypts a bundle created with SealShareBundle
+ */
+func OpenShareBundle(content []byte, passphrase string) (*ShareBundle, error) {
+  block, _ := pem.Decode(content)
+  if block == nil || block.Type != shareBundlePemType {
+    return nil, fmt.Errorf("This is not a terraform-wheels share bundle")
+  }
+
+  sealed := block.Bytes
+  if len(sealed) < 16 {
+    return nil, fmt.Errorf("The bundle is truncated")
+  }
+  aead, err := deriveShareKey(passphrase, sealed[:16])
+  if err != nil {
+    return nil, fmt.Errorf("Could not derive key: %s", err.Error())
+  }
+  sealed = sealed[16:]
+  if len(sealed) < aead.NonceSize() {
+    return nil, fmt.Errorf("The bundle is truncated")
+  }
+
+  plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+  if err != nil {
+    return nil, fmt.Errorf("Could not decrypt the bundle, is the passphrase correct?")
+  }
+
+  bundle := &ShareBundle{}
+  err = json.Unmarshal(plain, bundle)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse the bundle: %s", err.Error())
+  }
+
+  return bundle, nil
+}
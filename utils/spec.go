@@ -0,0 +1,123 @@
+package utils
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+
+  "gopkg.in/yaml.v3"
+)
+
+// The version of the spec format written by wheels-export
+var ClusterSpecVersion int = 1
+
+/**
+ * The DC/OS release installed on the cluster
+ */
+type ClusterSpecDcos struct {
+  Version    string `yaml:"version,omitempty"`
+  Variant    string `yaml:"variant,omitempty"`
+  InstanceOS string `yaml:"instance_os,omitempty"`
+}
+
+/**
+ * An additional pool of identical agents
+ */
+type ClusterSpecPool struct {
+  Name   string `yaml:"name"`
+  Count  int    `yaml:"count"`
+  Type   string `yaml:"type,omitempty"`
+  Public bool   `yaml:"public,omitempty"`
+}
+
+/**
+ * A package from universe, installed on the cluster by `up`
+ */
+type ClusterSpecService struct {
+  Name    string `yaml:"name,omitempty"`
+  Package string `yaml:"package"`
+  Version string `yaml:"version,omitempty"`
+  Config  string `yaml:"config,omitempty"`
+  AppID   string `yaml:"app_id,omitempty"`
+}
+
+/**
+ * A portable description of a cluster, that does not depend on the project
+ * it was created in. The options are the add-*-cluster flags that are not
+ * covered by the other fields, and what is missing keeps its default.
+ */
+type ClusterSpec struct {
+  Version       int                    `yaml:"version"`
+  Cloud         string                 `yaml:"cloud"`
+  Name          string                 `yaml:"name,omitempty"`
+  Description   string                 `yaml:"description,omitempty"`
+  Masters       *int                   `yaml:"masters,omitempty"`
+  PrivateAgents *int                   `yaml:"private_agents,omitempty"`
+  PublicAgents  *int                   `yaml:"public_agents,omitempty"`
+  Dcos          ClusterSpecDcos        `yaml:"dcos,omitempty"`
+  Pools         []ClusterSpecPool      `yaml:"pools,omitempty"`
+  Services      []ClusterSpecService   `yaml:"services,omitempty"`
+  Tags          map[string]string      `yaml:"tags,omitempty"`
+  Options       map[string]interface{} `yaml:"options,omitempty"`
+}
+
+/**
+ * Loads and checks the cluster spec from the given file
+ */
+func LoadClusterSpec(path string) (*ClusterSpec, error) {
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, fmt.Errorf("Could not read the cluster spec: %s", err.Error())
+  }
+
+  spec := &ClusterSpec{}
+  if err := yaml.Unmarshal(content, spec); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", path, err.Error())
+  }
+  if spec.Version > ClusterSpecVersion {
+    return nil, fmt.Errorf("The cluster spec %s is version %d, but only version %d is supported (try wheels-upgrade)", path, spec.Version, ClusterSpecVersion)
+  }
+  if spec.Cloud == "" {
+    return nil, fmt.Errorf("The cluster spec %s does not specify the cloud", path)
+  }
+  for _, pool := range spec.Pools {
+    if pool.Name == "" {
+      return nil, fmt.Errorf("There is an agent pool without a name in %s", path)
+    }
+  }
+  for i, service := range spec.Services {
+    if service.Package == "" {
+      return nil, fmt.Errorf("The service #%d in %s does not specify the package", i+1, path)
+    }
+    // The configurations are relative to the spec
+    if service.Config != "" && !filepath.IsAbs(service.Config) {
+      spec.Services[i].Config = filepath.Join(filepath.Dir(path), service.Config)
+    }
+  }
+  return spec, nil
+}
+
+/**
+ * Writes the cluster spec to the given file, or to the standard output
+ * with "-"
+ */
+func (s *ClusterSpec) Write(path string) error {
+  var buf bytes.Buffer
+  encoder := yaml.NewEncoder(&buf)
+  encoder.SetIndent(2)
+  if err := encoder.Encode(s); err != nil {
+    return fmt.Errorf("Could not encode the cluster spec: %s", err.Error())
+  }
+  content := buf.Bytes()
+
+  if path == "-" {
+    _, err := os.Stdout.Write(content)
+    return err
+  }
+  if err := ioutil.WriteFile(path, content, 0644); err != nil {
+    return fmt.Errorf("Could not write the cluster spec: %s", err.Error())
+  }
+  return nil
+}
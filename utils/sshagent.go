@@ -1,17 +1,54 @@
 package utils
 
 import (
+  "bytes"
+  "encoding/json"
   "fmt"
+  "io/ioutil"
   "os"
   "os/exec"
   "regexp"
+  "runtime"
   "strconv"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
 )
 
+// The named pipe of the Windows OpenSSH agent service
+var windowsOpenSSHAgentPipe string = `\\.\pipe\openssh-ssh-agent`
+
+// `agent` to use the keys of the agent of the session, or a private key file
+var sshKeyFlag string = ""
+
+/**
+ * Chooses the SSH key of the cluster, with `--ssh-key=<path|agent>`
+ */
+func SetSSHKey(key string) {
+  sshKeyFlag = key
+}
+
+/**
+ * Returns the SSH key given with `--ssh-key` or the TERRAFORM_WHEELS_SSH_KEY
+ * environment variable: either `agent`, a private key file, or empty to
+ * use the keys next to the public keys of the project
+ */
+func GetSSHKey() string {
+  if sshKeyFlag == "" {
+    return os.Getenv("TERRAFORM_WHEELS_SSH_KEY")
+  }
+  return sshKeyFlag
+}
+
 type SSHAgentWrapper struct {
   Socket string
   Pid    int
 
+  // True if we are using pageant, in which case the keys must be loaded
+  // by the user
+  Pageant bool
+
   sshAgentBinary string
   sshAddBinary   string
 }
@@ -19,6 +56,9 @@ type SSHAgentWrapper struct {
 func CreateSSHAgentWrapper() (*SSHAgentWrapper, error) {
   pathAgent, err := exec.LookPath(ExecutableName("ssh-agent"))
   if err != nil {
+    if runtime.GOOS == "windows" && isPageantRunning() {
+      return &SSHAgentWrapper{"", 0, true, "", ""}, nil
+    }
     return nil, fmt.Errorf("Could not find ssh-agent in your system")
   }
 
@@ -27,10 +67,26 @@ func CreateSSHAgentWrapper() (*SSHAgentWrapper, error) {
     return nil, fmt.Errorf("Could not find ssh-add in your system")
   }
 
-  return &SSHAgentWrapper{"", 0, pathAgent, pathAdd}, nil
+  return &SSHAgentWrapper{"", 0, false, pathAgent, pathAdd}, nil
+}
+
+/**
+ * Checks if PuTTY's pageant is running on windows
+ */
+func isPageantRunning() bool {
+  _, sout, _, err := ExecuteAndCollect([]string{}, "tasklist", "/FI", "IMAGENAME eq pageant.exe", "/NH")
+  if err != nil {
+    return false
+  }
+  return strings.Contains(strings.ToLower(sout), "pageant.exe")
 }
 
 func (w *SSHAgentWrapper) AddKey(path string) error {
+  if w.Pageant {
+    PrintWarning("Using pageant, please make sure that %s is loaded", path)
+    return nil
+  }
+
   _, _, _, err := ExecuteAndCollect([]string{
     fmt.Sprintf("SSH_AUTH_SOCK=%s", w.Socket),
   }, w.sshAddBinary, path)
@@ -41,6 +97,22 @@ func (w *SSHAgentWrapper) AddKey(path string) error {
 }
 
 func (w *SSHAgentWrapper) Start(socketPath string) error {
+  // On windows, the OpenSSH agent is a system service that cannot be started
+  // on a custom socket. We are using the service (or pageant) instead, and
+  // we are not stopping it when we are done.
+  if runtime.GOOS == "windows" {
+    if w.Pageant {
+      PrintInfo("Using pageant as ssh-agent")
+      return nil
+    }
+    if _, err := os.Stat(windowsOpenSSHAgentPipe); err != nil {
+      return fmt.Errorf("The OpenSSH Authentication Agent service is not running. Please start it with `Start-Service ssh-agent`")
+    }
+    w.Socket = windowsOpenSSHAgentPipe
+    PrintInfo("Using the OpenSSH Authentication Agent service")
+    return nil
+  }
+
   _, sout, serr, err := ExecuteAndCollect([]string{}, w.sshAgentBinary, "-a", socketPath)
   if err != nil {
     return fmt.Errorf("Could not start ssh-agent: %s: %s", err.Error(), serr)
@@ -70,6 +142,10 @@ func (w *SSHAgentWrapper) Start(socketPath string) error {
 }
 
 func (w *SSHAgentWrapper) Stop() error {
+  if w.Pid == 0 {
+    return nil
+  }
+
   proc, err := os.FindProcess(w.Pid)
   if err != nil {
     return fmt.Errorf("Could not find ssh-agent process: %s", err.Error())
@@ -78,3 +154,211 @@ func (w *SSHAgentWrapper) Stop() error {
   PrintInfo("Stopping ssh-agent")
   return proc.Kill()
 }
+
+/**
+ * Checks if the agent still accepts connections on its socket
+ */
+func (w *SSHAgentWrapper) IsRunning() bool {
+  if w.Pageant {
+    return true
+  }
+  // ssh-add exits with 1 when the agent has no keys, 2 when it's not there
+  code, _, _, err := ExecuteAndCollect([]string{
+    fmt.Sprintf("SSH_AUTH_SOCK=%s", w.Socket),
+  }, w.sshAddBinary, "-l")
+  return err == nil && (code == 0 || code == 1)
+}
+
+/**
+ * Checks if the agent has the private key of the given public key
+ */
+func (w *SSHAgentWrapper) HasKey(publicKeyPath string) (bool, error) {
+  content, err := ioutil.ReadFile(publicKeyPath)
+  if err != nil {
+    return false, fmt.Errorf("Could not read %s: %s", publicKeyPath, err.Error())
+  }
+  key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+  if err != nil {
+    return false, fmt.Errorf("Could not parse the public key %s: %s", publicKeyPath, err.Error())
+  }
+
+  _, sout, _, err := ExecuteAndCollect([]string{
+    fmt.Sprintf("SSH_AUTH_SOCK=%s", w.Socket),
+  }, w.sshAddBinary, "-L")
+  if err != nil {
+    return false, fmt.Errorf("Could not list the keys of ssh-agent: %s", err.Error())
+  }
+  for _, line := range strings.Split(sout, "\n") {
+    loaded, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+    if err == nil && bytes.Equal(loaded.Marshal(), key.Marshal()) {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+/**
+ * Returns the public keys whose private key is not in the agent. We cannot
+ * list the keys of pageant, so we assume they are there.
+ */
+func (w *SSHAgentWrapper) MissingKeys(publicKeyPaths []string) ([]string, error) {
+  var missing []string
+  if w.Pageant {
+    return missing, nil
+  }
+  for _, path := range publicKeyPaths {
+    loaded, err := w.HasKey(path)
+    if err != nil {
+      return nil, err
+    }
+    if !loaded {
+      missing = append(missing, path)
+    }
+  }
+  return missing, nil
+}
+
+/**
+ * Returns the agent that is already running in the session of the user
+ * (SSH_AUTH_SOCK), eg. gpg-agent with the keys of a hardware token, or nil
+ * if there is none
+ */
+func GetSessionSSHAgent() (*SSHAgentWrapper, error) {
+  socket := os.Getenv("SSH_AUTH_SOCK")
+  if socket == "" {
+    return nil, nil
+  }
+  agent, err := CreateSSHAgentWrapper()
+  if err != nil || agent.Pageant {
+    return nil, err
+  }
+  agent.Socket = socket
+  if !agent.IsRunning() {
+    return nil, nil
+  }
+  return agent, nil
+}
+
+// Where the agent of the project is recorded, in the .wheels directory
+var sshAgentStateName string = "ssh-agent.json"
+var sshAgentSocketName string = "ssh-agent.sock"
+
+/**
+ * The agent started for the project, that is reused by the next runs
+ */
+type SSHAgentState struct {
+  Socket    string    `json:"socket"`
+  Pid       int       `json:"pid"`
+  StartedAt time.Time `json:"started_at"`
+}
+
+/**
+ * Returns the agent recorded for the project, or nil
+ */
+func (s *ProjectSandbox) GetSSHAgentState() (*SSHAgentState, error) {
+  path, err := s.GetWheelsPath(sshAgentStateName)
+  if err != nil {
+    return nil, err
+  }
+  content, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return nil, nil
+  } else if err != nil {
+    return nil, fmt.Errorf("Could not read %s: %s", path, err.Error())
+  }
+
+  state := &SSHAgentState{}
+  if err := json.Unmarshal(content, state); err != nil {
+    return nil, fmt.Errorf("Could not parse %s: %s", path, err.Error())
+  }
+  return state, nil
+}
+
+func (s *ProjectSandbox) forgetSSHAgent(state *SSHAgentState) {
+  if path, err := s.GetWheelsPath(sshAgentStateName); err == nil {
+    os.Remove(path)
+  }
+  if state != nil && state.Socket != "" {
+    os.Remove(state.Socket)
+  }
+}
+
+/**
+ * Returns the agent of the project, started by a previous run if it's still
+ * running, or starts a new one. It's kept running after the run, until
+ * StopProjectSSHAgent (`wheels-agent stop`).
+ */
+func (s *ProjectSandbox) StartProjectSSHAgent() (*SSHAgentWrapper, error) {
+  agent, err := CreateSSHAgentWrapper()
+  if err != nil {
+    return nil, err
+  }
+
+  // The agents of windows are system-wide, not started by us
+  if runtime.GOOS == "windows" {
+    return agent, agent.Start("")
+  }
+
+  state, err := s.GetSSHAgentState()
+  if err != nil {
+    return nil, err
+  }
+  if state != nil {
+    agent.Socket = state.Socket
+    agent.Pid = state.Pid
+    if agent.IsRunning() {
+      return agent, nil
+    }
+    s.forgetSSHAgent(state)
+    agent.Socket = ""
+    agent.Pid = 0
+  }
+
+  socketPath, err := s.GetWheelsPath(sshAgentSocketName)
+  if err != nil {
+    return nil, err
+  }
+  if _, err := os.Stat(socketPath); err == nil {
+    if err := os.Remove(socketPath); err != nil {
+      return nil, fmt.Errorf("Could not delete old ssh-agent socket: %s", err.Error())
+    }
+  }
+  if err := agent.Start(socketPath); err != nil {
+    return nil, err
+  }
+
+  path, err := s.GetWheelsPath(sshAgentStateName)
+  if err != nil {
+    return nil, err
+  }
+  content, err := json.MarshalIndent(&SSHAgentState{agent.Socket, agent.Pid, time.Now().UTC()}, "", "  ")
+  if err != nil {
+    return nil, err
+  }
+  if err := ioutil.WriteFile(path, content, 0600); err != nil {
+    return nil, fmt.Errorf("Could not write %s: %s", path, err.Error())
+  }
+  return agent, nil
+}
+
+/**
+ * Stops the agent of the project, if it's running. Returns true if it was.
+ */
+func (s *ProjectSandbox) StopProjectSSHAgent() (bool, error) {
+  state, err := s.GetSSHAgentState()
+  if err != nil || state == nil {
+    return false, err
+  }
+  defer s.forgetSSHAgent(state)
+
+  agent, err := CreateSSHAgentWrapper()
+  if err != nil {
+    return false, err
+  }
+  agent.Socket = state.Socket
+  agent.Pid = state.Pid
+  if !agent.IsRunning() {
+    return false, nil
+  }
+  return true, agent.Stop()
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+// Where the state snapshots are kept, in a directory per workspace
+var stateBackupsDir string = "state-backups"
+
+// How many snapshots are kept per workspace, unless configured
+var defaultKeepSnapshots int = 20
+
+type StateSnapshot struct {
+  Name      string
+  Path      string
+  Reason    string
+  CreatedAt time.Time
+  Serial    int
+  Resources int
+
+  modTime time.Time
+}
+
+/**
+ * @brief      Returns the directory with the state snapshots of the current
+ *             workspace
+ */
+func (s *ProjectSandbox) getStateBackupsDir() (string, error) {
+  dir := filepath.Join(s.baseDir, ".wheels", stateBackupsDir, s.GetWorkspace())
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return "", fmt.Errorf("Unable to create the state backups directory: %s", err.Error())
+  }
+  return dir, nil
+}
+
+/**
+ * @brief      Copies the current terraform state to a new snapshot, returning
+ *             its name, or an empty string if there was nothing to save
+ *
+ * The state is pulled from the backend, so it works with the remote states
+ * too. No snapshot is taken if the state did not change since the last one.
+ */
+func (s *ProjectSandbox) SnapshotState(tf *TerraformWrapper, reason string) (string, error) {
+  defer StartSpan("state", "snapshot").End()
+
+  state, err := tf.PullState()
+  if err != nil {
+    return "", err
+  }
+  if state == nil {
+    return "", nil
+  }
+
+  snapshots, err := s.ListStateSnapshots()
+  if err != nil {
+    return "", err
+  }
+  if len(snapshots) > 0 {
+    if last, err := ioutil.ReadFile(snapshots[0].Path); err == nil && bytes.Equal(last, state) {
+      return "", nil
+    }
+  }
+
+  dir, err := s.getStateBackupsDir()
+  if err != nil {
+    return "", err
+  }
+  now := time.Now().UTC().Format("20060102-150405")
+  name := fmt.Sprintf("%s-%s.tfstate", now, reason)
+  for i := 2; ; i++ {
+    if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+      break
+    }
+    name = fmt.Sprintf("%s-%s-%d.tfstate", now, reason, i)
+  }
+
+  // The state contains the secrets of the cluster
+  if err := ioutil.WriteFile(filepath.Join(dir, name), state, 0600); err != nil {
+    return "", fmt.Errorf("Could not save the state snapshot: %s", err.Error())
+  }
+
+  s.pruneStateSnapshots(append([]StateSnapshot{{Name: name, Path: filepath.Join(dir, name)}}, snapshots...))
+  return name, nil
+}
+
+/**
+ * Removes the oldest snapshots, beyond the configured number
+ */
+func (s *ProjectSandbox) pruneStateSnapshots(snapshots []StateSnapshot) {
+  keep := defaultKeepSnapshots
+  if cfg := s.GetConfig().State.KeepSnapshots; cfg != nil {
+    keep = *cfg
+  }
+  if keep <= 0 || len(snapshots) <= keep {
+    return
+  }
+  for _, snapshot := range snapshots[keep:] {
+    if err := os.Remove(snapshot.Path); err != nil {
+      PrintWarning("Could not remove the old state snapshot %s: %s", snapshot.Name, err.Error())
+    }
+  }
+}
+
+/**
+ * Takes a snapshot of the state before changing it with the given command,
+ * only warning if that's not possible
+ */
+func (s *ProjectSandbox) BackupStateBefore(tf *TerraformWrapper, cmd string) {
+  name, err := s.SnapshotState(tf, cmd)
+  if err != nil {
+    PrintWarning("Could not take a snapshot of the state: %s", err.Error())
+  } else if name != "" {
+    PrintInfo("Saved the state in the %s snapshot", Bold(name))
+  }
+}
+
+/**
+ * @brief      Returns the state snapshots of the current workspace, the most
+ *             recent first
+ */
+func (s *ProjectSandbox) ListStateSnapshots() ([]StateSnapshot, error) {
+  dir, err := s.getStateBackupsDir()
+  if err != nil {
+    return nil, err
+  }
+  files, err := ioutil.ReadDir(dir)
+  if err != nil {
+    return nil, fmt.Errorf("Could not list the state snapshots: %s", err.Error())
+  }
+
+  var snapshots []StateSnapshot
+  for _, f := range files {
+    if f.IsDir() || !strings.HasSuffix(f.Name(), ".tfstate") {
+      continue
+    }
+    snapshot := StateSnapshot{
+      Name:      f.Name(),
+      Path:      filepath.Join(dir, f.Name()),
+      CreatedAt: f.ModTime(),
+      modTime:   f.ModTime(),
+    }
+    parts := strings.SplitN(strings.TrimSuffix(f.Name(), ".tfstate"), "-", 3)
+    if len(parts) == 3 {
+      if t, err := time.Parse("20060102-150405", parts[0]+"-"+parts[1]); err == nil {
+        snapshot.CreatedAt = t
+      }
+      snapshot.Reason = parts[2]
+    }
+    snapshot.Serial, snapshot.Resources = readStateSummary(snapshot.Path)
+    snapshots = append(snapshots, snapshot)
+  }
+
+  // The names only have a precision of a second
+  sort.Slice(snapshots, func(i, j int) bool {
+    if !snapshots[i].CreatedAt.Equal(snapshots[j].CreatedAt) {
+      return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+    }
+    return snapshots[i].modTime.After(snapshots[j].modTime)
+  })
+  return snapshots, nil
+}
+
+/**
+ * Returns the serial and the number of resources of a terraform state file
+ */
+func readStateSummary(path string) (int, int) {
+  var state struct {
+    Serial  int `json:"serial"`
+    Modules []struct {
+      Resources map[string]interface{} `json:"resources"`
+    } `json:"modules"`
+  }
+  content, err := ioutil.ReadFile(path)
+  if err != nil || json.Unmarshal(content, &state) != nil {
+    return 0, 0
+  }
+
+  resources := 0
+  for _, module := range state.Modules {
+    resources += len(module.Resources)
+  }
+  return state.Serial, resources
+}
+
+/**
+ * @brief      Finds a snapshot of the current workspace by its name (with or
+ *             without the extension), or "latest" for the most recent one
+ */
+func (s *ProjectSandbox) FindStateSnapshot(name string) (*StateSnapshot, error) {
+  snapshots, err := s.ListStateSnapshots()
+  if err != nil {
+    return nil, err
+  }
+  if len(snapshots) == 0 {
+    return nil, fmt.Errorf("There are no state snapshots in the '%s' workspace", s.GetWorkspace())
+  }
+  if name == "latest" {
+    return &snapshots[0], nil
+  }
+
+  name = strings.TrimSuffix(filepath.Base(name), ".tfstate")
+  for _, snapshot := range snapshots {
+    if strings.TrimSuffix(snapshot.Name, ".tfstate") == name {
+      return &snapshot, nil
+    }
+  }
+  return nil, fmt.Errorf("Could not find the state snapshot '%s'", name)
+}
+
+/**
+ * @brief      Replaces the terraform state with the given snapshot, after
+ *             taking a snapshot of the current one
+ */
+func (s *ProjectSandbox) RestoreStateSnapshot(tf *TerraformWrapper, snapshot *StateSnapshot) error {
+  defer StartSpan("state", "restore").End()
+
+  if _, err := s.SnapshotState(tf, "before-restore"); err != nil {
+    return fmt.Errorf("Could not save the current state before restoring: %s", err.Error())
+  }
+  return tf.PushState(snapshot.Path)
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// The most suggestions to give for a typo
+var maxSuggestions int = 3
+
+/**
+ * Returns the candidate that is the closest to the value (a typo of it), or
+ * an empty string if none is close enough
+ */
+func SuggestValue(value string, candidates []string) string {
+  suggestions := SuggestValues(value, candidates)
+  if len(suggestions) == 0 {
+    return ""
+  }
+  return suggestions[0]
+}
+
+/**
+ * Returns the candidates that are close to the value (a typo of it, or the
+ * beginning of it), the closest first
+ */
+func SuggestValues(value string, candidates []string) []string {
+  // At most one typo every three characters
+  maxDistance := len(value) / 3
+  if maxDistance < 1 {
+    maxDistance = 1
+  }
+
+  lowerValue := strings.ToLower(value)
+  distances := make(map[string]int)
+  var suggestions []string
+  for _, candidate := range candidates {
+    if _, ok := distances[candidate]; ok || candidate == "" {
+      continue
+    }
+    lowerCandidate := strings.ToLower(candidate)
+    distance := editDistance(lowerValue, lowerCandidate)
+    // The beginning of a name is the best guess (eg. `wheels-inv`)
+    if len(value) >= 4 && strings.HasPrefix(lowerCandidate, lowerValue) {
+      distance = 0
+    } else if distance > maxDistance {
+      continue
+    }
+    distances[candidate] = distance
+    suggestions = append(suggestions, candidate)
+  }
+
+  sort.SliceStable(suggestions, func(i, j int) bool {
+    if distances[suggestions[i]] != distances[suggestions[j]] {
+      return distances[suggestions[i]] < distances[suggestions[j]]
+    }
+    return suggestions[i] < suggestions[j]
+  })
+  if len(suggestions) > maxSuggestions {
+    suggestions = suggestions[:maxSuggestions]
+  }
+  return suggestions
+}
+
+/**
+ * Returns "did you mean 'a' or 'b'?", or an empty string without suggestions
+ */
+func FormatSuggestions(suggestions []string) string {
+  if len(suggestions) == 0 {
+    return ""
+  }
+  var quoted []string
+  for _, suggestion := range suggestions {
+    quoted = append(quoted, fmt.Sprintf("'%s'", suggestion))
+  }
+  return fmt.Sprintf("did you mean %s?", joinAlternatives(quoted))
+}
+
+/**
+ * The number of edits (insertions, deletions, substitutions and swaps of
+ * two characters) to turn one string into the other
+ */
+func editDistance(a string, b string) int {
+  ra, rb := []rune(a), []rune(b)
+  d := make([][]int, len(ra)+1)
+  for i := range d {
+    d[i] = make([]int, len(rb)+1)
+    d[i][0] = i
+  }
+  for j := range d[0] {
+    d[0][j] = j
+  }
+  for i := 1; i <= len(ra); i++ {
+    for j := 1; j <= len(rb); j++ {
+      cost := 1
+      if ra[i-1] == rb[j-1] {
+        cost = 0
+      }
+      d[i][j] = minInt(minInt(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+      if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+        d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+      }
+    }
+  }
+  return d[len(ra)][len(rb)]
+}
+
+func minInt(a int, b int) int {
+  if a < b {
+    return a
+  }
+  return b
+}
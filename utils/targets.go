@@ -0,0 +1,199 @@
+package utils
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/gobwas/glob"
+)
+
+// The parts of the cluster that are targeted with --target-masters,
+// --target-agents or --only-services
+var targetGroups []string
+
+/**
+ * Where to find a part of the cluster: the modules with the given source,
+ * and the address within them (or the whole module)
+ */
+type targetModule struct {
+  source  string
+  address string
+}
+
+var targetGroupModules map[string][]targetModule = map[string][]targetModule{
+  "masters": {
+    {"*dcos-terraform/dcos/aws", "module.dcos-infrastructure.module.dcos-master-instances"},
+    {"*dcos-terraform/masters/aws", ""},
+  },
+  "agents": {
+    {"*dcos-terraform/dcos/aws", "module.dcos-infrastructure.module.dcos-privateagent-instances"},
+    {"*dcos-terraform/dcos/aws", "module.dcos-infrastructure.module.dcos-publicagent-instances"},
+    {"*dcos-terraform/private-agents/aws", ""},
+    {"*dcos-terraform/public-agents/aws", ""},
+    {"*dcos-terraform/windows-instance/aws", ""},
+    // The agents of add-aws-remote-agents, in another region
+    {"*dcos-terraform/infrastructure/aws", ""},
+  },
+  "services": {
+    {"*data-services-terraform/modules/ds-deploy", ""},
+  },
+}
+
+// The resources of each part, by type
+var targetGroupResources map[string][]string = map[string][]string{
+  "agents":   {"aws_spot_instance_request"},
+  "services": {"dcos_*"},
+}
+
+/**
+ * Adds a part of the cluster to target, eg. "masters"
+ */
+func AddTargetGroup(group string) {
+  targetGroups = append(targetGroups, group)
+}
+
+/**
+ * Returns the terraform addresses of the given part of the cluster in the
+ * project, eg. `module.dcos.module.dcos-infrastructure.module.dcos-master-instances`
+ */
+func (s *ProjectSandbox) GetTargetGroupAddresses(group string) ([]string, error) {
+  var targets []string
+  for _, target := range targetGroupModules[group] {
+    for _, mod := range s.GetTerraformResourcesMatching("module", "source", target.source) {
+      address := "module." + mod["_name"].(string)
+      if target.address != "" {
+        address += "." + target.address
+      }
+      targets = append(targets, address)
+    }
+  }
+
+  hashes, err := s.getBlockHashes()
+  if err != nil {
+    return nil, err
+  }
+  for _, resType := range targetGroupResources[group] {
+    g := glob.MustCompile(resType + ".*")
+    for address := range hashes {
+      if g.Match(address) {
+        targets = append(targets, address)
+      }
+    }
+  }
+
+  sort.Strings(targets)
+  return targets, nil
+}
+
+/**
+ * @brief      Adds the -target options of the parts of the cluster given with
+ *             the global flags to the arguments of a `plan` or `apply`
+ */
+func (s *ProjectSandbox) ApplyTargetGroups(args []string) ([]string, error) {
+  if len(targetGroups) == 0 {
+    return args, nil
+  }
+  parsed := ParseTerraformArgs(args)
+  cmd := parsed.Command
+  if cmd == "init" {
+    return args, nil
+  }
+  if cmd != "plan" && cmd != "apply" {
+    return nil, fmt.Errorf("--target-masters, --target-agents and --only-services can only be used with plan or apply")
+  }
+
+  var extra []string
+  for _, group := range targetGroups {
+    targets, err := s.GetTargetGroupAddresses(group)
+    if err != nil {
+      return nil, err
+    }
+    if len(targets) == 0 {
+      return nil, fmt.Errorf("Could not find the %s in the project", group)
+    }
+    for _, target := range targets {
+      PrintInfo("Targeting %s", target)
+      extra = append(extra, "-target="+target)
+    }
+  }
+  PrintWarning("Only the %s are changed, the rest of the cluster might not be up to date", strings.Join(targetGroups, " and "))
+
+  // The options go right after the command, before a plan file
+  var ret []string
+  ret = append(ret, args[:parsed.CommandIndex+1]...)
+  ret = append(ret, extra...)
+  return append(ret, args[parsed.CommandIndex+1:]...), nil
+}
+
+// The instance of a node, and its index when the module creates several
+var nodeResourcePattern *regexp.Regexp = regexp.MustCompile(`\.(?:aws_instance|aws_spot_instance_request)\.[A-Za-z0-9_-]+(?:\[([0-9]+)\]|\.([0-9]+))?$`)
+
+/**
+ * @brief      Returns the terraform addresses of the instances of the nodes of
+ *             the given kind (master, agent or public-agent) in the state
+ */
+func (s *ProjectSandbox) GetNodeAddresses(tf *TerraformWrapper, kind string) ([]string, error) {
+  group := "agents"
+  switch kind {
+  case "master":
+    group = "masters"
+  case "agent", "public-agent":
+  default:
+    return nil, fmt.Errorf("Unknown kind of node '%s', expecting master, agent or public-agent", kind)
+  }
+
+  modules, err := s.GetTargetGroupAddresses(group)
+  if err != nil {
+    return nil, err
+  }
+  resources, err := tf.ListStateResources()
+  if err != nil {
+    return nil, err
+  }
+
+  var nodes []string
+  for _, address := range resources {
+    if !nodeResourcePattern.MatchString(address) {
+      continue
+    }
+    isPublic := strings.Contains(address, "public")
+    if (kind == "public-agent") != isPublic {
+      continue
+    }
+    for _, module := range modules {
+      if address == module || strings.HasPrefix(address, module+".") {
+        nodes = append(nodes, address)
+        break
+      }
+    }
+  }
+  return nodes, nil
+}
+
+/**
+ * Returns the terraform address of the instance of the given node, by its
+ * index (eg. the second master is `master 1`)
+ */
+func (s *ProjectSandbox) FindNodeAddress(tf *TerraformWrapper, kind string, index int) (string, []string, error) {
+  nodes, err := s.GetNodeAddresses(tf, kind)
+  if err != nil {
+    return "", nil, err
+  }
+
+  var found []string
+  for _, address := range nodes {
+    m := nodeResourcePattern.FindStringSubmatch(address)
+    if fmt.Sprintf("%d", index) == m[1]+m[2] || (index == 0 && m[1]+m[2] == "") {
+      found = append(found, address)
+    }
+  }
+  if len(found) == 0 {
+    return "", nodes, fmt.Errorf("Could not find the %s %d in the state, there are %d", kind, index, len(nodes))
+  }
+  if len(found) > 1 {
+    return "", nodes, fmt.Errorf("There are several %ss with the index %d (%s), taint the right one yourself", kind, index, strings.Join(found, ", "))
+  }
+  return found[0], nodes, nil
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+  "sync"
+)
+
+/**
+ * Runs functions concurrently, at most `limit` at a time, and collects the
+ * errors of all of them (not only the first one)
+ */
+type TaskGroup struct {
+  slots  chan struct{}
+  wg     sync.WaitGroup
+  mutex  sync.Mutex
+  errors []error
+}
+
+func CreateTaskGroup(limit int) *TaskGroup {
+  if limit < 1 {
+    limit = 1
+  }
+  return &TaskGroup{slots: make(chan struct{}, limit)}
+}
+
+/**
+ * Runs the function in the background as soon as there is a free slot. The
+ * `wait` function is called before taking the slot, so a task waiting for
+ * another one does not prevent it from running.
+ */
+func (g *TaskGroup) Go(wait func(), fn func() error) {
+  g.wg.Add(1)
+  go func() {
+    defer g.wg.Done()
+    if wait != nil {
+      wait()
+    }
+
+    g.slots <- struct{}{}
+    err := fn()
+    <-g.slots
+
+    if err != nil {
+      g.mutex.Lock()
+      g.errors = append(g.errors, err)
+      g.mutex.Unlock()
+    }
+  }()
+}
+
+/**
+ * Waits for all the functions to complete, and returns their errors
+ */
+func (g *TaskGroup) Wait() []error {
+  g.wg.Wait()
+  return g.errors
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+  "bytes"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "runtime"
+  "sync"
+  "time"
+)
+
+/**
+ * The user-wide telemetry settings. Nothing is recorded until the user opts
+ * in with `wheels-telemetry on`.
+ */
+type TelemetrySettings struct {
+  Enabled  bool   `json:"enabled"`
+  ID       string `json:"id,omitempty"`
+  Endpoint string `json:"endpoint,omitempty"`
+  File     string `json:"file,omitempty"`
+}
+
+/**
+ * What is recorded about a run. It never contains the arguments, the paths
+ * or anything about the clusters, only the names of the commands and plugins.
+ */
+type TelemetryEvent struct {
+  ID                string    `json:"id"`
+  Time              time.Time `json:"time"`
+  Version           string    `json:"version"`
+  Platform          string    `json:"platform"`
+  Command           string    `json:"command"`
+  DurationMs        int64     `json:"duration_ms"`
+  Failed            bool      `json:"failed"`
+  TerraformExitCode *int      `json:"terraform_exit_code,omitempty"`
+  Plugins           []string  `json:"plugins,omitempty"`
+}
+
+var telemetryStart time.Time = time.Now()
+var telemetryEvent TelemetryEvent
+var telemetryOnce sync.Once
+
+func getTelemetrySettingsPath() (string, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "telemetry.json"), nil
+}
+
+/**
+ * Returns the default file the events are appended to, when there is no
+ * endpoint
+ */
+func GetDefaultTelemetryFile() (string, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "telemetry.jsonl"), nil
+}
+
+/**
+ * Loads the telemetry settings, that are disabled by default
+ */
+func LoadTelemetrySettings() (*TelemetrySettings, error) {
+  path, err := getTelemetrySettingsPath()
+  if err != nil {
+    return nil, err
+  }
+
+  settings := &TelemetrySettings{}
+  content, err := ioutil.ReadFile(path)
+  if err != nil {
+    if os.IsNotExist(err) {
+      return settings, nil
+    }
+    return nil, fmt.Errorf("Could not read the telemetry settings: %s", err.Error())
+  }
+  if err := json.Unmarshal(content, settings); err != nil {
+    return nil, fmt.Errorf("Could not parse the telemetry settings %s: %s", path, err.Error())
+  }
+  return settings, nil
+}
+
+/**
+ * Saves the telemetry settings, giving this installation a random anonymous
+ * ID the first time
+ */
+func SaveTelemetrySettings(settings *TelemetrySettings) error {
+  path, err := getTelemetrySettingsPath()
+  if err != nil {
+    return err
+  }
+  if settings.ID == "" {
+    buf := make([]byte, 16)
+    if _, err := rand.Read(buf); err != nil {
+      return fmt.Errorf("Could not generate the telemetry ID: %s", err.Error())
+    }
+    settings.ID = hex.EncodeToString(buf)
+  }
+
+  content, err := json.MarshalIndent(settings, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(path, content, 0644); err != nil {
+    return fmt.Errorf("Could not write the telemetry settings: %s", err.Error())
+  }
+  return nil
+}
+
+/**
+ * Checks if the telemetry is turned off for this run with the environment,
+ * regardless of the settings (eg. in CI)
+ */
+func IsTelemetryDisabledByEnv() bool {
+  if v := os.Getenv("TERRAFORM_WHEELS_TELEMETRY"); v == "0" || v == "false" || v == "off" {
+    return true
+  }
+  return os.Getenv("DO_NOT_TRACK") == "1"
+}
+
+/**
+ * Sets the command of this run, as recorded by the telemetry
+ */
+func SetTelemetryCommand(name string) {
+  telemetryEvent.Command = name
+}
+
+/**
+ * Sets the plugins used by the project, as recorded by the telemetry
+ */
+func SetTelemetryPlugins(names []string) {
+  telemetryEvent.Plugins = names
+}
+
+/**
+ * Sets the exit code of terraform, as recorded by the telemetry
+ */
+func SetTelemetryExitCode(code int) {
+  telemetryEvent.TerraformExitCode = &code
+}
+
+/**
+ * Returns the event that is recorded for this run so far
+ */
+func GetTelemetryEvent(settings *TelemetrySettings, failed bool) TelemetryEvent {
+  event := telemetryEvent
+  event.ID = settings.ID
+  event.Time = telemetryStart.UTC()
+  event.Version = wheelsVersion
+  event.Platform = runtime.GOOS + "/" + runtime.GOARCH
+  event.DurationMs = time.Since(telemetryStart).Milliseconds()
+  event.Failed = failed
+  return event
+}
+
+/**
+ * Records this run, if the user opted in. Only the first call does something,
+ * and errors are ignored, since the telemetry must never get in the way.
+ */
+func SendTelemetry(failed bool) {
+  telemetryOnce.Do(func() {
+    if telemetryEvent.Command == "" || IsTelemetryDisabledByEnv() {
+      return
+    }
+    settings, err := LoadTelemetrySettings()
+    if err != nil || !settings.Enabled {
+      return
+    }
+
+    content, err := json.Marshal(GetTelemetryEvent(settings, failed))
+    if err != nil {
+      return
+    }
+
+    if settings.Endpoint != "" {
+      if IsOffline() {
+        return
+      }
+      client := getHttpClient(false)
+      client.Timeout = 3 * time.Second
+      resp, err := client.Post(settings.Endpoint, "application/json", bytes.NewReader(content))
+      if err == nil {
+        resp.Body.Close()
+      }
+      return
+    }
+
+    file := settings.File
+    if file == "" {
+      if file, err = GetDefaultTelemetryFile(); err != nil {
+        return
+      }
+    }
+    f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+      return
+    }
+    f.Write(append(content, '\n'))
+    f.Close()
+  })
+}
@@ -1,24 +1,93 @@
 package utils
 
 import (
+  "bytes"
+  "encoding/json"
   "fmt"
+  "io"
   "regexp"
+  "strings"
+  "sync"
+  "time"
 )
 
 type TerraformWrapper struct {
   terraformPath string
   env           []string
+  envMutex      sync.Mutex
+
+  lastArgs     []string
+  lastExitCode int
+  lastOutput   string
+  lastDuration time.Duration
+
+  failFast         bool
+  failFastPatterns []string
+  lastInterrupted  bool
+
+  refreshCredentials func(force bool) error
+  prepareApply       func(args []string) ([]string, error)
+}
+
+type TerraformOutput struct {
+  Sensitive bool        `json:"sensitive"`
+  Type      string      `json:"type"`
+  Value     interface{} `json:"value"`
 }
 
 func CreateTeraformWrapper(fName string) *TerraformWrapper {
-  return &TerraformWrapper{fName, nil}
+  return &TerraformWrapper{terraformPath: fName}
 }
 
 func (w *TerraformWrapper) SetEnv(key string, value string) {
+  if IsSecretVariable(key) {
+    RegisterSecret(value)
+  }
+
+  // The plugins can set variables concurrently
+  w.envMutex.Lock()
+  defer w.envMutex.Unlock()
+
+  // Credentials are given again when they are refreshed
+  for i, e := range w.env {
+    if strings.HasPrefix(e, key+"=") {
+      w.env[i] = fmt.Sprintf("%s=%s", key, value)
+      return
+    }
+  }
   w.env = append(w.env, fmt.Sprintf("%s=%s", key, value))
 }
 
+/**
+ * Returns a copy of the environment given to terraform
+ */
+func (w *TerraformWrapper) getEnv() []string {
+  w.envMutex.Lock()
+  defer w.envMutex.Unlock()
+  return append([]string{}, w.env...)
+}
+
+/**
+ * Sets the function that gives fresh cloud credentials to terraform. It's
+ * called before every run, and with force=true when the credentials expired
+ * during a run.
+ */
+func (w *TerraformWrapper) SetCredentialRefresher(refresh func(force bool) error) {
+  w.refreshCredentials = refresh
+}
+
+/**
+ * Sets the function that turns the `apply` runs of InvokeWithRetry into the
+ * apply of a plan that was checked. It's called before every attempt, so
+ * the retries are checked too.
+ */
+func (w *TerraformWrapper) SetApplyPreparer(prepare func(args []string) ([]string, error)) {
+  w.prepareApply = prepare
+}
+
 func (w *TerraformWrapper) GetVersion() (string, error) {
+  defer StartSpan("terraform", "terraform --version").End()
+
   _, sout, _, err := ExecuteAndCollect([]string{}, w.terraformPath, "--version")
   if err != nil {
     return "", err
@@ -33,7 +102,376 @@ func (w *TerraformWrapper) GetVersion() (string, error) {
   return match[1], nil
 }
 
+/**
+ * Returns the terraform sub-command of the last Invoke call (eg. "apply")
+ */
+func (w *TerraformWrapper) GetLastCommand() string {
+  return GetTerraformCommand(w.lastArgs)
+}
+
+// The options of terraform that take a value, that can also be given as the
+// next argument (eg. `-var k=v` instead of `-var=k=v`)
+var terraformValueOptions []string = []string{
+  "backend-config", "backup", "from-module", "lock-timeout", "module-depth", "out",
+  "parallelism", "plugin-dir", "state", "state-out", "target", "var", "var-file",
+}
+
+/**
+ * An option of terraform, with the arguments it was given with
+ */
+type TerraformOption struct {
+  Name  string
+  Value string
+  Args  []string
+}
+
+/**
+ * The arguments of terraform, told apart like terraform does
+ */
+type TerraformArgs struct {
+  Command string
+  // The index of the command in the arguments, or -1
+  CommandIndex int
+  Options      []TerraformOption
+  // The other arguments, eg. the plan file of `apply`
+  Positional []string
+}
+
+/**
+ * Parses the given terraform arguments. The options that take a value take
+ * the next argument when it's not given after an equal sign.
+ */
+func ParseTerraformArgs(args []string) TerraformArgs {
+  ret := TerraformArgs{CommandIndex: -1}
+  for i := 0; i < len(args); i++ {
+    arg := args[i]
+    if arg == "--" {
+      ret.Positional = append(ret.Positional, args[i+1:]...)
+      break
+    }
+    if !strings.HasPrefix(arg, "-") {
+      if ret.CommandIndex < 0 {
+        ret.Command = arg
+        ret.CommandIndex = i
+      } else {
+        ret.Positional = append(ret.Positional, arg)
+      }
+      continue
+    }
+
+    opt := TerraformOption{Name: strings.TrimLeft(arg, "-"), Args: []string{arg}}
+    if idx := strings.Index(opt.Name, "="); idx >= 0 {
+      opt.Name, opt.Value = opt.Name[:idx], opt.Name[idx+1:]
+    } else if containsString(terraformValueOptions, opt.Name) && i+1 < len(args) {
+      i++
+      opt.Value = args[i]
+      opt.Args = append(opt.Args, args[i])
+    }
+    ret.Options = append(ret.Options, opt)
+  }
+  return ret
+}
+
+/**
+ * Checks if the option is given, in any form
+ */
+func (a TerraformArgs) HasOption(name string) bool {
+  for _, opt := range a.Options {
+    if opt.Name == name {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Returns the saved plan that the arguments apply, or an empty string
+ */
+func (a TerraformArgs) GetSavedPlan() string {
+  if a.Command != "apply" || len(a.Positional) == 0 {
+    return ""
+  }
+  return a.Positional[len(a.Positional)-1]
+}
+
+/**
+ * Returns the terraform sub-command in the given arguments (eg. "apply")
+ */
+func GetTerraformCommand(args []string) string {
+  return ParseTerraformArgs(args).Command
+}
+
+/**
+ * Returns the exit code of the last Invoke call
+ */
+func (w *TerraformWrapper) GetLastExitCode() int {
+  return w.lastExitCode
+}
+
+/**
+ * Returns how long the last Invoke call took
+ */
+func (w *TerraformWrapper) GetLastDuration() time.Duration {
+  return w.lastDuration
+}
+
+/**
+ * Returns the combined stdout/stderr of the last Invoke call
+ */
+func (w *TerraformWrapper) GetLastOutput() string {
+  return w.lastOutput
+}
+
+/**
+ * Returns the outputs of the terraform project in the current directory
+ */
+func (w *TerraformWrapper) GetOutputs() (map[string]TerraformOutput, error) {
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "output", "-json")
+  if err != nil {
+    return nil, err
+  }
+  if code != 0 {
+    return nil, fmt.Errorf("Could not read terraform outputs: %s", strings.TrimSpace(serr))
+  }
+
+  outputs := make(map[string]TerraformOutput)
+  err = json.Unmarshal([]byte(sout), &outputs)
+  if err != nil {
+    return nil, fmt.Errorf("Could not parse terraform outputs: %s", err.Error())
+  }
+
+  return outputs, nil
+}
+
+/**
+ * Returns the address of the cluster and the IPs of its masters, from the
+ * `cluster-address` and `masters-ips` outputs
+ */
+func (w *TerraformWrapper) GetClusterOutputs() (string, []string, error) {
+  outputs, err := w.GetOutputs()
+  if err != nil {
+    return "", nil, err
+  }
+
+  address, ok := outputs["cluster-address"].Value.(string)
+  if !ok || address == "" {
+    return "", nil, fmt.Errorf("There is no `cluster-address` output")
+  }
+
+  var masters []string
+  if list, ok := outputs["masters-ips"].Value.([]interface{}); ok {
+    for _, ip := range list {
+      if ipStr, ok := ip.(string); ok {
+        masters = append(masters, ipStr)
+      }
+    }
+  }
+  return address, masters, nil
+}
+
+/**
+ * Returns the addresses of the resources in the terraform state of the
+ * project in the current directory
+ */
+func (w *TerraformWrapper) ListStateResources() ([]string, error) {
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "state", "list")
+  if err != nil {
+    return nil, err
+  }
+  if code != 0 {
+    if strings.Contains(serr, "No state file was found") {
+      return nil, nil
+    }
+    return nil, fmt.Errorf("Could not list the terraform state: %s", strings.TrimSpace(serr))
+  }
+
+  var resources []string
+  for _, line := range strings.Split(sout, "\n") {
+    if line = strings.TrimSpace(line); line != "" {
+      resources = append(resources, line)
+    }
+  }
+  return resources, nil
+}
+
+var providerLinePattern *regexp.Regexp = regexp.MustCompile(`provider\.([A-Za-z0-9_-]+)(?:\.[A-Za-z0-9_-]+)?(?:\s+(.*))?$`)
+
+/**
+ * Returns the providers required by the project (and its modules, that must
+ * already be installed), with their version constraints
+ */
+func (w *TerraformWrapper) ListProviders() (map[string][]string, error) {
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "providers")
+  if err != nil {
+    return nil, err
+  }
+  if code != 0 {
+    return nil, fmt.Errorf("Could not list the terraform providers: %s", strings.TrimSpace(serr))
+  }
+
+  providers := make(map[string][]string)
+  for _, line := range strings.Split(sout, "\n") {
+    m := providerLinePattern.FindStringSubmatch(line)
+    if m == nil {
+      continue
+    }
+    constraint := strings.TrimSpace(m[2])
+    if _, ok := providers[m[1]]; !ok {
+      providers[m[1]] = nil
+    }
+    if constraint != "" && !strings.HasPrefix(constraint, "(") {
+      providers[m[1]] = append(providers[m[1]], constraint)
+    }
+  }
+  return providers, nil
+}
+
+/**
+ * Returns the graph of the resources of the project, in the DOT language
+ */
+func (w *TerraformWrapper) GetGraph() (string, error) {
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "graph")
+  if err != nil {
+    return "", err
+  }
+  if code != 0 {
+    return "", fmt.Errorf("Could not get the terraform graph: %s", strings.TrimSpace(serr))
+  }
+  return sout, nil
+}
+
+/**
+ * Returns the current terraform state, wherever the backend keeps it, or
+ * nil if there is none yet
+ */
+func (w *TerraformWrapper) PullState() ([]byte, error) {
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "state", "pull")
+  if err != nil {
+    return nil, err
+  }
+  if code != 0 {
+    return nil, fmt.Errorf("Could not pull the terraform state: %s", strings.TrimSpace(serr))
+  }
+  if strings.TrimSpace(sout) == "" {
+    return nil, nil
+  }
+  return []byte(sout), nil
+}
+
+/**
+ * Replaces the terraform state with the one in the given file, even if it's
+ * older than the current one
+ */
+func (w *TerraformWrapper) PushState(file string) error {
+  code, _, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, "state", "push", "-force", file)
+  if err != nil {
+    return err
+  }
+  if code != 0 {
+    return fmt.Errorf("Could not push the terraform state: %s", strings.TrimSpace(serr))
+  }
+  return nil
+}
+
+/**
+ * Interrupts the next applies and destroys on the first fatal error (with
+ * the given patterns on top of the built-in ones), and summarizes the errors
+ */
+func (w *TerraformWrapper) EnableFailFast(extraPatterns []string) {
+  w.failFast = true
+  w.failFastPatterns = extraPatterns
+}
+
+/**
+ * Returns true if the applies and destroys stop on the first fatal error
+ */
+func (w *TerraformWrapper) IsFailFastEnabled() bool {
+  return w.failFast
+}
+
+/**
+ * Returns true if the last Invoke call was interrupted by a fatal error
+ */
+func (w *TerraformWrapper) WasLastInterrupted() bool {
+  return w.lastInterrupted
+}
+
 func (w *TerraformWrapper) Invoke(args []string) error {
-  _, err := ExecuteAndPassthrough(w.env, w.terraformPath, args...)
-  return err
+  defer StartSpan("terraform", "terraform "+GetTerraformCommand(args)).SetArg("args", strings.Join(args, " ")).End()
+
+  if w.refreshCredentials != nil {
+    if err := w.refreshCredentials(false); err != nil {
+      return err
+    }
+  }
+
+  var output bytes.Buffer
+  var capture io.Writer = &output
+  var watcher *fatalErrorWatcher
+  cmd := GetTerraformCommand(args)
+  if w.failFast && (cmd == "apply" || cmd == "destroy") {
+    watcher = createFatalErrorWatcher(w.failFastPatterns)
+    capture = io.MultiWriter(&output, watcher)
+  }
+
+  w.lastArgs = args
+  var interrupt chan struct{}
+  if watcher != nil {
+    interrupt = watcher.interrupt
+  }
+  started := time.Now()
+  code, err := ExecuteAndPassthroughWithInterrupt(w.getEnv(), capture, interrupt, w.terraformPath, addNoColorArg(args)...)
+  w.lastDuration = time.Since(started)
+  w.lastExitCode = code
+  w.lastOutput = output.String()
+  w.lastInterrupted = watcher != nil && watcher.isInterrupted()
+  if err != nil {
+    return err
+  }
+  if code != 0 && IsInterrupted() {
+    return WithExitCode(ExitInterrupted, fmt.Errorf("terraform was interrupted"))
+  }
+  if code == 2 && cmd == "plan" && containsString(args, "-detailed-exitcode") {
+    return WithExitCode(ExitDriftDetected, fmt.Errorf("terraform found changes to apply"))
+  }
+  if code != 0 {
+    if watcher != nil {
+      PrintErrorSummary(w.lastOutput)
+    }
+    return WithExitCode(ExitTerraformFailed, fmt.Errorf("terraform exited with code %d", code))
+  }
+  return nil
+}
+
+/**
+ * Runs terraform without showing its output, and returns it (eg. to read a
+ * plan). It's not remembered as the last run.
+ */
+func (w *TerraformWrapper) InvokeAndCollect(args []string) (string, error) {
+  defer StartSpan("terraform", "terraform "+GetTerraformCommand(args)).SetArg("args", strings.Join(args, " ")).End()
+
+  if w.refreshCredentials != nil {
+    if err := w.refreshCredentials(false); err != nil {
+      return "", err
+    }
+  }
+  code, sout, serr, err := ExecuteAndCollect(w.getEnv(), w.terraformPath, args...)
+  if err != nil {
+    return "", err
+  }
+  if code != 0 {
+    return "", WithExitCode(ExitTerraformFailed, fmt.Errorf("terraform %s failed: %s", GetTerraformCommand(args), strings.TrimSpace(serr)))
+  }
+  return sout, nil
+}
+
+/**
+ * Returns true if the last Invoke call failed, rather than finding changes
+ * with `plan -detailed-exitcode`
+ */
+func (w *TerraformWrapper) HasLastFailed() bool {
+  if w.lastExitCode == 2 && GetTerraformCommand(w.lastArgs) == "plan" && containsString(w.lastArgs, "-detailed-exitcode") {
+    return false
+  }
+  return w.lastExitCode != 0
 }
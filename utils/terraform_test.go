@@ -0,0 +1,141 @@
+package utils
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+)
+
+func TestParseTerraformArgs(t *testing.T) {
+  tests := []struct {
+    args       []string
+    command    string
+    options    []string
+    positional []string
+    savedPlan  string
+  }{
+    {[]string{"apply"}, "apply", nil, nil, ""},
+    {[]string{"apply", "-var=k=v"}, "apply", []string{"var"}, nil, ""},
+    {[]string{"apply", "-var", "k=v"}, "apply", []string{"var"}, nil, ""},
+    {[]string{"apply", "-var-file", "prod.tfvars", "-auto-approve"}, "apply", []string{"var-file", "auto-approve"}, nil, ""},
+    {[]string{"apply", "-target", "module.dcos", "-state", "other.tfstate"}, "apply", []string{"target", "state"}, nil, ""},
+    {[]string{"apply", "-backup", "-", "-lock-timeout", "5m", "-state-out", "out.tfstate"}, "apply", []string{"backup", "lock-timeout", "state-out"}, nil, ""},
+    {[]string{"apply", "--var", "k=v", "plan.out"}, "apply", []string{"var"}, []string{"plan.out"}, "plan.out"},
+    {[]string{"apply", "-auto-approve", "plan.out"}, "apply", []string{"auto-approve"}, []string{"plan.out"}, "plan.out"},
+    {[]string{"apply", "-refresh", "false"}, "apply", []string{"refresh"}, []string{"false"}, "false"},
+    {[]string{"plan", "-var", "k=v", "-out", "plan.out"}, "plan", []string{"var", "out"}, nil, ""},
+    {[]string{"state", "rm", "-backup", "b.tfstate", "module.dcos"}, "state", []string{"backup"}, []string{"rm", "module.dcos"}, ""},
+    {[]string{"apply", "--", "-var"}, "apply", nil, []string{"-var"}, "-var"},
+    {[]string{"-version"}, "", []string{"version"}, nil, ""},
+  }
+  for _, test := range tests {
+    parsed := ParseTerraformArgs(test.args)
+    var options []string
+    for _, opt := range parsed.Options {
+      options = append(options, opt.Name)
+    }
+    if parsed.Command != test.command || !reflect.DeepEqual(options, test.options) || !reflect.DeepEqual(parsed.Positional, test.positional) {
+      t.Errorf("%q: got the command %q, the options %q and the arguments %q", test.args, parsed.Command, options, parsed.Positional)
+    }
+    if got := parsed.GetSavedPlan(); got != test.savedPlan {
+      t.Errorf("%q: got the saved plan %q, want %q", test.args, got, test.savedPlan)
+    }
+    if hasSavedPlan(test.args) != (test.savedPlan != "") {
+      t.Errorf("%q: hasSavedPlan disagrees with the saved plan %q", test.args, test.savedPlan)
+    }
+  }
+
+  parsed := ParseTerraformArgs([]string{"apply", "-var", "k=v=w", "-var=a=b"})
+  if parsed.Options[0].Value != "k=v=w" || parsed.Options[1].Value != "a=b" {
+    t.Errorf("got the options %+v", parsed.Options)
+  }
+}
+
+func TestGetApplyPlanArgs(t *testing.T) {
+  tests := []struct {
+    args     []string
+    planArgs []string
+    planFile string
+  }{
+    {[]string{"apply"}, []string{"plan", "-no-color", "-input=false"}, ""},
+    {[]string{"apply", "-var=k=v", "-auto-approve"}, []string{"plan", "-var=k=v", "-no-color", "-input=false"}, ""},
+    {[]string{"apply", "-var", "k=v", "-auto-approve"}, []string{"plan", "-var", "k=v", "-no-color", "-input=false"}, ""},
+    {[]string{"apply", "-var-file", "f.tfvars", "-target", "x"}, []string{"plan", "-var-file", "f.tfvars", "-target", "x", "-no-color", "-input=false"}, ""},
+    {[]string{"apply", "-backup", "b.tfstate", "-state-out", "o.tfstate", "-lock-timeout", "5m"}, []string{"plan", "-lock-timeout", "5m", "-no-color", "-input=false"}, ""},
+    {[]string{"apply", "-auto-approve", "plan.out"}, nil, "plan.out"},
+  }
+  for _, test := range tests {
+    planArgs, planFile := getApplyPlanArgs(test.args)
+    if !reflect.DeepEqual(planArgs, test.planArgs) || planFile != test.planFile {
+      t.Errorf("%q: got %q and %q, want %q and %q", test.args, planArgs, planFile, test.planArgs, test.planFile)
+    }
+  }
+}
+
+func TestIsDestroyRun(t *testing.T) {
+  tests := []struct {
+    args    []string
+    destroy bool
+  }{
+    {[]string{"destroy", "-var", "k=v"}, true},
+    {[]string{"plan", "-destroy"}, true},
+    {[]string{"plan", "-destroy=true"}, true},
+    {[]string{"plan", "-destroy=false"}, false},
+    {[]string{"apply", "-var", "destroy"}, false},
+  }
+  for _, test := range tests {
+    if got := IsDestroyRun(test.args); got != test.destroy {
+      t.Errorf("%q: got %v", test.args, got)
+    }
+  }
+}
+
+func TestAddWorkspaceVarFile(t *testing.T) {
+  dir, err := ioutil.TempDir("", "wheels-workspace")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  if err := ioutil.WriteFile(filepath.Join(dir, "env-staging.tfvars"), []byte(""), 0644); err != nil {
+    t.Fatal(err)
+  }
+  os.Setenv("TF_WORKSPACE", "staging")
+  defer os.Unsetenv("TF_WORKSPACE")
+  sandbox := &ProjectSandbox{baseDir: dir}
+
+  tests := []struct {
+    args []string
+    want []string
+  }{
+    {[]string{"apply"}, []string{"apply", "-var-file=env-staging.tfvars"}},
+    {[]string{"apply", "-var=k=v"}, []string{"apply", "-var-file=env-staging.tfvars", "-var=k=v"}},
+    {[]string{"apply", "-var", "k=v"}, []string{"apply", "-var-file=env-staging.tfvars", "-var", "k=v"}},
+    {[]string{"plan", "-target", "module.dcos"}, []string{"plan", "-var-file=env-staging.tfvars", "-target", "module.dcos"}},
+    {[]string{"apply", "-var-file", "env-staging.tfvars"}, []string{"apply", "-var-file", "env-staging.tfvars"}},
+    {[]string{"apply", "plan.out"}, []string{"apply", "plan.out"}},
+    {[]string{"output", "-json"}, []string{"output", "-json"}},
+  }
+  for _, test := range tests {
+    if got := sandbox.AddWorkspaceVarFile(test.args); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%q: got %q, want %q", test.args, got, test.want)
+    }
+  }
+}
+
+func TestGetCheckedApplyArgs(t *testing.T) {
+  tests := []struct {
+    args []string
+    want []string
+  }{
+    {[]string{"apply"}, []string{"apply", "-input=false", "p.tfplan"}},
+    {[]string{"apply", "-auto-approve", "-var", "k=v", "-var-file=f.tfvars", "-target", "x"}, []string{"apply", "-input=false", "p.tfplan"}},
+    {[]string{"apply", "-lock-timeout", "5m", "-parallelism=20", "-refresh=false", "-state-out", "o.tfstate"}, []string{"apply", "-lock-timeout", "5m", "-parallelism=20", "-state-out", "o.tfstate", "-input=false", "p.tfplan"}},
+  }
+  for _, test := range tests {
+    if got := getCheckedApplyArgs(test.args, "p.tfplan"); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%q: got %q, want %q", test.args, got, test.want)
+    }
+  }
+}
@@ -0,0 +1,258 @@
+package utils
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "regexp"
+  "runtime"
+  "strings"
+)
+
+// The Terraform Cloud host, unless configured otherwise
+var DefaultTFEHostname string = "app.terraform.io"
+
+/**
+ * The Terraform Cloud / Enterprise settings, in the `tfe` section of
+ * .wheels.yaml
+ */
+type TFEConfig struct {
+  Hostname     string `yaml:"hostname"`
+  Organization string `yaml:"organization"`
+  TokenFile    string `yaml:"token_file"`
+}
+
+/**
+ * A variable of a Terraform Cloud workspace, either a terraform variable or
+ * an environment variable ("terraform" or "env" category)
+ */
+type TFEVariable struct {
+  Key       string
+  Value     string
+  Category  string
+  Sensitive bool
+}
+
+/**
+ * A minimal client of the Terraform Cloud / Enterprise API
+ */
+type TFEClient struct {
+  hostname string
+  token    string
+  client   *http.Client
+}
+
+/**
+ * Returns the file with the terraform CLI configuration, where the API
+ * tokens are kept in `credentials` blocks
+ */
+func GetTerraformCLIConfigFile() string {
+  if v, ok := os.LookupEnv("TF_CLI_CONFIG_FILE"); ok && v != "" {
+    return v
+  }
+  if runtime.GOOS == "windows" {
+    return filepath.Join(os.Getenv("APPDATA"), "terraform.rc")
+  }
+  home, err := os.UserHomeDir()
+  if err != nil {
+    return ""
+  }
+  return filepath.Join(home, ".terraformrc")
+}
+
+/**
+ * Returns the token of the given host in the terraform CLI configuration,
+ * or an empty string if there is none
+ */
+func getTerraformCLIToken(hostname string) string {
+  content, err := ioutil.ReadFile(GetTerraformCLIConfigFile())
+  if err != nil {
+    return ""
+  }
+  re := regexp.MustCompile(`(?s)credentials\s+"` + regexp.QuoteMeta(hostname) + `"\s*\{[^}]*?token\s*=\s*"([^"]+)"`)
+  if m := re.FindSubmatch(content); m != nil {
+    return string(m[1])
+  }
+  return ""
+}
+
+/**
+ * @brief      Returns the API token for the given host, and if terraform
+ *             itself can find it
+ *
+ * The token is looked up in the TFE_TOKEN environment variable, then in the
+ * `tfe.token_file` of .wheels.yaml, and then in the terraform CLI
+ * configuration (the only place terraform 0.11 reads it from).
+ */
+func (s *ProjectSandbox) GetTFEToken(hostname string) (string, bool, error) {
+  cliToken := getTerraformCLIToken(hostname)
+  if token := os.Getenv("TFE_TOKEN"); token != "" {
+    return token, cliToken != "", nil
+  }
+  if file := s.GetConfig().TFE.TokenFile; file != "" {
+    content, err := ioutil.ReadFile(file)
+    if err != nil {
+      return "", false, fmt.Errorf("Could not read the Terraform Cloud token: %s", err.Error())
+    }
+    return strings.TrimSpace(string(content)), cliToken != "", nil
+  }
+  if cliToken != "" {
+    return cliToken, true, nil
+  }
+  return "", false, fmt.Errorf("Could not find a token for %s, please set TFE_TOKEN or add it to %s", hostname, GetTerraformCLIConfigFile())
+}
+
+func CreateTFEClient(hostname string, token string) *TFEClient {
+  RegisterSecret(token)
+  return &TFEClient{hostname, token, getHttpClient(false)}
+}
+
+func (c *TFEClient) request(method string, path string, body interface{}, out interface{}) (int, error) {
+  var payload []byte
+  if body != nil {
+    var err error
+    if payload, err = json.Marshal(body); err != nil {
+      return 0, err
+    }
+  }
+  req, err := http.NewRequest(method, fmt.Sprintf("https://%s/api/v2/%s", c.hostname, path), bytes.NewReader(payload))
+  if err != nil {
+    return 0, err
+  }
+  req.Header.Set("Authorization", "Bearer "+c.token)
+  req.Header.Set("Content-Type", "application/vnd.api+json")
+
+  resp, err := c.client.Do(req)
+  if err != nil {
+    return 0, fmt.Errorf("Could not reach %s: %s", c.hostname, err.Error())
+  }
+  defer resp.Body.Close()
+  content, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return resp.StatusCode, err
+  }
+  if resp.StatusCode == http.StatusNotFound {
+    return resp.StatusCode, nil
+  }
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return resp.StatusCode, fmt.Errorf("%s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+  }
+  if out != nil && len(content) > 0 {
+    if err := json.Unmarshal(content, out); err != nil {
+      return resp.StatusCode, fmt.Errorf("Could not parse the response of %s: %s", path, err.Error())
+    }
+  }
+  return resp.StatusCode, nil
+}
+
+/**
+ * @brief      Returns the ID of the given workspace, creating it if it does
+ *             not exist yet
+ *
+ * With remote operations, the runs happen in Terraform Cloud, otherwise it
+ * only keeps the state.
+ */
+func (c *TFEClient) EnsureWorkspace(organization string, name string, remoteOperations bool) (string, bool, error) {
+  var ws struct {
+    Data struct {
+      ID string `json:"id"`
+    } `json:"data"`
+  }
+  code, err := c.request("GET", fmt.Sprintf("organizations/%s/workspaces/%s", organization, name), nil, &ws)
+  if err != nil {
+    return "", false, err
+  }
+  if code != http.StatusNotFound {
+    return ws.Data.ID, false, nil
+  }
+
+  _, err = c.request("POST", fmt.Sprintf("organizations/%s/workspaces", organization), map[string]interface{}{
+    "data": map[string]interface{}{
+      "type": "workspaces",
+      "attributes": map[string]interface{}{
+        "name":              name,
+        "terraform-version": upstreamTerraformVersion,
+        "operations":        remoteOperations,
+      },
+    },
+  }, &ws)
+  if err != nil {
+    return "", false, fmt.Errorf("Could not create the workspace %s: %s", name, err.Error())
+  }
+  return ws.Data.ID, true, nil
+}
+
+/**
+ * @brief      Creates or updates the given variables of a workspace
+ */
+func (c *TFEClient) SetVariables(workspaceID string, variables []TFEVariable) error {
+  var existing struct {
+    Data []struct {
+      ID         string `json:"id"`
+      Attributes struct {
+        Key      string `json:"key"`
+        Category string `json:"category"`
+      } `json:"attributes"`
+    } `json:"data"`
+  }
+  if _, err := c.request("GET", fmt.Sprintf("workspaces/%s/vars", workspaceID), nil, &existing); err != nil {
+    return err
+  }
+
+  for _, v := range variables {
+    body := map[string]interface{}{
+      "data": map[string]interface{}{
+        "type": "vars",
+        "attributes": map[string]interface{}{
+          "key":       v.Key,
+          "value":     v.Value,
+          "category":  v.Category,
+          "hcl":       false,
+          "sensitive": v.Sensitive,
+        },
+      },
+    }
+
+    method := "POST"
+    path := fmt.Sprintf("workspaces/%s/vars", workspaceID)
+    for _, e := range existing.Data {
+      if e.Attributes.Key == v.Key && e.Attributes.Category == v.Category {
+        method = "PATCH"
+        path = fmt.Sprintf("workspaces/%s/vars/%s", workspaceID, e.ID)
+        body["data"].(map[string]interface{})["id"] = e.ID
+      }
+    }
+    if _, err := c.request(method, path, body, nil); err != nil {
+      return fmt.Errorf("Could not set the variable %s: %s", v.Key, err.Error())
+    }
+  }
+  return nil
+}
+
+/**
+ * Reads the values of a tfvars file with plain `name = "value"` lines, like
+ * the ones generated for the sensitive variables
+ */
+func (s *ProjectSandbox) ReadTfvarsValues(file string) (map[string]string, error) {
+  content, err := s.ReadFile(file)
+  if err != nil {
+    return nil, err
+  }
+  values := make(map[string]string)
+  re := regexp.MustCompile(`^\s*([A-Za-z0-9_]+)\s*=\s*(".*")\s*$`)
+  for _, line := range strings.Split(string(content), "\n") {
+    m := re.FindStringSubmatch(line)
+    if m == nil {
+      continue
+    }
+    var value string
+    if err := json.Unmarshal([]byte(m[2]), &value); err == nil {
+      values[m[1]] = value
+    }
+  }
+  return values, nil
+}
@@ -1,11 +1,11 @@
 package utils
 
 import (
-  "encoding/json"
   "flag"
   "fmt"
   "io"
   "os"
+  "sort"
   "strings"
 )
 
@@ -21,7 +21,16 @@ type TerraformFileConfig struct {
 
   BodyPrefix string
 
-  printOutput io.Writer
+  // Parameters that are given through variables, with their values kept in
+  // a tfvars file
+  Variables []string
+
+  // Same as Variables, but their values are kept in a separate tfvars file,
+  // that is not meant to be shared
+  SensitiveVariables []string
+
+  variableValues map[string]string
+  printOutput    io.Writer
 }
 
 func wrapLongLines(text string, lineWidth int) []string {
@@ -113,88 +122,270 @@ func (c *TerraformFileConfig) SetOutput(output io.Writer) {
   c.printOutput = output
 }
 
-func (c *TerraformFileConfig) Generate() ([]byte, error) {
-  var lines []string = c.BodyLines
-  var errs []error
+/**
+ * Defines a list parameter, that can be given multiple times (and with
+ * comma-separated values)
+ */
+func (c *TerraformFileConfig) ListFlag(name string, usage string) {
+  c.Flags.Var(&StringSlice{}, name, usage)
+  c.ListFlags = append(c.ListFlags, name)
+}
+
+/**
+ * Defines a map parameter, that can be given multiple times as key=value
+ */
+func (c *TerraformFileConfig) MapFlag(name string, usage string) {
+  c.Flags.Var(make(StringMap), name, usage)
+  c.MapFlags = append(c.MapFlags, name)
+}
+
+// The kinds of values a parameter can have
+const (
+  tfScalarValue = iota
+  tfListValue
+  tfMapValue
+)
+
+/**
+ * The value of a parameter given with the flags
+ */
+type tfValue struct {
+  kind   int
+  scalar string
+  list   []string
+  keys   []string
+  items  map[string]string
+}
 
-  listValues := make(map[string][]string)
-  mapValues := make(map[string]map[string]string)
+/**
+ * Returns the value in HCL syntax
+ */
+func (v *tfValue) hcl() string {
+  switch v.kind {
+  case tfListValue:
+    lines := []string{"["}
+    for _, item := range v.list {
+      lines = append(lines, fmt.Sprintf("  %s,", FormatJSON(item)))
+    }
+    return strings.Join(append(lines, "]"), "\n")
+
+  case tfMapValue:
+    lines := []string{"{"}
+    for _, key := range v.keys {
+      lines = append(lines, fmt.Sprintf("  %s = %s", FormatJSON(key), FormatJSON(v.items[key])))
+    }
+    return strings.Join(append(lines, "}"), "\n")
+  }
+  return FormatJSON(v.scalar)
+}
+
+/**
+ * Returns the values of the parameters given with the flags, in the order
+ * of their names. A scalar keeps the last value it's given, a list all of
+ * them, and a map all of its keys (sorted), the last value of a key winning.
+ */
+func (c *TerraformFileConfig) flagValues() ([]string, map[string]*tfValue, error) {
+  var names []string
+  var errs []string
+  values := make(map[string]*tfValue)
 
   c.Flags.Visit(func(f *flag.Flag) {
     if c.IsIgnored(f.Name) {
       return
     }
 
-    // If that variable already exists in the body, remove it
-    for i, l := range lines {
-      if strings.Contains(l, f.Name) {
-        copy(lines[i:], lines[i+1:]) // Shift a[i+1:] left one index.
-        lines[len(lines)-1] = ""     // Erase last element (write zero value).
-        lines = lines[:len(lines)-1] // Truncate slice.
-        break
+    value := &tfValue{kind: tfScalarValue, scalar: f.Value.String()}
+    if c.IsList(f.Name) {
+      value.kind = tfListValue
+      value.list = []string{f.Value.String()}
+      if list, ok := f.Value.(*StringSlice); ok {
+        value.list = *list
       }
+    } else if c.IsMap(f.Name) {
+      // The flags that are not a StringMap are parsed here
+      items, ok := f.Value.(StringMap)
+      if !ok {
+        items = make(StringMap)
+        if err := items.Set(f.Value.String()); err != nil {
+          errs = append(errs, fmt.Sprintf("Could not parse -%s: %s", f.Name, err.Error()))
+          return
+        }
+      }
+      value.kind = tfMapValue
+      value.items = items
+      for key := range items {
+        value.keys = append(value.keys, key)
+      }
+      sort.Strings(value.keys)
     }
 
-    if c.IsList(f.Name) {
-      // If that's a list, add it on the list
-      var vals []string
-      if v, ok := listValues[f.Name]; ok {
-        vals = v
-      }
+    names = append(names, f.Name)
+    values[f.Name] = value
+  })
 
-      vals = append(vals, fmt.Sprintf("%s", f.Value.String()))
-      listValues[f.Name] = vals
+  if len(errs) > 0 {
+    return nil, nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+  }
+  return names, values, nil
+}
 
-    } else if c.IsMap(f.Name) {
-      // If that's a map, add it on the maps
-      value := f.Value.String()
-      kv := strings.Split(value, "=")
-      if len(kv) < 2 {
-        errs = append(errs, fmt.Errorf("Could not parse '%s': Expected key=value format", value))
-        return
-      }
+/**
+ * Composes the file from the pre, body and post lines, where the parameters
+ * given with the flags override the ones of the body, in the block the body
+ * lines are in. An overridden parameter is replaced where it's first
+ * defined, the others are added at the end of the block. The file is
+ * returned formatted, like it is written.
+ */
+func (c *TerraformFileConfig) Generate() ([]byte, error) {
+  names, values, err := c.flagValues()
+  if err != nil {
+    return nil, err
+  }
+
+  var allLines []string
+  allLines = append(allLines, c.PreLines...)
+  allLines = append(allLines, c.BodyLines...)
+  allLines = append(allLines, c.PostLines...)
+  file, err := ParseTerraformFile([]byte(strings.Join(allLines, "\n")))
+  if err != nil {
+    return nil, err
+  }
+  block := file.blockAtLine(len(c.PreLines))
+  if block == nil {
+    return nil, fmt.Errorf("Could not find the block of the generated parameters")
+  }
 
-      vals := make(map[string]string)
-      if v, ok := mapValues[f.Name]; ok {
-        vals = v
+  // The new lists and maps are set apart from the scalars
+  var appended []string
+  for _, kind := range []int{tfScalarValue, tfListValue, tfMapValue} {
+    for _, name := range names {
+      if values[name].kind != kind {
+        continue
+      }
+      if literal, ok := block.GetLiteral(name); ok && kind == tfScalarValue && literal == values[name].scalar {
+        // Kept as it is written
+      } else if _, ok := block.GetAttribute(name); ok {
+        err = block.SetAttribute(name, values[name].hcl())
+      } else {
+        if kind != tfScalarValue {
+          appended = append(appended, "")
+        }
+        appended = append(appended, fmt.Sprintf("%s = %s", name, values[name].hcl()))
       }
+      if err != nil {
+        return nil, err
+      }
+    }
+  }
+  if len(appended) > 0 {
+    if err := block.AppendLines(appended); err != nil {
+      return nil, err
+    }
+  }
 
-      vals[kv[0]] = kv[1]
-      mapValues[f.Name] = vals
+  if err := c.extractVariables(block); err != nil {
+    return nil, err
+  }
+  return file.Bytes()
+}
 
-    } else {
-      // Otherwise append it to the list
-      v, _ := json.Marshal(f.Value.String())
-      lines = append(lines, fmt.Sprintf("%s = %s", f.Name, string(v)))
+func (c *TerraformFileConfig) IsVariable(name string) bool {
+  for _, n := range append(c.Variables, c.SensitiveVariables...) {
+    if n == name {
+      return true
+    }
+  }
+  return false
+}
+
+// The tfvars file with the values of the sensitive variables. Terraform
+// loads it automatically.
+var SecretVariablesFile string = "secrets.auto.tfvars"
+
+/**
+ * Replaces the literal values of the variables with references to them,
+ * remembering the values. When a variable is given more than once, the last
+ * value wins but the first line is kept in place.
+ */
+func (c *TerraformFileConfig) extractVariables(block *TerraformBlock) error {
+  if c.variableValues == nil {
+    c.variableValues = make(map[string]string)
+  }
+  for _, name := range block.Attributes() {
+    literal, ok := block.GetLiteral(name)
+    if !c.IsVariable(name) || !ok || strings.Contains(literal, "${") {
+      continue
     }
-  })
 
-  // Then expand the lists
-  for varName, list := range listValues {
-    lines = append(lines, "")
-    lines = append(lines, fmt.Sprintf("%s = [", varName))
-    for _, item := range list {
-      v, _ := json.Marshal(item)
-      lines = append(lines, fmt.Sprintf("  %s,", v))
+    // Quoted like the values of the flags, so a regenerated file does not
+    // change when the value is given with a flag
+    c.variableValues[name] = FormatJSON(literal)
+    if err := block.SetAttribute(name, fmt.Sprintf(`"${var.%s}"`, name)); err != nil {
+      return err
     }
-    lines = append(lines, fmt.Sprintf("]"))
   }
+  return nil
+}
+
+/**
+ * Gives a parameter through a variable with the given value (in HCL syntax),
+ * returning the reference to it
+ */
+func (c *TerraformFileConfig) UseVariable(name string, value string) string {
+  if c.variableValues == nil {
+    c.variableValues = make(map[string]string)
+  }
+  c.variableValues[name] = value
+  return fmt.Sprintf(`"${var.%s}"`, name)
+}
+
+/**
+ * @brief      Returns the declarations of the variables that are used, a
+ *             tfvars file with their values, and a tfvars file with the
+ *             values of the sensitive ones (empty if there are none)
+ *
+ * Terraform 0.11 cannot mark variables as sensitive, so they are given
+ * without a default, to keep their values out of the declarations.
+ */
+func (c *TerraformFileConfig) GenerateVariables() ([]byte, []byte, []byte) {
+  var decls []string
+  var values []string
+  var secrets []string
 
-  // Then expand the maps
-  for varName, list := range mapValues {
-    lines = append(lines, "")
-    lines = append(lines, fmt.Sprintf("%s = {", varName))
-    for key, item := range list {
-      v, _ := json.Marshal(item)
-      lines = append(lines, fmt.Sprintf("  %s = %s", key, v))
+  for _, name := range append(c.Variables, c.SensitiveVariables...) {
+    value, ok := c.variableValues[name]
+    if !ok {
+      continue
     }
-    lines = append(lines, fmt.Sprintf("}"))
+
+    desc := ""
+    if f := c.Flags.Lookup(name); f != nil {
+      desc = f.Usage
+    }
+    if len(decls) > 0 {
+      decls = append(decls, "")
+    }
+    decls = append(decls,
+      fmt.Sprintf(`variable "%s" {`, name),
+      fmt.Sprintf(`  description = %s`, FormatJSON(desc)),
+    )
+    if c.isSensitive(name) {
+      secrets = append(secrets, fmt.Sprintf("%s = %s", name, value))
+    } else {
+      decls = append(decls, fmt.Sprintf(`  default     = %s`, value))
+      values = append(values, fmt.Sprintf("%s = %s", name, value))
+    }
+    decls = append(decls, `}`)
   }
 
-  // Compose all lines
-  allLines := append(c.PreLines, lines...)
-  allLines = append(allLines, c.PostLines...)
+  return []byte(strings.Join(decls, "\n")), []byte(strings.Join(values, "\n")), []byte(strings.Join(secrets, "\n"))
+}
 
-  content := []byte(strings.Join(allLines, "\n"))
-  return content, nil
+func (c *TerraformFileConfig) isSensitive(name string) bool {
+  for _, n := range c.SensitiveVariables {
+    if n == name {
+      return true
+    }
+  }
+  return false
 }
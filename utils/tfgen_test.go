@@ -0,0 +1,254 @@
+package utils
+
+import (
+  "flag"
+  "io/ioutil"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+/**
+ * Returns a config like the ones of the add-* commands: a block of the
+ * pre lines that the body and the flags fill in
+ */
+func createTestFileConfig() *TerraformFileConfig {
+  tfc := &TerraformFileConfig{
+    Flags: flag.NewFlagSet("test", flag.ContinueOnError),
+    PreLines: []string{
+      `provider "aws" {`,
+      `  region = "us-west-2"`,
+      `}`,
+      ``,
+      `module "dcos" {`,
+    },
+    BodyLines: []string{
+      `  # The size of the nodes`,
+      `  instance_type = "t2.medium"`,
+      `  num_masters   = 1`,
+      `  admin_ips     = ["10.0.0.1/32"]`,
+    },
+    PostLines: []string{
+      `}`,
+    },
+  }
+  tfc.Flags.String("instance_type", "", "The instance type")
+  tfc.Flags.String("num_masters", "", "The number of masters")
+  tfc.Flags.String("cluster_name", "", "The name of the cluster")
+  tfc.Flags.String("owner", "", "Not a parameter of the module")
+  tfc.ListFlag("admin_ips", "The admin CIDRs")
+  tfc.ListFlag("subnet_ids", "The subnets")
+  tfc.MapFlag("tags", "The tags")
+  tfc.IgnoreFlags = []string{"owner"}
+  return tfc
+}
+
+func TestGenerate(t *testing.T) {
+  tests := []struct {
+    name       string
+    args       []string
+    scalars    map[string]string
+    lists      map[string][]string
+    maps       map[string]map[string]string
+    attributes []string
+    err        string
+  }{
+    {
+      name:       "no flags",
+      scalars:    map[string]string{"instance_type": "t2.medium", "num_masters": "1"},
+      lists:      map[string][]string{"admin_ips": {"10.0.0.1/32"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "scalar overridden in place",
+      args:       []string{"-instance_type=m5.xlarge", "-num_masters=3"},
+      scalars:    map[string]string{"instance_type": "m5.xlarge", "num_masters": "3"},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "scalar given twice",
+      args:       []string{"-num_masters=3", "-num_masters=5"},
+      scalars:    map[string]string{"num_masters": "5"},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "new scalar appended",
+      args:       []string{"-cluster_name=demo"},
+      scalars:    map[string]string{"instance_type": "t2.medium", "cluster_name": "demo"},
+      attributes: []string{"instance_type", "num_masters", "admin_ips", "cluster_name"},
+    },
+    {
+      name:       "ignored flag",
+      args:       []string{"-owner=me"},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "list replaced in place",
+      args:       []string{"-admin_ips=10.0.0.2/32", "-admin_ips=10.0.0.3/32"},
+      lists:      map[string][]string{"admin_ips": {"10.0.0.2/32", "10.0.0.3/32"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "comma-separated list",
+      args:       []string{"-admin_ips=10.0.0.2/32,10.0.0.3/32", "-admin_ips=10.0.0.4/32"},
+      lists:      map[string][]string{"admin_ips": {"10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips"},
+    },
+    {
+      name:       "list appended",
+      args:       []string{"-subnet_ids=subnet-1", "-subnet_ids=subnet-2"},
+      lists:      map[string][]string{"admin_ips": {"10.0.0.1/32"}, "subnet_ids": {"subnet-1", "subnet-2"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips", "subnet_ids"},
+    },
+    {
+      name:       "map appended",
+      args:       []string{"-tags=team=infra", "-tags=env=dev"},
+      maps:       map[string]map[string]string{"tags": {"team": "infra", "env": "dev"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips", "tags"},
+    },
+    {
+      name:       "map value with an equal sign",
+      args:       []string{"-tags=query=a=b"},
+      maps:       map[string]map[string]string{"tags": {"query": "a=b"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips", "tags"},
+    },
+    {
+      name:       "duplicate map key",
+      args:       []string{"-tags=env=dev", "-tags=team=infra", "-tags=env=prod"},
+      maps:       map[string]map[string]string{"tags": {"env": "prod", "team": "infra"}},
+      attributes: []string{"instance_type", "num_masters", "admin_ips", "tags"},
+    },
+    {
+      name: "invalid map entry",
+      args: []string{"-tags=env=dev", "-tags=owner"},
+      err:  "expected key=value, got 'owner'",
+    },
+  }
+
+  for _, test := range tests {
+    tfc := createTestFileConfig()
+    tfc.Flags.SetOutput(ioutil.Discard)
+    err := tfc.Flags.Parse(test.args)
+    var content []byte
+    if err == nil {
+      content, err = tfc.Generate()
+    }
+    if test.err != "" {
+      if err == nil || !strings.Contains(err.Error(), test.err) {
+        t.Errorf("%s: got the error %v, want %q", test.name, err, test.err)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err.Error())
+      continue
+    }
+
+    // The generated file is read back like the next run of the command does
+    file, err := ParseTerraformFile(content)
+    if err != nil {
+      t.Errorf("%s: %s\n%s", test.name, err.Error(), content)
+      continue
+    }
+    block := file.blockAtLine(len(tfc.PreLines))
+    if block == nil || !reflect.DeepEqual(block.keys, []string{"module", "dcos"}) {
+      t.Errorf("%s: could not find the block of the parameters in\n%s", test.name, content)
+      continue
+    }
+    if got := block.Attributes(); !reflect.DeepEqual(got, test.attributes) {
+      t.Errorf("%s: got the attributes %q, want %q", test.name, got, test.attributes)
+    }
+    for name, want := range test.scalars {
+      if got, _ := block.GetLiteral(name); got != want {
+        t.Errorf("%s: got %s = %q, want %q", test.name, name, got, want)
+      }
+    }
+    for name, want := range test.lists {
+      if got, _ := block.GetList(name); !reflect.DeepEqual(got, want) {
+        t.Errorf("%s: got %s = %q, want %q", test.name, name, got, want)
+      }
+    }
+    for name, want := range test.maps {
+      if got, _ := block.GetMap(name); !reflect.DeepEqual(got, want) {
+        t.Errorf("%s: got %s = %q, want %q", test.name, name, got, want)
+      }
+    }
+    if !strings.Contains(string(content), "# The size of the nodes") {
+      t.Errorf("%s: the comments of the body are gone:\n%s", test.name, content)
+    }
+    if region, _ := file.Block("provider", "aws").GetLiteral("region"); region != "us-west-2" {
+      t.Errorf("%s: the other blocks changed:\n%s", test.name, content)
+    }
+  }
+}
+
+func TestGenerateSortsMapKeys(t *testing.T) {
+  tfc := createTestFileConfig()
+  tfc.Flags.Parse([]string{"-tags=zone=a", "-tags=env=dev", "-tags=zone=b"})
+  content, err := tfc.Generate()
+  if err != nil {
+    t.Fatal(err)
+  }
+  text := string(content)
+  if strings.Index(text, `"env"`) > strings.Index(text, `"zone"`) {
+    t.Errorf("the keys of the map are not sorted:\n%s", text)
+  }
+}
+
+func TestBlockAtLine(t *testing.T) {
+  file, err := ParseTerraformFile([]byte(strings.Join([]string{
+    `provider "aws" {`,
+    `  region = "us-west-2"`,
+    `}`,
+    ``,
+    `module "dcos" {`,
+    `  num_masters = 1`,
+    `}`,
+  }, "\n")))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    line int
+    keys []string
+  }{
+    {0, nil},
+    {1, []string{"provider", "aws"}},
+    {4, []string{"provider", "aws"}},
+    {5, []string{"module", "dcos"}},
+    {7, []string{"module", "dcos"}},
+  }
+  for _, test := range tests {
+    block := file.blockAtLine(test.line)
+    var keys []string
+    if block != nil {
+      keys = block.keys
+    }
+    if !reflect.DeepEqual(keys, test.keys) {
+      t.Errorf("line %d: got the block %q, want %q", test.line, keys, test.keys)
+    }
+  }
+}
+
+func TestGenerateFormatsTheFile(t *testing.T) {
+  tfc := createTestFileConfig()
+  tfc.Variables = []string{"num_masters", "instance_type"}
+  tfc.Flags.Parse([]string{"-instance_type=m5.xlarge", "-cluster_name=demo"})
+  content, err := tfc.Generate()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !strings.Contains(string(content), `  instance_type = "${var.instance_type}"`+"\n"+`  num_masters   = "${var.num_masters}"`) {
+    t.Errorf("the generated file is not formatted:\n%s", content)
+  }
+
+  // The values written in the body are quoted like the ones of the flags
+  decls, values, _ := tfc.GenerateVariables()
+  if !strings.Contains(string(values), `num_masters = "1"`) || !strings.Contains(string(values), `instance_type = "m5.xlarge"`) {
+    t.Errorf("got the values\n%s", values)
+  }
+  if !strings.Contains(string(decls), `default     = "1"`) {
+    t.Errorf("got the declarations\n%s", decls)
+  }
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sync"
+  "time"
+)
+
+var traceFile string = ""
+var traceStart time.Time = time.Now()
+var traceEvents []traceEvent
+var traceMutex sync.Mutex
+
+/**
+ * A complete event in the Chrome trace event format, that can be loaded in
+ * chrome://tracing or https://ui.perfetto.dev
+ */
+type traceEvent struct {
+  Name      string            `json:"name"`
+  Category  string            `json:"cat"`
+  Phase     string            `json:"ph"`
+  Timestamp int64             `json:"ts"`
+  Duration  int64             `json:"dur"`
+  Pid       int               `json:"pid"`
+  Tid       int               `json:"tid"`
+  Args      map[string]string `json:"args,omitempty"`
+}
+
+/**
+ * A timed operation, that is recorded when it ends
+ */
+type TraceSpan struct {
+  category string
+  name     string
+  start    time.Time
+  args     map[string]string
+}
+
+/**
+ * Records the timing of the operations in the given file, written on exit
+ */
+func SetTraceFile(path string) {
+  traceFile = path
+}
+
+/**
+ * Checks if we are recording a trace, either because of `--trace` or the
+ * TERRAFORM_WHEELS_TRACE environment variable
+ */
+func IsTracing() bool {
+  if traceFile == "" {
+    traceFile = os.Getenv("TERRAFORM_WHEELS_TRACE")
+  }
+  return traceFile != ""
+}
+
+/**
+ * Starts a new span. It's cheap to call even when not tracing.
+ */
+func StartSpan(category string, name string) *TraceSpan {
+  return &TraceSpan{category, name, time.Now(), nil}
+}
+
+/**
+ * Adds an argument to the span, shown in the details of the trace viewer
+ */
+func (s *TraceSpan) SetArg(key string, value string) *TraceSpan {
+  if s.args == nil {
+    s.args = make(map[string]string)
+  }
+  s.args[key] = Redact(value)
+  return s
+}
+
+/**
+ * Ends the span and records it
+ */
+func (s *TraceSpan) End() {
+  if !IsTracing() {
+    return
+  }
+
+  traceMutex.Lock()
+  defer traceMutex.Unlock()
+  traceEvents = append(traceEvents, traceEvent{
+    Name:      s.name,
+    Category:  s.category,
+    Phase:     "X",
+    Timestamp: s.start.Sub(traceStart).Microseconds(),
+    Duration:  time.Since(s.start).Microseconds(),
+    Pid:       os.Getpid(),
+    Tid:       1,
+    Args:      s.args,
+  })
+}
+
+/**
+ * Writes the recorded spans to the trace file, if we are tracing
+ */
+func WriteTrace() {
+  if !IsTracing() {
+    return
+  }
+
+  traceMutex.Lock()
+  defer traceMutex.Unlock()
+
+  // The whole run, so the gaps between the spans are visible too
+  events := append([]traceEvent{{
+    Name:      "terraform-wheels",
+    Category:  "main",
+    Phase:     "X",
+    Timestamp: 0,
+    Duration:  time.Since(traceStart).Microseconds(),
+    Pid:       os.Getpid(),
+    Tid:       1,
+    Args:      map[string]string{"args": fmt.Sprintf("%v", os.Args[1:])},
+  }}, traceEvents...)
+
+  content, err := json.MarshalIndent(map[string]interface{}{
+    "traceEvents":     events,
+    "displayTimeUnit": "ms",
+  }, "", "  ")
+  if err == nil {
+    err = ioutil.WriteFile(traceFile, content, 0644)
+  }
+  if err != nil {
+    colorableStderr.Write([]byte(fmt.Sprintf("Could not write the trace to %s: %s\n", traceFile, err.Error())))
+  }
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+)
+
+var insecureTLS bool = false
+var caBundleFile string = ""
+var insecureWarning sync.Once
+
+/**
+ * Disables the verification of TLS certificates, for lab environments with
+ * TLS interception
+ */
+func SetInsecureTLS(enabled bool) {
+  insecureTLS = enabled
+}
+
+/**
+ * Checks if the TLS certificates should not be verified, either because of
+ * `--insecure`, the TERRAFORM_WHEELS_INSECURE environment variable or the
+ * project config
+ */
+func IsInsecureTLS() bool {
+  if v, ok := os.LookupEnv("TERRAFORM_WHEELS_INSECURE"); ok && v != "" && v != "0" && v != "false" {
+    return true
+  }
+  return insecureTLS
+}
+
+/**
+ * Applies the network settings of the project configuration
+ */
+func applyNetworkConfig(baseDir string, cfg NetworkConfig) {
+  if cfg.Insecure {
+    insecureTLS = true
+  }
+  if cfg.CABundle != "" {
+    caBundleFile = cfg.CABundle
+    if !filepath.IsAbs(caBundleFile) {
+      caBundleFile = filepath.Join(baseDir, caBundleFile)
+    }
+
+    // The AWS SDK and the terraform AWS provider are picking it up from here
+    if _, ok := os.LookupEnv("AWS_CA_BUNDLE"); !ok {
+      os.Setenv("AWS_CA_BUNDLE", caBundleFile)
+    }
+  }
+}
+
+/**
+ * Returns the TLS configuration to use for the downloads, that trusts the
+ * system certificates and the custom CA bundle, if configured
+ */
+func getTLSConfig() *tls.Config {
+  if IsInsecureTLS() {
+    insecureWarning.Do(func() {
+      PrintWarning("TLS certificate verification is disabled, do not use this in production")
+    })
+    return &tls.Config{InsecureSkipVerify: true}
+  }
+  if caBundleFile == "" {
+    return nil
+  }
+
+  pool, err := x509.SystemCertPool()
+  if err != nil || pool == nil {
+    pool = x509.NewCertPool()
+  }
+
+  content, err := ioutil.ReadFile(caBundleFile)
+  if err != nil {
+    PrintWarning("Could not read CA bundle %s: %s", caBundleFile, err.Error())
+    return nil
+  }
+  if !pool.AppendCertsFromPEM(content) {
+    PrintWarning("Could not find any certificates in the CA bundle %s", caBundleFile)
+    return nil
+  }
+
+  return &tls.Config{RootCAs: pool}
+}
+
+/**
+ * Returns an HTTP transport that honours the HTTP(S)_PROXY and NO_PROXY
+ * environment variables and the TLS settings
+ */
+func newHttpTransport(disableCompression bool) *http.Transport {
+  return &http.Transport{
+    Proxy:               http.ProxyFromEnvironment,
+    TLSClientConfig:     getTLSConfig(),
+    TLSHandshakeTimeout: 10 * time.Second,
+    MaxIdleConns:        10,
+    IdleConnTimeout:     30 * time.Second,
+    DisableCompression:  disableCompression,
+  }
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "time"
+
+  "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
+  "golang.org/x/crypto/ssh/terminal"
+)
+
+// How often the latest released version is looked up
+var updateCheckInterval time.Duration = 24 * time.Hour
+
+// How long to wait for a check that is still running when the command ends
+var updateCheckGrace time.Duration = 500 * time.Millisecond
+
+/**
+ * The result of the last check for a new version, cached between the runs
+ */
+type UpdateCheckCache struct {
+  Checked time.Time `json:"checked"`
+  Latest  string    `json:"latest,omitempty"`
+}
+
+var updateCheckResult chan string
+
+func getUpdateCheckCachePath() (string, error) {
+  home, err := GetWheelsHomeDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(home, "latest-version.json"), nil
+}
+
+/**
+ * Checks if the new versions are not looked up, with `update_check: false`
+ * in the config or the environment (eg. in CI)
+ */
+func IsUpdateCheckDisabled(config *bool) bool {
+  if config != nil && !*config {
+    return true
+  }
+  if v := os.Getenv("TERRAFORM_WHEELS_UPDATE_CHECK"); v == "0" || v == "false" || v == "off" {
+    return true
+  }
+  return os.Getenv("CI") != ""
+}
+
+/**
+ * Returns the latest released version, from the cache if it was checked less
+ * than a day ago. The failed checks are cached too, so they are not retried
+ * on every run.
+ */
+func getCachedLatestVersion() (string, error) {
+  path, err := getUpdateCheckCachePath()
+  if err != nil {
+    return "", err
+  }
+
+  cache := &UpdateCheckCache{}
+  if content, err := ioutil.ReadFile(path); err == nil {
+    json.Unmarshal(content, cache)
+  }
+  if time.Since(cache.Checked) < updateCheckInterval {
+    return cache.Latest, nil
+  }
+
+  cache = &UpdateCheckCache{Checked: time.Now()}
+  if latest, err := GetLatestVersion(); err == nil {
+    cache.Latest = latest.Version.String()
+  }
+
+  content, err := json.MarshalIndent(cache, "", "  ")
+  if err != nil {
+    return "", err
+  }
+  if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+    return "", err
+  }
+  if err := ioutil.WriteFile(path, content, 0644); err != nil {
+    return "", fmt.Errorf("Could not write the update check cache: %s", err.Error())
+  }
+  return cache.Latest, nil
+}
+
+/**
+ * Looks up the latest released version in the background, to tell about it
+ * with PrintUpdateBanner once the command is done
+ */
+func StartUpdateCheck(config *bool) {
+  if IsUpdateCheckDisabled(config) || IsOffline() || IsQuiet() || getWheelsSemver() == nil {
+    return
+  }
+  if !terminal.IsTerminal(int(os.Stderr.Fd())) {
+    return
+  }
+
+  updateCheckResult = make(chan string, 1)
+  go func() {
+    latest, _ := getCachedLatestVersion()
+    updateCheckResult <- latest
+  }()
+}
+
+/**
+ * Prints a single line on stderr if a newer version was released. A check
+ * that did not complete yet is not waited for long, it's done again next time.
+ */
+func PrintUpdateBanner() {
+  if updateCheckResult == nil {
+    return
+  }
+
+  var latest string
+  select {
+  case latest = <-updateCheckResult:
+  case <-time.After(updateCheckGrace):
+    return
+  }
+
+  ver, err := semver.NewVersion(latest)
+  if err != nil || !ver.GreaterThan(getWheelsSemver()) {
+    return
+  }
+  upgrade := os.Args[0] + " wheels-upgrade"
+  if pm := DetectPackageManager(); pm != nil {
+    if cmds, err := pm.GetUpgradeCommands(""); err == nil {
+      upgrade = FormatUpgradeCommands(cmds)
+    }
+  }
+  colorableStderr.Write([]byte(fmt.Sprintf("\n%s terraform-wheels %s is available (you have %s), upgrade with `%s`\n",
+    Cyan("Info: "), Bold(ver.String()), wheelsVersion, upgrade)))
+}
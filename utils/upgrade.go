@@ -3,6 +3,7 @@ package utils
 import (
   "encoding/json"
   "fmt"
+  "io/ioutil"
   "os"
   "os/exec"
   "path/filepath"
@@ -11,22 +12,45 @@ import (
   "time"
 
   "github.com/Masterminds/semver/v3"
+  . "github.com/logrusorgru/aurora"
 )
 
 type LatestVersion struct {
-  Version *semver.Version
-  URL     string
+  Version  *semver.Version
+  URL      string
+  Checksum string
 }
 
+// The GitHub API of the releases of terraform-wheels
+var releasesURL string = "http://api.github.com/repos/mesosphere-incubator/terraform-wheels/releases"
+
 /**
  * Get the latest released version
  */
 func GetLatestVersion() (LatestVersion, error) {
+  return getRelease(releasesURL+"/latest", "the latest version")
+}
+
+/**
+ * Get the given released version (eg. 0.4.1), to upgrade or downgrade to it
+ */
+func GetReleasedVersion(version string) (LatestVersion, error) {
+  ver, err := semver.NewVersion(version)
+  if err != nil {
+    return LatestVersion{}, fmt.Errorf("Invalid version '%s': %s", version, err.Error())
+  }
+  return getRelease(releasesURL+"/tags/v"+ver.String(), "version "+ver.String())
+}
+
+func getRelease(url string, what string) (LatestVersion, error) {
   res := LatestVersion{}
 
-  // Download latest version
-  byt, err := Download("http://api.github.com/repos/mesosphere-incubator/terraform-wheels/releases/latest", WithDefaults).
+  // Download the release info
+  byt, err := Download(url, WithDefaults).
     EventuallyReadAll()
+  if err != nil {
+    return res, fmt.Errorf("could not check for %s: %s", what, err.Error())
+  }
 
   // Parse contents
   var dat map[string]interface{}
@@ -45,7 +69,8 @@ func GetLatestVersion() (LatestVersion, error) {
   }
 
   // Scan assets
-  var downloadUrl string = ""
+  var urls []string
+  digests := make(map[string]string)
   var assets []interface{}
   if assets, ok = dat["assets"].([]interface{}); !ok {
     return res, fmt.Errorf("invalid version info: missing `assets`")
@@ -56,14 +81,16 @@ func GetLatestVersion() (LatestVersion, error) {
       return res, fmt.Errorf("invalid version info: invalid field `assets`")
     }
     if url, ok := mapInst["browser_download_url"].(string); ok {
-      if strings.Contains(url, runtime.GOOS) {
-        downloadUrl = url
-        break
+      urls = append(urls, url)
+      // eg. "sha256:<hex>", only on the recent releases
+      if digest, ok := mapInst["digest"].(string); ok && strings.HasPrefix(digest, "sha256:") {
+        digests[url] = strings.TrimPrefix(digest, "sha256:")
       }
     }
   }
+  downloadUrl := findReleaseAsset(urls)
   if downloadUrl == "" {
-    return res, fmt.Errorf("could not find a download URL")
+    return res, fmt.Errorf("could not find a download URL for %s-%s", runtime.GOOS, runtime.GOARCH)
   }
 
   ver, err := semver.NewVersion(tagName[1:])
@@ -73,10 +100,32 @@ func GetLatestVersion() (LatestVersion, error) {
 
   res.Version = ver
   res.URL = downloadUrl
+  res.Checksum = digests[downloadUrl]
 
   return res, nil
 }
 
+/**
+ * Picks the release asset for the current OS/Arch. If there is no native
+ * build, fall back to one that can be emulated (eg. amd64 through Rosetta 2)
+ */
+func findReleaseAsset(urls []string) string {
+  candidates := []string{fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH)}
+  if runtime.GOARCH == "arm64" && runtime.GOOS != "linux" {
+    candidates = append(candidates, fmt.Sprintf("%s-amd64", runtime.GOOS))
+  }
+
+  for _, candidate := range candidates {
+    for _, url := range urls {
+      if strings.Contains(url, candidate) {
+        return url
+      }
+    }
+  }
+
+  return ""
+}
+
 /**
  * Perform upgrade
  */
@@ -88,37 +137,53 @@ func PerformUpgrade(newVersion LatestVersion) error {
     return fmt.Errorf("could not find the location of the tool: %s", err.Error())
   }
 
-  // Rename the current executable
-  bakTarget := replaceTarget + ".bak"
-  err = os.Rename(replaceTarget, bakTarget)
+  // Download the new version next to the current one, so it only replaces
+  // it once it's completely downloaded and verified
+  tmpDir, err := ioutil.TempDir(filepath.Dir(replaceTarget), ".download-")
   if err != nil {
-    return fmt.Errorf("could not rename old version: %s", err.Error())
+    return fmt.Errorf("could not create the download directory: %s", err.Error())
   }
+  defer os.RemoveAll(tmpDir)
 
-  // Download the new version
-  stream := Download(newVersion.URL, WithDefaults).AndShowProgress("")
+  name := filepath.Base(replaceTarget)
+  stream := Download(newVersion.URL, InParallel).AndShowProgress("Downloading terraform-wheels " + newVersion.Version.String())
+  if newVersion.Checksum != "" {
+    stream = stream.AndValidateChecksum(newVersion.Checksum)
+  }
   if runtime.GOOS == "windows" {
     err = stream.
-      EventuallyUnzipOnlyTo(
-        filepath.Dir(replaceTarget)+"/",
-        []string{filepath.Base(replaceTarget)},
-        0)
+      EventuallyUnzipOnlyTo(tmpDir, []string{name}, 0)
   } else {
     err = stream.
       AndDecompressIfCompressed().
-      EventuallyUntarOnlyTo(
-        filepath.Dir(replaceTarget)+"/",
-        []string{filepath.Base(replaceTarget)},
-        0)
+      EventuallyUntarOnlyTo(tmpDir, []string{name}, 0)
   }
   if err != nil {
-    os.Remove(replaceTarget)
-    os.Rename(bakTarget, replaceTarget)
     return fmt.Errorf("could not process file stream: %s", err.Error())
   }
+  newTarget := filepath.Join(tmpDir, name)
+
+  // Make it executable (there are no executable bits on windows)
+  if runtime.GOOS != "windows" {
+    os.Chmod(newTarget, 0755)
+  }
 
-  // Make it executable and close it
-  os.Chmod(replaceTarget, 0755)
+  // Make sure it runs on this machine before replacing anything
+  code, _, _, err := ExecuteAndCollect([]string{"TERRAFORM_WHEELS_OFFLINE=1"}, newTarget, "wheels-version")
+  if err != nil || code != 0 {
+    return fmt.Errorf("the downloaded version does not run on this machine")
+  }
+
+  // Swap the current executable with the new one
+  bakTarget := replaceTarget + ".bak"
+  err = os.Rename(replaceTarget, bakTarget)
+  if err != nil {
+    return fmt.Errorf("could not rename old version: %s", err.Error())
+  }
+  if err := os.Rename(newTarget, replaceTarget); err != nil {
+    os.Rename(bakTarget, replaceTarget)
+    return fmt.Errorf("could not install the new version: %s", err.Error())
+  }
 
   // Run the new version that is going to remove the backup file
   cmd := exec.Command(replaceTarget, "wheels-complete-upgrade", bakTarget)
@@ -136,18 +201,72 @@ func PerformUpgrade(newVersion LatestVersion) error {
 }
 
 /**
- * Helper function to complete an upgrade process
+ * Helper function to complete an upgrade process. The previous version is
+ * kept next to the new one, to roll back to it if the new one breaks.
  */
 func CompleteUpgrade(bakTarget string) {
   // Wait for the other process to exit
   time.Sleep(500 * time.Millisecond)
 
-  // Remove target
-  err := os.Remove(bakTarget)
-  if err != nil {
-    FatalError(fmt.Errorf("could not remove old version: %s", err.Error()))
+  if _, err := os.Stat(bakTarget); err == nil {
+    PrintInfo("The previous version is kept in %s, go back to it with `%s wheels-rollback`", bakTarget, os.Args[0])
   }
 
   // Just exit
   os.Exit(0)
 }
+
+/**
+ * Returns the version of the given terraform-wheels binary, or an empty
+ * string if it cannot tell
+ */
+func GetBinaryVersion(path string) string {
+  // Offline, so it does not look up the latest version
+  _, sout, _, err := ExecuteAndCollect([]string{"TERRAFORM_WHEELS_OFFLINE=1"}, path, "wheels-version", "-json")
+  if err != nil {
+    return ""
+  }
+  var info struct {
+    Version string `json:"version"`
+  }
+  if err := json.Unmarshal([]byte(sout), &info); err != nil {
+    return ""
+  }
+  return info.Version
+}
+
+/**
+ * Swaps the running binary with the previous version kept by the last
+ * upgrade, so rolling back again goes forward to the newer one
+ */
+func PerformRollback() error {
+  target, err := os.Executable()
+  if err != nil {
+    return fmt.Errorf("could not find the location of the tool: %s", err.Error())
+  }
+  bakTarget := target + ".bak"
+  if _, err := os.Stat(bakTarget); err != nil {
+    return fmt.Errorf("There is no previous version to roll back to (%s is missing)", bakTarget)
+  }
+
+  previous := GetBinaryVersion(bakTarget)
+  if previous == "" {
+    previous = "the previous version"
+  }
+
+  // The running binary can be renamed, but not replaced, on windows
+  tmpTarget := target + ".rollback"
+  if err := os.Rename(target, tmpTarget); err != nil {
+    return fmt.Errorf("could not rename the current version: %s", err.Error())
+  }
+  if err := os.Rename(bakTarget, target); err != nil {
+    os.Rename(tmpTarget, target)
+    return fmt.Errorf("could not restore the previous version: %s", err.Error())
+  }
+  if err := os.Rename(tmpTarget, bakTarget); err != nil {
+    return fmt.Errorf("could not keep the current version as %s: %s", bakTarget, err.Error())
+  }
+
+  PrintInfo("Rolled back to %s, the version you were using is now in %s", Bold(previous), bakTarget)
+  return nil
+}
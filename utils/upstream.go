@@ -7,34 +7,58 @@ import (
 
 var upstreamTerraformVersion string = "0.11.14"
 
+type upstreamArtifact struct {
+  url      string
+  checksum string
+}
+
+// The terraform artifacts for every supported OS/Arch combination
+var upstreamTerraformArtifacts map[string]upstreamArtifact = map[string]upstreamArtifact{
+  "linux/amd64": {
+    "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_linux_amd64.zip",
+    "9b9a4492738c69077b079e595f5b2a9ef1bc4e8fb5596610f69a6f322a8af8dd",
+  },
+  "linux/386": {
+    "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_linux_386.zip",
+    "0b6b2c61b80a35646df2cb7d443efeba3f4dedcdecbabab3b2626c2ea8976e87",
+  },
+  "darwin/amd64": {
+    "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_darwin_amd64.zip",
+    "829bdba148afbd61eab4aafbc6087838f0333d8876624fe2ebc023920cfc2ad5",
+  },
+  "windows/amd64": {
+    "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_windows_amd64.zip",
+    "bfec66e2ad079a1fab6101c19617a82ef79357dc1b92ddca80901bb8d5312dc0",
+  },
+  "windows/386": {
+    "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_windows_386.zip",
+    "f2eb847761cba796f306880288083b4c68f5ae9dd86c6cff47023eecc9895f8f",
+  },
+}
+
+// Architectures that can transparently run binaries built for another one
+// (eg. Apple Silicon through Rosetta 2), when there is no native build
+var upstreamArchFallbacks map[string]string = map[string]string{
+  "darwin/arm64":  "darwin/amd64",
+  "windows/arm64": "windows/386",
+}
+
 func upstreamGetTerraform() (string, string, error) {
-  if runtime.GOOS == "linux" {
-    if runtime.GOARCH == "amd64" {
-      return "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_linux_amd64.zip",
-        "9b9a4492738c69077b079e595f5b2a9ef1bc4e8fb5596610f69a6f322a8af8dd",
-        nil
-    } else if runtime.GOARCH == "386" {
-      return "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_linux_386.zip",
-        "0b6b2c61b80a35646df2cb7d443efeba3f4dedcdecbabab3b2626c2ea8976e87",
-        nil
-    }
-  } else if runtime.GOOS == "darwin" {
-    if runtime.GOARCH == "amd64" {
-      return "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_darwin_amd64.zip",
-        "829bdba148afbd61eab4aafbc6087838f0333d8876624fe2ebc023920cfc2ad5",
-        nil
-    }
-  } else if runtime.GOOS == "windows" {
-    if runtime.GOARCH == "amd64" {
-      return "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_windows_amd64.zip",
-        "bfec66e2ad079a1fab6101c19617a82ef79357dc1b92ddca80901bb8d5312dc0",
-        nil
-    } else if runtime.GOARCH == "386" {
-      return "https://releases.hashicorp.com/terraform/0.11.14/terraform_0.11.14_windows_386.zip",
-        "f2eb847761cba796f306880288083b4c68f5ae9dd86c6cff47023eecc9895f8f",
-        nil
+  platform := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+
+  if artifact, ok := upstreamTerraformArtifacts[platform]; ok {
+    return artifact.url, artifact.checksum, nil
+  }
+
+  if fallback, ok := upstreamArchFallbacks[platform]; ok {
+    if artifact, ok := upstreamTerraformArtifacts[fallback]; ok {
+      PrintWarning("There is no native terraform v%s for %s, using the %s build instead", upstreamTerraformVersion, platform, fallback)
+      if runtime.GOOS == "darwin" {
+        PrintInfo("If terraform fails to start, install Rosetta 2 with: softwareupdate --install-rosetta")
+      }
+      return artifact.url, artifact.checksum, nil
     }
   }
 
-  return "", "", fmt.Errorf("You are running an unsupported OS/Arch combination")
+  return "", "", fmt.Errorf("There is no terraform v%s release for %s. Please install a compatible terraform v%sx in your PATH", upstreamTerraformVersion, platform, RequiredTerraformVersionPrefix)
 }
@@ -6,9 +6,11 @@ import (
   "io"
   "os"
   "strings"
+  "sync"
 
   . "github.com/logrusorgru/aurora"
   . "github.com/mattn/go-colorable"
+  "golang.org/x/crypto/ssh/terminal"
 )
 
 type OptionsPrinter interface {
@@ -19,19 +21,37 @@ type OptionsPrinter interface {
 var colorableStdout = NewColorableStdout()
 var colorableStderr = NewColorableStderr()
 
+var terminalMutex sync.Mutex
+
+/**
+ * Prints the error and exits, with the exit code of the error (1 if it does
+ * not have one)
+ */
 func FatalError(err error) {
-  colorableStderr.Write([]byte(fmt.Sprintf("%s %s\n", Red("Error:"), err.Error())))
-  os.Exit(1)
+  PrintError(err)
+  Exit(GetExitCode(err))
+}
+
+func PrintError(err error) {
+  logMessage("", LogError, err.Error())
+}
+
+/**
+ * Takes exclusive use of the terminal, for the prompts that might happen
+ * while the plugins are running concurrently. Call the returned function to
+ * release it.
+ */
+func LockTerminal() func() {
+  terminalMutex.Lock()
+  return terminalMutex.Unlock
 }
 
 func PrintInfo(format string, a ...interface{}) {
-  args := append([]interface{}{Cyan("Info: ")}, a...)
-  colorableStdout.Write([]byte(fmt.Sprintf("%s "+format+"\n", args...)))
+  logMessage("", LogInfo, fmt.Sprintf(format, a...))
 }
 
 func PrintWarning(format string, a ...interface{}) {
-  args := append([]interface{}{Bold(Yellow("Warn: "))}, a...)
-  colorableStdout.Write([]byte(fmt.Sprintf("%s "+format+"\n", args...)))
+  logMessage("", LogWarn, fmt.Sprintf(format, a...))
 }
 
 func PrintHelp(cmd string, cmdline string, message []interface{}, opts OptionsPrinter) {
@@ -68,9 +88,30 @@ func PrintMessage(message []interface{}) {
 
 func ReadPrompt(message string) string {
   reader := bufio.NewReader(os.Stdin)
-  fmt.Print("%s: ", message)
+  Printf("%s: ", message)
   text, _ := reader.ReadString('\n')
-  return text
+  return strings.TrimSpace(text)
+}
+
+/**
+ * Prompts for a secret value without echoing it on the terminal
+ */
+func ReadPassword(message string) (string, error) {
+  Printf("%s: ", message)
+  pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+  Println("")
+  if err != nil {
+    return "", err
+  }
+  return string(pass), nil
+}
+
+/**
+ * Checks if we can interactively prompt the user for input
+ */
+func IsInteractive() bool {
+  // Not only a character device, that could be /dev/null
+  return terminal.IsTerminal(int(os.Stdin.Fd()))
 }
 
 func ReadYN(message string) bool {
@@ -80,8 +121,8 @@ func ReadYN(message string) bool {
       return true
     }
     if ans == "n" || ans == "no" {
-      return true
+      return false
     }
-    fmt.Println("\nInvalid option please specify 'yes' or 'no'")
+    Println("\nInvalid option please specify 'yes' or 'no'")
   }
 }
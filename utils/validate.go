@@ -0,0 +1,222 @@
+package utils
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/service/ec2"
+  "github.com/gobwas/glob"
+)
+
+/**
+ * A DC/OS constraint that the project does not satisfy
+ */
+type ValidationIssue struct {
+  Address string
+  Message string
+}
+
+// The modules that install DC/OS, and can be given an enterprise license
+var dcosInstallModuleGlobs []string = []string{
+  "*dcos-terraform/dcos/*",
+  "*dcos-terraform/dcos-install-remote-exec-ansible/*",
+}
+
+/**
+ * @brief      Checks the project against the DC/OS constraints that terraform
+ *             cannot check by itself, returning all the issues found
+ *
+ * With checkCloud, the instance types and the availability zones are also
+ * checked against the AWS region of the project, using the AWS API.
+ */
+func (s *ProjectSandbox) ValidateProject(table PriceTable, checkCloud bool) ([]ValidationIssue, error) {
+  var issues []ValidationIssue
+  addIssue := func(address string, format string, args ...interface{}) {
+    issues = append(issues, ValidationIssue{address, fmt.Sprintf(format, args...)})
+  }
+
+  // The masters need a quorum, so there must be an odd number of them
+  for _, spec := range costModuleSpecs {
+    g := glob.MustCompile(spec.sourceGlob)
+    for name, mod := range s.GetTerraformResources("module") {
+      if source, ok := mod["source"].(string); !ok || !g.Match(source) {
+        continue
+      }
+      for _, group := range spec.groups {
+        if group.name != "masters" {
+          continue
+        }
+        count, ok := getCostCount(s.ResolveTerraformValue(mod[group.countField]), group.defaultCount)
+        if ok && (count < 1 || count%2 == 0) {
+          addIssue("module."+name, "There must be an odd number of masters (1, 3, 5 or 7), got %d", count)
+        }
+      }
+    }
+  }
+
+  // The instance types must exist. The AWS API knows all of them, otherwise
+  // only the ones in the price table can be checked.
+  items, err := s.EstimateCost(table)
+  if err != nil {
+    return nil, err
+  }
+  awsTypes := make(map[string][]string)
+  for _, item := range items {
+    if item.InstanceType == "" {
+      continue
+    }
+    if checkCloud && item.Cloud == "aws" {
+      awsTypes[item.InstanceType] = append(awsTypes[item.InstanceType], item.Address)
+    } else if !item.Priced {
+      PrintWarning("Could not check the %s instance type %s of %s, it's not in the price table", item.Cloud, item.InstanceType, item.Address)
+    }
+  }
+
+  // Enterprise DC/OS does not install without a license
+  for _, pattern := range dcosInstallModuleGlobs {
+    for _, mod := range s.GetTerraformResourcesMatching("module", "source", pattern) {
+      variant, _ := s.ResolveTerraformValue(mod["dcos_variant"]).(string)
+      if variant != "ee" {
+        continue
+      }
+      address := fmt.Sprintf("module.%s", mod["_name"])
+      license, _ := s.ResolveTerraformValue(mod["dcos_license_key_contents"]).(string)
+      if license == "" {
+        addIssue(address, "Enterprise DC/OS needs a license, please set dcos_license_key_contents")
+      } else if m := regexp.MustCompile(`^\$\{file\("([^"]+)"\)\}$`).FindStringSubmatch(license); m != nil && !s.HasFile(m[1]) {
+        addIssue(address, "Enterprise DC/OS needs a license, but %s is missing", m[1])
+      }
+    }
+  }
+
+  if len(awsTypes) > 0 {
+    cloudIssues, err := s.validateAWSRegion(awsTypes)
+    if err != nil {
+      return nil, err
+    }
+    issues = append(issues, cloudIssues...)
+  }
+
+  sort.SliceStable(issues, func(i, j int) bool {
+    return issues[i].Address < issues[j].Address
+  })
+  return issues, nil
+}
+
+/**
+ * Returns the AWS region of the project, from the aws provider
+ */
+func (s *ProjectSandbox) GetAWSRegion() string {
+  if provider, ok := s.GetTerraformResources("provider")["aws"]; ok {
+    if region, ok := s.ResolveTerraformValue(provider["region"]).(string); ok && region != "" {
+      return region
+    }
+  }
+  return "us-west-2"
+}
+
+/**
+ * Checks that the instance types (with the addresses using them) and the
+ * availability zones are available in the region of the project
+ */
+func (s *ProjectSandbox) validateAWSRegion(instanceTypes map[string][]string) ([]ValidationIssue, error) {
+  var issues []ValidationIssue
+  region := s.GetAWSRegion()
+
+  sess, err := GetAWSSession(region)
+  if err != nil {
+    return nil, fmt.Errorf("Could not create an AWS session: %s", err.Error())
+  }
+  svc := ec2.New(sess)
+
+  available, err := listAvailabilityZones(svc, region)
+  if err != nil {
+    return nil, err
+  }
+  zones := make(map[string]bool)
+  for _, zone := range available {
+    zones[zone] = true
+  }
+  for _, mod := range s.GetTerraformResourcesMatching("module", "source", "*dcos-terraform/dcos/aws") {
+    for _, zone := range getStringList(mod["availability_zones"]) {
+      if !zones[zone] {
+        issues = append(issues, ValidationIssue{fmt.Sprintf("module.%s", mod["_name"]), fmt.Sprintf("The availability zone %s is not available in %s", zone, region)})
+      }
+    }
+  }
+
+  var names []*string
+  for instanceType := range instanceTypes {
+    names = append(names, aws.String(instanceType))
+  }
+  offered := make(map[string]bool)
+  input := &ec2.DescribeInstanceTypeOfferingsInput{
+    LocationType: aws.String(ec2.LocationTypeRegion),
+    Filters:      []*ec2.Filter{{Name: aws.String("instance-type"), Values: names}},
+  }
+  for {
+    page, err := svc.DescribeInstanceTypeOfferings(input)
+    if err != nil {
+      return nil, fmt.Errorf("Could not list the instance types of %s: %s", region, err.Error())
+    }
+    for _, offering := range page.InstanceTypeOfferings {
+      offered[aws.StringValue(offering.InstanceType)] = true
+    }
+    if page.NextToken == nil {
+      break
+    }
+    input.NextToken = page.NextToken
+  }
+  for instanceType, addresses := range instanceTypes {
+    if offered[instanceType] {
+      continue
+    }
+    for _, address := range addresses {
+      issues = append(issues, ValidationIssue{address, fmt.Sprintf("The instance type %s is not offered in %s", instanceType, region)})
+    }
+  }
+
+  return issues, nil
+}
+
+/**
+ * Returns the sorted availability zones of the given region that are
+ * currently available
+ */
+func ListAWSAvailabilityZones(region string) ([]string, error) {
+  sess, err := GetAWSSession(region)
+  if err != nil {
+    return nil, fmt.Errorf("Could not create an AWS session: %s", err.Error())
+  }
+  return listAvailabilityZones(ec2.New(sess), region)
+}
+
+func listAvailabilityZones(svc *ec2.EC2, region string) ([]string, error) {
+  out, err := svc.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+  if err != nil {
+    return nil, fmt.Errorf("Could not list the availability zones of %s: %s", region, err.Error())
+  }
+
+  var zones []string
+  for _, zone := range out.AvailabilityZones {
+    if aws.StringValue(zone.State) == "available" {
+      zones = append(zones, aws.StringValue(zone.ZoneName))
+    }
+  }
+  sort.Strings(zones)
+  return zones, nil
+}
+
+func getStringList(value interface{}) []string {
+  var ret []string
+  if list, ok := value.([]interface{}); ok {
+    for _, item := range list {
+      if str, ok := item.(string); ok {
+        ret = append(ret, str)
+      }
+    }
+  }
+  return ret
+}
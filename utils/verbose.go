@@ -0,0 +1,190 @@
+package utils
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+
+  . "github.com/logrusorgru/aurora"
+)
+
+var verboseMode bool = false
+
+/**
+ * Logs the external commands and the HTTP requests, with `--verbose`
+ */
+func SetVerboseMode(verbose bool) {
+  verboseMode = verbose
+}
+
+/**
+ * Checks if we are logging the external commands, either because of
+ * `--verbose` or the TERRAFORM_WHEELS_VERBOSE environment variable
+ */
+func IsVerbose() bool {
+  if !verboseMode {
+    v := os.Getenv("TERRAFORM_WHEELS_VERBOSE")
+    verboseMode = v != "" && v != "0" && v != "false"
+  }
+  return verboseMode
+}
+
+var logCommandsMode bool = false
+
+/**
+ * Logs only the external commands, with `--log-commands`
+ */
+func SetLogCommandsMode(logCommands bool) {
+  logCommandsMode = logCommands
+}
+
+/**
+ * Checks if we are logging the external commands, with `--log-commands` or
+ * `--verbose`
+ */
+func IsLoggingCommands() bool {
+  return logCommandsMode || IsVerbose()
+}
+
+func printVerbose(format string, a ...interface{}) {
+  line := Redact(fmt.Sprintf(format, a...))
+  colorableStderr.Write([]byte(fmt.Sprintf("%s\n", Faint("[verbose] "+line))))
+}
+
+/**
+ * Returns the command line as it would be typed in a shell
+ */
+func formatCommandLine(binary string, args []string) string {
+  parts := []string{binary}
+  for _, arg := range args {
+    if arg == "" || strings.ContainsAny(arg, " \t\n\"'$`\\") {
+      arg = strconv.Quote(arg)
+    }
+    parts = append(parts, arg)
+  }
+  return strings.Join(parts, " ")
+}
+
+/**
+ * Returns the variables given to a command, with the values of the secret
+ * ones masked
+ */
+func formatEnvDelta(env []string) string {
+  var parts []string
+  for _, e := range env {
+    pair := strings.SplitN(e, "=", 2)
+    if len(pair) == 2 && IsSecretVariable(pair[0]) {
+      e = pair[0] + "=" + redactedText
+    }
+    parts = append(parts, e)
+  }
+  return strings.Join(parts, " ")
+}
+
+/**
+ * The log of an external command, from its start until it exits
+ */
+type CommandLog struct {
+  binary string
+  start  time.Time
+  span   *TraceSpan
+}
+
+/**
+ * Logs the start of an external command, with the variables that are set for
+ * it on top of our own environment. Call Done when it exits.
+ */
+func LogCommand(env []string, binary string, args []string) *CommandLog {
+  // The arguments are redacted before they are quoted, or a quoted secret
+  // would not be recognized anymore
+  redacted := make([]string, len(args))
+  for i, arg := range args {
+    redacted[i] = Redact(arg)
+  }
+  cmdline := formatCommandLine(binary, redacted)
+  binary = filepath.Base(binary)
+  span := StartSpan("exec", binary).SetArg("cmdline", cmdline)
+  if len(env) > 0 {
+    span.SetArg("env", formatEnvDelta(env))
+  }
+
+  if IsLoggingCommands() {
+    if len(env) > 0 {
+      printVerbose("Running %s (with %s)", cmdline, formatEnvDelta(env))
+    } else {
+      printVerbose("Running %s", cmdline)
+    }
+  }
+  return &CommandLog{binary, time.Now(), span}
+}
+
+/**
+ * Logs the exit code of the command and how long it took
+ */
+func (l *CommandLog) Done(code int, err error) {
+  duration := time.Since(l.start).Round(time.Millisecond)
+  if err != nil {
+    l.span.SetArg("error", err.Error())
+  } else {
+    l.span.SetArg("exit_code", strconv.Itoa(code))
+  }
+  l.span.End()
+
+  if IsLoggingCommands() {
+    if err != nil {
+      printVerbose("%s failed after %s: %s", l.binary, duration, err.Error())
+    } else {
+      printVerbose("%s exited with code %d after %s", l.binary, code, duration)
+    }
+  }
+}
+
+/**
+ * An HTTP transport that logs the requests and their responses
+ */
+type loggingTransport struct {
+  base http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  if !IsVerbose() {
+    return t.base.RoundTrip(req)
+  }
+
+  start := time.Now()
+  desc := req.Method + " " + req.URL.String()
+  if r := req.Header.Get("Range"); r != "" {
+    desc += " (" + r + ")"
+  }
+  resp, err := t.base.RoundTrip(req)
+  duration := time.Since(start).Round(time.Millisecond)
+  if err != nil {
+    printVerbose("%s failed after %s: %s", desc, duration, err.Error())
+  } else {
+    printVerbose("%s responded with %s after %s", desc, resp.Status, duration)
+  }
+  return resp, err
+}
+
+/**
+ * Wraps the transport to log the requests with `--verbose`
+ */
+func wrapLoggingTransport(base http.RoundTripper) http.RoundTripper {
+  return &loggingTransport{base}
+}
+
+/**
+ * Logs the exit of a command that was run with exec.Cmd directly
+ */
+func (l *CommandLog) Exited(cmd *exec.Cmd, err error) {
+  if cmd.ProcessState != nil {
+    l.Done(cmd.ProcessState.ExitCode(), nil)
+  } else {
+    l.Done(0, err)
+  }
+}
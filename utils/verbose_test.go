@@ -0,0 +1,18 @@
+package utils
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestLogCommandRedactsSecrets(t *testing.T) {
+  secrets := []string{"hunter2-secret", `quo"ted\secret`}
+  for _, secret := range secrets {
+    RegisterSecret(secret)
+    log := LogCommand(nil, "/usr/bin/security", []string{"add-generic-password", "-a", "key", "-w", secret})
+    cmdline := log.span.args["cmdline"]
+    if strings.Contains(cmdline, "secret") || !strings.Contains(cmdline, redactedText) {
+      t.Errorf("%q: the secret is not redacted from the command line %q", secret, cmdline)
+    }
+  }
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "regexp"
+  "strings"
+  "sync"
+
+  "github.com/Masterminds/semver/v3"
+)
+
+var wheelsVersion string = ""
+var provenanceWarning sync.Once
+
+// Every generated file starts with this line, so we know which version of
+// terraform-wheels wrote it
+var provenanceRegex *regexp.Regexp = regexp.MustCompile(`(?m)\A# Generated by terraform-wheels v(\S+)\n`)
+
+/**
+ * Sets the version of the running binary, as defined at build time
+ */
+func SetWheelsVersion(version string) {
+  wheelsVersion = version
+}
+
+/**
+ * Returns the version of the running binary, or nil for development builds
+ */
+func getWheelsSemver() *semver.Version {
+  ver, err := semver.NewVersion(wheelsVersion)
+  if err != nil {
+    return nil
+  }
+  return ver
+}
+
+/**
+ * @brief      Checks the running binary against the `required_wheels_version`
+ *             of the project. Older binaries are refused, newer ones are only
+ *             warned about.
+ */
+func (s *ProjectSandbox) CheckWheelsVersion() error {
+  required := strings.TrimSpace(s.config.RequiredWheelsVersion)
+  ver := getWheelsSemver()
+  if required == "" || ver == nil {
+    return nil
+  }
+
+  constraint, err := semver.NewConstraint(required)
+  if err != nil {
+    return fmt.Errorf("Invalid required_wheels_version '%s' in %s: %s", required, WheelsConfigFile, err.Error())
+  }
+  if constraint.Check(ver) {
+    return nil
+  }
+
+  // Find out if we are too old or too new, by checking which of the
+  // individual bounds we violate
+  tooOld := false
+  verRegex := regexp.MustCompile(`[0-9]+(\.[0-9]+){0,2}(-[0-9A-Za-z.-]+)?`)
+  for _, clause := range strings.Split(required, ",") {
+    c, err := semver.NewConstraint(clause)
+    if err != nil || c.Check(ver) {
+      continue
+    }
+    bound, err := semver.NewVersion(verRegex.FindString(clause))
+    if err == nil && ver.LessThan(bound) {
+      tooOld = true
+    }
+  }
+
+  if tooOld {
+    return fmt.Errorf("This project requires terraform-wheels %s, but you are using %s. Please upgrade with `%s wheels-upgrade`", required, wheelsVersion, os.Args[0])
+  }
+
+  PrintWarning("This project requires terraform-wheels %s, but you are using the newer %s. The generated files might differ from the ones of your team", required, wheelsVersion)
+  return nil
+}
+
+/**
+ * Returns the version of terraform-wheels that generated the given file
+ * contents, or an empty string if unknown
+ */
+func getProvenance(contents []byte) string {
+  match := provenanceRegex.FindSubmatch(contents)
+  if match == nil {
+    return ""
+  }
+  return string(match[1])
+}
+
+/**
+ * Marks the given file contents as generated by this version
+ */
+func stampProvenance(contents []byte) []byte {
+  contents = provenanceRegex.ReplaceAll(contents, nil)
+  if getWheelsSemver() == nil {
+    return contents
+  }
+  return append([]byte(fmt.Sprintf("# Generated by terraform-wheels v%s\n", wheelsVersion)), contents...)
+}
+
+/**
+ * Warns if the project files were generated by a version of terraform-wheels
+ * that might produce incompatible output with the running one
+ */
+func (s *ProjectSandbox) checkProvenance() {
+  ver := getWheelsSemver()
+  if ver == nil {
+    return
+  }
+
+  files, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return
+  }
+  for _, file := range files {
+    if !strings.HasSuffix(file.Name(), ".tf") {
+      continue
+    }
+    content, err := s.ReadFile(file.Name())
+    if err != nil {
+      continue
+    }
+    recorded, err := semver.NewVersion(getProvenance(content))
+    if err != nil {
+      continue
+    }
+
+    // Patch releases never change the generated output
+    if recorded.Major() != ver.Major() || recorded.Minor() != ver.Minor() {
+      provenanceWarning.Do(func() {
+        PrintWarning("%s was generated by terraform-wheels v%s, but you are using v%s. The new output might not be compatible with it, consider pinning required_wheels_version in %s", file.Name(), recorded, wheelsVersion, WheelsConfigFile)
+      })
+      return
+    }
+  }
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// The directory of the project given with --project-dir
+var projectDir string
+
+/**
+ * Uses the project in the given directory instead of the current one
+ */
+func SetProjectDir(dir string) {
+  projectDir = dir
+}
+
+/**
+ * @brief      Changes to the directory of the project given with --project-dir (like
+ *             the -chdir option of terraform), and returns the directory
+ *             of the project
+ *
+ * Terraform and the plugins work on the current directory, so the other
+ * relative paths of the command-line are relative to the project too.
+ */
+func ChangeToProjectDir() (string, error) {
+  if projectDir != "" {
+    if info, err := os.Stat(projectDir); err != nil || !info.IsDir() {
+      return "", fmt.Errorf("The project directory %s does not exist", projectDir)
+    }
+    if err := os.Chdir(projectDir); err != nil {
+      return "", fmt.Errorf("Could not change to %s: %s", projectDir, err.Error())
+    }
+  }
+  return os.Getwd()
+}
+
+/**
+ * Checks if the given directory is a project: it has terraform files or a
+ * .wheels.yaml
+ */
+func isProjectDir(dir string) bool {
+  if _, err := os.Stat(filepath.Join(dir, WheelsConfigFile)); err == nil {
+    return true
+  }
+  files, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+  return len(files) > 0
+}
+
+/**
+ * Returns the sub-directories that are projects of their own, when the
+ * sandbox is only their parent directory (without terraform files)
+ */
+func (s *ProjectSandbox) ListSubProjects() ([]string, error) {
+  if isProjectDir(s.baseDir) {
+    return nil, nil
+  }
+  entries, err := ioutil.ReadDir(s.baseDir)
+  if err != nil {
+    return nil, err
+  }
+
+  var projects []string
+  for _, entry := range entries {
+    if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && isProjectDir(filepath.Join(s.baseDir, entry.Name())) {
+      projects = append(projects, entry.Name())
+    }
+  }
+  sort.Strings(projects)
+  return projects, nil
+}
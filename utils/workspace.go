@@ -0,0 +1,108 @@
+package utils
+
+import (
+  "fmt"
+  "strings"
+)
+
+var allowProtectedDestroy bool = false
+
+// The environments that cannot be destroyed by accident, unless configured
+var defaultProtectedWorkspaces []string = []string{"prod", "production"}
+
+// The commands that take variables
+var commandsWithVariables []string = []string{"plan", "apply", "destroy", "refresh", "import", "console"}
+
+/**
+ * Allows destroying the protected workspaces
+ */
+func SetAllowProtectedDestroy(enabled bool) {
+  allowProtectedDestroy = enabled
+}
+
+/**
+ * Returns the file with the variables of the given workspace, that is
+ * used on top of terraform.tfvars. The default workspace has none.
+ */
+func GetWorkspaceVarsFile(workspace string) string {
+  if workspace == "" || workspace == "default" {
+    return ""
+  }
+  return fmt.Sprintf("env-%s.tfvars", workspace)
+}
+
+/**
+ * Checks if the given workspace is protected against destroys
+ */
+func (s *ProjectSandbox) IsProtectedWorkspace(workspace string) bool {
+  protected := s.GetConfig().Environments.Protected
+  if protected == nil {
+    protected = defaultProtectedWorkspaces
+  }
+  for _, name := range protected {
+    if name == workspace {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Checks if the given terraform arguments destroy resources on purpose
+ */
+func IsDestroyRun(args []string) bool {
+  parsed := ParseTerraformArgs(args)
+  if parsed.Command == "destroy" {
+    return true
+  }
+  if parsed.Command == "plan" || parsed.Command == "apply" {
+    for _, opt := range parsed.Options {
+      if opt.Name == "destroy" && opt.Value != "false" {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+/**
+ * Returns an error if the current workspace is protected and destroying it
+ * was not explicitly allowed
+ */
+func (s *ProjectSandbox) CheckDestroyAllowed() error {
+  return s.CheckWorkspaceDestroyAllowed(s.GetWorkspace())
+}
+
+/**
+ * Same as CheckDestroyAllowed, for the given workspace
+ */
+func (s *ProjectSandbox) CheckWorkspaceDestroyAllowed(ws string) error {
+  if s.IsProtectedWorkspace(ws) && !allowProtectedDestroy {
+    return fmt.Errorf("The '%s' workspace is protected, use --allow-protected-destroy if you really want to destroy it", ws)
+  }
+  return nil
+}
+
+/**
+ * @brief      Adds the variables file of the current workspace to the given
+ *             terraform arguments, if it exists
+ *
+ * Saved plans already contain the variables, so they are left alone.
+ */
+func (s *ProjectSandbox) AddWorkspaceVarFile(args []string) []string {
+  file := GetWorkspaceVarsFile(s.GetWorkspace())
+  parsed := ParseTerraformArgs(args)
+  if file == "" || !s.HasFile(file) || parsed.GetSavedPlan() != "" || !containsString(commandsWithVariables, parsed.Command) {
+    return args
+  }
+  for _, opt := range parsed.Options {
+    if opt.Name == "var-file" && strings.HasSuffix(opt.Value, file) {
+      return args
+    }
+  }
+
+  var ret []string
+  ret = append(ret, args[:parsed.CommandIndex+1]...)
+  ret = append(ret, "-var-file="+file)
+  return append(ret, args[parsed.CommandIndex+1:]...)
+}